@@ -0,0 +1,254 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Access log buffering: entries queue in memory and are written in batches,
+// so a slow database never holds up the request being logged
+const (
+    accessLogBuffer        = 1024
+    accessLogBatch         = 100
+    accessLogFlushInterval = 2 * time.Second
+)
+
+// AccessLogEntry records one read of member data
+type AccessLogEntry struct {
+    AccessedAt time.Time `json:"accessed_at"`
+    ClientIP   string    `json:"client_ip"`
+    Principal  string    `json:"principal"`
+    Route      string    `json:"route"`
+    Path       string    `json:"path"`
+    Query      string    `json:"query,omitempty"`
+    Status     int       `json:"status"`
+}
+
+// RecordAccess writes a batch of access log entries in one transaction
+func (db *Database) RecordAccess(entries []AccessLogEntry) error {
+    return db.inTx(func(tx *sql.Tx) error {
+        stmt, err := tx.Prepare(`
+            INSERT INTO access_logs (accessed_at, client_ip, principal, route, path, query, status)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+        `)
+        if err != nil {
+            return fmt.Errorf("failed to record access: %w", err)
+        }
+        defer stmt.Close()
+
+        for _, e := range entries {
+            if _, err := stmt.Exec(e.AccessedAt, e.ClientIP, e.Principal, e.Route, e.Path, e.Query, e.Status); err != nil {
+                return fmt.Errorf("failed to record access: %w", err)
+            }
+        }
+        return nil
+    })
+}
+
+// GetAccessLogs returns access log entries since a time, oldest first
+func (db *Database) GetAccessLogs(since time.Time, limit int) ([]AccessLogEntry, error) {
+    rows, err := db.Query(`
+        SELECT accessed_at, COALESCE(client_ip, ''), principal, route, path, COALESCE(query, ''), status
+        FROM access_logs
+        WHERE accessed_at >= $1
+        ORDER BY accessed_at, id
+        LIMIT $2
+    `, since, limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get access logs: %w", err)
+    }
+    defer rows.Close()
+
+    var entries []AccessLogEntry
+    for rows.Next() {
+        var e AccessLogEntry
+        if err := rows.Scan(&e.AccessedAt, &e.ClientIP, &e.Principal, &e.Route, &e.Path, &e.Query, &e.Status); err != nil {
+            return nil, fmt.Errorf("failed to scan access log: %w", err)
+        }
+        entries = append(entries, e)
+    }
+    return entries, rows.Err()
+}
+
+// accessLogger writes access log entries in the background. When the
+// buffer is full, entries are dropped and counted rather than blocking.
+type accessLogger struct {
+    db      Store
+    logger  *log.Logger
+    entries chan AccessLogEntry
+    dropped atomic.Int64
+    done    chan struct{}
+    once    sync.Once
+}
+
+func newAccessLogger(db Store, logger *log.Logger) *accessLogger {
+    l := &accessLogger{
+        db:      db,
+        logger:  logger,
+        entries: make(chan AccessLogEntry, accessLogBuffer),
+        done:    make(chan struct{}),
+    }
+    go l.run()
+    return l
+}
+
+// record queues an entry without waiting
+func (l *accessLogger) record(entry AccessLogEntry) {
+    select {
+    case l.entries <- entry:
+    default:
+        if l.dropped.Add(1)%100 == 1 {
+            l.logger.Printf("Warning: access log buffer full, %d entries dropped so far", l.dropped.Load())
+        }
+    }
+}
+
+// close writes out whatever is buffered and stops the writer
+func (l *accessLogger) close() {
+    l.once.Do(func() {
+        close(l.entries)
+        <-l.done
+    })
+}
+
+func (l *accessLogger) run() {
+    defer close(l.done)
+
+    ticker := time.NewTicker(accessLogFlushInterval)
+    defer ticker.Stop()
+
+    batch := make([]AccessLogEntry, 0, accessLogBatch)
+    flush := func() {
+        if len(batch) == 0 {
+            return
+        }
+        if err := l.db.RecordAccess(batch); err != nil {
+            l.dropped.Add(int64(len(batch)))
+            l.logger.Printf("Failed to write %d access log entries: %v", len(batch), err)
+        }
+        batch = batch[:0]
+    }
+
+    for {
+        select {
+        case entry, ok := <-l.entries:
+            if !ok {
+                flush()
+                return
+            }
+            batch = append(batch, entry)
+            if len(batch) == accessLogBatch {
+                flush()
+            }
+        case <-ticker.C:
+            flush()
+        }
+    }
+}
+
+// statusRecorder remembers the status code a handler sent
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+    if w.status == 0 {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// principal names who made a request: the admin token it authenticated
+// with, by position in ADMIN_TOKENS, or anonymous
+func (s *WebhookServer) principal(r *http.Request) string {
+    if index := s.adminTokenIndex(r); index >= 0 {
+        return fmt.Sprintf("admin token #%d", index+1)
+    }
+    return "anonymous"
+}
+
+// accessLogMiddleware records who read member data, including refused
+// attempts. It sits outside the guard so 503s are recorded too.
+func (s *WebhookServer) accessLogMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        recorder := &statusRecorder{ResponseWriter: w}
+        next(recorder, r)
+
+        if recorder.status == 0 {
+            recorder.status = http.StatusOK
+        }
+        s.accessLog.record(AccessLogEntry{
+            AccessedAt: time.Now(),
+            ClientIP:   s.clientIP(r),
+            Principal:  s.principal(r),
+            Route:      r.Pattern,
+            Path:       r.URL.Path,
+            Query:      r.URL.RawQuery,
+            Status:     recorder.status,
+        })
+    }
+}
+
+func runAccessLog(logger *log.Logger) {
+    accessLogCmd := flag.NewFlagSet("access-log", flag.ExitOnError)
+    since := accessLogCmd.String("since", "7d", "Show requests from this long ago, e.g. 7d or 12h")
+    limit := accessLogCmd.Int("limit", 1000, "Maximum number of entries to show")
+    asJSON := accessLogCmd.Bool("json", false, "Print one JSON object per line")
+
+    parseSubcommand(accessLogCmd, "memberships access-log [--since 7d] [--limit N] [--json]", os.Args[2:])
+
+    age, err := parseAge(*since)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: --since: %v\n", err)
+        os.Exit(2)
+    }
+    if *limit < 1 {
+        fmt.Fprintln(os.Stderr, "Error: --limit must be positive")
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    entries, err := db.GetAccessLogs(time.Now().Add(-age), *limit)
+    if err != nil {
+        logger.Fatalf("Failed to get access logs: %v", err)
+    }
+
+    encoder := json.NewEncoder(os.Stdout)
+    for _, e := range entries {
+        if *asJSON {
+            encoder.Encode(e)
+            continue
+        }
+        target := e.Path
+        if e.Query != "" {
+            target += "?" + e.Query
+        }
+        fmt.Printf("%s  %d  %-16s  %-15s  %s\n", displayTime(e.AccessedAt), e.Status, e.Principal, e.ClientIP, target)
+    }
+    if !*asJSON && len(entries) == *limit {
+        fmt.Fprintf(os.Stderr, "Showing the first %d entries; use --limit to see more\n", *limit)
+    }
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// stringListFlag collects a flag that may be given more than once
+type stringListFlag []string
+
+func (l *stringListFlag) String() string {
+    return strings.Join(*l, ",")
+}
+
+func (l *stringListFlag) Set(value string) error {
+    *l = append(*l, value)
+    return nil
+}
+
+// ErrMemberExists is returned when adding a member who already exists
+// without asking to update them
+var ErrMemberExists = errors.New("member already exists")
+
+// addMemberOptions is what memberships add sets on the member it adds
+type addMemberOptions struct {
+    Name      string
+    Anonymous bool
+    Status    string
+    Tags      []string
+
+    // Update changes an existing member instead of refusing
+    Update bool
+}
+
+// addMember creates a member by hand, through ProcessMember like a webhook
+// would, and tags them. It returns the member as stored and whether they're
+// new. An existing member is returned with ErrMemberExists unless
+// opts.Update is set.
+func addMember(db Store, email string, opts addMemberOptions) (*Member, bool, error) {
+    normalized := db.NormalizeEmail(email)
+    existing, err := db.GetMemberByEmail(normalized)
+    if err == nil && !opts.Update {
+        return existing, false, ErrMemberExists
+    } else if err != nil && !errors.Is(err, ErrMemberNotFound) {
+        return nil, false, err
+    }
+
+    if _, err := db.ProcessMember(email, opts.Name, opts.Anonymous, opts.Status, ChangeSource{Source: "manual", Detail: "added by hand"}); err != nil {
+        return nil, false, err
+    }
+    for _, tag := range opts.Tags {
+        if err := db.AddMemberTag(normalized, tag); err != nil {
+            return nil, false, fmt.Errorf("failed to tag member: %w", err)
+        }
+    }
+
+    member, err := db.GetMemberByEmail(normalized)
+    if err != nil {
+        return nil, false, fmt.Errorf("failed to load member: %w", err)
+    }
+    return member, existing == nil, nil
+}
+
+func runAdd(logger *log.Logger) {
+    addCmd := flag.NewFlagSet("add", flag.ExitOnError)
+    name := addCmd.String("name", "", "Member's full name")
+    anonymous := addCmd.Bool("anonymous", false, "Mark the member anonymous (no name is stored)")
+    status := addCmd.String("status", "active", "Initial status: active, cancelled, or suspended")
+    update := addCmd.Bool("update", false, "Update the member if they already exist")
+    var tags stringListFlag
+    addCmd.Var(&tags, "tag", "Tag to add, e.g. comped (repeatable)")
+
+    args := parseSubcommand(addCmd, `memberships add <email> [--name "Full Name"] [--anonymous] [--status active] [--tag comped]`, os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: add command requires an email")
+        addCmd.Usage()
+        os.Exit(2)
+    }
+
+    *status = strings.ToLower(strings.TrimSpace(*status))
+    if !manualStatuses[*status] {
+        fmt.Fprintf(os.Stderr, "Error: invalid status %q (use active, cancelled, or suspended)\n", *status)
+        os.Exit(2)
+    }
+
+    if err := validateEmail(args[0]); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(2)
+    }
+
+    if *anonymous && strings.TrimSpace(*name) != "" {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", ErrAnonymousName)
+        os.Exit(2)
+    }
+
+    for _, tag := range tags {
+        if normalizeTag(tag) == "" {
+            fmt.Fprintln(os.Stderr, "Error: tags must not be empty")
+            os.Exit(2)
+        }
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    member, created, err := addMember(db, args[0], addMemberOptions{
+        Name:      *name,
+        Anonymous: *anonymous,
+        Status:    *status,
+        Tags:      tags,
+        Update:    *update,
+    })
+    if errors.Is(err, ErrMemberExists) {
+        fmt.Fprintf(os.Stderr, "Member %s already exists (ID: %d, status %s); pass --update to change it\n",
+            member.Email, member.ID, member.Status)
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Add failed: %v", err)
+    }
+
+    action := "Updated"
+    if created {
+        action = "Created"
+    }
+
+    displayName := member.Name.String
+    if member.IsAnonymous {
+        displayName = "(anonymous)"
+    }
+
+    fmt.Printf("%s member %s (ID: %d)\n", action, member.Email, member.ID)
+    fmt.Printf("  Name:   %s\n", displayName)
+    fmt.Printf("  Status: %s\n", member.Status)
+    if len(member.Tags) > 0 {
+        fmt.Printf("  Tags:   %s\n", strings.Join(member.Tags, ", "))
+    }
+}
+
+// createMemberRequest is the body of POST /members
+type createMemberRequest struct {
+    Email     string   `json:"email"`
+    Name      string   `json:"name"`
+    Anonymous bool     `json:"is_anonymous"`
+    Status    string   `json:"status"`
+    Tags      []string `json:"tags"`
+    Upsert    bool     `json:"upsert"`
+}
+
+// createMemberHandler adds a member, e.g. a comped volunteer. An existing
+// member is a 409 unless upsert is set, in which case it's updated.
+func (s *WebhookServer) createMemberHandler(w http.ResponseWriter, r *http.Request) {
+    var req createMemberRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid JSON")
+        return
+    }
+
+    if err := validateEmail(req.Email); err != nil {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+        return
+    }
+    req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+    if req.Status == "" {
+        req.Status = StatusActive
+    }
+    if !manualStatuses[req.Status] {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, fmt.Sprintf("Invalid status %q (use active, cancelled, or suspended)", req.Status))
+        return
+    }
+    if req.Anonymous && strings.TrimSpace(req.Name) != "" {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, "Anonymous members can't have a name")
+        return
+    }
+    for _, tag := range req.Tags {
+        if normalizeTag(tag) == "" {
+            writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, "tags must not be empty")
+            return
+        }
+    }
+
+    email := s.db.NormalizeEmail(req.Email)
+    existing, err := s.db.GetMemberByEmail(email)
+    if err != nil && !errors.Is(err, ErrMemberNotFound) {
+        s.logger.Printf("Error looking up member: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    if existing != nil && !req.Upsert {
+        writeError(w, r, http.StatusConflict, errConflict, "Member already exists; pass \"upsert\": true to update them")
+        return
+    }
+
+    action := "created"
+    if existing != nil {
+        action = "updated"
+    }
+    change := ChangeSource{Source: "manual", Detail: fmt.Sprintf("%s via API by %s", action, s.principal(r))}
+    _, err = s.db.ProcessMember(req.Email, req.Name, req.Anonymous, req.Status, change)
+    for _, tag := range req.Tags {
+        if err != nil {
+            break
+        }
+        err = s.db.AddMemberTag(email, tag)
+    }
+    if err != nil {
+        s.logger.Printf("Error creating member: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    member, err := s.db.GetMemberByEmail(email)
+    if err != nil {
+        s.logger.Printf("Error reloading member: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    s.logger.Printf("Member %s %s via API by %s", member.Email, action, s.principal(r))
+
+    w.Header().Set("Content-Type", "application/json")
+    if existing == nil {
+        w.Header().Set("Location", "/v1/members/id/"+member.PublicID)
+        w.WriteHeader(http.StatusCreated)
+    }
+    json.NewEncoder(w).Encode(memberResponse(member))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "errors"
+    "strings"
+    "testing"
+)
+
+func TestAddMember(t *testing.T) {
+    db := newMemStore()
+    member, created, err := addMember(db, "Ada@Example.org", addMemberOptions{
+        Name:   "Ada Lovelace",
+        Status: StatusActive,
+        Tags:   []string{"Comped"},
+    })
+    if err != nil || !created {
+        t.Fatalf("addMember = %v, %v", created, err)
+    }
+    if member.Email != "ada@example.org" || member.Name.String != "Ada Lovelace" || member.Status != StatusActive {
+        t.Errorf("member = %+v", member)
+    }
+    if len(member.Tags) != 1 || member.Tags[0] != "comped" {
+        t.Errorf("tags = %q", member.Tags)
+    }
+    if member.FirstSeen.IsZero() {
+        t.Error("first_seen not set")
+    }
+
+    history := 0
+    for _, h := range db.history {
+        if h.memberID == member.ID {
+            history++
+            if h.Source != "manual" || h.Detail != "added by hand" {
+                t.Errorf("history = %+v", h.StatusChange)
+            }
+        }
+    }
+    if history != 1 {
+        t.Errorf("%d status history rows, want 1", history)
+    }
+}
+
+func TestAddExistingMember(t *testing.T) {
+    db := newMemStore()
+    if _, err := db.ProcessMember("ada@example.org", "Ada Lovelace", false, StatusSuspended, ChangeSource{Source: "webhook"}); err != nil {
+        t.Fatal(err)
+    }
+
+    opts := addMemberOptions{Name: "Ada King", Status: StatusActive, Tags: []string{"comped"}}
+    member, created, err := addMember(db, "ADA@example.org", opts)
+    if !errors.Is(err, ErrMemberExists) || created {
+        t.Fatalf("addMember = %v, %v; want ErrMemberExists", created, err)
+    }
+    if member == nil || member.Status != StatusSuspended {
+        t.Errorf("existing member = %+v", member)
+    }
+    if m := db.member("ada@example.org"); m.Name.String != "Ada Lovelace" || m.Status != StatusSuspended || len(m.Tags) != 0 {
+        t.Errorf("refused add changed the member: %+v", m.Member)
+    }
+
+    opts.Update = true
+    member, created, err = addMember(db, "ADA@example.org", opts)
+    if err != nil || created {
+        t.Fatalf("addMember --update = %v, %v", created, err)
+    }
+    if member.Name.String != "Ada King" || member.Status != StatusActive || len(member.Tags) != 1 {
+        t.Errorf("updated member = %+v", member)
+    }
+    if len(db.members) != 1 {
+        t.Errorf("%d members, want 1", len(db.members))
+    }
+}
+
+func TestAddAnonymousMember(t *testing.T) {
+    db := newMemStore()
+    member, created, err := addMember(db, "anon@example.org", addMemberOptions{Anonymous: true, Status: StatusActive})
+    if err != nil || !created {
+        t.Fatalf("addMember = %v, %v", created, err)
+    }
+    if !member.IsAnonymous || member.Name.String != "" {
+        t.Errorf("member = %+v", member)
+    }
+
+    // Updating a named member to anonymous keeps the name on file, as a
+    // webhook would; it's just no longer shown
+    if _, err := db.ProcessMember("ada@example.org", "Ada Lovelace", false, StatusActive, ChangeSource{Source: "webhook"}); err != nil {
+        t.Fatal(err)
+    }
+    member, _, err = addMember(db, "ada@example.org", addMemberOptions{Anonymous: true, Status: StatusActive, Update: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !member.IsAnonymous || member.Name.String != "Ada Lovelace" {
+        t.Errorf("member = %+v", member)
+    }
+}
+
+func TestAddRejectsAnonymousName(t *testing.T) {
+    stderr, code := runCLI(t, "add", "anon@example.org", "--anonymous", "--name", "Ada Lovelace")
+    if code != 2 || !strings.Contains(stderr, ErrAnonymousName.Error()) {
+        t.Errorf("exit %d, stderr:\n%s", code, stderr)
+    }
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// defaultAnniversaryWindow is how far ahead anniversaries are listed
+const defaultAnniversaryWindow = 14 * 24 * time.Hour
+
+// maxAnniversaryWindow bounds ?within= on the API
+const maxAnniversaryWindow = 366 * 24 * time.Hour
+
+// donationMilestones are the gift counts worth a thank-you
+var donationMilestones = []int{12, 24, 36, 48, 60, 100}
+
+// Anniversary is a member with a membership anniversary or donation
+// milestone to thank them for
+type Anniversary struct {
+    Email     string    `json:"email"`
+    Name      string    `json:"name,omitempty"`
+    Status    string    `json:"status"`
+    FirstSeen time.Time `json:"first_seen"`
+
+    // Date and Years are the upcoming anniversary, if it's in the window
+    Date  *time.Time `json:"date,omitempty"`
+    Years int        `json:"years,omitempty"`
+
+    // Milestone is the gift count reached within the window, if any
+    Milestone   int        `json:"milestone,omitempty"`
+    MilestoneAt *time.Time `json:"milestone_at,omitempty"`
+}
+
+// nextAnniversary returns the first anniversary of firstSeen on or after
+// from's date, and how many years it marks. A Feb 29 start is celebrated on
+// Feb 28 in non-leap years.
+func nextAnniversary(firstSeen, from time.Time) (time.Time, int) {
+    from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+    on := func(year int) time.Time {
+        day := firstSeen.Day()
+        if firstSeen.Month() == time.February && day == 29 && !isLeapYear(year) {
+            day = 28
+        }
+        return time.Date(year, firstSeen.Month(), day, 0, 0, 0, 0, time.UTC)
+    }
+
+    year := from.Year()
+    if on(year).Before(from) {
+        year++
+    }
+    return on(year), year - firstSeen.Year()
+}
+
+func isLeapYear(year int) bool {
+    return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// upcomingAnniversaries picks the members whose anniversary, of at least
+// one year, falls between now and now+within, soonest first
+func upcomingAnniversaries(members []Anniversary, now time.Time, within time.Duration) []Anniversary {
+    end := now.Add(within)
+
+    var upcoming []Anniversary
+    for _, m := range members {
+        date, years := nextAnniversary(m.FirstSeen.In(displayZone), now.In(displayZone))
+        if years < 1 || date.After(end) {
+            continue
+        }
+        m.Date, m.Years = &date, years
+        upcoming = append(upcoming, m)
+    }
+
+    sort.SliceStable(upcoming, func(i, j int) bool {
+        return upcoming[i].Date.Before(*upcoming[j].Date)
+    })
+    return upcoming
+}
+
+// GetAnniversaryMembers returns the members eligible for a thank-you:
+// anyone not cancelled and not anonymous
+func (db *Database) GetAnniversaryMembers() ([]Anniversary, error) {
+    rows, err := db.Query(`
+        SELECT email, COALESCE(name, ''), status, first_seen
+        FROM members
+        WHERE status <> $1 AND NOT is_anonymous
+        ORDER BY email
+    `, StatusCancelled)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load members: %w", err)
+    }
+    defer rows.Close()
+
+    var members []Anniversary
+    for rows.Next() {
+        var m Anniversary
+        if err := rows.Scan(&m.Email, &m.Name, &m.Status, &m.FirstSeen); err != nil {
+            return nil, err
+        }
+        members = append(members, m)
+    }
+
+    return members, rows.Err()
+}
+
+// GetDonationMilestones returns eligible members whose Nth gift, for N in
+// donationMilestones, was made since the given time. Refunded gifts don't
+// count.
+func (db *Database) GetDonationMilestones(since time.Time) ([]Anniversary, error) {
+    rows, err := db.Query(`
+        SELECT m.email, COALESCE(m.name, ''), m.status, m.first_seen, d.n, d.occurred_at
+        FROM (
+            SELECT member_id, occurred_at,
+                   row_number() OVER (PARTITION BY member_id ORDER BY occurred_at, id) AS n
+            FROM donations
+            WHERE refunded_at IS NULL
+        ) d
+        JOIN members m ON m.id = d.member_id
+        WHERE d.n = ANY($1) AND d.occurred_at >= $2
+        AND m.status <> $3 AND NOT m.is_anonymous
+        ORDER BY d.occurred_at
+    `, pq.Array(donationMilestones), since, StatusCancelled)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load donation milestones: %w", err)
+    }
+    defer rows.Close()
+
+    var milestones []Anniversary
+    for rows.Next() {
+        var m Anniversary
+        var at sql.NullTime
+        if err := rows.Scan(&m.Email, &m.Name, &m.Status, &m.FirstSeen, &m.Milestone, &at); err != nil {
+            return nil, err
+        }
+        m.MilestoneAt = &at.Time
+        milestones = append(milestones, m)
+    }
+
+    return milestones, rows.Err()
+}
+
+// anniversaryReport lists upcoming anniversaries and, if asked, milestones
+// reached in the past window
+func anniversaryReport(db Store, within time.Duration, milestones bool) ([]Anniversary, error) {
+    members, err := db.GetAnniversaryMembers()
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    report := upcomingAnniversaries(members, now, within)
+
+    if milestones {
+        reached, err := db.GetDonationMilestones(now.Add(-within))
+        if err != nil {
+            return nil, err
+        }
+        report = append(report, reached...)
+    }
+
+    if report == nil {
+        report = []Anniversary{}
+    }
+    return report, nil
+}
+
+// anniversariesHandler serves GET /members/anniversaries?within=14d&milestones=1
+func (s *WebhookServer) anniversariesHandler(w http.ResponseWriter, r *http.Request) {
+    within := defaultAnniversaryWindow
+    if value := r.URL.Query().Get("within"); value != "" {
+        d, err := parseAge(value)
+        if err != nil || d > maxAnniversaryWindow {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "within must be a duration like 14d, at most 366d")
+            return
+        }
+        within = d
+    }
+    milestones := r.URL.Query().Get("milestones") == "1" || r.URL.Query().Get("milestones") == "true"
+
+    report, err := anniversaryReport(s.db, within, milestones)
+    if err != nil {
+        s.logger.Printf("Error getting anniversaries: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(report)
+}
+
+func runAnniversariesReport(args []string, logger *log.Logger) {
+    anniversariesCmd := flag.NewFlagSet("report anniversaries", flag.ExitOnError)
+    within := anniversariesCmd.String("within", "14d", "List anniversaries this far ahead, e.g. 14d")
+    milestones := anniversariesCmd.Bool("milestones", false, "Also list members who reached a gift-count milestone (12th, 24th, ...) in the same span back")
+    format := anniversariesCmd.String("format", "table", "Output format: table, csv, or json")
+
+    parseSubcommand(anniversariesCmd, "memberships report anniversaries [--within 14d] [--milestones] [--format table|csv|json]", args)
+
+    window, err := parseAge(*within)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: --within: %v\n", err)
+        os.Exit(2)
+    }
+    if *format != "table" && *format != "csv" && *format != "json" {
+        fmt.Fprintf(os.Stderr, "Error: unsupported format %q (use table, csv, or json)\n", *format)
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    report, err := anniversaryReport(db, window, *milestones)
+    if err != nil {
+        logger.Fatalf("Anniversary report failed: %v", err)
+    }
+
+    switch *format {
+    case "json":
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(report)
+    case "csv":
+        writer := csv.NewWriter(os.Stdout)
+        writer.Write([]string{"email", "name", "status", "first_seen", "occasion", "date"})
+        for _, a := range report {
+            occasion, date := a.occasion()
+            writer.Write([]string{a.Email, a.Name, a.Status, displayDate(a.FirstSeen), occasion, date})
+        }
+        writer.Flush()
+        if err := writer.Error(); err != nil {
+            logger.Fatalf("Failed to write CSV: %v", err)
+        }
+    default:
+        if len(report) == 0 {
+            fmt.Println("No anniversaries or milestones in that window")
+            return
+        }
+        for _, a := range report {
+            occasion, date := a.occasion()
+            name := a.Name
+            if name == "" {
+                name = "(no name)"
+            }
+            fmt.Printf("%s  %-22s  %-32s  %s\n", date, occasion, a.Email, name)
+        }
+    }
+}
+
+// occasion describes what an entry celebrates, and when
+func (a Anniversary) occasion() (string, string) {
+    if a.Milestone > 0 {
+        return ordinal(a.Milestone) + " gift", displayDate(*a.MilestoneAt)
+    }
+    if a.Years == 1 {
+        return "1 year", a.Date.Format("2006-01-02")
+    }
+    return strconv.Itoa(a.Years) + " years", a.Date.Format("2006-01-02")
+}
+
+// ordinal formats 1 as 1st, 12 as 12th, 22 as 22nd
+func ordinal(n int) string {
+    suffix := "th"
+    switch {
+    case n%100 >= 11 && n%100 <= 13:
+    case n%10 == 1:
+        suffix = "st"
+    case n%10 == 2:
+        suffix = "nd"
+    case n%10 == 3:
+        suffix = "rd"
+    }
+    return strconv.Itoa(n) + suffix
+}
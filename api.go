@@ -0,0 +1,149 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// apiVersion selects the response shapes a handler writes. Handlers share
+// their logic across versions and branch only where a shape differs.
+type apiVersion int
+
+const (
+    apiLegacy apiVersion = iota // unprefixed routes, deprecated
+    apiV1
+)
+
+// apiVersions are the versions served under a /vN prefix
+var apiVersions = []apiVersion{apiV1}
+
+// legacySunset is when the unprefixed read routes go away, as an HTTP date
+const legacySunset = "Fri, 30 Apr 2027 00:00:00 GMT"
+
+// Paging limits for /v1/members
+const (
+    defaultMembersLimit = 100
+    maxMembersLimit     = 1000
+)
+
+type apiVersionKey struct{}
+
+func (v apiVersion) prefix() string {
+    return "/v" + strconv.Itoa(int(v))
+}
+
+// versionOf returns the API version a request was routed under
+func versionOf(r *http.Request) apiVersion {
+    v, _ := r.Context().Value(apiVersionKey{}).(apiVersion)
+    return v
+}
+
+// versioned tags a request with its API version. Legacy responses carry
+// Deprecation and Sunset headers and link to the current version.
+func versioned(v apiVersion, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if v == apiLegacy {
+            current := apiVersions[len(apiVersions)-1]
+            w.Header().Set("Deprecation", "true")
+            w.Header().Set("Sunset", legacySunset)
+            w.Header().Set("Link", "<"+current.prefix()+r.URL.Path+`>; rel="successor-version"`)
+        }
+        next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, v)))
+    }
+}
+
+// handleRead registers a read endpoint under every versioned prefix and,
+// deprecated, at its original path. pattern is "METHOD /path".
+func (s *WebhookServer) handleRead(pattern string, handler http.HandlerFunc) {
+    method, path, _ := strings.Cut(pattern, " ")
+    for _, v := range apiVersions {
+        s.mux.HandleFunc(method+" "+v.prefix()+path, versioned(v, handler))
+    }
+    s.mux.HandleFunc(pattern, versioned(apiLegacy, handler))
+}
+
+// apiMember is a member as /v1 returns it. Notes, the Discord ID, and giving
+// history are only filled in for the admin single-member endpoints.
+type apiMember struct {
+    ID             string     `json:"id"`
+    Email          string     `json:"email"`
+    RawEmail       string     `json:"raw_email,omitempty"`
+    Name           string     `json:"name,omitempty"`
+    Status         string     `json:"status"`
+    IsAnonymous    bool       `json:"is_anonymous"`
+    Tags           []string   `json:"tags"`
+    Frequency      string     `json:"frequency,omitempty"`
+    Campaign       string     `json:"campaign,omitempty"`
+    Notes          string     `json:"notes,omitempty"`
+    DiscordID      string     `json:"discord_id,omitempty"`
+    FirstSeen      time.Time  `json:"first_seen"`
+    LastUpdated    time.Time  `json:"last_updated"`
+    FirstPaymentAt *time.Time `json:"first_payment_at"`
+    LastPaymentAt  *time.Time `json:"last_payment_at"`
+
+    // Email consent; email_opt_in is null until a choice is recorded
+    EmailOptIn        *bool      `json:"email_opt_in"`
+    ConsentRecordedAt *time.Time `json:"consent_recorded_at,omitempty"`
+    ConsentSource     string     `json:"consent_source,omitempty"`
+
+    // Organization is an active organization the member belongs to, which
+    // makes them a member whatever their own status
+    Organization string `json:"organization,omitempty"`
+
+    // Giving history, on the single-member endpoints only
+    Giving    []DonationTotal `json:"giving,omitempty"`
+    Donations []apiDonation   `json:"donations,omitempty"`
+}
+
+// newAPIMember converts a member for /v1. Anonymous members' names are
+// never shown.
+func newAPIMember(m *Member) apiMember {
+    am := apiMember{
+        ID:           m.PublicID,
+        Email:        m.Email,
+        Status:       m.Status,
+        IsAnonymous:  m.IsAnonymous,
+        Tags:         m.Tags,
+        Frequency:    m.Frequency.String,
+        Campaign:     m.Campaign.String,
+        Notes:        m.Notes.String,
+        DiscordID:    m.DiscordID.String,
+        FirstSeen:    m.FirstSeen,
+        LastUpdated:  m.LastUpdated,
+        Organization: m.Organization.String,
+    }
+    if am.Tags == nil {
+        am.Tags = []string{}
+    }
+    if !m.IsAnonymous {
+        am.Name = m.Name.String
+    }
+    if m.RawEmail.String != m.Email {
+        am.RawEmail = m.RawEmail.String
+    }
+    if m.FirstPaymentAt.Valid {
+        am.FirstPaymentAt = &m.FirstPaymentAt.Time
+    }
+    if m.LastPaymentAt.Valid {
+        am.LastPaymentAt = &m.LastPaymentAt.Time
+    }
+    if m.EmailOptIn.Valid {
+        am.EmailOptIn = &m.EmailOptIn.Bool
+    }
+    if m.ConsentRecordedAt.Valid {
+        am.ConsentRecordedAt = &m.ConsentRecordedAt.Time
+        am.ConsentSource = m.ConsentSource.String
+    }
+    return am
+}
+
+// apiMemberPage is the /v1/members response
+type apiMemberPage struct {
+    Members []apiMember `json:"members"`
+    Limit   int         `json:"limit"`
+    Offset  int         `json:"offset"`
+    HasMore bool        `json:"has_more"`
+}
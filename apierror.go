@@ -0,0 +1,39 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+)
+
+// Error codes in JSON error responses. These are part of the API, so add
+// new ones rather than renaming.
+const (
+    errUnauthorized     = "unauthorized"
+    errForbidden        = "forbidden"
+    errInvalidPayload   = "invalid_payload"
+    errNotFound         = "not_found"
+    errMethodNotAllowed = "method_not_allowed"
+    errConflict         = "conflict"
+    errRateLimited      = "rate_limited"
+    errUnavailable      = "unavailable"
+    errInternal         = "internal"
+)
+
+// apiError is the body of every error response, under an "error" key
+type apiError struct {
+    Code      string `json:"code"`
+    Message   string `json:"message"`
+    RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError sends a JSON error response
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+    h := w.Header()
+    h.Del("Content-Length")
+    h.Set("Content-Type", "application/json")
+    h.Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(map[string]apiError{
+        "error": {Code: code, Message: message, RequestID: requestID(r)},
+    })
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+    "bufio"
+    "compress/gzip"
+    "context"
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// backupVersion is written in the header so restore can reject newer formats
+const backupVersion = 1
+
+// A backup is one JSON record per line, gzipped when the file ends in .gz:
+// a header, then every member, status_history row, and optionally webhook_logs
+// row, streamed straight from the database.
+type backupRecord struct {
+    Type string          `json:"type"`
+    Data json.RawMessage `json:"data"`
+}
+
+// backupHeader identifies the archive
+type backupHeader struct {
+    Version   int       `json:"version"`
+    CreatedAt time.Time `json:"created_at"`
+    Build     BuildInfo `json:"build"`
+}
+
+type backupMember struct {
+    PublicID       *string    `json:"public_id,omitempty"`
+    Email          string     `json:"email"`
+    RawEmail       *string    `json:"raw_email,omitempty"`
+    Name           *string    `json:"name,omitempty"`
+    IsAnonymous    bool       `json:"is_anonymous"`
+    Status         string     `json:"status"`
+    Notes          *string    `json:"notes,omitempty"`
+    Tags           []string   `json:"tags"`
+    FirstSeen      *time.Time `json:"first_seen,omitempty"`
+    LastUpdated    *time.Time `json:"last_updated,omitempty"`
+    FirstPaymentAt *time.Time `json:"first_payment_at,omitempty"`
+    LastPaymentAt  *time.Time `json:"last_payment_at,omitempty"`
+    Frequency      *string    `json:"frequency,omitempty"`
+    FrequencyRaw   *string    `json:"frequency_raw,omitempty"`
+    DiscordID      *string    `json:"discord_id,omitempty"`
+    Campaign       *string    `json:"campaign,omitempty"`
+
+    EmailOptIn        *bool      `json:"email_opt_in,omitempty"`
+    ConsentRecordedAt *time.Time `json:"consent_recorded_at,omitempty"`
+    ConsentSource     *string    `json:"consent_source,omitempty"`
+    UnsubscribeToken  *string    `json:"unsubscribe_token,omitempty"`
+}
+
+type backupHistory struct {
+    Email     string    `json:"email"`
+    Status    string    `json:"status"`
+    Reason    *string   `json:"reason,omitempty"`
+    Source    *string   `json:"source,omitempty"`
+    Detail    *string   `json:"detail,omitempty"`
+    ChangedAt time.Time `json:"changed_at"`
+}
+
+type backupWebhookLog struct {
+    ReceivedAt    time.Time       `json:"received_at"`
+    Email         *string         `json:"email,omitempty"`
+    Status        *string         `json:"status,omitempty"`
+    Payload       json.RawMessage `json:"payload,omitempty"`
+    State         *string         `json:"state,omitempty"`
+    Attempts      int             `json:"attempts"`
+    NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+    LastError     *string         `json:"last_error,omitempty"`
+}
+
+// BackupResult counts what a backup or restore covered
+type BackupResult struct {
+    Members        int
+    NewMembers     int
+    History        int
+    WebhookLogs    int
+    SkippedRecords int
+}
+
+// defaultBackupPath names a backup after the current time
+func defaultBackupPath() string {
+    return fmt.Sprintf("members-%s.json.gz", time.Now().Format("2006-01-02-150405"))
+}
+
+// WriteBackup streams the database to path. It writes to a temporary file
+// first so a failed backup never leaves a truncated archive behind.
+func (db *Database) WriteBackup(path string, includeWebhooks bool) (*BackupResult, error) {
+    tmpPath := path + ".tmp"
+    file, err := os.Create(tmpPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create backup: %w", err)
+    }
+    defer os.Remove(tmpPath)
+    defer file.Close()
+
+    buffered := bufio.NewWriter(file)
+    var out io.Writer = buffered
+    var gz *gzip.Writer
+    if strings.HasSuffix(path, ".gz") {
+        gz = gzip.NewWriter(buffered)
+        out = gz
+    }
+    encoder := json.NewEncoder(out)
+
+    write := func(recordType string, data interface{}) error {
+        raw, err := json.Marshal(data)
+        if err != nil {
+            return err
+        }
+        return encoder.Encode(backupRecord{Type: recordType, Data: raw})
+    }
+
+    if err := write("header", backupHeader{Version: backupVersion, CreatedAt: time.Now().UTC(), Build: buildInfo()}); err != nil {
+        return nil, fmt.Errorf("failed to write backup: %w", err)
+    }
+
+    result := &BackupResult{}
+
+    // Read from one snapshot so members and history agree
+    tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    err = streamRows(tx, `
+        SELECT public_id, email, raw_email, name, COALESCE(is_anonymous, false), status, notes, tags,
+               first_seen, last_updated, first_payment_at, last_payment_at, frequency, frequency_raw, discord_id,
+               email_opt_in, consent_recorded_at, consent_source, unsubscribe_token, campaign
+        FROM members ORDER BY id
+    `, func(rows *sql.Rows) error {
+        var m backupMember
+        err := rows.Scan(&m.PublicID, &m.Email, &m.RawEmail, &m.Name, &m.IsAnonymous, &m.Status, &m.Notes, pq.Array(&m.Tags),
+            &m.FirstSeen, &m.LastUpdated, &m.FirstPaymentAt, &m.LastPaymentAt, &m.Frequency, &m.FrequencyRaw, &m.DiscordID,
+            &m.EmailOptIn, &m.ConsentRecordedAt, &m.ConsentSource, &m.UnsubscribeToken, &m.Campaign)
+        if err != nil {
+            return err
+        }
+        result.Members++
+        return write("member", m)
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to back up members: %w", err)
+    }
+
+    err = streamRows(tx, `
+        SELECT m.email, h.status, h.reason, h.source, h.detail, h.changed_at
+        FROM status_history h
+        JOIN members m ON m.id = h.member_id
+        ORDER BY h.id
+    `, func(rows *sql.Rows) error {
+        var h backupHistory
+        if err := rows.Scan(&h.Email, &h.Status, &h.Reason, &h.Source, &h.Detail, &h.ChangedAt); err != nil {
+            return err
+        }
+        result.History++
+        return write("status_history", h)
+    })
+    if err != nil {
+        return nil, fmt.Errorf("failed to back up status history: %w", err)
+    }
+
+    if includeWebhooks {
+        err = streamRows(tx, `
+            SELECT received_at, email, status, payload, state, attempts, next_attempt_at, last_error
+            FROM webhook_logs ORDER BY id
+        `, func(rows *sql.Rows) error {
+            var l backupWebhookLog
+            var payload []byte
+            err := rows.Scan(&l.ReceivedAt, &l.Email, &l.Status, &payload, &l.State, &l.Attempts, &l.NextAttemptAt, &l.LastError)
+            if err != nil {
+                return err
+            }
+            l.Payload = payload
+            result.WebhookLogs++
+            return write("webhook_log", l)
+        })
+        if err != nil {
+            return nil, fmt.Errorf("failed to back up webhook logs: %w", err)
+        }
+    }
+
+    if gz != nil {
+        if err := gz.Close(); err != nil {
+            return nil, fmt.Errorf("failed to write backup: %w", err)
+        }
+    }
+    if err := buffered.Flush(); err != nil {
+        return nil, fmt.Errorf("failed to write backup: %w", err)
+    }
+    if err := file.Close(); err != nil {
+        return nil, fmt.Errorf("failed to write backup: %w", err)
+    }
+    if err := os.Rename(tmpPath, path); err != nil {
+        return nil, fmt.Errorf("failed to write backup: %w", err)
+    }
+
+    return result, nil
+}
+
+// streamRows calls fn for each row without buffering the result set
+func streamRows(q querier, query string, fn func(*sql.Rows) error) error {
+    rows, err := q.Query(query)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        if err := fn(rows); err != nil {
+            return err
+        }
+    }
+    return rows.Err()
+}
+
+// RestoreBackup re-imports a backup in one transaction: members are upserted
+// by email, and history and webhook log rows not already present are
+// replayed. A dry run does all the work and then rolls it back.
+func (db *Database) RestoreBackup(path string, dryRun bool) (*BackupResult, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open backup: %w", err)
+    }
+    defer file.Close()
+
+    var in io.Reader = bufio.NewReader(file)
+    if strings.HasSuffix(path, ".gz") {
+        gz, err := gzip.NewReader(in)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read backup: %w", err)
+        }
+        defer gz.Close()
+        in = gz
+    }
+    decoder := json.NewDecoder(in)
+
+    var record backupRecord
+    if err := decoder.Decode(&record); err != nil || record.Type != "header" {
+        return nil, fmt.Errorf("%s is not a memberships backup", path)
+    }
+    var header backupHeader
+    if err := json.Unmarshal(record.Data, &header); err != nil {
+        return nil, fmt.Errorf("failed to read backup header: %w", err)
+    }
+    if header.Version > backupVersion {
+        return nil, fmt.Errorf("backup version %d is newer than this build supports (%d)", header.Version, backupVersion)
+    }
+    db.logger.Printf("Restoring backup from %s (created %s)", path, header.CreatedAt.Format(time.RFC3339))
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    result := &BackupResult{}
+    for line := 2; ; line++ {
+        record = backupRecord{}
+        if err := decoder.Decode(&record); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("record %d: %w", line, err)
+        }
+
+        if err := restoreRecord(tx, record, result); err != nil {
+            return nil, fmt.Errorf("record %d: %w", line, err)
+        }
+    }
+
+    err = recordEvent(tx, feedBackupRestored, 0, ChangeSource{Source: "restore", Detail: path}, map[string]interface{}{
+        "members":      result.Members,
+        "new_members":  result.NewMembers,
+        "history":      result.History,
+        "webhook_logs": result.WebhookLogs,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if dryRun {
+        return result, nil
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+    return result, nil
+}
+
+// restoreRecord applies one backup record
+func restoreRecord(tx *sql.Tx, record backupRecord, result *BackupResult) error {
+    switch record.Type {
+    case "member":
+        var m backupMember
+        if err := json.Unmarshal(record.Data, &m); err != nil {
+            return err
+        }
+        if m.Tags == nil {
+            m.Tags = []string{}
+        }
+        // Backups from before frequencies were normalized hold the raw value
+        if m.Frequency != nil && m.FrequencyRaw == nil && !validFrequency(*m.Frequency) {
+            raw, normalized := *m.Frequency, normalizeFrequency(*m.Frequency)
+            m.FrequencyRaw, m.Frequency = &raw, &normalized
+        }
+
+        var inserted bool
+        err := tx.QueryRow(`
+            INSERT INTO members (email, raw_email, name, is_anonymous, status, notes, tags,
+                                 first_seen, last_updated, first_payment_at, last_payment_at, frequency, discord_id, email_hash, public_id,
+                                 email_opt_in, consent_recorded_at, consent_source, unsubscribe_token, frequency_raw, campaign)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8, CURRENT_TIMESTAMP), COALESCE($9, CURRENT_TIMESTAMP), $10, $11, $12, $13, $14,
+                    COALESCE($15::uuid, gen_random_uuid()), $16, $17, $18, COALESCE($19::uuid, gen_random_uuid()), $20, $21)
+            ON CONFLICT (email) DO UPDATE SET
+                public_id = EXCLUDED.public_id,
+                email_hash = EXCLUDED.email_hash,
+                raw_email = EXCLUDED.raw_email,
+                name = EXCLUDED.name,
+                is_anonymous = EXCLUDED.is_anonymous,
+                status = EXCLUDED.status,
+                notes = EXCLUDED.notes,
+                tags = EXCLUDED.tags,
+                first_seen = EXCLUDED.first_seen,
+                last_updated = EXCLUDED.last_updated,
+                first_payment_at = EXCLUDED.first_payment_at,
+                last_payment_at = EXCLUDED.last_payment_at,
+                frequency = EXCLUDED.frequency,
+                frequency_raw = EXCLUDED.frequency_raw,
+                discord_id = EXCLUDED.discord_id,
+                email_opt_in = EXCLUDED.email_opt_in,
+                consent_recorded_at = EXCLUDED.consent_recorded_at,
+                consent_source = EXCLUDED.consent_source,
+                unsubscribe_token = EXCLUDED.unsubscribe_token,
+                campaign = EXCLUDED.campaign
+            RETURNING (xmax = 0)
+        `, m.Email, m.RawEmail, m.Name, m.IsAnonymous, m.Status, m.Notes, pq.Array(m.Tags),
+            m.FirstSeen, m.LastUpdated, m.FirstPaymentAt, m.LastPaymentAt, m.Frequency, m.DiscordID, emailHash(m.Email), m.PublicID,
+            m.EmailOptIn, m.ConsentRecordedAt, m.ConsentSource, m.UnsubscribeToken, m.FrequencyRaw, m.Campaign).Scan(&inserted)
+        if err != nil {
+            return fmt.Errorf("failed to restore member %s: %w", m.Email, err)
+        }
+        result.Members++
+        if inserted {
+            result.NewMembers++
+        }
+
+    case "status_history":
+        var h backupHistory
+        if err := json.Unmarshal(record.Data, &h); err != nil {
+            return err
+        }
+
+        res, err := tx.Exec(`
+            INSERT INTO status_history (member_id, status, reason, source, detail, changed_at)
+            SELECT m.id, $2, $3, COALESCE($5, 'restore'), $6, $4 FROM members m
+            WHERE m.email = $1 AND NOT EXISTS (
+                SELECT 1 FROM status_history h
+                WHERE h.member_id = m.id AND h.status = $2 AND h.changed_at = $4
+            )
+        `, h.Email, h.Status, h.Reason, h.ChangedAt, h.Source, h.Detail)
+        if err != nil {
+            return fmt.Errorf("failed to restore history for %s: %w", h.Email, err)
+        }
+        if n, _ := res.RowsAffected(); n > 0 {
+            result.History++
+        } else {
+            result.SkippedRecords++
+        }
+
+    case "webhook_log":
+        var l backupWebhookLog
+        if err := json.Unmarshal(record.Data, &l); err != nil {
+            return err
+        }
+
+        var payload interface{}
+        if len(l.Payload) > 0 && string(l.Payload) != "null" {
+            payload = string(l.Payload)
+        }
+
+        res, err := tx.Exec(`
+            INSERT INTO webhook_logs (received_at, email, status, payload, state, attempts, next_attempt_at, last_error)
+            SELECT $1, $2, $3, $4, $5, $6, $7, $8
+            WHERE NOT EXISTS (
+                SELECT 1 FROM webhook_logs WHERE received_at = $1 AND email IS NOT DISTINCT FROM $2
+            )
+        `, l.ReceivedAt, l.Email, l.Status, payload, l.State, l.Attempts, l.NextAttemptAt, l.LastError)
+        if err != nil {
+            return fmt.Errorf("failed to restore webhook log: %w", err)
+        }
+        if n, _ := res.RowsAffected(); n > 0 {
+            result.WebhookLogs++
+        } else {
+            result.SkippedRecords++
+        }
+
+    default:
+        result.SkippedRecords++
+    }
+
+    return nil
+}
+
+func runBackup(logger *log.Logger) {
+    backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+    output := backupCmd.String("output", "", "Backup file (default members-<date>-<time>.json.gz; gzipped if it ends in .gz)")
+    webhooks := backupCmd.Bool("webhooks", false, "Include webhook_logs")
+
+    parseSubcommand(backupCmd, "memberships backup [--output file.json.gz] [--webhooks]", os.Args[2:])
+
+    if *output == "" {
+        *output = defaultBackupPath()
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    result, err := db.WriteBackup(*output, *webhooks)
+    if err != nil {
+        logger.Fatalf("Backup failed: %v", err)
+    }
+
+    fmt.Printf("Backed up %d members, %d history rows, %d webhook logs to %s\n",
+        result.Members, result.History, result.WebhookLogs, *output)
+}
+
+func runRestore(logger *log.Logger) {
+    restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+    dryRun := restoreCmd.Bool("dry-run", false, "Show what would be restored without making changes")
+
+    args := parseSubcommand(restoreCmd, "memberships restore <file> [--dry-run]", os.Args[2:])
+
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: restore requires a backup file")
+        restoreCmd.Usage()
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if *dryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+    }
+
+    result, err := db.RestoreBackup(args[0], *dryRun)
+    if err != nil {
+        logger.Fatalf("Restore failed: %v", err)
+    }
+
+    verb := "Restored"
+    if *dryRun {
+        verb = "Would restore"
+    }
+    fmt.Printf("%s %d members (%d new), %d history rows, %d webhook logs; %d records already present\n",
+        verb, result.Members, result.NewMembers, result.History, result.WebhookLogs, result.SkippedRecords)
+    if *dryRun {
+        fmt.Println("DRY RUN complete - no changes made")
+    }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/lib/pq"
+)
+
+// maxBulkStatusEmails caps one bulk status request
+const maxBulkStatusEmails = 1000
+
+// Outcomes of a bulk status change for one email
+const (
+    bulkUpdated   = "updated"
+    bulkUnchanged = "unchanged"
+    bulkNotFound  = "not_found"
+    bulkHousehold = "household"
+)
+
+// BulkStatusResult is what a bulk status change did to one email
+type BulkStatusResult struct {
+    Email          string `json:"email"`
+    Result         string `json:"result"`
+    PreviousStatus string `json:"previous_status,omitempty"`
+}
+
+// SetMemberStatuses moves every listed member to status in one transaction,
+// skipping emails with no member and members already at that status. Unlike
+// BulkUpdateStatus, a missing member isn't an error; each email's outcome is
+// returned in the order given, duplicates dropped. Members whose status
+// follows their household are left alone.
+func (db *Database) SetMemberStatuses(emails []string, status string, change ChangeSource) ([]BulkStatusResult, error) {
+    if !validStatus(status) {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+    }
+
+    var normalized []string
+    seen := make(map[string]bool, len(emails))
+    for _, email := range emails {
+        email = db.NormalizeEmail(email)
+        if !seen[email] {
+            seen[email] = true
+            normalized = append(normalized, email)
+        }
+    }
+
+    var results []BulkStatusResult
+    err := db.inTx(func(tx *sql.Tx) error {
+        rows, err := tx.Query(`
+            SELECT email, status, COALESCE(household_id <> id, false) FROM members WHERE email = ANY($1) FOR UPDATE
+        `, pq.Array(normalized))
+        if err != nil {
+            return fmt.Errorf("failed to load members: %w", err)
+        }
+        current := make(map[string]string, len(normalized))
+        household := make(map[string]bool)
+        for rows.Next() {
+            var email, before string
+            var derived bool
+            if err := rows.Scan(&email, &before, &derived); err != nil {
+                rows.Close()
+                return err
+            }
+            current[email] = before
+            household[email] = derived
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+
+        results = make([]BulkStatusResult, 0, len(normalized))
+        var changing []string
+        for _, email := range normalized {
+            before, ok := current[email]
+            switch {
+            case !ok:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkNotFound})
+            case before == status:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkUnchanged, PreviousStatus: before})
+            case household[email]:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkHousehold, PreviousStatus: before})
+            default:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkUpdated, PreviousStatus: before})
+                changing = append(changing, email)
+            }
+        }
+
+        _, err = db.bulkUpdateStatus(tx, changing, status, change)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return results, nil
+}
+
+// bulkStatusRequest is the body of POST /members/bulk-status
+type bulkStatusRequest struct {
+    Emails []string `json:"emails"`
+    Status string   `json:"status"`
+    Reason string   `json:"reason"`
+}
+
+// bulkStatusResponse counts the outcomes and lists them per email
+type bulkStatusResponse struct {
+    Status    string             `json:"status"`
+    Updated   int                `json:"updated"`
+    Unchanged int                `json:"unchanged"`
+    NotFound  int                `json:"not_found"`
+    Results   []BulkStatusResult `json:"results"`
+}
+
+// bulkStatusHandler sets a batch of members to one status, e.g. suspending
+// everyone caught up in a payment processor incident
+func (s *WebhookServer) bulkStatusHandler(w http.ResponseWriter, r *http.Request) {
+    var req bulkStatusRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid JSON")
+        return
+    }
+
+    req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+    if !validStatus(req.Status) {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, fmt.Sprintf("Unknown status %q", req.Status))
+        return
+    }
+    if len(req.Emails) == 0 {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, "emails must not be empty")
+        return
+    }
+    if len(req.Emails) > maxBulkStatusEmails {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, fmt.Sprintf("At most %d emails per request", maxBulkStatusEmails))
+        return
+    }
+    for _, email := range req.Emails {
+        if err := validateEmail(email); err != nil {
+            writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+            return
+        }
+    }
+
+    change := ChangeSource{Source: "manual", Detail: strings.TrimSpace(req.Reason)}
+    results, err := s.db.SetMemberStatuses(req.Emails, req.Status, change)
+    if err != nil {
+        s.logger.Printf("Error in bulk status update: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    response := bulkStatusResponse{Status: req.Status, Results: results}
+    for _, result := range results {
+        switch result.Result {
+        case bulkUpdated:
+            response.Updated++
+        case bulkUnchanged:
+            response.Unchanged++
+        case bulkNotFound:
+            response.NotFound++
+        }
+    }
+    s.logger.Printf("Bulk status %s by %s: %d updated, %d unchanged, %d not found",
+        req.Status, s.principal(r), response.Updated, response.Unchanged, response.NotFound)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
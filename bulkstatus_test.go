@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "testing"
+)
+
+// benchMembers is how many synthetic members BenchmarkStatusUpdates moves
+const benchMembers = 5000
+
+// BenchmarkStatusUpdates compares updating members one at a time, as clean
+// did before it batched its changes, with BulkUpdateStatus. Each iteration
+// moves every member to cancelled and back. The difference is round trips,
+// so it needs Postgres: it's skipped unless MEMBERSHIPS_BENCH_DATABASE_URL
+// names a migrated database it may write synthetic members to.
+//
+//	MEMBERSHIPS_BENCH_DATABASE_URL=postgres://localhost/memberships_bench go test -run '^$' -bench StatusUpdates
+func BenchmarkStatusUpdates(b *testing.B) {
+    url := os.Getenv("MEMBERSHIPS_BENCH_DATABASE_URL")
+    if url == "" {
+        b.Skip("MEMBERSHIPS_BENCH_DATABASE_URL is not set")
+    }
+    db, err := NewDatabase(url, log.New(io.Discard, "", 0))
+    if err != nil {
+        b.Fatal(err)
+    }
+    defer db.Close()
+
+    removeBenchMembers := func() {
+        _, err := db.Exec(`
+            DELETE FROM events WHERE member_id IN (SELECT id FROM members WHERE email LIKE 'bench-%@bench.example.invalid')
+        `)
+        if err == nil {
+            _, err = db.Exec(`DELETE FROM members WHERE email LIKE 'bench-%@bench.example.invalid'`)
+        }
+        if err != nil {
+            b.Fatal(err)
+        }
+    }
+    removeBenchMembers()
+    defer removeBenchMembers()
+
+    change := ChangeSource{Source: "benchmark"}
+    emails := make([]string, benchMembers)
+    for i := range emails {
+        emails[i] = fmt.Sprintf("bench-%d@bench.example.invalid", i+1)
+        if _, err := db.ProcessMember(emails[i], "", false, StatusActive, change); err != nil {
+            b.Fatal(err)
+        }
+    }
+
+    b.Run("per-member", func(b *testing.B) {
+        for range b.N {
+            for _, status := range []string{StatusCancelled, StatusActive} {
+                for _, email := range emails {
+                    if err := db.UpdateMemberStatus(email, status, change); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            }
+        }
+        b.ReportMetric(float64(b.Elapsed().Microseconds())/float64(2*b.N*len(emails)), "µs/member")
+    })
+
+    b.Run("bulk", func(b *testing.B) {
+        for range b.N {
+            for _, status := range []string{StatusCancelled, StatusActive} {
+                if n, err := db.BulkUpdateStatus(emails, status, change); err != nil || n != len(emails) {
+                    b.Fatalf("updated %d of %d: %v", n, len(emails), err)
+                }
+            }
+        }
+        b.ReportMetric(float64(b.Elapsed().Microseconds())/float64(2*b.N*len(emails)), "µs/member")
+    })
+}
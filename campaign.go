@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strings"
+)
+
+// campaignUnknown stands for members with no recorded campaign, including
+// everyone who joined before campaigns were recorded
+const campaignUnknown = "unknown"
+
+// campaignRetentionDays is the member age campaign retention is measured at
+const campaignRetentionDays = 90
+
+// campaignColumnAliases name the campaign in CSV headers and webhook fields,
+// compared after normalizeHeader
+var campaignColumnAliases = []string{"campaign", "campaign name", "campaign title", "fundraising campaign", "fundraiser"}
+
+// UnmarshalJSON reads a webhook, taking the campaign from any of the names
+// Zapier steps have given it when there's no "campaign" field
+func (w *MemberWebhook) UnmarshalJSON(data []byte) error {
+    type plain MemberWebhook
+    if err := json.Unmarshal(data, (*plain)(w)); err != nil {
+        return err
+    }
+    if w.Campaign != "" {
+        return nil
+    }
+
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return nil
+    }
+    byHeader := make(map[string]json.RawMessage, len(fields))
+    for key, value := range fields {
+        byHeader[normalizeHeader(key)] = value
+    }
+    for _, alias := range campaignColumnAliases {
+        var campaign looseString
+        if value, ok := byHeader[alias]; ok && json.Unmarshal(value, &campaign) == nil && campaign != "" {
+            w.Campaign = string(campaign)
+            return nil
+        }
+    }
+    return nil
+}
+
+// SetMemberCampaign records the campaign a member joined through. Only a
+// member without one takes it, so it stays the campaign of their first
+// record.
+func (db *Database) SetMemberCampaign(email, campaign string) error {
+    return db.setMemberCampaign(db.DB, email, campaign)
+}
+
+func (db *Database) setMemberCampaign(q querier, email, campaign string) error {
+    campaign = strings.TrimSpace(campaign)
+    if campaign == "" {
+        return nil
+    }
+
+    _, err := q.Exec(`
+        UPDATE members SET campaign = $2 WHERE email = $1 AND campaign IS NULL
+    `, db.NormalizeEmail(email), campaign)
+    if err != nil {
+        return fmt.Errorf("failed to set campaign: %w", err)
+    }
+    return nil
+}
+
+// CampaignStats is how the members a campaign brought in have fared
+type CampaignStats struct {
+    Campaign string `json:"campaign"`
+    Members  int    `json:"members"`
+    Active   int    `json:"active"`
+
+    // Of the members who joined at least 90 days ago, how many were active
+    // 90 days in; the rate is null until any have
+    Eligible90Days  int      `json:"eligible_90_days"`
+    Retained90Days  int      `json:"retained_90_days"`
+    Retention90Days *float64 `json:"retention_90_days"`
+}
+
+// GetCampaignStats breaks members down by the campaign they joined
+// through, largest first, with "unknown" for members without one. Status
+// 90 days in is worked out from status_history the way GetRetention does.
+func (db *Database) GetCampaignStats(ctx context.Context) ([]CampaignStats, error) {
+    rows, err := db.QueryContext(ctx, `
+        WITH joined AS (
+            SELECT m.id, m.status, m.first_seen,
+                   COALESCE(NULLIF(m.campaign, ''), $1) AS campaign,
+                   CASE WHEN NOT EXISTS (SELECT 1 FROM status_history h WHERE h.member_id = m.id)
+                        THEN m.status END AS fallback
+            FROM members m
+        )
+        SELECT j.campaign, COUNT(*),
+               COUNT(*) FILTER (WHERE j.status = 'active'),
+               COUNT(*) FILTER (WHERE j.first_seen + $2::int * INTERVAL '1 day' <= CURRENT_TIMESTAMP),
+               COUNT(*) FILTER (WHERE j.first_seen + $2::int * INTERVAL '1 day' <= CURRENT_TIMESTAMP
+                                AND COALESCE(s.status, j.fallback) = 'active')
+        FROM joined j
+        LEFT JOIN LATERAL (
+            SELECT h.status FROM status_history h
+            WHERE h.member_id = j.id AND h.changed_at < j.first_seen + ($2::int + 1) * INTERVAL '1 day'
+            ORDER BY h.changed_at DESC, h.id DESC
+            LIMIT 1
+        ) s ON true
+        GROUP BY j.campaign
+        ORDER BY COUNT(*) DESC, j.campaign
+    `, campaignUnknown, campaignRetentionDays)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get campaign stats: %w", err)
+    }
+    defer rows.Close()
+
+    var campaigns []CampaignStats
+    for rows.Next() {
+        var c CampaignStats
+        if err := rows.Scan(&c.Campaign, &c.Members, &c.Active, &c.Eligible90Days, &c.Retained90Days); err != nil {
+            return nil, err
+        }
+        if c.Eligible90Days > 0 {
+            rate := float64(c.Retained90Days) / float64(c.Eligible90Days)
+            c.Retention90Days = &rate
+        }
+        campaigns = append(campaigns, c)
+    }
+
+    return campaigns, rows.Err()
+}
+
+// printCampaignStats prints the campaign section of memberships stats
+func printCampaignStats(campaigns []CampaignStats) {
+    // Nothing to compare while every member is "unknown"
+    if len(campaigns) == 0 || (len(campaigns) == 1 && campaigns[0].Campaign == campaignUnknown) {
+        return
+    }
+
+    fmt.Println("\n=== Members by Campaign ===")
+    for _, c := range campaigns {
+        retention := "-"
+        if c.Retention90Days != nil {
+            retention = fmt.Sprintf("%.1f%%", *c.Retention90Days*100)
+        }
+        fmt.Printf("%-24s  %d members, %d active, %s active at 90 days\n", c.Campaign+":", c.Members, c.Active, retention)
+    }
+}
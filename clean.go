@@ -0,0 +1,840 @@
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+)
+
+// utf8BOM is written at the start of CSVs saved by Excel
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// defaultMaxDeactivatePercent caps deactivations so a truncated export can't
+// cancel most of the membership
+const defaultMaxDeactivatePercent = 25
+
+func runClean(logger *log.Logger) {
+    // Parse flags for clean subcommand
+    cleanCmd := flag.NewFlagSet("clean", flag.ExitOnError)
+    dryRun := cleanCmd.Bool("dry-run", false, "Show what would change without making changes")
+    verbose := cleanCmd.Bool("verbose", false, "Show detailed output")
+    graceDays := cleanCmd.Int("grace-days", 0, "Only deactivate members whose last payment or update is older than N days")
+    emailColumn := cleanCmd.String("email-column", "", "Header of the email column (overrides detection)")
+    frequencyColumn := cleanCmd.String("frequency-column", "", "Header of the frequency column (overrides detection)")
+    statusColumn := cleanCmd.String("status-column", "", "Header of the payment status column (overrides detection)")
+    dateColumn := cleanCmd.String("date-column", "", "Header of the payment date column (overrides detection)")
+    amountColumn := cleanCmd.String("amount-column", "", "Header of the amount column; with a date column, payments are recorded as donations")
+    campaignColumn := cleanCmd.String("campaign-column", "", "Header of the campaign column (overrides detection)")
+    abortOnError := cleanCmd.Bool("abort-on-error", false, "Abort on the first malformed CSV row instead of skipping it")
+    maxDeactivate := cleanCmd.Int("max-deactivate-percent", defaultMaxDeactivatePercent, "Refuse to deactivate more than this percentage of active members")
+    force := cleanCmd.Bool("force", false, "Apply changes even if they exceed --max-deactivate-percent")
+    progressRows := cleanCmd.Int("progress-every", defaultProgressRows, "Log progress every N rows (and at least every few seconds)")
+    reportFile := cleanCmd.String("report", "", "Write a change report to this file")
+    reportFormat := cleanCmd.String("report-format", "json", "Report format: json or csv")
+    delimiter := cleanCmd.String("delimiter", ",", `Field delimiter: ",", "\t", ";", or "auto" to detect from the header`)
+    
+    noAdd := cleanCmd.Bool("no-add", false, "Report but don't add new members")
+    noReactivate := cleanCmd.Bool("no-reactivate", false, "Report but don't reactivate members")
+    noDeactivate := cleanCmd.Bool("no-deactivate", false, "Report but don't deactivate or suspend members")
+    var fetchHeaders headerFlags
+    cleanCmd.Var(&fetchHeaders, "header", `HTTP header for URL sources, e.g. "Authorization: Bearer ..." (repeatable)`)
+    fetchTimeout := cleanCmd.Duration("fetch-timeout", defaultFetchTimeout, "Timeout for downloading URL sources")
+    maxFetchBytes := cleanCmd.Int64("max-size", defaultMaxFetchBytes, "Maximum download size in bytes for URL sources")
+    history := cleanCmd.Bool("history", false, "List recent sync runs instead of cleaning")
+    autoBackup := cleanCmd.Bool("auto-backup", false, "Write a backup (see memberships backup) before applying changes")
+    review := cleanCmd.Bool("review", false, "Queue changes for approval (see memberships pending) instead of applying them")
+    
+    // Flags may appear before or after the filename
+    args := parseSubcommand(cleanCmd, "memberships clean <csv-file|url|-> [flags]", os.Args[2:])
+    
+    if *history {
+        db := connectDatabase(logger)
+        defer db.Close()
+        printSyncHistory(db, logger)
+        return
+    }
+    
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: clean command requires a CSV filename")
+        cleanCmd.Usage()
+        os.Exit(2)
+    }
+    
+    csvFile := args[0]
+    
+    if *reportFormat != "json" && *reportFormat != "csv" {
+        fmt.Fprintf(os.Stderr, "Error: unsupported report format %q (use json or csv)\n", *reportFormat)
+        os.Exit(2)
+    }
+    
+    comma, err := parseDelimiter(*delimiter)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(2)
+    }
+    
+    config := mustLoadConfig(logger)
+    db := connectDatabase(logger)
+    defer db.Close()
+    
+    // Process the CSV file
+    opts := CleanOptions{
+        DryRun:    *dryRun,
+        Verbose:   *verbose,
+        GraceDays: *graceDays,
+        
+        EmailColumn:     *emailColumn,
+        FrequencyColumn: *frequencyColumn,
+        StatusColumn:    *statusColumn,
+        DateColumn:      *dateColumn,
+        AmountColumn:    *amountColumn,
+        CampaignColumn:  *campaignColumn,
+        
+        AbortOnError:         *abortOnError,
+        MaxDeactivatePercent: *maxDeactivate,
+        Force:                *force,
+        Delimiter:            comma,
+        ProgressRows:         *progressRows,
+        NoAdd:                *noAdd,
+        NoReactivate:         *noReactivate,
+        NoDeactivate:         *noDeactivate,
+        FetchHeaders:         fetchHeaders,
+        FetchTimeout:         *fetchTimeout,
+        MaxFetchBytes:        *maxFetchBytes,
+        AutoBackup:           *autoBackup,
+        Review:               *review,
+        ReviewTTL:            config.PendingChangeTTL,
+        StatusRules:          config.StatusRules,
+        Logger:               logger,
+    }
+    
+    report, cleanErr := cleanDatabase(db, csvFile, opts)
+    
+    // Write the report even when clean refused to apply, so it can be reviewed
+    if *reportFile != "" && report != nil {
+        if err := report.WriteFile(*reportFile, *reportFormat); err != nil {
+            logger.Printf("Failed to write report: %v", err)
+        } else {
+            logger.Printf("Wrote %s report to %s", *reportFormat, *reportFile)
+        }
+    }
+    
+    if cleanErr != nil {
+        logger.Fatalf("Clean failed: %v", cleanErr)
+    }
+}
+
+// CleanOptions controls how cleanDatabase reconciles the CSV with the database
+type CleanOptions struct {
+    DryRun  bool
+    Verbose bool
+    
+    // Column overrides; empty means detect from the known aliases
+    EmailColumn     string
+    FrequencyColumn string
+    StatusColumn    string
+    DateColumn      string
+    AmountColumn    string
+    CampaignColumn  string
+    
+    // GraceDays skips deactivating members whose last payment or update is
+    // more recent than this many days
+    GraceDays int
+    
+    // AbortOnError stops at the first malformed row instead of skipping it
+    AbortOnError bool
+    
+    // MaxDeactivatePercent refuses runs that would deactivate more than this
+    // share of active members unless Force is set
+    MaxDeactivatePercent int
+    Force                bool
+    
+    // Suppress whole change categories while still reporting them
+    NoAdd        bool
+    NoReactivate bool
+    NoDeactivate bool
+    
+    // Options for fetching http(s) sources
+    FetchHeaders  []string
+    FetchTimeout  time.Duration
+    MaxFetchBytes int64
+    
+    // Delimiter separates fields; zero means sniff it from the header line
+    Delimiter rune
+    
+    // ProgressRows is how often, in rows, to log progress
+    ProgressRows int
+    
+    // AutoBackup writes a backup before any change is applied
+    AutoBackup bool
+    
+    // Review queues status changes as pending changes for an admin to
+    // approve instead of applying them; they expire after ReviewTTL
+    Review    bool
+    ReviewTTL time.Duration
+    
+    // StatusRules map payment statuses as for webhooks; empty means the
+    // defaults
+    StatusRules []StatusRule
+    
+    // Logger is where clean and the reconciler log; nil means the standard
+    // logger
+    Logger *log.Logger
+}
+
+// logger returns opts.Logger, or the standard logger if it's unset
+func (opts CleanOptions) logger() *log.Logger {
+    if opts.Logger == nil {
+        return log.Default()
+    }
+    return opts.Logger
+}
+
+// defaultProgressRows is how often clean logs progress on large files
+const defaultProgressRows = 10000
+
+// progressInterval forces a progress line on slow inputs even between row marks
+const progressInterval = 5 * time.Second
+
+// countingReader tracks how many bytes have been read, for ETA estimates
+type countingReader struct {
+    r io.Reader
+    n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    c.n += int64(n)
+    return n, err
+}
+
+// progress decides when to log progress, every N items or progressInterval
+type progress struct {
+    every   int
+    started time.Time
+    lastLog time.Time
+}
+
+func newProgress(every int) *progress {
+    if every <= 0 {
+        every = defaultProgressRows
+    }
+    now := time.Now()
+    return &progress{every: every, started: now, lastLog: now}
+}
+
+// due reports whether a progress line should be logged after count items
+func (p *progress) due(count int) bool {
+    now := time.Now()
+    if count%p.every == 0 || now.Sub(p.lastLog) >= progressInterval {
+        p.lastLog = now
+        return true
+    }
+    return false
+}
+
+// eta formats the estimated time remaining from bytes read so far, or
+// nothing when the total size is unknown
+func (p *progress) eta(read, total int64) string {
+    if total <= 0 || read <= 0 {
+        return ""
+    }
+    
+    elapsed := time.Since(p.started)
+    remaining := time.Duration(float64(elapsed) * float64(total-read) / float64(read))
+    return fmt.Sprintf(" (%.0f%%, ETA %v)", float64(read)*100/float64(total), remaining.Round(time.Second))
+}
+
+// cleanDatabase reconciles the database with a GiveLively CSV export
+func cleanDatabase(db Store, csvFile string, opts CleanOptions) (*CleanReport, error) {
+    logger := opts.logger()
+    if opts.ProgressRows <= 0 {
+        opts.ProgressRows = defaultProgressRows
+    }
+    
+    if opts.DryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+    } else if opts.Review {
+        logger.Println("REVIEW MODE - Changes will be queued for approval")
+    }
+    
+    source, err := readCSVSource(db, csvFile, opts)
+    if err != nil {
+        return nil, err
+    }
+    
+    return NewReconciler(db, opts).Run(source)
+}
+
+// readCSVSource reads a GiveLively export, a local path, "-" for stdin, or
+// an http(s) URL, into the recurring members it reports as active or failed
+func readCSVSource(db Store, csvFile string, opts CleanOptions) (*MemberSource, error) {
+    logger := opts.logger()
+    logger.Printf("Processing CSV file: %s", csvFile)
+    
+    var err error
+    
+    // Download URLs completely first so a network failure aborts before
+    // anything is parsed or changed
+    path := csvFile
+    if isURL(csvFile) {
+        tmpPath, cleanup, err := fetchToTempFile(csvFile, opts.FetchHeaders, opts.FetchTimeout, opts.MaxFetchBytes, logger)
+        if err != nil {
+            return nil, err
+        }
+        defer cleanup()
+        path = tmpPath
+    }
+    
+    // Open CSV file, or read stdin for "-" so exports can be piped in
+    var file *os.File
+    if csvFile == "-" {
+        file = os.Stdin
+    } else {
+        file, err = os.Open(path)
+        if err != nil {
+            return nil, fmt.Errorf("failed to open CSV file: %w", err)
+        }
+        defer file.Close()
+    }
+    
+    // Total size drives the ETA; stdin and pipes report no useful size
+    var totalBytes int64
+    if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+        totalBytes = info.Size()
+    }
+    counter := &countingReader{r: file}
+    
+    // Strip the byte order mark Excel adds, or the first header won't match
+    buffered := bufio.NewReaderSize(counter, sniffBufferSize)
+    if prefix, err := buffered.Peek(len(utf8BOM)); err == nil && string(prefix) == string(utf8BOM) {
+        buffered.Discard(len(utf8BOM))
+    }
+    
+    comma := opts.Delimiter
+    if comma == 0 {
+        comma = sniffDelimiter(buffered)
+        logger.Printf("Detected delimiter: %s", delimiterName(comma))
+    }
+    
+    // Parse CSV, tolerating stray quotes and ragged rows
+    reader := csv.NewReader(buffered)
+    reader.Comma = comma
+    reader.LazyQuotes = true
+    reader.FieldsPerRecord = -1
+    
+    // Read header row
+    headers, err := reader.Read()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read CSV headers: %w", err)
+    }
+    
+    // Find column indices we care about
+    cols, err := findCSVColumns(headers, opts)
+    if err != nil {
+        return nil, err
+    }
+    
+    emailIdx := cols.email
+    frequencyIdx := cols.frequency
+    statusIdx := cols.status
+    dateIdx := cols.date
+    amountIdx := cols.amount
+    
+    // Track active recurring members from CSV
+    activeMembers := make(map[string]bool)
+    
+    // Recurring members whose payment failed without an explicit cancellation
+    failedMembers := make(map[string]bool)
+    
+    // Latest payment date per active member, when the CSV has a date column
+    paymentDates := make(map[string]time.Time)
+    
+    // Recurring frequency per active member, used for lapse detection
+    frequencies := make(map[string]string)
+    
+    // Campaign of each active member's earliest dated row, for new members
+    campaigns := make(map[string]string)
+    campaignDates := make(map[string]time.Time)
+    
+    // Individual payments, when the CSV has amount and date columns
+    var donations []Donation
+    
+    // Statuses and frequencies nobody has mapped, with how often each was
+    // seen, and the members whose rows were skipped for them
+    unmapped := map[string]map[string]int{unmappedKindStatus: {}, unmappedKindFrequency: {}}
+    unmappedMembers := make(map[string]bool)
+    unmappedRows := 0
+    
+    // Status mappings, looked up once per distinct status
+    rules := opts.StatusRules
+    if len(rules) == 0 {
+        rules = defaultStatusRules
+    }
+    statuses := make(map[string]string)
+    
+    // Process each row
+    rowCount := 0
+    recurringCount := 0
+    invalidCount := 0
+    
+    parseErrors := 0
+    
+    progress := newProgress(opts.ProgressRows)
+    
+    for {
+        row, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            // A malformed row must never silently end processing early, or
+            // every member after it would look like they left
+            var parseErr *csv.ParseError
+            if !errors.As(err, &parseErr) {
+                return nil, fmt.Errorf("failed to read CSV: %w", err)
+            }
+            if opts.AbortOnError {
+                return nil, fmt.Errorf("malformed CSV at line %d: %w", parseErr.StartLine, err)
+            }
+            parseErrors++
+            logger.Printf("Skipping malformed row at line %d: %v", parseErr.StartLine, parseErr.Err)
+            continue
+        }
+        
+        rowCount++
+        
+        if progress.due(rowCount) {
+            logger.Printf("Progress: %d rows, %d active members%s",
+                rowCount, recurringCount, progress.eta(counter.n, totalBytes))
+        }
+        
+        // Skip if not enough columns
+        if len(row) <= emailIdx {
+            continue
+        }
+        
+        email := db.NormalizeEmail(row[emailIdx])
+        if email == "" {
+            continue
+        }
+        
+        if err := validateEmail(row[emailIdx]); err != nil {
+            invalidCount++
+            if opts.Verbose {
+                logger.Printf("Skipping row %d: %v", rowCount, err)
+            }
+            continue
+        }
+        
+        // Check if this is a recurring donation
+        frequency := ""
+        if frequencyIdx >= 0 && frequencyIdx < len(row) {
+            frequency = row[frequencyIdx]
+        }
+        
+        // Only process recurring donations (Monthly, Quarterly, Annual, etc.)
+        if frequency == "" || isOneTimeFrequency(frequency) {
+            if opts.Verbose {
+                logger.Printf("Skipping one-time donation from %s", email)
+            }
+            continue
+        }
+        
+        // A value nobody has mapped is held for review rather than guessed
+        if !knownFrequency(frequency) {
+            unmapped[unmappedKindFrequency][unmappedKey(frequency)]++
+            unmappedMembers[email] = true
+            unmappedRows++
+            continue
+        }
+        
+        // Check payment status
+        status := "active"
+        if statusIdx >= 0 && statusIdx < len(row) && unmappedKey(row[statusIdx]) != "" {
+            key := unmappedKey(row[statusIdx])
+            mapped, seen := statuses[key]
+            if !seen {
+                mapped = csvPaymentStatus(db, rules, row[statusIdx], logger)
+                statuses[key] = mapped
+            }
+            if mapped == "" {
+                unmapped[unmappedKindStatus][key]++
+                unmappedMembers[email] = true
+                unmappedRows++
+                continue
+            }
+            status = mapped
+        }
+        
+        if status == "suspended" {
+            failedMembers[email] = true
+            if opts.Verbose {
+                logger.Printf("Found failed recurring payment: %s (%s)", email, frequency)
+            }
+        }
+        
+        // Only track active recurring members
+        if status == "active" && frequency != "" {
+            activeMembers[email] = true
+            frequencies[email] = frequency
+            recurringCount++
+            
+            paidAt, dated := time.Time{}, false
+            if dateIdx >= 0 && dateIdx < len(row) {
+                if paidAt, dated = parseCSVDate(row[dateIdx]); dated && paidAt.After(paymentDates[email]) {
+                    paymentDates[email] = paidAt
+                }
+            }
+            
+            if cols.campaign >= 0 && cols.campaign < len(row) {
+                if campaign := strings.TrimSpace(row[cols.campaign]); campaign != "" {
+                    first, seen := campaignDates[email]
+                    switch {
+                    case dated && (!seen || paidAt.Before(first)):
+                        campaigns[email], campaignDates[email] = campaign, paidAt
+                    case campaigns[email] == "":
+                        campaigns[email] = campaign
+                    }
+                }
+            }
+            
+            if donation, ok := csvDonation(row, cols, email, frequency); ok {
+                donations = append(donations, donation)
+            } else if amountIdx >= 0 && opts.Verbose {
+                logger.Printf("Not recording a donation for row %d: missing or invalid amount or date", rowCount)
+            }
+            
+            if opts.Verbose {
+                logger.Printf("Found active recurring member: %s (%s)", email, frequency)
+            }
+        }
+    }
+    
+    // A successful payment anywhere in the export outweighs a failed one,
+    // or one nobody has mapped
+    for email := range activeMembers {
+        delete(failedMembers, email)
+        delete(unmappedMembers, email)
+    }
+    
+    for kind, values := range unmapped {
+        for value, seen := range values {
+            logger.Printf("WARNING: %d rows have the unrecognized %s %q; see memberships statuses list", seen, kind, value)
+            if err := db.RecordUnmappedValue(kind, value, seen); err != nil {
+                logger.Printf("Warning: %v", err)
+            }
+        }
+    }
+    
+    logger.Printf("Processed %d rows, found %d active recurring members", rowCount, recurringCount)
+    if len(failedMembers) > 0 {
+        logger.Printf("Found %d recurring members with failed payments", len(failedMembers))
+    }
+    if invalidCount > 0 {
+        logger.Printf("Skipped %d rows with invalid email addresses", invalidCount)
+    }
+    if unmappedRows > 0 {
+        logger.Printf("Skipped %d rows with unrecognized statuses or frequencies; %d members they cover are left as they are",
+            unmappedRows, len(unmappedMembers))
+    }
+    if parseErrors > 0 {
+        logger.Printf("Skipped %d malformed rows", parseErrors)
+    }
+    
+    return &MemberSource{
+        Name:          csvFile,
+        Active:        activeMembers,
+        Failed:        failedMembers,
+        PaymentDates:  paymentDates,
+        Frequencies:   frequencies,
+        Campaigns:     campaigns,
+        Donations:     donations,
+        RowsProcessed: rowCount,
+        Unmapped:      unmappedMembers,
+        RowsSkipped:   invalidCount + parseErrors + unmappedRows,
+    }, nil
+}
+
+// csvPaymentStatus maps a payment status to a member status with the same
+// rules as webhooks, then any mapping made with "memberships statuses map".
+// A failed charge suspends the member. It returns "" for a status neither
+// covers.
+func csvPaymentStatus(db Store, rules []StatusRule, paymentStatus string, logger *log.Logger) string {
+    status := ""
+    if rule, ok := matchStatusRule(rules, paymentStatus); ok {
+        status = rule.Status
+    } else if mapped, err := db.StatusMapping(paymentStatus); err != nil {
+        logger.Printf("Warning: %v", err)
+    } else {
+        status = mapped
+    }
+    
+    if status == statusFailed {
+        return StatusSuspended
+    }
+    return status
+}
+
+// suppressedNote marks a summary line whose category won't be applied, so a
+// reviewer doesn't read the planned count as what happened
+func suppressedNote(suppressed bool, flagName string) string {
+    if suppressed {
+        return " (SUPPRESSED by " + flagName + ", will not be applied)"
+    }
+    return ""
+}
+
+// suppressedCategories lists the report categories disabled by flags
+func suppressedCategories(opts CleanOptions) []string {
+    suppressed := []string{}
+    if opts.NoAdd {
+        suppressed = append(suppressed, "added")
+    }
+    if opts.NoReactivate {
+        suppressed = append(suppressed, "reactivated")
+    }
+    if opts.NoDeactivate {
+        suppressed = append(suppressed, "deactivated", "suspended")
+    }
+    return suppressed
+}
+
+// sniffBufferSize bounds how much of the file delimiter detection looks at
+const sniffBufferSize = 64 * 1024
+
+// delimiterCandidates are the separators clean understands
+var delimiterCandidates = []rune{',', '\t', ';'}
+
+// parseDelimiter converts the --delimiter flag value; "auto" returns zero
+func parseDelimiter(value string) (rune, error) {
+    switch value {
+    case ",", "comma":
+        return ',', nil
+    case "\t", "\\t", "tab":
+        return '\t', nil
+    case ";", "semicolon":
+        return ';', nil
+    case "auto":
+        return 0, nil
+    }
+    
+    return 0, fmt.Errorf(`unsupported delimiter %q (use ",", "\t", ";", or "auto")`, value)
+}
+
+// delimiterName describes a delimiter for log output
+func delimiterName(comma rune) string {
+    switch comma {
+    case '\t':
+        return "tab"
+    case ';':
+        return "semicolon"
+    }
+    return "comma"
+}
+
+// sniffDelimiter picks the candidate that splits the header line into the
+// most fields, ignoring separators inside quotes. It falls back to comma.
+func sniffDelimiter(r *bufio.Reader) rune {
+    peeked, _ := r.Peek(sniffBufferSize)
+    
+    line := string(peeked)
+    if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+        line = line[:i]
+    }
+    
+    counts := make(map[rune]int)
+    inQuotes := false
+    for _, c := range line {
+        if c == '"' {
+            inQuotes = !inQuotes
+            continue
+        }
+        if !inQuotes {
+            counts[c]++
+        }
+    }
+    
+    best := ','
+    for _, candidate := range delimiterCandidates {
+        if counts[candidate] > counts[best] {
+            best = candidate
+        }
+    }
+    
+    return best
+}
+
+// Known header aliases, compared after normalizeHeader. GiveLively has renamed
+// its export columns before, so match loosely rather than exactly.
+var (
+    emailColumnAliases     = []string{"email", "donor email", "email address", "donor email address"}
+    frequencyColumnAliases = []string{"frequency", "donation frequency", "recurring frequency", "recurrence"}
+    statusColumnAliases    = []string{"payment status", "status", "transaction status", "donation status"}
+    dateColumnAliases      = []string{"date", "payment date", "donation date", "transaction date"}
+    amountColumnAliases    = []string{"amount", "donation amount", "payment amount", "gift amount", "total amount"}
+    currencyColumnAliases  = []string{"currency", "donation currency"}
+    donationIDAliases      = []string{"transaction id", "donation id", "payment id", "charge id"}
+)
+
+// campaignColumnAliases are in campaign.go, shared with webhook fields
+
+// csvColumns holds the detected column indices, -1 when absent
+type csvColumns struct {
+    email      int
+    frequency  int
+    status     int
+    date       int
+    amount     int
+    currency   int
+    donationID int
+    campaign   int
+}
+
+// normalizeHeader lowercases a header and folds underscores, dashes, and
+// repeated whitespace into single spaces
+func normalizeHeader(header string) string {
+    header = strings.ToLower(header)
+    header = strings.NewReplacer("_", " ", "-", " ").Replace(header)
+    return strings.Join(strings.Fields(header), " ")
+}
+
+// findColumn returns the index of the override header if given, otherwise of
+// the first header matching any alias
+func findColumn(headers []string, override string, aliases []string) int {
+    candidates := aliases
+    if override != "" {
+        candidates = []string{override}
+    }
+    
+    for _, candidate := range candidates {
+        candidate = normalizeHeader(candidate)
+        for i, header := range headers {
+            if normalizeHeader(header) == candidate {
+                return i
+            }
+        }
+    }
+    
+    return -1
+}
+
+// findCSVColumns locates the columns clean needs, warning about the
+// assumptions it makes when optional columns are missing
+func findCSVColumns(headers []string, opts CleanOptions) (csvColumns, error) {
+    logger := opts.logger()
+    cols := csvColumns{
+        email:      findColumn(headers, opts.EmailColumn, emailColumnAliases),
+        frequency:  findColumn(headers, opts.FrequencyColumn, frequencyColumnAliases),
+        status:     findColumn(headers, opts.StatusColumn, statusColumnAliases),
+        date:       findColumn(headers, opts.DateColumn, dateColumnAliases),
+        amount:     findColumn(headers, opts.AmountColumn, amountColumnAliases),
+        currency:   findColumn(headers, "", currencyColumnAliases),
+        donationID: findColumn(headers, "", donationIDAliases),
+        campaign:   findColumn(headers, opts.CampaignColumn, campaignColumnAliases),
+    }
+    
+    if cols.email == -1 {
+        if opts.EmailColumn != "" {
+            return cols, fmt.Errorf("CSV has no %q column (headers: %v)", opts.EmailColumn, headers)
+        }
+        return cols, fmt.Errorf("CSV missing required email column (headers: %v); use --email-column", headers)
+    }
+    
+    if opts.FrequencyColumn != "" && cols.frequency == -1 {
+        return cols, fmt.Errorf("CSV has no %q column (headers: %v)", opts.FrequencyColumn, headers)
+    }
+    if opts.StatusColumn != "" && cols.status == -1 {
+        return cols, fmt.Errorf("CSV has no %q column (headers: %v)", opts.StatusColumn, headers)
+    }
+    if opts.DateColumn != "" && cols.date == -1 {
+        return cols, fmt.Errorf("CSV has no %q column (headers: %v)", opts.DateColumn, headers)
+    }
+    if opts.AmountColumn != "" && cols.amount == -1 {
+        return cols, fmt.Errorf("CSV has no %q column (headers: %v)", opts.AmountColumn, headers)
+    }
+    if opts.CampaignColumn != "" && cols.campaign == -1 {
+        return cols, fmt.Errorf("CSV has no %q column (headers: %v)", opts.CampaignColumn, headers)
+    }
+    
+    if cols.frequency == -1 {
+        logger.Println("WARNING: no frequency column found; every row will be treated as a one-time donation and skipped. Use --frequency-column to set it.")
+    }
+    if cols.status == -1 {
+        logger.Println("WARNING: no payment status column found; every recurring row will be treated as active. Use --status-column to set it.")
+    }
+    
+    if opts.Verbose {
+        logger.Printf("Columns: email=%d frequency=%d status=%d date=%d amount=%d campaign=%d",
+            cols.email, cols.frequency, cols.status, cols.date, cols.amount, cols.campaign)
+    }
+    
+    return cols, nil
+}
+
+// csvDonation reads the payment on a row, if the CSV has amount and date
+// columns and both parse
+func csvDonation(row []string, cols csvColumns, email, frequency string) (Donation, bool) {
+    cell := func(idx int) string {
+        if idx < 0 || idx >= len(row) {
+            return ""
+        }
+        return strings.TrimSpace(row[idx])
+    }
+    
+    cents, err := parseAmount(cell(cols.amount))
+    if err != nil {
+        return Donation{}, false
+    }
+    occurredAt, ok := parseCSVDate(cell(cols.date))
+    if !ok {
+        return Donation{}, false
+    }
+    
+    return Donation{
+        Email:       email,
+        AmountCents: cents,
+        Currency:    cell(cols.currency),
+        Frequency:   frequency,
+        OccurredAt:  occurredAt,
+        Source:      "clean",
+        ExternalID:  cell(cols.donationID),
+        Campaign:    cell(cols.campaign),
+    }, true
+}
+
+// csvDateLayouts are the date formats seen in GiveLively and spreadsheet exports
+var csvDateLayouts = []string{
+    time.RFC3339,
+    "2006-01-02 15:04:05",
+    "2006-01-02T15:04:05",
+    "2006-01-02",
+    "01/02/2006 15:04",
+    "1/2/2006 15:04",
+    "01/02/2006",
+    "1/2/2006",
+}
+
+// parseCSVDate parses a payment date cell in any of the known layouts. Dates
+// without a zone are read in the display zone, where the export was made.
+func parseCSVDate(value string) (time.Time, bool) {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return time.Time{}, false
+    }
+    
+    for _, layout := range csvDateLayouts {
+        if t, err := time.ParseInLocation(layout, value, displayZone); err == nil {
+            return t, true
+        }
+    }
+    
+    return time.Time{}, false
+}
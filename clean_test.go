@@ -0,0 +1,352 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/csv"
+    "log"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// writeCSV writes a CSV export to a temporary file and returns its path
+func writeCSV(t *testing.T, content string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "export.csv")
+    if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// seedMembers creates members with the given statuses
+func seedMembers(t *testing.T, db *memStore, statuses map[string]string) {
+    t.Helper()
+    for email, status := range statuses {
+        if _, err := db.ProcessMember(email, "", false, status, ChangeSource{Source: "manual"}); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// statusesOf returns each member's status
+func statusesOf(t *testing.T, db *memStore) map[string]string {
+    t.Helper()
+    statuses, err := db.GetAllMemberStatuses()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return statuses
+}
+
+const cleanExport = `Donor Email,Frequency,Payment Status,Date,Amount
+new@example.org,Monthly,Succeeded,2026-03-01,10.00
+returning@example.org,Annual,Succeeded,2026-03-01,120.00
+steady@example.org,Monthly,Succeeded,2026-03-01,10.00
+failing@example.org,Monthly,Failed,2026-03-01,10.00
+once@example.org,One-time,Succeeded,2026-03-01,50.00
+`
+
+// cleanMembers is the database cleanExport is reconciled against
+var cleanMembers = map[string]string{
+    "returning@example.org": StatusCancelled,
+    "steady@example.org":    StatusActive,
+    "failing@example.org":   StatusActive,
+    "gone@example.org":      StatusActive,
+    "board@example.org":     StatusActive,
+}
+
+func TestCleanAppliesChanges(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, cleanMembers)
+    if err := db.AddMemberTag("board@example.org", ProtectedTag); err != nil {
+        t.Fatal(err)
+    }
+
+    report, err := cleanDatabase(db, writeCSV(t, cleanExport), CleanOptions{MaxDeactivatePercent: 100})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !reflect.DeepEqual(report.Added, []string{"new@example.org"}) {
+        t.Errorf("added = %v", report.Added)
+    }
+    if !reflect.DeepEqual(report.Reactivated, []string{"returning@example.org"}) {
+        t.Errorf("reactivated = %v", report.Reactivated)
+    }
+    if !reflect.DeepEqual(report.Deactivated, []string{"gone@example.org"}) {
+        t.Errorf("deactivated = %v", report.Deactivated)
+    }
+    if !reflect.DeepEqual(report.Suspended, []string{"failing@example.org"}) {
+        t.Errorf("suspended = %v", report.Suspended)
+    }
+    if !reflect.DeepEqual(report.ProtectedSkipped, []string{"board@example.org"}) {
+        t.Errorf("protected skipped = %v", report.ProtectedSkipped)
+    }
+    if report.SyncRunID == 0 {
+        t.Error("no sync run was recorded")
+    }
+
+    want := map[string]string{
+        "new@example.org":       StatusActive,
+        "returning@example.org": StatusActive,
+        "steady@example.org":    StatusActive,
+        "failing@example.org":   StatusSuspended,
+        "gone@example.org":      StatusCancelled,
+        "board@example.org":     StatusActive,
+    }
+    if got := statusesOf(t, db); !reflect.DeepEqual(got, want) {
+        t.Errorf("statuses = %v, want %v", got, want)
+    }
+
+    donations, _ := db.GetDonations("returning@example.org", 10)
+    if len(donations) != 1 || donations[0].AmountCents != 12000 {
+        t.Errorf("donations = %+v, want one of 120.00", donations)
+    }
+    if applied, _ := db.SyncRunApplied(report.InputFile); !applied {
+        t.Error("the export isn't recorded as applied")
+    }
+}
+
+func TestCleanDryRunChangesNothing(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, cleanMembers)
+
+    report, err := cleanDatabase(db, writeCSV(t, cleanExport), CleanOptions{DryRun: true, MaxDeactivatePercent: 100})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(report.Added) != 1 || len(report.Deactivated) != 2 {
+        t.Errorf("dry run planned %v added and %v deactivated", report.Added, report.Deactivated)
+    }
+    if got := statusesOf(t, db); !reflect.DeepEqual(got, cleanMembers) {
+        t.Errorf("dry run changed statuses to %v", got)
+    }
+    if len(db.syncRuns) != 0 {
+        t.Errorf("dry run recorded %d sync runs", len(db.syncRuns))
+    }
+}
+
+func TestCleanRefusesMassDeactivation(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, map[string]string{
+        "a@example.org":      StatusActive,
+        "b@example.org":      StatusActive,
+        "c@example.org":      StatusActive,
+        "steady@example.org": StatusActive,
+    })
+    export := writeCSV(t, "Email,Frequency,Status\nsteady@example.org,Monthly,Succeeded\n")
+
+    _, err := cleanDatabase(db, export, CleanOptions{MaxDeactivatePercent: 50})
+    if err == nil {
+        t.Fatal("deactivating 3 of 4 members wasn't refused")
+    }
+    if got := statusesOf(t, db); got["a@example.org"] != StatusActive {
+        t.Errorf("a refused run changed statuses: %v", got)
+    }
+
+    if _, err := cleanDatabase(db, export, CleanOptions{MaxDeactivatePercent: 50, Force: true}); err != nil {
+        t.Fatalf("forced run: %v", err)
+    }
+    if got := statusesOf(t, db); got["a@example.org"] != StatusCancelled {
+        t.Errorf("a forced run left statuses as %v", got)
+    }
+}
+
+func TestCleanSuppressesCategories(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, cleanMembers)
+
+    report, err := cleanDatabase(db, writeCSV(t, cleanExport), CleanOptions{MaxDeactivatePercent: 100, NoAdd: true, NoDeactivate: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(report.Added) != 1 || len(report.Deactivated) != 2 {
+        t.Errorf("suppressed categories should still be reported, got %v and %v", report.Added, report.Deactivated)
+    }
+
+    got := statusesOf(t, db)
+    if _, added := got["new@example.org"]; added {
+        t.Error("--no-add added a member")
+    }
+    if got["gone@example.org"] != StatusActive || got["failing@example.org"] != StatusActive {
+        t.Errorf("--no-deactivate changed statuses: %v", got)
+    }
+    if got["returning@example.org"] != StatusActive {
+        t.Error("reactivation was suppressed too")
+    }
+}
+
+func TestCleanReviewQueuesChanges(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, cleanMembers)
+
+    report, err := cleanDatabase(db, writeCSV(t, cleanExport), CleanOptions{MaxDeactivatePercent: 100, Review: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := statusesOf(t, db); !reflect.DeepEqual(got, cleanMembers) {
+        t.Errorf("review mode changed statuses to %v", got)
+    }
+
+    pending, _ := db.GetPendingChanges(pendingStatePending, 100)
+    if report.PendingChanges != len(pending) || len(pending) != 5 {
+        t.Fatalf("queued %d changes (reported %d), want 5", len(pending), report.PendingChanges)
+    }
+
+    result, err := db.ApprovePendingChanges(nil, "admin", noDeactivateLimit)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(result.Approved) != 5 {
+        t.Errorf("approved %d changes, want 5", len(result.Approved))
+    }
+    if got := statusesOf(t, db); got["gone@example.org"] != StatusCancelled || got["new@example.org"] != StatusActive {
+        t.Errorf("approving left statuses as %v", got)
+    }
+}
+
+func TestCleanReviewRefusesMassDeactivation(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, map[string]string{
+        "a@example.org":      StatusActive,
+        "b@example.org":      StatusActive,
+        "c@example.org":      StatusActive,
+        "steady@example.org": StatusActive,
+    })
+    export := writeCSV(t, "Email,Frequency,Status\nsteady@example.org,Monthly,Succeeded\n")
+
+    if _, err := cleanDatabase(db, export, CleanOptions{MaxDeactivatePercent: 50, Review: true}); err == nil {
+        t.Fatal("queueing 3 of 4 members for deactivation wasn't refused")
+    }
+    if pending, _ := db.GetPendingChanges(pendingStatePending, 100); len(pending) != 0 {
+        t.Errorf("a refused review run queued %d changes", len(pending))
+    }
+
+    report, err := cleanDatabase(db, export, CleanOptions{MaxDeactivatePercent: 50, Review: true, Force: true})
+    if err != nil {
+        t.Fatalf("forced review run: %v", err)
+    }
+    if report.PendingChanges != 3 {
+        t.Errorf("forced review run queued %d changes, want 3", report.PendingChanges)
+    }
+}
+
+func TestParseDelimiter(t *testing.T) {
+    tests := map[string]rune{",": ',', "comma": ',', "\t": '\t', `\t`: '\t', "tab": '\t', ";": ';', "semicolon": ';', "auto": 0}
+    for value, want := range tests {
+        if got, err := parseDelimiter(value); err != nil || got != want {
+            t.Errorf("parseDelimiter(%q) = %q, %v; want %q", value, got, err, want)
+        }
+    }
+    for _, value := range []string{"", "|", ",;"} {
+        if _, err := parseDelimiter(value); err == nil {
+            t.Errorf("parseDelimiter(%q) was accepted", value)
+        }
+    }
+}
+
+func TestSniffDelimiter(t *testing.T) {
+    tests := []struct {
+        content string
+        want    rune
+    }{
+        {"Donor Email,Frequency,Payment Status\na@example.org,Monthly,Succeeded\n", ','},
+        {"Donor Email\tFrequency\tPayment Status\na@example.org\tMonthly\tSucceeded\n", '\t'},
+        {"Donor Email;Frequency;Payment Status\r\na@example.org;Monthly;Succeeded\r\n", ';'},
+
+        // Only the header line counts, so decimal commas in rows don't sway it
+        {"Donor Email;Amount\na@example.org;10,00\nb@example.org;1.234,50\n", ';'},
+
+        // Commas inside quoted headers aren't separators
+        {`"Donor Email";"Frequency";"Payment Status, as of export, final"` + "\n", ';'},
+
+        // Nothing to go on means comma
+        {"Donor Email\n", ','},
+        {"", ','},
+    }
+    for _, tt := range tests {
+        if got := sniffDelimiter(bufio.NewReader(strings.NewReader(tt.content))); got != tt.want {
+            t.Errorf("sniffDelimiter(%q) = %q, want %q", tt.content, got, tt.want)
+        }
+    }
+}
+
+func TestCleanDelimiters(t *testing.T) {
+    for _, delimiter := range []rune{',', '\t', ';'} {
+        export := strings.ReplaceAll(cleanExport, ",", string(delimiter))
+        for _, opt := range []rune{delimiter, 0} {
+            db := newMemStore()
+            seedMembers(t, db, cleanMembers)
+            report, err := cleanDatabase(db, writeCSV(t, export), CleanOptions{Delimiter: opt, MaxDeactivatePercent: 100})
+            if err != nil {
+                t.Errorf("%s file, --delimiter %q: %v", delimiterName(delimiter), opt, err)
+                continue
+            }
+            if !reflect.DeepEqual(report.Added, []string{"new@example.org"}) || !reflect.DeepEqual(report.Suspended, []string{"failing@example.org"}) {
+                t.Errorf("%s file, --delimiter %q: added %v, suspended %v", delimiterName(delimiter), opt, report.Added, report.Suspended)
+            }
+        }
+    }
+}
+
+// A semicolon export whose quoted header has more commas than semicolons:
+// a naive count guesses comma and splits the last column in three
+func TestCleanSniffsQuotedHeader(t *testing.T) {
+    export := `"Donor Email";"Frequency";"Payment Status, as of export, final"` + "\n" +
+        `"new@example.org";"Monthly";"Succeeded"` + "\n"
+
+    reader := csv.NewReader(strings.NewReader(export))
+    reader.LazyQuotes = true
+    if header, _ := reader.Read(); len(header) == 3 {
+        t.Fatalf("read as comma-separated, the header still has 3 columns: %q", header)
+    }
+
+    reader = csv.NewReader(strings.NewReader(export))
+    reader.LazyQuotes = true
+    reader.Comma = sniffDelimiter(bufio.NewReader(strings.NewReader(export)))
+    if header, err := reader.Read(); err != nil || len(header) != 3 || header[2] != "Payment Status, as of export, final" {
+        t.Fatalf("read with the sniffed delimiter: %q, %v", header, err)
+    }
+
+    db := newMemStore()
+    report, err := cleanDatabase(db, writeCSV(t, export), CleanOptions{MaxDeactivatePercent: 100, StatusColumn: "Payment Status, as of export, final"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(report.Added, []string{"new@example.org"}) {
+        t.Errorf("added = %v", report.Added)
+    }
+
+    // Forcing comma reads the header as different columns and finds no status
+    if _, err := cleanDatabase(newMemStore(), writeCSV(t, export), CleanOptions{Delimiter: ',', MaxDeactivatePercent: 100,
+        StatusColumn: "Payment Status, as of export, final"}); err == nil {
+        t.Error("the wrong delimiter still found the status column")
+    }
+}
+
+func TestCleanLogsToInjectedLogger(t *testing.T) {
+    var global bytes.Buffer
+    defer log.SetOutput(log.Writer())
+    log.SetOutput(&global)
+
+    var logged bytes.Buffer
+    db := newMemStore()
+    seedMembers(t, db, cleanMembers)
+    _, err := cleanDatabase(db, writeCSV(t, cleanExport), CleanOptions{MaxDeactivatePercent: 100, Logger: log.New(&logged, "", 0)})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, want := range []string{"Processing CSV file", "Database currently has", "Changes to make:", "Database sync complete!"} {
+        if !strings.Contains(logged.String(), want) {
+            t.Errorf("log is missing %q:\n%s", want, logged.String())
+        }
+    }
+    if global.Len() > 0 {
+        t.Errorf("clean wrote to the standard logger:\n%s", global.String())
+    }
+}
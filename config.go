@@ -0,0 +1,623 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "log"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/joho/godotenv"
+)
+
+// configPath is set by the global --config flag; MEMBERSHIPS_CONFIG is used
+// when it is empty
+var configPath string
+
+// configKeys are the settings a config file may hold. File keys are the
+// lowercase form of the environment variable, e.g. database_url.
+var configKeys = []string{
+    "DATABASE_URL",
+    "PORT",
+    "LISTEN_SOCKET",
+    "LISTEN_SOCKET_MODE",
+    "PUBLIC_URL",
+    "REQUEST_TIMEOUT",
+    "MAX_CONCURRENT_REQUESTS",
+    "TRUSTED_PROXIES",
+    "DEBUG_ENDPOINTS",
+    "WEBHOOK_LOG_RETENTION",
+    "ACCESS_LOG_RETENTION",
+    "JOB_HISTORY_RETENTION",
+    "PENDING_CHANGE_TTL",
+    "PENDING_MAX_DEACTIVATE_PERCENT",
+    "DISPLAY_TIMEZONE",
+    "WEBHOOK_SECRET",
+    "ADMIN_TOKEN",
+    "WEBHOOK_FAIL_HARD",
+    "EMAIL_NORMALIZATION",
+    "PRIVACY_MODE",
+    "EMAIL_PEPPER",
+    "EMAIL_ENCRYPTION_KEY",
+    "STATUS_TRANSITIONS",
+    "LAPSE_INTERVAL",
+    "LAPSE_GRACE_DAYS",
+    "FAILED_PAYMENT_LIMIT",
+    "STATUS_RULES",
+    "STATUS_RULES_FILE",
+    "STATUS_FALLBACK",
+    "STRICT_STATUS",
+    "NOTIFY_WEBHOOK_URL",
+    "NOTIFY_EVENTS",
+    "MAILCHIMP_API_KEY",
+    "MAILCHIMP_LIST_ID",
+    "DISCORD_BOT_TOKEN",
+    "DISCORD_GUILD_ID",
+    "DISCORD_ROLE_ID",
+    "DISCORD_SYNC_INTERVAL",
+    "STRIPE_API_KEY",
+    "VERIFY_TOKEN",
+    "VERIFY_ACTIVE_STATUSES",
+    "VERIFY_RATE_LIMIT",
+    "MEMBER_COUNTING",
+    "SYNC_SOURCE",
+    "SYNC_INTERVAL",
+    "SMTP_HOST",
+    "SMTP_PORT",
+    "SMTP_USER",
+    "SMTP_PASS",
+    "SMTP_FROM",
+    "DIGEST_TO",
+    "DIGEST_TIME",
+    "MEMBER_EMAIL_WELCOME",
+    "MEMBER_EMAIL_CANCELLATION",
+    "MEMBER_EMAIL_TEMPLATES",
+}
+
+// webhookSecretPrefix introduces a named webhook source, e.g.
+// WEBHOOK_SECRET_GIVELIVELY; config files may use webhook_secret_givelively
+const webhookSecretPrefix = "WEBHOOK_SECRET_"
+
+// LoadConfig builds the configuration from the optional config file, .env,
+// and the environment, with environment variables taking precedence over
+// file values. Every value is validated; errors name the offending key.
+func LoadConfig(path string, logger *log.Logger) (*Config, error) {
+    // Load .env file
+    if err := godotenv.Load(); err != nil {
+        logger.Println("No .env file found")
+    }
+
+    if path == "" {
+        path = os.Getenv("MEMBERSHIPS_CONFIG")
+    }
+
+    fileValues := map[string]string{}
+    if path != "" {
+        values, err := readConfigFile(path)
+        if err != nil {
+            return nil, err
+        }
+        fileValues = values
+        logger.Printf("Loaded config file %s", path)
+    }
+
+    get := func(key, defaultValue string) string {
+        if value := os.Getenv(key); value != "" {
+            return value
+        }
+        if value := fileValues[key]; value != "" {
+            return value
+        }
+        return defaultValue
+    }
+
+    config := &Config{
+        DatabaseURL:        get("DATABASE_URL", ""),
+        Port:               get("PORT", "3000"),
+        LapseGraceDays:     defaultLapseGraceDays,
+        FailedPaymentLimit: defaultFailedPaymentLimit,
+
+        PendingMaxDeactivatePercent: defaultMaxDeactivatePercent,
+    }
+
+    if config.DatabaseURL == "" {
+        return nil, fmt.Errorf("DATABASE_URL is required")
+    }
+
+    if port, err := strconv.Atoi(config.Port); err != nil || port < 1 || port > 65535 {
+        return nil, fmt.Errorf("PORT must be a port number, got %q", config.Port)
+    }
+
+    sources, err := loadWebhookSources(get, fileValues)
+    if err != nil {
+        return nil, err
+    }
+    config.WebhookSources = sources
+
+    tokens, err := parseSecretList(get("ADMIN_TOKEN", ""))
+    if err != nil {
+        return nil, fmt.Errorf("ADMIN_TOKEN: %w", err)
+    }
+    config.AdminTokens = tokens
+
+    config.ListenSocket = get("LISTEN_SOCKET", "")
+    if config.ListenSocket != "" && get("PORT", "") != "" {
+        return nil, fmt.Errorf("PORT and LISTEN_SOCKET are both set; use one or the other")
+    }
+
+    config.ListenSocketMode = defaultSocketMode
+    if value := get("LISTEN_SOCKET_MODE", ""); value != "" {
+        mode, err := strconv.ParseUint(value, 8, 32)
+        if err != nil || mode > 0777 {
+            return nil, fmt.Errorf("LISTEN_SOCKET_MODE must be octal permissions like 0660, got %q", value)
+        }
+        config.ListenSocketMode = os.FileMode(mode)
+    }
+
+    config.PublicURL = strings.TrimRight(get("PUBLIC_URL", defaultPublicURL), "/")
+    if u, err := url.Parse(config.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        return nil, fmt.Errorf("PUBLIC_URL must be an http or https URL, got %q", config.PublicURL)
+    }
+
+    config.RequestTimeout = defaultRequestTimeout
+    if value := get("REQUEST_TIMEOUT", ""); value != "" {
+        d, err := time.ParseDuration(value)
+        if err != nil || d < 0 {
+            return nil, fmt.Errorf("REQUEST_TIMEOUT must be a duration like 30s, got %q", value)
+        }
+        config.RequestTimeout = d
+    }
+
+    config.MaxConcurrentRequests = defaultMaxConcurrentRequests
+    if value := get("MAX_CONCURRENT_REQUESTS", ""); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("MAX_CONCURRENT_REQUESTS must be a positive integer, got %q", value)
+        }
+        config.MaxConcurrentRequests = n
+    }
+
+    proxies, err := parseTrustedProxies(get("TRUSTED_PROXIES", ""))
+    if err != nil {
+        return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+    }
+    config.TrustedProxies = proxies
+
+    switch value := strings.ToLower(get("DEBUG_ENDPOINTS", "false")); value {
+    case "true":
+        config.DebugEndpoints = true
+    case "false":
+    default:
+        return nil, fmt.Errorf("DEBUG_ENDPOINTS must be true or false, got %q", value)
+    }
+
+    if config.WebhookLogRetention, err = parseAge(get("WEBHOOK_LOG_RETENTION", "0")); err != nil {
+        return nil, fmt.Errorf("WEBHOOK_LOG_RETENTION: %w", err)
+    }
+    if config.AccessLogRetention, err = parseAge(get("ACCESS_LOG_RETENTION", formatAge(defaultAccessLogRetention))); err != nil {
+        return nil, fmt.Errorf("ACCESS_LOG_RETENTION: %w", err)
+    }
+    if config.JobHistoryRetention, err = parseAge(get("JOB_HISTORY_RETENTION", formatAge(defaultJobHistoryRetention))); err != nil {
+        return nil, fmt.Errorf("JOB_HISTORY_RETENTION: %w", err)
+    }
+    if config.PendingChangeTTL, err = parseAge(get("PENDING_CHANGE_TTL", formatAge(defaultPendingChangeTTL))); err != nil {
+        return nil, fmt.Errorf("PENDING_CHANGE_TTL: %w", err)
+    }
+    if value := get("PENDING_MAX_DEACTIVATE_PERCENT", ""); value != "" {
+        percent, err := strconv.Atoi(value)
+        if err != nil || percent < 0 || percent > 100 {
+            return nil, fmt.Errorf("PENDING_MAX_DEACTIVATE_PERCENT must be a percentage from 0 to 100, got %q", value)
+        }
+        config.PendingMaxDeactivatePercent = percent
+    }
+    if config.DisplayZone, err = time.LoadLocation(get("DISPLAY_TIMEZONE", "Local")); err != nil {
+        return nil, fmt.Errorf("DISPLAY_TIMEZONE must be a zone name like America/Los_Angeles: %w", err)
+    }
+
+    switch value := strings.ToLower(get("EMAIL_NORMALIZATION", "false")); value {
+    case "true":
+        config.NormalizeEmails = true
+    case "false":
+    default:
+        return nil, fmt.Errorf("EMAIL_NORMALIZATION must be true or false, got %q", value)
+    }
+
+    switch value := strings.ToLower(get("PRIVACY_MODE", privacyOff)); value {
+    case privacyOff:
+    case privacyHash, privacyEncrypt:
+        if config.Privacy, err = NewEmailPrivacy(value, get("EMAIL_PEPPER", ""), get("EMAIL_ENCRYPTION_KEY", "")); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, fmt.Errorf("PRIVACY_MODE must be off, hash, or encrypt, got %q", value)
+    }
+
+    switch value := strings.ToLower(get("WEBHOOK_FAIL_HARD", "false")); value {
+    case "true":
+        config.WebhookFailHard = true
+    case "false":
+    default:
+        return nil, fmt.Errorf("WEBHOOK_FAIL_HARD must be true or false, got %q", value)
+    }
+
+    switch value := strings.ToLower(get("STATUS_TRANSITIONS", "warn")); value {
+    case "reject":
+        config.StrictTransitions = true
+    case "warn":
+    default:
+        return nil, fmt.Errorf("STATUS_TRANSITIONS must be warn or reject, got %q", value)
+    }
+
+    if value := get("LAPSE_INTERVAL", ""); value != "" {
+        d, err := time.ParseDuration(value)
+        if err != nil || d < 0 {
+            return nil, fmt.Errorf("LAPSE_INTERVAL must be a duration like 24h, got %q", value)
+        }
+        config.LapseInterval = d
+    }
+
+    if value := get("LAPSE_GRACE_DAYS", ""); value != "" {
+        days, err := strconv.Atoi(value)
+        if err != nil || days < 0 {
+            return nil, fmt.Errorf("LAPSE_GRACE_DAYS must be a non-negative integer, got %q", value)
+        }
+        config.LapseGraceDays = days
+    }
+
+    if value := get("FAILED_PAYMENT_LIMIT", ""); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("FAILED_PAYMENT_LIMIT must be a positive integer, got %q", value)
+        }
+        config.FailedPaymentLimit = n
+    }
+
+    rulesFile := get("STATUS_RULES_FILE", "")
+    if rulesFile != "" && get("STATUS_RULES", "") != "" {
+        return nil, fmt.Errorf("STATUS_RULES and STATUS_RULES_FILE are both set; use one or the other")
+    }
+    if rulesFile != "" {
+        rules, err := loadStatusRules(rulesFile)
+        if err != nil {
+            return nil, fmt.Errorf("STATUS_RULES_FILE: %w", err)
+        }
+        config.StatusRules = rules
+    } else {
+        rules, err := parseStatusRules(get("STATUS_RULES", ""))
+        if err != nil {
+            return nil, fmt.Errorf("STATUS_RULES: %w", err)
+        }
+        config.StatusRules = rules
+    }
+
+    // Strict mode is opt-in. STATUS_FALLBACK=reject was the strict switch
+    // before STRICT_STATUS, so it still turns it on when STRICT_STATUS is unset.
+    fallback := strings.ToLower(get("STATUS_FALLBACK", ""))
+    switch value := strings.ToLower(get("STRICT_STATUS", "")); value {
+    case "":
+        config.StrictStatus = fallback == "reject"
+    case "true":
+        config.StrictStatus = true
+    case "false":
+    default:
+        return nil, fmt.Errorf("STRICT_STATUS must be true or false, got %q", value)
+    }
+    switch {
+    case fallback == "" || fallback == "reject":
+        config.StatusFallback = StatusUnknown
+    case fallback == StatusActive:
+        return nil, fmt.Errorf("STATUS_FALLBACK can't be active: a payment status no rule matches must never activate a member")
+    case fallback == statusFailed || validStatus(fallback):
+        config.StatusFallback = fallback
+    default:
+        return nil, fmt.Errorf("STATUS_FALLBACK must be a status other than active, got %q", fallback)
+    }
+
+    config.NotifyWebhookURL = get("NOTIFY_WEBHOOK_URL", "")
+    if config.NotifyWebhookURL != "" && !isURL(config.NotifyWebhookURL) {
+        return nil, fmt.Errorf("NOTIFY_WEBHOOK_URL must be an http(s) URL")
+    }
+
+    events, err := parseNotifyEvents(get("NOTIFY_EVENTS", ""))
+    if err != nil {
+        return nil, fmt.Errorf("NOTIFY_EVENTS: %w", err)
+    }
+    config.NotifyEvents = events
+
+    config.MailchimpAPIKey = get("MAILCHIMP_API_KEY", "")
+    config.MailchimpListID = get("MAILCHIMP_LIST_ID", "")
+    if config.MailchimpAPIKey != "" && !strings.Contains(config.MailchimpAPIKey, "-") {
+        return nil, fmt.Errorf("MAILCHIMP_API_KEY must end with the datacenter, e.g. -us21")
+    }
+
+    config.StripeAPIKey = get("STRIPE_API_KEY", "")
+
+    verifyTokens, err := parseVerifyTokens(get("VERIFY_TOKEN", ""))
+    if err != nil {
+        return nil, fmt.Errorf("VERIFY_TOKEN: %w", err)
+    }
+    config.VerifyTokens = verifyTokens
+
+    for _, status := range strings.Split(get("VERIFY_ACTIVE_STATUSES", "active"), ",") {
+        status = strings.ToLower(strings.TrimSpace(status))
+        if status == "" {
+            continue
+        }
+        if _, ok := statusRank[status]; !ok {
+            return nil, fmt.Errorf("VERIFY_ACTIVE_STATUSES: unknown status %q", status)
+        }
+        config.VerifyStatuses = append(config.VerifyStatuses, status)
+    }
+
+    config.VerifyRateLimit = defaultVerifyRateLimit
+    if value := get("VERIFY_RATE_LIMIT", ""); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("VERIFY_RATE_LIMIT must be a positive integer, got %q", value)
+        }
+        config.VerifyRateLimit = n
+    }
+
+    switch value := strings.ToLower(get("MEMBER_COUNTING", "individuals")); value {
+    case "households":
+        config.CountHouseholds = true
+    case "individuals":
+    default:
+        return nil, fmt.Errorf("MEMBER_COUNTING must be individuals or households, got %q", value)
+    }
+
+    config.SyncSource = get("SYNC_SOURCE", "")
+    if value := get("SYNC_INTERVAL", ""); value != "" {
+        d, err := time.ParseDuration(value)
+        if err != nil || d < 0 {
+            return nil, fmt.Errorf("SYNC_INTERVAL must be a duration like 24h, got %q", value)
+        }
+        if config.SyncSource == "" {
+            return nil, fmt.Errorf("SYNC_INTERVAL requires SYNC_SOURCE")
+        }
+        config.SyncInterval = d
+    }
+
+    config.DiscordBotToken = get("DISCORD_BOT_TOKEN", "")
+    config.DiscordGuildID = get("DISCORD_GUILD_ID", "")
+    config.DiscordRoleID = get("DISCORD_ROLE_ID", "")
+    for key, value := range map[string]string{"DISCORD_GUILD_ID": config.DiscordGuildID, "DISCORD_ROLE_ID": config.DiscordRoleID} {
+        if value != "" && !validDiscordID(value) {
+            return nil, fmt.Errorf("%s must be a numeric Discord ID, got %q", key, value)
+        }
+    }
+
+    if value := get("DISCORD_SYNC_INTERVAL", ""); value != "" {
+        d, err := time.ParseDuration(value)
+        if err != nil || d < 0 {
+            return nil, fmt.Errorf("DISCORD_SYNC_INTERVAL must be a duration like 1h, got %q", value)
+        }
+        config.DiscordSyncInterval = d
+    }
+
+    config.SMTPHost = get("SMTP_HOST", "")
+    config.SMTPUser = get("SMTP_USER", "")
+    config.SMTPPass = get("SMTP_PASS", "")
+    config.SMTPFrom = get("SMTP_FROM", "")
+    if value := get("SMTP_PORT", "587"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 || n > 65535 {
+            return nil, fmt.Errorf("SMTP_PORT must be a port number, got %q", value)
+        }
+        config.SMTPPort = n
+    }
+    if config.SMTPFrom != "" {
+        if _, err := parseAddressList(config.SMTPFrom); err != nil {
+            return nil, fmt.Errorf("SMTP_FROM must be an email address: %w", err)
+        }
+    }
+
+    if config.DigestTo, err = parseAddressList(get("DIGEST_TO", "")); err != nil {
+        return nil, fmt.Errorf("DIGEST_TO: %w", err)
+    }
+    if len(config.DigestTo) > 0 && (config.SMTPHost == "" || config.SMTPFrom == "") {
+        return nil, fmt.Errorf("DIGEST_TO requires SMTP_HOST and SMTP_FROM")
+    }
+    value := get("DIGEST_TIME", "07:00")
+    at, err := time.Parse("15:04", value)
+    if err != nil {
+        return nil, fmt.Errorf("DIGEST_TIME must be a time of day like 07:00, got %q", value)
+    }
+    config.DigestTime = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+
+    for key, enabled := range map[string]*bool{
+        "MEMBER_EMAIL_WELCOME":      &config.MemberEmailWelcome,
+        "MEMBER_EMAIL_CANCELLATION": &config.MemberEmailCancellation,
+    } {
+        switch value := strings.ToLower(get(key, "false")); value {
+        case "true":
+            *enabled = true
+        case "false":
+        default:
+            return nil, fmt.Errorf("%s must be true or false, got %q", key, value)
+        }
+    }
+    if (config.MemberEmailWelcome || config.MemberEmailCancellation) && (config.SMTPHost == "" || config.SMTPFrom == "") {
+        return nil, fmt.Errorf("MEMBER_EMAIL_WELCOME and MEMBER_EMAIL_CANCELLATION require SMTP_HOST and SMTP_FROM")
+    }
+    config.MemberEmailTemplates = get("MEMBER_EMAIL_TEMPLATES", "")
+    if _, err := loadMemberEmailTemplates(config.MemberEmailTemplates); err != nil {
+        return nil, fmt.Errorf("MEMBER_EMAIL_TEMPLATES: %w", err)
+    }
+
+    return config, nil
+}
+
+// mustLoadConfig loads the configuration for CLI subcommands, exiting on error
+func mustLoadConfig(logger *log.Logger) *Config {
+    config, err := LoadConfig(configPath, logger)
+    if err != nil {
+        logger.Fatalf("Invalid configuration: %v", err)
+    }
+    displayZone = config.DisplayZone
+    return config
+}
+
+// readConfigFile parses a flat YAML file of "key: value" lines. Nested
+// structures aren't needed for the settings we have, so only that subset
+// is accepted; unknown keys are rejected to catch typos.
+func readConfigFile(path string) (map[string]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open config file: %w", err)
+    }
+    defer file.Close()
+
+    known := make(map[string]bool)
+    for _, key := range configKeys {
+        known[key] = true
+    }
+
+    values := make(map[string]string)
+    scanner := bufio.NewScanner(file)
+    lineNum := 0
+    for scanner.Scan() {
+        lineNum++
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+            continue
+        }
+
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            return nil, fmt.Errorf("%s:%d: expected \"key: value\"", path, lineNum)
+        }
+
+        key = strings.ToUpper(strings.TrimSpace(key))
+        if !known[key] && !strings.HasPrefix(key, webhookSecretPrefix) {
+            return nil, fmt.Errorf("%s:%d: unknown key %q", path, lineNum, strings.ToLower(key))
+        }
+
+        values[key] = unquoteConfigValue(strings.TrimSpace(value))
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read config file: %w", err)
+    }
+
+    return values, nil
+}
+
+// unquoteConfigValue strips matching quotes, or a trailing comment from an
+// unquoted value
+func unquoteConfigValue(value string) string {
+    if len(value) >= 2 {
+        if (value[0] == '"' && value[len(value)-1] == '"') || (value[0] == '\'' && value[len(value)-1] == '\'') {
+            return value[1 : len(value)-1]
+        }
+    }
+    if i := strings.Index(value, " #"); i >= 0 {
+        value = strings.TrimSpace(value[:i])
+    }
+    return value
+}
+
+// extractConfigFlag removes a global --config flag from args, which may
+// appear anywhere on the command line, and returns its value
+func extractConfigFlag(args []string) ([]string, string) {
+    var rest []string
+    path := ""
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        switch {
+        case arg == "--":
+            return append(rest, args[i:]...), path
+        case arg == "--config" || arg == "-config":
+            if i+1 < len(args) {
+                path = args[i+1]
+                i++
+            }
+        case strings.HasPrefix(arg, "--config="):
+            path = strings.TrimPrefix(arg, "--config=")
+        case strings.HasPrefix(arg, "-config="):
+            path = strings.TrimPrefix(arg, "-config=")
+        default:
+            rest = append(rest, arg)
+        }
+    }
+    return rest, path
+}
+
+// loadWebhookSources collects WEBHOOK_SECRET as the "default" source plus
+// every WEBHOOK_SECRET_<NAME> from the environment or config file. A secret
+// may belong to only one source, or attribution would be ambiguous.
+func loadWebhookSources(get func(key, defaultValue string) string, fileValues map[string]string) (map[string][]string, error) {
+    keys := map[string]bool{"WEBHOOK_SECRET": true}
+    for _, env := range os.Environ() {
+        if key, _, _ := strings.Cut(env, "="); strings.HasPrefix(key, webhookSecretPrefix) {
+            keys[key] = true
+        }
+    }
+    for key := range fileValues {
+        if strings.HasPrefix(key, webhookSecretPrefix) {
+            keys[key] = true
+        }
+    }
+
+    sources := make(map[string][]string)
+    owner := make(map[string]string)
+    for key := range keys {
+        name := "default"
+        if key != "WEBHOOK_SECRET" {
+            name = strings.ToLower(strings.TrimPrefix(key, webhookSecretPrefix))
+            if !validSourceName(name) {
+                return nil, fmt.Errorf("%s: source names may only contain letters, digits, and underscores", key)
+            }
+        }
+
+        secrets, err := parseSecretList(get(key, ""))
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", key, err)
+        }
+        for _, secret := range secrets {
+            if other, dup := owner[secret]; dup {
+                return nil, fmt.Errorf("%s: secret is also used by source %q", key, other)
+            }
+            owner[secret] = name
+        }
+        if len(secrets) > 0 {
+            sources[name] = secrets
+        }
+    }
+
+    return sources, nil
+}
+
+// validSourceName reports whether name is a non-empty [a-z0-9_]+ word
+func validSourceName(name string) bool {
+    if name == "" {
+        return false
+    }
+    for _, r := range name {
+        if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+            return false
+        }
+    }
+    return true
+}
+
+// parseSecretList splits a comma-separated list of secrets. An unset value
+// yields none; an empty entry is an error, since it would match a request
+// carrying no secret at all.
+func parseSecretList(value string) ([]string, error) {
+    if strings.TrimSpace(value) == "" {
+        return nil, nil
+    }
+
+    var secrets []string
+    for i, secret := range strings.Split(value, ",") {
+        secret = strings.TrimSpace(secret)
+        if secret == "" {
+            return nil, fmt.Errorf("entry %d is empty", i+1)
+        }
+        secrets = append(secrets, secret)
+    }
+    return secrets, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "io"
+    "log"
+    "testing"
+)
+
+func TestStrictStatusIsOptIn(t *testing.T) {
+    tests := []struct {
+        strict   string
+        fallback string
+        want     bool
+        target   string
+    }{
+        {"", "", false, StatusUnknown},
+        {"", "cancelled", false, StatusCancelled},
+        {"", "reject", true, StatusUnknown},
+        {"true", "", true, StatusUnknown},
+        {"false", "reject", false, StatusUnknown},
+    }
+    for _, tt := range tests {
+        t.Setenv("DATABASE_URL", "postgres://localhost/memberships")
+        t.Setenv("STRICT_STATUS", tt.strict)
+        t.Setenv("STATUS_FALLBACK", tt.fallback)
+
+        config, err := LoadConfig("", log.New(io.Discard, "", 0))
+        if err != nil {
+            t.Fatalf("STRICT_STATUS=%q STATUS_FALLBACK=%q: %v", tt.strict, tt.fallback, err)
+        }
+        if config.StrictStatus != tt.want || config.StatusFallback != tt.target {
+            t.Errorf("STRICT_STATUS=%q STATUS_FALLBACK=%q: strict %v with fallback %s, want %v with %s",
+                tt.strict, tt.fallback, config.StrictStatus, config.StatusFallback, tt.want, tt.target)
+        }
+    }
+}
+
+func TestPublicURL(t *testing.T) {
+    t.Setenv("DATABASE_URL", "postgres://localhost/memberships")
+    for _, tt := range []struct{ value, want string }{
+        {"", defaultPublicURL},
+        {"https://members.example.org/", "https://members.example.org"},
+        {"http://localhost:3000/memberships", "http://localhost:3000/memberships"},
+        {"members.example.org", ""},
+        {"ftp://members.example.org", ""},
+    } {
+        t.Setenv("PUBLIC_URL", tt.value)
+        config, err := LoadConfig("", log.New(io.Discard, "", 0))
+        if tt.want == "" {
+            if err == nil {
+                t.Errorf("PUBLIC_URL=%q was accepted as %q", tt.value, config.PublicURL)
+            }
+        } else if err != nil || config.PublicURL != tt.want {
+            t.Errorf("PUBLIC_URL=%q: got %v, %v; want %q", tt.value, config, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// parseOptIn reads an email consent value as Zapier sends it ("True",
+// "False", "yes", "0", ...); ok is false when the value says neither
+func parseOptIn(value string) (optIn, ok bool) {
+    switch strings.ToLower(strings.TrimSpace(value)) {
+    case "true", "yes", "1":
+        return true, true
+    case "false", "no", "0":
+        return false, true
+    }
+    return false, false
+}
+
+// consentLabel describes a member's recorded email consent
+func consentLabel(optIn *bool) string {
+    switch {
+    case optIn == nil:
+        return "not recorded"
+    case *optIn:
+        return "opted in"
+    default:
+        return "opted out"
+    }
+}
+
+// SetEmailConsent records a member's email consent, attributed to
+// change.Source, with a member.updated event. changed is false when the
+// member had already made that choice.
+func (db *Database) SetEmailConsent(email string, optIn bool, change ChangeSource) (changed bool, err error) {
+    email = db.NormalizeEmail(email)
+
+    err = db.inTx(func(tx *sql.Tx) error {
+        var memberID int
+        var before sql.NullBool
+        err := tx.QueryRow(`
+            SELECT id, email_opt_in FROM members WHERE email = $1 FOR UPDATE
+        `, email).Scan(&memberID, &before)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        } else if err != nil {
+            return fmt.Errorf("failed to load member: %w", err)
+        }
+        if before.Valid && before.Bool == optIn {
+            return nil
+        }
+
+        _, err = tx.Exec(`
+            UPDATE members SET email_opt_in = $2, consent_recorded_at = CURRENT_TIMESTAMP, consent_source = $3,
+                last_updated = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, memberID, optIn, change.Source)
+        if err != nil {
+            return fmt.Errorf("failed to record consent: %w", err)
+        }
+        changed = true
+
+        var previous interface{}
+        if before.Valid {
+            previous = before.Bool
+        }
+        return recordEvent(tx, feedMemberUpdated, memberID, change, map[string]interface{}{
+            "email": email,
+            "changes": map[string]interface{}{
+                "email_opt_in": map[string]interface{}{"before": previous, "after": optIn},
+            },
+        })
+    })
+    if err == nil && changed {
+        db.logger.Printf("Email consent for %s: %s (%s)", email, consentLabel(&optIn), change.Source)
+    }
+    return changed, err
+}
+
+// Unsubscribe opts out the member whose unsubscribe token this is
+func (db *Database) Unsubscribe(token string) error {
+    if !isUUID(token) {
+        return fmt.Errorf("%w: invalid unsubscribe token", ErrMemberNotFound)
+    }
+
+    var email string
+    err := db.QueryRow(`SELECT email FROM members WHERE unsubscribe_token = $1`, strings.ToLower(token)).Scan(&email)
+    if err == sql.ErrNoRows {
+        return fmt.Errorf("%w: unknown unsubscribe token", ErrMemberNotFound)
+    } else if err != nil {
+        return fmt.Errorf("database error: %w", err)
+    }
+
+    _, err = db.SetEmailConsent(email, false, ChangeSource{Source: "unsubscribe"})
+    return err
+}
+
+// unsubscribeHandler serves the link in email footers. It answers in plain
+// text since it's opened in a browser, and accepts POST for one-click
+// unsubscribe (RFC 8058) as well as GET.
+func (s *WebhookServer) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+
+    err := s.db.Unsubscribe(r.PathValue("token"))
+    if errors.Is(err, ErrMemberNotFound) {
+        w.WriteHeader(http.StatusNotFound)
+        fmt.Fprintln(w, "This unsubscribe link isn't valid. It may be incomplete, or the address may have been removed.")
+        return
+    } else if err != nil {
+        s.logger.Printf("Error unsubscribing: %v", err)
+        w.WriteHeader(http.StatusInternalServerError)
+        fmt.Fprintln(w, "Something went wrong; please try the link again later.")
+        return
+    }
+
+    fmt.Fprintln(w, "You've been unsubscribed and won't receive further emails from us.")
+}
+
+func runConsent(logger *log.Logger) {
+    consentCmd := flag.NewFlagSet("consent", flag.ExitOnError)
+    optIn := consentCmd.Bool("opt-in", false, "Record that the member agreed to email")
+    optOut := consentCmd.Bool("opt-out", false, "Record that the member declined email")
+
+    args := parseSubcommand(consentCmd, "memberships consent <email> --opt-in|--opt-out", os.Args[2:])
+    if len(args) != 1 || *optIn == *optOut {
+        fmt.Fprintln(os.Stderr, "Error: consent requires an email and exactly one of --opt-in or --opt-out")
+        consentCmd.Usage()
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    err := db.UpdateMemberFields(args[0], MemberUpdate{EmailOptIn: optIn})
+    if errors.Is(err, ErrMemberNotFound) {
+        fmt.Fprintf(os.Stderr, "No member found for %s\n", db.NormalizeEmail(args[0]))
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Failed to record consent: %v", err)
+    }
+
+    fmt.Printf("%s: %s\n", db.NormalizeEmail(args[0]), consentLabel(optIn))
+}
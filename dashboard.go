@@ -0,0 +1,298 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "html/template"
+    "net/http"
+    "sort"
+    "time"
+)
+
+// Dashboard sizing
+const (
+    dashboardRecentMembers = 10
+    dashboardFailures      = 10
+    dashboardGrowthMonths  = 12
+    dashboardChartWidth    = 600
+    dashboardChartHeight   = 160
+)
+
+// growthBar is one month in the dashboard's growth chart
+type growthBar struct {
+    Label  string
+    Active int
+    X      int
+    Y      int
+    Width  int
+    Height int
+}
+
+// dashboardData is everything the dashboard template renders. Optional
+// sections carry an error message instead of failing the whole page.
+type dashboardData struct {
+    GeneratedAt time.Time
+    Zone        string
+
+    Stats       *Stats
+    Frequencies []string
+
+    Revenue      []RevenueStats
+    RevenueError string
+
+    Growth      []growthBar
+    GrowthError string
+
+    Members      []apiMember
+    MembersError string
+
+    Failures      []FailedWebhook
+    FailuresError string
+
+    LastSync    *SyncStatus
+    SyncEnabled bool
+}
+
+// monthlyGrowth reduces daily snapshots to the last one of each of the
+// most recent months, laid out as chart bars
+func monthlyGrowth(snapshots []StatsSnapshot, months int) []growthBar {
+    byMonth := map[string]int{}
+    var order []string
+    for _, snap := range snapshots {
+        if len(snap.Date) < 7 {
+            continue
+        }
+        month := snap.Date[:7]
+        if _, ok := byMonth[month]; !ok {
+            order = append(order, month)
+        }
+        byMonth[month] = snap.Active
+    }
+    sort.Strings(order)
+    if len(order) > months {
+        order = order[len(order)-months:]
+    }
+    if len(order) == 0 {
+        return nil
+    }
+
+    peak := 1
+    for _, month := range order {
+        peak = max(peak, byMonth[month])
+    }
+
+    width := dashboardChartWidth / len(order)
+    bars := make([]growthBar, len(order))
+    for i, month := range order {
+        label := month
+        if t, err := time.Parse("2006-01", month); err == nil {
+            label = t.Format("Jan 06")
+        }
+        height := byMonth[month] * dashboardChartHeight / peak
+        bars[i] = growthBar{
+            Label:  label,
+            Active: byMonth[month],
+            X:      i * width,
+            Y:      dashboardChartHeight - height,
+            Width:  max(width-4, 1),
+            Height: height,
+        }
+    }
+    return bars
+}
+
+// dashboardHandler serves GET /dashboard, an HTML overview built from the
+// same queries as the JSON endpoints
+func (s *WebhookServer) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+    stats, err := s.db.GetStats(r.Context())
+    if err != nil {
+        s.logger.Printf("Error getting stats: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    stats.LastSync = s.scheduler.Last()
+
+    data := dashboardData{
+        GeneratedAt: time.Now().In(displayZone),
+        Zone:        displayZone.String(),
+        Stats:       stats,
+        Frequencies: append(append([]string{}, canonicalFrequencies...), frequencyUnknown),
+        LastSync:    stats.LastSync,
+        SyncEnabled: s.config.SyncSource != "",
+    }
+
+    if data.Revenue, err = s.db.GetRevenueStats(r.Context()); err != nil {
+        s.logger.Printf("Dashboard: error getting revenue stats: %v", err)
+        data.RevenueError = "Revenue figures are unavailable right now."
+    }
+
+    // A year of snapshots plus a month, so the oldest month is complete
+    snapshots, err := s.db.GetSnapshots(dashboardGrowthMonths*31 + 31)
+    if err != nil {
+        s.logger.Printf("Dashboard: error getting snapshots: %v", err)
+        data.GrowthError = "Growth history is unavailable right now."
+    }
+    data.Growth = monthlyGrowth(snapshots, dashboardGrowthMonths)
+
+    members, err := s.db.GetMembers(MemberFilter{Limit: dashboardRecentMembers})
+    if err != nil {
+        s.logger.Printf("Dashboard: error getting members: %v", err)
+        data.MembersError = "Recent members are unavailable right now."
+    }
+    for i := range members {
+        data.Members = append(data.Members, newAPIMember(&members[i]))
+    }
+
+    if data.Failures, err = s.db.GetFailedWebhooks(failureOpen, dashboardFailures); err != nil {
+        s.logger.Printf("Dashboard: error getting failed webhooks: %v", err)
+        data.FailuresError = "Webhook failures are unavailable right now."
+    }
+
+    // Render first so a template error can still become a clean 500
+    var page bytes.Buffer
+    if err := dashboardTemplate.Execute(&page, data); err != nil {
+        s.logger.Printf("Error rendering dashboard: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Write(page.Bytes())
+}
+
+// basicAuthChallenge asks browsers for credentials when a request isn't
+// authenticated, so the dashboard can be opened without a bearer token;
+// the admin token goes in the password field
+func (s *WebhookServer) basicAuthChallenge(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if s.adminTokenIndex(r) < 0 {
+            w.Header().Set("WWW-Authenticate", `Basic realm="memberships", charset="UTF-8"`)
+        }
+        next(w, r)
+    }
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+    "time": displayTime,
+    "percent": func(part, whole int) string {
+        if whole == 0 {
+            return "0.0%"
+        }
+        return fmt.Sprintf("%.1f%%", float64(part)*100/float64(whole))
+    },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Memberships</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 60rem; padding: 0 1rem; color: #222; }
+h1 { margin-bottom: 0; }
+h2 { margin-top: 2rem; border-bottom: 1px solid #ddd; padding-bottom: .25rem; }
+.muted { color: #777; }
+.cards { display: flex; flex-wrap: wrap; gap: .75rem; }
+.card { border: 1px solid #ddd; border-radius: 6px; padding: .5rem 1rem; min-width: 8rem; }
+.card .n { font-size: 1.6rem; font-weight: 600; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3rem .5rem; border-bottom: 1px solid #eee; vertical-align: top; }
+td.num, th.num { text-align: right; }
+.status-active { color: #17803d; }
+.status-cancelled { color: #b42318; }
+.status-suspended, .status-lapsed, .status-unknown { color: #b54708; }
+svg rect { fill: #3b82f6; }
+svg text { font-size: 10px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>Memberships</h1>
+<p class="muted">As of {{time .GeneratedAt}} ({{.Zone}})</p>
+
+<h2>Members</h2>
+<div class="cards">
+  <div class="card"><div class="n">{{.Stats.TotalMembers}}</div>total</div>
+  <div class="card"><div class="n status-active">{{.Stats.ActiveMembers}}</div>active ({{percent .Stats.ActiveMembers .Stats.TotalMembers}})</div>
+  <div class="card"><div class="n status-cancelled">{{.Stats.CancelledMembers}}</div>cancelled</div>
+  <div class="card"><div class="n status-suspended">{{.Stats.SuspendedMembers}}</div>suspended</div>
+  <div class="card"><div class="n status-lapsed">{{.Stats.LapsedMembers}}</div>lapsed</div>
+  {{with .Stats.UnknownStatusMembers}}<div class="card"><div class="n status-unknown">{{.}}</div>unmapped payment status</div>{{end}}
+  {{range $status, $count := .Stats.OtherStatuses}}<div class="card"><div class="n">{{$count}}</div>unknown status "{{$status}}"</div>{{end}}
+</div>
+<p>
+  Anonymous: {{.Stats.AnonymousMembers}} &middot;
+  Active with no payment in 90+ days: {{.Stats.OverduePaymentMembers}} &middot;
+  With failed payments: {{.Stats.FailedPaymentMembers}}
+</p>
+{{if .Stats.ActiveMembers}}
+<table>
+  <tr><th>Active by frequency</th><th class="num">Members</th><th class="num">Share</th></tr>
+  {{$stats := .Stats}}{{range $frequency := .Frequencies}}{{with index $stats.ActiveByFrequency $frequency}}
+  <tr><td>{{$frequency}}</td><td class="num">{{.}}</td><td class="num">{{percent . $stats.ActiveMembers}}</td></tr>
+  {{end}}{{end}}
+</table>
+{{end}}
+
+<h2>Revenue</h2>
+{{if .RevenueError}}<p class="muted">{{.RevenueError}}</p>
+{{else if not .Revenue}}<p class="muted">No donations recorded yet.</p>
+{{else}}
+<table>
+  <tr><th>Currency</th><th class="num">Estimated MRR</th><th class="num">Recurring members</th><th class="num">Last 30 days</th><th class="num">Last 365 days</th><th class="num">Average gift</th></tr>
+  {{range .Revenue}}
+  <tr><td>{{.Currency}}</td><td class="num">{{.EstimatedMRR}}</td><td class="num">{{.RecurringMembers}}</td><td class="num">{{.Revenue30Days}}</td><td class="num">{{.Revenue365Days}}</td><td class="num">{{.AverageGift}}</td></tr>
+  {{end}}
+</table>
+{{end}}
+
+<h2>Active members, last 12 months</h2>
+{{if .GrowthError}}<p class="muted">{{.GrowthError}}</p>
+{{else if not .Growth}}<p class="muted">No snapshots yet. The server records one each day.</p>
+{{else}}
+<svg width="100%" viewBox="0 -16 600 196" role="img" aria-label="Active members at the end of each month">
+  {{range .Growth}}
+  <rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Label}}: {{.Active}}</title></rect>
+  <text x="{{.X}}" y="{{.Y}}" dy="-3">{{.Active}}</text>
+  <text x="{{.X}}" y="176">{{.Label}}</text>
+  {{end}}
+</svg>
+{{end}}
+
+<h2>Recently updated members</h2>
+{{if .MembersError}}<p class="muted">{{.MembersError}}</p>
+{{else if not .Members}}<p class="muted">No members yet.</p>
+{{else}}
+<table>
+  <tr><th>Email</th><th>Name</th><th>Status</th><th>Frequency</th><th>Tags</th><th>Updated</th></tr>
+  {{range .Members}}
+  <tr><td>{{.Email}}</td><td>{{.Name}}</td><td class="status-{{.Status}}">{{.Status}}</td><td>{{.Frequency}}</td><td>{{range $i, $t := .Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</td><td>{{time .LastUpdated}}</td></tr>
+  {{end}}
+</table>
+{{end}}
+
+<h2>Open webhook failures</h2>
+{{if .FailuresError}}<p class="muted">{{.FailuresError}}</p>
+{{else if not .Failures}}<p class="muted">None. Every webhook has been processed.</p>
+{{else}}
+<table>
+  <tr><th>#</th><th>Received</th><th>Class</th><th>Error</th></tr>
+  {{range .Failures}}
+  <tr><td>{{.ID}}</td><td>{{time .ReceivedAt}}</td><td>{{.ErrorClass}}</td><td>{{.Error}}</td></tr>
+  {{end}}
+</table>
+<p class="muted">Review with <code>memberships failed list</code>.</p>
+{{end}}
+
+<h2>Last sync</h2>
+{{with .LastSync}}
+<p>
+  {{.Status}} at {{time .FinishedAt}} from {{.Source}}{{if .SyncRunID}} (run #{{.SyncRunID}}){{end}}:
+  {{.Added}} added, {{.Reactivated}} reactivated, {{.Deactivated}} deactivated, {{.Suspended}} suspended
+</p>
+{{if .Error}}<p class="status-cancelled">{{.Error}}</p>{{end}}
+{{else}}
+<p class="muted">{{if .SyncEnabled}}No scheduled sync has run since the server started.{{else}}Scheduled sync is off (SYNC_SOURCE is not set).{{end}}</p>
+{{end}}
+</body>
+</html>
+`))
@@ -1,23 +1,102 @@
 package main
 
 import (
+    "context"
     "database/sql"
+    "database/sql/driver"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
+    "log"
+    "net"
     "strings"
     "time"
 
-    _ "github.com/lib/pq"
+    "github.com/lib/pq"
 )
 
+// ErrMemberNotFound is returned when an operation targets an unknown email
+var ErrMemberNotFound = errors.New("member not found")
+
+// Chunk sizes for whole-table reads and bulk status updates
+const (
+    memberChunkSize = 5000
+    statusBatchSize = 1000
+)
+
+// isRetryableError reports whether err is transient, such as a lost
+// connection or a serialization failure, so the same request may succeed later
+func isRetryableError(err error) bool {
+    if err == nil || errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrMemberNotFound) {
+        return false
+    }
+    
+    if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+        errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+        return true
+    }
+    
+    var netErr net.Error
+    if errors.As(err, &netErr) {
+        return true
+    }
+    
+    // Connection exceptions, transaction rollbacks, insufficient resources,
+    // and operator intervention (e.g. shutdown) are worth retrying
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) {
+        switch pqErr.Code.Class() {
+        case "08", "40", "53", "57":
+            return true
+        }
+    }
+    
+    return false
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so write paths can run
+// standalone or as part of a larger transaction
+type querier interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // Database wraps the SQL database connection
 type Database struct {
     *sql.DB
+    
+    // NormalizeEmails enables plus-suffix and gmail dot stripping
+    NormalizeEmails bool
+    
+    // Privacy, if set, replaces every email with its keyed hash
+    Privacy *EmailPrivacy
+    
+    // StrictTransitions rejects status changes not in statusTransitions
+    // instead of logging a warning
+    StrictTransitions bool
+    
+    // CountHouseholds counts each household once in stats, by its primary
+    CountHouseholds bool
+    
+    // Events, if set, is woken after changes that record feed events commit
+    Events *EventHub
+    
+    // logger receives the database's log output, set by NewDatabase
+    logger *log.Logger
+    
+    // OnStatusChange, if set, is called after ProcessMember, UpdateMemberStatus,
+    // or SetMemberStatus creates a member or changes its status. previous is
+    // empty for new members.
+    OnStatusChange func(email, previous, status string)
 }
 
-// NewDatabase creates a new database connection
-func NewDatabase(connStr string) (*Database, error) {
-    conn, err := sql.Open("postgres", connStr)
+// NewDatabase creates a new database connection. A nil logger uses the
+// standard logger.
+func NewDatabase(connStr string, logger *log.Logger) (*Database, error) {
+    // Sessions run in UTC; see utcSession
+    conn, err := sql.Open("postgres", utcSession(connStr))
     if err != nil {
         return nil, fmt.Errorf("failed to open database: %w", err)
     }
@@ -32,15 +111,241 @@ func NewDatabase(connStr string) (*Database, error) {
         return nil, fmt.Errorf("failed to ping database: %w", err)
     }
     
-    return &Database{conn}, nil
+    if logger == nil {
+        logger = log.Default()
+    }
+    
+    return &Database{DB: conn, logger: logger}, nil
+}
+
+// NormalizeEmail canonicalizes an email using the database's normalization settings
+// and, in privacy mode, returns its key. Keys are returned unchanged.
+func (db *Database) NormalizeEmail(email string) string {
+    if db.Privacy == nil {
+        return normalizeEmail(email, db.NormalizeEmails)
+    }
+    if isEmailKey(email) {
+        return strings.TrimSpace(email)
+    }
+    normalized := normalizeEmail(email, db.NormalizeEmails)
+    if normalized == "" {
+        return ""
+    }
+    return db.Privacy.Key(normalized)
+}
+
+// ChangeSource says what caused a status change, for status_history
+type ChangeSource struct {
+    // Source is the kind of change: webhook (or webhook:<name> for a named
+    // webhook source), clean, undo, manual, lapse, merge, restore, import,
+    // or review
+    Source string
+    
+    // Detail identifies the specific cause: a webhook log id, sync run,
+    // operator note, or lapse reason
+    Detail string
+    
+    // EventTime is when the change happened at its source, if known. A
+    // member update older than the last one applied fails with ErrStaleEvent.
+    EventTime time.Time
+    
+    // ReceivedAt is when the webhook behind the change arrived. A Retried
+    // delivery with no EventTime that arrived before the last delivery
+    // applied fails with ErrStaleEvent, since the newer one superseded it.
+    ReceivedAt time.Time
+    Retried    bool
+}
+
+// staleRetry reports whether change is a retried delivery, with no event
+// time of its own, that arrived before the last delivery applied
+func staleRetry(change ChangeSource, lastReceived time.Time) bool {
+    return change.Retried && change.EventTime.IsZero() && !change.ReceivedAt.IsZero() &&
+        !lastReceived.IsZero() && change.ReceivedAt.Before(lastReceived)
+}
+
+// webhookChange attributes a change to a webhook log row from a source
+func webhookChange(sourceName string, logID int) ChangeSource {
+    change := ChangeSource{Source: "webhook"}
+    if sourceName != "" && sourceName != "default" {
+        change.Source += ":" + sourceName
+    }
+    if logID > 0 {
+        change.Detail = fmt.Sprintf("webhook log #%d", logID)
+    }
+    return change
+}
+
+// recordStatusHistory appends a status_history row for a member
+func recordStatusHistory(q querier, memberID int, status string, change ChangeSource) error {
+    _, err := q.Exec(`
+        INSERT INTO status_history (member_id, status, source, detail)
+        VALUES ($1, $2, COALESCE(NULLIF($3, ''), 'unknown'), NULLIF($4, ''))
+    `, memberID, status, change.Source, change.Detail)
+    return err
+}
+
+// What processing a member did
+const (
+    actionCreated   = "created"
+    actionUpdated   = "updated"
+    actionUnchanged = "unchanged"
+)
+
+// Why a webhook left the member alone without failing outright
+const (
+    actionSkipped      = "skipped"
+    actionDeferred     = "deferred"
+    actionQueued       = "queued"
+    actionNotProcessed = "not_processed"
+)
+
+// ProcessResult is what processing a member did. Action is "updated" only
+// when the status changed; Status is the status the member was left with,
+// which for a household member may not be the one asked for. For an
+// organization's webhook, Action and the statuses are the organization's.
+// A webhook that wasn't applied has one of the skipped, deferred, queued,
+// or not_processed actions, no status, and a Message saying why.
+type ProcessResult struct {
+    Action         string `json:"result"`
+    Email          string `json:"email"`
+    PreviousStatus string `json:"previous_status,omitempty"`
+    Status         string `json:"status,omitempty"`
+    Message        string `json:"message,omitempty"`
+    MemberID       string `json:"member_id,omitempty"`
+    IsAnonymous    bool   `json:"is_anonymous"`
+    Organization   string `json:"organization,omitempty"`
+    DryRun         bool   `json:"dry_run,omitempty"`
+}
+
+// Changed reports whether the member was created or changed status
+func (r *ProcessResult) Changed() bool {
+    return r.Action != actionUnchanged
+}
+
+// ProcessMember handles creating or updating a member from webhook data,
+// attributing any status change to change
+func (db *Database) ProcessMember(email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    var result *ProcessResult
+    err := db.inTx(func(tx *sql.Tx) error {
+        var err error
+        result, err = db.processMember(tx, email, name, isAnonymous, status, change)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    if db.OnStatusChange != nil && result.Changed() {
+        db.OnStatusChange(result.Email, result.PreviousStatus, result.Status)
+    }
+    
+    return result, nil
+}
+
+// PreviewMember reports what ProcessMember would do, with the same checks,
+// without writing anything
+func (db *Database) PreviewMember(email string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    decision, err := db.decideMember(db.DB, email, isAnonymous, status, change, false)
+    if err != nil {
+        return nil, err
+    }
+    return decision.result, nil
 }
 
-// ProcessMember handles creating or updating a member from webhook data
-func (db *Database) ProcessMember(email, name string, isAnonymous bool, status string) error {
-    email = strings.ToLower(strings.TrimSpace(email))
+// memberDecision is what processMember will do to a member, worked out
+// before anything is written
+type memberDecision struct {
+    result   *ProcessResult
+    memberID int
+    rawEmail string
+}
+
+// decideMember validates a change and works out its result from the
+// member's current row, reading but never writing. With lock the row is
+// locked, so two deliveries for the same member can't both pass the event
+// time check.
+func (db *Database) decideMember(q querier, email string, isAnonymous bool, status string, change ChangeSource, lock bool) (*memberDecision, error) {
+    rawEmail := strings.TrimSpace(email)
+    email = db.NormalizeEmail(email)
     
     if email == "" {
-        return fmt.Errorf("email is required")
+        return nil, fmt.Errorf("email is required")
+    }
+    
+    // Keys come from redacted webhook logs and privacy-mode CSV sources
+    if !isEmailKey(rawEmail) {
+        if err := validateEmail(rawEmail); err != nil {
+            return nil, err
+        }
+    }
+    
+    decision := &memberDecision{
+        result:   &ProcessResult{Email: email, IsAnonymous: isAnonymous, Status: status},
+        rawEmail: rawEmail,
+    }
+    result := decision.result
+    
+    forUpdate := ""
+    if lock {
+        forUpdate = " FOR UPDATE"
+    }
+    var currentStatus string
+    var lastEvent, lastReceived sql.NullTime
+    var household sql.NullInt64
+    err := q.QueryRow(`
+        SELECT id, public_id, status, last_event_at, last_received_at, household_id FROM members WHERE email = $1`+forUpdate,
+        email).Scan(&decision.memberID, &result.MemberID, &currentStatus, &lastEvent, &lastReceived, &household)
+    
+    if err == sql.ErrNoRows {
+        result.Action = actionCreated
+        return decision, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("database error: %w", err)
+    }
+    
+    result.PreviousStatus = currentStatus
+    if !change.EventTime.IsZero() && lastEvent.Valid && change.EventTime.Before(lastEvent.Time) {
+        return nil, fmt.Errorf("%w: %s event for %s from %s predates the last one applied (%s)", ErrStaleEvent,
+            status, email, change.EventTime.UTC().Format(time.RFC3339), lastEvent.Time.UTC().Format(time.RFC3339))
+    }
+    if staleRetry(change, lastReceived.Time) {
+        return nil, fmt.Errorf("%w: retried %s delivery for %s received %s predates the last one applied (%s)", ErrStaleEvent,
+            status, email, change.ReceivedAt.UTC().Format(time.RFC3339), lastReceived.Time.UTC().Format(time.RFC3339))
+    }
+    
+    // A household member's status follows the primary member's
+    if household.Valid && int(household.Int64) != decision.memberID && change.Source != householdSource && status != currentStatus {
+        db.logger.Printf("Member %s (ID: %d) follows household #%d; keeping %s instead of %s from %s",
+            email, decision.memberID, household.Int64, currentStatus, status, change.Source)
+        result.Status = currentStatus
+    }
+    
+    if err := db.checkTransition(email, currentStatus, result.Status, change); err != nil {
+        return nil, err
+    }
+    
+    result.Action = actionUnchanged
+    if currentStatus != result.Status {
+        result.Action = actionUpdated
+    }
+    return decision, nil
+}
+
+// processMember is ProcessMember against q
+func (db *Database) processMember(q querier, email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    decision, err := db.decideMember(q, email, isAnonymous, status, change, true)
+    if err != nil {
+        return nil, err
+    }
+    result := decision.result
+    memberID := decision.memberID
+    email = result.Email
+    status = result.Status
+    
+    storedRaw, ciphertext, err := db.storedEmail(decision.rawEmail)
+    if err != nil {
+        return nil, err
     }
     
     // Don't store name for anonymous members
@@ -48,205 +353,723 @@ func (db *Database) ProcessMember(email, name string, isAnonymous bool, status s
         name = ""
     }
     
-    // Check if member exists
-    var memberID int
-    var currentStatus string
-    err := db.QueryRow(`
-        SELECT id, status FROM members WHERE email = $1
-    `, email).Scan(&memberID, &currentStatus)
+    var eventTime, receivedAt interface{}
+    if !change.EventTime.IsZero() {
+        eventTime = change.EventTime
+    }
+    if !change.ReceivedAt.IsZero() {
+        receivedAt = change.ReceivedAt
+    }
     
-    if err == sql.ErrNoRows {
+    if result.Action == actionCreated {
         // Create new member
-        err = db.QueryRow(`
-            INSERT INTO members (email, name, is_anonymous, status, first_seen, last_updated)
-            VALUES ($1, $2, $3, $4, CURRENT_DATE, CURRENT_TIMESTAMP)
-            RETURNING id
-        `, email, name, isAnonymous, status).Scan(&memberID)
+        err = q.QueryRow(`
+            INSERT INTO members (email, email_hash, raw_email, email_ciphertext, name, is_anonymous, status, first_seen, last_updated, last_event_at, last_received_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, $8, $9)
+            RETURNING id, public_id
+        `, email, emailHash(email), storedRaw, ciphertext, name, isAnonymous, status, eventTime, receivedAt).Scan(&memberID, &result.MemberID)
         
         if err != nil {
-            return fmt.Errorf("failed to create member: %w", err)
+            return nil, fmt.Errorf("failed to create member: %w", err)
         }
         
-        logger.Printf("Created new member: %s (ID: %d, Status: %s)", email, memberID, status)
+        db.logger.Printf("Created new member: %s (ID: %d, Status: %s)", email, memberID, status)
         
         // Record initial status in history
-        _, _ = db.Exec(`
-            INSERT INTO status_history (member_id, status)
-            VALUES ($1, $2)
-        `, memberID, status)
-        
-    } else if err == nil {
-        // Update existing member
-        _, err = db.Exec(`
-            UPDATE members SET
-                name = CASE 
-                    WHEN $1 = true THEN name  -- Keep existing name if anonymous
-                    WHEN $2 = '' THEN name     -- Keep existing name if new name is empty
-                    ELSE $2                    -- Otherwise update name
-                END,
-                is_anonymous = $1,
-                status = $3,
-                last_updated = CURRENT_TIMESTAMP
-            WHERE id = $4
-        `, isAnonymous, name, status, memberID)
+        _ = recordStatusHistory(q, memberID, status, change)
         
+        err = recordEvent(q, feedMemberCreated, memberID, change, map[string]interface{}{
+            "email":  email,
+            "status": status,
+        })
         if err != nil {
-            return fmt.Errorf("failed to update member: %w", err)
+            return nil, err
         }
+        return result, nil
+    }
+    
+    // Update existing member
+    _, err = q.Exec(`
+        UPDATE members SET
+            name = CASE 
+                WHEN $1 = true THEN name  -- Keep existing name if anonymous
+                WHEN $2 = '' THEN name     -- Keep existing name if new name is empty
+                ELSE $2                    -- Otherwise update name
+            END,
+            is_anonymous = $1,
+            status = $3,
+            email_hash = COALESCE(email_hash, $5),
+            email_ciphertext = COALESCE(email_ciphertext, $6),
+            last_event_at = GREATEST(last_event_at, $7),
+            last_received_at = GREATEST(last_received_at, $8),
+            last_updated = CURRENT_TIMESTAMP
+        WHERE id = $4
+    `, isAnonymous, name, status, memberID, emailHash(email), ciphertext, eventTime, receivedAt)
+    
+    if err != nil {
+        return nil, fmt.Errorf("failed to update member: %w", err)
+    }
+    
+    // Record status change if different
+    if result.Action == actionUpdated {
+        _ = recordStatusHistory(q, memberID, status, change)
         
-        // Record status change if different
-        if currentStatus != status {
-            _, _ = db.Exec(`
-                INSERT INTO status_history (member_id, status)
-                VALUES ($1, $2)
-            `, memberID, status)
-            
-            logger.Printf("Updated member %s (ID: %d): %s -> %s", 
-                email, memberID, currentStatus, status)
-        } else {
-            logger.Printf("Member %s (ID: %d) status unchanged: %s", 
-                email, memberID, status)
+        if err := recordStatusEvent(q, memberID, email, result.PreviousStatus, status, change); err != nil {
+            return nil, err
         }
+        if err := followHousehold(q, int64(memberID)); err != nil {
+            return nil, err
+        }
+        
+        db.logger.Printf("Updated member %s (ID: %d): %s -> %s", 
+            email, memberID, result.PreviousStatus, status)
     } else {
-        return fmt.Errorf("database error: %w", err)
+        db.logger.Printf("Member %s (ID: %d) status unchanged: %s", 
+            email, memberID, status)
+    }
+    
+    return result, nil
+}
+
+// RecordPayment notes a successful payment, keeping the earliest first and
+// latest last payment times seen so out-of-order sources can't move them back.
+// A successful payment also ends any run of failed payments.
+func (db *Database) RecordPayment(email string, paidAt time.Time) error {
+    return db.recordPayment(db.DB, email, paidAt)
+}
+
+func (db *Database) recordPayment(q querier, email string, paidAt time.Time) error {
+    email = db.NormalizeEmail(email)
+    
+    _, err := q.Exec(`
+        UPDATE members SET
+            first_payment_at = LEAST(COALESCE(first_payment_at, $2), $2),
+            last_payment_at = GREATEST(COALESCE(last_payment_at, $2), $2),
+            failed_payment_count = 0
+        WHERE email = $1
+    `, email, paidAt)
+    if err != nil {
+        return fmt.Errorf("failed to record payment: %w", err)
     }
     
     return nil
 }
 
-// LogWebhook stores the raw webhook data for debugging
-func (db *Database) LogWebhook(email, status string, payload json.RawMessage) error {
-    _, err := db.Exec(`
-        INSERT INTO webhook_logs (email, status, payload)
-        VALUES ($1, $2, $3)
-    `, email, status, payload)
-    return err
+// FailedPaymentCount returns a member's consecutive failed payments, or 0 for
+// an unknown member
+func (db *Database) FailedPaymentCount(email string) (int, error) {
+    var count int
+    err := db.QueryRow(`
+        SELECT failed_payment_count FROM members WHERE email = $1
+    `, db.NormalizeEmail(email)).Scan(&count)
+    if err == sql.ErrNoRows {
+        return 0, nil
+    } else if err != nil {
+        return 0, fmt.Errorf("database error: %w", err)
+    }
+    
+    return count, nil
 }
 
-// GetStats returns membership statistics
-func (db *Database) GetStats() (*Stats, error) {
-    var stats Stats
+// failedPaymentStatus is the status a failed payment leaves a member in:
+// suspended, or cancelled once failures in a row reach limit. Cancelled
+// members stay cancelled.
+func failedPaymentStatus(previous string, failures, limit int) string {
+    if previous == StatusCancelled || failures >= limit {
+        return StatusCancelled
+    }
+    return StatusSuspended
+}
+
+// ProcessFailedPayment is ProcessMember for a failed payment. It adds one to
+// the member's run of failed payments and sets the status that run calls
+// for in the same transaction, so concurrent failures can't both read the
+// old count.
+func (db *Database) ProcessFailedPayment(email, name string, isAnonymous bool, limit int, change ChangeSource) (*ProcessResult, error) {
+    var result *ProcessResult
+    err := db.inTx(func(tx *sql.Tx) error {
+        key := db.NormalizeEmail(email)
+        
+        // The update locks the row until the transaction ends
+        failures := 1
+        var previous string
+        err := tx.QueryRow(`
+            UPDATE members SET failed_payment_count = failed_payment_count + 1
+            WHERE email = $1
+            RETURNING failed_payment_count, status
+        `, key).Scan(&failures, &previous)
+        if err != nil && err != sql.ErrNoRows {
+            return fmt.Errorf("failed to record failed payment: %w", err)
+        }
+        
+        result, err = db.processMember(tx, email, name, isAnonymous, failedPaymentStatus(previous, failures, limit), change)
+        if err != nil {
+            return err
+        }
+        
+        if result.Action == actionCreated {
+            _, err = tx.Exec(`UPDATE members SET failed_payment_count = 1 WHERE email = $1`, key)
+            if err != nil {
+                return fmt.Errorf("failed to record failed payment: %w", err)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    
+    if db.OnStatusChange != nil && result.Changed() {
+        db.OnStatusChange(result.Email, result.PreviousStatus, result.Status)
+    }
+    
+    return result, nil
+}
+
+// LogWebhook stores the raw webhook data for debugging and returns the log ID.
+// In privacy mode the email is stored as its key and the payload redacted.
+func (db *Database) LogWebhook(email, status, source string, payload json.RawMessage) (int, error) {
+    return db.logWebhook(email, status, source, payload, "")
+}
+
+// LogDryRunWebhook logs a dry-run webhook, flagged so it's never retried
+// or counted as received
+func (db *Database) LogDryRunWebhook(email, status, source string, payload json.RawMessage) (int, error) {
+    return db.logWebhook(email, status, source, payload, webhookStateDryRun)
+}
+
+func (db *Database) logWebhook(email, status, source string, payload json.RawMessage, state string) (int, error) {
+    if db.Privacy != nil {
+        email = db.NormalizeEmail(email)
+        payload = db.redactPayload(payload)
+    }
+    
+    var id int
+    err := db.QueryRow(`
+        INSERT INTO webhook_logs (email, status, source, payload, state)
+        VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''))
+        RETURNING id
+    `, email, status, source, payload, state).Scan(&id)
+    return id, err
+}
+
+// GetStats returns membership statistics. The queries are abandoned if ctx
+// is cancelled.
+func (db *Database) GetStats(ctx context.Context) (*Stats, error) {
+    stats := Stats{CountedBy: "individuals"}
+    if db.CountHouseholds {
+        stats.CountedBy = "households"
+    }
+    counted := db.countedMembers()
     
-    err := db.QueryRow(`SELECT COUNT(*) FROM members`).Scan(&stats.TotalMembers)
+    statusRows, err := db.QueryContext(ctx, `SELECT status, COUNT(*) FROM members WHERE `+counted+` GROUP BY status`)
     if err != nil {
         return nil, err
     }
+    defer statusRows.Close()
     
-    err = db.QueryRow(`SELECT COUNT(*) FROM members WHERE status = 'active'`).Scan(&stats.ActiveMembers)
+    for statusRows.Next() {
+        var status string
+        var count int
+        if err := statusRows.Scan(&status, &count); err != nil {
+            return nil, err
+        }
+        stats.addStatusCount(status, count)
+    }
+    if err := statusRows.Err(); err != nil {
+        return nil, err
+    }
+    
+    frequencyRows, err := db.QueryContext(ctx, `
+        SELECT COALESCE(frequency, $1), COUNT(*) FROM members WHERE status = 'active' AND `+counted+` GROUP BY 1
+    `, frequencyUnknown)
+    if err != nil {
+        return nil, err
+    }
+    defer frequencyRows.Close()
+    
+    stats.ActiveByFrequency = map[string]int{}
+    for frequencyRows.Next() {
+        var frequency string
+        var count int
+        if err := frequencyRows.Scan(&frequency, &count); err != nil {
+            return nil, err
+        }
+        stats.ActiveByFrequency[frequency] = count
+    }
+    if err := frequencyRows.Err(); err != nil {
+        return nil, err
+    }
+    
+    err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM members WHERE is_anonymous = true AND `+counted).Scan(&stats.AnonymousMembers)
+    if err != nil {
+        return nil, err
+    }
+    
+    // Household members never pay themselves, so aren't overdue
+    err = db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM members
+        WHERE status = 'active' AND `+notDerived+`
+        AND COALESCE(last_payment_at, first_seen) < CURRENT_TIMESTAMP - INTERVAL '90 days'
+    `).Scan(&stats.OverduePaymentMembers)
     if err != nil {
         return nil, err
     }
     
-    err = db.QueryRow(`SELECT COUNT(*) FROM members WHERE status = 'cancelled'`).Scan(&stats.CancelledMembers)
+    err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM members WHERE failed_payment_count > 0`).Scan(&stats.FailedPaymentMembers)
     if err != nil {
         return nil, err
     }
     
-    err = db.QueryRow(`SELECT COUNT(*) FROM members WHERE is_anonymous = true`).Scan(&stats.AnonymousMembers)
+    rows, err := db.QueryContext(ctx, `
+        SELECT COALESCE(source, 'unknown'), COUNT(*) FROM webhook_logs
+        WHERE received_at > CURRENT_TIMESTAMP - INTERVAL '30 days'
+        AND state IS DISTINCT FROM $1
+        GROUP BY 1
+    `, webhookStateDryRun)
     if err != nil {
         return nil, err
     }
+    defer rows.Close()
+    
+    stats.WebhooksBySource = map[string]int{}
+    for rows.Next() {
+        var source string
+        var count int
+        if err := rows.Scan(&source, &count); err != nil {
+            return nil, err
+        }
+        stats.WebhooksBySource[source] = count
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
     
     return &stats, nil
 }
 
-// GetMembers returns a list of members, optionally filtered by status
-func (db *Database) GetMembers(statusFilter string, limit int) ([]map[string]interface{}, error) {
+// GetMembers returns the members matching the filter, most recently updated
+// first. Notes and Discord IDs are not loaded.
+func (db *Database) GetMembers(filter MemberFilter) ([]Member, error) {
+    var members []Member
+    err := db.EachMember(filter, func(m Member) error {
+        members = append(members, m)
+        return nil
+    })
+    return members, err
+}
+
+// EachMember calls fn with each member matching the filter, in GetMembers
+// order, without holding them all in memory. A zero Limit means no limit.
+func (db *Database) EachMember(filter MemberFilter, fn func(Member) error) error {
     query := `
-        SELECT email, name, is_anonymous, status, first_seen, last_updated
+        SELECT public_id, email, raw_email, name, is_anonymous, status, tags, first_seen, last_updated,
+               first_payment_at, last_payment_at, frequency, email_opt_in, consent_recorded_at, consent_source,
+               unsubscribe_token,
+               (SELECT o.name FROM org_members om JOIN organizations o ON o.id = om.organization_id
+                WHERE om.member_id = members.id AND o.status = 'active' ORDER BY o.id LIMIT 1),
+               campaign
         FROM members
     `
     args := []interface{}{}
+    conditions := []string{}
     
-    if statusFilter != "" {
-        query += " WHERE status = $1"
-        args = append(args, statusFilter)
+    if filter.Status == StatusActive {
+        conditions = append(conditions, `(status = 'active' OR EXISTS (
+            SELECT 1 FROM org_members om JOIN organizations o ON o.id = om.organization_id
+            WHERE om.member_id = members.id AND o.status = 'active'))`)
+    } else if filter.Status != "" {
+        args = append(args, filter.Status)
+        conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
     }
     
-    query += fmt.Sprintf(" ORDER BY last_updated DESC LIMIT %d", limit)
+    if filter.Tag != "" {
+        args = append(args, normalizeTag(filter.Tag))
+        conditions = append(conditions, fmt.Sprintf("$%d = ANY(tags)", len(args)))
+    }
+    
+    if filter.Frequency != "" {
+        args = append(args, filter.Frequency)
+        conditions = append(conditions, fmt.Sprintf("frequency = $%d", len(args)))
+    }
+    
+    if strings.EqualFold(filter.Campaign, campaignUnknown) {
+        conditions = append(conditions, "COALESCE(campaign, '') = ''")
+    } else if filter.Campaign != "" {
+        args = append(args, strings.TrimSpace(filter.Campaign))
+        conditions = append(conditions, fmt.Sprintf("lower(campaign) = lower($%d)", len(args)))
+    }
+    
+    if len(conditions) > 0 {
+        query += " WHERE " + strings.Join(conditions, " AND ")
+    }
+    
+    query += " ORDER BY last_updated DESC, id"
+    if filter.Limit > 0 {
+        query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+    }
+    query += fmt.Sprintf(" OFFSET %d", filter.Offset)
     
     rows, err := db.Query(query, args...)
     if err != nil {
-        return nil, err
+        return err
     }
     defer rows.Close()
     
-    var members []map[string]interface{}
     for rows.Next() {
-        var email, name, status sql.NullString
+        var m Member
         var isAnonymous sql.NullBool
+        var status sql.NullString
         var firstSeen, lastUpdated sql.NullTime
         
-        err := rows.Scan(&email, &name, &isAnonymous, &status, &firstSeen, &lastUpdated)
+        err := rows.Scan(&m.PublicID, &m.Email, &m.RawEmail, &m.Name, &isAnonymous, &status, pq.Array(&m.Tags), &firstSeen, &lastUpdated,
+            &m.FirstPaymentAt, &m.LastPaymentAt, &m.Frequency, &m.EmailOptIn, &m.ConsentRecordedAt, &m.ConsentSource,
+            &m.UnsubscribeToken, &m.Organization, &m.Campaign)
         if err != nil {
             continue
         }
+        m.IsAnonymous = isAnonymous.Bool
+        m.Status = status.String
+        m.FirstSeen = firstSeen.Time
+        m.LastUpdated = lastUpdated.Time
         
-        member := map[string]interface{}{
-            "email":        email.String,
-            "status":       status.String,
-            "is_anonymous": isAnonymous.Bool,
-            "first_seen":   firstSeen.Time,
-            "last_updated": lastUpdated.Time,
+        if err := fn(m); err != nil {
+            return err
         }
-        
-        if !isAnonymous.Bool && name.Valid {
-            member["name"] = name.String
+    }
+    
+    return rows.Err()
+}
+
+// GetMemberByEmail returns a single member, or ErrMemberNotFound
+func (db *Database) GetMemberByEmail(email string) (*Member, error) {
+    email = db.NormalizeEmail(email)
+    
+    var m Member
+    err := db.QueryRow(`
+        SELECT id, public_id, email, name, is_anonymous, status, notes, tags, first_seen, last_updated,
+               first_payment_at, last_payment_at, frequency, discord_id,
+               email_opt_in, consent_recorded_at, consent_source, unsubscribe_token, campaign
+        FROM members WHERE email = $1
+    `, email).Scan(&m.ID, &m.PublicID, &m.Email, &m.Name, &m.IsAnonymous, &m.Status,
+        &m.Notes, pq.Array(&m.Tags), &m.FirstSeen, &m.LastUpdated,
+        &m.FirstPaymentAt, &m.LastPaymentAt, &m.Frequency, &m.DiscordID,
+        &m.EmailOptIn, &m.ConsentRecordedAt, &m.ConsentSource, &m.UnsubscribeToken, &m.Campaign)
+    
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    } else if err != nil {
+        return nil, fmt.Errorf("database error: %w", err)
+    }
+    
+    return &m, nil
+}
+
+// GetMemberStatus returns a member's current status; existed is false when
+// there is no such member
+func (db *Database) GetMemberStatus(email string) (status string, existed bool, err error) {
+    err = db.QueryRow(`SELECT status FROM members WHERE email = $1`, db.NormalizeEmail(email)).Scan(&status)
+    if err == sql.ErrNoRows {
+        return "", false, nil
+    } else if err != nil {
+        return "", false, fmt.Errorf("database error: %w", err)
+    }
+    
+    return status, true, nil
+}
+
+// SyncMember is the member data pushed to external services
+type SyncMember struct {
+    Email       string
+    Name        string
+    IsAnonymous bool
+    Status      string
+    DiscordID   string
+    
+    // OptedOut members have declined email, whatever their status
+    OptedOut bool
+}
+
+// GetSyncMembers returns every member with the fields integrations need. In
+// encrypt privacy mode addresses are decrypted; otherwise in privacy mode,
+// and for members with no stored address, Email is the member's key.
+func (db *Database) GetSyncMembers() ([]SyncMember, error) {
+    rows, err := db.Query(`
+        SELECT email, COALESCE(name, ''), is_anonymous, status, COALESCE(discord_id, ''),
+               COALESCE(email_ciphertext, ''), email_opt_in IS FALSE
+        FROM members ORDER BY email
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    
+    var members []SyncMember
+    for rows.Next() {
+        var m SyncMember
+        var ciphertext string
+        if err := rows.Scan(&m.Email, &m.Name, &m.IsAnonymous, &m.Status, &m.DiscordID, &ciphertext, &m.OptedOut); err != nil {
+            return nil, err
         }
-        
-        members = append(members, member)
+        if ciphertext != "" && db.Privacy != nil && db.Privacy.CanDecrypt() {
+            email, err := db.Privacy.Open(ciphertext)
+            if err != nil {
+                return nil, err
+            }
+            m.Email = email
+        }
+        members = append(members, m)
     }
     
-    return members, nil
+    return members, rows.Err()
 }
 
-// GetAllMemberStatuses returns a map of email -> status for all members
+// GetAllMemberStatuses returns a map of email -> status for all members,
+// loaded in chunks of memberChunkSize by id so large tables don't hold one
+// huge result set open
 func (db *Database) GetAllMemberStatuses() (map[string]string, error) {
-    rows, err := db.Query(`SELECT email, status FROM members`)
+    members := make(map[string]string)
+    lastID := 0
+    
+    for {
+        rows, err := db.Query(`
+            SELECT id, email, status FROM members WHERE id > $1 ORDER BY id LIMIT $2
+        `, lastID, memberChunkSize)
+        if err != nil {
+            return nil, err
+        }
+        
+        count := 0
+        for rows.Next() {
+            var email, status string
+            if err := rows.Scan(&lastID, &email, &status); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            members[strings.ToLower(email)] = status
+            count++
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+        
+        if count < memberChunkSize {
+            return members, nil
+        }
+    }
+}
+
+// GetLastActivityTimes returns a map of email -> last payment time, falling
+// back to last_updated for members with no recorded payment
+func (db *Database) GetLastActivityTimes() (map[string]time.Time, error) {
+    rows, err := db.Query(`SELECT email, COALESCE(last_payment_at, last_updated) FROM members`)
     if err != nil {
         return nil, err
     }
     defer rows.Close()
     
-    members := make(map[string]string)
+    times := make(map[string]time.Time)
     for rows.Next() {
-        var email, status string
-        if err := rows.Scan(&email, &status); err != nil {
-            continue
+        var email string
+        var t time.Time
+        if err := rows.Scan(&email, &t); err != nil {
+            return nil, err
         }
-        members[strings.ToLower(email)] = status
+        times[strings.ToLower(email)] = t
     }
     
-    return members, nil
+    return times, rows.Err()
 }
 
-// UpdateMemberStatus updates just the status for a member
-func (db *Database) UpdateMemberStatus(email, status string) error {
-    email = strings.ToLower(strings.TrimSpace(email))
+// UpdateMemberStatus updates the status and records what caused it in
+// status_history
+func (db *Database) UpdateMemberStatus(email, status string, change ChangeSource) error {
+    update := func(tx *sql.Tx) error {
+        return db.updateMemberStatus(tx, email, status, change)
+    }
+    if db.OnStatusChange == nil {
+        return db.inTx(update)
+    }
     
-    result, err := db.Exec(`
+    previous, _, err := db.GetMemberStatus(email)
+    if err != nil {
+        return err
+    }
+    if err := db.inTx(update); err != nil {
+        return err
+    }
+    if previous != status {
+        db.OnStatusChange(db.NormalizeEmail(email), previous, status)
+    }
+    
+    return nil
+}
+
+func (db *Database) updateMemberStatus(q querier, email, status string, change ChangeSource) error {
+    email = db.NormalizeEmail(email)
+    
+    var memberID int
+    var currentStatus string
+    var household sql.NullInt64
+    err := q.QueryRow(`SELECT id, status, household_id FROM members WHERE email = $1`, email).Scan(&memberID, &currentStatus, &household)
+    if err == sql.ErrNoRows {
+        return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    } else if err != nil {
+        return err
+    }
+    if household.Valid && int(household.Int64) != memberID && change.Source != householdSource {
+        return fmt.Errorf("%w: %s follows member #%d; unlink it to change its status", ErrHouseholdMember, email, household.Int64)
+    }
+    
+    if err := db.checkTransition(email, currentStatus, status, change); err != nil {
+        return err
+    }
+    
+    _, err = q.Exec(`
         UPDATE members 
         SET status = $1, last_updated = CURRENT_TIMESTAMP
-        WHERE email = $2
-    `, status, email)
+        WHERE id = $2
+    `, status, memberID)
     
     if err != nil {
         return err
     }
     
-    rows, _ := result.RowsAffected()
-    if rows == 0 {
-        return fmt.Errorf("member not found: %s", email)
+    // Record status change in history
+    recordStatusHistory(q, memberID, status, change)
+    
+    if currentStatus != status {
+        if err := recordStatusEvent(q, memberID, email, currentStatus, status, change); err != nil {
+            return err
+        }
+        return followHousehold(q, int64(memberID))
     }
     
-    // Record status change in history
-    var memberID int
-    db.QueryRow(`SELECT id FROM members WHERE email = $1`, email).Scan(&memberID)
-    if memberID > 0 {
-        db.Exec(`
-            INSERT INTO status_history (member_id, status)
-            VALUES ($1, $2)
-        `, memberID, status)
+    return nil
+}
+
+// BulkUpdateStatus sets many members to one status in batches, recording
+// history and events for each, and returns the number updated. All batches
+// run in one transaction.
+func (db *Database) BulkUpdateStatus(emails []string, status string, change ChangeSource) (int, error) {
+    var updated int
+    err := db.inTx(func(tx *sql.Tx) error {
+        changes, err := db.bulkUpdateStatus(tx, emails, status, change)
+        updated = len(changes)
+        return err
+    })
+    return updated, err
+}
+
+// bulkChange is one member's transition made by bulkUpdateStatus
+type bulkChange struct {
+    MemberID int
+    Email    string
+    Before   string
+}
+
+// bulkUpdateStatus is BulkUpdateStatus against q. Every email must exist.
+func (db *Database) bulkUpdateStatus(q querier, emails []string, status string, change ChangeSource) ([]bulkChange, error) {
+    var changes []bulkChange
+    
+    for start := 0; start < len(emails); start += statusBatchSize {
+        var batch []string
+        for _, email := range emails[start:min(start+statusBatchSize, len(emails))] {
+            batch = append(batch, db.NormalizeEmail(email))
+        }
+        
+        rows, err := q.Query(`
+            SELECT id, email, status, household_id FROM members WHERE email = ANY($1) FOR UPDATE
+        `, pq.Array(batch))
+        if err != nil {
+            return nil, fmt.Errorf("failed to load members: %w", err)
+        }
+        
+        found := make(map[string]bool, len(batch))
+        var ids []int64
+        var batchEmails, befores []string
+        for rows.Next() {
+            var c bulkChange
+            var household sql.NullInt64
+            if err := rows.Scan(&c.MemberID, &c.Email, &c.Before, &household); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            if household.Valid && int(household.Int64) != c.MemberID {
+                rows.Close()
+                return nil, fmt.Errorf("%w: %s follows member #%d", ErrHouseholdMember, c.Email, household.Int64)
+            }
+            found[c.Email] = true
+            if err := db.checkTransition(c.Email, c.Before, status, change); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            changes = append(changes, c)
+            ids = append(ids, int64(c.MemberID))
+            batchEmails = append(batchEmails, c.Email)
+            befores = append(befores, c.Before)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+        
+        for _, email := range batch {
+            if !found[email] {
+                return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+            }
+        }
+        
+        _, err = q.Exec(`
+            UPDATE members SET status = $2, last_updated = CURRENT_TIMESTAMP WHERE id = ANY($1)
+        `, pq.Array(ids), status)
+        if err != nil {
+            return nil, fmt.Errorf("failed to update members: %w", err)
+        }
+        
+        _, err = q.Exec(`
+            INSERT INTO status_history (member_id, status, source, detail)
+            SELECT id, $2, COALESCE(NULLIF($3, ''), 'unknown'), NULLIF($4, '')
+            FROM unnest($1::int[]) AS id
+        `, pq.Array(ids), status, change.Source, change.Detail)
+        if err != nil {
+            return nil, fmt.Errorf("failed to record status history: %w", err)
+        }
+        
+        _, err = q.Exec(`
+            INSERT INTO events (type, member_id, source, detail, payload)
+            SELECT $4, t.id, COALESCE(NULLIF($6, ''), 'unknown'), NULLIF($7, ''),
+                   jsonb_build_object('email', t.email, 'old_status', t.before, 'new_status', $5::text)
+            FROM unnest($1::int[], $2::text[], $3::text[]) AS t(id, email, before)
+            WHERE t.before <> $5
+        `, pq.Array(ids), pq.Array(batchEmails), pq.Array(befores), feedStatusChanged, status, change.Source, change.Detail)
+        if err != nil {
+            return nil, fmt.Errorf("failed to record events: %w", err)
+        }
+        
+        if err := followHousehold(q, ids...); err != nil {
+            return nil, err
+        }
+    }
+    
+    return changes, nil
+}
+
+// SetMemberFrequency records a member's recurring donation frequency,
+// normalized, keeping the value as given in frequency_raw
+func (db *Database) SetMemberFrequency(email, frequency string) error {
+    return db.setMemberFrequency(db.DB, email, frequency)
+}
+
+func (db *Database) setMemberFrequency(q querier, email, frequency string) error {
+    email = db.NormalizeEmail(email)
+    
+    _, err := q.Exec(`
+        UPDATE members SET frequency = NULLIF($2, ''), frequency_raw = NULLIF($3, '') WHERE email = $1
+    `, email, normalizeFrequency(frequency), strings.TrimSpace(frequency))
+    if err != nil {
+        return fmt.Errorf("failed to set frequency: %w", err)
     }
     
     return nil
@@ -285,7 +1108,7 @@ func (db *Database) GetRecentMembers(limit int) ([]map[string]interface{}, error
         member := map[string]interface{}{
             "email":        email.String,
             "status":       status.String,
-            "last_updated": lastUpdated.Time.Format("2006-01-02 15:04:05"),
+            "last_updated": displayTime(lastUpdated.Time),
         }
         
         if name.Valid && name.String != "" {
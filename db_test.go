@@ -0,0 +1,115 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestProcessResultActions(t *testing.T) {
+    checkProcessResultActions(t, newMemStore(), "example.org")
+}
+
+func TestProcessResultActionsPostgres(t *testing.T) {
+    checkProcessResultActions(t, openTestDatabase(t), pgTestDomain)
+}
+
+func checkProcessResultActions(t *testing.T, db Store, domain string) {
+    change := ChangeSource{Source: "test"}
+
+    steps := []struct {
+        name      string
+        email     string
+        who       string
+        anonymous bool
+        status    string
+
+        action, previous, wantStatus string
+        changed                      bool
+    }{
+        {"new member", "ada@" + domain, "Ada Lovelace", false, StatusActive, actionCreated, "", StatusActive, true},
+        {"same status", "ADA@" + domain, "", false, StatusActive, actionUnchanged, StatusActive, StatusActive, false},
+        {"status change", "ada@" + domain, "", false, StatusCancelled, actionUpdated, StatusActive, StatusCancelled, true},
+        {"back again", "ada@" + domain, "", false, StatusActive, actionUpdated, StatusCancelled, StatusActive, true},
+        {"new anonymous member", "anon@" + domain, "Secret Donor", true, StatusSuspended, actionCreated, "", StatusSuspended, true},
+    }
+    for _, step := range steps {
+        result, err := db.ProcessMember(step.email, step.who, step.anonymous, step.status, change)
+        if err != nil {
+            t.Fatalf("%s: %v", step.name, err)
+        }
+        if result.Action != step.action || result.PreviousStatus != step.previous || result.Status != step.wantStatus ||
+            result.Changed() != step.changed || result.Email != normalizeEmail(step.email, false) || result.MemberID == "" {
+            t.Errorf("%s: got %+v", step.name, result)
+        }
+    }
+
+    if m, err := db.GetMemberByEmail("anon@" + domain); err != nil || m.Name.String != "" || !m.IsAnonymous {
+        t.Errorf("a new anonymous member was stored with name %q", m.Name.String)
+    }
+}
+
+func TestProcessMemberKeepsNames(t *testing.T) {
+    checkProcessMemberKeepsNames(t, newMemStore(), "ada@example.org")
+}
+
+func TestProcessMemberKeepsNamesPostgres(t *testing.T) {
+    checkProcessMemberKeepsNames(t, openTestDatabase(t), "ada@"+pgTestDomain)
+}
+
+func checkProcessMemberKeepsNames(t *testing.T, db Store, email string) {
+    change := ChangeSource{Source: "test"}
+    process := func(name string, anonymous bool) *ProcessResult {
+        t.Helper()
+        result, err := db.ProcessMember(email, name, anonymous, StatusActive, change)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return result
+    }
+    member := func() *Member {
+        t.Helper()
+        m, err := db.GetMemberByEmail(email)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return m
+    }
+    name := func() string { return member().Name.String }
+
+    process("Ada Lovelace", false)
+
+    // Going anonymous hides the name but doesn't erase it, and a name sent
+    // with an anonymous gift doesn't replace it
+    if result := process("Someone Else", true); !result.IsAnonymous || result.Action != actionUnchanged {
+        t.Errorf("anonymous gift: got %+v", result)
+    }
+    if name() != "Ada Lovelace" || !member().IsAnonymous {
+        t.Errorf("after an anonymous gift: name %q, anonymous %v", name(), member().IsAnonymous)
+    }
+    if memberResponse(member())["name"] != nil {
+        t.Error("an anonymous member's name is shown")
+    }
+
+    // A blank name keeps the one on file; a new one replaces it
+    if process("", false); name() != "Ada Lovelace" || member().IsAnonymous {
+        t.Errorf("after a blank name: name %q", name())
+    }
+    if process("Ada King", false); name() != "Ada King" {
+        t.Errorf("after a new name: name %q", name())
+    }
+}
+
+func TestProcessResultForHouseholdMember(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, map[string]string{"ada@example.org": StatusActive, "charles@example.org": StatusCancelled})
+    if _, err := db.LinkHousehold("ada@example.org", "charles@example.org", "", "test"); err != nil {
+        t.Fatal(err)
+    }
+
+    result, err := db.ProcessMember("charles@example.org", "", false, StatusCancelled, ChangeSource{Source: "webhook"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Status != StatusActive || result.PreviousStatus != StatusActive || result.Action != actionUnchanged {
+        t.Errorf("household member: got %+v, want left active with the household", result)
+    }
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "net/http"
+    "net/http/pprof"
+    "runtime"
+    "time"
+)
+
+// registerDebug mounts the pprof handlers and /debug/vars behind the admin
+// token. It's only called with DEBUG_ENDPOINTS=true.
+func (s *WebhookServer) registerDebug() {
+    admin := func(h http.HandlerFunc) http.HandlerFunc {
+        return s.loggingMiddleware(s.adminMiddleware(h))
+    }
+
+    s.mux.HandleFunc("GET /debug/pprof/", admin(pprof.Index))
+    s.mux.HandleFunc("GET /debug/pprof/cmdline", admin(pprof.Cmdline))
+    s.mux.HandleFunc("GET /debug/pprof/profile", admin(pprof.Profile))
+    s.mux.HandleFunc("GET /debug/pprof/symbol", admin(pprof.Symbol))
+    s.mux.HandleFunc("GET /debug/pprof/trace", admin(pprof.Trace))
+    s.mux.HandleFunc("GET /debug/vars", admin(s.debugVarsHandler))
+
+    s.logger.Printf("Debug endpoints enabled under /debug")
+}
+
+// pooledStore is a Store backed by a connection pool, as *Database is
+type pooledStore interface {
+    Stats() sql.DBStats
+}
+
+// debugVarsHandler reports goroutines, heap usage, GC activity, and the
+// database connection pool, if the store has one
+func (s *WebhookServer) debugVarsHandler(w http.ResponseWriter, r *http.Request) {
+    var mem runtime.MemStats
+    runtime.ReadMemStats(&mem)
+
+    response := map[string]interface{}{
+        "goroutines": runtime.NumGoroutine(),
+        "heap": map[string]interface{}{
+            "alloc_bytes":    mem.HeapAlloc,
+            "sys_bytes":      mem.HeapSys,
+            "idle_bytes":     mem.HeapIdle,
+            "released_bytes": mem.HeapReleased,
+            "objects":        mem.HeapObjects,
+        },
+        "gc": map[string]interface{}{
+            "runs":           mem.NumGC,
+            "pause_total_ms": float64(mem.PauseTotalNs) / float64(time.Millisecond),
+            "next_gc_bytes":  mem.NextGC,
+        },
+        "in_flight":          s.inFlight(),
+        "panics":             s.panics.Load(),
+        "access_log_dropped": s.accessLog.dropped.Load(),
+    }
+    if pooled, ok := s.db.(pooledStore); ok {
+        pool := pooled.Stats()
+        response["db_pool"] = map[string]interface{}{
+            "max_open":            pool.MaxOpenConnections,
+            "open":                pool.OpenConnections,
+            "in_use":              pool.InUse,
+            "idle":                pool.Idle,
+            "wait_count":          pool.WaitCount,
+            "wait_duration_ms":    pool.WaitDuration.Milliseconds(),
+            "max_idle_closed":     pool.MaxIdleClosed,
+            "max_lifetime_closed": pool.MaxLifetimeClosed,
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
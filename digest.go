@@ -0,0 +1,319 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+)
+
+// digestCheckInterval is how often the server checks whether the daily
+// digest is due
+const digestCheckInterval = 5 * time.Minute
+
+// digestSentKey is the settings row holding the last day a digest covered,
+// so a restart doesn't send it twice
+const digestSentKey = "digest_last_sent"
+
+// DigestChange is one status change in a digest
+type DigestChange struct {
+    Email     string
+    Status    string
+    Previous  string
+    ChangedAt time.Time
+}
+
+// Digest summarizes one day of membership activity
+type Digest struct {
+    Date  time.Time
+    Start time.Time
+    End   time.Time
+
+    NewMembers   []DigestChange
+    Cancelled    []DigestChange
+    Reactivated  []DigestChange
+    OtherChanges []DigestChange
+
+    WebhooksReceived int
+    WebhooksFailed   int
+    WebhooksRetrying int
+
+    Stats *Stats
+}
+
+// digestWindow returns the start and end of day in the display zone
+func digestWindow(day time.Time) (start, end time.Time) {
+    day = day.In(displayZone)
+    start = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, displayZone)
+    return start, start.AddDate(0, 0, 1)
+}
+
+// BuildDigest gathers the status changes and webhook failures between start
+// and end, and the current totals
+func (db *Database) BuildDigest(start, end time.Time) (*Digest, error) {
+    digest := &Digest{Date: start, Start: start, End: end}
+
+    // LAG over each changed member's whole history gives the status each
+    // change in the window moved from
+    rows, err := db.Query(`
+        SELECT m.email, h.status, COALESCE(h.previous, ''), h.changed_at
+        FROM (
+            SELECT member_id, status, changed_at, id,
+                   LAG(status) OVER (PARTITION BY member_id ORDER BY changed_at, id) AS previous
+            FROM status_history
+            WHERE member_id IN (
+                SELECT member_id FROM status_history WHERE changed_at >= $1 AND changed_at < $2
+            )
+        ) h
+        JOIN members m ON m.id = h.member_id
+        WHERE h.changed_at >= $1 AND h.changed_at < $2
+        ORDER BY h.changed_at, h.id
+    `, start, end)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load status changes: %w", err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var c DigestChange
+        if err := rows.Scan(&c.Email, &c.Status, &c.Previous, &c.ChangedAt); err != nil {
+            return nil, err
+        }
+        eventType, ok := memberEventFor(c.Previous != "", c.Previous, c.Status)
+        switch {
+        case eventType == eventCreated:
+            digest.NewMembers = append(digest.NewMembers, c)
+        case eventType == eventCancelled:
+            digest.Cancelled = append(digest.Cancelled, c)
+        case eventType == eventReactivated:
+            digest.Reactivated = append(digest.Reactivated, c)
+        case !ok && c.Previous != c.Status:
+            digest.OtherChanges = append(digest.OtherChanges, c)
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    err = db.QueryRow(`
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE state = $3),
+               COUNT(*) FILTER (WHERE state = $4)
+        FROM webhook_logs
+        WHERE received_at >= $1 AND received_at < $2 AND state IS DISTINCT FROM $5
+    `, start, end, webhookStateFailed, webhookStatePending, webhookStateDryRun).Scan(&digest.WebhooksReceived, &digest.WebhooksFailed, &digest.WebhooksRetrying)
+    if err != nil {
+        return nil, fmt.Errorf("failed to count webhooks: %w", err)
+    }
+
+    if digest.Stats, err = db.GetStats(context.Background()); err != nil {
+        return nil, fmt.Errorf("failed to get stats: %w", err)
+    }
+
+    return digest, nil
+}
+
+// Empty reports whether nothing worth mentioning happened
+func (d *Digest) Empty() bool {
+    return len(d.NewMembers) == 0 && len(d.Cancelled) == 0 && len(d.Reactivated) == 0 &&
+        len(d.OtherChanges) == 0 && d.WebhooksFailed == 0 && d.WebhooksRetrying == 0
+}
+
+// Subject is the digest email's subject line
+func (d *Digest) Subject() string {
+    return fmt.Sprintf("Membership digest for %s: %d new, %d cancelled, %d reactivated",
+        d.Date.Format("2006-01-02"), len(d.NewMembers), len(d.Cancelled), len(d.Reactivated))
+}
+
+// Text is the plain-text digest
+func (d *Digest) Text() string {
+    var b strings.Builder
+
+    fmt.Fprintf(&b, "Membership activity for %s (%s)\n", d.Date.Format("Monday, January 2, 2006"), displayZone)
+
+    section := func(title string, changes []DigestChange, showPrevious bool) {
+        fmt.Fprintf(&b, "\n%s (%d)\n", title, len(changes))
+        if len(changes) == 0 {
+            b.WriteString("  none\n")
+        }
+        for _, c := range changes {
+            if showPrevious {
+                fmt.Fprintf(&b, "  %s  %s: %s -> %s\n", c.ChangedAt.In(displayZone).Format("15:04"), c.Email, c.Previous, c.Status)
+            } else {
+                fmt.Fprintf(&b, "  %s  %s\n", c.ChangedAt.In(displayZone).Format("15:04"), c.Email)
+            }
+        }
+    }
+    section("New members", d.NewMembers, false)
+    section("Cancellations", d.Cancelled, false)
+    section("Reactivations", d.Reactivated, true)
+    if len(d.OtherChanges) > 0 {
+        section("Other status changes", d.OtherChanges, true)
+    }
+
+    fmt.Fprintf(&b, "\nWebhooks: %d received, %d failed permanently, %d awaiting retry\n",
+        d.WebhooksReceived, d.WebhooksFailed, d.WebhooksRetrying)
+    if d.WebhooksFailed > 0 {
+        b.WriteString("  Reprocess them with: memberships retry-failed\n")
+    }
+
+    s := d.Stats
+    b.WriteString("\nCurrent totals\n")
+    fmt.Fprintf(&b, "  Total:     %d\n", s.TotalMembers)
+    fmt.Fprintf(&b, "  Active:    %d\n", s.ActiveMembers)
+    fmt.Fprintf(&b, "  Suspended: %d\n", s.SuspendedMembers)
+    fmt.Fprintf(&b, "  Lapsed:    %d\n", s.LapsedMembers)
+    fmt.Fprintf(&b, "  Cancelled: %d\n", s.CancelledMembers)
+
+    return b.String()
+}
+
+// LastDigestDate returns the day, as YYYY-MM-DD, the last scheduled digest
+// covered, or "" if none has been sent
+func (db *Database) LastDigestDate() (string, error) {
+    var value string
+    err := db.QueryRow(`SELECT value FROM settings WHERE key = $1`, digestSentKey).Scan(&value)
+    if err == sql.ErrNoRows {
+        return "", nil
+    } else if err != nil {
+        return "", fmt.Errorf("failed to read last digest date: %w", err)
+    }
+    return value, nil
+}
+
+// RecordDigestSent notes that the scheduled digest for date is done
+func (db *Database) RecordDigestSent(date string) error {
+    _, err := db.Exec(`
+        INSERT INTO settings (key, value) VALUES ($1, $2)
+        ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP
+    `, digestSentKey, date)
+    if err != nil {
+        return fmt.Errorf("failed to record digest: %w", err)
+    }
+    return nil
+}
+
+// digestJob emails yesterday's digest to DIGEST_TO once DIGEST_TIME has
+// passed each day, skipping days when nothing happened
+func (s *WebhookServer) digestJob() *Job {
+    if len(s.config.DigestTo) == 0 {
+        return nil
+    }
+    mailer, err := NewMailer(s.config)
+    if err != nil {
+        s.logger.Printf("Digest job disabled: %v", err)
+        return nil
+    }
+
+    check := func() (string, error) {
+        now := time.Now().In(displayZone)
+        today, _ := digestWindow(now)
+        if now.Before(today.Add(s.config.DigestTime)) {
+            return "", nil
+        }
+
+        yesterday, end := digestWindow(today.AddDate(0, 0, -1))
+        date := yesterday.Format("2006-01-02")
+        last, err := s.db.LastDigestDate()
+        if err != nil {
+            return "", err
+        }
+        if last >= date {
+            return "", nil
+        }
+
+        digest, err := s.db.BuildDigest(yesterday, end)
+        if err != nil {
+            return "", err
+        }
+        result := "sent digest for " + date
+        if digest.Empty() {
+            s.logger.Printf("No membership activity on %s; digest not sent", date)
+            result = "no activity on " + date
+        } else if err := mailer.Send(s.config.DigestTo, digest.Subject(), digest.Text()); err != nil {
+            return "", err
+        } else {
+            s.logger.Printf("Sent digest for %s to %s", date, strings.Join(s.config.DigestTo, ", "))
+        }
+
+        return result, s.db.RecordDigestSent(date)
+    }
+
+    return &Job{
+        Name:     jobDigest,
+        Interval: digestCheckInterval,
+        Schedule: fmt.Sprintf("daily after %02d:%02d", int(s.config.DigestTime.Hours()), int(s.config.DigestTime.Minutes())%60),
+        AtStart:  true,
+        run:      check,
+    }
+}
+
+func runDigest(logger *log.Logger) {
+    digestCmd := flag.NewFlagSet("digest", flag.ExitOnError)
+    to := digestCmd.String("to", "", "Comma-separated recipients (default: DIGEST_TO)")
+    date := digestCmd.String("date", "", "Day to summarize, YYYY-MM-DD (default: yesterday)")
+    always := digestCmd.Bool("always", false, "Send even when nothing happened")
+    print := digestCmd.Bool("print", false, "Print the digest instead of emailing it")
+
+    parseSubcommand(digestCmd, "memberships digest [--to addr,...] [--date YYYY-MM-DD] [--always] [--print]", os.Args[2:])
+
+    config := mustLoadConfig(logger)
+
+    day := time.Now().In(displayZone).AddDate(0, 0, -1)
+    if *date != "" {
+        parsed, err := time.ParseInLocation("2006-01-02", *date, displayZone)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: --date must be YYYY-MM-DD, got %q\n", *date)
+            os.Exit(2)
+        }
+        day = parsed
+    }
+
+    recipients := config.DigestTo
+    if *to != "" {
+        var err error
+        if recipients, err = parseAddressList(*to); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: --to: %v\n", err)
+            os.Exit(2)
+        }
+    }
+
+    var mailer *Mailer
+    if !*print {
+        if len(recipients) == 0 {
+            fmt.Fprintln(os.Stderr, "Error: no recipients; pass --to or set DIGEST_TO")
+            os.Exit(2)
+        }
+        var err error
+        if mailer, err = NewMailer(config); err != nil {
+            logger.Fatalf("Cannot send digest: %v", err)
+        }
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    start, end := digestWindow(day)
+    digest, err := db.BuildDigest(start, end)
+    if err != nil {
+        logger.Fatalf("Failed to build digest: %v", err)
+    }
+
+    if digest.Empty() && !*always {
+        logger.Printf("No membership activity on %s; nothing to send (use --always to send anyway)", start.Format("2006-01-02"))
+        return
+    }
+
+    if *print {
+        fmt.Printf("Subject: %s\n\n%s", digest.Subject(), digest.Text())
+        return
+    }
+    if err := mailer.Send(recipients, digest.Subject(), digest.Text()); err != nil {
+        logger.Fatalf("Failed to send digest: %v", err)
+    }
+    logger.Printf("Sent digest for %s to %s", start.Format("2006-01-02"), strings.Join(recipients, ", "))
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// discordAPI is the base URL of the Discord REST API
+const discordAPI = "https://discord.com/api/v10"
+
+// discordMaxRetries bounds retries on rate-limited requests
+const discordMaxRetries = 5
+
+// errDiscordNotInGuild means the linked user isn't in the server
+var errDiscordNotInGuild = errors.New("user is not in the Discord server")
+
+// validDiscordID reports whether s looks like a Discord snowflake ID
+func validDiscordID(s string) bool {
+    if len(s) < 15 || len(s) > 20 {
+        return false
+    }
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
+
+// SetDiscordID links a member to a Discord user; an empty id unlinks them
+func (db *Database) SetDiscordID(email, discordID string) error {
+    email = db.NormalizeEmail(email)
+
+    result, err := db.Exec(`
+        UPDATE members SET discord_id = NULLIF($2, '') WHERE email = $1
+    `, email, strings.TrimSpace(discordID))
+    if err != nil {
+        return fmt.Errorf("failed to set Discord ID: %w", err)
+    }
+
+    rows, _ := result.RowsAffected()
+    if rows == 0 {
+        return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    }
+
+    return nil
+}
+
+// DiscordClient manages one role in one Discord server with a bot token
+type DiscordClient struct {
+    token   string
+    guildID string
+    roleID  string
+    client  *http.Client
+    logger  *log.Logger
+}
+
+// NewDiscordClient builds a client from the configuration
+func NewDiscordClient(config *Config, logger *log.Logger) (*DiscordClient, error) {
+    if config.DiscordBotToken == "" || config.DiscordGuildID == "" || config.DiscordRoleID == "" {
+        return nil, fmt.Errorf("DISCORD_BOT_TOKEN, DISCORD_GUILD_ID, and DISCORD_ROLE_ID are required")
+    }
+
+    return &DiscordClient{
+        token:   config.DiscordBotToken,
+        guildID: config.DiscordGuildID,
+        roleID:  config.DiscordRoleID,
+        client:  &http.Client{Timeout: 15 * time.Second},
+        logger:  logger,
+    }, nil
+}
+
+// do sends a request, waiting out 429 rate limits. out may be nil.
+func (c *DiscordClient) do(method, path string, out interface{}) error {
+    for attempt := 0; ; attempt++ {
+        req, err := http.NewRequest(method, discordAPI+path, nil)
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Authorization", "Bot "+c.token)
+        req.Header.Set("X-Audit-Log-Reason", "memberships role sync")
+
+        resp, err := c.client.Do(req)
+        if err != nil {
+            return err
+        }
+        body, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            return err
+        }
+
+        if resp.StatusCode == http.StatusTooManyRequests && attempt < discordMaxRetries {
+            var limit struct {
+                RetryAfter float64 `json:"retry_after"`
+            }
+            json.Unmarshal(body, &limit)
+            wait := time.Duration(limit.RetryAfter*float64(time.Second)) + 100*time.Millisecond
+            c.logger.Printf("Discord rate limited, retrying in %v", wait)
+            time.Sleep(wait)
+            continue
+        }
+
+        if resp.StatusCode == http.StatusNotFound {
+            return errDiscordNotInGuild
+        }
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+            return fmt.Errorf("discord %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+        }
+
+        if out != nil && len(body) > 0 {
+            return json.Unmarshal(body, out)
+        }
+        return nil
+    }
+}
+
+// HasRole reports whether a guild member currently has the managed role
+func (c *DiscordClient) HasRole(userID string) (bool, error) {
+    var member struct {
+        Roles []string `json:"roles"`
+    }
+    if err := c.do(http.MethodGet, fmt.Sprintf("/guilds/%s/members/%s", c.guildID, userID), &member); err != nil {
+        return false, err
+    }
+    for _, role := range member.Roles {
+        if role == c.roleID {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// SetRole grants or revokes the managed role
+func (c *DiscordClient) SetRole(userID string, grant bool) error {
+    method := http.MethodDelete
+    if grant {
+        method = http.MethodPut
+    }
+    return c.do(method, fmt.Sprintf("/guilds/%s/members/%s/roles/%s", c.guildID, userID, c.roleID), nil)
+}
+
+// DiscordSyncResult summarizes a role sync
+type DiscordSyncResult struct {
+    Granted    []string
+    Revoked    []string
+    Unchanged  int
+    Unlinked   int
+    NotInGuild []string
+    Errors     map[string]string
+}
+
+// SyncDiscordRoles grants the role to active members and revokes it from
+// cancelled and lapsed ones. Members without a linked Discord ID are skipped,
+// and an error for one user doesn't stop the others.
+func SyncDiscordRoles(db Store, client *DiscordClient, dryRun bool) (*DiscordSyncResult, error) {
+    members, err := db.GetSyncMembers()
+    if err != nil {
+        return nil, fmt.Errorf("failed to get members: %w", err)
+    }
+
+    result := &DiscordSyncResult{Errors: map[string]string{}}
+
+    for _, m := range members {
+        var want bool
+        switch m.Status {
+        case "active":
+            want = true
+        case "cancelled", "lapsed":
+            want = false
+        default:
+            continue
+        }
+
+        if m.DiscordID == "" {
+            result.Unlinked++
+            continue
+        }
+
+        has, err := client.HasRole(m.DiscordID)
+        if errors.Is(err, errDiscordNotInGuild) {
+            result.NotInGuild = append(result.NotInGuild, m.Email)
+            continue
+        } else if err != nil {
+            result.Errors[m.Email] = err.Error()
+            continue
+        }
+
+        if has == want {
+            result.Unchanged++
+            continue
+        }
+
+        if !dryRun {
+            if err := client.SetRole(m.DiscordID, want); err != nil {
+                result.Errors[m.Email] = err.Error()
+                continue
+            }
+        }
+
+        if want {
+            result.Granted = append(result.Granted, m.Email)
+        } else {
+            result.Revoked = append(result.Revoked, m.Email)
+        }
+    }
+
+    return result, nil
+}
+
+// discordJob periodically syncs Discord roles in server mode
+func (s *WebhookServer) discordJob() *Job {
+    if s.config.DiscordSyncInterval <= 0 {
+        return nil
+    }
+
+    client, err := NewDiscordClient(s.config, s.logger)
+    if err != nil {
+        s.logger.Printf("Discord sync job disabled: %v", err)
+        return nil
+    }
+
+    return &Job{
+        Name:     jobDiscord,
+        Interval: s.config.DiscordSyncInterval,
+        run: func() (string, error) {
+            result, err := SyncDiscordRoles(s.db, client, false)
+            if err != nil {
+                return "", err
+            }
+            summary := fmt.Sprintf("%d granted, %d revoked, %d errors",
+                len(result.Granted), len(result.Revoked), len(result.Errors))
+            s.logger.Printf("Discord sync job: %s", summary)
+            return summary, nil
+        },
+    }
+}
+
+func runSyncDiscord(args []string, logger *log.Logger) {
+    discordCmd := flag.NewFlagSet("sync discord", flag.ExitOnError)
+    dryRun := discordCmd.Bool("dry-run", false, "Show role changes without making them")
+
+    parseSubcommand(discordCmd, "memberships sync discord [--dry-run]", args)
+
+    client, err := NewDiscordClient(mustLoadConfig(logger), logger)
+    if err != nil {
+        logger.Fatalf("Discord sync failed: %v", err)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    result, err := SyncDiscordRoles(db, client, *dryRun)
+    if err != nil {
+        logger.Fatalf("Discord sync failed: %v", err)
+    }
+
+    for _, email := range result.Granted {
+        fmt.Printf("  grant  %s\n", email)
+    }
+    for _, email := range result.Revoked {
+        fmt.Printf("  revoke %s\n", email)
+    }
+    for _, email := range result.NotInGuild {
+        fmt.Printf("  absent %s (not in the Discord server)\n", email)
+    }
+    for email, msg := range result.Errors {
+        fmt.Printf("  error  %s: %s\n", email, msg)
+    }
+
+    fmt.Printf("\nGranted: %d, Revoked: %d, Unchanged: %d, Not linked: %d, Not in server: %d, Errors: %d\n",
+        len(result.Granted), len(result.Revoked), result.Unchanged, result.Unlinked, len(result.NotInGuild), len(result.Errors))
+    if *dryRun {
+        fmt.Println("DRY RUN complete - no roles changed")
+    }
+    if len(result.Errors) > 0 {
+        os.Exit(1)
+    }
+}
+
+// runLinkDiscord imports an email,discord_id CSV mapping
+func runLinkDiscord(logger *log.Logger) {
+    linkCmd := flag.NewFlagSet("link-discord", flag.ExitOnError)
+
+    args := parseSubcommand(linkCmd, "memberships link-discord <csv-file>  (columns: email, discord_id)", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: link-discord command requires a CSV filename")
+        linkCmd.Usage()
+        os.Exit(2)
+    }
+
+    file, err := os.Open(args[0])
+    if err != nil {
+        logger.Fatalf("Failed to open CSV file: %v", err)
+    }
+    defer file.Close()
+
+    reader := csv.NewReader(file)
+    reader.FieldsPerRecord = -1
+
+    headers, err := reader.Read()
+    if err != nil {
+        logger.Fatalf("Failed to read CSV headers: %v", err)
+    }
+    emailIdx, idIdx := -1, -1
+    for i, header := range headers {
+        switch normalizeHeader(header) {
+        case "email", "email address":
+            emailIdx = i
+        case "discord id", "discord user id", "discord":
+            idIdx = i
+        }
+    }
+    if emailIdx < 0 || idIdx < 0 {
+        logger.Fatalf("CSV must have email and discord_id columns, found %v", headers)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    linked, skipped := 0, 0
+    for {
+        row, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            logger.Fatalf("Failed to read CSV: %v", err)
+        }
+        if len(row) <= emailIdx || len(row) <= idIdx {
+            skipped++
+            continue
+        }
+
+        email, discordID := row[emailIdx], strings.TrimSpace(row[idIdx])
+        if !validDiscordID(discordID) {
+            logger.Printf("Skipping %s: invalid Discord ID %q", email, discordID)
+            skipped++
+            continue
+        }
+        if err := db.SetDiscordID(email, discordID); err != nil {
+            logger.Printf("Skipping %s: %v", email, err)
+            skipped++
+            continue
+        }
+        linked++
+    }
+
+    fmt.Printf("Linked %d members, skipped %d rows\n", linked, skipped)
+}
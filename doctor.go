@@ -0,0 +1,357 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "strings"
+
+    "github.com/lib/pq"
+)
+
+// expectedColumns lists the columns and information_schema data types the
+// code relies on, per table
+var expectedColumns = map[string]map[string]string{
+    "members": {
+        "id":                   "integer",
+        "email":                "character varying",
+        "raw_email":            "character varying",
+        "name":                 "character varying",
+        "is_anonymous":         "boolean",
+        "status":               "character varying",
+        "notes":                "text",
+        "tags":                 "ARRAY",
+        "first_seen":           "timestamp with time zone",
+        "last_updated":         "timestamp with time zone",
+        "first_payment_at":     "timestamp with time zone",
+        "last_payment_at":      "timestamp with time zone",
+        "frequency":            "character varying",
+        "frequency_raw":        "character varying",
+        "discord_id":           "character varying",
+        "email_hash":           "character varying",
+        "public_id":            "uuid",
+        "failed_payment_count": "integer",
+        "email_ciphertext":     "text",
+        "email_opt_in":         "boolean",
+        "consent_recorded_at":  "timestamp with time zone",
+        "consent_source":       "character varying",
+        "unsubscribe_token":    "uuid",
+        "last_event_at":        "timestamp with time zone",
+        "last_received_at":     "timestamp with time zone",
+        "household_id":         "integer",
+        "campaign":             "character varying",
+    },
+    "status_history": {
+        "id":         "integer",
+        "member_id":  "integer",
+        "status":     "character varying",
+        "reason":     "text",
+        "changed_at": "timestamp with time zone",
+    },
+    "webhook_logs": {
+        "id":          "integer",
+        "received_at": "timestamp with time zone",
+        "email":       "character varying",
+        "status":      "character varying",
+        "payload":     "jsonb",
+        "state":       "character varying",
+        "attempts":    "integer",
+        "skip_reason": "character varying",
+    },
+    "sync_runs": {
+        "id":          "integer",
+        "status":      "character varying",
+        "suspended":   "integer",
+        "finished_at": "timestamp with time zone",
+    },
+    "stats_snapshots": {
+        "snapshot_date": "date",
+        "active":        "integer",
+        "suspended":     "integer",
+    },
+    "events": {
+        "id":         "bigint",
+        "type":       "character varying",
+        "member_id":  "integer",
+        "source":     "character varying",
+        "payload":    "jsonb",
+        "created_at": "timestamp with time zone",
+    },
+    "settings": {
+        "key":        "character varying",
+        "value":      "text",
+        "updated_at": "timestamp with time zone",
+    },
+    "access_logs": {
+        "id":          "bigint",
+        "accessed_at": "timestamp with time zone",
+        "client_ip":   "character varying",
+        "principal":   "character varying",
+        "route":       "character varying",
+        "path":        "text",
+        "query":       "text",
+        "status":      "integer",
+    },
+    "donations": {
+        "id":          "integer",
+        "member_id":   "integer",
+        "amount":      "numeric",
+        "currency":    "character varying",
+        "frequency":   "character varying",
+        "occurred_at": "timestamp with time zone",
+        "source":      "character varying",
+        "external_id": "character varying",
+        "created_at":  "timestamp with time zone",
+        "refunded_at": "timestamp with time zone",
+        "campaign":    "character varying",
+    },
+    "sync_run_changes": {
+        "run_id":        "integer",
+        "member_id":     "integer",
+        "email":         "character varying",
+        "before_status": "character varying",
+        "after_status":  "character varying",
+    },
+    "failed_webhooks": {
+        "id":             "integer",
+        "received_at":    "timestamp with time zone",
+        "webhook_log_id": "integer",
+        "headers":        "jsonb",
+        "body":           "text",
+        "error_class":    "character varying",
+        "state":          "character varying",
+        "resolved_by":    "character varying",
+    },
+    "organizations": {
+        "id":     "integer",
+        "name":   "character varying",
+        "status": "character varying",
+        "tier":   "character varying",
+    },
+    "org_members": {
+        "organization_id": "integer",
+        "member_id":       "integer",
+    },
+    "unmapped_statuses": {
+        "kind":           "character varying",
+        "payment_status": "character varying",
+        "seen":           "integer",
+        "last_seen_at":   "timestamp with time zone",
+        "mapped_to":      "character varying",
+    },
+    "member_emails": {
+        "member_id":       "integer",
+        "kind":            "character varying",
+        "transition":      "character varying",
+        "state":           "character varying",
+        "attempts":        "integer",
+        "next_attempt_at": "timestamp with time zone",
+    },
+    "jobs_history": {
+        "id":           "bigint",
+        "job":          "character varying",
+        "triggered_by": "character varying",
+        "started_at":   "timestamp with time zone",
+        "finished_at":  "timestamp with time zone",
+        "duration_ms":  "bigint",
+    },
+    "pending_changes": {
+        "id":          "integer",
+        "email":       "character varying",
+        "action":      "character varying",
+        "from_status": "character varying",
+        "to_status":   "character varying",
+        "proposed_at": "timestamp with time zone",
+        "expires_at":  "timestamp with time zone",
+        "state":       "character varying",
+        "sync_run_id": "integer",
+    },
+}
+
+// expectedTables is the order tables are checked and reported in
+var expectedTables = []string{"members", "status_history", "webhook_logs", "sync_runs", "sync_run_changes", "stats_snapshots", "events", "settings", "access_logs", "donations", "failed_webhooks", "organizations", "org_members", "unmapped_statuses", "member_emails", "jobs_history", "pending_changes"}
+
+// expectedIndexes maps a description to a table and a fragment of its
+// pg_indexes definition
+var expectedIndexes = []struct {
+    name     string
+    table    string
+    fragment string
+}{
+    {"unique members.email", "members", "UNIQUE INDEX"},
+    {"unique lower(members.email)", "members", "(lower((email)::text))"},
+    {"members.tags GIN", "members", "USING gin (tags)"},
+    {"members.email_hash", "members", "(email_hash)"},
+    {"unique members.public_id", "members", "(public_id)"},
+    {"unique members.unsubscribe_token", "members", "(unsubscribe_token)"},
+    {"members.household_id", "members", "(household_id) WHERE"},
+    {"lower(members.campaign)", "members", "(lower((campaign)::text))"},
+    {"sync_run_changes.run_id", "sync_run_changes", "(run_id)"},
+    {"events.type", "events", "(type, id)"},
+    {"one open failure per webhook log", "failed_webhooks", "(webhook_log_id) WHERE"},
+    {"unique lower(organizations.name)", "organizations", "(lower((name)::text))"},
+    {"org_members.member_id", "org_members", "(member_id)"},
+    {"pending member_emails", "member_emails", "(next_attempt_at) WHERE"},
+    {"jobs_history.job", "jobs_history", "(job, started_at DESC)"},
+    {"one pending change per member", "pending_changes", "(email) WHERE"},
+}
+
+// doctor collects check results
+type doctor struct {
+    failed bool
+}
+
+func (d *doctor) pass(check string) {
+    fmt.Printf("[PASS] %s\n", check)
+}
+
+func (d *doctor) fail(check string, format string, args ...interface{}) {
+    fmt.Printf("[FAIL] %s: %s\n", check, fmt.Sprintf(format, args...))
+    d.failed = true
+}
+
+// runDoctor runs read-only checks and exits non-zero if any fail
+func runDoctor(logger *log.Logger) {
+    d := &doctor{}
+
+    config, err := LoadConfig(configPath, logger)
+    if err != nil {
+        d.fail("configuration", "%v", err)
+        os.Exit(1)
+    }
+    d.pass("configuration")
+
+    if len(config.WebhookSources) == 0 {
+        d.fail("server environment", "WEBHOOK_SECRET is not set")
+    } else {
+        d.pass("server environment")
+    }
+
+    d.checkPort(config.Port)
+
+    db, err := NewDatabase(config.DatabaseURL, logger)
+    if err != nil {
+        d.fail("database connectivity", "%v", err)
+        os.Exit(1)
+    }
+    defer db.Close()
+    db.NormalizeEmails = config.NormalizeEmails
+    d.pass("database connectivity")
+
+    d.checkSchema(db)
+    d.checkIndexes(db)
+    d.checkEmails(db)
+
+    if d.failed {
+        db.Close()
+        os.Exit(1)
+    }
+}
+
+// checkPort confirms the server could listen on its port. A running server
+// holds the port, so that case is reported as such rather than failing.
+func (d *doctor) checkPort(port string) {
+    addr := "127.0.0.1:" + port
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        if strings.Contains(err.Error(), "address already in use") {
+            fmt.Printf("[PASS] port %s: in use (server already running?)\n", port)
+            return
+        }
+        d.fail("port "+port, "%v", err)
+        return
+    }
+    listener.Close()
+    d.pass("port " + port + " bindable")
+}
+
+// checkSchema compares information_schema against expectedColumns
+func (d *doctor) checkSchema(db *Database) {
+    rows, err := db.Query(`
+        SELECT table_name, column_name, data_type
+        FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = ANY($1)
+    `, pq.Array(expectedTables))
+    if err != nil {
+        d.fail("schema", "%v", err)
+        return
+    }
+    defer rows.Close()
+
+    actual := make(map[string]map[string]string)
+    for rows.Next() {
+        var table, column, dataType string
+        if err := rows.Scan(&table, &column, &dataType); err != nil {
+            d.fail("schema", "%v", err)
+            return
+        }
+        if actual[table] == nil {
+            actual[table] = make(map[string]string)
+        }
+        actual[table][column] = dataType
+    }
+    if err := rows.Err(); err != nil {
+        d.fail("schema", "%v", err)
+        return
+    }
+
+    for _, table := range expectedTables {
+        columns, ok := actual[table]
+        if !ok {
+            d.fail("table "+table, "missing (run ./migrate.sh up)")
+            continue
+        }
+
+        var problems []string
+        for column, wantType := range expectedColumns[table] {
+            gotType, ok := columns[column]
+            if !ok {
+                problems = append(problems, "missing column "+column)
+            } else if gotType != wantType {
+                problems = append(problems, fmt.Sprintf("%s is %s, expected %s", column, gotType, wantType))
+            }
+        }
+
+        if len(problems) > 0 {
+            d.fail("table "+table, "%s", strings.Join(problems, "; "))
+        } else {
+            d.pass("table " + table)
+        }
+    }
+}
+
+// checkIndexes confirms the indexes the queries depend on exist
+func (d *doctor) checkIndexes(db *Database) {
+    for _, index := range expectedIndexes {
+        var found bool
+        err := db.QueryRow(`
+            SELECT EXISTS (
+                SELECT 1 FROM pg_indexes
+                WHERE schemaname = current_schema() AND tablename = $1 AND indexdef LIKE '%' || $2 || '%'
+            )
+        `, index.table, index.fragment).Scan(&found)
+        if err != nil {
+            d.fail("index "+index.name, "%v", err)
+        } else if !found {
+            d.fail("index "+index.name, "missing")
+        } else {
+            d.pass("index " + index.name)
+        }
+    }
+}
+
+// checkEmails reports stored members whose email fails validation
+func (d *doctor) checkEmails(db *Database) {
+    invalid, err := db.FindInvalidEmails()
+    if err != nil {
+        d.fail("email validation", "%v", err)
+    } else if len(invalid) > 0 {
+        d.fail("email validation", "%d members have invalid emails", len(invalid))
+        for _, row := range invalid {
+            fmt.Printf("         ID %d (%s): %s\n", row.ID, row.Status, row.Reason)
+        }
+    } else {
+        d.pass("email validation")
+    }
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// defaultCurrency is assumed when a payment doesn't say; GiveLively and the
+// Zapier webhooks are in US dollars
+const defaultCurrency = "USD"
+
+// Donation is one payment by a member. Amounts are kept in cents.
+type Donation struct {
+    Email       string
+    AmountCents int64
+    Currency    string
+    Frequency   string
+    OccurredAt  time.Time
+    Source      string
+    ExternalID  string
+    Campaign    string
+}
+
+// DonationTotal sums a member's donations in one currency
+type DonationTotal struct {
+    Currency      string `json:"currency"`
+    Count         int    `json:"count"`
+    LifetimeTotal string `json:"lifetime_total"`
+    AverageGift   string `json:"average_gift"`
+}
+
+// apiDonation is a donation as the API and lookup --json show it
+type apiDonation struct {
+    Amount     string    `json:"amount"`
+    Currency   string    `json:"currency"`
+    Frequency  string    `json:"frequency,omitempty"`
+    OccurredAt time.Time `json:"occurred_at"`
+    Source     string    `json:"source"`
+    ExternalID string    `json:"external_id"`
+    Campaign   string    `json:"campaign,omitempty"`
+}
+
+func newAPIDonation(d Donation) apiDonation {
+    return apiDonation{
+        Amount:     formatCents(d.AmountCents),
+        Currency:   d.Currency,
+        Frequency:  d.Frequency,
+        OccurredAt: d.OccurredAt,
+        Source:     d.Source,
+        ExternalID: d.ExternalID,
+        Campaign:   d.Campaign,
+    }
+}
+
+// parseAmount reads a payment amount such as "50", "$1,250.00", or "25 USD"
+// into cents
+func parseAmount(value string) (int64, error) {
+    cleaned := strings.NewReplacer("$", "", ",", "", " ", "").Replace(strings.TrimSpace(value))
+    cleaned = strings.TrimSuffix(strings.ToUpper(cleaned), defaultCurrency)
+    f, err := strconv.ParseFloat(cleaned, 64)
+    if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f <= 0 {
+        return 0, fmt.Errorf("invalid amount %q", value)
+    }
+    return int64(math.Round(f * 100)), nil
+}
+
+// formatCents prints cents as a decimal amount, e.g. 1250 as "12.50"
+func formatCents(cents int64) string {
+    sign := ""
+    if cents < 0 {
+        sign, cents = "-", -cents
+    }
+    return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// normalizeCurrency uppercases a currency code, defaulting to USD
+func normalizeCurrency(currency string) string {
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    if currency == "" {
+        return defaultCurrency
+    }
+    return currency
+}
+
+// donationKey stands in for a transaction id when the source has none. It's
+// derived from the donation itself, so the same payment seen twice from the
+// same source maps to the same key; two equal gifts from one donor at the
+// same moment are indistinguishable.
+func donationKey(source, email string, cents int64, occurredAt time.Time) string {
+    sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s", email, cents, occurredAt.UTC().Format(time.RFC3339))))
+    return source + ":" + hex.EncodeToString(sum[:16])
+}
+
+// recordDonation stores a donation for an existing member. A donation whose
+// external id is already recorded is skipped. It reports whether a row was
+// added.
+func (db *Database) recordDonation(q querier, d Donation) (bool, error) {
+    email := db.NormalizeEmail(d.Email)
+    if d.ExternalID == "" {
+        d.ExternalID = donationKey(d.Source, email, d.AmountCents, d.OccurredAt)
+    }
+
+    result, err := q.Exec(`
+        INSERT INTO donations (member_id, amount, currency, frequency, occurred_at, source, external_id, campaign)
+        SELECT id, $2::numeric / 100, $3, NULLIF($4, ''), $5, $6, $7, NULLIF($8, '')
+        FROM members WHERE email = $1
+        ON CONFLICT (external_id) DO NOTHING
+    `, email, d.AmountCents, normalizeCurrency(d.Currency), d.Frequency, d.OccurredAt, d.Source, d.ExternalID, strings.TrimSpace(d.Campaign))
+    if err != nil {
+        return false, fmt.Errorf("failed to record donation for %s: %w", email, err)
+    }
+    n, _ := result.RowsAffected()
+    return n > 0, nil
+}
+
+// RecordDonation stores one donation for an existing member
+func (db *Database) RecordDonation(d Donation) error {
+    _, err := db.recordDonation(db.DB, d)
+    return err
+}
+
+// recordDonations stores a batch of donations, returning how many were new
+func (db *Database) recordDonations(q querier, donations []Donation) (int, error) {
+    added := 0
+    for _, d := range donations {
+        ok, err := db.recordDonation(q, d)
+        if err != nil {
+            return added, err
+        }
+        if ok {
+            added++
+        }
+    }
+    return added, nil
+}
+
+// GetDonations returns a member's most recent donations, newest first
+func (db *Database) GetDonations(email string, limit int) ([]Donation, error) {
+    email = db.NormalizeEmail(email)
+
+    rows, err := db.Query(`
+        SELECT (d.amount * 100)::bigint, d.currency, COALESCE(d.frequency, ''), d.occurred_at, d.source, d.external_id,
+               COALESCE(d.campaign, '')
+        FROM donations d
+        JOIN members m ON m.id = d.member_id
+        WHERE m.email = $1
+        ORDER BY d.occurred_at DESC, d.id DESC
+        LIMIT $2
+    `, email, limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get donations: %w", err)
+    }
+    defer rows.Close()
+
+    var donations []Donation
+    for rows.Next() {
+        d := Donation{Email: email}
+        if err := rows.Scan(&d.AmountCents, &d.Currency, &d.Frequency, &d.OccurredAt, &d.Source, &d.ExternalID, &d.Campaign); err != nil {
+            return nil, err
+        }
+        donations = append(donations, d)
+    }
+
+    return donations, rows.Err()
+}
+
+// GetDonationTotals returns a member's lifetime giving per currency, largest
+// first
+func (db *Database) GetDonationTotals(email string) ([]DonationTotal, error) {
+    rows, err := db.Query(`
+        SELECT d.currency, COUNT(*), (SUM(d.amount) * 100)::bigint
+        FROM donations d
+        JOIN members m ON m.id = d.member_id
+        WHERE m.email = $1
+        GROUP BY d.currency
+        ORDER BY SUM(d.amount) DESC
+    `, db.NormalizeEmail(email))
+    if err != nil {
+        return nil, fmt.Errorf("failed to get donation totals: %w", err)
+    }
+    defer rows.Close()
+
+    totals := []DonationTotal{}
+    for rows.Next() {
+        var t DonationTotal
+        var cents int64
+        if err := rows.Scan(&t.Currency, &t.Count, &cents); err != nil {
+            return nil, err
+        }
+        t.LifetimeTotal = formatCents(cents)
+        t.AverageGift = formatCents(int64(math.Round(float64(cents) / float64(t.Count))))
+        totals = append(totals, t)
+    }
+
+    return totals, rows.Err()
+}
+
+// recordWebhookDonation adds the payment a successful webhook describes to
+// the member's donation history. A bad amount is logged and skipped; the
+// status change still stands.
+func (s *WebhookServer) recordWebhookDonation(webhook MemberWebhook, occurredAt time.Time, change ChangeSource) {
+    cents, err := parseAmount(string(webhook.Amount))
+    if err != nil {
+        s.logger.Printf("Warning: Not recording donation for %s: %v", webhook.Email, err)
+        return
+    }
+
+    err = s.db.RecordDonation(Donation{
+        Email:       webhook.Email,
+        AmountCents: cents,
+        Currency:    webhook.Currency,
+        Frequency:   webhook.Frequency,
+        OccurredAt:  occurredAt,
+        Source:      change.Source,
+        ExternalID:  strings.TrimSpace(webhook.DonationID),
+        Campaign:    webhook.Campaign,
+    })
+    if err != nil {
+        s.logger.Printf("Warning: Failed to record donation: %v", err)
+    }
+}
+
+// looseString accepts a JSON string or number, since Zapier sends amounts
+// either way depending on how the field was mapped
+type looseString string
+
+func (s *looseString) UnmarshalJSON(data []byte) error {
+    if bytes.Equal(data, []byte("null")) {
+        *s = ""
+        return nil
+    }
+    if len(data) > 0 && data[0] == '"' {
+        var str string
+        if err := json.Unmarshal(data, &str); err != nil {
+            return err
+        }
+        *s = looseString(str)
+        return nil
+    }
+    var n json.Number
+    if err := json.Unmarshal(data, &n); err != nil {
+        return fmt.Errorf("expected a string or number, got %s", data)
+    }
+    *s = looseString(n)
+    return nil
+}
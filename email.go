@@ -0,0 +1,370 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/mail"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ErrInvalidEmail is returned when an address fails validateEmail
+var ErrInvalidEmail = errors.New("invalid email")
+
+// emailHash is the hex SHA-256 of an already-normalized email, as stored in
+// members.email_hash. Migration 000014 computes the same thing in SQL.
+func emailHash(normalizedEmail string) string {
+    sum := sha256.Sum256([]byte(normalizedEmail))
+    return hex.EncodeToString(sum[:])
+}
+
+// verifyHash is what GET /verify/hash expects for an email: the hex SHA-256
+// of the address trimmed and lowercased, and with EMAIL_NORMALIZATION also
+// stripped of any +suffix and, at gmail.com or googlemail.com, of dots in
+// the local part, with the domain written gmail.com
+func verifyHash(email string, extended bool) string {
+    return emailHash(normalizeEmail(email, extended))
+}
+
+// gmailDomains ignore dots in the local part
+var gmailDomains = map[string]bool{
+    "gmail.com":      true,
+    "googlemail.com": true,
+}
+
+// normalizeEmail lowercases and trims an email. When extended is set it also
+// strips plus-address suffixes and, for gmail domains, dots in the local part.
+// Addresses that don't look like local@domain are only lowercased and trimmed
+// so that garbage input can't collapse onto a real member.
+func normalizeEmail(email string, extended bool) string {
+    email = strings.ToLower(strings.TrimSpace(email))
+    if !extended {
+        return email
+    }
+
+    at := strings.LastIndex(email, "@")
+    if at <= 0 || at == len(email)-1 {
+        return email
+    }
+
+    local, domain := email[:at], email[at+1:]
+
+    if plus := strings.Index(local, "+"); plus > 0 {
+        local = local[:plus]
+    }
+
+    if gmailDomains[domain] {
+        stripped := strings.ReplaceAll(local, ".", "")
+        if stripped != "" {
+            local = stripped
+        }
+        // googlemail.com and gmail.com deliver to the same mailbox
+        domain = "gmail.com"
+    }
+
+    return local + "@" + domain
+}
+
+// validateEmail checks that an address is a bare, plausibly deliverable email.
+// It catches mis-mapped Zapier fields like "n/a" or a postal address.
+func validateEmail(email string) error {
+    email = strings.TrimSpace(email)
+    if email == "" {
+        return fmt.Errorf("%w: empty", ErrInvalidEmail)
+    }
+
+    addr, err := mail.ParseAddress(email)
+    if err != nil {
+        return fmt.Errorf("%w: %q: %v", ErrInvalidEmail, email, err)
+    }
+
+    // Reject "Name <addr>" forms; the field should hold only the address
+    if addr.Address != email || addr.Name != "" {
+        return fmt.Errorf("%w: %q: not a bare address", ErrInvalidEmail, email)
+    }
+
+    at := strings.LastIndex(email, "@")
+    domain := email[at+1:]
+
+    labels := strings.Split(domain, ".")
+    if len(labels) < 2 {
+        return fmt.Errorf("%w: %q: domain has no TLD", ErrInvalidEmail, email)
+    }
+    for _, label := range labels {
+        if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+            return fmt.Errorf("%w: %q: malformed domain", ErrInvalidEmail, email)
+        }
+    }
+    if tld := labels[len(labels)-1]; len(tld) < 2 {
+        return fmt.Errorf("%w: %q: malformed TLD", ErrInvalidEmail, email)
+    }
+
+    return nil
+}
+
+// InvalidEmailRow is a stored member whose email fails validation
+type InvalidEmailRow struct {
+    ID     int
+    Email  string
+    Status string
+    Reason string
+}
+
+// FindInvalidEmails returns existing members whose email fails validateEmail
+func (db *Database) FindInvalidEmails() ([]InvalidEmailRow, error) {
+    rows, err := db.Query(`SELECT id, email, status FROM members ORDER BY id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var invalid []InvalidEmailRow
+    for rows.Next() {
+        var row InvalidEmailRow
+        if err := rows.Scan(&row.ID, &row.Email, &row.Status); err != nil {
+            return nil, err
+        }
+        if err := validateEmail(row.Email); err != nil {
+            row.Reason = err.Error()
+            invalid = append(invalid, row)
+        }
+    }
+
+    return invalid, rows.Err()
+}
+
+// DuplicateMember is one row within a DuplicateGroup
+type DuplicateMember struct {
+    Email       string
+    Status      string
+    FirstSeen   time.Time
+    LastUpdated time.Time
+}
+
+// DuplicateGroup is a set of stored rows that share a normalized email
+type DuplicateGroup struct {
+    Normalized string
+    Members    []DuplicateMember
+}
+
+// FindDuplicateGroups returns rows whose stored email differs from its
+// normalized form, grouped by the normalized email. Groups with a single
+// member only need their stored key rewritten.
+func (db *Database) FindDuplicateGroups() ([]DuplicateGroup, error) {
+    rows, err := db.Query(`SELECT email, status, first_seen, last_updated FROM members`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    groups := make(map[string][]DuplicateMember)
+    for rows.Next() {
+        var m DuplicateMember
+        if err := rows.Scan(&m.Email, &m.Status, &m.FirstSeen, &m.LastUpdated); err != nil {
+            return nil, err
+        }
+        key := db.NormalizeEmail(m.Email)
+        groups[key] = append(groups[key], m)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    var result []DuplicateGroup
+    for key, members := range groups {
+        if len(members) == 1 && members[0].Email == key {
+            continue
+        }
+
+        // Most recently updated first; it becomes the surviving record
+        sort.Slice(members, func(i, j int) bool {
+            return members[i].LastUpdated.After(members[j].LastUpdated)
+        })
+        result = append(result, DuplicateGroup{Normalized: key, Members: members})
+    }
+
+    sort.Slice(result, func(i, j int) bool {
+        return result[i].Normalized < result[j].Normalized
+    })
+
+    return result, nil
+}
+
+// RenameMemberEmail rewrites a member's stored email key, keeping the
+// previous address in raw_email for display. oldEmail is matched as stored,
+// since the point is to fix keys that predate normalization; newEmail is
+// normalized.
+func (db *Database) RenameMemberEmail(oldEmail, newEmail string) error {
+    return renameMemberEmail(db.DB, oldEmail, db.NormalizeEmail(newEmail))
+}
+
+func renameMemberEmail(q querier, oldEmail, newEmail string) error {
+    result, err := q.Exec(`
+        UPDATE members SET
+            raw_email = COALESCE(raw_email, email),
+            email = $2,
+            email_hash = $3,
+            last_updated = CURRENT_TIMESTAMP
+        WHERE email = $1
+    `, oldEmail, newEmail, emailHash(newEmail))
+    if err != nil {
+        return fmt.Errorf("failed to rename member: %w", err)
+    }
+
+    rows, _ := result.RowsAffected()
+    if rows == 0 {
+        return fmt.Errorf("%w: %s", ErrMemberNotFound, oldEmail)
+    }
+
+    return nil
+}
+
+// statusRank orders statuses from most to least favorable to the member
+var statusRank = map[string]int{
+    "active":    4,
+    "suspended": 3,
+    "lapsed":    2,
+    "cancelled": 1,
+}
+
+// favorableStatus returns whichever status is better for the member
+func favorableStatus(a, b string) string {
+    if statusRank[b] > statusRank[a] {
+        return b
+    }
+    return a
+}
+
+// DedupeGroup merges every row of a duplicate group into its most recently
+// updated row, keeping the most favorable status and the earliest first_seen,
+// then rewrites the survivor's key to the normalized email. The whole group is
+// one transaction; a dry run rolls it back.
+func (db *Database) DedupeGroup(group DuplicateGroup, dryRun bool) ([]*MergeResult, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    survivor := group.Members[0].Email
+    to, err := lockMergeRow(tx, survivor)
+    if err != nil {
+        return nil, err
+    }
+
+    var results []*MergeResult
+    for _, m := range group.Members[1:] {
+        from, err := lockMergeRow(tx, m.Email)
+        if err != nil {
+            return nil, err
+        }
+
+        result, err := mergeLockedRows(tx, m.Email, survivor, from, to, favorableStatus(to.status, from.status))
+        if err != nil {
+            return nil, fmt.Errorf("failed to merge %s: %w", m.Email, err)
+        }
+        result.DryRun = dryRun
+        results = append(results, result)
+    }
+
+    if survivor != group.Normalized {
+        if err := renameMemberEmail(tx, survivor, group.Normalized); err != nil {
+            return nil, err
+        }
+    }
+
+    if dryRun {
+        return results, nil
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+
+    return results, nil
+}
+
+func runDedupe(logger *log.Logger) {
+    dedupeCmd := flag.NewFlagSet("dedupe", flag.ExitOnError)
+    merge := dedupeCmd.Bool("merge", false, "Merge each duplicate group into one record")
+    dryRun := dedupeCmd.Bool("dry-run", false, "With --merge, show the merges without making changes")
+
+    parseSubcommand(dedupeCmd, "memberships dedupe [--merge] [--dry-run]", os.Args[2:])
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if !db.NormalizeEmails {
+        logger.Println("EMAIL_NORMALIZATION is not enabled; only case and whitespace differences will be found")
+    }
+
+    groups, err := db.FindDuplicateGroups()
+    if err != nil {
+        logger.Fatalf("Failed to find duplicates: %v", err)
+    }
+
+    if len(groups) == 0 {
+        fmt.Println("No duplicate or unnormalized emails found")
+        return
+    }
+
+    fmt.Printf("\n=== %d Normalized Emails With Changes ===\n", len(groups))
+    for _, group := range groups {
+        fmt.Printf("%s\n", group.Normalized)
+        final := ""
+        for i, m := range group.Members {
+            marker := " "
+            if i == 0 {
+                marker = "*"
+            }
+            final = favorableStatus(final, m.Status)
+            fmt.Printf("  %s %q (%s, first seen %s, updated %s)\n", marker, m.Email, m.Status,
+                displayDate(m.FirstSeen), displayDate(m.LastUpdated))
+        }
+        if len(group.Members) > 1 {
+            fmt.Printf("    -> status after merge: %s\n", final)
+        }
+    }
+    fmt.Println("(* = surviving record; the most favorable status and earliest first_seen are kept)")
+
+    if !*merge {
+        fmt.Println("\nRe-run with --merge to consolidate these records")
+        return
+    }
+
+    if *dryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+    }
+
+    merged, renamed, failed := 0, 0, 0
+    for _, group := range groups {
+        survivor := group.Members[0].Email
+
+        results, err := db.DedupeGroup(group, *dryRun)
+        if err != nil {
+            logger.Printf("Error consolidating %s, group left unchanged: %v", group.Normalized, err)
+            failed++
+            continue
+        }
+
+        for _, result := range results {
+            logger.Printf("Merge %s -> %s (final status %s)", result.FromEmail, survivor, result.FinalStatus)
+            merged++
+        }
+        if survivor != group.Normalized {
+            logger.Printf("Rename %s -> %s", survivor, group.Normalized)
+            renamed++
+        }
+    }
+
+    fmt.Printf("\nMerged: %d, Renamed: %d, Errors: %d\n", merged, renamed, failed)
+    if *dryRun {
+        fmt.Println("DRY RUN complete - no changes made")
+    }
+}
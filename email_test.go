@@ -0,0 +1,92 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+    tests := []struct {
+        email    string
+        extended bool
+        want     string
+    }{
+        // Lowercasing and trimming always apply
+        {"Ada@Example.ORG", false, "ada@example.org"},
+        {"  ada@example.org\t", false, "ada@example.org"},
+        {"Ada.Lovelace+News@Gmail.com", false, "ada.lovelace+news@gmail.com"},
+
+        // Plus suffixes
+        {"ada+news@example.org", true, "ada@example.org"},
+        {"ada+news+more@example.org", true, "ada@example.org"},
+        {"ADA+News@Example.org", true, "ada@example.org"},
+        {"+news@example.org", true, "+news@example.org"},
+
+        // Dots at gmail and googlemail
+        {"ada.lovelace@gmail.com", true, "adalovelace@gmail.com"},
+        {"a.d.a@GMAIL.com", true, "ada@gmail.com"},
+        {"ada.lovelace@googlemail.com", true, "adalovelace@gmail.com"},
+        {"Ada.Love.Lace+news@GoogleMail.com", true, "adalovelace@gmail.com"},
+        {"...@gmail.com", true, "...@gmail.com"},
+
+        // Dots elsewhere are part of the mailbox
+        {"ada.lovelace@example.org", true, "ada.lovelace@example.org"},
+        {"ada.lovelace@mail.gmail.com", true, "ada.lovelace@mail.gmail.com"},
+        {"ada.lovelace@gmail.co.uk", true, "ada.lovelace@gmail.co.uk"},
+
+        // Anything that isn't local@domain is only lowercased
+        {"N/A", true, "n/a"},
+        {"Ada.Lovelace+news", true, "ada.lovelace+news"},
+        {"@gmail.com", true, "@gmail.com"},
+        {"a.da@", true, "a.da@"},
+        {"", true, ""},
+    }
+    for _, tt := range tests {
+        if got := normalizeEmail(tt.email, tt.extended); got != tt.want {
+            t.Errorf("normalizeEmail(%q, %v) = %q, want %q", tt.email, tt.extended, got, tt.want)
+        }
+    }
+}
+
+func TestLookupsNormalizeEmail(t *testing.T) {
+    db := newMemStore()
+    db.NormalizeEmails = true
+    seedMembers(t, db, map[string]string{"adalovelace@gmail.com": StatusActive})
+
+    const variant = " Ada.Love.Lace+news@GoogleMail.com "
+    if _, err := db.GetMemberByEmail(variant); err != nil {
+        t.Errorf("GetMemberByEmail: %v", err)
+    }
+    if status, found, err := db.GetMemberStatus(variant); err != nil || !found || status != StatusActive {
+        t.Errorf("GetMemberStatus = %s, %v, %v", status, found, err)
+    }
+    if err := db.UpdateMemberStatus(variant, StatusSuspended, ChangeSource{Source: "test"}); err != nil {
+        t.Errorf("UpdateMemberStatus: %v", err)
+    }
+    if err := db.SetDiscordID(variant, "1234"); err != nil {
+        t.Errorf("SetDiscordID: %v", err)
+    }
+    if err := db.RecordPayment(variant, time.Now()); err != nil {
+        t.Errorf("RecordPayment: %v", err)
+    }
+    if queued, err := db.QueueMemberEmail(variant, "welcome", "joined"); err != nil || !queued {
+        t.Errorf("QueueMemberEmail = %v, %v", queued, err)
+    }
+
+    err := db.QueuePendingChanges([]PendingChange{{Email: variant, Action: pendingDeactivate, ToStatus: StatusCancelled, Source: "test"}}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pending, err := db.GetPendingChanges(pendingStatePending, 10)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(pending) != 1 || pending[0].Email != "adalovelace@gmail.com" || pending[0].FromStatus != StatusSuspended {
+        t.Errorf("pending changes = %+v", pending)
+    }
+
+    m := db.member("adalovelace@gmail.com")
+    if m.DiscordID.String != "1234" || !m.LastPaymentAt.Valid {
+        t.Errorf("member = %+v", m)
+    }
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// readCacheMaxAge is how long clients may reuse a read response unvalidated
+const readCacheMaxAge = 10 * time.Second
+
+// GetChangeToken returns a cheap fingerprint of the members table that
+// changes whenever a member is added, updated, or removed. Every write path
+// bumps last_updated, so no write needs to maintain it separately.
+func (db *Database) GetChangeToken() (string, error) {
+    var lastUpdated sql.NullTime
+    var count int
+    err := db.QueryRow(`
+        SELECT GREATEST(MAX(last_updated), (SELECT MAX(updated_at) FROM organizations)), COUNT(*) FROM members
+    `).Scan(&lastUpdated, &count)
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%d-%d", lastUpdated.Time.UnixMicro(), count), nil
+}
+
+// LatestWebhookLogID returns the newest webhook log id, or 0, so /stats can
+// notice webhooks that changed no member
+func (db *Database) LatestWebhookLogID() int {
+    var id int
+    db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM webhook_logs`).Scan(&id)
+    return id
+}
+
+// etagMatches reports whether an If-None-Match header matches etag
+func etagMatches(header, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+        if candidate == etag || candidate == "*" {
+            return true
+        }
+    }
+    return false
+}
+
+// notModified sets ETag and Cache-Control from the change token plus any
+// extra state the response depends on, and answers 304 when the client
+// already has this version. It returns true when the response is complete.
+// On error it lets the handler run normally.
+func (s *WebhookServer) notModified(w http.ResponseWriter, r *http.Request, extra ...string) bool {
+    token, err := s.db.GetChangeToken()
+    if err != nil {
+        s.logger.Printf("Error getting change token: %v", err)
+        return false
+    }
+
+    sum := sha256.Sum256([]byte(strings.Join(append([]string{token}, extra...), "|")))
+    etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+    w.Header().Set("ETag", etag)
+    w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(readCacheMaxAge.Seconds())))
+
+    if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+        w.WriteHeader(http.StatusNotModified)
+        return true
+    }
+    return false
+}
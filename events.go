@@ -0,0 +1,257 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// Feed event types
+const (
+    feedMemberCreated   = "member.created"
+    feedStatusChanged   = "member.status_changed"
+    feedMemberUpdated   = "member.updated"
+    feedMemberMerged    = "member.merged"
+    feedMemberForgotten = "member.forgotten"
+    feedSyncApplied     = "sync.applied"
+    feedSyncUndone      = "sync.undone"
+    feedBackupRestored  = "backup.restored"
+)
+
+// Paging limits for the events feed
+const (
+    defaultEventsLimit = 100
+    maxEventsLimit     = 1000
+)
+
+// FeedEvent is one row of the events feed
+type FeedEvent struct {
+    ID        int64           `json:"id"`
+    Type      string          `json:"type"`
+    MemberID  string          `json:"member_id,omitempty"`
+    Source    string          `json:"source"`
+    Detail    string          `json:"detail,omitempty"`
+    Payload   json.RawMessage `json:"payload,omitempty"`
+    CreatedAt time.Time       `json:"created_at"`
+}
+
+// recordEvent appends to the events feed. It takes the querier of the change
+// it describes so the two commit or roll back together; memberID is 0 for
+// events not about one member.
+func recordEvent(q querier, eventType string, memberID int, change ChangeSource, payload map[string]interface{}) error {
+    var data []byte
+    if payload != nil {
+        var err error
+        if data, err = json.Marshal(payload); err != nil {
+            return fmt.Errorf("failed to encode event: %w", err)
+        }
+    }
+
+    _, err := q.Exec(`
+        INSERT INTO events (type, member_id, source, detail, payload)
+        VALUES ($1, NULLIF($2, 0), COALESCE(NULLIF($3, ''), 'unknown'), NULLIF($4, ''), $5)
+    `, eventType, memberID, change.Source, change.Detail, data)
+    if err != nil {
+        return fmt.Errorf("failed to record %s event: %w", eventType, err)
+    }
+
+    return nil
+}
+
+// recordStatusEvent records a member.status_changed event
+func recordStatusEvent(q querier, memberID int, email, previous, status string, change ChangeSource) error {
+    return recordEvent(q, feedStatusChanged, memberID, change, map[string]interface{}{
+        "email":      email,
+        "old_status": previous,
+        "new_status": status,
+    })
+}
+
+// inTx runs fn in a transaction, committing only if it succeeds
+func (db *Database) inTx(fn func(tx *sql.Tx) error) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if err := fn(tx); err != nil {
+        return err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+    return nil
+}
+
+// GetEvents returns up to limit events after the since cursor, oldest first,
+// optionally only of the given types
+func (db *Database) GetEvents(since int64, types []string, limit int) ([]FeedEvent, error) {
+    rows, err := db.Query(`
+        SELECT e.id, e.type, COALESCE(m.public_id::text, ''), e.source, COALESCE(e.detail, ''),
+               e.payload, e.created_at
+        FROM events e
+        LEFT JOIN members m ON m.id = e.member_id
+        WHERE e.id > $1 AND (cardinality($2::text[]) = 0 OR e.type = ANY($2))
+        ORDER BY e.id
+        LIMIT $3
+    `, since, pq.Array(types), limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get events: %w", err)
+    }
+    defer rows.Close()
+
+    var events []FeedEvent
+    for rows.Next() {
+        var e FeedEvent
+        var payload []byte
+        if err := rows.Scan(&e.ID, &e.Type, &e.MemberID, &e.Source, &e.Detail, &payload, &e.CreatedAt); err != nil {
+            return nil, err
+        }
+        if payload != nil {
+            e.Payload = payload
+        }
+        events = append(events, e)
+    }
+
+    return events, rows.Err()
+}
+
+// eventsHandler serves GET /events?since=<cursor>&type=&limit=. The response
+// carries the cursor to poll with next, which stays put when nothing is new.
+func (s *WebhookServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+
+    var since int64
+    if value := query.Get("since"); value != "" {
+        n, err := strconv.ParseInt(value, 10, 64)
+        if err != nil || n < 0 {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid since cursor")
+            return
+        }
+        since = n
+    }
+
+    limit := defaultEventsLimit
+    if value := query.Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid limit")
+            return
+        }
+        limit = min(n, maxEventsLimit)
+    }
+
+    var types []string
+    if eventType := query.Get("type"); eventType != "" {
+        types = []string{eventType}
+    }
+
+    events, err := s.db.GetEvents(since, types, limit)
+    if err != nil {
+        s.logger.Printf("Error getting events: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    if events == nil {
+        events = []FeedEvent{}
+    }
+
+    next := since
+    if len(events) > 0 {
+        next = events[len(events)-1].ID
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "events":   events,
+        "next":     strconv.FormatInt(next, 10),
+        "has_more": len(events) == limit,
+    })
+}
+
+// printEvent writes one event as a line of text
+func printEvent(e FeedEvent) {
+    fmt.Printf("%-6d %s  %-22s [%s]", e.ID, displayTime(e.CreatedAt), e.Type, e.Source)
+    if e.Detail != "" {
+        fmt.Printf(" %s", e.Detail)
+    }
+    if len(e.Payload) > 0 {
+        fmt.Printf(" %s", e.Payload)
+    }
+    fmt.Println()
+}
+
+func runEvents(logger *log.Logger) {
+    eventsCmd := flag.NewFlagSet("events", flag.ExitOnError)
+    since := eventsCmd.Int64("since", 0, "Show events after this event ID")
+    eventType := eventsCmd.String("type", "", "Only show events of this type, e.g. member.status_changed")
+    limit := eventsCmd.Int("limit", 50, "Number of events to show (the most recent, unless --since is given)")
+    follow := eventsCmd.Bool("follow", false, "Keep polling and print new events as they happen")
+    interval := eventsCmd.Duration("interval", 2*time.Second, "Polling interval with --follow")
+    asJSON := eventsCmd.Bool("json", false, "Print one JSON object per line")
+
+    parseSubcommand(eventsCmd, "memberships events [--since ID] [--type TYPE] [--limit N] [--follow] [--json]", os.Args[2:])
+
+    if *limit < 1 || *interval <= 0 {
+        fmt.Fprintln(os.Stderr, "Error: --limit and --interval must be positive")
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    var types []string
+    if *eventType != "" {
+        types = []string{*eventType}
+    }
+
+    cursor := *since
+    if cursor == 0 {
+        // Start from the last page rather than the beginning of time
+        latest, err := db.LatestEventID()
+        if err != nil {
+            logger.Fatalf("Failed to get events: %v", err)
+        }
+        cursor = max(latest-int64(*limit), 0)
+    }
+
+    batch := *limit
+    if *follow {
+        batch = maxEventsLimit
+    }
+
+    encoder := json.NewEncoder(os.Stdout)
+    for {
+        events, err := db.GetEvents(cursor, types, batch)
+        if err != nil {
+            logger.Fatalf("Failed to get events: %v", err)
+        }
+
+        for _, e := range events {
+            if *asJSON {
+                encoder.Encode(e)
+            } else {
+                printEvent(e)
+            }
+            cursor = e.ID
+        }
+
+        if !*follow {
+            return
+        }
+        if len(events) < batch {
+            time.Sleep(*interval)
+        }
+    }
+}
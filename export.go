@@ -0,0 +1,341 @@
+package main
+
+import (
+    "bufio"
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Member export formats. JSON Lines is one member object per line, for
+// data pipelines.
+const (
+    exportJSON  = "json"
+    exportCSV   = "csv"
+    exportJSONL = "jsonl"
+)
+
+// exportField is one column of a member export. value gives the field's
+// JSON value, and csv its CSV cell. Private fields are only exported by the
+// CLI, and only when named.
+type exportField struct {
+    name    string
+    value   func(m Member) interface{}
+    csv     func(m Member) string
+    private bool
+}
+
+// exportTime formats a nullable timestamp as RFC3339, or nil
+func exportTime(t sql.NullTime) interface{} {
+    if !t.Valid {
+        return nil
+    }
+    return t.Time.Format(time.RFC3339)
+}
+
+// memberExportFields are the export columns, in CSV order. Anonymous
+// members' names are never exported.
+var memberExportFields = []exportField{
+    {
+        name:  "id",
+        value: func(m Member) interface{} { return m.PublicID },
+    },
+    {
+        name:  "email",
+        value: func(m Member) interface{} { return m.Email },
+    },
+    {
+        name: "name",
+        value: func(m Member) interface{} {
+            if m.IsAnonymous || !m.Name.Valid {
+                return nil
+            }
+            return m.Name.String
+        },
+    },
+    {
+        name:  "status",
+        value: func(m Member) interface{} { return m.Status },
+    },
+    {
+        name:  "is_anonymous",
+        value: func(m Member) interface{} { return m.IsAnonymous },
+        csv:   func(m Member) string { return strconv.FormatBool(m.IsAnonymous) },
+    },
+    {
+        name: "frequency",
+        value: func(m Member) interface{} {
+            if !m.Frequency.Valid || m.Frequency.String == "" {
+                return nil
+            }
+            return m.Frequency.String
+        },
+    },
+    {
+        name: "campaign",
+        value: func(m Member) interface{} {
+            if !m.Campaign.Valid || m.Campaign.String == "" {
+                return nil
+            }
+            return m.Campaign.String
+        },
+    },
+    {
+        name: "tags",
+        value: func(m Member) interface{} {
+            if m.Tags == nil {
+                return []string{}
+            }
+            return m.Tags
+        },
+        csv: func(m Member) string { return strings.Join(m.Tags, ";") },
+    },
+    {
+        name:  "first_seen",
+        value: func(m Member) interface{} { return m.FirstSeen.Format(time.RFC3339) },
+    },
+    {
+        name:  "last_updated",
+        value: func(m Member) interface{} { return m.LastUpdated.Format(time.RFC3339) },
+    },
+    {
+        name:  "first_payment_at",
+        value: func(m Member) interface{} { return exportTime(m.FirstPaymentAt) },
+    },
+    {
+        name:  "last_payment_at",
+        value: func(m Member) interface{} { return exportTime(m.LastPaymentAt) },
+    },
+    {
+        name:  "email_opt_in",
+        value: func(m Member) interface{} { return nullableBool(m.EmailOptIn) },
+        csv: func(m Member) string {
+            if !m.EmailOptIn.Valid {
+                return ""
+            }
+            return strconv.FormatBool(m.EmailOptIn.Bool)
+        },
+    },
+    {
+        // For unsubscribe links in mail merges; it's a credential, so the
+        // API never exports it
+        name:    "unsubscribe_token",
+        value:   func(m Member) interface{} { return m.UnsubscribeToken },
+        private: true,
+    },
+}
+
+// csvCell formats a field for CSV; fields without their own formatter are
+// strings, with nil left blank
+func (f exportField) csvCell(m Member) string {
+    if f.csv != nil {
+        return f.csv(m)
+    }
+    if value, ok := f.value(m).(string); ok {
+        return value
+    }
+    return ""
+}
+
+// parseExportFields selects export columns from a comma-separated list,
+// keeping the order given. Empty means all of them except private ones,
+// which can be named only when allowPrivate is set. An unknown name is an
+// error, so a typo can't quietly export nothing.
+func parseExportFields(value string, allowPrivate bool) ([]exportField, error) {
+    byName := make(map[string]exportField, len(memberExportFields))
+    var names []string
+    var defaults []exportField
+    for _, f := range memberExportFields {
+        if f.private && !allowPrivate {
+            continue
+        }
+        byName[f.name] = f
+        names = append(names, f.name)
+        if !f.private {
+            defaults = append(defaults, f)
+        }
+    }
+    if strings.TrimSpace(value) == "" {
+        return defaults, nil
+    }
+
+    var fields []exportField
+    seen := make(map[string]bool)
+    for _, name := range strings.Split(value, ",") {
+        name = strings.ToLower(strings.TrimSpace(name))
+        f, ok := byName[name]
+        if !ok {
+            return nil, fmt.Errorf("unknown field %q (fields: %s)", name, strings.Join(names, ", "))
+        }
+        if !seen[name] {
+            seen[name] = true
+            fields = append(fields, f)
+        }
+    }
+    return fields, nil
+}
+
+// exportFormatOf reads the format a members request asked for, by ?format=
+// or the Accept header. JSON is the default.
+func exportFormatOf(r *http.Request) string {
+    if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+        switch format {
+        case exportCSV, exportJSONL:
+            return format
+        case "ndjson":
+            return exportJSONL
+        }
+        return exportJSON
+    }
+    for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+        mediaType, _, _ := strings.Cut(strings.TrimSpace(accept), ";")
+        switch strings.ToLower(strings.TrimSpace(mediaType)) {
+        case "text/csv":
+            return exportCSV
+        case "application/jsonl", "application/x-ndjson":
+            return exportJSONL
+        case "application/json", "*/*":
+            return exportJSON
+        }
+    }
+    return exportJSON
+}
+
+// exportContentTypes are the Content-Type of each streamed format
+var exportContentTypes = map[string]string{
+    exportCSV:   "text/csv; charset=utf-8",
+    exportJSONL: "application/x-ndjson; charset=utf-8",
+}
+
+// writeMembersExport streams every member matching the filter in format,
+// one row at a time as they come back from the database
+func writeMembersExport(w io.Writer, db Store, filter MemberFilter, format string, fields []exportField) error {
+    buffered := bufio.NewWriter(w)
+
+    var err error
+    switch format {
+    case exportCSV:
+        writer := csv.NewWriter(buffered)
+        header := make([]string, len(fields))
+        for i, f := range fields {
+            header[i] = f.name
+        }
+        writer.Write(header)
+        err = db.EachMember(filter, func(m Member) error {
+            row := make([]string, len(fields))
+            for i, f := range fields {
+                row[i] = f.csvCell(m)
+            }
+            return writer.Write(row)
+        })
+        writer.Flush()
+        if err == nil {
+            err = writer.Error()
+        }
+    case exportJSONL:
+        encoder := json.NewEncoder(buffered)
+        err = db.EachMember(filter, func(m Member) error {
+            object := make(map[string]interface{}, len(fields))
+            for _, f := range fields {
+                // Anonymous members have no name at all, not a null one
+                if f.name == "name" && m.IsAnonymous {
+                    continue
+                }
+                object[f.name] = f.value(m)
+            }
+            return encoder.Encode(object)
+        })
+    default:
+        return fmt.Errorf("unsupported export format %q", format)
+    }
+
+    if err != nil {
+        return err
+    }
+    return buffered.Flush()
+}
+
+// writeMembersExportResponse streams the members export as an attachment
+func (s *WebhookServer) writeMembersExportResponse(w http.ResponseWriter, r *http.Request, filter MemberFilter, format string, fields []exportField) {
+    label := filter.Status
+    if label == "" {
+        label = "all"
+    }
+    filename := fmt.Sprintf("members-%s-%s.%s", time.Now().Format("2006-01-02"), sanitizeFilename(label), format)
+
+    w.Header().Set("Content-Type", exportContentTypes[format])
+    w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+    // The status line is gone by the time rows fail; all that's left is to
+    // log it
+    if err := writeMembersExport(w, s.db, filter, format, fields); err != nil {
+        s.logger.Printf("Error exporting members: %v", err)
+    }
+}
+
+func runExport(logger *log.Logger) {
+    exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+    format := exportCmd.String("format", exportCSV, "Output format: csv, jsonl, or givelively (GiveLively's CSV columns)")
+    fieldList := exportCmd.String("fields", "", "Comma-separated fields to export (default all)")
+    status := exportCmd.String("status", "", "Only members with this status")
+    tag := exportCmd.String("tag", "", "Only members with this tag")
+    frequency := exportCmd.String("frequency", "", "Only members with this frequency: monthly, quarterly, annual, or other")
+    campaign := exportCmd.String("campaign", "", "Only members who joined through this campaign; unknown for members without one")
+    output := exportCmd.String("output", "", "Write to this file instead of stdout")
+
+    parseSubcommand(exportCmd, "memberships export [--format csv|jsonl|givelively] [--fields id,email,...] [--status S] [--tag T] [--frequency F] [--campaign C] [--output file]", os.Args[2:])
+
+    switch *format {
+    case exportCSV, exportJSONL:
+    case exportGiveLively:
+        if *fieldList != "" {
+            fmt.Fprintln(os.Stderr, "Error: --fields can't be used with --format givelively, whose columns are fixed")
+            os.Exit(2)
+        }
+    default:
+        fmt.Fprintf(os.Stderr, "Error: unsupported format %q (use csv, jsonl, or givelively)\n", *format)
+        os.Exit(2)
+    }
+    *frequency = strings.ToLower(*frequency)
+    if *frequency != "" && !validFrequency(*frequency) {
+        fmt.Fprintf(os.Stderr, "Error: --frequency must be monthly, quarterly, annual, or other, got %q\n", *frequency)
+        os.Exit(2)
+    }
+    fields, err := parseExportFields(*fieldList, true)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: --fields: %v\n", err)
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    var out io.Writer = os.Stdout
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            logger.Fatalf("Failed to create %s: %v", *output, err)
+        }
+        defer file.Close()
+        out = file
+    }
+
+    filter := MemberFilter{Status: *status, Tag: *tag, Frequency: *frequency, Campaign: *campaign}
+    if *format == exportGiveLively {
+        err = writeGiveLivelyExport(out, db, filter)
+    } else {
+        err = writeMembersExport(out, db, filter, *format, fields)
+    }
+    if err != nil {
+        logger.Fatalf("Export failed: %v", err)
+    }
+}
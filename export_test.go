@@ -0,0 +1,253 @@
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+)
+
+func TestExportFormatOf(t *testing.T) {
+    tests := []struct {
+        query  string
+        accept string
+        want   string
+    }{
+        {"", "", exportJSON},
+        {"", "application/json", exportJSON},
+        {"", "*/*", exportJSON},
+        {"", "text/csv", exportCSV},
+        {"", "Text/CSV; charset=utf-8", exportCSV},
+        {"", "application/x-ndjson", exportJSONL},
+        {"", "application/jsonl", exportJSONL},
+        {"", "text/html, text/csv;q=0.9", exportCSV},
+        {"", "application/json, text/csv", exportJSON},
+        {"", "text/html", exportJSON},
+        {"format=csv", "", exportCSV},
+        {"format=CSV", "", exportCSV},
+        {"format=jsonl", "", exportJSONL},
+        {"format=ndjson", "", exportJSONL},
+        {"format=json", "text/csv", exportJSON},
+        {"format=xml", "text/csv", exportJSON},
+        {"format=csv", "application/json", exportCSV},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest("GET", "/members?"+tt.query, nil)
+        if tt.accept != "" {
+            r.Header.Set("Accept", tt.accept)
+        }
+        if got := exportFormatOf(r); got != tt.want {
+            t.Errorf("?%s with Accept %q: got %s, want %s", tt.query, tt.accept, got, tt.want)
+        }
+    }
+}
+
+// exportServer is a test server holding members with a spread of statuses,
+// frequencies, tags, and campaigns
+func exportServer(t *testing.T) (*httptest.Server, *memStore) {
+    t.Helper()
+    server, db := newTestServer(t, nil)
+    for _, payload := range []string{
+        `{"email":"a@example.org","name":"Ada","status":"Succeeded","frequency":"Monthly","campaign":"Spring"}`,
+        `{"email":"b@example.org","name":"Bo","status":"Succeeded","frequency":"Annual","campaign":"Spring"}`,
+        `{"email":"c@example.org","name":"Cy","status":"Succeeded","frequency":"Monthly","anonymous":"True"}`,
+        `{"email":"d@example.org","name":"Di","status":"Cancelled","frequency":"Monthly","campaign":"Spring"}`,
+    } {
+        expectStatus(t, postWebhook(t, server, payload), http.StatusCreated)
+    }
+    if err := db.AddMemberTag("b@example.org", "board"); err != nil {
+        t.Fatal(err)
+    }
+    return server, db
+}
+
+// readCSVExport returns an export's header and its rows keyed by email
+func readCSVExport(t *testing.T, resp *http.Response) ([]string, map[string]map[string]string) {
+    t.Helper()
+    records, err := csv.NewReader(resp.Body).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) == 0 {
+        t.Fatal("export has no header")
+    }
+
+    header := records[0]
+    rows := map[string]map[string]string{}
+    for _, record := range records[1:] {
+        row := map[string]string{}
+        for i, name := range header {
+            row[name] = record[i]
+        }
+        rows[row["email"]] = row
+    }
+    return header, rows
+}
+
+// emailsOf returns the sorted keys of an export's rows
+func emailsOf(rows map[string]map[string]string) []string {
+    emails := []string{}
+    for email := range rows {
+        emails = append(emails, email)
+    }
+    sort.Strings(emails)
+    return emails
+}
+
+func TestMembersExportRequiresAdmin(t *testing.T) {
+    server, _ := exportServer(t)
+
+    for _, req := range []struct{ path, accept string }{
+        {"/members?format=csv", ""},
+        {"/members?format=jsonl", ""},
+        {"/members", "text/csv"},
+        {"/v1/members", "application/x-ndjson"},
+        {"/v1/members?status=active&format=csv", ""},
+    } {
+        for _, token := range []string{"", "wrong-token"} {
+            r, _ := http.NewRequest("GET", server.URL+req.path, nil)
+            if req.accept != "" {
+                r.Header.Set("Accept", req.accept)
+            }
+            if token != "" {
+                r.Header.Set("Authorization", "Bearer "+token)
+            }
+            resp, err := server.Client().Do(r)
+            if err != nil {
+                t.Fatal(err)
+            }
+            resp.Body.Close()
+            if resp.StatusCode != http.StatusUnauthorized {
+                t.Errorf("%s (Accept %q) with token %q: got %d, want 401", req.path, req.accept, token, resp.StatusCode)
+            }
+        }
+    }
+
+    // The paged JSON list stays public
+    expectStatus(t, do(t, server, "GET", "/v1/members", "", ""), http.StatusOK)
+}
+
+func TestMembersExportNegotiation(t *testing.T) {
+    server, _ := exportServer(t)
+
+    tests := []struct {
+        path        string
+        accept      string
+        contentType string
+    }{
+        {"/v1/members", "", "application/json"},
+        {"/v1/members?format=csv", "", "text/csv; charset=utf-8"},
+        {"/v1/members", "text/csv", "text/csv; charset=utf-8"},
+        {"/v1/members?format=jsonl", "", "application/x-ndjson; charset=utf-8"},
+        {"/v1/members", "application/x-ndjson", "application/x-ndjson; charset=utf-8"},
+        {"/members", "text/csv", "text/csv; charset=utf-8"},
+    }
+    for _, tt := range tests {
+        r, _ := http.NewRequest("GET", server.URL+tt.path, nil)
+        r.Header.Set("Authorization", "Bearer "+testAdminToken)
+        if tt.accept != "" {
+            r.Header.Set("Accept", tt.accept)
+        }
+        resp, err := server.Client().Do(r)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+
+        if resp.StatusCode != http.StatusOK {
+            t.Errorf("%s (Accept %q): got %d", tt.path, tt.accept, resp.StatusCode)
+        }
+        if got := resp.Header.Get("Content-Type"); got != tt.contentType {
+            t.Errorf("%s (Accept %q): Content-Type %q, want %q", tt.path, tt.accept, got, tt.contentType)
+        }
+        if !strings.Contains(resp.Header.Get("Vary"), "Accept") {
+            t.Errorf("%s: responses that depend on Accept must say so in Vary", tt.path)
+        }
+    }
+}
+
+func TestMembersExportFiltersCSV(t *testing.T) {
+    server, _ := exportServer(t)
+
+    tests := []struct {
+        query string
+        want  []string
+    }{
+        {"", []string{"a@example.org", "b@example.org", "c@example.org", "d@example.org"}},
+        {"status=active", []string{"a@example.org", "b@example.org", "c@example.org"}},
+        {"status=cancelled", []string{"d@example.org"}},
+        {"frequency=monthly", []string{"a@example.org", "c@example.org", "d@example.org"}},
+        {"status=active&frequency=monthly", []string{"a@example.org", "c@example.org"}},
+        {"tag=board", []string{"b@example.org"}},
+        {"campaign=spring", []string{"a@example.org", "b@example.org", "d@example.org"}},
+        {"campaign=unknown", []string{"c@example.org"}},
+        {"status=active&campaign=Spring", []string{"a@example.org", "b@example.org"}},
+        {"status=lapsed", []string{}},
+
+        // Exports ignore paging
+        {"limit=1&offset=1", []string{"a@example.org", "b@example.org", "c@example.org", "d@example.org"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            resp := do(t, server, "GET", "/v1/members?format=csv&"+tt.query, testAdminToken, "")
+            expectStatus(t, resp, http.StatusOK)
+            _, rows := readCSVExport(t, resp)
+            if got := emailsOf(rows); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("got %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestMembersExportCSVFields(t *testing.T) {
+    server, _ := exportServer(t)
+
+    resp := do(t, server, "GET", "/v1/members?format=csv&status=active&fields=email,name,frequency,tags", testAdminToken, "")
+    expectStatus(t, resp, http.StatusOK)
+    if disposition := resp.Header.Get("Content-Disposition"); !strings.Contains(disposition, "members-") || !strings.HasSuffix(disposition, `-active.csv"`) {
+        t.Errorf("Content-Disposition = %q", disposition)
+    }
+
+    header, rows := readCSVExport(t, resp)
+    if want := []string{"email", "name", "frequency", "tags"}; !reflect.DeepEqual(header, want) {
+        t.Errorf("header = %v, want %v", header, want)
+    }
+    if rows["a@example.org"]["name"] != "Ada" || rows["a@example.org"]["frequency"] != "monthly" {
+        t.Errorf("a@example.org = %v", rows["a@example.org"])
+    }
+    if rows["c@example.org"]["name"] != "" {
+        t.Errorf("an anonymous member's name was exported: %v", rows["c@example.org"])
+    }
+    if rows["b@example.org"]["tags"] != "board" {
+        t.Errorf("b@example.org tags = %q", rows["b@example.org"]["tags"])
+    }
+
+    resp = do(t, server, "GET", "/v1/members?format=csv&fields=email,password", testAdminToken, "")
+    expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestMembersExportJSONL(t *testing.T) {
+    server, _ := exportServer(t)
+
+    resp := do(t, server, "GET", "/v1/members?format=jsonl&campaign=Spring", testAdminToken, "")
+    expectStatus(t, resp, http.StatusOK)
+
+    var emails []string
+    scanner := bufio.NewScanner(resp.Body)
+    for scanner.Scan() {
+        var member map[string]interface{}
+        if err := json.Unmarshal(scanner.Bytes(), &member); err != nil {
+            t.Fatalf("line %q: %v", scanner.Text(), err)
+        }
+        emails = append(emails, member["email"].(string))
+    }
+    sort.Strings(emails)
+    if want := []string{"a@example.org", "b@example.org", "d@example.org"}; !reflect.DeepEqual(emails, want) {
+        t.Errorf("got %v, want %v", emails, want)
+    }
+}
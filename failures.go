@@ -0,0 +1,459 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Failed webhook error classes
+const (
+    failureBadJSON       = "bad_json"
+    failureMissingEmail  = "missing_email"
+    failureUnknownStatus = "unknown_status"
+    failureDBError       = "db_error"
+)
+
+// Failed webhook review states
+const (
+    failureOpen      = "open"
+    failureRetried   = "retried"
+    failureDismissed = "dismissed"
+)
+
+// ErrFailureNotFound is returned for a failed webhook id that doesn't exist
+// or has already been resolved
+var ErrFailureNotFound = errors.New("failed webhook not found")
+
+// failureHeaders are the request headers kept with a failed webhook, along
+// with any X-Zapier-* header. Authorization is never kept.
+var failureHeaders = []string{"Content-Type", "Content-Length", "User-Agent", "X-Forwarded-For", "X-Webhook-Fail-Hard"}
+
+// FailedWebhook is a webhook that couldn't be fully processed, awaiting review
+type FailedWebhook struct {
+    ID             int               `json:"id"`
+    ReceivedAt     time.Time         `json:"received_at"`
+    WebhookLogID   int               `json:"webhook_log_id,omitempty"`
+    Source         string            `json:"source,omitempty"`
+    SourceIP       string            `json:"source_ip,omitempty"`
+    Headers        map[string]string `json:"headers,omitempty"`
+    Body           string            `json:"body"`
+    ErrorClass     string            `json:"error_class"`
+    Error          string            `json:"error"`
+    State          string            `json:"state"`
+    ResolvedAt     *time.Time        `json:"resolved_at,omitempty"`
+    ResolvedBy     string            `json:"resolved_by,omitempty"`
+    ResolutionNote string            `json:"resolution_note,omitempty"`
+}
+
+// classifyWebhookError names the kind of failure err is
+func classifyWebhookError(err error) string {
+    switch {
+    case errors.Is(err, ErrInvalidEmail):
+        return failureMissingEmail
+    case errors.Is(err, ErrUnknownStatus):
+        return failureUnknownStatus
+    }
+    return failureDBError
+}
+
+// failureHeadersOf picks the headers worth keeping from a request
+func failureHeadersOf(r *http.Request) map[string]string {
+    headers := map[string]string{}
+    for _, name := range failureHeaders {
+        if value := r.Header.Get(name); value != "" {
+            headers[name] = value
+        }
+    }
+    for name, values := range r.Header {
+        if strings.HasPrefix(name, "X-Zapier-") && len(values) > 0 {
+            headers[name] = values[0]
+        }
+    }
+    return headers
+}
+
+// RecordFailedWebhook stores a failure for review. A failure for a logged
+// webhook that already has an open one updates it instead. In privacy mode
+// the body is redacted like the webhook log, and unparseable bodies aren't
+// kept at all since the address can't be picked out of them.
+func (db *Database) RecordFailedWebhook(f FailedWebhook) (int, error) {
+    if db.Privacy != nil {
+        if json.Valid([]byte(f.Body)) {
+            f.Body = string(db.redactPayload(json.RawMessage(f.Body)))
+        } else {
+            f.Body = fmt.Sprintf("[withheld in privacy mode: %d bytes]", len(f.Body))
+        }
+    }
+    if f.ReceivedAt.IsZero() {
+        f.ReceivedAt = time.Now()
+    }
+
+    var headers interface{}
+    if len(f.Headers) > 0 {
+        data, err := json.Marshal(f.Headers)
+        if err != nil {
+            return 0, err
+        }
+        headers = data
+    }
+    var logID interface{}
+    if f.WebhookLogID > 0 {
+        logID = f.WebhookLogID
+    }
+
+    var id int
+    err := db.QueryRow(`
+        INSERT INTO failed_webhooks (received_at, webhook_log_id, source, source_ip, headers, body, error_class, error)
+        VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7, $8)
+        ON CONFLICT (webhook_log_id) WHERE state = 'open' DO UPDATE SET
+            error_class = EXCLUDED.error_class,
+            error = EXCLUDED.error
+        RETURNING id
+    `, f.ReceivedAt, logID, f.Source, f.SourceIP, headers, f.Body, f.ErrorClass, f.Error).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("failed to record failed webhook: %w", err)
+    }
+    return id, nil
+}
+
+// GetFailedWebhooks returns failures in a state (all states if empty),
+// newest first
+func (db *Database) GetFailedWebhooks(state string, limit int) ([]FailedWebhook, error) {
+    return db.queryFailedWebhooks(`
+        WHERE $1 = '' OR state = $1
+        ORDER BY id DESC
+        LIMIT $2
+    `, state, limit)
+}
+
+// GetOpenFailuresOfClass returns every open failure of an error class,
+// oldest first
+func (db *Database) GetOpenFailuresOfClass(class string) ([]FailedWebhook, error) {
+    return db.queryFailedWebhooks(`
+        WHERE state = 'open' AND error_class = $1
+        ORDER BY id
+    `, class)
+}
+
+// GetFailedWebhook returns one failure by id
+func (db *Database) GetFailedWebhook(id int) (*FailedWebhook, error) {
+    failures, err := db.queryFailedWebhooks(`WHERE id = $1`, id)
+    if err != nil {
+        return nil, err
+    }
+    if len(failures) == 0 {
+        return nil, fmt.Errorf("%w: #%d", ErrFailureNotFound, id)
+    }
+    return &failures[0], nil
+}
+
+// queryFailedWebhooks selects failed webhook rows
+func (db *Database) queryFailedWebhooks(where string, args ...interface{}) ([]FailedWebhook, error) {
+    rows, err := db.Query(`
+        SELECT id, received_at, COALESCE(webhook_log_id, 0), COALESCE(source, ''), COALESCE(source_ip, ''),
+               headers, body, error_class, COALESCE(error, ''), state, resolved_at,
+               COALESCE(resolved_by, ''), COALESCE(resolution_note, '')
+        FROM failed_webhooks
+    `+where, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get failed webhooks: %w", err)
+    }
+    defer rows.Close()
+
+    failures := []FailedWebhook{}
+    for rows.Next() {
+        var f FailedWebhook
+        var headers []byte
+        var resolvedAt sql.NullTime
+        err := rows.Scan(&f.ID, &f.ReceivedAt, &f.WebhookLogID, &f.Source, &f.SourceIP, &headers, &f.Body,
+            &f.ErrorClass, &f.Error, &f.State, &resolvedAt, &f.ResolvedBy, &f.ResolutionNote)
+        if err != nil {
+            return nil, err
+        }
+        if headers != nil {
+            if err := json.Unmarshal(headers, &f.Headers); err != nil {
+                return nil, fmt.Errorf("failed webhook %d has bad headers: %w", f.ID, err)
+            }
+        }
+        if resolvedAt.Valid {
+            f.ResolvedAt = &resolvedAt.Time
+        }
+        failures = append(failures, f)
+    }
+
+    return failures, rows.Err()
+}
+
+// ResolveFailedWebhook closes an open failure as retried or dismissed,
+// noting who did it and why. A retried failure's webhook log leaves the
+// retry queue so retry-failed doesn't apply it again.
+func (db *Database) ResolveFailedWebhook(id int, state, by, note string) error {
+    return db.inTx(func(tx *sql.Tx) error {
+        var logID sql.NullInt64
+        err := tx.QueryRow(`
+            UPDATE failed_webhooks SET
+                state = $2,
+                resolved_at = CURRENT_TIMESTAMP,
+                resolved_by = NULLIF($3, ''),
+                resolution_note = NULLIF($4, '')
+            WHERE id = $1 AND state = $5
+            RETURNING webhook_log_id
+        `, id, state, by, note, failureOpen).Scan(&logID)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w: no open failure #%d", ErrFailureNotFound, id)
+        } else if err != nil {
+            return fmt.Errorf("failed to resolve failed webhook: %w", err)
+        }
+
+        if state != failureRetried || !logID.Valid {
+            return nil
+        }
+        _, err = tx.Exec(`
+            UPDATE webhook_logs SET state = $2, next_attempt_at = NULL
+            WHERE id = $1 AND state IN ($3, $4)
+        `, logID.Int64, webhookStateDone, webhookStateFailed, webhookStatePending)
+        return err
+    })
+}
+
+// UpdateFailedWebhookError replaces an open failure's error after another failed attempt
+func (db *Database) UpdateFailedWebhookError(id int, class, message string) error {
+    _, err := db.Exec(`
+        UPDATE failed_webhooks SET error_class = $2, error = $3 WHERE id = $1
+    `, id, class, message)
+    if err != nil {
+        return fmt.Errorf("failed to update failed webhook: %w", err)
+    }
+    return nil
+}
+
+// ResolveFailuresForLog closes the open failure for a logged webhook that
+// has since been processed, e.g. by retry-failed
+func (db *Database) ResolveFailuresForLog(logID int, by string) error {
+    _, err := db.Exec(`
+        UPDATE failed_webhooks SET state = $2, resolved_at = CURRENT_TIMESTAMP, resolved_by = $3
+        WHERE webhook_log_id = $1 AND state = $4
+    `, logID, failureRetried, by, failureOpen)
+    if err != nil {
+        return fmt.Errorf("failed to resolve failed webhook: %w", err)
+    }
+    return nil
+}
+
+// recordFailure keeps a webhook the handler couldn't process for review. A
+// dry run's failures are only reported back.
+func (s *WebhookServer) recordFailure(r *http.Request, source string, logID int, body []byte, class string, cause error) {
+    if isDryRun(r) {
+        return
+    }
+    id, err := s.db.RecordFailedWebhook(FailedWebhook{
+        WebhookLogID: logID,
+        Source:       source,
+        SourceIP:     s.clientIP(r),
+        Headers:      failureHeadersOf(r),
+        Body:         string(body),
+        ErrorClass:   class,
+        Error:        cause.Error(),
+    })
+    if err != nil {
+        s.logger.Printf("Warning: %v", err)
+        return
+    }
+    s.logger.Printf("Recorded failed webhook #%d (%s); review with memberships failed list", id, class)
+}
+
+// retryFailedWebhook reprocesses a failure through the normal webhook path,
+// closing it on success and updating its error otherwise
+func (s *WebhookServer) retryFailedWebhook(f *FailedWebhook, by string) error {
+    var webhook MemberWebhook
+    err := json.Unmarshal([]byte(f.Body), &webhook)
+    class := failureBadJSON
+    if err == nil && !isEmailKey(webhook.Email) {
+        err = validateEmail(webhook.Email)
+        class = failureMissingEmail
+    }
+    if err == nil {
+        _, err = s.applyWebhook(webhook, s.mapPaymentStatus(webhook.Status), f.ReceivedAt, webhookChange(f.Source, f.WebhookLogID))
+        class = classifyWebhookError(err)
+    }
+
+    if err != nil && !errors.Is(err, ErrStaleEvent) {
+        if uerr := s.db.UpdateFailedWebhookError(f.ID, class, err.Error()); uerr != nil {
+            s.logger.Printf("Warning: %v", uerr)
+        }
+        return err
+    }
+
+    // A stale event needs nothing more done; say so on the record
+    note := ""
+    if err != nil {
+        note = err.Error()
+    }
+    return s.db.ResolveFailedWebhook(f.ID, failureRetried, by, note)
+}
+
+// failedWebhooksHandler serves GET /webhooks/failed, open failures by default
+func (s *WebhookServer) failedWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+    state := r.URL.Query().Get("state")
+    switch state {
+    case "":
+        state = failureOpen
+    case "all":
+        state = ""
+    case failureOpen, failureRetried, failureDismissed:
+    default:
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "state must be open, retried, dismissed, or all")
+        return
+    }
+
+    limit := 100
+    if value := r.URL.Query().Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid limit")
+            return
+        }
+        limit = n
+    }
+
+    failures, err := s.db.GetFailedWebhooks(state, limit)
+    if err != nil {
+        s.logger.Printf("Error getting failed webhooks: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(failures)
+}
+
+func runFailed(logger *log.Logger) {
+    usage := `memberships failed <list|retry|dismiss> [args]`
+    if len(os.Args) < 3 {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+        os.Exit(2)
+    }
+
+    action := os.Args[2]
+    failedCmd := flag.NewFlagSet("failed "+action, flag.ExitOnError)
+    state := failedCmd.String("state", failureOpen, "With list: open, retried, dismissed, or all")
+    limit := failedCmd.Int("limit", 50, "With list: maximum number to show")
+    verbose := failedCmd.Bool("verbose", false, "With list: show bodies and headers")
+    reason := failedCmd.String("reason", "", "With dismiss: why the failure needs no action (required)")
+    by := failedCmd.String("by", os.Getenv("USER"), "Who is retrying or dismissing (default: $USER)")
+
+    args := parseSubcommand(failedCmd, usage, os.Args[3:])
+
+    parseID := func() int {
+        if len(args) != 1 {
+            fmt.Fprintf(os.Stderr, "Error: failed %s requires a failure ID\n", action)
+            os.Exit(2)
+        }
+        id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: invalid failure ID %q\n", args[0])
+            os.Exit(2)
+        }
+        return id
+    }
+
+    switch action {
+    case "list":
+        if *state == "all" {
+            *state = ""
+        } else if *state != failureOpen && *state != failureRetried && *state != failureDismissed {
+            fmt.Fprintf(os.Stderr, "Error: --state must be open, retried, dismissed, or all\n")
+            os.Exit(2)
+        }
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        failures, err := db.GetFailedWebhooks(*state, *limit)
+        if err != nil {
+            logger.Fatalf("List failed: %v", err)
+        }
+        if len(failures) == 0 {
+            fmt.Println("No failed webhooks")
+            return
+        }
+        for _, f := range failures {
+            fmt.Printf("  #%d %s [%s] %s", f.ID, displayTime(f.ReceivedAt), f.ErrorClass, f.State)
+            if f.Source != "" {
+                fmt.Printf(" source=%s", f.Source)
+            }
+            if f.SourceIP != "" {
+                fmt.Printf(" from %s", f.SourceIP)
+            }
+            fmt.Println()
+            fmt.Printf("      %s\n", f.Error)
+            if f.ResolvedAt != nil {
+                fmt.Printf("      %s %s by %s", f.State, displayTime(*f.ResolvedAt), f.ResolvedBy)
+                if f.ResolutionNote != "" {
+                    fmt.Printf(": %s", f.ResolutionNote)
+                }
+                fmt.Println()
+            }
+            if *verbose {
+                for name, value := range f.Headers {
+                    fmt.Printf("      %s: %s\n", name, value)
+                }
+                fmt.Printf("      %s\n", f.Body)
+            }
+        }
+
+    case "retry":
+        id := parseID()
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        f, err := db.GetFailedWebhook(id)
+        if err != nil {
+            logger.Fatalf("Retry failed: %v", err)
+        }
+        if f.State != failureOpen {
+            fmt.Fprintf(os.Stderr, "Failure #%d is already %s\n", id, f.State)
+            os.Exit(1)
+        }
+
+        server := NewWebhookServer(db, mustLoadConfig(logger), logger)
+        if err := server.retryFailedWebhook(f, *by); err != nil {
+            fmt.Fprintf(os.Stderr, "Failure #%d still fails: %v\n", id, err)
+            os.Exit(1)
+        }
+        fmt.Printf("Failure #%d processed\n", id)
+
+    case "dismiss":
+        id := parseID()
+        if strings.TrimSpace(*reason) == "" {
+            fmt.Fprintln(os.Stderr, `Error: failed dismiss requires --reason "why"`)
+            os.Exit(2)
+        }
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        if err := db.ResolveFailedWebhook(id, failureDismissed, *by, *reason); err != nil {
+            if errors.Is(err, ErrFailureNotFound) {
+                fmt.Fprintf(os.Stderr, "No open failure #%d\n", id)
+                os.Exit(1)
+            }
+            logger.Fatalf("Dismiss failed: %v", err)
+        }
+        fmt.Printf("Failure #%d dismissed\n", id)
+
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown action %q\nUsage: %s\n", action, usage)
+        os.Exit(2)
+    }
+}
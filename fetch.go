@@ -0,0 +1,107 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// Defaults for fetching clean input over HTTP
+const (
+    defaultFetchTimeout  = 2 * time.Minute
+    defaultMaxFetchBytes = 200 << 20
+)
+
+// headerFlags collects repeatable --header "Name: value" flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+    return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+    if !strings.Contains(value, ":") {
+        return fmt.Errorf("header must look like \"Name: value\", got %q", value)
+    }
+    *h = append(*h, value)
+    return nil
+}
+
+// isURL reports whether a clean source should be fetched rather than opened
+func isURL(source string) bool {
+    return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// fetchToTempFile downloads url into a temporary file so that network
+// failures surface before any parsing or database work starts. Redirects are
+// followed, which Google Sheets export links rely on. The caller must call
+// the returned cleanup function.
+func fetchToTempFile(url string, headers []string, timeout time.Duration, maxBytes int64, logger *log.Logger) (string, func(), error) {
+    if timeout <= 0 {
+        timeout = defaultFetchTimeout
+    }
+    if maxBytes <= 0 {
+        maxBytes = defaultMaxFetchBytes
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return "", nil, fmt.Errorf("invalid URL: %w", err)
+    }
+    for _, header := range headers {
+        name, value, _ := strings.Cut(header, ":")
+        req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+    }
+
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return "", nil, fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+    }
+
+    // An HTML page usually means a login or sharing-permission screen
+    if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+        return "", nil, fmt.Errorf("fetch %s returned HTML, not CSV; check the link is a CSV export and is accessible", url)
+    }
+
+    tmp, err := os.CreateTemp("", "memberships-clean-*.csv")
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to create temp file: %w", err)
+    }
+    cleanup := func() {
+        tmp.Close()
+        os.Remove(tmp.Name())
+    }
+
+    // Read one byte past the limit to detect oversized downloads
+    n, err := io.Copy(tmp, io.LimitReader(resp.Body, maxBytes+1))
+    if err != nil {
+        cleanup()
+        return "", nil, fmt.Errorf("failed to download %s: %w", url, err)
+    }
+    if n > maxBytes {
+        cleanup()
+        return "", nil, fmt.Errorf("download from %s exceeds %d bytes", url, maxBytes)
+    }
+
+    if err := tmp.Close(); err != nil {
+        cleanup()
+        return "", nil, fmt.Errorf("failed to write temp file: %w", err)
+    }
+
+    logger.Printf("Fetched %d bytes from %s", n, url)
+
+    return tmp.Name(), cleanup, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "regexp"
+    "strings"
+
+    "github.com/lib/pq"
+)
+
+// ForgetResult describes what was scrubbed by ForgetMember
+type ForgetResult struct {
+    MemberID            int    `json:"member_id"`
+    OriginalEmail       string `json:"-"`
+    Placeholder         string `json:"placeholder_email"`
+    NameCleared         bool   `json:"name_cleared"`
+    WebhookLogsRedacted int    `json:"webhook_logs_redacted"`
+    AccessLogsRedacted  int    `json:"access_logs_redacted"`
+}
+
+// forgottenPlaceholder builds a non-reversible stand-in for an erased email
+func forgottenPlaceholder(email string) (string, error) {
+    salt := make([]byte, 16)
+    if _, err := rand.Read(salt); err != nil {
+        return "", fmt.Errorf("failed to generate salt: %w", err)
+    }
+
+    sum := sha256.Sum256(append(salt, []byte(email)...))
+    return "forgotten-" + hex.EncodeToString(sum[:8]) + "@redacted.invalid", nil
+}
+
+// forgetAddresses returns each distinct spelling of a member's address that
+// logs and queues may hold, lowercased: as asked for, as stored (a key in
+// privacy mode), as first received, and decrypted
+func forgetAddresses(addresses ...string) []string {
+    var unique []string
+    seen := map[string]bool{}
+    for _, address := range addresses {
+        address = strings.ToLower(strings.TrimSpace(address))
+        if address != "" && !seen[address] {
+            seen[address] = true
+            unique = append(unique, address)
+        }
+    }
+    return unique
+}
+
+// addressPattern is a case-insensitive regular expression, valid in Go and
+// Postgres, matching any of the addresses as they appear in a URL path or
+// query string, escaped or not
+func addressPattern(addresses []string) string {
+    var alternatives []string
+    seen := map[string]bool{}
+    for _, address := range addresses {
+        for _, form := range []string{address, url.PathEscape(address), url.QueryEscape(address)} {
+            if !seen[form] {
+                seen[form] = true
+                alternatives = append(alternatives, regexp.QuoteMeta(form))
+            }
+        }
+    }
+    return strings.Join(alternatives, "|")
+}
+
+// emailInTextPattern matches anything shaped like an email address in free
+// text; it's valid in Go and Postgres
+const emailInTextPattern = `[^[:space:]@]+@[^[:space:]@]+`
+
+// ForgetMember irreversibly scrubs a member's personal data: every stored
+// form of their address, their name, notes, and Discord link, and mentions
+// of them in logs and queues. The member row and its status history are
+// kept so aggregate statistics stay correct, along with its campaign and
+// tags, which describe the membership rather than the person.
+func (db *Database) ForgetMember(email string) (*ForgetResult, error) {
+    email = strings.TrimSpace(email)
+    key := db.NormalizeEmail(email)
+
+    if key == "" {
+        return nil, fmt.Errorf("email is required")
+    }
+
+    placeholder, err := forgottenPlaceholder(key)
+    if err != nil {
+        return nil, err
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    result := &ForgetResult{OriginalEmail: email, Placeholder: placeholder}
+
+    var name, rawEmail, ciphertext sql.NullString
+    err = tx.QueryRow(`
+        SELECT id, name, raw_email, email_ciphertext FROM members WHERE email = $1 FOR UPDATE
+    `, key).Scan(&result.MemberID, &name, &rawEmail, &ciphertext)
+
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, key)
+    } else if err != nil {
+        return nil, fmt.Errorf("database error: %w", err)
+    }
+
+    result.NameCleared = name.Valid && name.String != ""
+
+    decrypted := ""
+    if ciphertext.Valid && db.Privacy != nil && db.Privacy.CanDecrypt() {
+        if decrypted, err = db.Privacy.Open(ciphertext.String); err != nil {
+            return nil, fmt.Errorf("failed to decrypt email: %w", err)
+        }
+    }
+    addresses := forgetAddresses(email, key, normalizeEmail(email, db.NormalizeEmails), rawEmail.String, decrypted)
+
+    _, err = tx.Exec(`
+        UPDATE members SET
+            email = $1,
+            email_hash = NULL,
+            raw_email = NULL,
+            email_ciphertext = NULL,
+            name = NULL,
+            is_anonymous = true,
+            notes = NULL,
+            discord_id = NULL,
+            last_updated = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `, placeholder, result.MemberID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to scrub member: %w", err)
+    }
+
+    // Raw payloads carry the email and name, so strip those keys as well
+    res, err := tx.Exec(`
+        UPDATE webhook_logs SET
+            email = $1,
+            payload = CASE
+                WHEN jsonb_typeof(payload) = 'object'
+                THEN (payload - 'email' - 'name') || jsonb_build_object('redacted', true)
+                ELSE NULL
+            END
+        WHERE lower(email) = ANY($2) OR lower(payload->>'email') = ANY($2)
+    `, placeholder, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact webhook logs: %w", err)
+    }
+
+    redacted, _ := res.RowsAffected()
+    result.WebhookLogsRedacted = int(redacted)
+
+    // Failed webhook bodies may not even parse, so any mention drops the body
+    _, err = tx.Exec(`
+        UPDATE failed_webhooks SET body = '{"redacted":true}'
+        WHERE EXISTS (SELECT 1 FROM unnest($1::text[]) AS address WHERE strpos(lower(body), address) > 0)
+    `, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact failed webhooks: %w", err)
+    }
+
+    // Changes queued for review are only useful while someone can act on them
+    _, err = tx.Exec(`DELETE FROM pending_changes WHERE email = ANY($1)`, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to delete pending changes: %w", err)
+    }
+
+    // Sync runs keep the change for undo, under the placeholder
+    _, err = tx.Exec(`
+        UPDATE sync_run_changes SET email = $1 WHERE member_id = $2 OR lower(email) = ANY($3)
+    `, placeholder, result.MemberID, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact sync runs: %w", err)
+    }
+
+    // Mail errors often quote the recipient
+    _, err = tx.Exec(`UPDATE member_emails SET last_error = NULL WHERE member_id = $1`, result.MemberID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact member emails: %w", err)
+    }
+
+    // The access log keeps who read what, but not whose address it was
+    pattern := addressPattern(addresses)
+    res, err = tx.Exec(`
+        UPDATE access_logs SET
+            path = regexp_replace(path, $1, $2, 'gi'),
+            query = regexp_replace(query, $1, $2, 'gi')
+        WHERE path ~* $1 OR query ~* $1
+    `, pattern, placeholder)
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact access logs: %w", err)
+    }
+
+    redacted, _ = res.RowsAffected()
+    result.AccessLogsRedacted = int(redacted)
+
+    // The events feed keeps what happened, but not to which address
+    _, err = tx.Exec(`
+        UPDATE events SET payload = payload - 'email' - 'from_email' - 'to_email'
+        WHERE member_id = $1 OR lower(payload->>'email') = ANY($2)
+           OR lower(payload->>'from_email') = ANY($2) OR lower(payload->>'to_email') = ANY($2)
+    `, result.MemberID, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact events: %w", err)
+    }
+
+    // Details quote addresses too, e.g. "merged from" the member's other
+    // address: the member's own rows lose every address, others lose theirs
+    _, err = tx.Exec(`
+        UPDATE status_history SET
+            detail = regexp_replace(detail, CASE WHEN member_id = $1 THEN $2::text ELSE $3::text END, $4::text, 'gi'),
+            reason = regexp_replace(reason, CASE WHEN member_id = $1 THEN $2::text ELSE $3::text END, $4::text, 'gi')
+        WHERE (member_id = $1 AND (detail ~ $2::text OR reason ~ $2::text)) OR detail ~* $3::text OR reason ~* $3::text
+    `, result.MemberID, emailInTextPattern, pattern, placeholder)
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact status history: %w", err)
+    }
+    _, err = tx.Exec(`
+        UPDATE events SET
+            detail = regexp_replace(detail, CASE WHEN member_id = $1 THEN $2::text ELSE $3::text END, $4::text, 'gi')
+        WHERE (member_id = $1 AND detail ~ $2::text) OR detail ~* $3::text
+    `, result.MemberID, emailInTextPattern, pattern, placeholder)
+    if err != nil {
+        return nil, fmt.Errorf("failed to redact event details: %w", err)
+    }
+
+    err = recordEvent(tx, feedMemberForgotten, result.MemberID, ChangeSource{Source: "manual"}, map[string]interface{}{
+        "webhook_logs_redacted": result.WebhookLogsRedacted,
+        "access_logs_redacted":  result.AccessLogsRedacted,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    db.logger.Printf("Forgot member ID %d (%d webhook logs and %d access logs redacted)",
+        result.MemberID, result.WebhookLogsRedacted, result.AccessLogsRedacted)
+
+    return result, nil
+}
+
+// forgetHandler erases a member's personal data on request
+func (s *WebhookServer) forgetHandler(w http.ResponseWriter, r *http.Request) {
+    email := r.PathValue("email")
+
+    result, err := s.db.ForgetMember(email)
+    if err != nil {
+        if errors.Is(err, ErrMemberNotFound) {
+            writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+            return
+        }
+        s.logger.Printf("Error forgetting member: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
+
+func runForget(logger *log.Logger) {
+    forgetCmd := flag.NewFlagSet("forget", flag.ExitOnError)
+    confirm := forgetCmd.Bool("confirm", false, "Confirm the irreversible erasure")
+
+    args := parseSubcommand(forgetCmd, "memberships forget <email> --confirm", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: forget command requires an email address")
+        forgetCmd.Usage()
+        os.Exit(2)
+    }
+
+    email := args[0]
+
+    if !*confirm {
+        fmt.Println("Error: forget irreversibly erases personal data; re-run with --confirm")
+        os.Exit(1)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    result, err := db.ForgetMember(email)
+    if err != nil {
+        logger.Fatalf("Forget failed: %v", err)
+    }
+
+    fmt.Println("\n=== Member Forgotten ===")
+    fmt.Printf("Member ID:             %d\n", result.MemberID)
+    fmt.Printf("Email:                 %s -> %s\n", result.OriginalEmail, result.Placeholder)
+    if result.NameCleared {
+        fmt.Println("Name:                  cleared")
+    } else {
+        fmt.Println("Name:                  (none stored)")
+    }
+    fmt.Println("Anonymous:             set")
+    fmt.Printf("Webhook logs redacted: %d\n", result.WebhookLogsRedacted)
+    fmt.Printf("Access logs redacted:  %d\n", result.AccessLogsRedacted)
+    fmt.Println("Status history kept for aggregate statistics")
+    fmt.Println()
+}
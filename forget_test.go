@@ -0,0 +1,196 @@
+package main
+
+import (
+    "reflect"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+func TestForgetAddresses(t *testing.T) {
+    got := forgetAddresses(" Ada@Example.org ", "ada@example.org", "", "ADA+news@example.org")
+    want := []string{"ada@example.org", "ada+news@example.org"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got %v, want %v", got, want)
+    }
+}
+
+func TestAddressPattern(t *testing.T) {
+    pattern := regexp.MustCompile("(?i)" + addressPattern([]string{"ada+news@example.org"}))
+    for _, s := range []string{
+        "/v1/members/ada+news@example.org",
+        "/v1/members/ADA+NEWS@example.org/notes",
+        "email=ada%2Bnews%40example.org",
+        "email=ada%2bnews%40EXAMPLE.org",
+    } {
+        if !pattern.MatchString(s) {
+            t.Errorf("%q doesn't match %s", s, pattern)
+        }
+    }
+    for _, s := range []string{"/v1/members/adaXnews@example.org", "email=ada@example.org"} {
+        if pattern.MatchString(s) {
+            t.Errorf("%q matches %s", s, pattern)
+        }
+    }
+}
+
+func TestForgetMemberScrubsEveryCopy(t *testing.T) {
+    db := newMemStore()
+    db.NormalizeEmails = true
+
+    raw := "Ada.Lovelace+news@gmail.com"
+    if _, err := db.ProcessMember(raw, "Ada", false, StatusActive, ChangeSource{Source: "manual"}); err != nil {
+        t.Fatal(err)
+    }
+    key := db.NormalizeEmail(raw)
+    notes := "met at the spring fundraiser"
+    if err := db.UpdateMemberAnnotations(key, &notes, []string{"board"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := db.SetDiscordID(key, "1234"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := db.QueueMemberEmail(key, "welcome", ""); err != nil {
+        t.Fatal(err)
+    }
+    db.memberEmails[0].lastError = "550 no such user " + strings.ToLower(raw)
+
+    db.LogWebhook(strings.ToLower(raw), StatusActive, "default", []byte(`{"email":"`+raw+`","name":"Ada"}`))
+    db.QueuePendingChanges([]PendingChange{{Email: key, Action: "deactivate"}, {Email: "bo@example.org", Action: "add"}}, nil)
+    db.syncChanges = append(db.syncChanges, memSyncChange{runID: 1, email: strings.ToLower(raw), after: StatusActive})
+    db.RecordAccess([]AccessLogEntry{
+        {Path: "/v1/members/" + raw, Status: 200},
+        {Path: "/v1/members", Query: "email=ada.lovelace%2Bnews%40gmail.com&limit=5", Status: 200},
+        {Path: "/v1/members/bo@example.org", Status: 200},
+    })
+
+    // Forgetting works from any spelling that normalizes to the member
+    result, err := db.ForgetMember("adalovelace@googlemail.com")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.NameCleared || result.WebhookLogsRedacted != 1 || result.AccessLogsRedacted != 2 {
+        t.Errorf("got %+v", result)
+    }
+
+    m := db.members[result.MemberID]
+    if m.Email != result.Placeholder || m.RawEmail.Valid || m.Name.Valid || m.Notes.Valid || m.DiscordID.Valid || !m.IsAnonymous {
+        t.Errorf("member row still holds personal data: %+v", m.Member)
+    }
+    if !reflect.DeepEqual(m.Tags, []string{"board"}) {
+        t.Errorf("tags = %v, want them kept", m.Tags)
+    }
+    if db.memberEmails[0].lastError != "" {
+        t.Errorf("member email error kept: %q", db.memberEmails[0].lastError)
+    }
+    if len(db.pending) != 1 || db.pending[0].Email != "bo@example.org" {
+        t.Errorf("pending changes = %+v, want only bo@example.org's", db.pending)
+    }
+    if db.syncChanges[0].email != result.Placeholder {
+        t.Errorf("sync change email = %q", db.syncChanges[0].email)
+    }
+
+    for _, entry := range db.accessLogs {
+        text := strings.ToLower(entry.Path + "?" + entry.Query)
+        if strings.Contains(text, "lovelace") {
+            t.Errorf("access log still holds the address: %s", text)
+        }
+    }
+    if db.accessLogs[1].Query != "email="+result.Placeholder+"&limit=5" {
+        t.Errorf("query = %q, want the rest of it kept", db.accessLogs[1].Query)
+    }
+    if db.accessLogs[2].Path != "/v1/members/bo@example.org" {
+        t.Errorf("another member's access log was changed: %q", db.accessLogs[2].Path)
+    }
+
+    if _, err := db.ForgetMember(raw); err == nil {
+        t.Error("a forgotten member was found again")
+    }
+}
+
+func TestForgetMemberKeepsOthers(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, map[string]string{"ada@example.org": StatusActive, "bo@example.org": StatusActive})
+    db.LogWebhook("bo@example.org", StatusActive, "default", []byte(`{"email":"bo@example.org","name":"Bo"}`))
+
+    if _, err := db.ForgetMember("ADA@example.org"); err != nil {
+        t.Fatal(err)
+    }
+    if bo := db.member("bo@example.org"); bo == nil || bo.IsAnonymous {
+        t.Errorf("bo@example.org = %+v", bo)
+    }
+    if !strings.Contains(string(db.webhookLogs[0].Payload), "bo@example.org") {
+        t.Errorf("another member's webhook log was redacted: %s", db.webhookLogs[0].Payload)
+    }
+    if db.member("ada@example.org") != nil {
+        t.Error("ada@example.org can still be found")
+    }
+}
+
+func TestForgetMergedMember(t *testing.T) {
+    checkForgetMerged(t, newMemStore(), 0, "old@example.org", "ada@example.org")
+}
+
+func TestForgetMergedMemberPostgres(t *testing.T) {
+    db := openTestDatabase(t)
+    var since int64
+    if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM events`).Scan(&since); err != nil {
+        t.Fatal(err)
+    }
+    result := checkForgetMerged(t, db, since, "old@"+pgTestDomain, "ada@"+pgTestDomain)
+    if result != nil {
+        removeTestMembers(t, db, `$1`, result.MemberID)
+    }
+}
+
+// checkForgetMerged merges from into to, forgets to, and checks that neither
+// address is left in the details of its status history or of events after
+// since, where the merge quotes from
+func checkForgetMerged(t *testing.T, db Store, since int64, from, to string) *ForgetResult {
+    t.Helper()
+    if _, err := db.ProcessMember(to, "Ada", false, StatusActive, ChangeSource{Source: "manual"}); err != nil {
+        t.Fatal(err)
+    }
+    // The later status wins the merge, so it's recorded in status history
+    if _, err := db.ProcessMember(from, "", false, StatusCancelled, ChangeSource{Source: "manual"}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := db.MergeMembers(from, to); err != nil {
+        t.Fatal(err)
+    }
+
+    result, err := db.ForgetMember(to)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    history, err := db.GetStatusHistory(result.Placeholder, 100)
+    if err != nil {
+        t.Fatal(err)
+    }
+    events, err := db.GetEvents(since, nil, 100)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var details []string
+    merged := false
+    for _, h := range history {
+        details = append(details, h.Detail)
+        merged = merged || h.Source == "merge"
+    }
+    for _, e := range events {
+        details = append(details, e.Detail)
+    }
+    if !merged {
+        t.Errorf("no merge in status history: %+v", history)
+    }
+    for _, detail := range details {
+        if strings.Contains(detail, from) || strings.Contains(detail, to) {
+            t.Errorf("detail still holds an address: %q", detail)
+        }
+    }
+    if _, err := db.ForgetMember(to); err == nil {
+        t.Error("a forgotten member was found again")
+    }
+    return result
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "strings"
+)
+
+// Canonical recurring frequencies; members.frequency holds one of these and
+// members.frequency_raw the value as the source sent it
+const (
+    FrequencyMonthly   = "monthly"
+    FrequencyQuarterly = "quarterly"
+    FrequencyAnnual    = "annual"
+    FrequencyOther     = "other"
+)
+
+// frequencyUnknown labels members with no recorded frequency in breakdowns
+const frequencyUnknown = "unknown"
+
+// canonicalFrequencies lists the frequencies in display order
+var canonicalFrequencies = []string{FrequencyMonthly, FrequencyQuarterly, FrequencyAnnual, FrequencyOther}
+
+// normalizeFrequency maps a source's frequency ("Monthly", "Every month",
+// "ANNUAL") to the canonical set, or "" for a blank one
+func normalizeFrequency(raw string) string {
+    if strings.TrimSpace(raw) == "" {
+        return ""
+    }
+    months, ok := frequencyMonths(raw)
+    switch {
+    case !ok:
+        return FrequencyOther
+    case months == 1:
+        return FrequencyMonthly
+    case months == 3:
+        return FrequencyQuarterly
+    case months == 12:
+        return FrequencyAnnual
+    }
+    return FrequencyOther
+}
+
+// validFrequency reports whether value is a canonical frequency
+func validFrequency(value string) bool {
+    for _, f := range canonicalFrequencies {
+        if value == f {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// exportGiveLively is the export format laid out like a GiveLively donation
+// export, so the two can be diffed or the export fed back to clean
+const exportGiveLively = "givelively"
+
+// giveLivelyDateLayout is how GiveLively writes dates, in the display zone
+const giveLivelyDateLayout = "2006-01-02 15:04:05"
+
+// giveLivelyColumns are the GiveLively export's headers, in its order.
+// Columns with nothing to fill them are written empty, so spreadsheet
+// formulas that refer to them by position keep working.
+var giveLivelyColumns = []string{
+    "Date",
+    "Transaction ID",
+    "First Name",
+    "Last Name",
+    "Email",
+    "Phone",
+    "Amount",
+    "Currency",
+    "Frequency",
+    "Payment Status",
+    "Payment Method",
+    "Campaign",
+    "Anonymous",
+}
+
+// giveLivelyStatuses are the payment statuses standing in for each member
+// status, chosen so clean reads each back as the status it came from
+var giveLivelyStatuses = map[string]string{
+    StatusActive:    "Succeeded",
+    StatusSuspended: "Failed",
+    StatusCancelled: "Cancelled",
+    StatusLapsed:    "Cancelled",
+
+    // No rule mapped the member's status; "Pending" never activates anyone
+    StatusUnknown: "Pending",
+}
+
+// giveLivelyFrequencies are GiveLively's names for the canonical
+// frequencies; "other" has none and is left blank
+var giveLivelyFrequencies = map[string]string{
+    FrequencyMonthly:   "Monthly",
+    FrequencyQuarterly: "Quarterly",
+    FrequencyAnnual:    "Annually",
+}
+
+// LatestDonations returns each member's most recent donation by public id
+func (db *Database) LatestDonations() (map[string]Donation, error) {
+    rows, err := db.Query(`
+        SELECT DISTINCT ON (d.member_id)
+               m.public_id, (d.amount * 100)::bigint, d.currency, COALESCE(d.frequency, ''), d.occurred_at, d.source, d.external_id,
+               COALESCE(d.campaign, '')
+        FROM donations d
+        JOIN members m ON m.id = d.member_id
+        ORDER BY d.member_id, d.occurred_at DESC, d.id DESC
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get latest donations: %w", err)
+    }
+    defer rows.Close()
+
+    latest := make(map[string]Donation)
+    for rows.Next() {
+        var publicID string
+        var d Donation
+        if err := rows.Scan(&publicID, &d.AmountCents, &d.Currency, &d.Frequency, &d.OccurredAt, &d.Source, &d.ExternalID, &d.Campaign); err != nil {
+            return nil, err
+        }
+        latest[publicID] = d
+    }
+
+    return latest, rows.Err()
+}
+
+// giveLivelyRow lays out one member, with their latest donation if they
+// have one, as a GiveLively export row
+func giveLivelyRow(m Member, donation Donation, hasDonation bool) []string {
+    row := make([]string, len(giveLivelyColumns))
+    set := func(column, value string) {
+        for i, c := range giveLivelyColumns {
+            if c == column {
+                row[i] = value
+                return
+            }
+        }
+    }
+
+    if !m.IsAnonymous && m.Name.Valid {
+        first, last, _ := strings.Cut(strings.TrimSpace(m.Name.String), " ")
+        set("First Name", first)
+        set("Last Name", strings.TrimSpace(last))
+    }
+    email := m.Email
+    if m.RawEmail.Valid && m.RawEmail.String != "" {
+        email = m.RawEmail.String
+    }
+    set("Email", email)
+    set("Payment Status", giveLivelyStatuses[m.Status])
+    set("Anonymous", "False")
+    if m.IsAnonymous {
+        set("Anonymous", "True")
+    }
+
+    set("Campaign", m.Campaign.String)
+
+    frequency := ""
+    if m.Frequency.Valid {
+        frequency = giveLivelyFrequencies[m.Frequency.String]
+    }
+    if hasDonation {
+        set("Date", donation.OccurredAt.In(displayZone).Format(giveLivelyDateLayout))
+        set("Amount", formatCents(donation.AmountCents))
+        set("Currency", donation.Currency)
+
+        // Even a key made up for a donation without a transaction id is
+        // written, so feeding the export to clean doesn't record it twice
+        set("Transaction ID", donation.ExternalID)
+        if donation.Frequency != "" {
+            frequency = donation.Frequency
+        }
+        if donation.Campaign != "" {
+            set("Campaign", donation.Campaign)
+        }
+    }
+    set("Frequency", frequency)
+
+    return row
+}
+
+// writeGiveLivelyExport writes every member matching the filter in the
+// GiveLively export's column layout
+func writeGiveLivelyExport(w io.Writer, db Store, filter MemberFilter) error {
+    latest, err := db.LatestDonations()
+    if err != nil {
+        return err
+    }
+
+    buffered := bufio.NewWriter(w)
+    writer := csv.NewWriter(buffered)
+    writer.Write(giveLivelyColumns)
+    err = db.EachMember(filter, func(m Member) error {
+        donation, ok := latest[m.PublicID]
+        return writer.Write(giveLivelyRow(m, donation, ok))
+    })
+    writer.Flush()
+    if err == nil {
+        err = writer.Error()
+    }
+    if err != nil {
+        return err
+    }
+    return buffered.Flush()
+}
@@ -4,4 +4,4 @@ go 1.24.4
 
 require github.com/joho/godotenv v1.5.1
 
-require github.com/lib/pq v1.10.9 // indirect
+require github.com/lib/pq v1.10.9
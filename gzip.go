@@ -0,0 +1,166 @@
+package main
+
+import (
+    "compress/gzip"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// gzipMinSize is the smallest response worth compressing; anything shorter
+// is sent as-is
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{
+    New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+    for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+        coding, params, _ := strings.Cut(part, ";")
+        if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+            continue
+        }
+
+        // "gzip;q=0" explicitly refuses it
+        if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip. Output is
+// held back until gzipMinSize bytes or a Flush, so small responses go out
+// uncompressed and streaming handlers still stream.
+func (s *WebhookServer) gzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "Accept-Encoding")
+        if !acceptsGzip(r) || r.Method == http.MethodHead {
+            next(w, r)
+            return
+        }
+
+        gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+        defer gw.Close()
+        next(gw, r)
+    }
+}
+
+// gzipResponseWriter decides on compression once it has seen enough output
+type gzipResponseWriter struct {
+    http.ResponseWriter
+
+    status      int
+    wroteHeader bool
+    decided     bool
+    buf         []byte
+    gz          *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+    if g.wroteHeader {
+        return
+    }
+    g.wroteHeader = true
+    g.status = status
+
+    // Bodiless and already-encoded responses pass straight through
+    if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+        g.Header().Get("Content-Encoding") != "" {
+        g.decided = true
+        g.ResponseWriter.WriteHeader(status)
+    }
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+    if !g.wroteHeader {
+        g.WriteHeader(http.StatusOK)
+    }
+
+    if g.decided {
+        if g.gz != nil {
+            return g.gz.Write(p)
+        }
+        return g.ResponseWriter.Write(p)
+    }
+
+    g.buf = append(g.buf, p...)
+    if len(g.buf) >= gzipMinSize {
+        if err := g.start(true); err != nil {
+            return 0, err
+        }
+    }
+    return len(p), nil
+}
+
+// start commits to compressing or not and writes out anything buffered
+func (g *gzipResponseWriter) start(compress bool) error {
+    g.decided = true
+
+    if compress {
+        g.Header().Set("Content-Encoding", "gzip")
+        g.Header().Del("Content-Length")
+        g.gz = gzipWriters.Get().(*gzip.Writer)
+        g.gz.Reset(g.ResponseWriter)
+    }
+    g.ResponseWriter.WriteHeader(g.status)
+
+    buf := g.buf
+    g.buf = nil
+    if len(buf) == 0 {
+        return nil
+    }
+    if g.gz != nil {
+        _, err := g.gz.Write(buf)
+        return err
+    }
+    _, err := g.ResponseWriter.Write(buf)
+    return err
+}
+
+// Flush sends everything written so far, compressing it if the response is
+// a stream that hasn't reached gzipMinSize yet
+func (g *gzipResponseWriter) Flush() {
+    if !g.decided {
+        if !g.wroteHeader {
+            g.WriteHeader(http.StatusOK)
+        }
+        if !g.decided {
+            g.start(true)
+        }
+    }
+    if g.gz != nil {
+        g.gz.Flush()
+    }
+    if flusher, ok := g.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+// Close finishes the response, sending short bodies uncompressed
+func (g *gzipResponseWriter) Close() {
+    if !g.decided {
+        if !g.wroteHeader {
+            g.WriteHeader(http.StatusOK)
+        }
+        if !g.decided {
+            g.start(false)
+        }
+    }
+    if g.gz != nil {
+        g.gz.Close()
+        gzipWriters.Put(g.gz)
+        g.gz = nil
+    }
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+    return g.ResponseWriter
+}
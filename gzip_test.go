@@ -0,0 +1,181 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+    tests := []struct {
+        header string
+        want   bool
+    }{
+        {"", false},
+        {"gzip", true},
+        {"GZIP", true},
+        {"deflate, gzip;q=0.8", true},
+        {"br, deflate", false},
+        {"gzip;q=0", false},
+        {" gzip ; q=0.5", true},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest("GET", "/", nil)
+        r.Header.Set("Accept-Encoding", tt.header)
+        if got := acceptsGzip(r); got != tt.want {
+            t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+        }
+    }
+}
+
+// gzipGet requests path from a server wrapping handler in gzipMiddleware,
+// asking for gzip without letting the transport decode it
+func gzipGet(t *testing.T, handler http.HandlerFunc) *http.Response {
+    t.Helper()
+    server := httptest.NewServer((&WebhookServer{}).gzipMiddleware(handler))
+    t.Cleanup(server.Close)
+
+    client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+    req, _ := http.NewRequest("GET", server.URL, nil)
+    req.Header.Set("Accept-Encoding", "gzip")
+    resp, err := client.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { resp.Body.Close() })
+    return resp
+}
+
+func TestGzipSizes(t *testing.T) {
+    small := strings.Repeat("a", gzipMinSize-1)
+    resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, small) })
+    body, _ := io.ReadAll(resp.Body)
+    if resp.Header.Get("Content-Encoding") != "" || string(body) != small {
+        t.Errorf("a %d byte response was encoded %q", len(small), resp.Header.Get("Content-Encoding"))
+    }
+
+    large := strings.Repeat("member,", gzipMinSize)
+    resp = gzipGet(t, func(w http.ResponseWriter, r *http.Request) {
+        // Written in pieces smaller than gzipMinSize, so buffering decides
+        for i := 0; i < len(large); i += 100 {
+            io.WriteString(w, large[i:min(i+100, len(large))])
+        }
+    })
+    if resp.Header.Get("Content-Encoding") != "gzip" {
+        t.Fatalf("a %d byte response wasn't compressed", len(large))
+    }
+    gz, err := gzip.NewReader(resp.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if body, _ := io.ReadAll(gz); string(body) != large {
+        t.Errorf("decompressed %d bytes, want %d", len(body), len(large))
+    }
+}
+
+func TestGzipPassesThroughBodilessResponses(t *testing.T) {
+    resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+    if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Content-Encoding") != "" {
+        t.Errorf("got %d encoded %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+    }
+    if !strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding") {
+        t.Error("missing Vary: Accept-Encoding")
+    }
+}
+
+// A stream shorter than gzipMinSize must reach the client at each Flush, not
+// when the handler returns
+func TestGzipStreams(t *testing.T) {
+    release := make(chan struct{})
+    done := make(chan struct{})
+    resp := gzipGet(t, func(w http.ResponseWriter, r *http.Request) {
+        defer close(done)
+        for i := 1; i <= 3; i++ {
+            fmt.Fprintf(w, "event %d\n", i)
+            w.(http.Flusher).Flush()
+            if i == 1 {
+                <-release
+            }
+        }
+    })
+    defer func() {
+        select {
+        case <-release:
+        default:
+            close(release)
+        }
+        <-done
+    }()
+
+    if resp.Header.Get("Content-Encoding") != "gzip" {
+        t.Fatalf("a flushed stream was encoded %q", resp.Header.Get("Content-Encoding"))
+    }
+
+    lines := make(chan string)
+    go func() {
+        defer close(lines)
+        gz, err := gzip.NewReader(resp.Body)
+        if err != nil {
+            return
+        }
+        scanner := bufio.NewScanner(gz)
+        for scanner.Scan() {
+            lines <- scanner.Text()
+        }
+    }()
+
+    // The handler is still blocked, so this line can only have come from
+    // the first Flush
+    select {
+    case line := <-lines:
+        if line != "event 1" {
+            t.Fatalf("first line = %q", line)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("the first event wasn't sent until the handler finished")
+    }
+
+    close(release)
+    var rest []string
+    for line := range lines {
+        rest = append(rest, line)
+    }
+    if strings.Join(rest, ",") != "event 2,event 3" {
+        t.Errorf("rest of the stream = %v", rest)
+    }
+}
+
+// BenchmarkGzipStream compares a stream of small events flushed one by one
+// against the same bytes written at once
+func BenchmarkGzipStream(b *testing.B) {
+    event := []byte(`data: {"type":"member.updated","email":"ada@example.org","status":"active"}` + "\n\n")
+    for _, flush := range []bool{false, true} {
+        b.Run(fmt.Sprintf("flush=%v", flush), func(b *testing.B) {
+            handler := (&WebhookServer{}).gzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+                for i := 0; i < 100; i++ {
+                    w.Write(event)
+                    if flush {
+                        w.(http.Flusher).Flush()
+                    }
+                }
+            })
+            r := httptest.NewRequest("GET", "/events", nil)
+            r.Header.Set("Accept-Encoding", "gzip")
+
+            b.ReportAllocs()
+            b.SetBytes(int64(100 * len(event)))
+            for i := 0; i < b.N; i++ {
+                w := httptest.NewRecorder()
+                w.Body = bytes.NewBuffer(make([]byte, 0, 8192))
+                handler(w, r)
+            }
+        })
+    }
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+
+    "github.com/lib/pq"
+)
+
+// householdSource attributes status changes that come from a member's
+// household: linking, unlinking, and following the primary member
+const householdSource = "household"
+
+// ErrHouseholdMember is returned for a direct status change to a member
+// whose status follows their household's primary member
+var ErrHouseholdMember = errors.New("status follows household")
+
+// ErrHouseholdLink is returned for a link or unlink that can't be made
+var ErrHouseholdLink = errors.New("cannot change household")
+
+// HouseholdLink is the outcome of linking or unlinking a member
+type HouseholdLink struct {
+    Primary   string `json:"primary"`
+    Secondary string `json:"secondary"`
+    Status    string `json:"status"`
+    Created   bool   `json:"created,omitempty"`
+    Unchanged bool   `json:"unchanged,omitempty"`
+}
+
+// setHouseholdStatus moves a household member to status, recording history
+// and an event when it changes
+func setHouseholdStatus(q querier, memberID int, email, before, status string, change ChangeSource) error {
+    if before == status {
+        return nil
+    }
+
+    _, err := q.Exec(`
+        UPDATE members SET status = $2, last_updated = CURRENT_TIMESTAMP WHERE id = $1
+    `, memberID, status)
+    if err != nil {
+        return fmt.Errorf("failed to update household member: %w", err)
+    }
+    if err := recordStatusHistory(q, memberID, status, change); err != nil {
+        return fmt.Errorf("failed to record status history: %w", err)
+    }
+    return recordStatusEvent(q, memberID, email, before, status, change)
+}
+
+// followHousehold brings the members linked to each primary in line with
+// the primary's status. Members who aren't primaries have no one to update.
+func followHousehold(q querier, primaryIDs ...int64) error {
+    rows, err := q.Query(`
+        SELECT s.id, s.email, s.status, p.id, p.status
+        FROM members s
+        JOIN members p ON p.id = s.household_id
+        WHERE p.id = ANY($1) AND s.id <> p.id AND s.status <> p.status
+        FOR UPDATE OF s
+    `, pq.Array(primaryIDs))
+    if err != nil {
+        return fmt.Errorf("failed to load household members: %w", err)
+    }
+
+    type follower struct {
+        id, primaryID         int
+        email, before, status string
+    }
+    var followers []follower
+    for rows.Next() {
+        var f follower
+        if err := rows.Scan(&f.id, &f.email, &f.before, &f.primaryID, &f.status); err != nil {
+            rows.Close()
+            return err
+        }
+        followers = append(followers, f)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    for _, f := range followers {
+        change := ChangeSource{Source: householdSource, Detail: fmt.Sprintf("follows member #%d", f.primaryID)}
+        if err := setHouseholdStatus(q, f.id, f.email, f.before, f.status, change); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// mergeHousehold hands the members following from's household to the
+// household to belongs to, so merging a primary doesn't orphan them, and
+// brings that household in line with its primary
+func mergeHousehold(q querier, fromID, toID int) error {
+    var primaryID int
+    err := q.QueryRow(`SELECT COALESCE(household_id, id) FROM members WHERE id = $1`, toID).Scan(&primaryID)
+    if err != nil {
+        return fmt.Errorf("failed to load household: %w", err)
+    }
+
+    result, err := q.Exec(`
+        UPDATE members SET household_id = $2 WHERE household_id = $1 AND id NOT IN ($1, $2)
+    `, fromID, primaryID)
+    if err != nil {
+        return fmt.Errorf("failed to move household members: %w", err)
+    }
+    if moved, _ := result.RowsAffected(); moved > 0 {
+        _, err = q.Exec(`UPDATE members SET household_id = id WHERE id = $1 AND household_id IS NULL`, primaryID)
+        if err != nil {
+            return fmt.Errorf("failed to move household members: %w", err)
+        }
+    }
+
+    // The merge may also have changed the survivor's status
+    return followHousehold(q, int64(primaryID))
+}
+
+// notDerived matches members who don't follow another member's household:
+// independent members and household primaries
+const notDerived = "(household_id IS NULL OR household_id = id)"
+
+// countedMembers is the condition for members that count toward stats:
+// everyone, or only those not derived when counting households
+func (db *Database) countedMembers() string {
+    if db.CountHouseholds {
+        return notDerived
+    }
+    return "true"
+}
+
+// householdRow is a member locked for a household change
+type householdRow struct {
+    id        int
+    status    string
+    household sql.NullInt64
+}
+
+// derived reports whether the member follows another member's household
+func (r *householdRow) derived() bool {
+    return r.household.Valid && int(r.household.Int64) != r.id
+}
+
+func lockHouseholdRow(q querier, email string) (*householdRow, error) {
+    var row householdRow
+    err := q.QueryRow(`
+        SELECT id, status, household_id FROM members WHERE email = $1 FOR UPDATE
+    `, email).Scan(&row.id, &row.status, &row.household)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    } else if err != nil {
+        return nil, fmt.Errorf("failed to load member: %w", err)
+    }
+    return &row, nil
+}
+
+// LinkHousehold adds secondary to primary's household. The secondary takes
+// the primary's status from then on, and clean and webhooks stop changing it
+// directly. A secondary who isn't a member yet is created, with name.
+func (db *Database) LinkHousehold(primaryEmail, secondaryEmail, name, by string) (*HouseholdLink, error) {
+    primary := db.NormalizeEmail(primaryEmail)
+    secondary := db.NormalizeEmail(secondaryEmail)
+    if primary == secondary {
+        return nil, fmt.Errorf("%w: a member can't be linked to themselves", ErrHouseholdLink)
+    }
+
+    link := &HouseholdLink{Primary: primary, Secondary: secondary}
+    err := db.inTx(func(tx *sql.Tx) error {
+        p, err := lockHouseholdRow(tx, primary)
+        if err != nil {
+            return err
+        }
+        if p.derived() {
+            return fmt.Errorf("%w: %s is itself linked to member #%d's household", ErrHouseholdLink, primary, p.household.Int64)
+        }
+        change := ChangeSource{Source: householdSource, Detail: fmt.Sprintf("linked to member #%d by %s", p.id, by)}
+
+        s, err := lockHouseholdRow(tx, secondary)
+        if errors.Is(err, ErrMemberNotFound) {
+            if _, err := db.processMember(tx, secondaryEmail, name, false, p.status, change); err != nil {
+                return err
+            }
+            link.Created = true
+            s, err = lockHouseholdRow(tx, secondary)
+        }
+        if err != nil {
+            return err
+        }
+
+        switch {
+        case s.household.Valid && int(s.household.Int64) == p.id:
+            link.Status = s.status
+            link.Unchanged = true
+            return nil
+        case s.household.Valid && !s.derived():
+            return fmt.Errorf("%w: %s is the primary of its own household; unlink its members first", ErrHouseholdLink, secondary)
+        case s.household.Valid:
+            return fmt.Errorf("%w: %s is already linked to member #%d's household; unlink it first", ErrHouseholdLink, secondary, s.household.Int64)
+        }
+
+        _, err = tx.Exec(`UPDATE members SET household_id = id WHERE id = $1 AND household_id IS NULL`, p.id)
+        if err != nil {
+            return fmt.Errorf("failed to link household: %w", err)
+        }
+        _, err = tx.Exec(`
+            UPDATE members SET household_id = $2, last_updated = CURRENT_TIMESTAMP WHERE id = $1
+        `, s.id, p.id)
+        if err != nil {
+            return fmt.Errorf("failed to link household: %w", err)
+        }
+
+        err = recordEvent(tx, feedMemberUpdated, s.id, change, map[string]interface{}{
+            "email": secondary,
+            "changes": map[string]interface{}{
+                "household": map[string]interface{}{"before": nil, "after": primary},
+            },
+        })
+        if err != nil {
+            return err
+        }
+
+        link.Status = p.status
+        return setHouseholdStatus(tx, s.id, secondary, s.status, p.status, change)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if !link.Unchanged {
+        db.logger.Printf("Linked %s to %s's household (%s)", secondary, primary, link.Status)
+    }
+    return link, nil
+}
+
+// UnlinkHousehold takes a member out of their household. They become an
+// independent member again, cancelled until they pay for themselves.
+func (db *Database) UnlinkHousehold(email, by string) (*HouseholdLink, error) {
+    email = db.NormalizeEmail(email)
+
+    link := &HouseholdLink{Secondary: email, Status: StatusCancelled}
+    err := db.inTx(func(tx *sql.Tx) error {
+        s, err := lockHouseholdRow(tx, email)
+        if err != nil {
+            return err
+        }
+        if !s.household.Valid {
+            return fmt.Errorf("%w: %s isn't linked to a household", ErrHouseholdLink, email)
+        }
+        if !s.derived() {
+            return fmt.Errorf("%w: %s is a household's primary member; unlink the others instead", ErrHouseholdLink, email)
+        }
+        primaryID := int(s.household.Int64)
+
+        if err := tx.QueryRow(`SELECT email FROM members WHERE id = $1`, primaryID).Scan(&link.Primary); err != nil {
+            return fmt.Errorf("failed to load household: %w", err)
+        }
+        change := ChangeSource{Source: householdSource, Detail: fmt.Sprintf("unlinked from member #%d by %s", primaryID, by)}
+
+        _, err = tx.Exec(`
+            UPDATE members SET household_id = NULL, last_updated = CURRENT_TIMESTAMP WHERE id = $1
+        `, s.id)
+        if err != nil {
+            return fmt.Errorf("failed to unlink household: %w", err)
+        }
+
+        // A primary with no one left is an ordinary member again
+        _, err = tx.Exec(`
+            UPDATE members SET household_id = NULL
+            WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM members WHERE household_id = $1 AND id <> $1)
+        `, primaryID)
+        if err != nil {
+            return fmt.Errorf("failed to unlink household: %w", err)
+        }
+
+        err = recordEvent(tx, feedMemberUpdated, s.id, change, map[string]interface{}{
+            "email": email,
+            "changes": map[string]interface{}{
+                "household": map[string]interface{}{"before": link.Primary, "after": nil},
+            },
+        })
+        if err != nil {
+            return err
+        }
+
+        return setHouseholdStatus(tx, s.id, email, s.status, StatusCancelled, change)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    db.logger.Printf("Unlinked %s from %s's household (%s)", email, link.Primary, link.Status)
+    return link, nil
+}
+
+// HouseholdPrimary returns the primary member of the household email
+// follows; linked is false for independent members and primaries
+func (db *Database) HouseholdPrimary(email string) (primary string, linked bool, err error) {
+    err = db.QueryRow(`
+        SELECT p.email FROM members s
+        JOIN members p ON p.id = s.household_id
+        WHERE s.email = $1 AND s.id <> p.id
+    `, db.NormalizeEmail(email)).Scan(&primary)
+    if err == sql.ErrNoRows {
+        return "", false, nil
+    } else if err != nil {
+        return "", false, fmt.Errorf("database error: %w", err)
+    }
+    return primary, true, nil
+}
+
+// GetHouseholdMembers returns the members linked to another member's
+// household, by email, for clean to leave alone
+func (db *Database) GetHouseholdMembers() (map[string]bool, error) {
+    rows, err := db.Query(`SELECT email FROM members WHERE household_id <> id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    members := make(map[string]bool)
+    for rows.Next() {
+        var email string
+        if err := rows.Scan(&email); err != nil {
+            return nil, err
+        }
+        members[strings.ToLower(email)] = true
+    }
+    return members, rows.Err()
+}
+
+// GetHousehold returns the household email belongs to, primary first, or
+// nil if it isn't in one
+func (db *Database) GetHousehold(email string) ([]string, error) {
+    rows, err := db.Query(`
+        SELECT h.email FROM members m
+        JOIN members h ON h.household_id = m.household_id
+        WHERE m.email = $1
+        ORDER BY h.id <> h.household_id, h.id
+    `, db.NormalizeEmail(email))
+    if err != nil {
+        return nil, fmt.Errorf("failed to load household: %w", err)
+    }
+    defer rows.Close()
+
+    var household []string
+    for rows.Next() {
+        var member string
+        if err := rows.Scan(&member); err != nil {
+            return nil, err
+        }
+        household = append(household, member)
+    }
+    return household, rows.Err()
+}
+
+// householdRequest is the body of POST /members/link and /members/unlink
+type householdRequest struct {
+    Primary   string `json:"primary"`
+    Secondary string `json:"secondary"`
+    Name      string `json:"name"`
+}
+
+// linkHouseholdHandler links a secondary member to a primary's household
+func (s *WebhookServer) linkHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+    var req householdRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Primary == "" || req.Secondary == "" {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"primary\": \"...\", \"secondary\": \"...\"}")
+        return
+    }
+
+    link, err := s.db.LinkHousehold(req.Primary, req.Secondary, req.Name, s.principal(r))
+    s.writeHouseholdLink(w, r, link, err)
+}
+
+// unlinkHouseholdHandler makes a household's secondary member independent
+func (s *WebhookServer) unlinkHouseholdHandler(w http.ResponseWriter, r *http.Request) {
+    var req householdRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Secondary == "" {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"secondary\": \"...\"}")
+        return
+    }
+
+    link, err := s.db.UnlinkHousehold(req.Secondary, s.principal(r))
+    s.writeHouseholdLink(w, r, link, err)
+}
+
+func (s *WebhookServer) writeHouseholdLink(w http.ResponseWriter, r *http.Request, link *HouseholdLink, err error) {
+    switch {
+    case errors.Is(err, ErrMemberNotFound):
+        writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+        return
+    case errors.Is(err, ErrHouseholdLink):
+        writeError(w, r, http.StatusConflict, errConflict, err.Error())
+        return
+    case errors.Is(err, ErrInvalidEmail):
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+        return
+    case err != nil:
+        s.logger.Printf("Error changing household: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(link)
+}
+
+func runLink(logger *log.Logger) {
+    linkCmd := flag.NewFlagSet("link", flag.ExitOnError)
+    name := linkCmd.String("name", "", "Name for the secondary member, if they're new")
+    by := linkCmd.String("by", os.Getenv("USER"), "Who is linking them (default: $USER)")
+
+    args := parseSubcommand(linkCmd, `memberships link <primary-email> <secondary-email> [--name "Full Name"] [--by NAME]`, os.Args[2:])
+    if len(args) != 2 {
+        fmt.Fprintln(os.Stderr, "Error: link requires the primary member's email and the secondary's")
+        linkCmd.Usage()
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    link, err := db.LinkHousehold(args[0], args[1], *name, *by)
+    if errors.Is(err, ErrMemberNotFound) || errors.Is(err, ErrHouseholdLink) || errors.Is(err, ErrInvalidEmail) {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Link failed: %v", err)
+    }
+
+    switch {
+    case link.Unchanged:
+        fmt.Printf("%s is already in %s's household\n", link.Secondary, link.Primary)
+    case link.Created:
+        fmt.Printf("Added %s to %s's household as a new member (%s)\n", link.Secondary, link.Primary, link.Status)
+    default:
+        fmt.Printf("Linked %s to %s's household (%s)\n", link.Secondary, link.Primary, link.Status)
+    }
+}
+
+func runUnlink(logger *log.Logger) {
+    unlinkCmd := flag.NewFlagSet("unlink", flag.ExitOnError)
+    by := unlinkCmd.String("by", os.Getenv("USER"), "Who is unlinking them (default: $USER)")
+
+    args := parseSubcommand(unlinkCmd, "memberships unlink <secondary-email> [--by NAME]", os.Args[2:])
+    if len(args) != 1 {
+        fmt.Fprintln(os.Stderr, "Error: unlink requires the email of a household's secondary member")
+        unlinkCmd.Usage()
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    link, err := db.UnlinkHousehold(args[0], *by)
+    if errors.Is(err, ErrMemberNotFound) || errors.Is(err, ErrHouseholdLink) {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Unlink failed: %v", err)
+    }
+
+    fmt.Printf("Unlinked %s from %s's household; it is now an independent member (%s)\n", link.Secondary, link.Primary, link.Status)
+}
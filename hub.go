@@ -0,0 +1,178 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// streamHeartbeat is how often an idle event stream sends a comment so
+// proxies keep the connection open. Each heartbeat also picks up events
+// written by other processes, such as the CLI.
+const streamHeartbeat = 15 * time.Second
+
+// streamEventTypes are the feed events sent on /events/stream
+var streamEventTypes = []string{feedMemberCreated, feedMemberUpdated, feedStatusChanged}
+
+// EventHub wakes event streams when new feed events are committed. Streams
+// read the events themselves from the events table, so a wakeup carries no
+// data and a missed one only delays delivery until the next heartbeat.
+type EventHub struct {
+    mu     sync.Mutex
+    subs   map[chan struct{}]struct{}
+    closed bool
+}
+
+// NewEventHub creates an empty hub
+func NewEventHub() *EventHub {
+    return &EventHub{subs: make(map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel that receives a value after events are
+// published and is closed when the hub shuts down, and a function to
+// unsubscribe
+func (h *EventHub) Subscribe() (<-chan struct{}, func()) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    ch := make(chan struct{}, 1)
+    if h.closed {
+        close(ch)
+        return ch, func() {}
+    }
+    h.subs[ch] = struct{}{}
+
+    return ch, func() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        if _, ok := h.subs[ch]; ok {
+            delete(h.subs, ch)
+            close(ch)
+        }
+    }
+}
+
+// Publish wakes every subscriber. It never blocks, and is a no-op on a nil
+// hub so CLI commands can share the code paths that call it.
+func (h *EventHub) Publish() {
+    if h == nil {
+        return
+    }
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    for ch := range h.subs {
+        select {
+        case ch <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// Close ends every subscription, letting open streams finish during a
+// graceful shutdown
+func (h *EventHub) Close() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    h.closed = true
+    for ch := range h.subs {
+        delete(h.subs, ch)
+        close(ch)
+    }
+}
+
+// LatestEventID returns the id of the newest feed event, or 0
+func (db *Database) LatestEventID() (int64, error) {
+    var id int64
+    err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM events`).Scan(&id)
+    return id, err
+}
+
+// eventStreamHandler serves GET /events/stream as Server-Sent Events. A
+// client reconnecting with Last-Event-ID is sent everything it missed from the
+// events table; a new client starts from now.
+func (s *WebhookServer) eventStreamHandler(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Streaming not supported")
+        return
+    }
+
+    var cursor int64
+    if value := r.Header.Get("Last-Event-ID"); value != "" {
+        n, err := strconv.ParseInt(value, 10, 64)
+        if err != nil || n < 0 {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid Last-Event-ID")
+            return
+        }
+        cursor = n
+    } else {
+        latest, err := s.db.LatestEventID()
+        if err != nil {
+            s.logger.Printf("Error getting events: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+        cursor = latest
+    }
+
+    wake, unsubscribe := s.db.EventHub().Subscribe()
+    defer unsubscribe()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprint(w, "retry: 5000\n\n")
+    flusher.Flush()
+
+    // send writes every event after the cursor
+    send := func() error {
+        for {
+            events, err := s.db.GetEvents(cursor, streamEventTypes, maxEventsLimit)
+            if err != nil {
+                return err
+            }
+            for _, e := range events {
+                data, err := json.Marshal(e)
+                if err != nil {
+                    return err
+                }
+                if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+                    return err
+                }
+                cursor = e.ID
+            }
+            flusher.Flush()
+            if len(events) < maxEventsLimit {
+                return nil
+            }
+        }
+    }
+
+    heartbeat := time.NewTicker(streamHeartbeat)
+    defer heartbeat.Stop()
+
+    err := send()
+    for err == nil {
+        select {
+        case <-r.Context().Done():
+            return
+        case _, open := <-wake:
+            if !open {
+                return
+            }
+            err = send()
+        case <-heartbeat.C:
+            if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err == nil {
+                err = send()
+            }
+        }
+    }
+
+    s.logger.Printf("Event stream to %s ended: %v", s.clientIP(r), err)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+    "bufio"
+    "database/sql"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// Header aliases for import files, compared after normalizeHeader
+var (
+    nameColumnAliases      = []string{"name", "full name", "donor name", "donor full name"}
+    anonymousColumnAliases = []string{"anonymous", "is anonymous", "anonymous donation"}
+    memberStatusAliases    = []string{"status", "member status", "membership status"}
+)
+
+// ImportError is an import row that was skipped
+type ImportError struct {
+    Line  int    `json:"line"`
+    Email string `json:"email,omitempty"`
+    Error string `json:"error"`
+}
+
+// ImportResult describes an import run
+type ImportResult struct {
+    RunAt     time.Time     `json:"run_at"`
+    File      string        `json:"file"`
+    DryRun    bool          `json:"dry_run"`
+    Inserted  int           `json:"inserted"`
+    Updated   int           `json:"updated"`
+    Skipped   int           `json:"skipped"`
+    Donations int           `json:"donations"`
+    Errors    []ImportError `json:"errors"`
+}
+
+// defaultImportReportPath names an import report after the current time
+func defaultImportReportPath() string {
+    return fmt.Sprintf("import-%s.json", time.Now().Format("2006-01-02-150405"))
+}
+
+// BulkInsertMembers creates or updates members wholesale in one transaction.
+// New members are loaded with COPY; members that already exist, or that a
+// concurrent writer created first, are upserted row by row. An import never
+// deactivates anyone: existing members only take the imported status when it
+// is active. Repeated emails after the first are skipped. Donations are
+// recorded once their members exist. A dry run does the work and rolls it
+// back.
+func (db *Database) BulkInsertMembers(members []Member, donations []Donation, change ChangeSource, dryRun bool) (*ImportResult, error) {
+    result := &ImportResult{DryRun: dryRun}
+
+    byEmail := make(map[string]Member, len(members))
+    var emails []string
+    for _, m := range members {
+        email := db.NormalizeEmail(m.Email)
+        if _, dup := byEmail[email]; dup {
+            result.Skipped++
+            continue
+        }
+        if m.IsAnonymous {
+            m.Name = sql.NullString{}
+        }
+        byEmail[email] = m
+        emails = append(emails, email)
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    existing, err := memberStatusesIn(tx, emails)
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.Exec(`
+        CREATE TEMP TABLE import_members (
+            email TEXT, email_hash TEXT, raw_email TEXT, email_ciphertext TEXT, name TEXT,
+            is_anonymous BOOLEAN, status TEXT, frequency TEXT, frequency_raw TEXT, campaign TEXT
+        ) ON COMMIT DROP
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create import table: %w", err)
+    }
+
+    stmt, err := tx.Prepare(pq.CopyIn("import_members",
+        "email", "email_hash", "raw_email", "email_ciphertext", "name", "is_anonymous", "status", "frequency", "frequency_raw", "campaign"))
+    if err != nil {
+        return nil, fmt.Errorf("failed to start COPY: %w", err)
+    }
+    for _, email := range emails {
+        if _, ok := existing[email]; ok {
+            continue
+        }
+        m := byEmail[email]
+        raw, ciphertext, err := db.storedEmail(m.Email)
+        if err != nil {
+            stmt.Close()
+            return nil, err
+        }
+        _, err = stmt.Exec(email, emailHash(email), raw, ciphertext, m.Name, m.IsAnonymous, m.Status,
+            normalizeFrequency(m.Frequency.String), strings.TrimSpace(m.Frequency.String), strings.TrimSpace(m.Campaign.String))
+        if err != nil {
+            stmt.Close()
+            return nil, fmt.Errorf("failed to copy %s: %w", email, err)
+        }
+    }
+    if _, err := stmt.Exec(); err != nil {
+        stmt.Close()
+        return nil, fmt.Errorf("failed to copy members: %w", err)
+    }
+    if err := stmt.Close(); err != nil {
+        return nil, fmt.Errorf("failed to copy members: %w", err)
+    }
+
+    rows, err := tx.Query(`
+        INSERT INTO members (email, email_hash, raw_email, email_ciphertext, name, is_anonymous, status, frequency, frequency_raw,
+                             campaign, first_seen, last_updated)
+        SELECT email, email_hash, raw_email, email_ciphertext, NULLIF(name, ''), is_anonymous, status, NULLIF(frequency, ''),
+               NULLIF(frequency_raw, ''), NULLIF(campaign, ''), CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+        FROM import_members
+        ON CONFLICT DO NOTHING
+        RETURNING id, email, status
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to insert members: %w", err)
+    }
+
+    inserted := make(map[string]bool)
+    var ids []int64
+    var insertedEmails, statuses []string
+    for rows.Next() {
+        var id int64
+        var email, status string
+        if err := rows.Scan(&id, &email, &status); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        inserted[email] = true
+        ids = append(ids, id)
+        insertedEmails = append(insertedEmails, email)
+        statuses = append(statuses, status)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    result.Inserted = len(ids)
+
+    _, err = tx.Exec(`
+        INSERT INTO status_history (member_id, status, source, detail)
+        SELECT t.id, t.status, COALESCE(NULLIF($3, ''), 'unknown'), NULLIF($4, '')
+        FROM unnest($1::int[], $2::text[]) AS t(id, status)
+    `, pq.Array(ids), pq.Array(statuses), change.Source, change.Detail)
+    if err != nil {
+        return nil, fmt.Errorf("failed to record status history: %w", err)
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO events (type, member_id, source, detail, payload)
+        SELECT $4, t.id, COALESCE(NULLIF($5, ''), 'unknown'), NULLIF($6, ''),
+               jsonb_build_object('email', t.email, 'status', t.status)
+        FROM unnest($1::int[], $2::text[], $3::text[]) AS t(id, email, status)
+    `, pq.Array(ids), pq.Array(insertedEmails), pq.Array(statuses), feedMemberCreated, change.Source, change.Detail)
+    if err != nil {
+        return nil, fmt.Errorf("failed to record events: %w", err)
+    }
+
+    // Rows that existed beforehand, or lost an insert race, are upserted one
+    // at a time so the usual update rules apply
+    var remaining []string
+    for _, email := range emails {
+        if !inserted[email] {
+            remaining = append(remaining, email)
+        }
+    }
+    if len(remaining) > len(existing) {
+        if existing, err = memberStatusesIn(tx, remaining); err != nil {
+            return nil, err
+        }
+    }
+    for _, email := range remaining {
+        m := byEmail[email]
+        status := m.Status
+        if current, ok := existing[email]; ok && status != StatusActive {
+            status = current
+        }
+
+        if _, err := db.processMember(tx, m.Email, m.Name.String, m.IsAnonymous, status, change); err != nil {
+            return nil, fmt.Errorf("failed to update %s: %w", email, err)
+        }
+        if m.Frequency.Valid && m.Frequency.String != "" {
+            if err := db.setMemberFrequency(tx, email, m.Frequency.String); err != nil {
+                return nil, err
+            }
+        }
+        result.Updated++
+    }
+
+    if result.Donations, err = db.recordDonations(tx, donations); err != nil {
+        return nil, err
+    }
+
+    if dryRun {
+        return result, nil
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    return result, nil
+}
+
+// memberStatusesIn returns email -> status for the members among emails
+func memberStatusesIn(q querier, emails []string) (map[string]string, error) {
+    rows, err := q.Query(`SELECT email, status FROM members WHERE email = ANY($1)`, pq.Array(emails))
+    if err != nil {
+        return nil, fmt.Errorf("failed to load existing members: %w", err)
+    }
+    defer rows.Close()
+
+    statuses := make(map[string]string)
+    for rows.Next() {
+        var email, status string
+        if err := rows.Scan(&email, &status); err != nil {
+            return nil, err
+        }
+        statuses[email] = status
+    }
+
+    return statuses, rows.Err()
+}
+
+// readImportFile parses an import CSV into members, and the payments of rows
+// with an amount and date, collecting a per-row error for each row that
+// can't be imported
+func readImportFile(path string, comma rune, defaultStatus string) ([]Member, []Donation, []ImportError, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("failed to open CSV file: %w", err)
+    }
+    defer file.Close()
+
+    buffered := bufio.NewReaderSize(file, sniffBufferSize)
+    if prefix, err := buffered.Peek(len(utf8BOM)); err == nil && string(prefix) == string(utf8BOM) {
+        buffered.Discard(len(utf8BOM))
+    }
+    if comma == 0 {
+        comma = sniffDelimiter(buffered)
+    }
+
+    reader := csv.NewReader(buffered)
+    reader.Comma = comma
+    reader.LazyQuotes = true
+    reader.FieldsPerRecord = -1
+
+    headers, err := reader.Read()
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("failed to read CSV headers: %w", err)
+    }
+
+    emailIdx := findColumn(headers, "", emailColumnAliases)
+    if emailIdx == -1 {
+        return nil, nil, nil, fmt.Errorf("CSV missing required email column (headers: %v)", headers)
+    }
+    nameIdx := findColumn(headers, "", nameColumnAliases)
+    anonymousIdx := findColumn(headers, "", anonymousColumnAliases)
+    statusIdx := findColumn(headers, "", memberStatusAliases)
+    frequencyIdx := findColumn(headers, "", frequencyColumnAliases)
+    donationCols := csvColumns{
+        amount:     findColumn(headers, "", amountColumnAliases),
+        date:       findColumn(headers, "", dateColumnAliases),
+        currency:   findColumn(headers, "", currencyColumnAliases),
+        donationID: findColumn(headers, "", donationIDAliases),
+        campaign:   findColumn(headers, "", campaignColumnAliases),
+    }
+
+    cell := func(row []string, idx int) string {
+        if idx < 0 || idx >= len(row) {
+            return ""
+        }
+        return strings.TrimSpace(row[idx])
+    }
+
+    var members []Member
+    var donations []Donation
+    var rowErrors []ImportError
+    for {
+        row, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            var parseErr *csv.ParseError
+            if !errors.As(err, &parseErr) {
+                return nil, nil, nil, fmt.Errorf("failed to read CSV: %w", err)
+            }
+            rowErrors = append(rowErrors, ImportError{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+            continue
+        }
+        line, _ := reader.FieldPos(0)
+
+        email := cell(row, emailIdx)
+        if err := validateEmail(email); err != nil {
+            rowErrors = append(rowErrors, ImportError{Line: line, Email: email, Error: err.Error()})
+            continue
+        }
+
+        status := strings.ToLower(cell(row, statusIdx))
+        if status == "" {
+            status = defaultStatus
+        }
+        if !validStatus(status) {
+            rowErrors = append(rowErrors, ImportError{Line: line, Email: email, Error: fmt.Sprintf("unknown status %q", status)})
+            continue
+        }
+
+        anonymous := strings.ToLower(cell(row, anonymousIdx))
+        name := cell(row, nameIdx)
+        frequency := cell(row, frequencyIdx)
+        campaign := cell(row, donationCols.campaign)
+
+        if cell(row, donationCols.amount) != "" {
+            donation, ok := csvDonation(row, donationCols, email, frequency)
+            if !ok {
+                rowErrors = append(rowErrors, ImportError{Line: line, Email: email, Error: "invalid donation amount or date"})
+                continue
+            }
+            donation.Source = "import"
+            donations = append(donations, donation)
+        }
+
+        members = append(members, Member{
+            Email:       email,
+            Name:        sql.NullString{String: name, Valid: name != ""},
+            IsAnonymous: anonymous == "true" || anonymous == "yes" || anonymous == "1",
+            Status:      status,
+            Frequency:   sql.NullString{String: frequency, Valid: frequency != ""},
+            Campaign:    sql.NullString{String: campaign, Valid: campaign != ""},
+        })
+    }
+
+    return members, donations, rowErrors, nil
+}
+
+func runImport(logger *log.Logger) {
+    importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+    dryRun := importCmd.Bool("dry-run", false, "Show what would be imported without making changes")
+    reportFile := importCmd.String("report", "", "Report file (default import-<date>-<time>.json)")
+    delimiter := importCmd.String("delimiter", ",", `Field delimiter: ",", "\t", ";", or "auto" to detect from the header`)
+    defaultStatus := importCmd.String("status", StatusActive, "Status for rows without a status column value")
+
+    args := parseSubcommand(importCmd, "memberships import <csv-file> [--dry-run] [--report file] [--status active]", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: import command requires a CSV filename")
+        importCmd.Usage()
+        os.Exit(2)
+    }
+
+    comma, err := parseDelimiter(*delimiter)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(2)
+    }
+    *defaultStatus = strings.ToLower(strings.TrimSpace(*defaultStatus))
+    if !validStatus(*defaultStatus) {
+        fmt.Fprintf(os.Stderr, "Error: invalid status %q\n", *defaultStatus)
+        os.Exit(2)
+    }
+    if *reportFile == "" {
+        *reportFile = defaultImportReportPath()
+    }
+
+    members, donations, rowErrors, err := readImportFile(args[0], comma, *defaultStatus)
+    if err != nil {
+        logger.Fatalf("Import failed: %v", err)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if *dryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+    }
+
+    start := time.Now()
+    result, err := db.BulkInsertMembers(members, donations, ChangeSource{Source: "import", Detail: args[0]}, *dryRun)
+    if err != nil {
+        logger.Fatalf("Import failed: %v", err)
+    }
+    result.RunAt = start.UTC()
+    result.File = args[0]
+    result.Skipped += len(rowErrors)
+    result.Errors = rowErrors
+    if result.Errors == nil {
+        result.Errors = []ImportError{}
+    }
+
+    data, err := json.MarshalIndent(result, "", "  ")
+    if err != nil {
+        logger.Fatalf("Failed to encode report: %v", err)
+    }
+    if err := os.WriteFile(*reportFile, append(data, '\n'), 0644); err != nil {
+        logger.Printf("Failed to write report: %v", err)
+    }
+
+    verb := "Imported"
+    if *dryRun {
+        verb = "Would import"
+    }
+    fmt.Printf("%s %s in %v: %d inserted, %d updated, %d skipped, %d donations recorded\n", verb, args[0],
+        time.Since(start).Round(time.Millisecond), result.Inserted, result.Updated, result.Skipped, result.Donations)
+    if len(rowErrors) > 0 {
+        fmt.Printf("%d rows had errors; see %s\n", len(rowErrors), *reportFile)
+    } else {
+        fmt.Printf("Report written to %s\n", *reportFile)
+    }
+}
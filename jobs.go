@@ -0,0 +1,480 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "sync/atomic"
+    "time"
+)
+
+// Background job names
+const (
+    jobLapse        = "lapse"
+    jobMemberEmails = "member-emails"
+    jobWebhookRetry = "webhook-retry"
+    jobDiscord      = "discord"
+    jobSync         = "sync"
+    jobSnapshot     = "snapshot"
+    jobPrune        = "prune"
+    jobDigest       = "digest"
+)
+
+// What set a job run off
+const (
+    jobTriggerStartup     = "startup"
+    jobTriggerSchedule    = "schedule"
+    jobTriggerManual      = "manual"
+    jobTriggerCLI         = "cli"
+    jobTriggerMaintenance = "maintenance"
+)
+
+// jobLockNamespace is the first key of the advisory locks held while a job
+// runs, keeping them apart from any other advisory locks on the database
+const jobLockNamespace = 7401
+
+// defaultJobHistoryRetention keeps job runs for 30 days unless
+// JOB_HISTORY_RETENTION says otherwise; the retry worker alone records one
+// every 30 seconds
+const defaultJobHistoryRetention = 30 * 24 * time.Hour
+
+// ErrJobRunning is returned when a job is asked to run while it already is,
+// here or in another process
+var ErrJobRunning = errors.New("job is already running")
+
+// Job is a background task the server runs on a schedule, which an admin
+// can also run on demand
+type Job struct {
+    Name     string
+    Interval time.Duration
+
+    // Schedule describes when the job runs, if "every <Interval>" doesn't
+    Schedule string
+
+    // AtStart runs the job once when the server starts
+    AtStart bool
+
+    // wake, when set, runs the job early
+    wake <-chan struct{}
+
+    // run does the work and sums up what it did, "" for nothing
+    run func() (string, error)
+
+    running atomic.Bool
+}
+
+// describe returns when the job runs
+func (j *Job) describe() string {
+    if j.Schedule != "" {
+        return j.Schedule
+    }
+    return "every " + formatInterval(j.Interval)
+}
+
+// JobRun is one run of a job, as recorded in jobs_history
+type JobRun struct {
+    ID         int64     `json:"id,omitempty"`
+    Job        string    `json:"job"`
+    Trigger    string    `json:"trigger"`
+    StartedAt  time.Time `json:"started_at"`
+    FinishedAt time.Time `json:"finished_at"`
+    DurationMS int64     `json:"duration_ms"`
+    Result     string    `json:"result,omitempty"`
+    Error      string    `json:"error,omitempty"`
+}
+
+// JobStatus is a job as listed by GET /admin/jobs
+type JobStatus struct {
+    Name     string  `json:"name"`
+    Schedule string  `json:"schedule"`
+    Running  bool    `json:"running"`
+    LastRun  *JobRun `json:"last_run"`
+}
+
+// JobRegistry holds the server's background jobs. A job runs at most once at
+// a time: an in-process flag and a database advisory lock turn away a run
+// while one is in progress, whether in the server or the jobs CLI.
+type JobRegistry struct {
+    db     Store
+    logger *log.Logger
+    jobs   []*Job
+}
+
+// NewJobRegistry returns an empty registry
+func NewJobRegistry(db Store, logger *log.Logger) *JobRegistry {
+    return &JobRegistry{db: db, logger: logger}
+}
+
+// Register adds a job; a nil job, one that isn't configured, is skipped
+func (r *JobRegistry) Register(job *Job) {
+    if job != nil {
+        r.jobs = append(r.jobs, job)
+    }
+}
+
+// Get returns the named job, or nil if there is no such job
+func (r *JobRegistry) Get(name string) *Job {
+    if r == nil {
+        return nil
+    }
+    for _, job := range r.jobs {
+        if job.Name == name {
+            return job
+        }
+    }
+    return nil
+}
+
+// Status lists every job with its most recent run
+func (r *JobRegistry) Status() ([]JobStatus, error) {
+    last, err := r.db.LastJobRuns()
+    if err != nil {
+        return nil, err
+    }
+
+    statuses := make([]JobStatus, 0, len(r.jobs))
+    for _, job := range r.jobs {
+        status := JobStatus{Name: job.Name, Schedule: job.describe(), Running: job.running.Load()}
+        if !status.Running {
+            // Held elsewhere means another process is running it
+            release, locked, err := r.db.LockJob(job.Name)
+            if err != nil {
+                return nil, err
+            }
+            if locked {
+                release()
+            }
+            status.Running = !locked
+        }
+        if run, ok := last[job.Name]; ok {
+            status.LastRun = &run
+        }
+        statuses = append(statuses, status)
+    }
+    return statuses, nil
+}
+
+// Run runs a job now and waits for it, or returns ErrJobRunning
+func (r *JobRegistry) Run(job *Job, trigger string) (*JobRun, error) {
+    release, err := r.claim(job)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+    return r.execute(job, trigger), nil
+}
+
+// Start runs a job in the background, returning once it has been claimed,
+// or ErrJobRunning
+func (r *JobRegistry) Start(job *Job, trigger string) error {
+    release, err := r.claim(job)
+    if err != nil {
+        return err
+    }
+    go func() {
+        defer release()
+        r.execute(job, trigger)
+    }()
+    return nil
+}
+
+// claim marks a job running, here and in the database
+func (r *JobRegistry) claim(job *Job) (func(), error) {
+    if !job.running.CompareAndSwap(false, true) {
+        return nil, ErrJobRunning
+    }
+    unlock, locked, err := r.db.LockJob(job.Name)
+    if err != nil || !locked {
+        job.running.Store(false)
+        if err == nil {
+            err = ErrJobRunning
+        }
+        return nil, err
+    }
+    return func() {
+        unlock()
+        job.running.Store(false)
+    }, nil
+}
+
+// execute runs a claimed job and records the run. A panic is recorded as
+// the run's error so a job never takes down the server.
+func (r *JobRegistry) execute(job *Job, trigger string) *JobRun {
+    run := &JobRun{Job: job.Name, Trigger: trigger, StartedAt: time.Now().UTC()}
+
+    func() {
+        defer func() {
+            if p := recover(); p != nil {
+                run.Error = fmt.Sprintf("panic: %v", p)
+            }
+        }()
+        result, err := job.run()
+        run.Result = result
+        if err != nil {
+            run.Error = err.Error()
+        }
+    }()
+
+    run.FinishedAt = time.Now().UTC()
+    run.DurationMS = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+    if run.Error != "" {
+        r.logger.Printf("Job %s failed: %s", job.Name, run.Error)
+    }
+    if err := r.db.RecordJobRun(run); err != nil {
+        r.logger.Printf("Failed to record %s job run: %v", job.Name, err)
+    }
+    return run
+}
+
+// loop runs a job on its schedule until the process exits
+func (r *JobRegistry) loop(job *Job) {
+    if job.AtStart {
+        r.runScheduled(job, jobTriggerStartup)
+    }
+
+    ticker := time.NewTicker(job.Interval)
+    defer ticker.Stop()
+
+    for {
+        // A nil wake channel never fires
+        select {
+        case <-ticker.C:
+        case <-job.wake:
+        }
+        r.runScheduled(job, jobTriggerSchedule)
+    }
+}
+
+func (r *JobRegistry) runScheduled(job *Job, trigger string) {
+    _, err := r.Run(job, trigger)
+    if errors.Is(err, ErrJobRunning) {
+        r.logger.Printf("Job %s skipped: previous run still in progress", job.Name)
+    } else if err != nil {
+        r.logger.Printf("Job %s could not start: %v", job.Name, err)
+    }
+}
+
+// formatInterval prints whole days, hours, and minutes without the trailing
+// zero units time.Duration adds
+func formatInterval(d time.Duration) string {
+    switch {
+    case d%(24*time.Hour) == 0:
+        return formatAge(d)
+    case d%time.Hour == 0:
+        return fmt.Sprintf("%dh", d/time.Hour)
+    case d%time.Minute == 0:
+        return fmt.Sprintf("%dm", d/time.Minute)
+    }
+    return d.String()
+}
+
+// LockJob takes the advisory lock marking a job as running, on a connection
+// of its own so the lock is released if this process dies mid-run. It
+// reports false, with nothing to release, if another session holds it.
+func (db *Database) LockJob(name string) (func(), bool, error) {
+    ctx := context.Background()
+    conn, err := db.Conn(ctx)
+    if err != nil {
+        return nil, false, fmt.Errorf("failed to lock job: %w", err)
+    }
+
+    var locked bool
+    err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, jobLockNamespace, name).Scan(&locked)
+    if err != nil || !locked {
+        conn.Close()
+        if err != nil {
+            return nil, false, fmt.Errorf("failed to lock job: %w", err)
+        }
+        return nil, false, nil
+    }
+
+    return func() {
+        if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`, jobLockNamespace, name); err != nil {
+            db.logger.Printf("Failed to unlock job %s: %v", name, err)
+        }
+        conn.Close()
+    }, true, nil
+}
+
+// RecordJobRun adds a finished run to jobs_history
+func (db *Database) RecordJobRun(run *JobRun) error {
+    err := db.QueryRow(`
+        INSERT INTO jobs_history (job, triggered_by, started_at, finished_at, duration_ms, result, error)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+        RETURNING id
+    `, run.Job, run.Trigger, run.StartedAt, run.FinishedAt, run.DurationMS, run.Result, run.Error).Scan(&run.ID)
+    if err != nil {
+        return fmt.Errorf("failed to record job run: %w", err)
+    }
+    return nil
+}
+
+// LastJobRuns returns each job's most recent run by name
+func (db *Database) LastJobRuns() (map[string]JobRun, error) {
+    rows, err := db.Query(`
+        SELECT DISTINCT ON (job)
+               id, job, triggered_by, started_at, finished_at, duration_ms, COALESCE(result, ''), COALESCE(error, '')
+        FROM jobs_history
+        ORDER BY job, started_at DESC, id DESC
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get job runs: %w", err)
+    }
+    defer rows.Close()
+
+    last := make(map[string]JobRun)
+    for rows.Next() {
+        var run JobRun
+        err := rows.Scan(&run.ID, &run.Job, &run.Trigger, &run.StartedAt, &run.FinishedAt, &run.DurationMS, &run.Result, &run.Error)
+        if err != nil {
+            return nil, err
+        }
+        last[run.Job] = run
+    }
+
+    return last, rows.Err()
+}
+
+// registerJobs sets up the server's background jobs, leaving out any that
+// aren't configured
+func (s *WebhookServer) registerJobs() {
+    s.jobs.Register(s.lapseJob())
+    s.jobs.Register(s.memberEmailJob())
+    s.jobs.Register(s.retryJob())
+    s.jobs.Register(s.discordJob())
+    s.jobs.Register(s.syncJob())
+    s.jobs.Register(s.snapshotJob())
+    s.jobs.Register(s.pruneJob())
+    s.jobs.Register(s.digestJob())
+}
+
+// startJobs runs every registered job on its schedule
+func (s *WebhookServer) startJobs() {
+    for _, job := range s.jobs.jobs {
+        s.logger.Printf("Job %s running %s", job.Name, job.describe())
+        go s.jobs.loop(job)
+    }
+}
+
+// listJobsHandler lists the background jobs with their last runs
+func (s *WebhookServer) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+    statuses, err := s.jobs.Status()
+    if err != nil {
+        s.logger.Printf("Error listing jobs: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(statuses)
+}
+
+// runJobHandler starts a job now. A job that is already running is turned
+// away with 409 rather than queued behind the current run.
+func (s *WebhookServer) runJobHandler(w http.ResponseWriter, r *http.Request) {
+    job := s.jobs.Get(r.PathValue("name"))
+    if job == nil {
+        writeError(w, r, http.StatusNotFound, errNotFound, "No such job, or it isn't configured")
+        return
+    }
+
+    err := s.jobs.Start(job, jobTriggerManual)
+    if errors.Is(err, ErrJobRunning) {
+        writeError(w, r, http.StatusConflict, errConflict, fmt.Sprintf("Job %s is already running", job.Name))
+        return
+    } else if err != nil {
+        s.logger.Printf("Error starting job %s: %v", job.Name, err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    s.logger.Printf("Job %s started by admin from %s", job.Name, s.clientIP(r))
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(JobStatus{Name: job.Name, Schedule: job.describe(), Running: true})
+}
+
+func runJobs(logger *log.Logger) {
+    usage := "memberships jobs <list|run> [name]"
+    if len(os.Args) < 3 {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+        os.Exit(2)
+    }
+
+    action := os.Args[2]
+    jobsCmd := flag.NewFlagSet("jobs "+action, flag.ExitOnError)
+    args := parseSubcommand(jobsCmd, usage, os.Args[3:])
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    // The same jobs the server would run with this configuration
+    server := NewWebhookServer(db, mustLoadConfig(logger), logger)
+    server.registerJobs()
+
+    switch action {
+    case "list":
+        statuses, err := server.jobs.Status()
+        if err != nil {
+            logger.Fatalf("List failed: %v", err)
+        }
+        if len(statuses) == 0 {
+            fmt.Println("No jobs configured")
+            return
+        }
+        for _, status := range statuses {
+            state := "idle"
+            if status.Running {
+                state = "running"
+            }
+            fmt.Printf("%-14s %-8s %s\n", status.Name, state, status.Schedule)
+            if run := status.LastRun; run != nil {
+                outcome := run.Result
+                if run.Error != "" {
+                    outcome = "FAILED: " + run.Error
+                } else if outcome == "" {
+                    outcome = "nothing to do"
+                }
+                fmt.Printf("%14s last run %s (%s, %dms): %s\n", "", displayTime(run.StartedAt), run.Trigger, run.DurationMS, outcome)
+            } else {
+                fmt.Printf("%14s never run\n", "")
+            }
+        }
+
+    case "run":
+        if len(args) != 1 {
+            fmt.Fprintln(os.Stderr, "Error: jobs run requires a job name (see memberships jobs list)")
+            os.Exit(2)
+        }
+        job := server.jobs.Get(args[0])
+        if job == nil {
+            fmt.Fprintf(os.Stderr, "Error: no job %q, or it isn't configured\n", args[0])
+            os.Exit(1)
+        }
+
+        run, err := server.jobs.Run(job, jobTriggerCLI)
+        if errors.Is(err, ErrJobRunning) {
+            fmt.Fprintf(os.Stderr, "Error: job %s is already running\n", job.Name)
+            os.Exit(1)
+        } else if err != nil {
+            logger.Fatalf("Run failed: %v", err)
+        }
+        if run.Error != "" {
+            fmt.Fprintf(os.Stderr, "Job %s failed after %dms: %s\n", job.Name, run.DurationMS, run.Error)
+            os.Exit(1)
+        }
+        if run.Result == "" {
+            run.Result = "nothing to do"
+        }
+        fmt.Printf("Job %s finished in %dms: %s\n", job.Name, run.DurationMS, run.Result)
+
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown jobs action %q\nUsage: %s\n", action, usage)
+        os.Exit(2)
+    }
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+)
+
+// defaultLapseGraceDays is how long past the expected renewal we wait
+const defaultLapseGraceDays = 14
+
+// frequencyMonths returns the renewal period in months for a donation
+// frequency, in English, Spanish, or French (see frequencySynonyms)
+func frequencyMonths(frequency string) (int, bool) {
+    f := foldText(frequency)
+
+    for _, synonyms := range frequencySynonyms {
+        for _, word := range synonyms.words {
+            if strings.Contains(f, word) {
+                return synonyms.months, true
+            }
+        }
+    }
+
+    return 0, false
+}
+
+// LapseCandidate is an active member whose renewal window has passed
+type LapseCandidate struct {
+    Email       string
+    Frequency   string
+    LastPayment time.Time
+    DueAt       time.Time
+}
+
+// FindLapseCandidates returns active members with a known frequency whose
+// last payment plus one period plus the grace period is before now
+func (db *Database) FindLapseCandidates(graceDays int, now time.Time) ([]LapseCandidate, error) {
+    rows, err := db.Query(`
+        SELECT email, frequency, COALESCE(last_payment_at, first_seen)
+        FROM members
+        WHERE status = 'active' AND frequency IS NOT NULL AND frequency <> ''
+        AND NOT ($1 = ANY(tags))
+        AND (household_id IS NULL OR household_id = id)
+    `, ProtectedTag)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var candidates []LapseCandidate
+    for rows.Next() {
+        var c LapseCandidate
+        if err := rows.Scan(&c.Email, &c.Frequency, &c.LastPayment); err != nil {
+            return nil, err
+        }
+
+        months, ok := frequencyMonths(c.Frequency)
+        if !ok {
+            continue
+        }
+
+        c.DueAt = c.LastPayment.AddDate(0, months, graceDays)
+        if c.DueAt.Before(now) {
+            candidates = append(candidates, c)
+        }
+    }
+
+    return candidates, rows.Err()
+}
+
+// LapseMembers transitions overdue members to lapsed and returns who changed.
+// A later successful payment webhook sets them back to active.
+func (db *Database) LapseMembers(graceDays int, dryRun bool) ([]LapseCandidate, error) {
+    candidates, err := db.FindLapseCandidates(graceDays, time.Now())
+    if err != nil {
+        return nil, fmt.Errorf("failed to find lapse candidates: %w", err)
+    }
+
+    if dryRun {
+        return candidates, nil
+    }
+
+    var lapsed []LapseCandidate
+    for _, c := range candidates {
+        reason := fmt.Sprintf("no %s payment since %s (grace %d days)",
+            strings.ToLower(c.Frequency), displayDate(c.LastPayment), graceDays)
+
+        if err := db.UpdateMemberStatus(c.Email, "lapsed", ChangeSource{Source: "lapse", Detail: reason}); err != nil {
+            db.logger.Printf("Error lapsing member %s: %v", c.Email, err)
+            continue
+        }
+        lapsed = append(lapsed, c)
+    }
+
+    return lapsed, nil
+}
+
+// lapseJob periodically lapses overdue members in server mode
+func (s *WebhookServer) lapseJob() *Job {
+    if s.config.LapseInterval <= 0 {
+        return nil
+    }
+
+    return &Job{
+        Name:     jobLapse,
+        Interval: s.config.LapseInterval,
+        Schedule: fmt.Sprintf("every %s (grace %d days)", formatInterval(s.config.LapseInterval), s.config.LapseGraceDays),
+        run: func() (string, error) {
+            if s.maintenance.Load() {
+                return "skipped for maintenance", nil
+            }
+            lapsed, err := s.db.LapseMembers(s.config.LapseGraceDays, false)
+            if err != nil {
+                return "", err
+            }
+            if len(lapsed) == 0 {
+                return "", nil
+            }
+            s.logger.Printf("Lapse job marked %d members lapsed", len(lapsed))
+            return fmt.Sprintf("%d members lapsed", len(lapsed)), nil
+        },
+    }
+}
+
+func runLapse(logger *log.Logger) {
+    lapseCmd := flag.NewFlagSet("lapse", flag.ExitOnError)
+    dryRun := lapseCmd.Bool("dry-run", false, "Show who would lapse without making changes")
+    graceDays := lapseCmd.Int("grace-days", -1, "Days past the expected renewal before lapsing (default LAPSE_GRACE_DAYS or 14)")
+
+    parseSubcommand(lapseCmd, "memberships lapse [--dry-run] [--grace-days N]", os.Args[2:])
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if *graceDays < 0 {
+        *graceDays = mustLoadConfig(logger).LapseGraceDays
+    }
+
+    if *dryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+    }
+
+    lapsed, err := db.LapseMembers(*graceDays, *dryRun)
+    if err != nil {
+        logger.Fatalf("Lapse failed: %v", err)
+    }
+
+    for _, c := range lapsed {
+        fmt.Printf("  %s (%s, last paid %s, due %s)\n", c.Email, c.Frequency,
+            displayDate(c.LastPayment), displayDate(c.DueAt))
+    }
+
+    if *dryRun {
+        fmt.Printf("Would lapse %d members\n", len(lapsed))
+        fmt.Println("DRY RUN complete - no changes made")
+    } else {
+        fmt.Printf("Lapsed %d members\n", len(lapsed))
+    }
+}
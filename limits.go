@@ -0,0 +1,141 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// Defaults for REQUEST_TIMEOUT and MAX_CONCURRENT_REQUESTS
+const (
+    defaultRequestTimeout        = 30 * time.Second
+    defaultMaxConcurrentRequests = 32
+)
+
+// busyRetryAfter is the Retry-After, in seconds, sent with a 503 when a route
+// group is saturated
+const busyRetryAfter = 5
+
+// Route groups, each with its own concurrency limit so a flood of one kind
+// of request can't starve the others
+const (
+    groupPublic  = "public"
+    groupWebhook = "webhook"
+    groupAdmin   = "admin"
+)
+
+var routeGroups = []string{groupPublic, groupWebhook, groupAdmin}
+
+// concurrencyLimit caps in-flight requests; it never queues, a request
+// either gets a slot or is turned away
+type concurrencyLimit struct {
+    slots chan struct{}
+}
+
+func newConcurrencyLimit(n int) *concurrencyLimit {
+    return &concurrencyLimit{slots: make(chan struct{}, n)}
+}
+
+// acquire takes a slot if one is free
+func (l *concurrencyLimit) acquire() bool {
+    select {
+    case l.slots <- struct{}{}:
+        return true
+    default:
+        return false
+    }
+}
+
+func (l *concurrencyLimit) release() {
+    <-l.slots
+}
+
+// inFlight returns how many requests hold a slot
+func (l *concurrencyLimit) inFlight() int {
+    return len(l.slots)
+}
+
+// limitMiddleware rejects requests with 503 while the route group is at its
+// concurrency limit
+func (s *WebhookServer) limitMiddleware(group string, next http.HandlerFunc) http.HandlerFunc {
+    limit := s.limits[group]
+    return func(w http.ResponseWriter, r *http.Request) {
+        if !limit.acquire() {
+            s.logger.Printf("Rejecting %s %s: %s requests at limit (%d)", r.Method, r.URL.Path, group, cap(limit.slots))
+            w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+            writeError(w, r, http.StatusServiceUnavailable, errUnavailable, "Server busy, try again shortly")
+            return
+        }
+        defer limit.release()
+
+        next(w, r)
+    }
+}
+
+// timeoutMiddleware gives the request context a REQUEST_TIMEOUT deadline,
+// and answers 503 if the handler gives up because of it without answering
+// itself. Handlers write straight through, so streamed exports and flushes
+// reach the client as they happen. The handler keeps its concurrency slot
+// until it actually returns, so stuck requests still count toward the limit.
+func (s *WebhookServer) timeoutMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    if s.config.RequestTimeout <= 0 {
+        return next
+    }
+    return func(w http.ResponseWriter, r *http.Request) {
+        ctx, cancel := context.WithTimeout(r.Context(), s.config.RequestTimeout)
+        defer cancel()
+        
+        tw := &timeoutWriter{ResponseWriter: w}
+        next(tw, r.WithContext(ctx))
+        if !tw.wrote && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+            writeError(w, r, http.StatusServiceUnavailable, errUnavailable, "Request timed out")
+        }
+    }
+}
+
+// timeoutWriter notes whether the handler has started its response
+type timeoutWriter struct {
+    http.ResponseWriter
+    wrote bool
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+    w.wrote = true
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+    w.wrote = true
+    return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far, through any writers below
+func (w *timeoutWriter) Flush() {
+    w.wrote = true
+    http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// guard applies the timeout and the route group's concurrency limit, and
+// for everything but webhooks, which are deferred instead, maintenance mode
+func (s *WebhookServer) guard(group string, next http.HandlerFunc) http.HandlerFunc {
+    if group != groupWebhook {
+        next = s.maintenanceMiddleware(next)
+    }
+    return s.timeoutMiddleware(s.limitMiddleware(group, next))
+}
+
+// inFlight reports the in-flight request count of each route group
+func (s *WebhookServer) inFlight() map[string]int {
+    counts := make(map[string]int, len(s.limits))
+    for group, limit := range s.limits {
+        counts[group] = limit.inFlight()
+    }
+    return counts
+}
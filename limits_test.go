@@ -0,0 +1,152 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+// guardedServer serves handler behind guard with the given request timeout
+func guardedServer(t *testing.T, timeout time.Duration, handler http.HandlerFunc) *httptest.Server {
+    t.Helper()
+    config := testConfig()
+    config.RequestTimeout = timeout
+    s := NewWebhookServer(newMemStore(), config, log.New(io.Discard, "", 0))
+    t.Cleanup(s.accessLog.close)
+
+    server := httptest.NewServer(s.guard(groupPublic, handler))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func TestTimeoutAnswersWhenHandlerGivesUp(t *testing.T) {
+    server := guardedServer(t, 20*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+        <-r.Context().Done()
+    })
+
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusServiceUnavailable {
+        t.Fatalf("got %d, want 503", resp.StatusCode)
+    }
+    var body map[string]apiError
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        t.Fatalf("503 body isn't JSON: %v", err)
+    }
+    if body["error"].Code != errUnavailable {
+        t.Errorf("error code = %q, want %q", body["error"].Code, errUnavailable)
+    }
+}
+
+func TestTimeoutKeepsHandlerResponse(t *testing.T) {
+    server := guardedServer(t, 20*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+        <-r.Context().Done()
+        w.WriteHeader(http.StatusTeapot)
+    })
+
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusTeapot {
+        t.Errorf("got %d, want the handler's own 418", resp.StatusCode)
+    }
+}
+
+func TestTimeoutStreamsResponses(t *testing.T) {
+    release := make(chan struct{})
+    server := guardedServer(t, time.Minute, func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/csv")
+        io.WriteString(w, "email\n")
+        w.(http.Flusher).Flush()
+
+        // The rest of the export only comes once the client has the header
+        <-release
+        io.WriteString(w, "ada@example.org\n")
+    })
+
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+
+    reader := bufio.NewReader(resp.Body)
+    line, err := reader.ReadString('\n')
+    if err != nil || line != "email\n" {
+        t.Fatalf("first line = %q, %v", line, err)
+    }
+
+    close(release)
+    line, err = reader.ReadString('\n')
+    if err != nil || line != "ada@example.org\n" {
+        t.Fatalf("second line = %q, %v", line, err)
+    }
+}
+
+func TestTimeoutDisabled(t *testing.T) {
+    server := guardedServer(t, 0, func(w http.ResponseWriter, r *http.Request) {
+        if _, ok := r.Context().Deadline(); ok {
+            t.Error("REQUEST_TIMEOUT=0 still set a deadline")
+        }
+    })
+
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf("got %d, want 200", resp.StatusCode)
+    }
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+    config := testConfig()
+    config.MaxConcurrentRequests = 1
+    s := NewWebhookServer(newMemStore(), config, log.New(io.Discard, "", 0))
+    t.Cleanup(s.accessLog.close)
+
+    started, release := make(chan struct{}), make(chan struct{})
+    server := httptest.NewServer(s.guard(groupPublic, func(w http.ResponseWriter, r *http.Request) {
+        close(started)
+        <-release
+    }))
+    t.Cleanup(server.Close)
+
+    done := make(chan int)
+    go func() {
+        resp, err := http.Get(server.URL)
+        if err != nil {
+            done <- 0
+            return
+        }
+        resp.Body.Close()
+        done <- resp.StatusCode
+    }()
+    <-started
+
+    resp, err := http.Get(server.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+        t.Errorf("second request got %d (Retry-After %q), want 503 with Retry-After", resp.StatusCode, resp.Header.Get("Retry-After"))
+    }
+
+    close(release)
+    if status := <-done; status != http.StatusOK {
+        t.Errorf("first request got %d, want 200", status)
+    }
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+)
+
+// defaultSocketMode lets the socket's group (e.g. nginx) connect
+const defaultSocketMode os.FileMode = 0660
+
+// shutdownTimeout bounds how long in-flight requests get on shutdown
+const shutdownTimeout = 15 * time.Second
+
+// listen opens the configured TCP port or Unix socket
+func (s *WebhookServer) listen() (net.Listener, error) {
+    if s.config.ListenSocket == "" {
+        return net.Listen("tcp", "127.0.0.1:"+s.config.Port)
+    }
+
+    path := s.config.ListenSocket
+    if err := removeStaleSocket(path, s.logger); err != nil {
+        return nil, err
+    }
+
+    listener, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chmod(path, s.config.ListenSocketMode); err != nil {
+        listener.Close()
+        return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+    }
+
+    return listener, nil
+}
+
+// removeStaleSocket deletes a socket left behind by a server that didn't
+// shut down cleanly, refusing if something is still listening on it
+func removeStaleSocket(path string, logger *log.Logger) error {
+    info, err := os.Lstat(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    } else if err != nil {
+        return err
+    }
+
+    if info.Mode()&os.ModeSocket == 0 {
+        return fmt.Errorf("%s exists and is not a socket", path)
+    }
+
+    if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+        conn.Close()
+        return fmt.Errorf("another server is already listening on %s", path)
+    }
+
+    logger.Printf("Removing stale socket %s", path)
+    return os.Remove(path)
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then drains in-flight
+// requests and ends open event streams. The Unix socket, if any, is removed
+// when the listener closes.
+func (s *WebhookServer) serve(listener net.Listener) error {
+    server := &http.Server{Handler: requestIDMiddleware(s.recoverMiddleware(routeMiddleware(s.mux)))}
+    if hub := s.db.EventHub(); hub != nil {
+        server.RegisterOnShutdown(hub.Close)
+    }
+
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+    defer signal.Stop(stop)
+
+    errc := make(chan error, 1)
+    go func() {
+        errc <- server.Serve(listener)
+    }()
+
+    select {
+    case err := <-errc:
+        return err
+    case sig := <-stop:
+        s.logger.Printf("Received %v, shutting down", sig)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    if err := server.Shutdown(ctx); err != nil {
+        return fmt.Errorf("shutdown: %w", err)
+    }
+    s.accessLog.close()
+
+    return nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "math/rand/v2"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "os/signal"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// loadTestDomain is the domain of every generated email, so load test
+// members are easy to find and delete afterwards
+const loadTestDomain = "loadtest.example.com"
+
+// LoadTestOptions controls a webhook load test
+type LoadTestOptions struct {
+    URL      string
+    Secret   string
+    Rate     int
+    Duration time.Duration
+    Workers  int
+
+    // Seed drives the payload mix, so runs are comparable
+    Seed uint64
+
+    // Handler, if set, receives the requests in process instead of over
+    // the network; URL then only supplies the path
+    Handler http.Handler
+}
+
+// LoadTestResult is what a load test saw
+type LoadTestResult struct {
+    Target  int
+    Elapsed time.Duration
+
+    Sent      int
+    Completed int
+
+    // Missed counts requests never sent because every worker was busy
+    Missed int
+
+    ByStatus        map[int]int
+    TransportErrors map[string]int
+
+    // Latencies of completed requests, sorted
+    Latencies []time.Duration
+}
+
+// Percentile returns the latency p percent of requests finished within
+func (r *LoadTestResult) Percentile(p float64) time.Duration {
+    if len(r.Latencies) == 0 {
+        return 0
+    }
+    i := int(float64(len(r.Latencies))*p/100+0.5) - 1
+    return r.Latencies[min(max(i, 0), len(r.Latencies)-1)]
+}
+
+// Throughput is completed requests per second
+func (r *LoadTestResult) Throughput() float64 {
+    if r.Elapsed <= 0 {
+        return 0
+    }
+    return float64(r.Completed) / r.Elapsed.Seconds()
+}
+
+// Report is the result as the loadtest command prints it
+func (r *LoadTestResult) Report() string {
+    var b strings.Builder
+
+    fmt.Fprintf(&b, "Sent %d webhooks in %.1fs: %.1f/s completed (target %d/s)\n",
+        r.Sent, r.Elapsed.Seconds(), r.Throughput(), r.Target)
+    if r.Missed > 0 {
+        fmt.Fprintf(&b, "  %d not sent because every worker was busy; add --workers or lower --rate\n", r.Missed)
+    }
+
+    b.WriteString("\nResponses\n")
+    codes := make([]int, 0, len(r.ByStatus))
+    for code := range r.ByStatus {
+        codes = append(codes, code)
+    }
+    sort.Ints(codes)
+    for _, code := range codes {
+        fmt.Fprintf(&b, "  %d %-22s %d\n", code, http.StatusText(code), r.ByStatus[code])
+    }
+    errs := make([]string, 0, len(r.TransportErrors))
+    for err := range r.TransportErrors {
+        errs = append(errs, err)
+    }
+    sort.Strings(errs)
+    for _, err := range errs {
+        fmt.Fprintf(&b, "  error: %s: %d\n", err, r.TransportErrors[err])
+    }
+
+    b.WriteString("\nLatency\n")
+    for _, p := range []float64{50, 90, 95, 99} {
+        fmt.Fprintf(&b, "  p%-3v %v\n", p, r.Percentile(p).Round(100*time.Microsecond))
+    }
+    if len(r.Latencies) > 0 {
+        fmt.Fprintf(&b, "  max  %v\n", r.Latencies[len(r.Latencies)-1].Round(100*time.Microsecond))
+    }
+
+    return b.String()
+}
+
+// handlerTransport serves client requests straight from a handler, for
+// load testing without a network
+type handlerTransport struct {
+    handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    in := req.Clone(req.Context())
+    in.RemoteAddr = "127.0.0.1:0"
+    in.RequestURI = req.URL.RequestURI()
+
+    rec := httptest.NewRecorder()
+    t.handler.ServeHTTP(rec, in)
+    return rec.Result(), nil
+}
+
+// loadGenerator makes randomized Zapier-style payloads: mostly successful
+// payments from a mix of new and returning donors, some failures and
+// cancellations, and the occasional malformed body
+type loadGenerator struct {
+    rng    *rand.Rand
+    run    string
+    emails []string
+    sent   int
+}
+
+func newLoadGenerator(seed uint64) *loadGenerator {
+    return &loadGenerator{
+        rng: rand.New(rand.NewPCG(seed, seed^0x10ad)),
+        run: strconv.FormatInt(time.Now().Unix(), 36),
+    }
+}
+
+func (g *loadGenerator) next() []byte {
+    g.sent++
+
+    // Bodies the handler has to turn away
+    if g.rng.IntN(100) < 2 {
+        switch g.rng.IntN(4) {
+        case 0:
+            return []byte(`{"email": "truncated@` + loadTestDomain + `", "status": `)
+        case 1:
+            return []byte(`not json at all`)
+        case 2:
+            return []byte(`{"status": "Succeeded", "name": "No Email"}`)
+        default:
+            return []byte(`{"email": "Jane Doe", "status": "Succeeded"}`)
+        }
+    }
+
+    var email string
+    if len(g.emails) > 0 && g.rng.IntN(100) < 40 {
+        email = g.emails[g.rng.IntN(len(g.emails))]
+    } else {
+        email = fmt.Sprintf("load-%s-%d@%s", g.run, len(g.emails)+1, loadTestDomain)
+        g.emails = append(g.emails, email)
+    }
+
+    webhook := MemberWebhook{
+        Email:     email,
+        Name:      "Load Test",
+        Anonymous: "False",
+        Frequency: "Monthly",
+        EventTime: time.Now().UTC().Format(time.RFC3339Nano),
+    }
+    switch n := g.rng.IntN(100); {
+    case n < 75:
+        webhook.Status = "Succeeded"
+        webhook.Amount = looseString(strconv.Itoa(5 * (1 + g.rng.IntN(20))))
+        webhook.DonationID = fmt.Sprintf("load-%s-%d", g.run, g.sent)
+    case n < 87:
+        webhook.Status = "Failed"
+    case n < 95:
+        webhook.Status = "Cancelled"
+    default:
+        webhook.Status = "Pending"
+    }
+    if g.rng.IntN(100) < 10 {
+        webhook.Anonymous = "True"
+        webhook.Name = ""
+    }
+
+    body, _ := json.Marshal(webhook)
+    return body
+}
+
+// loadSample is one finished request
+type loadSample struct {
+    status  int
+    err     string
+    latency time.Duration
+}
+
+// RunLoadTest posts generated webhooks at opts.Rate per second for
+// opts.Duration, or until ctx is done, using a pool of opts.Workers
+func RunLoadTest(ctx context.Context, opts LoadTestOptions) (*LoadTestResult, error) {
+    if opts.Rate <= 0 || opts.Duration <= 0 || opts.Workers <= 0 {
+        return nil, fmt.Errorf("rate, duration, and workers must be positive")
+    }
+
+    client := &http.Client{
+        Timeout:   30 * time.Second,
+        Transport: &http.Transport{MaxIdleConnsPerHost: opts.Workers},
+    }
+    if opts.Handler != nil {
+        client.Transport = handlerTransport{opts.Handler}
+    }
+
+    jobs := make(chan []byte, opts.Workers)
+    samples := make(chan loadSample, opts.Workers)
+
+    var workers sync.WaitGroup
+    for range opts.Workers {
+        workers.Add(1)
+        go func() {
+            defer workers.Done()
+            for body := range jobs {
+                samples <- postLoadWebhook(client, opts, body)
+            }
+        }()
+    }
+
+    result := &LoadTestResult{
+        Target:          opts.Rate,
+        ByStatus:        map[int]int{},
+        TransportErrors: map[string]int{},
+    }
+    collected := make(chan struct{})
+    go func() {
+        defer close(collected)
+        for sample := range samples {
+            if sample.err != "" {
+                result.TransportErrors[sample.err]++
+                continue
+            }
+            result.Completed++
+            result.ByStatus[sample.status]++
+            result.Latencies = append(result.Latencies, sample.latency)
+        }
+    }()
+
+    generator := newLoadGenerator(opts.Seed)
+    ticker := time.NewTicker(time.Second / time.Duration(opts.Rate))
+    deadline := time.NewTimer(opts.Duration)
+    start := time.Now()
+
+send:
+    for {
+        select {
+        case <-ctx.Done():
+            break send
+        case <-deadline.C:
+            break send
+        case <-ticker.C:
+            // Dropping rather than queueing keeps a slow server from
+            // quietly lowering the rate under test
+            select {
+            case jobs <- generator.next():
+                result.Sent++
+            default:
+                result.Missed++
+            }
+        }
+    }
+    ticker.Stop()
+    deadline.Stop()
+
+    close(jobs)
+    workers.Wait()
+    result.Elapsed = time.Since(start)
+    close(samples)
+    <-collected
+
+    sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+    return result, nil
+}
+
+// postLoadWebhook sends one webhook and times it
+func postLoadWebhook(client *http.Client, opts LoadTestOptions, body []byte) loadSample {
+    req, err := http.NewRequest(http.MethodPost, opts.URL, bytes.NewReader(body))
+    if err != nil {
+        return loadSample{err: err.Error()}
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if opts.Secret != "" {
+        req.Header.Set("Authorization", "Bearer "+opts.Secret)
+    }
+
+    start := time.Now()
+    resp, err := client.Do(req)
+    if err != nil {
+        // Group by cause, not by the per-request details
+        msg := err.Error()
+        if i := strings.LastIndex(msg, ": "); i >= 0 {
+            msg = msg[i+2:]
+        }
+        return loadSample{err: msg}
+    }
+    io.Copy(io.Discard, resp.Body)
+    resp.Body.Close()
+
+    return loadSample{status: resp.StatusCode, latency: time.Since(start)}
+}
+
+func runLoadTest(logger *log.Logger) {
+    loadCmd := flag.NewFlagSet("loadtest", flag.ExitOnError)
+    url := loadCmd.String("url", "http://localhost:3000/webhook", "Webhook endpoint to load")
+    rate := loadCmd.Int("rate", 50, "Webhooks per second")
+    duration := loadCmd.Duration("duration", 60*time.Second, "How long to send")
+    workers := loadCmd.Int("workers", 20, "Concurrent requests in flight")
+    secret := loadCmd.String("secret", os.Getenv("WEBHOOK_SECRET"), "Webhook secret (default: $WEBHOOK_SECRET)")
+    seed := loadCmd.Uint64("seed", 1, "Random seed for the payload mix")
+    inProcess := loadCmd.Bool("in-process", false, "Serve requests from this process's handler against DATABASE_URL, with no network")
+
+    parseSubcommand(loadCmd, "memberships loadtest [--url http://localhost:3000/webhook] [--rate 50] [--duration 60s] [--workers 20] [--secret S] [--seed 1] [--in-process]", os.Args[2:])
+    if *rate <= 0 || *duration <= 0 || *workers <= 0 {
+        fmt.Fprintln(os.Stderr, "Error: --rate, --duration, and --workers must be positive")
+        os.Exit(2)
+    }
+
+    opts := LoadTestOptions{
+        URL:      *url,
+        Secret:   *secret,
+        Rate:     *rate,
+        Duration: *duration,
+        Workers:  *workers,
+        Seed:     *seed,
+    }
+
+    if *inProcess {
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        config := mustLoadConfig(logger)
+        if opts.Secret == "" && len(config.WebhookSources["default"]) > 0 {
+            opts.Secret = config.WebhookSources["default"][0]
+        }
+
+        // The server logs every request; keep its output out of the report
+        db.logger = log.New(io.Discard, "", 0)
+        server := NewWebhookServer(db, config, log.New(io.Discard, "", 0))
+        server.routes()
+        opts.Handler = server.mux
+    }
+    if opts.Secret == "" {
+        fmt.Fprintln(os.Stderr, "Warning: no webhook secret; expect every request to be rejected with 401")
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    target := opts.URL
+    if *inProcess {
+        target = "the in-process handler"
+    }
+    logger.Printf("Sending %d webhooks/s to %s for %v (Ctrl-C to stop early)", opts.Rate, target, opts.Duration)
+    logger.Printf("Generated members use @%s", loadTestDomain)
+
+    result, err := RunLoadTest(ctx, opts)
+    if err != nil {
+        logger.Fatalf("Load test failed: %v", err)
+    }
+    fmt.Print("\n" + result.Report())
+}
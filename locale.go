@@ -0,0 +1,86 @@
+package main
+
+import (
+    "strings"
+)
+
+// accentFolder strips the accents Spanish and French statuses and
+// frequencies carry, so "Réussi" and "Reussi" read the same
+var accentFolder = strings.NewReplacer(
+    "á", "a", "à", "a", "â", "a", "ä", "a",
+    "é", "e", "è", "e", "ê", "e", "ë", "e",
+    "í", "i", "ì", "i", "î", "i", "ï", "i",
+    "ó", "o", "ò", "o", "ô", "o", "ö", "o",
+    "ú", "u", "ù", "u", "û", "u", "ü", "u",
+    "ñ", "n", "ç", "c",
+)
+
+// foldText lowercases s and strips its accents
+func foldText(s string) string {
+    return accentFolder.Replace(strings.ToLower(strings.TrimSpace(s)))
+}
+
+// localizedStatuses translate Spanish and French payment statuses to the
+// English word the status rules look for. Like defaultStatusRules they're
+// checked top-down, so refunds and failures come before success: "impayé"
+// contains "payé".
+var localizedStatuses = []struct {
+    english string
+    words   []string
+}{
+    {"chargeback", []string{"contracargo", "retrocargo", "retrofacturation"}},
+    {"disputed", []string{"disput", "litigio", "litige", "contestation"}},
+    {"refunded", []string{"reembols", "devolucion", "devuelto", "rembours"}},
+    {"cancelled", []string{"cancelad", "anulad", "annul", "resili"}},
+    {"inactive", []string{"inactiv", "inactif"}},
+    {"failed", []string{"fallid", "fallo", "rechazad", "denegad", "impaye", "echou", "echec", "refus"}},
+    {"suspended", []string{"suspendid", "suspendu"}},
+    {"pending", []string{"pendiente", "en espera", "en attente", "en cours"}},
+    {"succeeded", []string{"exitos", "completad", "aprobad", "pagad", "reussi", "effectue", "approuve", "paye"}},
+    {"active", []string{"activ", "actif"}},
+}
+
+// localizeStatus returns the English equivalent of a Spanish or French
+// payment status, or "" if it isn't one
+func localizeStatus(paymentStatus string) string {
+    folded := foldText(paymentStatus)
+    for _, l := range localizedStatuses {
+        for _, word := range l.words {
+            if strings.Contains(folded, word) {
+                return l.english
+            }
+        }
+    }
+    return ""
+}
+
+// frequencySynonyms are the words, in English, Spanish, and French, that
+// mark each recurring period, checked top-down against folded text
+var frequencySynonyms = []struct {
+    months int
+    words  []string
+}{
+    {1, []string{"month", "mensu"}},
+    {3, []string{"quarter", "trimestr"}},
+    {12, []string{"annual", "year", "anual", "annuel", "annee"}},
+}
+
+// oneTimeFrequencies are the whole values, folded, that mean a single gift
+var oneTimeFrequencies = map[string]bool{
+    "one-time": true, "one time": true, "onetime": true, "once": true, "single": true,
+    "unica vez": true, "una vez": true, "unico": true, "unica": true, "puntual": true,
+    "une fois": true, "unique": true, "ponctuel": true, "ponctuelle": true,
+}
+
+// isOneTimeFrequency reports whether a source's frequency means a single
+// gift rather than a recurring one
+func isOneTimeFrequency(frequency string) bool {
+    return oneTimeFrequencies[foldText(frequency)]
+}
+
+// knownFrequency reports whether a frequency is one-time or one of the
+// recurring periods; anything else goes to the unmapped-values review
+func knownFrequency(frequency string) bool {
+    _, ok := frequencyMonths(frequency)
+    return ok || isOneTimeFrequency(frequency)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+)
+
+// StatusChange is one status_history row
+type StatusChange struct {
+    Status    string    `json:"status"`
+    Source    string    `json:"source"`
+    Detail    string    `json:"detail,omitempty"`
+    ChangedAt time.Time `json:"changed_at"`
+}
+
+// WebhookLogEntry is one webhook_logs row
+type WebhookLogEntry struct {
+    ID            int             `json:"id,omitempty"`
+    ReceivedAt    time.Time       `json:"received_at"`
+    Email         string          `json:"email"`
+    Status        string          `json:"status"`
+    Source        string          `json:"source,omitempty"`
+    Payload       json.RawMessage `json:"payload"`
+    State         string          `json:"state,omitempty"`
+    Attempts      int             `json:"attempts,omitempty"`
+    NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+    LastError     string          `json:"last_error,omitempty"`
+    SkipReason    string          `json:"skip_reason,omitempty"`
+}
+
+// GetStatusHistory returns a member's most recent status changes, newest first
+func (db *Database) GetStatusHistory(email string, limit int) ([]StatusChange, error) {
+    email = db.NormalizeEmail(email)
+
+    rows, err := db.Query(`
+        SELECT h.status, h.source, COALESCE(h.detail, ''), h.changed_at
+        FROM status_history h
+        JOIN members m ON m.id = h.member_id
+        WHERE m.email = $1
+        ORDER BY h.changed_at DESC, h.id DESC
+        LIMIT $2
+    `, email, limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get status history: %w", err)
+    }
+    defer rows.Close()
+
+    var history []StatusChange
+    for rows.Next() {
+        var c StatusChange
+        if err := rows.Scan(&c.Status, &c.Source, &c.Detail, &c.ChangedAt); err != nil {
+            return nil, err
+        }
+        history = append(history, c)
+    }
+
+    return history, rows.Err()
+}
+
+// GetWebhookLogs returns the most recent webhooks received for an email,
+// newest first. Logs keep the address as sent, so both the raw and the
+// normalized form are matched case-insensitively.
+func (db *Database) GetWebhookLogs(email string, limit int) ([]WebhookLogEntry, error) {
+    raw := strings.ToLower(strings.TrimSpace(email))
+
+    rows, err := db.Query(`
+        SELECT received_at, COALESCE(email, ''), COALESCE(status, ''), COALESCE(source, ''), payload
+        FROM webhook_logs
+        WHERE lower(trim(email)) IN ($1, $2)
+        ORDER BY received_at DESC, id DESC
+        LIMIT $3
+    `, raw, db.NormalizeEmail(email), limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get webhook logs: %w", err)
+    }
+    defer rows.Close()
+
+    var logs []WebhookLogEntry
+    for rows.Next() {
+        var entry WebhookLogEntry
+        var payload []byte
+        if err := rows.Scan(&entry.ReceivedAt, &entry.Email, &entry.Status, &entry.Source, &payload); err != nil {
+            return nil, err
+        }
+        entry.Payload = payload
+        logs = append(logs, entry)
+    }
+
+    return logs, rows.Err()
+}
+
+// memberLookup is the --json output of the lookup command
+type memberLookup struct {
+    ID             string            `json:"id"`
+    Email          string            `json:"email"`
+    Name           string            `json:"name,omitempty"`
+    IsAnonymous    bool              `json:"is_anonymous"`
+    Status         string            `json:"status"`
+    Frequency      string            `json:"frequency,omitempty"`
+    Notes          string            `json:"notes,omitempty"`
+    Tags           []string          `json:"tags"`
+    FirstSeen      time.Time         `json:"first_seen"`
+    LastUpdated    time.Time         `json:"last_updated"`
+    FirstPaymentAt *time.Time        `json:"first_payment_at,omitempty"`
+    LastPaymentAt  *time.Time        `json:"last_payment_at,omitempty"`
+    EmailOptIn     *bool             `json:"email_opt_in"`
+    ConsentSource  string            `json:"consent_source,omitempty"`
+    Household      []string          `json:"household,omitempty"`
+    Giving         []DonationTotal   `json:"giving"`
+    Donations      []apiDonation     `json:"donations"`
+    History        []StatusChange    `json:"status_history"`
+    WebhookLogs    []WebhookLogEntry `json:"webhook_logs"`
+}
+
+func runLookup(logger *log.Logger) {
+    lookupCmd := flag.NewFlagSet("lookup", flag.ExitOnError)
+    asJSON := lookupCmd.Bool("json", false, "Print machine-readable JSON")
+    historyLimit := lookupCmd.Int("history", 10, "Number of status changes to show")
+    logLimit := lookupCmd.Int("logs", 5, "Number of webhook log entries to show")
+    donationLimit := lookupCmd.Int("donations", 10, "Number of donations to show")
+
+    args := parseSubcommand(lookupCmd, "memberships lookup <email> [--json]", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: lookup command requires an email")
+        lookupCmd.Usage()
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    member, err := db.GetMemberByEmail(args[0])
+    if errors.Is(err, ErrMemberNotFound) {
+        fmt.Fprintf(os.Stderr, "No member found for %s\n", db.NormalizeEmail(args[0]))
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Lookup failed: %v", err)
+    }
+
+    history, err := db.GetStatusHistory(member.Email, *historyLimit)
+    if err != nil {
+        logger.Fatalf("Lookup failed: %v", err)
+    }
+
+    logs, err := db.GetWebhookLogs(args[0], *logLimit)
+    if err != nil {
+        logger.Fatalf("Lookup failed: %v", err)
+    }
+
+    donations, err := db.GetDonations(member.Email, *donationLimit)
+    if err != nil {
+        logger.Fatalf("Lookup failed: %v", err)
+    }
+    totals, err := db.GetDonationTotals(member.Email)
+    if err != nil {
+        logger.Fatalf("Lookup failed: %v", err)
+    }
+    household, err := db.GetHousehold(member.Email)
+    if err != nil {
+        logger.Fatalf("Lookup failed: %v", err)
+    }
+
+    result := memberLookup{
+        ID:          member.PublicID,
+        Email:       member.Email,
+        Name:        member.Name.String,
+        IsAnonymous: member.IsAnonymous,
+        Status:      member.Status,
+        Frequency:   member.Frequency.String,
+        Notes:       member.Notes.String,
+        Tags:        member.Tags,
+        FirstSeen:   member.FirstSeen,
+        LastUpdated: member.LastUpdated,
+        Household:   household,
+        Giving:      totals,
+        Donations:   []apiDonation{},
+        History:     history,
+        WebhookLogs: logs,
+    }
+    for _, d := range donations {
+        result.Donations = append(result.Donations, newAPIDonation(d))
+    }
+    if member.FirstPaymentAt.Valid {
+        result.FirstPaymentAt = &member.FirstPaymentAt.Time
+    }
+    if member.LastPaymentAt.Valid {
+        result.LastPaymentAt = &member.LastPaymentAt.Time
+    }
+    if member.EmailOptIn.Valid {
+        result.EmailOptIn = &member.EmailOptIn.Bool
+        result.ConsentSource = member.ConsentSource.String
+    }
+
+    if *asJSON {
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        if err := encoder.Encode(result); err != nil {
+            logger.Fatalf("Failed to encode JSON: %v", err)
+        }
+        return
+    }
+
+    printMemberLookup(result)
+}
+
+// printMemberLookup prints a lookup result for humans
+func printMemberLookup(m memberLookup) {
+    name := m.Name
+    if m.IsAnonymous {
+        name = "(anonymous)"
+    } else if name == "" {
+        name = "(none)"
+    }
+
+    fmt.Printf("\n=== %s ===\n", m.Email)
+    fmt.Printf("Name:         %s\n", name)
+    fmt.Printf("Status:       %s\n", m.Status)
+    if m.Frequency != "" {
+        fmt.Printf("Frequency:    %s\n", m.Frequency)
+    }
+    fmt.Printf("First Seen:   %s\n", displayDate(m.FirstSeen))
+    fmt.Printf("Last Updated: %s\n", displayTime(m.LastUpdated))
+    if m.LastPaymentAt != nil {
+        fmt.Printf("Last Payment: %s\n", displayDate(*m.LastPaymentAt))
+    }
+    if len(m.Tags) > 0 {
+        fmt.Printf("Tags:         %s\n", strings.Join(m.Tags, ", "))
+    }
+    if m.EmailOptIn != nil {
+        fmt.Printf("Email:        %s (%s)\n", consentLabel(m.EmailOptIn), m.ConsentSource)
+    } else {
+        fmt.Printf("Email:        %s\n", consentLabel(nil))
+    }
+    if m.Notes != "" {
+        fmt.Printf("Notes:        %s\n", m.Notes)
+    }
+    if len(m.Household) > 0 {
+        fmt.Printf("Household:    %s (primary)", m.Household[0])
+        for _, other := range m.Household[1:] {
+            fmt.Printf(", %s", other)
+        }
+        fmt.Println()
+    }
+
+    fmt.Println("\n=== Donations ===")
+    if len(m.Donations) == 0 {
+        fmt.Println("  (none)")
+    }
+    for _, t := range m.Giving {
+        fmt.Printf("  Lifetime: %s %s over %d gifts (average %s)\n", t.LifetimeTotal, t.Currency, t.Count, t.AverageGift)
+    }
+    for _, d := range m.Donations {
+        line := fmt.Sprintf("  %s  %10s %s  [%s]", displayDate(d.OccurredAt), d.Amount, d.Currency, d.Source)
+        if d.Frequency != "" {
+            line += " " + d.Frequency
+        }
+        fmt.Println(line)
+    }
+
+    fmt.Println("\n=== Status History ===")
+    if len(m.History) == 0 {
+        fmt.Println("  (none)")
+    }
+    for _, c := range m.History {
+        line := fmt.Sprintf("  %s  %-10s  [%s]", displayTime(c.ChangedAt), c.Status, c.Source)
+        if c.Detail != "" {
+            line += " " + c.Detail
+        }
+        fmt.Println(line)
+    }
+
+    fmt.Println("\n=== Recent Webhooks ===")
+    if len(m.WebhookLogs) == 0 {
+        fmt.Println("  (none)")
+    }
+    for _, entry := range m.WebhookLogs {
+        source := entry.Source
+        if source == "" {
+            source = "unknown"
+        }
+        fmt.Printf("  %s  %s  [%s]  %s\n", displayTime(entry.ReceivedAt), entry.Status, source, entry.Payload)
+    }
+
+    fmt.Println()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "bytes"
+    "crypto/tls"
+    "fmt"
+    "mime"
+    "net"
+    "net/mail"
+    "net/smtp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// smtpImplicitTLSPort is the submissions port, which speaks TLS from the
+// start instead of upgrading with STARTTLS
+const smtpImplicitTLSPort = 465
+
+// Mailer sends plain-text email through the SMTP_* server
+type Mailer struct {
+    host     string
+    port     int
+    username string
+    password string
+    from     string
+}
+
+// NewMailer returns a mailer for the configured SMTP server, or an error if
+// none is configured
+func NewMailer(config *Config) (*Mailer, error) {
+    if config.SMTPHost == "" || config.SMTPFrom == "" {
+        return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM must be set to send email")
+    }
+    return &Mailer{
+        host:     config.SMTPHost,
+        port:     config.SMTPPort,
+        username: config.SMTPUser,
+        password: config.SMTPPass,
+        from:     config.SMTPFrom,
+    }, nil
+}
+
+// parseAddressList reads comma-separated email addresses
+func parseAddressList(value string) ([]string, error) {
+    if strings.TrimSpace(value) == "" {
+        return nil, nil
+    }
+    list, err := mail.ParseAddressList(value)
+    if err != nil {
+        return nil, err
+    }
+    addresses := make([]string, len(list))
+    for i, a := range list {
+        addresses[i] = a.Address
+    }
+    return addresses, nil
+}
+
+// Send delivers a plain-text message to each address in to
+func (m *Mailer) Send(to []string, subject, body string) error {
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+    fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+    fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+    fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    msg.WriteString("MIME-Version: 1.0\r\n")
+    msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    msg.WriteString("\r\n")
+    msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+    from := m.from
+    if addr, err := mail.ParseAddress(m.from); err == nil {
+        from = addr.Address
+    }
+
+    addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+    var auth smtp.Auth
+    if m.username != "" {
+        auth = smtp.PlainAuth("", m.username, m.password, m.host)
+    }
+
+    if m.port != smtpImplicitTLSPort {
+        // SendMail upgrades with STARTTLS whenever the server offers it
+        if err := smtp.SendMail(addr, auth, from, to, msg.Bytes()); err != nil {
+            return fmt.Errorf("failed to send email via %s: %w", addr, err)
+        }
+        return nil
+    }
+
+    conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: m.host})
+    if err != nil {
+        return fmt.Errorf("failed to connect to %s: %w", addr, err)
+    }
+    client, err := smtp.NewClient(conn, m.host)
+    if err != nil {
+        conn.Close()
+        return fmt.Errorf("failed to connect to %s: %w", addr, err)
+    }
+    defer client.Close()
+
+    if auth != nil {
+        if err := client.Auth(auth); err != nil {
+            return fmt.Errorf("SMTP authentication failed: %w", err)
+        }
+    }
+    if err := client.Mail(from); err != nil {
+        return fmt.Errorf("failed to send email: %w", err)
+    }
+    for _, rcpt := range to {
+        if err := client.Rcpt(rcpt); err != nil {
+            return fmt.Errorf("failed to send email to %s: %w", rcpt, err)
+        }
+    }
+    w, err := client.Data()
+    if err != nil {
+        return fmt.Errorf("failed to send email: %w", err)
+    }
+    if _, err := w.Write(msg.Bytes()); err != nil {
+        return fmt.Errorf("failed to send email: %w", err)
+    }
+    if err := w.Close(); err != nil {
+        return fmt.Errorf("failed to send email: %w", err)
+    }
+    return client.Quit()
+}
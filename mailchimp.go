@@ -0,0 +1,364 @@
+package main
+
+import (
+    "bytes"
+    "crypto/md5"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// mailchimpCancelledTag marks contacts whose membership has ended
+const mailchimpCancelledTag = "cancelled"
+
+// Rate limit handling for the Mailchimp API
+const (
+    mailchimpMaxRetries = 5
+    mailchimpBaseDelay  = 2 * time.Second
+    mailchimpPageSize   = 1000
+)
+
+// MailchimpClient talks to one Mailchimp audience
+type MailchimpClient struct {
+    apiKey  string
+    listID  string
+    baseURL string
+    client  *http.Client
+    logger  *log.Logger
+}
+
+// NewMailchimpClient builds a client; the datacenter comes from the key suffix
+func NewMailchimpClient(apiKey, listID string, logger *log.Logger) (*MailchimpClient, error) {
+    if apiKey == "" || listID == "" {
+        return nil, fmt.Errorf("MAILCHIMP_API_KEY and MAILCHIMP_LIST_ID are required")
+    }
+
+    dc := apiKey[strings.LastIndex(apiKey, "-")+1:]
+
+    return &MailchimpClient{
+        apiKey:  apiKey,
+        listID:  listID,
+        baseURL: fmt.Sprintf("https://%s.api.mailchimp.com/3.0", dc),
+        client:  &http.Client{Timeout: 30 * time.Second},
+        logger:  logger,
+    }, nil
+}
+
+// mailchimpContact is an audience member as returned by the API
+type mailchimpContact struct {
+    Email       string            `json:"email_address"`
+    Status      string            `json:"status"`
+    MergeFields map[string]string `json:"merge_fields"`
+    Tags        []struct {
+        Name string `json:"name"`
+    } `json:"tags"`
+}
+
+// hasTag reports whether the contact carries a tag
+func (c mailchimpContact) hasTag(tag string) bool {
+    for _, t := range c.Tags {
+        if strings.EqualFold(t.Name, tag) {
+            return true
+        }
+    }
+    return false
+}
+
+// subscriberHash is Mailchimp's ID for a contact: the MD5 of the lowercased email
+func subscriberHash(email string) string {
+    sum := md5.Sum([]byte(strings.ToLower(email)))
+    return hex.EncodeToString(sum[:])
+}
+
+// do sends a request, backing off on 429 and 5xx responses. out may be nil.
+func (c *MailchimpClient) do(method, path string, in, out interface{}) error {
+    var payload []byte
+    if in != nil {
+        var err error
+        if payload, err = json.Marshal(in); err != nil {
+            return err
+        }
+    }
+
+    delay := mailchimpBaseDelay
+    for attempt := 0; ; attempt++ {
+        req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(payload))
+        if err != nil {
+            return err
+        }
+        req.SetBasicAuth("memberships", c.apiKey)
+        if in != nil {
+            req.Header.Set("Content-Type", "application/json")
+        }
+
+        resp, err := c.client.Do(req)
+        if err != nil {
+            return fmt.Errorf("mailchimp %s %s: %w", method, path, err)
+        }
+        body, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            return fmt.Errorf("mailchimp %s %s: %w", method, path, err)
+        }
+
+        retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+        if retryable && attempt < mailchimpMaxRetries {
+            wait := delay
+            if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+                wait = time.Duration(seconds) * time.Second
+            }
+            c.logger.Printf("Mailchimp returned %s, retrying in %v", resp.Status, wait)
+            time.Sleep(wait)
+            delay *= 2
+            continue
+        }
+
+        if resp.StatusCode < 200 || resp.StatusCode > 299 {
+            var apiErr struct {
+                Title  string `json:"title"`
+                Detail string `json:"detail"`
+            }
+            json.Unmarshal(body, &apiErr)
+            return fmt.Errorf("mailchimp %s %s: %s: %s %s", method, path, resp.Status, apiErr.Title, apiErr.Detail)
+        }
+
+        if out != nil && len(body) > 0 {
+            if err := json.Unmarshal(body, out); err != nil {
+                return fmt.Errorf("mailchimp %s %s: invalid response: %w", method, path, err)
+            }
+        }
+        return nil
+    }
+}
+
+// Contacts returns the whole audience keyed by lowercased email
+func (c *MailchimpClient) Contacts() (map[string]mailchimpContact, error) {
+    contacts := make(map[string]mailchimpContact)
+
+    for offset := 0; ; offset += mailchimpPageSize {
+        var page struct {
+            Members    []mailchimpContact `json:"members"`
+            TotalItems int                `json:"total_items"`
+        }
+        path := fmt.Sprintf("/lists/%s/members?count=%d&offset=%d&fields=members.email_address,members.status,members.merge_fields,members.tags,total_items",
+            c.listID, mailchimpPageSize, offset)
+        if err := c.do(http.MethodGet, path, nil, &page); err != nil {
+            return nil, err
+        }
+
+        for _, contact := range page.Members {
+            contacts[strings.ToLower(contact.Email)] = contact
+        }
+
+        if len(page.Members) < mailchimpPageSize || offset+mailchimpPageSize >= page.TotalItems {
+            return contacts, nil
+        }
+    }
+}
+
+// Upsert adds or updates a contact. New contacts are subscribed; existing
+// unsubscribes are left alone.
+func (c *MailchimpClient) Upsert(email string, mergeFields map[string]string) error {
+    body := map[string]interface{}{
+        "email_address": email,
+        "status_if_new": "subscribed",
+    }
+    if mergeFields != nil {
+        body["merge_fields"] = mergeFields
+    }
+    return c.do(http.MethodPut, fmt.Sprintf("/lists/%s/members/%s", c.listID, subscriberHash(email)), body, nil)
+}
+
+// Unsubscribe marks a contact unsubscribed, so it stays in the audience but
+// gets no campaigns
+func (c *MailchimpClient) Unsubscribe(email string) error {
+    body := map[string]interface{}{"status": "unsubscribed"}
+    return c.do(http.MethodPatch, fmt.Sprintf("/lists/%s/members/%s", c.listID, subscriberHash(email)), body, nil)
+}
+
+// SetTag adds or removes a tag on a contact
+func (c *MailchimpClient) SetTag(email, tag string, active bool) error {
+    status := "inactive"
+    if active {
+        status = "active"
+    }
+    body := map[string]interface{}{
+        "tags": []map[string]string{{"name": tag, "status": status}},
+    }
+    return c.do(http.MethodPost, fmt.Sprintf("/lists/%s/members/%s/tags", c.listID, subscriberHash(email)), body, nil)
+}
+
+// Archive removes a contact from the audience without deleting its history
+func (c *MailchimpClient) Archive(email string) error {
+    return c.do(http.MethodDelete, fmt.Sprintf("/lists/%s/members/%s", c.listID, subscriberHash(email)), nil, nil)
+}
+
+// mailchimpMergeFields returns FNAME/LNAME for a member, or nil for
+// anonymous members so only their email is synced
+func mailchimpMergeFields(m SyncMember) map[string]string {
+    if m.IsAnonymous || m.Name == "" {
+        return nil
+    }
+    first, last, _ := strings.Cut(strings.TrimSpace(m.Name), " ")
+    return map[string]string{"FNAME": first, "LNAME": strings.TrimSpace(last)}
+}
+
+// mailchimpChange is one planned change to the audience
+type mailchimpChange struct {
+    action string
+    member SyncMember
+    detail string
+}
+
+// planMailchimpSync diffs members against the audience. Members who opted
+// out of email are never added, and are unsubscribed if they're there.
+func planMailchimpSync(members []SyncMember, contacts map[string]mailchimpContact, archive bool) []mailchimpChange {
+    var changes []mailchimpChange
+
+    for _, m := range members {
+        contact, exists := contacts[strings.ToLower(m.Email)]
+
+        if m.OptedOut {
+            if exists && contact.Status == "subscribed" {
+                changes = append(changes, mailchimpChange{"unsubscribe", m, "opted out"})
+            }
+            continue
+        }
+
+        if m.Status == "active" {
+            fields := mailchimpMergeFields(m)
+            switch {
+            case !exists:
+                changes = append(changes, mailchimpChange{"add", m, ""})
+            case contact.hasTag(mailchimpCancelledTag):
+                changes = append(changes, mailchimpChange{"update", m, "remove cancelled tag"})
+            case fields != nil && (contact.MergeFields["FNAME"] != fields["FNAME"] || contact.MergeFields["LNAME"] != fields["LNAME"]):
+                changes = append(changes, mailchimpChange{"update", m, "name"})
+            }
+            continue
+        }
+
+        if m.Status == "cancelled" && exists && contact.Status != "archived" {
+            if archive {
+                changes = append(changes, mailchimpChange{"archive", m, ""})
+            } else if !contact.hasTag(mailchimpCancelledTag) {
+                changes = append(changes, mailchimpChange{"tag", m, "cancelled"})
+            }
+        }
+    }
+
+    return changes
+}
+
+// applyMailchimpChange sends one change
+func applyMailchimpChange(c *MailchimpClient, change mailchimpChange) error {
+    switch change.action {
+    case "add":
+        return c.Upsert(change.member.Email, mailchimpMergeFields(change.member))
+    case "update":
+        if err := c.Upsert(change.member.Email, mailchimpMergeFields(change.member)); err != nil {
+            return err
+        }
+        if change.detail == "remove cancelled tag" {
+            return c.SetTag(change.member.Email, mailchimpCancelledTag, false)
+        }
+        return nil
+    case "tag":
+        return c.SetTag(change.member.Email, mailchimpCancelledTag, true)
+    case "archive":
+        return c.Archive(change.member.Email)
+    case "unsubscribe":
+        return c.Unsubscribe(change.member.Email)
+    }
+    return fmt.Errorf("unknown action %q", change.action)
+}
+
+func runSyncMailchimp(args []string, logger *log.Logger) {
+    mailchimpCmd := flag.NewFlagSet("sync mailchimp", flag.ExitOnError)
+    dryRun := mailchimpCmd.Bool("dry-run", false, "Show the changes without sending anything")
+    archive := mailchimpCmd.Bool("archive", false, "Archive cancelled members instead of tagging them")
+
+    parseSubcommand(mailchimpCmd, "memberships sync mailchimp [--dry-run] [--archive]", args)
+
+    config := mustLoadConfig(logger)
+    client, err := NewMailchimpClient(config.MailchimpAPIKey, config.MailchimpListID, logger)
+    if err != nil {
+        logger.Fatalf("Mailchimp sync failed: %v", err)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if db.Privacy != nil && !db.Privacy.CanDecrypt() {
+        logger.Fatal("Mailchimp sync needs email addresses, which PRIVACY_MODE=hash doesn't keep; use PRIVACY_MODE=encrypt")
+    }
+
+    members, err := db.GetSyncMembers()
+    if err != nil {
+        logger.Fatalf("Failed to get members: %v", err)
+    }
+
+    // Members hashed before their address was kept can't be synced
+    synced := members[:0]
+    for _, m := range members {
+        if !isEmailKey(m.Email) {
+            synced = append(synced, m)
+        }
+    }
+    unsynced := len(members) - len(synced)
+    members = synced
+    if unsynced > 0 {
+        logger.Printf("Skipping %d members with no stored address", unsynced)
+    }
+
+    logger.Println("Loading Mailchimp audience...")
+    contacts, err := client.Contacts()
+    if err != nil {
+        logger.Fatalf("Failed to load audience: %v", err)
+    }
+    logger.Printf("Audience has %d contacts; database has %d members", len(contacts), len(members))
+
+    changes := planMailchimpSync(members, contacts, *archive)
+
+    counts := map[string]int{}
+    for _, change := range changes {
+        counts[change.action]++
+        who := change.member.Email
+        if change.member.IsAnonymous {
+            who += " (anonymous, email only)"
+        }
+        line := fmt.Sprintf("  %-7s %s", change.action, who)
+        if change.detail != "" {
+            line += " [" + change.detail + "]"
+        }
+        fmt.Println(line)
+    }
+    fmt.Printf("\nAdd: %d, Update: %d, Tag cancelled: %d, Archive: %d\n",
+        counts["add"], counts["update"], counts["tag"], counts["archive"])
+
+    if *dryRun {
+        fmt.Println("DRY RUN complete - nothing sent to Mailchimp")
+        return
+    }
+
+    failed := 0
+    for _, change := range changes {
+        if err := applyMailchimpChange(client, change); err != nil {
+            logger.Printf("Error syncing %s: %v", change.member.Email, err)
+            failed++
+        }
+    }
+
+    fmt.Printf("Synced %d changes to Mailchimp, %d errors\n", len(changes)-failed, failed)
+    if failed > 0 {
+        os.Exit(1)
+    }
+}
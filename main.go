@@ -1,41 +1,125 @@
 package main
 
 import (
-    "encoding/csv"
+    "context"
+    "encoding/json"
     "flag"
     "fmt"
     "log"
     "os"
-    "strings"
-
-    "github.com/joho/godotenv"
+    "sort"
 )
 
-var logger *log.Logger
-
 func main() {
-    // Set up logger
-    logger = log.New(os.Stdout, "[MEMBERSHIP] ", log.LstdFlags|log.Lshortfile)
+    log.SetOutput(os.Stdout)
+    log.SetPrefix("[MEMBERSHIP] ")
+    log.SetFlags(log.LstdFlags | log.Lshortfile)
+    logger := log.Default()
+    
+    // --config applies to every subcommand
+    os.Args, configPath = extractConfigFlag(os.Args)
     
     // Handle subcommands
     if len(os.Args) < 2 {
         // No subcommand - run webhook server
-        runServer()
+        runServer(logger)
         return
     }
     
     switch os.Args[1] {
     case "server":
-        runServer()
+        runServer(logger)
     case "clean":
-        runClean()
+        runClean(logger)
+    case "import":
+        runImport(logger)
+    case "undo":
+        runUndo(logger)
+    case "backup":
+        runBackup(logger)
+    case "restore":
+        runRestore(logger)
+    case "export":
+        runExport(logger)
     case "stats":
-        runStats()
+        runStats(logger)
+    case "snapshot":
+        runSnapshot(logger)
+    case "lookup":
+        runLookup(logger)
+    case "verify-hash":
+        runVerifyHash(logger)
+    case "set-status":
+        runSetStatus(logger)
+    case "add":
+        runAdd(logger)
+    case "forget":
+        runForget(logger)
+    case "consent":
+        runConsent(logger)
+    case "sar":
+        runSAR(logger)
+    case "encrypt-existing":
+        runEncryptExisting(logger)
+    case "merge":
+        runMerge(logger)
+    case "dedupe":
+        runDedupe(logger)
+    case "link":
+        runLink(logger)
+    case "unlink":
+        runUnlink(logger)
+    case "org":
+        runOrganization(logger)
+    case "doctor":
+        runDoctor(logger)
+    case "lapse":
+        runLapse(logger)
+    case "protect":
+        runProtect(logger)
+    case "tag":
+        runTag(logger)
+    case "note":
+        runNote(logger)
+    case "reconcile":
+        runReconcile(logger)
+    case "link-discord":
+        runLinkDiscord(logger)
+    case "sync":
+        runSyncTarget(logger)
+    case "subscriptions":
+        runSubscriptions(logger)
+    case "retry-failed":
+        runRetryFailed(logger)
+    case "failed":
+        runFailed(logger)
+    case "statuses":
+        runStatuses(logger)
+    case "events":
+        runEvents(logger)
+    case "report":
+        runReport(logger)
+    case "access-log":
+        runAccessLog(logger)
+    case "prune":
+        runPrune(logger)
+    case "digest":
+        runDigest(logger)
+    case "jobs":
+        runJobs(logger)
+    case "pending":
+        runPending(logger)
+    case "seed":
+        runSeed(logger)
+    case "loadtest":
+        runLoadTest(logger)
+    case "version", "--version":
+        runVersion()
     case "help", "-h", "--help":
         printHelp()
     default:
         // If first arg doesn't match any subcommand, assume server mode
-        runServer()
+        runServer(logger)
     }
 }
 
@@ -43,62 +127,392 @@ func printHelp() {
     fmt.Println(`Membership Manager
 
 Usage:
+  memberships [--config file] <command>
   memberships                    Run the webhook server (default)
   memberships server             Run the webhook server
   memberships clean <csv-file>   Sync database with GiveLively CSV export
-  memberships stats              Display membership statistics
+                                 (also accepts an https:// URL, or "-" for stdin)
+  memberships clean --history    List recent clean runs
+  memberships clean <csv-file> --review
+                                 Queue the changes for approval instead of applying them
+  memberships undo <run-id>      Reverse the status changes of a clean run
+  memberships import <csv-file> [--dry-run] [--report file] [--status active]
+                                 Create or update members in bulk from a donor list
+                                 (email, name, status, anonymous, frequency, campaign, and optionally
+                                 amount and date to record donations); never deactivates
+  memberships backup [--output members.json.gz] [--webhooks]
+                                 Export members and status history (and webhook logs)
+  memberships restore <file> [--dry-run]
+                                 Re-import a backup, upserting members by email
+  memberships export [--format csv|jsonl|givelively] [--fields id,email,...] [--status S] [--tag T] [--frequency F] [--campaign C] [--output file]
+                                 Export members as CSV or JSON Lines (one member per line);
+                                 givelively writes GiveLively's export columns with each
+                                 member's latest donation, for diffing or feeding to clean
+  memberships stats [--json]     Display membership statistics
+  memberships stats --history [--days 90]
+                                 Show daily member counts from recorded snapshots
+  memberships snapshot           Record today's member counts (the server does this daily)
+  memberships report retention [--months 12] [--format table|csv|json]
+                                 Show how many of each month's new members stayed active
+  memberships report outreach [--output outreach.csv] [--renewal-days 30] [--suspended-days 14]
+                                 List members worth a personal email: annual renewals coming up,
+                                 failed monthly payments, long suspensions (never anonymous members)
+  memberships report anniversaries [--within 14d] [--milestones] [--format table|csv|json]
+                                 List upcoming membership anniversaries (and recent gift milestones)
+  memberships lookup <email> [--json]
+                                 Show a member's details, status history, and recent webhooks
+  memberships verify-hash <email>
+                                 Print the hash GET /verify/hash/{hash} expects for an email
+  memberships set-status <email> <active|cancelled|suspended> [--reason "text"]
+                                 Manually correct a member's status
+  memberships add <email> [--name "Full Name"] [--anonymous] [--status active] [--tag comped] [--update]
+                                 Create a member by hand (comped, honorary)
+  memberships forget <email> --confirm
+                                 Irreversibly erase a member's personal data
+  memberships consent <email> --opt-in|--opt-out
+                                 Record whether a member agreed to email
+  memberships sar <email|id> [--output jane.json] [--include-notes]
+                                 Export everything held about one member for an
+                                 access request (notes are withheld by default)
+  memberships encrypt-existing --confirm
+                                 Convert stored emails to PRIVACY_MODE keys (one time)
+  memberships merge <old-email> <new-email> [--dry-run]
+                                 Merge a duplicate member into another record
+  memberships dedupe [--merge] [--dry-run]
+                                 Report (and merge) rows that collapse under normalization
+  memberships link <primary-email> <secondary-email> [--name "Full Name"] [--by NAME]
+                                 Add a member to another's household; their status then
+                                 follows the primary's (creates the secondary if needed)
+  memberships unlink <secondary-email> [--by NAME]
+                                 Take a member out of their household (leaves them cancelled)
+  memberships org list
+  memberships org create "<name>" [--tier T] [--status active]
+  memberships org attach <org> <email>... [--name "Full Name"]
+  memberships org detach <org> <email>...
+  memberships org status <org> <active|cancelled|suspended>
+                                 Manage organizational memberships; attached emails verify
+                                 as active while their organization is (<org> is a name or #id)
+  memberships lapse [--dry-run] [--grace-days N]
+                                 Mark members lapsed when their renewal is overdue
+  memberships protect <email> [--remove]
+                                 Never let clean or webhooks deactivate this member
+  memberships tag <email> <tag> [--remove]
+                                 Add or remove a member tag ("protected" is never deactivated by clean)
+  memberships note <email> "text"
+                                 Set a member's notes
+  memberships reconcile stripe [--dry-run|--review]
+                                 Sync membership status from Stripe subscriptions (same flags as clean)
+  memberships sync mailchimp [--dry-run] [--archive]
+                                 Push active members to the Mailchimp audience and tag cancelled ones
+  memberships sync discord [--dry-run]
+                                 Grant the Discord member role to active members, revoke it otherwise
+  memberships link-discord <csv-file>
+                                 Import email,discord_id pairs linking members to Discord users
+  memberships subscriptions <list|add|remove|enable|test> [args]
+                                 Manage outbound webhooks fired on member status changes
+  memberships retry-failed [--dry-run]
+                                 Reprocess webhooks that exhausted their automatic retries
+  memberships failed list [--state open|retried|dismissed|all] [--verbose]
+  memberships failed retry <id>
+  memberships failed dismiss <id> --reason "text"
+                                 Review webhooks that couldn't be processed (bad JSON,
+                                 bad email, unknown status, database errors)
+  memberships statuses list [--all]
+  memberships statuses map <payment-status> <status> [--retry]
+  memberships statuses unmap <payment-status>
+                                 Review payment statuses no rule matched and map them,
+                                 and frequencies no synonym covers; --retry reprocesses
+                                 the webhooks held for a status
+  memberships pending list [--state pending|approved|rejected|expired|superseded|skipped|all]
+  memberships pending approve <id>... [--by NAME]
+  memberships pending approve-all [--by NAME] [--max-deactivate-percent N] [--force]
+  memberships pending reject <id>... [--reason "text"] [--by NAME]
+                                 Review changes queued by clean or reconcile --review;
+                                 approved changes are one sync run that undo can reverse
+  memberships events [--since ID] [--type TYPE] [--follow] [--json]
+                                 Show the feed of member, clean, and admin changes
+  memberships access-log [--since 7d] [--json]
+                                 Show who read member data through the API
+  memberships prune [--dry-run]  Delete webhook logs, access logs, and job runs past
+                                 their retention (the server does this daily)
+  memberships jobs list          Show the server's background jobs, their schedules, and last runs
+  memberships jobs run <name>    Run a background job now; refused while it is already running
+  memberships digest [--to addr,...] [--date YYYY-MM-DD] [--always] [--print]
+                                 Email yesterday's new members, cancellations,
+                                 reactivations, and webhook failures
+  memberships seed [--members 500] [--days 365] [--seed 1] [--force]
+                                 Fill a development database with fake members, history,
+                                 webhook logs, and donations (refuses real data without --force)
+  memberships loadtest [--url URL] [--rate 50] [--duration 60s] [--workers 20] [--secret S] [--in-process]
+                                 Post randomized webhooks at a fixed rate and report latency
+                                 percentiles, responses by status code, and throughput
+                                 (--in-process skips the network and writes to DATABASE_URL)
+  memberships doctor             Check configuration, schema, and stored data (read-only)
+  memberships version            Show build version
   memberships help               Show this help message
 
+Configuration is read from a flat YAML file (--config or MEMBERSHIPS_CONFIG)
+using the lowercase names below, e.g. "database_url: postgres://...".
+Environment variables and .env take precedence over the file.
+
 Environment variables:
   DATABASE_URL     PostgreSQL connection string (required)
-  WEBHOOK_SECRET   Secret for authenticating webhooks (required for server)
-  PORT            Port to listen on (default: 3000)`)
+  WEBHOOK_SECRET   Secret for authenticating webhooks (required for server); a
+                   comma-separated list accepts any of them during rotation
+  WEBHOOK_SECRET_<NAME>
+                   Secret for a named webhook source (e.g. WEBHOOK_SECRET_BACKFILL);
+                   the name is recorded on each webhook log and history entry
+  ADMIN_TOKEN      Bearer token for admin endpoints (admin API disabled if unset);
+                   also accepts a comma-separated list. /dashboard also takes it as
+                   the password of a browser login (any username)
+  WEBHOOK_FAIL_HARD
+                   Set to "true" to return 500 on transient webhook failures so Zapier retries
+  EMAIL_NORMALIZATION
+                   Set to "true" to strip plus suffixes and gmail dots from emails
+  PRIVACY_MODE     "off" (default) stores emails as given; "hash" stores only an
+                   HMAC of each email; "encrypt" also keeps the email encrypted
+                   (needed for Mailchimp sync). Convert an existing database
+                   with memberships encrypt-existing.
+  EMAIL_PEPPER     HMAC key for PRIVACY_MODE. Required with it, and must never
+                   change: members can't be found under a different pepper
+  EMAIL_ENCRYPTION_KEY
+                   32-byte key, hex or base64, for PRIVACY_MODE=encrypt
+  STATUS_TRANSITIONS
+                   "warn" (default) logs status changes outside the allowed
+                   transitions; "reject" refuses them
+  LAPSE_INTERVAL   Run the lapse job in server mode at this interval (e.g. 24h)
+  LAPSE_GRACE_DAYS Days past the expected renewal before lapsing (default: 14)
+  FAILED_PAYMENT_LIMIT
+                   Consecutive failed payments before a suspended member is
+                   cancelled (default: 3)
+  STATUS_RULES     Comma-separated pattern=status rules mapping payment statuses,
+                   checked in order (e.g. "refund=cancelled,succeed=active"), or
+                   a JSON array of {"pattern", "status"}; status may be "failed"
+                   to count toward FAILED_PAYMENT_LIMIT. Spanish and French
+                   statuses ("Exitoso", "Échoué") match through built-in synonyms
+  STATUS_RULES_FILE
+                   Read the rules from a JSON file instead
+  STRICT_STATUS    Reject webhooks whose payment status no rule matches with a
+                   422, keeping them under "failed" for review (default: false)
+  STATUS_FALLBACK  Status given for a payment status no rule matches when
+                   STRICT_STATUS is false (default: unknown; never active)
+  NOTIFY_WEBHOOK_URL
+                   Slack-compatible incoming webhook for member notifications
+  NOTIFY_EVENTS    Events to announce (default: created,cancelled,reactivated)
+  MAILCHIMP_API_KEY, MAILCHIMP_LIST_ID
+                   Mailchimp credentials and audience for sync mailchimp
+  VERIFY_TOKEN     Comma-separated name:token pairs for POST /verify (disabled if unset)
+  VERIFY_ACTIVE_STATUSES
+                   Statuses /verify and /verify/hash report as active (default: active)
+  VERIFY_RATE_LIMIT
+                   /verify requests per caller per minute (default: 60)
+  MEMBER_COUNTING  "individuals" (default) counts every member in stats and
+                   /verify; "households" counts a linked household once
+  SYNC_SOURCE      URL or directory of CSV drops for the server's scheduled clean
+                   (also enables POST /sync)
+  SYNC_INTERVAL    Run the scheduled clean at this interval (e.g. 720h)
+  STRIPE_API_KEY   Stripe key with read access to subscriptions and customers
+  DISCORD_BOT_TOKEN, DISCORD_GUILD_ID, DISCORD_ROLE_ID
+                   Bot credentials and the role granted to active members
+  DISCORD_SYNC_INTERVAL
+                   Run the Discord role sync in server mode at this interval (e.g. 1h)
+  SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASS
+                   Mail server for the digest and member emails (port default: 587 with STARTTLS;
+                   465 uses implicit TLS)
+  SMTP_FROM        Sender address for email, e.g. "Memberships <noreply@example.org>"
+  DIGEST_TO        Comma-separated recipients; the server then emails the previous
+                   day's digest each morning, skipping days with no activity
+  DIGEST_TIME      Time of day to send it, in DISPLAY_TIMEZONE (default: 07:00)
+  MEMBER_EMAIL_WELCOME, MEMBER_EMAIL_CANCELLATION
+                   true to email members when they join and when they cancel
+                   (default: false); never sent to anonymous or opted-out members
+  MEMBER_EMAIL_TEMPLATES
+                   Directory of welcome.txt and cancellation.txt templates, each
+                   a "Subject:" line, a blank line, and the body, overriding the
+                   built-in ones; they can use {{.Name}}, {{.Email}}, and
+                   {{.UnsubscribeURL}}
+  PORT             Port to listen on (default: 3000)
+  LISTEN_SOCKET    Listen on this Unix socket instead of a TCP port
+  LISTEN_SOCKET_MODE
+                   Socket permissions (default: 0660)
+  PUBLIC_URL       Where members reach the server; unsubscribe links in member
+                   emails point under it (default: https://memberships.operatorfoundation.org)
+  REQUEST_TIMEOUT  Abandon requests that run longer than this with a 503
+                   (default: 30s, 0 disables)
+  MAX_CONCURRENT_REQUESTS
+                   In-flight requests allowed per route group (public, webhook,
+                   admin) before answering 503 (default: 32)
+  TRUSTED_PROXIES  Comma-separated proxy addresses or CIDRs (e.g. 127.0.0.1) whose
+                   X-Forwarded-For and X-Real-IP headers give the client address;
+                   with LISTEN_SOCKET, the headers are honored whenever it is set
+  DEBUG_ENDPOINTS  Set to "true" to serve pprof profiles and runtime stats under
+                   /debug (admin token required)
+  WEBHOOK_LOG_RETENTION
+                   Prune processed webhook logs older than this, e.g. 90d
+                   (default: 0, keep forever)
+  ACCESS_LOG_RETENTION
+                   Prune access logs older than this (default: 365d, 0 keeps forever)
+  JOB_HISTORY_RETENTION
+                   Prune background job runs older than this (default: 30d, 0 keeps forever)
+  PENDING_CHANGE_TTL
+                   How long changes queued by clean --review can be approved
+                   (default: 14d, 0 never expires)
+  PENDING_MAX_DEACTIVATE_PERCENT
+                   Refuse to approve every pending change at once when they
+                   deactivate more than this percentage of active members
+                   (default: 25)
+  DISPLAY_TIMEZONE Zone for times in CLI output and reports, e.g. America/Los_Angeles
+                   (default: the system zone; the API always uses UTC)
+  MEMBERSHIPS_CONFIG
+                   Path to a config file`)
 }
 
-func runStats() {
-    // Load .env file
-    if err := godotenv.Load(); err != nil {
-        logger.Println("No .env file found")
+func runStats(logger *log.Logger) {
+    statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+    asJSON := statsCmd.Bool("json", false, "Print stats as a single JSON document (same as --format json)")
+    format := statsCmd.String("format", "text", "Output format: text or json")
+    history := statsCmd.Bool("history", false, "Show daily snapshots instead of current counts")
+    days := statsCmd.Int("days", defaultHistoryDays, "Days of history to show with --history")
+    
+    parseSubcommand(statsCmd, "memberships stats [--json | --format json|text] [--history [--days N]]", os.Args[2:])
+    
+    if *history && *days < 1 {
+        fmt.Fprintln(os.Stderr, "Error: --days must be at least 1")
+        os.Exit(2)
     }
     
-    // Get database URL
-    dbURL := os.Getenv("DATABASE_URL")
-    if dbURL == "" {
-        logger.Fatal("DATABASE_URL environment variable is required")
+    if *asJSON {
+        *format = "json"
+    }
+    if *format != "text" && *format != "json" {
+        fmt.Fprintf(os.Stderr, "Error: unsupported format %q (use text or json)\n", *format)
+        os.Exit(2)
     }
     
-    // Connect to database
-    db, err := NewDatabase(dbURL)
-    if err != nil {
-        logger.Fatalf("Failed to connect to database: %v", err)
+    // Keep stdout to the JSON document alone
+    if *format == "json" {
+        logger.SetOutput(os.Stderr)
     }
+    
+    db := connectDatabase(logger)
     defer db.Close()
     
+    if *history {
+        if *format == "json" {
+            snapshots, err := db.GetSnapshots(*days)
+            if err != nil {
+                logger.Fatalf("Failed to get stats history: %v", err)
+            }
+            encoder := json.NewEncoder(os.Stdout)
+            encoder.SetIndent("", "  ")
+            encoder.Encode(snapshots)
+            return
+        }
+        printStatsHistory(db, *days, logger)
+        return
+    }
+    
     // Get stats
-    stats, err := db.GetStats()
+    stats, err := db.GetStats(context.Background())
     if err != nil {
         logger.Fatalf("Failed to get stats: %v", err)
     }
+    stats.Revenue, err = db.GetRevenueStats(context.Background())
+    if err != nil {
+        logger.Fatalf("Failed to get revenue stats: %v", err)
+    }
+    stats.Campaigns, err = db.GetCampaignStats(context.Background())
+    if err != nil {
+        logger.Fatalf("Failed to get campaign stats: %v", err)
+    }
+    
+    if *format == "json" {
+        recentMembers, err := db.GetRecentMembers(5)
+        if err != nil {
+            logger.Fatalf("Failed to get recent members: %v", err)
+        }
+        
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        err = encoder.Encode(map[string]interface{}{
+            "stats":          stats,
+            "recent_members": recentMembers,
+        })
+        if err != nil {
+            logger.Fatalf("Failed to encode JSON: %v", err)
+        }
+        return
+    }
     
     // Display stats
     fmt.Println("\n=== Membership Statistics ===")
+    if stats.CountedBy == "households" {
+        fmt.Println("(linked households count as one member)")
+    }
     fmt.Printf("Total Members:      %d\n", stats.TotalMembers)
     fmt.Printf("Active Members:     %d\n", stats.ActiveMembers)
     fmt.Printf("Cancelled Members:  %d\n", stats.CancelledMembers)
+    fmt.Printf("Suspended Members:  %d\n", stats.SuspendedMembers)
+    fmt.Printf("Lapsed Members:     %d\n", stats.LapsedMembers)
+    if stats.UnknownStatusMembers > 0 {
+        fmt.Printf("Unknown Status:     %d (see memberships statuses list)\n", stats.UnknownStatusMembers)
+    }
+    if len(stats.OtherStatuses) > 0 {
+        others := make([]string, 0, len(stats.OtherStatuses))
+        for status := range stats.OtherStatuses {
+            others = append(others, status)
+        }
+        sort.Strings(others)
+        for _, status := range others {
+            fmt.Printf("Unknown status %q: %d\n", status, stats.OtherStatuses[status])
+        }
+    }
     fmt.Printf("Anonymous Members:  %d\n", stats.AnonymousMembers)
+    fmt.Printf("Active, no payment in 90+ days: %d\n", stats.OverduePaymentMembers)
+    fmt.Printf("With failed payments: %d\n", stats.FailedPaymentMembers)
+    
+    if stats.ActiveMembers > 0 {
+        fmt.Println("\n=== Active Members by Frequency ===")
+        frequencies := append([]string{}, canonicalFrequencies...)
+        for _, frequency := range append(frequencies, frequencyUnknown) {
+            if count := stats.ActiveByFrequency[frequency]; count > 0 {
+                fmt.Printf("%-18s  %d (%.1f%%)\n", frequency+":", count, float64(count)*100.0/float64(stats.ActiveMembers))
+            }
+        }
+    }
+    
+    if len(stats.WebhooksBySource) > 0 {
+        sources := make([]string, 0, len(stats.WebhooksBySource))
+        for source := range stats.WebhooksBySource {
+            sources = append(sources, source)
+        }
+        sort.Strings(sources)
+        
+        fmt.Println("\n=== Webhooks by Source (30 days) ===")
+        for _, source := range sources {
+            fmt.Printf("%-18s  %d\n", source+":", stats.WebhooksBySource[source])
+        }
+    }
     
     // Calculate and display percentages if there are members
     if stats.TotalMembers > 0 {
         activePercent := float64(stats.ActiveMembers) * 100.0 / float64(stats.TotalMembers)
         cancelledPercent := float64(stats.CancelledMembers) * 100.0 / float64(stats.TotalMembers)
+        suspendedPercent := float64(stats.SuspendedMembers) * 100.0 / float64(stats.TotalMembers)
+        lapsedPercent := float64(stats.LapsedMembers) * 100.0 / float64(stats.TotalMembers)
         anonymousPercent := float64(stats.AnonymousMembers) * 100.0 / float64(stats.TotalMembers)
         
         fmt.Println("\n=== Percentages ===")
         fmt.Printf("Active:    %.1f%%\n", activePercent)
         fmt.Printf("Cancelled: %.1f%%\n", cancelledPercent)
+        fmt.Printf("Suspended: %.1f%%\n", suspendedPercent)
+        fmt.Printf("Lapsed:    %.1f%%\n", lapsedPercent)
         fmt.Printf("Anonymous: %.1f%%\n", anonymousPercent)
     }
     
+    printRevenueStats(stats.Revenue)
+    printCampaignStats(stats.Campaigns)
+    
     // Get recent activity
     recentMembers, err := db.GetRecentMembers(5)
     if err == nil && len(recentMembers) > 0 {
@@ -114,289 +528,103 @@ func runStats() {
     fmt.Println()
 }
 
-func runServer() {
-    // Load .env file
-    if err := godotenv.Load(); err != nil {
-        logger.Println("No .env file found")
-    }
+func runServer(logger *log.Logger) {
+    logger.Printf("Membership Manager %s", buildInfo())
     
-    // Build configuration
-    config := &Config{
-        DatabaseURL:   os.Getenv("DATABASE_URL"),
-        Port:          getEnvOrDefault("PORT", "3000"),
-        WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
-    }
+    config := mustLoadConfig(logger)
     
-    // Validate required configuration
-    if config.DatabaseURL == "" {
-        logger.Fatal("DATABASE_URL environment variable is required")
-    }
-    
-    if config.WebhookSecret == "" {
-        logger.Fatal("WEBHOOK_SECRET environment variable is required")
+    // Validate server-only configuration
+    if len(config.WebhookSources) == 0 {
+        logger.Fatal("WEBHOOK_SECRET is required for server mode")
     }
     
     // Connect to database
     logger.Println("Connecting to database...")
-    db, err := NewDatabase(config.DatabaseURL)
+    db, err := NewDatabase(config.DatabaseURL, logger)
     if err != nil {
         logger.Fatalf("Failed to connect to database: %v", err)
     }
     defer db.Close()
+    db.NormalizeEmails = config.NormalizeEmails
+    db.StrictTransitions = config.StrictTransitions
+    db.CountHouseholds = config.CountHouseholds
+    db.Privacy = config.Privacy
+    if err := db.CheckPrivacy(); err != nil {
+        logger.Fatalf("Privacy mode: %v", err)
+    }
+    db.Events = NewEventHub()
+    db.OnStatusChange = NewDispatcher(db, logger).StatusChanged
     logger.Println("Database connected successfully")
     
     // Start webhook server
-    server := NewWebhookServer(db, config)
-    logger.Printf("Starting server on port %s...", config.Port)
-    
-    if err := server.Start(); err != nil {
-        logger.Fatalf("Server failed: %v", err)
+    server := NewWebhookServer(db, config, logger)
+    if err := server.loadMaintenance(); err != nil {
+        logger.Fatalf("Failed to load maintenance mode: %v", err)
     }
-}
-
-func runClean() {
-    // Parse flags for clean subcommand
-    cleanCmd := flag.NewFlagSet("clean", flag.ExitOnError)
-    dryRun := cleanCmd.Bool("dry-run", false, "Show what would change without making changes")
-    verbose := cleanCmd.Bool("verbose", false, "Show detailed output")
-    
-    // Need at least "memberships clean filename.csv"
-    if len(os.Args) < 3 {
-        fmt.Println("Error: clean command requires a CSV filename")
-        fmt.Println("Usage: memberships clean <csv-file> [--dry-run] [--verbose]")
-        os.Exit(1)
-    }
-    
-    // Parse flags (everything after the filename)
-    cleanCmd.Parse(os.Args[3:])
-    
-    csvFile := os.Args[2]
-    
-    // Load .env file
-    if err := godotenv.Load(); err != nil {
-        logger.Println("No .env file found")
-    }
-    
-    // Get database URL
-    dbURL := os.Getenv("DATABASE_URL")
-    if dbURL == "" {
-        logger.Fatal("DATABASE_URL environment variable is required")
-    }
-    
-    // Connect to database
-    logger.Println("Connecting to database...")
-    db, err := NewDatabase(dbURL)
-    if err != nil {
-        logger.Fatalf("Failed to connect to database: %v", err)
+    server.registerJobs()
+    server.startJobs()
+    if config.ListenSocket != "" {
+        logger.Printf("Starting server on socket %s...", config.ListenSocket)
+    } else {
+        logger.Printf("Starting server on port %s...", config.Port)
     }
-    defer db.Close()
     
-    // Process the CSV file
-    if err := cleanDatabase(db, csvFile, *dryRun, *verbose); err != nil {
-        logger.Fatalf("Clean failed: %v", err)
+    if err := server.Start(); err != nil {
+        logger.Fatalf("Server failed: %v", err)
     }
 }
 
-func cleanDatabase(db *Database, csvFile string, dryRun, verbose bool) error {
-    logger.Printf("Processing CSV file: %s", csvFile)
-    
-    if dryRun {
-        logger.Println("DRY RUN MODE - No changes will be made")
-    }
-    
-    // Open CSV file
-    file, err := os.Open(csvFile)
-    if err != nil {
-        return fmt.Errorf("failed to open CSV file: %w", err)
-    }
-    defer file.Close()
-    
-    // Parse CSV
-    reader := csv.NewReader(file)
-    
-    // Read header row
-    headers, err := reader.Read()
-    if err != nil {
-        return fmt.Errorf("failed to read CSV headers: %w", err)
+// parseSubcommand parses a subcommand's flags, allowing them to appear before,
+// after, or between positional arguments, and returns the positional arguments.
+// -h/--help prints the usage line and flag defaults.
+func parseSubcommand(fs *flag.FlagSet, usage string, args []string) []string {
+    fs.Usage = func() {
+        fmt.Fprintf(fs.Output(), "Usage: %s\n", usage)
+        fs.PrintDefaults()
     }
     
-    // Find column indices we care about
-    var (
-        emailIdx     = -1
-        frequencyIdx = -1
-        statusIdx    = -1
-    )
-    
-    for i, header := range headers {
-        switch header {
-        case "Email":
-            emailIdx = i
-        case "Frequency":
-            frequencyIdx = i
-        case "Payment Status":
-            statusIdx = i
-        }
-    }
-    
-    if emailIdx == -1 {
-        return fmt.Errorf("CSV missing required Email column")
-    }
-    
-    // Track active recurring members from CSV
-    activeMembers := make(map[string]bool)
-    
-    // Process each row
-    rowCount := 0
-    recurringCount := 0
-    
+    var positional []string
     for {
-        row, err := reader.Read()
-        if err != nil {
-            break // End of file
-        }
-        
-        rowCount++
-        
-        // Skip if not enough columns
-        if len(row) <= emailIdx {
-            continue
-        }
-        
-        email := strings.ToLower(strings.TrimSpace(row[emailIdx]))
-        if email == "" {
-            continue
-        }
-        
-        // Check if this is a recurring donation
-        frequency := ""
-        if frequencyIdx >= 0 && frequencyIdx < len(row) {
-            frequency = row[frequencyIdx]
-        }
+        // ExitOnError flag sets exit on a bad flag, so the error can be ignored
+        fs.Parse(args)
         
-        // Only process recurring donations (Monthly, Quarterly, Annual, etc.)
-        if frequency == "" || strings.ToLower(frequency) == "one-time" {
-            if verbose {
-                logger.Printf("Skipping one-time donation from %s", email)
-            }
-            continue
+        rest := fs.Args()
+        if len(rest) == 0 {
+            break
         }
         
-        // Check payment status
-        status := "active"
-        if statusIdx >= 0 && statusIdx < len(row) {
-            paymentStatus := strings.ToLower(row[statusIdx])
-            if strings.Contains(paymentStatus, "succeed") {
-                status = "active"
-            } else if strings.Contains(paymentStatus, "fail") || strings.Contains(paymentStatus, "cancel") {
-                status = "cancelled"
-            }
+        // Everything after a "--" terminator is positional
+        consumed := len(args) - len(rest)
+        if consumed > 0 && args[consumed-1] == "--" {
+            positional = append(positional, rest...)
+            break
         }
         
-        // Only track active recurring members
-        if status == "active" && frequency != "" {
-            activeMembers[email] = true
-            recurringCount++
-            
-            if verbose {
-                logger.Printf("Found active recurring member: %s (%s)", email, frequency)
-            }
-        }
+        positional = append(positional, rest[0])
+        args = rest[1:]
     }
     
-    logger.Printf("Processed %d rows, found %d active recurring members", rowCount, recurringCount)
+    return positional
+}
+
+// connectDatabase loads the configuration and opens the database for CLI
+// subcommands, logging to logger and exiting on failure
+func connectDatabase(logger *log.Logger) *Database {
+    config := mustLoadConfig(logger)
     
-    // Get current members from database
-    currentMembers, err := db.GetAllMemberStatuses()
+    // Connect to database
+    logger.Println("Connecting to database...")
+    db, err := NewDatabase(config.DatabaseURL, logger)
     if err != nil {
-        return fmt.Errorf("failed to get current members: %w", err)
-    }
-    
-    logger.Printf("Database currently has %d members", len(currentMembers))
-    
-    // Find members to update
-    toActivate := []string{}
-    toDeactivate := []string{}
-    
-    for email, dbStatus := range currentMembers {
-        if activeMembers[email] {
-            // Member is in CSV as active
-            if dbStatus != "active" {
-                toActivate = append(toActivate, email)
-            }
-        } else {
-            // Member is not in CSV (or not active)
-            if dbStatus == "active" {
-                toDeactivate = append(toDeactivate, email)
-            }
-        }
-    }
-    
-    // Find new members to add (in CSV but not in database)
-    toAdd := []string{}
-    for email := range activeMembers {
-        if _, exists := currentMembers[email]; !exists {
-            toAdd = append(toAdd, email)
-        }
-    }
-    
-    // Report what will change
-    logger.Printf("Changes to make:")
-    logger.Printf("  - New members to add: %d", len(toAdd))
-    logger.Printf("  - Members to reactivate: %d", len(toActivate))
-    logger.Printf("  - Members to deactivate: %d", len(toDeactivate))
-    
-    if verbose {
-        if len(toAdd) > 0 {
-            logger.Printf("  New members: %v", toAdd)
-        }
-        if len(toActivate) > 0 {
-            logger.Printf("  To activate: %v", toActivate)
-        }
-        if len(toDeactivate) > 0 {
-            logger.Printf("  To deactivate: %v", toDeactivate)
-        }
+        logger.Fatalf("Failed to connect to database: %v", err)
     }
-    
-    // Apply changes if not dry run
-    if !dryRun {
-        // Add new members
-        for _, email := range toAdd {
-            if err := db.ProcessMember(email, "", false, "active"); err != nil {
-                logger.Printf("Error adding member %s: %v", email, err)
-            } else if verbose {
-                logger.Printf("Added member: %s", email)
-            }
-        }
-        
-        // Activate members
-        for _, email := range toActivate {
-            if err := db.UpdateMemberStatus(email, "active"); err != nil {
-                logger.Printf("Error activating member %s: %v", email, err)
-            } else if verbose {
-                logger.Printf("Activated member: %s", email)
-            }
-        }
-        
-        // Deactivate members
-        for _, email := range toDeactivate {
-            if err := db.UpdateMemberStatus(email, "cancelled"); err != nil {
-                logger.Printf("Error deactivating member %s: %v", email, err)
-            } else if verbose {
-                logger.Printf("Deactivated member: %s", email)
-            }
-        }
-        
-        logger.Println("Database sync complete!")
-    } else {
-        logger.Println("DRY RUN complete - no changes made")
+    db.NormalizeEmails = config.NormalizeEmails
+    db.StrictTransitions = config.StrictTransitions
+    db.CountHouseholds = config.CountHouseholds
+    db.Privacy = config.Privacy
+    if err := db.CheckPrivacy(); err != nil {
+        logger.Fatalf("Privacy mode: %v", err)
     }
     
-    return nil
-}
-
-func getEnvOrDefault(key, defaultValue string) string {
-    if value := os.Getenv(key); value != "" {
-        return value
-    }
-    return defaultValue
+    return db
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "flag"
+    "os"
+    "os/exec"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseSubcommandFlagOrder(t *testing.T) {
+    tests := []struct {
+        args       []string
+        positional []string
+        dryRun     bool
+        graceDays  int
+    }{
+        {[]string{"export.csv", "--dry-run"}, []string{"export.csv"}, true, 0},
+        {[]string{"--dry-run", "export.csv"}, []string{"export.csv"}, true, 0},
+        {[]string{"--grace-days", "7", "export.csv", "--dry-run"}, []string{"export.csv"}, true, 7},
+        {[]string{"export.csv", "--grace-days=7"}, []string{"export.csv"}, false, 7},
+        {[]string{"-dry-run", "old@example.org", "--grace-days", "3", "new@example.org"}, []string{"old@example.org", "new@example.org"}, true, 3},
+        {[]string{"--dry-run", "--", "--not-a-flag.csv"}, []string{"--not-a-flag.csv"}, true, 0},
+        {[]string{"-"}, []string{"-"}, false, 0},
+        {[]string{"--dry-run"}, nil, true, 0},
+        {nil, nil, false, 0},
+    }
+    for _, tt := range tests {
+        fs := flag.NewFlagSet("clean", flag.ExitOnError)
+        dryRun := fs.Bool("dry-run", false, "")
+        graceDays := fs.Int("grace-days", 0, "")
+
+        positional := parseSubcommand(fs, "memberships clean <csv-file> [flags]", tt.args)
+        if !reflect.DeepEqual(positional, tt.positional) || *dryRun != tt.dryRun || *graceDays != tt.graceDays {
+            t.Errorf("%q: got %q, dry-run %v, grace-days %d", tt.args, positional, *dryRun, *graceDays)
+        }
+    }
+}
+
+// runCLI runs the memberships command with args in a child process, since
+// usage errors exit
+func runCLI(t *testing.T, args ...string) (stderr string, code int) {
+    t.Helper()
+    cmd := exec.Command(os.Args[0], "-test.run=^TestCLIHelper$")
+    cmd.Env = append(os.Environ(), "MEMBERSHIPS_CLI_ARGS="+strings.Join(args, "\n"), "DATABASE_URL=")
+    var out bytes.Buffer
+    cmd.Stderr = &out
+    err := cmd.Run()
+
+    var exit *exec.ExitError
+    if errors.As(err, &exit) {
+        return out.String(), exit.ExitCode()
+    } else if err != nil {
+        t.Fatal(err)
+    }
+    return out.String(), 0
+}
+
+// TestCLIHelper is the child process for runCLI
+func TestCLIHelper(t *testing.T) {
+    args, ok := os.LookupEnv("MEMBERSHIPS_CLI_ARGS")
+    if !ok {
+        t.Skip("only runs as runCLI's child process")
+    }
+    os.Args = append([]string{"memberships"}, strings.Split(args, "\n")...)
+    main()
+    os.Exit(0)
+}
+
+func TestCleanRequiresFile(t *testing.T) {
+    for _, args := range [][]string{{"clean"}, {"clean", "--dry-run"}, {"clean", "--grace-days", "7", "--verbose"}} {
+        stderr, code := runCLI(t, args...)
+        if code != 2 || !strings.Contains(stderr, "clean command requires a CSV filename") || !strings.Contains(stderr, "Usage: memberships clean") {
+            t.Errorf("%q: exit %d, stderr:\n%s", args, code, stderr)
+        }
+    }
+}
+
+func TestSubcommandHelp(t *testing.T) {
+    for _, args := range [][]string{{"clean", "-h"}, {"clean", "export.csv", "--help"}} {
+        stderr, code := runCLI(t, args...)
+        if code != 0 || !strings.Contains(stderr, "Usage: memberships clean") || !strings.Contains(stderr, "-dry-run") {
+            t.Errorf("%q: exit %d, stderr:\n%s", args, code, stderr)
+        }
+    }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "syscall"
+)
+
+// maintenanceKey is the settings row holding the maintenance flag
+const maintenanceKey = "maintenance_mode"
+
+// maintenanceRetryAfter is the Retry-After, in seconds, sent with a 503
+// during maintenance
+const maintenanceRetryAfter = 300
+
+// webhookStateDeferred marks a webhook received during maintenance; it is
+// queued for the retry worker once maintenance ends
+const webhookStateDeferred = "deferred"
+
+// MaintenanceMode reports whether maintenance mode is on
+func (db *Database) MaintenanceMode() (bool, error) {
+    var value string
+    err := db.QueryRow(`SELECT value FROM settings WHERE key = $1`, maintenanceKey).Scan(&value)
+    if err == sql.ErrNoRows {
+        return false, nil
+    } else if err != nil {
+        return false, fmt.Errorf("failed to read maintenance mode: %w", err)
+    }
+    return value == "true", nil
+}
+
+// SetMaintenanceMode turns maintenance mode on or off. Turning it off
+// queues the webhooks deferred meanwhile, in the same transaction.
+func (db *Database) SetMaintenanceMode(on bool) error {
+    return db.inTx(func(tx *sql.Tx) error {
+        _, err := tx.Exec(`
+            INSERT INTO settings (key, value) VALUES ($1, $2)
+            ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP
+        `, maintenanceKey, strconv.FormatBool(on))
+        if err != nil {
+            return fmt.Errorf("failed to set maintenance mode: %w", err)
+        }
+        if on {
+            return nil
+        }
+        return releaseDeferredWebhooks(tx)
+    })
+}
+
+// DeferWebhook marks a logged webhook to be processed after maintenance
+func (db *Database) DeferWebhook(logID int) error {
+    _, err := db.Exec(`UPDATE webhook_logs SET state = $2 WHERE id = $1`, logID, webhookStateDeferred)
+    if err != nil {
+        return fmt.Errorf("failed to defer webhook: %w", err)
+    }
+    return nil
+}
+
+// ReleaseDeferredWebhooks queues any deferred webhooks for the retry worker,
+// which takes them oldest first. It returns how many were queued.
+func (db *Database) ReleaseDeferredWebhooks() (int, error) {
+    var count int
+    err := db.inTx(func(tx *sql.Tx) error {
+        if err := tx.QueryRow(`SELECT COUNT(*) FROM webhook_logs WHERE state = $1`, webhookStateDeferred).Scan(&count); err != nil {
+            return fmt.Errorf("failed to count deferred webhooks: %w", err)
+        }
+        return releaseDeferredWebhooks(tx)
+    })
+    return count, err
+}
+
+func releaseDeferredWebhooks(q querier) error {
+    _, err := q.Exec(`
+        UPDATE webhook_logs SET state = $2, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+        WHERE state = $1
+    `, webhookStateDeferred, webhookStatePending)
+    if err != nil {
+        return fmt.Errorf("failed to release deferred webhooks: %w", err)
+    }
+    return nil
+}
+
+// setMaintenance persists and applies a maintenance mode change. Ending
+// maintenance sets the retry worker on the deferred webhooks right away.
+func (s *WebhookServer) setMaintenance(on bool) error {
+    if err := s.db.SetMaintenanceMode(on); err != nil {
+        return err
+    }
+    s.maintenance.Store(on)
+
+    if on {
+        s.logger.Printf("Maintenance mode on: webhooks are deferred, other endpoints return 503")
+    } else {
+        s.logger.Printf("Maintenance mode off: processing deferred webhooks")
+        // If the retry job is mid-run, its next run picks them up
+        if job := s.jobs.Get(jobWebhookRetry); job != nil {
+            if err := s.jobs.Start(job, jobTriggerMaintenance); err != nil && !errors.Is(err, ErrJobRunning) {
+                s.logger.Printf("Failed to start retry job: %v", err)
+            }
+        }
+    }
+    return nil
+}
+
+// loadMaintenance restores maintenance mode from the database at startup,
+// and handles SIGUSR1 by toggling it
+func (s *WebhookServer) loadMaintenance() error {
+    on, err := s.db.MaintenanceMode()
+    if err != nil {
+        return err
+    }
+    s.maintenance.Store(on)
+    if on {
+        s.logger.Printf("Starting in maintenance mode")
+    } else if count, err := s.db.ReleaseDeferredWebhooks(); err != nil {
+        s.logger.Printf("Warning: %v", err)
+    } else if count > 0 {
+        s.logger.Printf("Queued %d webhooks deferred during maintenance", count)
+    }
+
+    toggle := make(chan os.Signal, 1)
+    signal.Notify(toggle, syscall.SIGUSR1)
+    go func() {
+        for range toggle {
+            if err := s.setMaintenance(!s.maintenance.Load()); err != nil {
+                s.logger.Printf("Failed to toggle maintenance mode: %v", err)
+            }
+        }
+    }()
+
+    return nil
+}
+
+// maintenanceMiddleware answers 503 while in maintenance mode
+func (s *WebhookServer) maintenanceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if s.maintenance.Load() {
+            w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+            writeError(w, r, http.StatusServiceUnavailable, errUnavailable, "Down for maintenance, try again shortly")
+            return
+        }
+        next(w, r)
+    }
+}
+
+// maintenanceHandler serves GET and POST /admin/maintenance. POST takes
+// {"enabled": true|false}.
+func (s *WebhookServer) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method == http.MethodPost {
+        var req struct {
+            Enabled *bool `json:"enabled"`
+        }
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Enabled == nil {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"enabled\": true|false}")
+            return
+        }
+        if err := s.setMaintenance(*req.Enabled); err != nil {
+            s.logger.Printf("Error setting maintenance mode: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]bool{"enabled": s.maintenance.Load()})
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Member email kinds, which are also the template file names
+const (
+    memberEmailWelcome      = "welcome"
+    memberEmailCancellation = "cancellation"
+)
+
+// memberEmailKinds is every kind, in the order they're documented
+var memberEmailKinds = []string{memberEmailWelcome, memberEmailCancellation}
+
+// Member email states
+const (
+    memberEmailPending    = "pending"
+    memberEmailSent       = "sent"
+    memberEmailFailed     = "failed"
+    memberEmailSuppressed = "suppressed"
+)
+
+// Member email worker tuning; failed sends back off like webhook retries
+const (
+    memberEmailPollInterval = time.Minute
+    memberEmailMaxAttempts  = 6
+    memberEmailBatchSize    = 20
+)
+
+// defaultPublicURL is where the unsubscribe links in member emails point
+// unless PUBLIC_URL says otherwise
+const defaultPublicURL = "https://memberships.operatorfoundation.org"
+
+// defaultMemberEmailTemplates are used for any kind without a file in
+// MEMBER_EMAIL_TEMPLATES. The first line is the subject.
+var defaultMemberEmailTemplates = map[string]string{
+    memberEmailWelcome: `Subject: Welcome to Operator Foundation
+
+Hi{{if .Name}} {{.Name}}{{end}},
+
+Thank you for becoming a member of Operator Foundation. Your support keeps
+our work free and open for everyone who needs it.
+
+If you have any questions about your membership, just reply to this email.
+{{if .UnsubscribeURL}}
+To stop receiving email from us: {{.UnsubscribeURL}}
+{{end}}`,
+    memberEmailCancellation: `Subject: Sorry to see you go
+
+Hi{{if .Name}} {{.Name}}{{end}},
+
+Your Operator Foundation membership has been cancelled. Thank you for the
+support you've given us; it made a real difference.
+
+If that wasn't intended, or you'd like to rejoin, just reply to this email.
+{{if .UnsubscribeURL}}
+To stop receiving email from us: {{.UnsubscribeURL}}
+{{end}}`,
+}
+
+// memberEmailTemplate is a parsed template: a subject line and a body
+type memberEmailTemplate struct {
+    subject *template.Template
+    body    *template.Template
+}
+
+// memberEmailData is what the templates can use
+type memberEmailData struct {
+    Name           string
+    Email          string
+    UnsubscribeURL string
+}
+
+// parseMemberEmailTemplate reads a "Subject: ..." line, a blank line, and
+// the body
+func parseMemberEmailTemplate(kind, text string) (*memberEmailTemplate, error) {
+    first, body, _ := strings.Cut(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+    subject, ok := strings.CutPrefix(first, "Subject:")
+    if !ok {
+        return nil, fmt.Errorf("%s template must start with a Subject: line", kind)
+    }
+
+    t := &memberEmailTemplate{}
+    var err error
+    if t.subject, err = template.New(kind + " subject").Option("missingkey=error").Parse(strings.TrimSpace(subject)); err != nil {
+        return nil, fmt.Errorf("%s template subject: %w", kind, err)
+    }
+    if t.body, err = template.New(kind).Option("missingkey=error").Parse(strings.TrimLeft(body, "\n")); err != nil {
+        return nil, fmt.Errorf("%s template: %w", kind, err)
+    }
+    return t, nil
+}
+
+// loadMemberEmailTemplates parses each kind's template from <kind>.txt in
+// dir, falling back to the built-in default when dir is empty or has no
+// file for it
+func loadMemberEmailTemplates(dir string) (map[string]*memberEmailTemplate, error) {
+    templates := make(map[string]*memberEmailTemplate, len(memberEmailKinds))
+    for _, kind := range memberEmailKinds {
+        text := defaultMemberEmailTemplates[kind]
+        if dir != "" {
+            data, err := os.ReadFile(filepath.Join(dir, kind+".txt"))
+            switch {
+            case err == nil:
+                text = string(data)
+            case !errors.Is(err, os.ErrNotExist):
+                return nil, err
+            }
+        }
+
+        t, err := parseMemberEmailTemplate(kind, text)
+        if err != nil {
+            return nil, err
+        }
+        templates[kind] = t
+    }
+    return templates, nil
+}
+
+// render fills in the template for one member
+func (t *memberEmailTemplate) render(data memberEmailData) (subject, body string, err error) {
+    var b bytes.Buffer
+    if err := t.subject.Execute(&b, data); err != nil {
+        return "", "", err
+    }
+    subject = b.String()
+
+    b.Reset()
+    if err := t.body.Execute(&b, data); err != nil {
+        return "", "", err
+    }
+    return subject, b.String(), nil
+}
+
+// MemberEmail is a queued email with the member's current details
+type MemberEmail struct {
+    ID       int
+    Kind     string
+    Attempts int
+
+    // Address is where it goes; empty when only the member's key is stored
+    Address          string
+    Name             string
+    IsAnonymous      bool
+    OptedOut         bool
+    UnsubscribeToken string
+}
+
+// suppressed is why the email shouldn't go to this member, or "" if it
+// should. It's checked at send time, so an opt-out recorded after the email
+// was queued still counts.
+func (e MemberEmail) suppressed() string {
+    switch {
+    case e.IsAnonymous:
+        return "member is anonymous"
+    case e.OptedOut:
+        return "member opted out of email"
+    case e.Address == "":
+        return "no deliverable address stored"
+    }
+    return ""
+}
+
+// templateData is what the member's email is filled in with; links point
+// under publicURL
+func (e MemberEmail) templateData(publicURL string) memberEmailData {
+    data := memberEmailData{Name: e.Name, Email: e.Address}
+    if e.UnsubscribeToken != "" {
+        data.UnsubscribeURL = publicURL + "/unsubscribe/" + e.UnsubscribeToken
+    }
+    return data
+}
+
+// memberEmailFor reports which email, if any, a webhook's result calls for,
+// and the transition it's sent once for. Members get one welcome, on
+// joining; cancellations are keyed by day so a status that flaps sends one.
+func memberEmailFor(result *ProcessResult, now time.Time) (kind, transition string, ok bool) {
+    switch {
+    case result.Action == actionCreated && result.Status == StatusActive:
+        return memberEmailWelcome, "joined", true
+    case result.Action == actionUpdated && result.Status == StatusCancelled && result.PreviousStatus != StatusCancelled:
+        return memberEmailCancellation, now.In(displayZone).Format("2006-01-02"), true
+    }
+    return "", "", false
+}
+
+// QueueMemberEmail queues an email of kind for the member with this email,
+// once per transition. queued is false when it had been queued before.
+func (db *Database) QueueMemberEmail(email, kind, transition string) (queued bool, err error) {
+    res, err := db.Exec(`
+        INSERT INTO member_emails (member_id, kind, transition, next_attempt_at)
+        SELECT id, $2, $3, CURRENT_TIMESTAMP FROM members WHERE email = $1
+        ON CONFLICT (member_id, kind, transition) DO NOTHING
+    `, db.NormalizeEmail(email), kind, transition)
+    if err != nil {
+        return false, fmt.Errorf("failed to queue %s email: %w", kind, err)
+    }
+    n, _ := res.RowsAffected()
+    return n > 0, nil
+}
+
+// DueMemberEmails returns pending member emails whose next attempt is due.
+// In encrypt privacy mode addresses are decrypted; a member with only a key
+// stored has no Address.
+func (db *Database) DueMemberEmails(limit int) ([]MemberEmail, error) {
+    rows, err := db.Query(`
+        SELECT e.id, e.kind, e.attempts, COALESCE(m.raw_email, m.email), COALESCE(m.email_ciphertext, ''),
+               COALESCE(m.name, ''), COALESCE(m.is_anonymous, false), m.email_opt_in IS FALSE,
+               COALESCE(m.unsubscribe_token::text, '')
+        FROM member_emails e
+        JOIN members m ON m.id = e.member_id
+        WHERE e.state = $1 AND e.next_attempt_at <= CURRENT_TIMESTAMP
+        ORDER BY e.id
+        LIMIT $2
+    `, memberEmailPending, limit)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load member emails: %w", err)
+    }
+    defer rows.Close()
+
+    var emails []MemberEmail
+    for rows.Next() {
+        var e MemberEmail
+        var ciphertext string
+        if err := rows.Scan(&e.ID, &e.Kind, &e.Attempts, &e.Address, &ciphertext,
+            &e.Name, &e.IsAnonymous, &e.OptedOut, &e.UnsubscribeToken); err != nil {
+            return nil, err
+        }
+        if ciphertext != "" && db.Privacy != nil && db.Privacy.CanDecrypt() {
+            if e.Address, err = db.Privacy.Open(ciphertext); err != nil {
+                return nil, err
+            }
+        }
+        if isEmailKey(e.Address) {
+            e.Address = ""
+        }
+        emails = append(emails, e)
+    }
+    return emails, rows.Err()
+}
+
+// RecordMemberEmailAttempt records one attempt at a member email. A pending
+// state retries at next; cause is why it failed or was suppressed.
+func (db *Database) RecordMemberEmailAttempt(id int, state string, next time.Time, cause string) error {
+    var nextAttempt interface{}
+    if state == memberEmailPending {
+        nextAttempt = next
+    }
+    _, err := db.Exec(`
+        UPDATE member_emails SET
+            state = $2,
+            attempts = attempts + 1,
+            next_attempt_at = $3,
+            last_error = NULLIF($4, ''),
+            sent_at = CASE WHEN $2 = 'sent' THEN CURRENT_TIMESTAMP END
+        WHERE id = $1
+    `, id, state, nextAttempt, cause)
+    if err != nil {
+        return fmt.Errorf("failed to record member email: %w", err)
+    }
+    return nil
+}
+
+// MemberMailer emails members when they join and when they cancel. Emails
+// are queued in member_emails and sent by a background worker, so a
+// webhook never waits on, or fails because of, the mail server.
+type MemberMailer struct {
+    db        Store
+    mailer    *Mailer
+    templates map[string]*memberEmailTemplate
+    enabled   map[string]bool
+    publicURL string
+    logger    *log.Logger
+
+    // wake starts a send as soon as something is queued
+    wake chan struct{}
+}
+
+// NewMemberMailer returns a mailer for the enabled member emails, or nil
+// when none are enabled
+func NewMemberMailer(db Store, config *Config, logger *log.Logger) (*MemberMailer, error) {
+    enabled := map[string]bool{
+        memberEmailWelcome:      config.MemberEmailWelcome,
+        memberEmailCancellation: config.MemberEmailCancellation,
+    }
+    if !enabled[memberEmailWelcome] && !enabled[memberEmailCancellation] {
+        return nil, nil
+    }
+
+    mailer, err := NewMailer(config)
+    if err != nil {
+        return nil, err
+    }
+    templates, err := loadMemberEmailTemplates(config.MemberEmailTemplates)
+    if err != nil {
+        return nil, err
+    }
+
+    return &MemberMailer{
+        db:        db,
+        mailer:    mailer,
+        templates: templates,
+        enabled:   enabled,
+        publicURL: config.PublicURL,
+        logger:    logger,
+        wake:      make(chan struct{}, 1),
+    }, nil
+}
+
+// Queue queues the email, if any, a webhook's result calls for. It never
+// fails the caller; errors are only logged. A nil MemberMailer does nothing.
+func (m *MemberMailer) Queue(result *ProcessResult) {
+    if m == nil {
+        return
+    }
+    kind, transition, ok := memberEmailFor(result, time.Now())
+    if !ok || !m.enabled[kind] {
+        return
+    }
+
+    queued, err := m.db.QueueMemberEmail(result.Email, kind, transition)
+    if err != nil {
+        m.logger.Printf("Warning: %v", err)
+        return
+    }
+    if queued {
+        select {
+        case m.wake <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// sendDue sends every member email whose attempt is due, and returns how
+// many it attempted
+func (m *MemberMailer) sendDue() (int, error) {
+    attempted := 0
+    for {
+        due, err := m.db.DueMemberEmails(memberEmailBatchSize)
+        if err != nil {
+            return attempted, err
+        }
+
+        for _, e := range due {
+            if err := m.send(e); err != nil {
+                return attempted, err
+            }
+            attempted++
+        }
+
+        if len(due) < memberEmailBatchSize {
+            return attempted, nil
+        }
+    }
+}
+
+// send makes one attempt at a queued email and records how it went
+func (m *MemberMailer) send(e MemberEmail) error {
+    state, next, cause := memberEmailSent, time.Time{}, ""
+
+    if reason := e.suppressed(); reason != "" {
+        state, cause = memberEmailSuppressed, reason
+    } else if subject, body, err := m.templates[e.Kind].render(e.templateData(m.publicURL)); err != nil {
+        state, cause = memberEmailFailed, err.Error()
+    } else if err := m.mailer.Send([]string{e.Address}, subject, body); err != nil {
+        cause = err.Error()
+        state, next = memberEmailPending, time.Now().Add(retryDelay(e.Attempts))
+        if e.Attempts+1 >= memberEmailMaxAttempts {
+            state = memberEmailFailed
+        }
+    }
+
+    switch state {
+    case memberEmailSent:
+        m.logger.Printf("Sent %s email %d to %s", e.Kind, e.ID, e.Address)
+    case memberEmailSuppressed:
+        m.logger.Printf("Not sending %s email %d: %s", e.Kind, e.ID, cause)
+    case memberEmailPending:
+        m.logger.Printf("Failed to send %s email %d (attempt %d), retrying at %s: %s",
+            e.Kind, e.ID, e.Attempts+1, next.Format(time.RFC3339), cause)
+    case memberEmailFailed:
+        m.logger.Printf("Member %s email %d FAILED permanently: %s", e.Kind, e.ID, cause)
+    }
+
+    return m.db.RecordMemberEmailAttempt(e.ID, state, next, cause)
+}
+
+// memberEmailJob sends queued member emails in the background, as soon as
+// one is queued. Pending rows live in member_emails, so anything queued
+// before a restart is sent.
+func (s *WebhookServer) memberEmailJob() *Job {
+    mailer, err := NewMemberMailer(s.db, s.config, s.logger)
+    if err != nil {
+        s.logger.Printf("Member emails disabled: %v", err)
+        return nil
+    }
+    if mailer == nil {
+        return nil
+    }
+    s.memberMail = mailer
+
+    return &Job{
+        Name:     jobMemberEmails,
+        Interval: memberEmailPollInterval,
+        Schedule: "every " + formatInterval(memberEmailPollInterval) + " and when an email is queued",
+        AtStart:  true,
+        wake:     mailer.wake,
+        run: func() (string, error) {
+            attempted, err := mailer.sendDue()
+            if attempted == 0 {
+                return "", err
+            }
+            return fmt.Sprintf("%d emails attempted", attempted), err
+        },
+    }
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/lib/pq"
+)
+
+// ErrAnonymousName is returned when a name is set on an anonymous member
+var ErrAnonymousName = errors.New("anonymous members can't have a name")
+
+// MemberUpdate is a partial edit of a member's details; nil fields are left
+// unchanged
+type MemberUpdate struct {
+    Name        *string
+    IsAnonymous *bool
+    Notes       *string
+    Tags        []string
+    DiscordID   *string
+    Protected   *bool
+    EmailOptIn  *bool
+}
+
+// memberFields are the editable details of a member, as stored
+type memberFields struct {
+    Name        sql.NullString
+    IsAnonymous bool
+    Notes       sql.NullString
+    Tags        []string
+    DiscordID   sql.NullString
+    EmailOptIn  sql.NullBool
+}
+
+// nullable returns a nullable column as a JSON value
+func nullable(s sql.NullString) interface{} {
+    if !s.Valid {
+        return nil
+    }
+    return s.String
+}
+
+// nullableBool returns a nullable boolean column as a JSON value
+func nullableBool(b sql.NullBool) interface{} {
+    if !b.Valid {
+        return nil
+    }
+    return b.Bool
+}
+
+// optionalString stores an empty value as NULL
+func optionalString(s string) sql.NullString {
+    s = strings.TrimSpace(s)
+    return sql.NullString{String: s, Valid: s != ""}
+}
+
+// apply returns the fields with the update made. Making a member anonymous
+// clears their name.
+func (f memberFields) apply(update MemberUpdate) (memberFields, error) {
+    if update.IsAnonymous != nil {
+        f.IsAnonymous = *update.IsAnonymous
+        if f.IsAnonymous {
+            f.Name = sql.NullString{}
+        }
+    }
+    if update.Name != nil {
+        name := optionalString(*update.Name)
+        if name.Valid && f.IsAnonymous {
+            return f, ErrAnonymousName
+        }
+        f.Name = name
+    }
+    if update.Notes != nil {
+        f.Notes = sql.NullString{String: *update.Notes, Valid: true}
+    }
+    if update.Tags != nil {
+        f.Tags = normalizeTags(update.Tags)
+    }
+    if update.Protected != nil {
+        tags := []string{}
+        for _, tag := range f.Tags {
+            if tag != ProtectedTag {
+                tags = append(tags, tag)
+            }
+        }
+        if *update.Protected {
+            tags = append(tags, ProtectedTag)
+        }
+        f.Tags = tags
+    }
+    if update.DiscordID != nil {
+        f.DiscordID = optionalString(*update.DiscordID)
+    }
+    if update.EmailOptIn != nil {
+        f.EmailOptIn = sql.NullBool{Bool: *update.EmailOptIn, Valid: true}
+    }
+    return f, nil
+}
+
+// changes lists each field that differs from after, with both values
+func (f memberFields) changes(after memberFields) map[string]interface{} {
+    changes := map[string]interface{}{}
+    diff := func(field string, before, after interface{}) {
+        changes[field] = map[string]interface{}{"before": before, "after": after}
+    }
+    if f.Name != after.Name {
+        diff("name", nullable(f.Name), nullable(after.Name))
+    }
+    if f.IsAnonymous != after.IsAnonymous {
+        diff("is_anonymous", f.IsAnonymous, after.IsAnonymous)
+    }
+    if f.Notes != after.Notes {
+        diff("notes", nullable(f.Notes), nullable(after.Notes))
+    }
+    if strings.Join(f.Tags, "\x00") != strings.Join(after.Tags, "\x00") {
+        diff("tags", f.Tags, after.Tags)
+    }
+    if f.DiscordID != after.DiscordID {
+        diff("discord_id", nullable(f.DiscordID), nullable(after.DiscordID))
+    }
+    if f.EmailOptIn != after.EmailOptIn {
+        diff("email_opt_in", nullableBool(f.EmailOptIn), nullableBool(after.EmailOptIn))
+    }
+    return changes
+}
+
+// UpdateMemberFields edits a member's name, anonymity, notes, tags,
+// protection, Discord link, and email consent in one transaction, recording
+// a member.updated event with the before and after values of whatever
+// changed
+func (db *Database) UpdateMemberFields(email string, update MemberUpdate) error {
+    email = db.NormalizeEmail(email)
+
+    return db.inTx(func(tx *sql.Tx) error {
+        var memberID int
+        var before memberFields
+        err := tx.QueryRow(`
+            SELECT id, name, is_anonymous, notes, tags, discord_id, email_opt_in
+            FROM members WHERE email = $1
+            FOR UPDATE
+        `, email).Scan(&memberID, &before.Name, &before.IsAnonymous, &before.Notes, pq.Array(&before.Tags), &before.DiscordID,
+            &before.EmailOptIn)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        } else if err != nil {
+            return fmt.Errorf("failed to load member: %w", err)
+        }
+        if before.Tags == nil {
+            before.Tags = []string{}
+        }
+
+        after, err := before.apply(update)
+        if err != nil {
+            return err
+        }
+        changes := before.changes(after)
+        if len(changes) == 0 {
+            return nil
+        }
+
+        _, err = tx.Exec(`
+            UPDATE members SET name = $2, is_anonymous = $3, notes = $4, tags = $5, discord_id = $6,
+                email_opt_in = $7,
+                consent_recorded_at = CASE WHEN email_opt_in IS DISTINCT FROM $7 THEN CURRENT_TIMESTAMP ELSE consent_recorded_at END,
+                consent_source = CASE WHEN email_opt_in IS DISTINCT FROM $7 THEN 'manual' ELSE consent_source END,
+                last_updated = CURRENT_TIMESTAMP
+            WHERE id = $1
+        `, memberID, after.Name, after.IsAnonymous, after.Notes, pq.Array(after.Tags), after.DiscordID, after.EmailOptIn)
+        if err != nil {
+            return fmt.Errorf("failed to update member: %w", err)
+        }
+
+        return recordEvent(tx, feedMemberUpdated, memberID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":   email,
+            "changes": changes,
+        })
+    })
+}
+
+// memberPatch is the partial document accepted by PATCH /members/{email}
+type memberPatch struct {
+    Name        *string  `json:"name"`
+    IsAnonymous *bool    `json:"is_anonymous"`
+    Notes       *string  `json:"notes"`
+    Tags        []string `json:"tags"`
+    Protected   *bool    `json:"protected"`
+    DiscordID   *string  `json:"discord_id"`
+    EmailOptIn  *bool    `json:"email_opt_in"`
+}
+
+// patchMemberHandler edits a member's details. Only the fields present
+// change; the email is the member's key and changes only by merging.
+func (s *WebhookServer) patchMemberHandler(w http.ResponseWriter, r *http.Request) {
+    email := r.PathValue("email")
+
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+    var fields map[string]json.RawMessage
+    var patch memberPatch
+    if err != nil || json.Unmarshal(body, &fields) != nil || json.Unmarshal(body, &patch) != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid JSON")
+        return
+    }
+    if _, ok := fields["email"]; ok {
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload,
+            "email can't be changed here; to move a member to a new address, add it and POST /members/merge")
+        return
+    }
+
+    if patch.DiscordID != nil && *patch.DiscordID != "" && !validDiscordID(*patch.DiscordID) {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "discord_id must be a numeric Discord user ID")
+        return
+    }
+
+    err = s.db.UpdateMemberFields(email, MemberUpdate{
+        Name:        patch.Name,
+        IsAnonymous: patch.IsAnonymous,
+        Notes:       patch.Notes,
+        Tags:        patch.Tags,
+        DiscordID:   patch.DiscordID,
+        Protected:   patch.Protected,
+        EmailOptIn:  patch.EmailOptIn,
+    })
+    if err != nil {
+        switch {
+        case errors.Is(err, ErrMemberNotFound):
+            writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+        case errors.Is(err, ErrAnonymousName):
+            writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, "Anonymous members can't have a name; set is_anonymous to false to name them")
+        default:
+            s.logger.Printf("Error updating member: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        }
+        return
+    }
+
+    member, err := s.db.GetMemberByEmail(email)
+    s.writeMember(w, r, member, err)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// isUUID reports whether s is a canonical 8-4-4-4-12 hex UUID
+func isUUID(s string) bool {
+    if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+        return false
+    }
+    _, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+    return err == nil
+}
+
+// GetMemberByPublicID retrieves a member by their public UUID
+func (db *Database) GetMemberByPublicID(publicID string) (*Member, error) {
+    var email string
+    err := db.QueryRow(`SELECT email FROM members WHERE public_id = $1`, strings.ToLower(publicID)).Scan(&email)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, publicID)
+    } else if err != nil {
+        return nil, fmt.Errorf("database error: %w", err)
+    }
+
+    return db.GetMemberByEmail(email)
+}
+
+// GetPublicID returns a member's public UUID, or "" if there is no such member
+func (db *Database) GetPublicID(email string) (string, error) {
+    var publicID string
+    err := db.QueryRow(`SELECT public_id FROM members WHERE email = $1`, db.NormalizeEmail(email)).Scan(&publicID)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return publicID, err
+}
+
+// memberResponse is the legacy (unversioned) API representation of a
+// member; see apiMember for /v1
+func memberResponse(m *Member) map[string]interface{} {
+    response := map[string]interface{}{
+        "id":           m.PublicID,
+        "email":        m.Email,
+        "status":       m.Status,
+        "is_anonymous": m.IsAnonymous,
+        "tags":         m.Tags,
+        "first_seen":   m.FirstSeen,
+        "last_updated": m.LastUpdated,
+    }
+
+    if !m.IsAnonymous && m.Name.Valid {
+        response["name"] = m.Name.String
+    }
+    if m.Frequency.Valid && m.Frequency.String != "" {
+        response["frequency"] = m.Frequency.String
+    }
+    if m.Campaign.Valid && m.Campaign.String != "" {
+        response["campaign"] = m.Campaign.String
+    }
+    if m.FirstPaymentAt.Valid {
+        response["first_payment_at"] = m.FirstPaymentAt.Time
+    }
+    if m.LastPaymentAt.Valid {
+        response["last_payment_at"] = m.LastPaymentAt.Time
+    }
+    if m.Notes.Valid && m.Notes.String != "" {
+        response["notes"] = m.Notes.String
+    }
+    if m.DiscordID.Valid && m.DiscordID.String != "" {
+        response["discord_id"] = m.DiscordID.String
+    }
+    response["email_opt_in"] = nullableBool(m.EmailOptIn)
+    if m.ConsentRecordedAt.Valid {
+        response["consent_recorded_at"] = m.ConsentRecordedAt.Time
+        response["consent_source"] = m.ConsentSource.String
+    }
+
+    // Show the address as the donor entered it when it differs from the key
+    if m.RawEmail.Valid && m.RawEmail.String != "" && m.RawEmail.String != m.Email {
+        response["raw_email"] = m.RawEmail.String
+    }
+
+    return response
+}
+
+// memberDonationsLimit is how many recent donations the member detail
+// endpoints include; the giving totals cover all of them
+const memberDonationsLimit = 100
+
+// writeMember responds with a member and their giving history, or 404 when
+// the lookup found none
+func (s *WebhookServer) writeMember(w http.ResponseWriter, r *http.Request, member *Member, err error) {
+    if err != nil {
+        if errors.Is(err, ErrMemberNotFound) {
+            writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+            return
+        }
+        s.logger.Printf("Error getting member: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    donations, err := s.db.GetDonations(member.Email, memberDonationsLimit)
+    var totals []DonationTotal
+    if err == nil {
+        totals, err = s.db.GetDonationTotals(member.Email)
+    }
+    if err != nil {
+        s.logger.Printf("Error getting donations: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    apiDonations := []apiDonation{}
+    for _, d := range donations {
+        apiDonations = append(apiDonations, newAPIDonation(d))
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if versionOf(r) == apiLegacy {
+        response := memberResponse(member)
+        response["giving"] = totals
+        response["donations"] = apiDonations
+        json.NewEncoder(w).Encode(response)
+        return
+    }
+    response := newAPIMember(member)
+    response.Giving = totals
+    response.Donations = apiDonations
+    json.NewEncoder(w).Encode(response)
+}
+
+// getMemberHandler returns one member by email
+func (s *WebhookServer) getMemberHandler(w http.ResponseWriter, r *http.Request) {
+    member, err := s.db.GetMemberByEmail(r.PathValue("email"))
+    s.writeMember(w, r, member, err)
+}
+
+// getMemberByIDHandler returns one member by public UUID
+func (s *WebhookServer) getMemberByIDHandler(w http.ResponseWriter, r *http.Request) {
+    publicID := r.PathValue("id")
+    if !isUUID(publicID) {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid member id")
+        return
+    }
+
+    member, err := s.db.GetMemberByPublicID(publicID)
+    s.writeMember(w, r, member, err)
+}
+
+// memberHistoryHandler returns a member's status history with the source and
+// detail of each change, newest first
+func (s *WebhookServer) memberHistoryHandler(w http.ResponseWriter, r *http.Request) {
+    email := r.PathValue("email")
+    if _, existed, err := s.db.GetMemberStatus(email); err != nil {
+        s.logger.Printf("Error getting member: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    } else if !existed {
+        writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+        return
+    }
+
+    history, err := s.db.GetStatusHistory(email, 100)
+    if err != nil {
+        s.logger.Printf("Error getting status history: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    if history == nil {
+        history = []StatusChange{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(history)
+}
+
+// sanitizeFilename keeps letters, digits, dashes, and underscores
+func sanitizeFilename(s string) string {
+    return strings.Map(func(r rune) rune {
+        if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+            return r
+        }
+        return '_'
+    }, s)
+}
@@ -0,0 +1,2791 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// errNotInMemory is returned by the few Store methods memStore leaves to
+// Postgres: reports that are one large query, and organizations
+var errNotInMemory = errors.New("not supported by the in-memory store")
+
+// memStore is a thread-safe, in-memory Store for tests. It follows the
+// Postgres implementation's rules (email normalization, stale events,
+// transitions, households, the retry queue, pending changes) closely enough
+// that handlers, clean, and the reconciler can be exercised without a
+// database. Every method takes the lock, so it's safe to share between a
+// test server's goroutines.
+type memStore struct {
+    mu sync.Mutex
+
+    // As on Database
+    NormalizeEmails   bool
+    StrictTransitions bool
+    CountHouseholds   bool
+    Events            *EventHub
+
+    // Healthy, if set, is returned by HealthCheck
+    Healthy error
+
+    logger *log.Logger
+    memState
+}
+
+// memState is everything memStore holds. Writes that Postgres makes in one
+// transaction run through atomically, which restores a copy on error.
+type memState struct {
+    ids map[string]int
+
+    members        map[int]*memMember
+    history        []memHistory
+    events         []memEvent
+    webhookLogs    []WebhookLogEntry
+    failures       []FailedWebhook
+    unmapped       map[string]UnmappedStatus
+    donations      []memDonation
+    syncRuns       []SyncRun
+    syncChanges    []memSyncChange
+    pending        []PendingChange
+    memberEmails   []memMemberEmail
+    settings       map[string]string
+    jobRuns        []JobRun
+    jobLocks       map[string]bool
+    snapshots      map[string]StatsSnapshot
+    subscriptions  []Subscription
+    accessLogs     []AccessLogEntry
+}
+
+// memMember is a members row, with the columns Member doesn't carry
+type memMember struct {
+    Member
+    emailHash      string
+    frequencyRaw   string
+    lastEventAt    time.Time
+    lastReceivedAt time.Time
+    household      int
+    failedPayments int
+}
+
+// derived reports whether the member follows another member's household
+func (m *memMember) derived() bool {
+    return m.household != 0 && m.household != m.ID
+}
+
+type memHistory struct {
+    memberID int
+    StatusChange
+}
+
+type memEvent struct {
+    FeedEvent
+    memberID int
+}
+
+type memDonation struct {
+    Donation
+    id         int
+    memberID   int
+    refundedAt *time.Time
+}
+
+type memSyncChange struct {
+    runID    int
+    memberID int
+    email    string
+    before   string
+    after    string
+}
+
+type memMemberEmail struct {
+    id          int
+    memberID    int
+    kind        string
+    transition  string
+    state       string
+    attempts    int
+    nextAttempt time.Time
+    lastError   string
+}
+
+// newMemStore returns an empty in-memory store that logs to the test log
+func newMemStore() *memStore {
+    return &memStore{
+        logger: log.New(testLogWriter{}, "", 0),
+        memState: memState{
+            ids:       map[string]int{},
+            members:   map[int]*memMember{},
+            unmapped:  map[string]UnmappedStatus{},
+            settings:  map[string]string{},
+            jobLocks:  map[string]bool{},
+            snapshots: map[string]StatsSnapshot{},
+        },
+    }
+}
+
+// testLogWriter discards log output; tests assert on results, not logs
+type testLogWriter struct{}
+
+func (testLogWriter) Write(p []byte) (int, error) {
+    return len(p), nil
+}
+
+// clone copies the state deeply enough to restore it after a failed write
+func (s memState) clone() memState {
+    c := s
+    c.ids = make(map[string]int, len(s.ids))
+    for k, v := range s.ids {
+        c.ids[k] = v
+    }
+    c.members = make(map[int]*memMember, len(s.members))
+    for id, m := range s.members {
+        copied := *m
+        copied.Tags = append([]string(nil), m.Tags...)
+        c.members[id] = &copied
+    }
+    c.history = append([]memHistory(nil), s.history...)
+    c.events = append([]memEvent(nil), s.events...)
+    c.webhookLogs = append([]WebhookLogEntry(nil), s.webhookLogs...)
+    c.failures = append([]FailedWebhook(nil), s.failures...)
+    c.unmapped = make(map[string]UnmappedStatus, len(s.unmapped))
+    for k, v := range s.unmapped {
+        c.unmapped[k] = v
+    }
+    c.donations = append([]memDonation(nil), s.donations...)
+    c.syncRuns = append([]SyncRun(nil), s.syncRuns...)
+    c.syncChanges = append([]memSyncChange(nil), s.syncChanges...)
+    c.pending = append([]PendingChange(nil), s.pending...)
+    c.memberEmails = append([]memMemberEmail(nil), s.memberEmails...)
+    c.settings = make(map[string]string, len(s.settings))
+    for k, v := range s.settings {
+        c.settings[k] = v
+    }
+    c.jobRuns = append([]JobRun(nil), s.jobRuns...)
+    c.snapshots = make(map[string]StatsSnapshot, len(s.snapshots))
+    for k, v := range s.snapshots {
+        c.snapshots[k] = v
+    }
+    c.subscriptions = append([]Subscription(nil), s.subscriptions...)
+    c.accessLogs = append([]AccessLogEntry(nil), s.accessLogs...)
+    return c
+}
+
+// atomically runs fn, which must hold the lock, rolling the state back if
+// it fails and waking the event hub if it doesn't
+func (s *memStore) atomically(fn func() error) error {
+    saved := s.memState.clone()
+    if err := fn(); err != nil {
+        s.memState = saved
+        return err
+    }
+    s.Events.Publish()
+    return nil
+}
+
+// newID returns the next id for a table
+func (s *memStore) newID(table string) int {
+    s.ids[table]++
+    return s.ids[table]
+}
+
+// newUUID returns a random UUID, as Postgres generates public ids and tokens
+func newUUID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    h := hex.EncodeToString(b)
+    return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// member returns the member with a normalized email, or nil
+func (s *memStore) member(email string) *memMember {
+    for _, m := range s.members {
+        if m.Email == email {
+            return m
+        }
+    }
+    return nil
+}
+
+// sortedMembers returns every member by id
+func (s *memStore) sortedMembers() []*memMember {
+    members := make([]*memMember, 0, len(s.members))
+    for _, m := range s.members {
+        members = append(members, m)
+    }
+    sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+    return members
+}
+
+func (s *memStore) checkTransition(email, from, to string, change ChangeSource) error {
+    err := transitionError(email, from, to, change)
+    if err == nil || !errors.Is(err, ErrInvalidTransition) || s.StrictTransitions {
+        return err
+    }
+    s.logger.Printf("Warning: %v; allowing it (STATUS_TRANSITIONS=warn)", err)
+    return nil
+}
+
+func (s *memStore) recordStatusHistory(memberID int, status string, change ChangeSource) {
+    source := change.Source
+    if source == "" {
+        source = "unknown"
+    }
+    s.history = append(s.history, memHistory{memberID: memberID, StatusChange: StatusChange{
+        Status: status, Source: source, Detail: change.Detail, ChangedAt: time.Now(),
+    }})
+}
+
+func (s *memStore) recordEvent(eventType string, memberID int, change ChangeSource, payload map[string]interface{}) {
+    e := memEvent{memberID: memberID, FeedEvent: FeedEvent{
+        ID: int64(s.newID("events")), Type: eventType, Source: change.Source, Detail: change.Detail, CreatedAt: time.Now(),
+    }}
+    if e.Source == "" {
+        e.Source = "unknown"
+    }
+    if payload != nil {
+        e.Payload, _ = json.Marshal(payload)
+    }
+    s.events = append(s.events, e)
+}
+
+func (s *memStore) recordStatusEvent(memberID int, email, previous, status string, change ChangeSource) {
+    s.recordEvent(feedStatusChanged, memberID, change, map[string]interface{}{
+        "email":      email,
+        "old_status": previous,
+        "new_status": status,
+    })
+}
+
+// followHousehold brings the members linked to primaryID in line with it
+func (s *memStore) followHousehold(primaryID int) {
+    primary := s.members[primaryID]
+    if primary == nil {
+        return
+    }
+    for _, m := range s.sortedMembers() {
+        if m.household != primaryID || m.ID == primaryID || m.Status == primary.Status {
+            continue
+        }
+        change := ChangeSource{Source: householdSource, Detail: fmt.Sprintf("follows member #%d", primaryID)}
+        before := m.Status
+        m.Status = primary.Status
+        m.LastUpdated = time.Now()
+        s.recordStatusHistory(m.ID, m.Status, change)
+        s.recordStatusEvent(m.ID, m.Email, before, m.Status, change)
+    }
+}
+
+func (s *memStore) HealthCheck() error {
+    return s.Healthy
+}
+
+func (s *memStore) NormalizeEmail(email string) string {
+    return normalizeEmail(email, s.NormalizeEmails)
+}
+
+func (s *memStore) EventHub() *EventHub {
+    return s.Events
+}
+
+func (s *memStore) decideMember(email string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, *memMember, error) {
+    rawEmail := strings.TrimSpace(email)
+    email = s.NormalizeEmail(email)
+    if email == "" {
+        return nil, nil, fmt.Errorf("email is required")
+    }
+    if !isEmailKey(rawEmail) {
+        if err := validateEmail(rawEmail); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    result := &ProcessResult{Email: email, IsAnonymous: isAnonymous, Status: status}
+    m := s.member(email)
+    if m == nil {
+        result.Action = actionCreated
+        return result, nil, nil
+    }
+
+    result.MemberID = m.PublicID
+    result.PreviousStatus = m.Status
+    if !change.EventTime.IsZero() && !m.lastEventAt.IsZero() && change.EventTime.Before(m.lastEventAt) {
+        return nil, nil, fmt.Errorf("%w: %s event for %s from %s predates the last one applied (%s)", ErrStaleEvent,
+            status, email, change.EventTime.UTC().Format(time.RFC3339), m.lastEventAt.UTC().Format(time.RFC3339))
+    }
+    if staleRetry(change, m.lastReceivedAt) {
+        return nil, nil, fmt.Errorf("%w: retried %s delivery for %s received %s predates the last one applied (%s)", ErrStaleEvent,
+            status, email, change.ReceivedAt.UTC().Format(time.RFC3339), m.lastReceivedAt.UTC().Format(time.RFC3339))
+    }
+    if m.derived() && change.Source != householdSource && status != m.Status {
+        result.Status = m.Status
+    }
+    if err := s.checkTransition(email, m.Status, result.Status, change); err != nil {
+        return nil, nil, err
+    }
+
+    result.Action = actionUnchanged
+    if m.Status != result.Status {
+        result.Action = actionUpdated
+    }
+    return result, m, nil
+}
+
+func (s *memStore) processMember(email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    result, m, err := s.decideMember(email, isAnonymous, status, change)
+    if err != nil {
+        return nil, err
+    }
+    if isAnonymous {
+        name = ""
+    }
+    now := time.Now()
+
+    if result.Action == actionCreated {
+        m = &memMember{Member: Member{
+            ID:               s.newID("members"),
+            PublicID:         newUUID(),
+            Email:            result.Email,
+            RawEmail:         sql.NullString{String: strings.TrimSpace(email), Valid: true},
+            Name:             sql.NullString{String: name, Valid: true},
+            IsAnonymous:      isAnonymous,
+            Status:           result.Status,
+            Tags:             []string{},
+            FirstSeen:        now,
+            LastUpdated:      now,
+            UnsubscribeToken: newUUID(),
+        }, emailHash: emailHash(result.Email), lastEventAt: change.EventTime, lastReceivedAt: change.ReceivedAt}
+        s.members[m.ID] = m
+        result.MemberID = m.PublicID
+
+        s.recordStatusHistory(m.ID, m.Status, change)
+        s.recordEvent(feedMemberCreated, m.ID, change, map[string]interface{}{
+            "email":  m.Email,
+            "status": m.Status,
+        })
+        return result, nil
+    }
+
+    if !isAnonymous && name != "" {
+        m.Name = sql.NullString{String: name, Valid: true}
+    }
+    m.IsAnonymous = isAnonymous
+    m.Status = result.Status
+    if m.emailHash == "" {
+        m.emailHash = emailHash(m.Email)
+    }
+    if change.EventTime.After(m.lastEventAt) {
+        m.lastEventAt = change.EventTime
+    }
+    if change.ReceivedAt.After(m.lastReceivedAt) {
+        m.lastReceivedAt = change.ReceivedAt
+    }
+    m.LastUpdated = now
+
+    if result.Action == actionUpdated {
+        s.recordStatusHistory(m.ID, m.Status, change)
+        s.recordStatusEvent(m.ID, m.Email, result.PreviousStatus, m.Status, change)
+        s.followHousehold(m.ID)
+    }
+    return result, nil
+}
+
+func (s *memStore) ProcessMember(email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var result *ProcessResult
+    err := s.atomically(func() error {
+        var err error
+        result, err = s.processMember(email, name, isAnonymous, status, change)
+        return err
+    })
+    return result, err
+}
+
+func (s *memStore) PreviewMember(email string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result, _, err := s.decideMember(email, isAnonymous, status, change)
+    return result, err
+}
+
+func (s *memStore) updateMemberStatus(email, status string, change ChangeSource) error {
+    email = s.NormalizeEmail(email)
+    m := s.member(email)
+    if m == nil {
+        return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    }
+    if m.derived() && change.Source != householdSource {
+        return fmt.Errorf("%w: %s follows member #%d; unlink it to change its status", ErrHouseholdMember, email, m.household)
+    }
+    if err := s.checkTransition(email, m.Status, status, change); err != nil {
+        return err
+    }
+
+    before := m.Status
+    m.Status = status
+    m.LastUpdated = time.Now()
+    s.recordStatusHistory(m.ID, status, change)
+    if before != status {
+        s.recordStatusEvent(m.ID, email, before, status, change)
+        s.followHousehold(m.ID)
+    }
+    return nil
+}
+
+func (s *memStore) UpdateMemberStatus(email, status string, change ChangeSource) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.atomically(func() error {
+        return s.updateMemberStatus(email, status, change)
+    })
+}
+
+// bulkUpdateStatus is Database.bulkUpdateStatus. Every email must exist.
+func (s *memStore) bulkUpdateStatus(emails []string, status string, change ChangeSource) ([]bulkChange, error) {
+    var changes []bulkChange
+    for _, email := range emails {
+        email = s.NormalizeEmail(email)
+        m := s.member(email)
+        if m == nil {
+            return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        }
+        if m.derived() {
+            return nil, fmt.Errorf("%w: %s follows member #%d", ErrHouseholdMember, m.Email, m.household)
+        }
+        if err := s.checkTransition(m.Email, m.Status, status, change); err != nil {
+            return nil, err
+        }
+        changes = append(changes, bulkChange{MemberID: m.ID, Email: m.Email, Before: m.Status})
+    }
+
+    for _, c := range changes {
+        m := s.members[c.MemberID]
+        m.Status = status
+        m.LastUpdated = time.Now()
+        s.recordStatusHistory(m.ID, status, change)
+        if c.Before != status {
+            s.recordStatusEvent(m.ID, m.Email, c.Before, status, change)
+        }
+        s.followHousehold(m.ID)
+    }
+    return changes, nil
+}
+
+func (s *memStore) SetMemberStatuses(emails []string, status string, change ChangeSource) ([]BulkStatusResult, error) {
+    if !validStatus(status) {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var results []BulkStatusResult
+    err := s.atomically(func() error {
+        results = []BulkStatusResult{}
+        seen := map[string]bool{}
+        var changing []string
+        for _, email := range emails {
+            email = s.NormalizeEmail(email)
+            if seen[email] {
+                continue
+            }
+            seen[email] = true
+
+            m := s.member(email)
+            switch {
+            case m == nil:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkNotFound})
+            case m.Status == status:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkUnchanged, PreviousStatus: m.Status})
+            case m.derived():
+                results = append(results, BulkStatusResult{Email: email, Result: bulkHousehold, PreviousStatus: m.Status})
+            default:
+                results = append(results, BulkStatusResult{Email: email, Result: bulkUpdated, PreviousStatus: m.Status})
+                changing = append(changing, email)
+            }
+        }
+        _, err := s.bulkUpdateStatus(changing, status, change)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return results, nil
+}
+
+func (s *memStore) GetMemberStatus(email string) (string, bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if m := s.member(s.NormalizeEmail(email)); m != nil {
+        return m.Status, true, nil
+    }
+    return "", false, nil
+}
+
+func (s *memStore) GetMemberStatusByHash(hash string) (string, bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, m := range s.members {
+        if m.emailHash == hash {
+            return m.Status, true, nil
+        }
+    }
+    return "", false, nil
+}
+
+// memberCopy returns the member as GetMemberByEmail loads it
+func memberCopy(m *memMember) *Member {
+    copied := m.Member
+    copied.Tags = append([]string{}, m.Tags...)
+    return &copied
+}
+
+func (s *memStore) GetMemberByEmail(email string) (*Member, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    email = s.NormalizeEmail(email)
+    m := s.member(email)
+    if m == nil {
+        return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    }
+    return memberCopy(m), nil
+}
+
+func (s *memStore) GetMemberByPublicID(publicID string) (*Member, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, m := range s.members {
+        if m.PublicID == strings.ToLower(publicID) {
+            return memberCopy(m), nil
+        }
+    }
+    return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, publicID)
+}
+
+func (s *memStore) GetPublicID(email string) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if m := s.member(s.NormalizeEmail(email)); m != nil {
+        return m.PublicID, nil
+    }
+    return "", nil
+}
+
+func (s *memStore) GetMembers(filter MemberFilter) ([]Member, error) {
+    var members []Member
+    err := s.EachMember(filter, func(m Member) error {
+        members = append(members, m)
+        return nil
+    })
+    return members, err
+}
+
+// EachMember is Database.EachMember. Notes and Discord IDs aren't loaded,
+// and no member belongs to an organization.
+func (s *memStore) EachMember(filter MemberFilter, fn func(Member) error) error {
+    s.mu.Lock()
+    var matched []Member
+    for _, m := range s.members {
+        switch {
+        case filter.Status != "" && m.Status != filter.Status:
+            continue
+        case filter.Tag != "" && !containsTag(m.Tags, normalizeTag(filter.Tag)):
+            continue
+        case filter.Frequency != "" && m.Frequency.String != filter.Frequency:
+            continue
+        case strings.EqualFold(filter.Campaign, campaignUnknown) && m.Campaign.String != "":
+            continue
+        case filter.Campaign != "" && !strings.EqualFold(filter.Campaign, campaignUnknown) &&
+            !strings.EqualFold(m.Campaign.String, strings.TrimSpace(filter.Campaign)):
+            continue
+        }
+        member := *memberCopy(m)
+        member.ID = 0
+        member.Notes = sql.NullString{}
+        member.DiscordID = sql.NullString{}
+        matched = append(matched, member)
+    }
+    s.mu.Unlock()
+
+    sort.Slice(matched, func(i, j int) bool {
+        if !matched[i].LastUpdated.Equal(matched[j].LastUpdated) {
+            return matched[i].LastUpdated.After(matched[j].LastUpdated)
+        }
+        return matched[i].PublicID < matched[j].PublicID
+    })
+    matched = matched[min(filter.Offset, len(matched)):]
+    if filter.Limit > 0 {
+        matched = matched[:min(filter.Limit, len(matched))]
+    }
+
+    for _, m := range matched {
+        if err := fn(m); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *memStore) GetSyncMembers() ([]SyncMember, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var members []SyncMember
+    for _, m := range s.sortedMembers() {
+        members = append(members, SyncMember{
+            Email:       m.Email,
+            Name:        m.Name.String,
+            IsAnonymous: m.IsAnonymous,
+            Status:      m.Status,
+            DiscordID:   m.DiscordID.String,
+            OptedOut:    m.EmailOptIn.Valid && !m.EmailOptIn.Bool,
+        })
+    }
+    sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+    return members, nil
+}
+
+func (s *memStore) GetStatusHistory(email string, limit int) ([]StatusChange, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    m := s.member(s.NormalizeEmail(email))
+    if m == nil {
+        return nil, nil
+    }
+    var history []StatusChange
+    for i := len(s.history) - 1; i >= 0 && len(history) < limit; i-- {
+        if s.history[i].memberID == m.ID {
+            history = append(history, s.history[i].StatusChange)
+        }
+    }
+    return history, nil
+}
+
+// editMember runs fn on a member inside atomically, failing with
+// ErrMemberNotFound when there's no such member
+func (s *memStore) editMember(email string, fn func(m *memMember) error) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    email = s.NormalizeEmail(email)
+    return s.atomically(func() error {
+        m := s.member(email)
+        if m == nil {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        }
+        return fn(m)
+    })
+}
+
+func (s *memStore) UpdateMemberAnnotations(email string, notes *string, tags []string) error {
+    if tags != nil {
+        tags = normalizeTags(tags)
+    }
+    var fields []string
+    if notes != nil {
+        fields = append(fields, "notes")
+    }
+    if tags != nil {
+        fields = append(fields, "tags")
+    }
+
+    return s.editMember(email, func(m *memMember) error {
+        if notes != nil {
+            m.Notes = sql.NullString{String: *notes, Valid: true}
+        }
+        if tags != nil {
+            m.Tags = tags
+        }
+        s.recordEvent(feedMemberUpdated, m.ID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":  m.Email,
+            "fields": fields,
+        })
+        return nil
+    })
+}
+
+func (s *memStore) UpdateMemberFields(email string, update MemberUpdate) error {
+    return s.editMember(email, func(m *memMember) error {
+        before := memberFields{
+            Name:        m.Name,
+            IsAnonymous: m.IsAnonymous,
+            Notes:       m.Notes,
+            Tags:        append([]string{}, m.Tags...),
+            DiscordID:   m.DiscordID,
+            EmailOptIn:  m.EmailOptIn,
+        }
+        after, err := before.apply(update)
+        if err != nil {
+            return err
+        }
+        changes := before.changes(after)
+        if len(changes) == 0 {
+            return nil
+        }
+
+        if m.EmailOptIn != after.EmailOptIn {
+            m.ConsentRecordedAt = sql.NullTime{Time: time.Now(), Valid: true}
+            m.ConsentSource = sql.NullString{String: "manual", Valid: true}
+        }
+        m.Name, m.IsAnonymous, m.Notes, m.Tags, m.DiscordID, m.EmailOptIn =
+            after.Name, after.IsAnonymous, after.Notes, after.Tags, after.DiscordID, after.EmailOptIn
+        m.LastUpdated = time.Now()
+
+        s.recordEvent(feedMemberUpdated, m.ID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":   m.Email,
+            "changes": changes,
+        })
+        return nil
+    })
+}
+
+func (s *memStore) AddMemberTag(email, tag string) error {
+    tag = normalizeTag(tag)
+    if tag == "" {
+        return fmt.Errorf("tag is required")
+    }
+    return s.editMember(email, func(m *memMember) error {
+        if !containsTag(m.Tags, tag) {
+            m.Tags = append(m.Tags, tag)
+        }
+        s.recordEvent(feedMemberUpdated, m.ID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":     m.Email,
+            "tag_added": tag,
+        })
+        return nil
+    })
+}
+
+func (s *memStore) SetEmailConsent(email string, optIn bool, change ChangeSource) (bool, error) {
+    changed := false
+    err := s.editMember(email, func(m *memMember) error {
+        if m.EmailOptIn.Valid && m.EmailOptIn.Bool == optIn {
+            return nil
+        }
+        var previous interface{}
+        if m.EmailOptIn.Valid {
+            previous = m.EmailOptIn.Bool
+        }
+        m.EmailOptIn = sql.NullBool{Bool: optIn, Valid: true}
+        m.ConsentRecordedAt = sql.NullTime{Time: time.Now(), Valid: true}
+        m.ConsentSource = sql.NullString{String: change.Source, Valid: true}
+        m.LastUpdated = time.Now()
+        changed = true
+
+        s.recordEvent(feedMemberUpdated, m.ID, change, map[string]interface{}{
+            "email": m.Email,
+            "changes": map[string]interface{}{
+                "email_opt_in": map[string]interface{}{"before": previous, "after": optIn},
+            },
+        })
+        return nil
+    })
+    return changed, err
+}
+
+func (s *memStore) Unsubscribe(token string) error {
+    if !isUUID(token) {
+        return fmt.Errorf("%w: invalid unsubscribe token", ErrMemberNotFound)
+    }
+
+    s.mu.Lock()
+    email := ""
+    for _, m := range s.members {
+        if m.UnsubscribeToken == strings.ToLower(token) {
+            email = m.Email
+        }
+    }
+    s.mu.Unlock()
+
+    if email == "" {
+        return fmt.Errorf("%w: unknown unsubscribe token", ErrMemberNotFound)
+    }
+    _, err := s.SetEmailConsent(email, false, ChangeSource{Source: "unsubscribe"})
+    return err
+}
+
+func (s *memStore) IsProtected(email string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    m := s.member(s.NormalizeEmail(email))
+    return m != nil && containsTag(m.Tags, ProtectedTag), nil
+}
+
+func (s *memStore) setMemberFrequency(email, frequency string) {
+    if m := s.member(s.NormalizeEmail(email)); m != nil {
+        normalized := normalizeFrequency(frequency)
+        m.Frequency = sql.NullString{String: normalized, Valid: normalized != ""}
+        m.frequencyRaw = strings.TrimSpace(frequency)
+    }
+}
+
+func (s *memStore) SetMemberFrequency(email, frequency string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.setMemberFrequency(email, frequency)
+    return nil
+}
+
+func (s *memStore) setMemberCampaign(email, campaign string) {
+    campaign = strings.TrimSpace(campaign)
+    if m := s.member(s.NormalizeEmail(email)); m != nil && campaign != "" && !m.Campaign.Valid {
+        m.Campaign = sql.NullString{String: campaign, Valid: true}
+    }
+}
+
+func (s *memStore) SetMemberCampaign(email, campaign string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.setMemberCampaign(email, campaign)
+    return nil
+}
+
+func (s *memStore) SetDiscordID(email, discordID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    email = s.NormalizeEmail(email)
+    m := s.member(email)
+    if m == nil {
+        return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    }
+    m.DiscordID = optionalString(discordID)
+    return nil
+}
+
+func (s *memStore) MergeMembers(fromEmail, toEmail string) (*MergeResult, error) {
+    return s.mergeMembers(fromEmail, toEmail, false)
+}
+
+func (s *memStore) PreviewMerge(fromEmail, toEmail string) (*MergeResult, error) {
+    return s.mergeMembers(fromEmail, toEmail, true)
+}
+
+// mergeMembers is Database.mergeMembers
+func (s *memStore) mergeMembers(fromEmail, toEmail string, dryRun bool) (*MergeResult, error) {
+    fromEmail = s.NormalizeEmail(fromEmail)
+    toEmail = s.NormalizeEmail(toEmail)
+    if fromEmail == "" || toEmail == "" {
+        return nil, fmt.Errorf("%w: both emails are required", ErrInvalidMerge)
+    }
+    if fromEmail == toEmail {
+        return nil, fmt.Errorf("%w: cannot merge %s into itself", ErrInvalidMerge, fromEmail)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var result *MergeResult
+    saved := s.memState.clone()
+    err := s.atomically(func() error {
+        from, to := s.member(fromEmail), s.member(toEmail)
+        if from == nil {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, fromEmail)
+        }
+        if to == nil {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, toEmail)
+        }
+
+        result = &MergeResult{
+            FromEmail:   fromEmail,
+            ToEmail:     toEmail,
+            FromID:      from.ID,
+            ToID:        to.ID,
+            FromStatus:  from.Status,
+            ToStatus:    to.Status,
+            FinalStatus: to.Status,
+            FirstSeen:   to.FirstSeen,
+            DryRun:      dryRun,
+        }
+        if from.Status != to.Status && from.LastUpdated.After(to.LastUpdated) {
+            result.FinalStatus = from.Status
+        }
+
+        campaign := to.Campaign
+        if from.FirstSeen.Before(to.FirstSeen) {
+            result.FirstSeen = from.FirstSeen
+            if from.Campaign.Valid {
+                campaign = from.Campaign
+            }
+        } else if !campaign.Valid {
+            campaign = from.Campaign
+        }
+
+        for i := range s.history {
+            if s.history[i].memberID == from.ID {
+                s.history[i].memberID = to.ID
+                result.HistoryMoved++
+            }
+        }
+        for i := range s.events {
+            if s.events[i].memberID == from.ID {
+                s.events[i].memberID = to.ID
+            }
+        }
+        for i := range s.donations {
+            if s.donations[i].memberID == from.ID {
+                s.donations[i].memberID = to.ID
+            }
+        }
+        for i := range s.memberEmails {
+            e := &s.memberEmails[i]
+            if e.memberID != from.ID {
+                continue
+            }
+            kept := false
+            for _, other := range s.memberEmails {
+                if other.memberID == to.ID && other.kind == e.kind && other.transition == e.transition {
+                    kept = true
+                }
+            }
+            if !kept {
+                e.memberID = to.ID
+            }
+        }
+
+        if !to.IsAnonymous && to.Name.String == "" {
+            to.Name = from.Name
+        }
+        if to.DiscordID.String == "" {
+            to.DiscordID = from.DiscordID
+        }
+        to.Tags = mergeTags(to.Tags, from.Tags)
+        to.Notes = mergeNotes(to.Notes, from.Notes)
+        to.FirstPaymentAt = earlierTime(to.FirstPaymentAt, from.FirstPaymentAt)
+        to.LastPaymentAt = laterTime(to.LastPaymentAt, from.LastPaymentAt)
+        to.failedPayments = mergeFailedPayments(to.failedPayments, from.failedPayments, to.Status, from.Status, result.FinalStatus)
+        to.Status = result.FinalStatus
+        to.FirstSeen = result.FirstSeen
+        to.Campaign = campaign
+        to.LastUpdated = time.Now()
+
+        change := ChangeSource{Source: "merge", Detail: "merged from " + fromEmail}
+        if result.FinalStatus != result.ToStatus {
+            s.recordStatusHistory(to.ID, result.FinalStatus, change)
+        }
+
+        primaryID := to.ID
+        if to.household != 0 {
+            primaryID = to.household
+        }
+        moved := false
+        for _, m := range s.members {
+            if m.household == from.ID && m.ID != from.ID && m.ID != primaryID {
+                m.household = primaryID
+                moved = true
+            }
+        }
+        if moved && s.members[primaryID].household == 0 {
+            s.members[primaryID].household = primaryID
+        }
+        s.followHousehold(primaryID)
+
+        s.recordEvent(feedMemberMerged, to.ID, change, map[string]interface{}{
+            "from_email":   fromEmail,
+            "to_email":     toEmail,
+            "from_status":  result.FromStatus,
+            "to_status":    result.ToStatus,
+            "final_status": result.FinalStatus,
+        })
+        delete(s.members, from.ID)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    if dryRun {
+        s.memState = saved
+    }
+    return result, nil
+}
+
+func (s *memStore) ForgetMember(email string) (*ForgetResult, error) {
+    email = strings.TrimSpace(email)
+    key := s.NormalizeEmail(email)
+    if key == "" {
+        return nil, fmt.Errorf("email is required")
+    }
+    placeholder, err := forgottenPlaceholder(key)
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result := &ForgetResult{OriginalEmail: email, Placeholder: placeholder}
+    err = s.atomically(func() error {
+        m := s.member(key)
+        if m == nil {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, key)
+        }
+        result.MemberID = m.ID
+        result.NameCleared = m.Name.String != ""
+
+        addresses := forgetAddresses(email, key, normalizeEmail(email, s.NormalizeEmails), m.RawEmail.String)
+        mentioned := func(value string) bool {
+            for _, address := range addresses {
+                if strings.ToLower(value) == address {
+                    return true
+                }
+            }
+            return false
+        }
+
+        m.Email = placeholder
+        m.emailHash = ""
+        m.RawEmail = sql.NullString{}
+        m.Name = sql.NullString{}
+        m.IsAnonymous = true
+        m.Notes = sql.NullString{}
+        m.DiscordID = sql.NullString{}
+        m.LastUpdated = time.Now()
+
+        for i := range s.webhookLogs {
+            entry := &s.webhookLogs[i]
+            var payload map[string]interface{}
+            json.Unmarshal(entry.Payload, &payload)
+            payloadEmail, _ := payload["email"].(string)
+            if !mentioned(entry.Email) && !mentioned(payloadEmail) {
+                continue
+            }
+            entry.Email = placeholder
+            entry.Payload = nil
+            if payload != nil {
+                delete(payload, "email")
+                delete(payload, "name")
+                payload["redacted"] = true
+                entry.Payload, _ = json.Marshal(payload)
+            }
+            result.WebhookLogsRedacted++
+        }
+        for i := range s.failures {
+            for _, address := range addresses {
+                if strings.Contains(strings.ToLower(s.failures[i].Body), address) {
+                    s.failures[i].Body = `{"redacted":true}`
+                    break
+                }
+            }
+        }
+
+        pending := s.pending[:0]
+        for _, c := range s.pending {
+            if !mentioned(c.Email) {
+                pending = append(pending, c)
+            }
+        }
+        s.pending = pending
+
+        for i := range s.syncChanges {
+            if s.syncChanges[i].memberID == m.ID || mentioned(s.syncChanges[i].email) {
+                s.syncChanges[i].email = placeholder
+            }
+        }
+        for i := range s.memberEmails {
+            if s.memberEmails[i].memberID == m.ID {
+                s.memberEmails[i].lastError = ""
+            }
+        }
+
+        pattern := regexp.MustCompile("(?i)" + addressPattern(addresses))
+        for i := range s.accessLogs {
+            entry := &s.accessLogs[i]
+            if !pattern.MatchString(entry.Path) && !pattern.MatchString(entry.Query) {
+                continue
+            }
+            entry.Path = pattern.ReplaceAllLiteralString(entry.Path, placeholder)
+            entry.Query = pattern.ReplaceAllLiteralString(entry.Query, placeholder)
+            result.AccessLogsRedacted++
+        }
+
+        for i := range s.events {
+            e := &s.events[i]
+            var payload map[string]interface{}
+            if json.Unmarshal(e.Payload, &payload) != nil || payload == nil {
+                continue
+            }
+            emailOf := func(field string) string {
+                value, _ := payload[field].(string)
+                return value
+            }
+            if e.memberID != m.ID && !mentioned(emailOf("email")) && !mentioned(emailOf("from_email")) && !mentioned(emailOf("to_email")) {
+                continue
+            }
+            delete(payload, "email")
+            delete(payload, "from_email")
+            delete(payload, "to_email")
+            e.Payload, _ = json.Marshal(payload)
+        }
+
+        anyAddress := regexp.MustCompile(emailInTextPattern)
+        redactDetail := func(memberID int, detail string) string {
+            if memberID == m.ID {
+                return anyAddress.ReplaceAllLiteralString(detail, placeholder)
+            }
+            return pattern.ReplaceAllLiteralString(detail, placeholder)
+        }
+        for i := range s.history {
+            s.history[i].Detail = redactDetail(s.history[i].memberID, s.history[i].Detail)
+        }
+        for i := range s.events {
+            s.events[i].Detail = redactDetail(s.events[i].memberID, s.events[i].Detail)
+        }
+
+        s.recordEvent(feedMemberForgotten, m.ID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "webhook_logs_redacted": result.WebhookLogsRedacted,
+            "access_logs_redacted":  result.AccessLogsRedacted,
+        })
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+func (s *memStore) SubjectAccess(ref string, includeNotes bool) (*SubjectAccessExport, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) LapseMembers(graceDays int, dryRun bool) ([]LapseCandidate, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) LinkHousehold(primaryEmail, secondaryEmail, name, by string) (*HouseholdLink, error) {
+    primary := s.NormalizeEmail(primaryEmail)
+    secondary := s.NormalizeEmail(secondaryEmail)
+    if primary == secondary {
+        return nil, fmt.Errorf("%w: a member can't be linked to themselves", ErrHouseholdLink)
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    link := &HouseholdLink{Primary: primary, Secondary: secondary}
+    err := s.atomically(func() error {
+        p := s.member(primary)
+        if p == nil {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, primary)
+        }
+        if p.derived() {
+            return fmt.Errorf("%w: %s is itself linked to member #%d's household", ErrHouseholdLink, primary, p.household)
+        }
+        change := ChangeSource{Source: householdSource, Detail: fmt.Sprintf("linked to member #%d by %s", p.ID, by)}
+
+        m := s.member(secondary)
+        if m == nil {
+            if _, err := s.processMember(secondaryEmail, name, false, p.Status, change); err != nil {
+                return err
+            }
+            link.Created = true
+            m = s.member(secondary)
+        }
+
+        switch {
+        case m.household == p.ID:
+            link.Status = m.Status
+            link.Unchanged = true
+            return nil
+        case m.household != 0 && !m.derived():
+            return fmt.Errorf("%w: %s is the primary of its own household; unlink its members first", ErrHouseholdLink, secondary)
+        case m.household != 0:
+            return fmt.Errorf("%w: %s is already linked to member #%d's household; unlink it first", ErrHouseholdLink, secondary, m.household)
+        }
+
+        if p.household == 0 {
+            p.household = p.ID
+        }
+        m.household = p.ID
+        m.LastUpdated = time.Now()
+        s.recordEvent(feedMemberUpdated, m.ID, change, map[string]interface{}{
+            "email": secondary,
+            "changes": map[string]interface{}{
+                "household": map[string]interface{}{"before": nil, "after": primary},
+            },
+        })
+
+        link.Status = p.Status
+        if m.Status != p.Status {
+            before := m.Status
+            m.Status = p.Status
+            s.recordStatusHistory(m.ID, m.Status, change)
+            s.recordStatusEvent(m.ID, secondary, before, m.Status, change)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return link, nil
+}
+
+func (s *memStore) UnlinkHousehold(email, by string) (*HouseholdLink, error) {
+    email = s.NormalizeEmail(email)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    link := &HouseholdLink{Secondary: email, Status: StatusCancelled}
+    err := s.atomically(func() error {
+        m := s.member(email)
+        if m == nil {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        }
+        if m.household == 0 {
+            return fmt.Errorf("%w: %s isn't linked to a household", ErrHouseholdLink, email)
+        }
+        if !m.derived() {
+            return fmt.Errorf("%w: %s is a household's primary member; unlink the others instead", ErrHouseholdLink, email)
+        }
+        primary := s.members[m.household]
+        link.Primary = primary.Email
+        change := ChangeSource{Source: householdSource, Detail: fmt.Sprintf("unlinked from member #%d by %s", primary.ID, by)}
+
+        m.household = 0
+        m.LastUpdated = time.Now()
+        alone := true
+        for _, other := range s.members {
+            if other.household == primary.ID && other.ID != primary.ID {
+                alone = false
+            }
+        }
+        if alone {
+            primary.household = 0
+        }
+
+        s.recordEvent(feedMemberUpdated, m.ID, change, map[string]interface{}{
+            "email": email,
+            "changes": map[string]interface{}{
+                "household": map[string]interface{}{"before": link.Primary, "after": nil},
+            },
+        })
+        if m.Status != StatusCancelled {
+            before := m.Status
+            m.Status = StatusCancelled
+            s.recordStatusHistory(m.ID, m.Status, change)
+            s.recordStatusEvent(m.ID, email, before, m.Status, change)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return link, nil
+}
+
+func (s *memStore) HouseholdPrimary(email string) (string, bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    m := s.member(s.NormalizeEmail(email))
+    if m == nil || !m.derived() {
+        return "", false, nil
+    }
+    return s.members[m.household].Email, true, nil
+}
+
+func (s *memStore) CreateOrganization(name, tier, status, by string) (*Organization, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) SetOrganizationStatus(ref, status string, change ChangeSource) (*OrganizationChange, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) AttachOrganizationMember(ref, email, name, by string) (*OrganizationChange, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) DetachOrganizationMember(ref, email, by string) (*OrganizationChange, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) ProcessOrganization(name, email, contactName, status string, change ChangeSource) (*OrganizationChange, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) GetOrganization(ref string) (*Organization, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) GetOrganizations() ([]Organization, error) {
+    return []Organization{}, nil
+}
+
+func (s *memStore) MemberOrganization(email string, statuses []string) (string, bool, error) {
+    return "", false, nil
+}
+
+func (s *memStore) MemberOrganizationByHash(hash string, statuses []string) (string, bool, error) {
+    return "", false, nil
+}
+
+func (s *memStore) recordPayment(email string, paidAt time.Time) {
+    m := s.member(s.NormalizeEmail(email))
+    if m == nil {
+        return
+    }
+    if !m.FirstPaymentAt.Valid || paidAt.Before(m.FirstPaymentAt.Time) {
+        m.FirstPaymentAt = sql.NullTime{Time: paidAt, Valid: true}
+    }
+    if !m.LastPaymentAt.Valid || paidAt.After(m.LastPaymentAt.Time) {
+        m.LastPaymentAt = sql.NullTime{Time: paidAt, Valid: true}
+    }
+    m.failedPayments = 0
+}
+
+func (s *memStore) RecordPayment(email string, paidAt time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.recordPayment(email, paidAt)
+    return nil
+}
+
+func (s *memStore) FailedPaymentCount(email string) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if m := s.member(s.NormalizeEmail(email)); m != nil {
+        return m.failedPayments, nil
+    }
+    return 0, nil
+}
+
+func (s *memStore) ProcessFailedPayment(email, name string, isAnonymous bool, limit int, change ChangeSource) (*ProcessResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var result *ProcessResult
+    err := s.atomically(func() error {
+        failures, previous := 1, ""
+        if m := s.member(s.NormalizeEmail(email)); m != nil {
+            m.failedPayments++
+            failures, previous = m.failedPayments, m.Status
+        }
+
+        var err error
+        result, err = s.processMember(email, name, isAnonymous, failedPaymentStatus(previous, failures, limit), change)
+        if err != nil {
+            return err
+        }
+        s.member(result.Email).failedPayments = failures
+        return nil
+    })
+    return result, err
+}
+
+// recordDonation is Database.recordDonation
+func (s *memStore) recordDonation(d Donation) bool {
+    email := s.NormalizeEmail(d.Email)
+    if d.ExternalID == "" {
+        d.ExternalID = donationKey(d.Source, email, d.AmountCents, d.OccurredAt)
+    }
+    m := s.member(email)
+    if m == nil {
+        return false
+    }
+    for _, existing := range s.donations {
+        if existing.ExternalID == d.ExternalID {
+            return false
+        }
+    }
+
+    d.Email = email
+    d.Currency = normalizeCurrency(d.Currency)
+    d.Campaign = strings.TrimSpace(d.Campaign)
+    s.donations = append(s.donations, memDonation{Donation: d, id: s.newID("donations"), memberID: m.ID})
+    return true
+}
+
+func (s *memStore) RecordDonation(d Donation) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.recordDonation(d)
+    return nil
+}
+
+// memberDonations returns a member's donations, newest first
+func (s *memStore) memberDonations(memberID int) []memDonation {
+    var donations []memDonation
+    for _, d := range s.donations {
+        if d.memberID == memberID {
+            donations = append(donations, d)
+        }
+    }
+    sort.Slice(donations, func(i, j int) bool {
+        if !donations[i].OccurredAt.Equal(donations[j].OccurredAt) {
+            return donations[i].OccurredAt.After(donations[j].OccurredAt)
+        }
+        return donations[i].id > donations[j].id
+    })
+    return donations
+}
+
+func (s *memStore) GetDonations(email string, limit int) ([]Donation, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    m := s.member(s.NormalizeEmail(email))
+    if m == nil {
+        return nil, nil
+    }
+    var donations []Donation
+    for _, d := range s.memberDonations(m.ID) {
+        if len(donations) == limit {
+            break
+        }
+        d.Email = m.Email
+        donations = append(donations, d.Donation)
+    }
+    return donations, nil
+}
+
+func (s *memStore) GetDonationTotals(email string) ([]DonationTotal, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    totals := []DonationTotal{}
+    m := s.member(s.NormalizeEmail(email))
+    if m == nil {
+        return totals, nil
+    }
+    sums := map[string]int64{}
+    counts := map[string]int{}
+    for _, d := range s.memberDonations(m.ID) {
+        sums[d.Currency] += d.AmountCents
+        counts[d.Currency]++
+    }
+    for currency, cents := range sums {
+        totals = append(totals, DonationTotal{
+            Currency:      currency,
+            Count:         counts[currency],
+            LifetimeTotal: formatCents(cents),
+            AverageGift:   formatCents(int64(math.Round(float64(cents) / float64(counts[currency])))),
+        })
+    }
+    sort.Slice(totals, func(i, j int) bool { return sums[totals[i].Currency] > sums[totals[j].Currency] })
+    return totals, nil
+}
+
+func (s *memStore) MarkDonationRefunded(externalID string, refundedAt time.Time) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i := range s.donations {
+        if s.donations[i].ExternalID == externalID && s.donations[i].refundedAt == nil {
+            s.donations[i].refundedAt = &refundedAt
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func (s *memStore) GetAllMemberStatuses() (map[string]string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    statuses := make(map[string]string, len(s.members))
+    for _, m := range s.members {
+        statuses[strings.ToLower(m.Email)] = m.Status
+    }
+    return statuses, nil
+}
+
+func (s *memStore) GetEmailsWithTag(tag string) (map[string]bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    emails := map[string]bool{}
+    for _, m := range s.members {
+        if containsTag(m.Tags, normalizeTag(tag)) {
+            emails[strings.ToLower(m.Email)] = true
+        }
+    }
+    return emails, nil
+}
+
+func (s *memStore) GetHouseholdMembers() (map[string]bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    members := map[string]bool{}
+    for _, m := range s.members {
+        if m.derived() {
+            members[strings.ToLower(m.Email)] = true
+        }
+    }
+    return members, nil
+}
+
+func (s *memStore) GetLastActivityTimes() (map[string]time.Time, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    times := map[string]time.Time{}
+    for _, m := range s.members {
+        t := m.LastUpdated
+        if m.LastPaymentAt.Valid {
+            t = m.LastPaymentAt.Time
+        }
+        times[strings.ToLower(m.Email)] = t
+    }
+    return times, nil
+}
+
+// startSyncRun records a new sync run and returns its id
+func (s *memStore) startSyncRun(inputFile string) int {
+    id := s.newID("sync_runs")
+    s.syncRuns = append(s.syncRuns, SyncRun{ID: id, StartedAt: time.Now(), InputFile: inputFile, Status: "running"})
+    return id
+}
+
+// finishSyncRun marks a sync run applied with its counts
+func (s *memStore) finishSyncRun(runID, added, reactivated, deactivated, suspended int) {
+    for i := range s.syncRuns {
+        if s.syncRuns[i].ID == runID {
+            run := &s.syncRuns[i]
+            run.Status = "applied"
+            run.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+            run.Added, run.Reactivated, run.Deactivated, run.Suspended = added, reactivated, deactivated, suspended
+        }
+    }
+}
+
+func (s *memStore) recordSyncChange(runID int, email, before, after string) {
+    c := memSyncChange{runID: runID, email: email, before: before, after: after}
+    if m := s.member(email); m != nil {
+        c.memberID = m.ID
+    }
+    s.syncChanges = append(s.syncChanges, c)
+}
+
+// ApplySyncChanges is Database.ApplySyncChanges: one atomic run, counting
+// what actually changed
+func (s *memStore) ApplySyncChanges(changes SyncChanges, progress func()) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var runID int
+    err := s.atomically(func() error {
+        runID = s.startSyncRun(changes.InputFile)
+        change := ChangeSource{Source: "clean", Detail: fmt.Sprintf("sync run #%d (%s)", runID, changes.InputFile)}
+
+        added := 0
+        for _, email := range changes.Add {
+            if progress != nil {
+                progress()
+            }
+            result, err := s.processMember(email, "", false, StatusActive, change)
+            if err != nil {
+                return fmt.Errorf("failed to add member %s: %w", email, err)
+            }
+            if !result.Changed() {
+                continue
+            }
+            if result.Action == actionCreated {
+                added++
+                s.setMemberCampaign(email, changes.Campaigns[email])
+            }
+            s.recordSyncChange(runID, result.Email, result.PreviousStatus, result.Status)
+        }
+
+        apply := func(emails []string, status string) (int, error) {
+            updated, err := s.bulkUpdateStatus(emails, status, change)
+            if err != nil {
+                return 0, fmt.Errorf("failed to set members to %s: %w", status, err)
+            }
+            count := 0
+            for _, u := range updated {
+                s.syncChanges = append(s.syncChanges, memSyncChange{runID: runID, memberID: u.MemberID, email: u.Email, before: u.Before, after: status})
+                if u.Before != status {
+                    count++
+                }
+                if progress != nil {
+                    progress()
+                }
+            }
+            return count, nil
+        }
+        reactivated, err := apply(changes.Activate, StatusActive)
+        if err != nil {
+            return err
+        }
+        deactivated, err := apply(changes.Deactivate, StatusCancelled)
+        if err != nil {
+            return err
+        }
+        suspended, err := apply(changes.Suspend, StatusSuspended)
+        if err != nil {
+            return err
+        }
+
+        for email, paidAt := range changes.PaymentDates {
+            s.recordPayment(email, paidAt)
+        }
+        for email, frequency := range changes.Frequencies {
+            s.setMemberFrequency(email, frequency)
+        }
+        for _, d := range changes.Donations {
+            s.recordDonation(d)
+        }
+
+        s.finishSyncRun(runID, added, reactivated, deactivated, suspended)
+        s.recordEvent(feedSyncApplied, 0, change, map[string]interface{}{
+            "run_id":      runID,
+            "input_file":  changes.InputFile,
+            "added":       added,
+            "reactivated": reactivated,
+            "deactivated": deactivated,
+            "suspended":   suspended,
+        })
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+    return runID, nil
+}
+
+func (s *memStore) SyncRunApplied(inputFile string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, run := range s.syncRuns {
+        if run.InputFile == inputFile && (run.Status == "applied" || run.Status == "undone") {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func (s *memStore) RecordFailedSyncRun(inputFile string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.syncRuns = append(s.syncRuns, SyncRun{
+        ID: s.newID("sync_runs"), StartedAt: time.Now(), FinishedAt: sql.NullTime{Time: time.Now(), Valid: true},
+        InputFile: inputFile, Status: "failed",
+    })
+    return nil
+}
+
+func (s *memStore) WriteBackup(path string, includeWebhooks bool) (*BackupResult, error) {
+    return nil, errNotInMemory
+}
+
+// expirePendingChanges marks pending changes past their expiry as expired
+func (s *memStore) expirePendingChanges() {
+    now := time.Now()
+    for i := range s.pending {
+        c := &s.pending[i]
+        if c.State == pendingStatePending && c.ExpiresAt != nil && !c.ExpiresAt.After(now) {
+            c.State = pendingStateExpired
+            expired := *c.ExpiresAt
+            c.DecidedAt = &expired
+        }
+    }
+}
+
+func (s *memStore) QueuePendingChanges(changes []PendingChange, expiresAt *time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.atomically(func() error {
+        s.expirePendingChanges()
+        now := time.Now()
+
+        queued := map[string]bool{}
+        for _, c := range changes {
+            c.Email = s.NormalizeEmail(c.Email)
+            queued[c.Email] = true
+            from := ""
+            if m := s.member(c.Email); m != nil {
+                from = m.Status
+            }
+            row := PendingChange{
+                Email: c.Email, Action: c.Action, FromStatus: from, ToStatus: c.ToStatus, Source: c.Source,
+                Frequency: c.Frequency, Campaign: c.Campaign, PaidAt: c.PaidAt, ProposedAt: now, ExpiresAt: expiresAt,
+                State: pendingStatePending,
+            }
+
+            replaced := false
+            for i := range s.pending {
+                if s.pending[i].Email == c.Email && s.pending[i].State == pendingStatePending {
+                    row.ID = s.pending[i].ID
+                    s.pending[i] = row
+                    replaced = true
+                }
+            }
+            if !replaced {
+                row.ID = s.newID("pending_changes")
+                s.pending = append(s.pending, row)
+            }
+        }
+
+        for i := range s.pending {
+            if c := &s.pending[i]; c.State == pendingStatePending && !queued[c.Email] {
+                c.State = pendingStateSuperseded
+                c.DecidedAt = &now
+            }
+        }
+        return nil
+    })
+}
+
+func (s *memStore) GetPendingChanges(state string, limit int) ([]PendingChange, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.expirePendingChanges()
+    changes := []PendingChange{}
+    for _, c := range s.pending {
+        if len(changes) == limit {
+            break
+        }
+        if state == "" || c.State == state {
+            changes = append(changes, c)
+        }
+    }
+    return changes, nil
+}
+
+// lockPendingChanges returns the given pending changes, or with no ids
+// every pending one. Each id must exist and still be pending.
+func (s *memStore) lockPendingChanges(ids []int) ([]PendingChange, error) {
+    var changes []PendingChange
+    if len(ids) == 0 {
+        for _, c := range s.pending {
+            if c.State == pendingStatePending {
+                changes = append(changes, c)
+            }
+        }
+        return changes, nil
+    }
+
+    for _, id := range ids {
+        found := false
+        for _, c := range s.pending {
+            if c.ID != id {
+                continue
+            }
+            if c.State != pendingStatePending {
+                return nil, fmt.Errorf("%w: #%d is %s", ErrPendingChangeDecided, id, c.State)
+            }
+            changes = append(changes, c)
+            found = true
+        }
+        if !found {
+            return nil, fmt.Errorf("%w: #%d", ErrPendingChangeNotFound, id)
+        }
+    }
+    sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+    return changes, nil
+}
+
+// decidePendingChange records a decision on a pending change
+func (s *memStore) decidePendingChange(id int, state, by, note string, runID int) {
+    now := time.Now()
+    for i := range s.pending {
+        if c := &s.pending[i]; c.ID == id {
+            c.State, c.DecidedAt, c.DecidedBy, c.Note, c.SyncRunID = state, &now, by, note, runID
+        }
+    }
+}
+
+// applyPendingChange is Database.applyPendingChange
+func (s *memStore) applyPendingChange(runID int, c PendingChange, change ChangeSource) (string, error) {
+    current := ""
+    if m := s.member(c.Email); m != nil {
+        current = m.Status
+    }
+    if current != c.FromStatus {
+        if current == "" {
+            return "member no longer exists", nil
+        }
+        if c.FromStatus == "" {
+            return "member has been added since", nil
+        }
+        return fmt.Sprintf("member is %s now, not %s", current, c.FromStatus), nil
+    }
+
+    if c.Action == pendingAdd {
+        if _, err := s.processMember(c.Email, "", false, c.ToStatus, change); err != nil {
+            return "", err
+        }
+        s.setMemberCampaign(c.Email, c.Campaign)
+    } else {
+        err := s.updateMemberStatus(c.Email, c.ToStatus, change)
+        if errors.Is(err, ErrHouseholdMember) || errors.Is(err, ErrInvalidTransition) {
+            return err.Error(), nil
+        } else if err != nil {
+            return "", err
+        }
+    }
+
+    if c.PaidAt != nil {
+        s.recordPayment(c.Email, *c.PaidAt)
+    }
+    if c.Frequency != "" {
+        s.setMemberFrequency(c.Email, c.Frequency)
+    }
+    s.recordSyncChange(runID, c.Email, c.FromStatus, c.ToStatus)
+    return "", nil
+}
+
+func (s *memStore) ApprovePendingChanges(ids []int, by string, maxDeactivatePercent int) (*ReviewResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    result := &ReviewResult{Approved: []PendingChange{}, Skipped: []PendingChange{}}
+    err := s.atomically(func() error {
+        s.expirePendingChanges()
+        changes, err := s.lockPendingChanges(ids)
+        if err != nil || len(changes) == 0 {
+            return err
+        }
+
+        active := 0
+        for _, m := range s.members {
+            if m.Status == StatusActive {
+                active++
+            }
+        }
+        if err := checkPendingDeactivations(changes, active, maxDeactivatePercent); err != nil {
+            return err
+        }
+
+        result.SyncRunID = s.startSyncRun("review by " + by)
+        counts := map[string]int{}
+        for _, c := range changes {
+            change := ChangeSource{Source: "review", Detail: fmt.Sprintf("pending change #%d from %s, approved by %s", c.ID, c.Source, by)}
+            skip, err := s.applyPendingChange(result.SyncRunID, c, change)
+            if err != nil {
+                return fmt.Errorf("failed to apply pending change #%d: %w", c.ID, err)
+            }
+
+            if skip != "" {
+                s.decidePendingChange(c.ID, pendingStateSkipped, by, skip, 0)
+                c.State, c.DecidedBy, c.Note = pendingStateSkipped, by, skip
+                result.Skipped = append(result.Skipped, c)
+                continue
+            }
+            s.decidePendingChange(c.ID, pendingStateApproved, by, "", result.SyncRunID)
+            c.State, c.DecidedBy, c.SyncRunID = pendingStateApproved, by, result.SyncRunID
+            result.Approved = append(result.Approved, c)
+            counts[c.Action]++
+        }
+
+        s.finishSyncRun(result.SyncRunID, counts[pendingAdd], counts[pendingReactivate], counts[pendingDeactivate], counts[pendingSuspend])
+        s.recordEvent(feedSyncApplied, 0, ChangeSource{Source: "review"}, map[string]interface{}{
+            "run_id":  result.SyncRunID,
+            "skipped": len(result.Skipped),
+        })
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+func (s *memStore) RejectPendingChanges(ids []int, by, reason string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.atomically(func() error {
+        s.expirePendingChanges()
+        if _, err := s.lockPendingChanges(ids); err != nil {
+            return err
+        }
+        for _, id := range ids {
+            s.decidePendingChange(id, pendingStateRejected, by, strings.TrimSpace(reason), 0)
+        }
+        return nil
+    })
+}
+
+func (s *memStore) logWebhook(email, status, source string, payload json.RawMessage, state string) int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    id := s.newID("webhook_logs")
+    s.webhookLogs = append(s.webhookLogs, WebhookLogEntry{
+        ID: id, ReceivedAt: time.Now(), Email: email, Status: status, Source: source,
+        Payload: append(json.RawMessage(nil), payload...), State: state,
+    })
+    return id
+}
+
+func (s *memStore) LogWebhook(email, status, source string, payload json.RawMessage) (int, error) {
+    return s.logWebhook(email, status, source, payload, ""), nil
+}
+
+func (s *memStore) LogDryRunWebhook(email, status, source string, payload json.RawMessage) (int, error) {
+    return s.logWebhook(email, status, source, payload, webhookStateDryRun), nil
+}
+
+// webhookLog returns the log row with an id, or nil
+func (s *memStore) webhookLog(id int) *WebhookLogEntry {
+    for i := range s.webhookLogs {
+        if s.webhookLogs[i].ID == id {
+            return &s.webhookLogs[i]
+        }
+    }
+    return nil
+}
+
+func (s *memStore) QueueWebhookRetry(logID int, cause error) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if entry := s.webhookLog(logID); entry != nil {
+        next := time.Now().Add(retryDelay(0))
+        entry.State, entry.Attempts, entry.NextAttemptAt, entry.LastError = webhookStatePending, 0, &next, cause.Error()
+    }
+    return nil
+}
+
+func (s *memStore) SkipWebhook(logID int, reason string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if entry := s.webhookLog(logID); entry != nil {
+        entry.State, entry.SkipReason, entry.NextAttemptAt = webhookStateSkipped, reason, nil
+    }
+    return nil
+}
+
+func (s *memStore) DueWebhookRetries(limit int) ([]WebhookLogEntry, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var due []WebhookLogEntry
+    for _, entry := range s.webhookLogs {
+        if len(due) == limit {
+            break
+        }
+        if entry.State == webhookStatePending && entry.NextAttemptAt != nil && !entry.NextAttemptAt.After(time.Now()) {
+            due = append(due, entry)
+        }
+    }
+    return due, nil
+}
+
+func (s *memStore) GetWebhookLogsByState(state, source string, limit int) ([]WebhookLogEntry, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var logs []WebhookLogEntry
+    for i := len(s.webhookLogs) - 1; i >= 0 && len(logs) < limit; i-- {
+        entry := s.webhookLogs[i]
+        if entry.State == state && (source == "" || entry.Source == source) {
+            logs = append(logs, entry)
+        }
+    }
+    return logs, nil
+}
+
+func (s *memStore) LatestWebhookLogID() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.ids["webhook_logs"]
+}
+
+func (s *memStore) RecordWebhookAttempt(logID int, attempt WebhookAttempt) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if entry := s.webhookLog(logID); entry != nil {
+        entry.State, entry.Attempts, entry.NextAttemptAt = attempt.State, attempt.Attempts, attempt.NextAttempt
+        entry.LastError, entry.SkipReason = attempt.LastError, attempt.SkipReason
+    }
+    return nil
+}
+
+func (s *memStore) DeferWebhook(logID int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if entry := s.webhookLog(logID); entry != nil {
+        entry.State = webhookStateDeferred
+    }
+    return nil
+}
+
+// releaseDeferredWebhooks queues the deferred webhooks and counts them
+func (s *memStore) releaseDeferredWebhooks() int {
+    count := 0
+    now := time.Now()
+    for i := range s.webhookLogs {
+        if entry := &s.webhookLogs[i]; entry.State == webhookStateDeferred {
+            entry.State, entry.Attempts, entry.NextAttemptAt = webhookStatePending, 0, &now
+            count++
+        }
+    }
+    return count
+}
+
+func (s *memStore) ReleaseDeferredWebhooks() (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.releaseDeferredWebhooks(), nil
+}
+
+func (s *memStore) RecordFailedWebhook(f FailedWebhook) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if f.ReceivedAt.IsZero() {
+        f.ReceivedAt = time.Now()
+    }
+    if f.WebhookLogID > 0 {
+        for i := range s.failures {
+            if existing := &s.failures[i]; existing.WebhookLogID == f.WebhookLogID && existing.State == failureOpen {
+                existing.ErrorClass, existing.Error = f.ErrorClass, f.Error
+                return existing.ID, nil
+            }
+        }
+    }
+    f.ID = s.newID("failed_webhooks")
+    f.State = failureOpen
+    s.failures = append(s.failures, f)
+    return f.ID, nil
+}
+
+func (s *memStore) GetFailedWebhooks(state string, limit int) ([]FailedWebhook, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    failures := []FailedWebhook{}
+    for i := len(s.failures) - 1; i >= 0 && len(failures) < limit; i-- {
+        if state == "" || s.failures[i].State == state {
+            failures = append(failures, s.failures[i])
+        }
+    }
+    return failures, nil
+}
+
+func (s *memStore) ResolveFailedWebhook(id int, state, by, note string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i := range s.failures {
+        f := &s.failures[i]
+        if f.ID != id || f.State != failureOpen {
+            continue
+        }
+        now := time.Now()
+        f.State, f.ResolvedAt, f.ResolvedBy, f.ResolutionNote = state, &now, by, note
+        if entry := s.webhookLog(f.WebhookLogID); state == failureRetried && entry != nil &&
+            (entry.State == webhookStateFailed || entry.State == webhookStatePending) {
+            entry.State, entry.NextAttemptAt = webhookStateDone, nil
+        }
+        return nil
+    }
+    return fmt.Errorf("%w: no open failure #%d", ErrFailureNotFound, id)
+}
+
+func (s *memStore) UpdateFailedWebhookError(id int, class, message string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i := range s.failures {
+        if s.failures[i].ID == id {
+            s.failures[i].ErrorClass, s.failures[i].Error = class, message
+        }
+    }
+    return nil
+}
+
+func (s *memStore) GetOpenFailuresOfClass(class string) ([]FailedWebhook, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    failures := []FailedWebhook{}
+    for _, f := range s.failures {
+        if f.State == failureOpen && f.ErrorClass == class {
+            failures = append(failures, f)
+        }
+    }
+    return failures, nil
+}
+
+func (s *memStore) ResolveFailuresForLog(logID int, by string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    for i := range s.failures {
+        if f := &s.failures[i]; f.WebhookLogID == logID && f.State == failureOpen {
+            f.State, f.ResolvedAt, f.ResolvedBy = failureRetried, &now, by
+        }
+    }
+    return nil
+}
+
+func (s *memStore) RecordUnmappedValue(kind, value string, seen int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key := kind + "|" + unmappedKey(value)
+    u, ok := s.unmapped[key]
+    if !ok {
+        u = UnmappedStatus{Kind: kind, PaymentStatus: unmappedKey(value), FirstSeenAt: time.Now()}
+    }
+    u.Seen += seen
+    u.LastSeenAt = time.Now()
+    s.unmapped[key] = u
+    return nil
+}
+
+func (s *memStore) StatusMapping(paymentStatus string) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.unmapped[unmappedKindStatus+"|"+unmappedKey(paymentStatus)].MappedTo, nil
+}
+
+func (s *memStore) GetUnmappedStatuses(all bool) ([]UnmappedStatus, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var statuses []UnmappedStatus
+    for _, u := range s.unmapped {
+        if all || u.MappedTo == "" {
+            statuses = append(statuses, u)
+        }
+    }
+    sort.Slice(statuses, func(i, j int) bool { return statuses[i].LastSeenAt.After(statuses[j].LastSeenAt) })
+    return statuses, nil
+}
+
+func (s *memStore) MapUnmappedStatus(paymentStatus, target, by string) error {
+    key := unmappedKey(paymentStatus)
+    if key == "" {
+        return fmt.Errorf("payment status is empty")
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    u, ok := s.unmapped[unmappedKindStatus+"|"+key]
+    if target == "" {
+        if !ok {
+            return fmt.Errorf("%w: %q", ErrUnmappedStatusNotFound, key)
+        }
+        u.MappedTo, u.MappedAt, u.MappedBy = "", nil, ""
+        s.unmapped[unmappedKindStatus+"|"+key] = u
+        return nil
+    }
+
+    if target != statusFailed && !validStatus(target) {
+        return fmt.Errorf("%w: %q", ErrUnknownStatus, target)
+    }
+    if !ok {
+        u = UnmappedStatus{Kind: unmappedKindStatus, PaymentStatus: key, FirstSeenAt: time.Now(), LastSeenAt: time.Now()}
+    }
+    now := time.Now()
+    u.MappedTo, u.MappedAt, u.MappedBy = target, &now, by
+    s.unmapped[unmappedKindStatus+"|"+key] = u
+    return nil
+}
+
+func (s *memStore) LatestDonations() (map[string]Donation, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    latest := map[string]Donation{}
+    for _, m := range s.members {
+        if donations := s.memberDonations(m.ID); len(donations) > 0 {
+            latest[m.PublicID] = donations[0].Donation
+        }
+    }
+    return latest, nil
+}
+
+func (s *memStore) QueueMemberEmail(email, kind, transition string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    m := s.member(s.NormalizeEmail(email))
+    if m == nil {
+        return false, nil
+    }
+    for _, e := range s.memberEmails {
+        if e.memberID == m.ID && e.kind == kind && e.transition == transition {
+            return false, nil
+        }
+    }
+    s.memberEmails = append(s.memberEmails, memMemberEmail{
+        id: s.newID("member_emails"), memberID: m.ID, kind: kind, transition: transition,
+        state: memberEmailPending, nextAttempt: time.Now(),
+    })
+    return true, nil
+}
+
+func (s *memStore) DueMemberEmails(limit int) ([]MemberEmail, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var emails []MemberEmail
+    for _, e := range s.memberEmails {
+        if len(emails) == limit {
+            break
+        }
+        m := s.members[e.memberID]
+        if m == nil || e.state != memberEmailPending || e.nextAttempt.After(time.Now()) {
+            continue
+        }
+        address := m.Email
+        if m.RawEmail.Valid {
+            address = m.RawEmail.String
+        }
+        emails = append(emails, MemberEmail{
+            ID: e.id, Kind: e.kind, Attempts: e.attempts, Address: address, Name: m.Name.String,
+            IsAnonymous: m.IsAnonymous, OptedOut: m.EmailOptIn.Valid && !m.EmailOptIn.Bool,
+            UnsubscribeToken: m.UnsubscribeToken,
+        })
+    }
+    return emails, nil
+}
+
+func (s *memStore) RecordMemberEmailAttempt(id int, state string, next time.Time, cause string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i := range s.memberEmails {
+        if e := &s.memberEmails[i]; e.id == id {
+            e.state, e.nextAttempt, e.lastError = state, next, cause
+            e.attempts++
+        }
+    }
+    return nil
+}
+
+func (s *memStore) MaintenanceMode() (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.settings[maintenanceKey] == "true", nil
+}
+
+func (s *memStore) SetMaintenanceMode(on bool) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.settings[maintenanceKey] = fmt.Sprint(on)
+    if !on {
+        s.releaseDeferredWebhooks()
+    }
+    return nil
+}
+
+func (s *memStore) LockJob(name string) (func(), bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.jobLocks[name] {
+        return nil, false, nil
+    }
+    s.jobLocks[name] = true
+    return func() {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        delete(s.jobLocks, name)
+    }, true, nil
+}
+
+func (s *memStore) RecordJobRun(run *JobRun) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    run.ID = int64(s.newID("jobs_history"))
+    s.jobRuns = append(s.jobRuns, *run)
+    return nil
+}
+
+func (s *memStore) LastJobRuns() (map[string]JobRun, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    last := map[string]JobRun{}
+    for _, run := range s.jobRuns {
+        if previous, ok := last[run.Job]; !ok || !run.StartedAt.Before(previous.StartedAt) {
+            last[run.Job] = run
+        }
+    }
+    return last, nil
+}
+
+// counted reports whether a member counts toward stats
+func (s *memStore) counted(m *memMember) bool {
+    return !s.CountHouseholds || !m.derived()
+}
+
+// GetStats is Database.GetStats
+func (s *memStore) GetStats(ctx context.Context) (*Stats, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    stats := Stats{CountedBy: "individuals", ActiveByFrequency: map[string]int{}, WebhooksBySource: map[string]int{}}
+    if s.CountHouseholds {
+        stats.CountedBy = "households"
+    }
+
+    statuses := map[string]int{}
+    overdue := time.Now().AddDate(0, 0, -90)
+    for _, m := range s.members {
+        if m.failedPayments > 0 {
+            stats.FailedPaymentMembers++
+        }
+        if m.Status == StatusActive && !m.derived() {
+            lastPaid := m.FirstSeen
+            if m.LastPaymentAt.Valid {
+                lastPaid = m.LastPaymentAt.Time
+            }
+            if lastPaid.Before(overdue) {
+                stats.OverduePaymentMembers++
+            }
+        }
+        if !s.counted(m) {
+            continue
+        }
+        statuses[m.Status]++
+        if m.IsAnonymous {
+            stats.AnonymousMembers++
+        }
+        if m.Status == StatusActive {
+            frequency := m.Frequency.String
+            if !m.Frequency.Valid {
+                frequency = frequencyUnknown
+            }
+            stats.ActiveByFrequency[frequency]++
+        }
+    }
+    for status, count := range statuses {
+        stats.addStatusCount(status, count)
+    }
+
+    since := time.Now().AddDate(0, 0, -30)
+    for _, entry := range s.webhookLogs {
+        if entry.ReceivedAt.After(since) && entry.State != webhookStateDryRun {
+            source := entry.Source
+            if source == "" {
+                source = "unknown"
+            }
+            stats.WebhooksBySource[source]++
+        }
+    }
+
+    return &stats, nil
+}
+
+// GetRevenueStats is Database.GetRevenueStats
+func (s *memStore) GetRevenueStats(ctx context.Context) ([]RevenueStats, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    type totals struct {
+        mrr, last30, last365, sum int64
+        members, donations        int
+    }
+    byCurrency := map[string]*totals{}
+    get := func(currency string) *totals {
+        if byCurrency[currency] == nil {
+            byCurrency[currency] = &totals{}
+        }
+        return byCurrency[currency]
+    }
+
+    for _, m := range s.members {
+        if m.Status != StatusActive {
+            continue
+        }
+        for _, d := range s.memberDonations(m.ID) {
+            if d.refundedAt != nil {
+                continue
+            }
+            frequency := d.Frequency
+            if frequency == "" {
+                frequency = m.Frequency.String
+            }
+            if cents, ok := monthlyCents(d.AmountCents, frequency); ok {
+                t := get(d.Currency)
+                t.mrr += cents
+                t.members++
+            }
+            break
+        }
+    }
+
+    for _, d := range s.donations {
+        if d.refundedAt != nil {
+            continue
+        }
+        t := get(d.Currency)
+        t.donations++
+        t.sum += d.AmountCents
+        if d.OccurredAt.After(time.Now().AddDate(0, 0, -30)) {
+            t.last30 += d.AmountCents
+        }
+        if d.OccurredAt.After(time.Now().AddDate(0, 0, -365)) {
+            t.last365 += d.AmountCents
+        }
+    }
+
+    revenue := []RevenueStats{}
+    for currency, t := range byCurrency {
+        if t.donations == 0 {
+            continue
+        }
+        revenue = append(revenue, RevenueStats{
+            Currency:         currency,
+            EstimatedMRR:     formatCents(t.mrr),
+            RecurringMembers: t.members,
+            Revenue30Days:    formatCents(t.last30),
+            Revenue365Days:   formatCents(t.last365),
+            Donations:        t.donations,
+            AverageGift:      formatCents(int64(math.Round(float64(t.sum) / float64(t.donations)))),
+        })
+    }
+    sort.Slice(revenue, func(i, j int) bool {
+        a, b := byCurrency[revenue[i].Currency], byCurrency[revenue[j].Currency]
+        if a.mrr != b.mrr {
+            return a.mrr > b.mrr
+        }
+        return a.last365 > b.last365
+    })
+    return revenue, nil
+}
+
+// GetCampaignStats is Database.GetCampaignStats
+func (s *memStore) GetCampaignStats(ctx context.Context) ([]CampaignStats, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    byCampaign := map[string]*CampaignStats{}
+    for _, m := range s.members {
+        name := m.Campaign.String
+        if name == "" {
+            name = campaignUnknown
+        }
+        c := byCampaign[name]
+        if c == nil {
+            c = &CampaignStats{Campaign: name}
+            byCampaign[name] = c
+        }
+        c.Members++
+        if m.Status == StatusActive {
+            c.Active++
+        }
+
+        measured := m.FirstSeen.AddDate(0, 0, campaignRetentionDays)
+        if measured.After(time.Now()) {
+            continue
+        }
+        c.Eligible90Days++
+
+        status, any := "", false
+        cutoff := m.FirstSeen.AddDate(0, 0, campaignRetentionDays+1)
+        for _, h := range s.history {
+            if h.memberID != m.ID {
+                continue
+            }
+            any = true
+            if h.ChangedAt.Before(cutoff) {
+                status = h.Status
+            }
+        }
+        if !any {
+            status = m.Status
+        }
+        if status == StatusActive {
+            c.Retained90Days++
+        }
+    }
+
+    var campaigns []CampaignStats
+    for _, c := range byCampaign {
+        if c.Eligible90Days > 0 {
+            rate := float64(c.Retained90Days) / float64(c.Eligible90Days)
+            c.Retention90Days = &rate
+        }
+        campaigns = append(campaigns, *c)
+    }
+    sort.Slice(campaigns, func(i, j int) bool {
+        if campaigns[i].Members != campaigns[j].Members {
+            return campaigns[i].Members > campaigns[j].Members
+        }
+        return campaigns[i].Campaign < campaigns[j].Campaign
+    })
+    return campaigns, nil
+}
+
+func (s *memStore) GetChangeToken() (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var latest time.Time
+    for _, m := range s.members {
+        if m.LastUpdated.After(latest) {
+            latest = m.LastUpdated
+        }
+    }
+    return fmt.Sprintf("%d-%d", latest.UnixMicro(), len(s.members)), nil
+}
+
+func (s *memStore) TakeSnapshot() (*StatsSnapshot, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    snapshot := StatsSnapshot{Date: time.Now().Format("2006-01-02"), TakenAt: time.Now()}
+    for _, m := range s.members {
+        if !s.counted(m) {
+            continue
+        }
+        snapshot.Total++
+        switch m.Status {
+        case StatusActive:
+            snapshot.Active++
+        case StatusCancelled:
+            snapshot.Cancelled++
+        case StatusSuspended:
+            snapshot.Suspended++
+        case StatusLapsed:
+            snapshot.Lapsed++
+        }
+        if m.IsAnonymous {
+            snapshot.Anonymous++
+        }
+    }
+    s.snapshots[snapshot.Date] = snapshot
+    return &snapshot, nil
+}
+
+func (s *memStore) GetSnapshots(days int) ([]StatsSnapshot, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    since := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+    snapshots := []StatsSnapshot{}
+    for date, snapshot := range s.snapshots {
+        if date > since {
+            snapshots = append(snapshots, snapshot)
+        }
+    }
+    sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Date < snapshots[j].Date })
+    return snapshots, nil
+}
+
+func (s *memStore) GetRetention(months int) ([]RetentionCohort, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) GetAnniversaryMembers() ([]Anniversary, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) GetDonationMilestones(since time.Time) ([]Anniversary, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) GetEvents(since int64, types []string, limit int) ([]FeedEvent, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var events []FeedEvent
+    for _, e := range s.events {
+        if len(events) == limit {
+            break
+        }
+        if e.ID <= since || (len(types) > 0 && !containsTag(types, e.Type)) {
+            continue
+        }
+        event := e.FeedEvent
+        if m := s.members[e.memberID]; m != nil {
+            event.MemberID = m.PublicID
+        }
+        events = append(events, event)
+    }
+    return events, nil
+}
+
+func (s *memStore) LatestEventID() (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return int64(s.ids["events"]), nil
+}
+
+func (s *memStore) ListSubscriptions(activeOnly bool) ([]Subscription, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var subs []Subscription
+    for _, sub := range s.subscriptions {
+        if !activeOnly || sub.DisabledAt == nil {
+            subs = append(subs, sub)
+        }
+    }
+    return subs, nil
+}
+
+func (s *memStore) CreateSubscription(url, secret string, events []string) (*Subscription, error) {
+    if !isURL(url) {
+        return nil, fmt.Errorf("subscription URL must be http(s), got %q", url)
+    }
+    if secret == "" {
+        var err error
+        if secret, err = newSubscriptionSecret(); err != nil {
+            return nil, err
+        }
+    }
+    if events == nil {
+        events = []string{}
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    sub := Subscription{ID: s.newID("subscriptions"), URL: url, Secret: secret, Events: events, CreatedAt: time.Now()}
+    s.subscriptions = append(s.subscriptions, sub)
+    return &sub, nil
+}
+
+func (s *memStore) DeleteSubscription(id int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for i, sub := range s.subscriptions {
+        if sub.ID == id {
+            s.subscriptions = append(s.subscriptions[:i], s.subscriptions[i+1:]...)
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+}
+
+func (s *memStore) RecordAccess(entries []AccessLogEntry) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.accessLogs = append(s.accessLogs, entries...)
+    return nil
+}
+
+func (s *memStore) GetAccessLogs(since time.Time, limit int) ([]AccessLogEntry, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var entries []AccessLogEntry
+    for _, e := range s.accessLogs {
+        if len(entries) == limit {
+            break
+        }
+        if !e.AccessedAt.Before(since) {
+            entries = append(entries, e)
+        }
+    }
+    return entries, nil
+}
+
+// PruneOlderThan is Database.PruneOlderThan for the pruneTargets tables
+func (s *memStore) PruneOlderThan(target pruneTarget, dryRun bool) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    cutoff := time.Now().Add(-target.Retention)
+    count := 0
+    switch target.Table {
+    case "webhook_logs":
+        var kept []WebhookLogEntry
+        for _, entry := range s.webhookLogs {
+            if entry.ReceivedAt.Before(cutoff) && (entry.State == "" || entry.State == webhookStateDone) {
+                count++
+            } else {
+                kept = append(kept, entry)
+            }
+        }
+        if !dryRun {
+            s.webhookLogs = kept
+        }
+    case "access_logs":
+        var kept []AccessLogEntry
+        for _, e := range s.accessLogs {
+            if e.AccessedAt.Before(cutoff) {
+                count++
+            } else {
+                kept = append(kept, e)
+            }
+        }
+        if !dryRun {
+            s.accessLogs = kept
+        }
+    case "jobs_history":
+        var kept []JobRun
+        for _, run := range s.jobRuns {
+            if run.StartedAt.Before(cutoff) {
+                count++
+            } else {
+                kept = append(kept, run)
+            }
+        }
+        if !dryRun {
+            s.jobRuns = kept
+        }
+    default:
+        return 0, fmt.Errorf("failed to prune %s: %w", target.Name, errNotInMemory)
+    }
+    return count, nil
+}
+
+func (s *memStore) BuildDigest(start, end time.Time) (*Digest, error) {
+    return nil, errNotInMemory
+}
+
+func (s *memStore) LastDigestDate() (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.settings[digestSentKey], nil
+}
+
+func (s *memStore) RecordDigestSent(date string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.settings[digestSentKey] = date
+    return nil
+}
+
+// Store is implemented in full
+var _ Store = (*memStore)(nil)
@@ -0,0 +1,429 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// ErrInvalidMerge is returned when a merge request names the wrong members
+var ErrInvalidMerge = errors.New("invalid merge")
+
+// MergeResult describes how two member records were combined
+type MergeResult struct {
+    FromEmail    string    `json:"from_email"`
+    ToEmail      string    `json:"to_email"`
+    FromID       int       `json:"from_id"`
+    ToID         int       `json:"to_id"`
+    FromStatus   string    `json:"from_status"`
+    ToStatus     string    `json:"to_status"`
+    FinalStatus  string    `json:"final_status"`
+    FirstSeen    time.Time `json:"first_seen"`
+    HistoryMoved int       `json:"history_moved"`
+    DryRun       bool      `json:"dry_run"`
+}
+
+// mergeRow is the subset of a member row needed to resolve a merge
+type mergeRow struct {
+    id             int
+    name           sql.NullString
+    isAnonymous    bool
+    status         string
+    campaign       sql.NullString
+    tags           []string
+    notes          sql.NullString
+    discordID      sql.NullString
+    firstPaymentAt sql.NullTime
+    lastPaymentAt  sql.NullTime
+    failedPayments int
+    firstSeen      time.Time
+    lastUpdated    time.Time
+}
+
+// mergeTags returns the survivor's tags followed by any only the duplicate
+// had, so a protected duplicate makes a protected survivor
+func mergeTags(to, from []string) []string {
+    tags := append([]string{}, to...)
+    for _, tag := range from {
+        if !containsTag(tags, tag) {
+            tags = append(tags, tag)
+        }
+    }
+    return tags
+}
+
+// containsTag reports whether tags holds tag
+func containsTag(tags []string, tag string) bool {
+    for _, t := range tags {
+        if t == tag {
+            return true
+        }
+    }
+    return false
+}
+
+// mergeNotes keeps both records' notes, the survivor's first
+func mergeNotes(to, from sql.NullString) sql.NullString {
+    if from.String == "" || from.String == to.String {
+        return to
+    }
+    if to.String == "" {
+        return from
+    }
+    return sql.NullString{String: to.String + "\n\n" + from.String, Valid: true}
+}
+
+// earlierTime returns whichever of a and b is set and earlier
+func earlierTime(a, b sql.NullTime) sql.NullTime {
+    if !a.Valid || (b.Valid && b.Time.Before(a.Time)) {
+        return b
+    }
+    return a
+}
+
+// laterTime returns whichever of a and b is set and later
+func laterTime(a, b sql.NullTime) sql.NullTime {
+    if !a.Valid || (b.Valid && b.Time.After(a.Time)) {
+        return b
+    }
+    return a
+}
+
+// mergeFailedPayments returns the failed-payment streak of the record whose
+// status survives, the longer one if both had it
+func mergeFailedPayments(to, from int, toStatus, fromStatus, finalStatus string) int {
+    switch {
+    case toStatus == fromStatus:
+        return max(to, from)
+    case finalStatus == fromStatus:
+        return from
+    default:
+        return to
+    }
+}
+
+// MergeMembers folds fromEmail into toEmail: history moves to the surviving
+// record, the earliest first_seen is kept, the most recently updated status
+// wins, and the duplicate row is deleted, all in one transaction
+func (db *Database) MergeMembers(fromEmail, toEmail string) (*MergeResult, error) {
+    return db.mergeMembers(fromEmail, toEmail, false)
+}
+
+// PreviewMerge computes the outcome of MergeMembers without changing anything
+func (db *Database) PreviewMerge(fromEmail, toEmail string) (*MergeResult, error) {
+    return db.mergeMembers(fromEmail, toEmail, true)
+}
+
+func (db *Database) mergeMembers(fromEmail, toEmail string, dryRun bool) (*MergeResult, error) {
+    fromEmail = db.NormalizeEmail(fromEmail)
+    toEmail = db.NormalizeEmail(toEmail)
+
+    if fromEmail == "" || toEmail == "" {
+        return nil, fmt.Errorf("%w: both emails are required", ErrInvalidMerge)
+    }
+    if fromEmail == toEmail {
+        return nil, fmt.Errorf("%w: cannot merge %s into itself", ErrInvalidMerge, fromEmail)
+    }
+
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    from, err := lockMergeRow(tx, fromEmail)
+    if err != nil {
+        return nil, err
+    }
+    to, err := lockMergeRow(tx, toEmail)
+    if err != nil {
+        return nil, err
+    }
+
+    // Conflicting statuses resolve to whichever record was touched last
+    finalStatus := to.status
+    if from.status != to.status && from.lastUpdated.After(to.lastUpdated) {
+        finalStatus = from.status
+    }
+    if from.status != to.status {
+        db.logger.Printf("Merge %s -> %s: status conflict %s vs %s, keeping %s (most recently updated)",
+            fromEmail, toEmail, from.status, to.status, finalStatus)
+    }
+
+    result, err := mergeLockedRows(tx, fromEmail, toEmail, from, to, finalStatus)
+    if err != nil {
+        return nil, err
+    }
+    result.DryRun = dryRun
+
+    if dryRun {
+        return result, nil
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    db.logger.Printf("Merged member %s (ID: %d) into %s (ID: %d), status %s",
+        fromEmail, from.id, toEmail, to.id, result.FinalStatus)
+
+    return result, nil
+}
+
+// mergeLockedRows folds from into to within tx: history moves, the earliest
+// first_seen is kept, the survivor takes finalStatus along with the union of
+// both records' tags, notes, and payment dates, and the duplicate row is
+// deleted. to is updated in place so several rows can be merged in turn.
+func mergeLockedRows(tx *sql.Tx, fromEmail, toEmail string, from, to *mergeRow, finalStatus string) (*MergeResult, error) {
+    result := &MergeResult{
+        FromEmail:   fromEmail,
+        ToEmail:     toEmail,
+        FromID:      from.id,
+        ToID:        to.id,
+        FromStatus:  from.status,
+        ToStatus:    to.status,
+        FinalStatus: finalStatus,
+        FirstSeen:   to.firstSeen,
+    }
+
+    // The campaign goes with the earlier record, as first_seen does
+    campaign := to.campaign
+    if from.firstSeen.Before(to.firstSeen) {
+        result.FirstSeen = from.firstSeen
+        if from.campaign.Valid {
+            campaign = from.campaign
+        }
+    } else if !campaign.Valid {
+        campaign = from.campaign
+    }
+
+    discordID := to.discordID
+    if discordID.String == "" {
+        discordID = from.discordID
+    }
+    tags := mergeTags(to.tags, from.tags)
+    notes := mergeNotes(to.notes, from.notes)
+    firstPaymentAt := earlierTime(to.firstPaymentAt, from.firstPaymentAt)
+    lastPaymentAt := laterTime(to.lastPaymentAt, from.lastPaymentAt)
+    failedPayments := mergeFailedPayments(to.failedPayments, from.failedPayments, to.status, from.status, finalStatus)
+
+    res, err := tx.Exec(`
+        UPDATE status_history SET member_id = $1 WHERE member_id = $2
+    `, to.id, from.id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to move status history: %w", err)
+    }
+    moved, _ := res.RowsAffected()
+    result.HistoryMoved = int(moved)
+
+    _, err = tx.Exec(`UPDATE events SET member_id = $1 WHERE member_id = $2`, to.id, from.id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to move events: %w", err)
+    }
+
+    _, err = tx.Exec(`UPDATE donations SET member_id = $1 WHERE member_id = $2`, to.id, from.id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to move donations: %w", err)
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO org_members (organization_id, member_id, added_at)
+        SELECT organization_id, $1, added_at FROM org_members WHERE member_id = $2
+        ON CONFLICT DO NOTHING
+    `, to.id, from.id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to move organization memberships: %w", err)
+    }
+
+    // Keeps the surviving member from being welcomed twice
+    _, err = tx.Exec(`
+        UPDATE member_emails SET member_id = $1
+        WHERE member_id = $2
+          AND NOT EXISTS (
+              SELECT 1 FROM member_emails kept
+              WHERE kept.member_id = $1 AND kept.kind = member_emails.kind AND kept.transition = member_emails.transition
+          )
+    `, to.id, from.id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to move member emails: %w", err)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE members SET
+            name = CASE
+                WHEN is_anonymous THEN name
+                WHEN COALESCE(name, '') = '' THEN $2
+                ELSE name
+            END,
+            status = $3,
+            first_seen = $4,
+            campaign = $5,
+            tags = $6,
+            notes = $7,
+            discord_id = $8,
+            first_payment_at = $9,
+            last_payment_at = $10,
+            failed_payment_count = $11,
+            last_updated = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `, to.id, from.name, result.FinalStatus, result.FirstSeen, campaign,
+        pq.Array(tags), notes, discordID, firstPaymentAt, lastPaymentAt, failedPayments)
+    if err != nil {
+        return nil, fmt.Errorf("failed to update surviving member: %w", err)
+    }
+
+    change := ChangeSource{Source: "merge", Detail: "merged from " + fromEmail}
+    if result.FinalStatus != to.status {
+        err = recordStatusHistory(tx, to.id, result.FinalStatus, change)
+        if err != nil {
+            return nil, fmt.Errorf("failed to record status change: %w", err)
+        }
+    }
+
+    if err := mergeHousehold(tx, from.id, to.id); err != nil {
+        return nil, err
+    }
+
+    err = recordEvent(tx, feedMemberMerged, to.id, change, map[string]interface{}{
+        "from_email":   fromEmail,
+        "to_email":     toEmail,
+        "from_status":  from.status,
+        "to_status":    to.status,
+        "final_status": result.FinalStatus,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.Exec(`DELETE FROM members WHERE id = $1`, from.id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to delete duplicate member: %w", err)
+    }
+
+    to.status = result.FinalStatus
+    to.firstSeen = result.FirstSeen
+    to.campaign = campaign
+    to.tags = tags
+    to.notes = notes
+    to.discordID = discordID
+    to.firstPaymentAt = firstPaymentAt
+    to.lastPaymentAt = lastPaymentAt
+    to.failedPayments = failedPayments
+    if !to.name.Valid || to.name.String == "" {
+        to.name = from.name
+    }
+
+    return result, nil
+}
+
+// lockMergeRow loads and locks one side of a merge
+func lockMergeRow(tx *sql.Tx, email string) (*mergeRow, error) {
+    var row mergeRow
+    err := tx.QueryRow(`
+        SELECT id, name, is_anonymous, status, campaign, tags, notes, discord_id,
+               first_payment_at, last_payment_at, failed_payment_count, first_seen, last_updated
+        FROM members WHERE email = $1
+        FOR UPDATE
+    `, email).Scan(&row.id, &row.name, &row.isAnonymous, &row.status, &row.campaign, pq.Array(&row.tags),
+        &row.notes, &row.discordID, &row.firstPaymentAt, &row.lastPaymentAt, &row.failedPayments,
+        &row.firstSeen, &row.lastUpdated)
+
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    } else if err != nil {
+        return nil, fmt.Errorf("database error: %w", err)
+    }
+
+    return &row, nil
+}
+
+// mergeRequest is the body accepted by the merge endpoint
+type mergeRequest struct {
+    From   string `json:"from"`
+    To     string `json:"to"`
+    DryRun bool   `json:"dry_run"`
+}
+
+// mergeHandler merges two member records
+func (s *WebhookServer) mergeHandler(w http.ResponseWriter, r *http.Request) {
+    var req mergeRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid JSON")
+        return
+    }
+
+    var result *MergeResult
+    var err error
+    if req.DryRun {
+        result, err = s.db.PreviewMerge(req.From, req.To)
+    } else {
+        result, err = s.db.MergeMembers(req.From, req.To)
+    }
+
+    if err != nil {
+        if errors.Is(err, ErrMemberNotFound) {
+            writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+            return
+        }
+        if errors.Is(err, ErrInvalidMerge) {
+            writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+            return
+        }
+        s.logger.Printf("Error merging members: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Merge failed")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
+
+func runMerge(logger *log.Logger) {
+    mergeCmd := flag.NewFlagSet("merge", flag.ExitOnError)
+    dryRun := mergeCmd.Bool("dry-run", false, "Show the merge outcome without making changes")
+
+    args := parseSubcommand(mergeCmd, "memberships merge <old-email> <new-email> [--dry-run]", os.Args[2:])
+    if len(args) < 2 {
+        fmt.Fprintln(os.Stderr, "Error: merge command requires two email addresses")
+        mergeCmd.Usage()
+        os.Exit(2)
+    }
+
+    fromEmail := args[0]
+    toEmail := args[1]
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    var result *MergeResult
+    var err error
+    if *dryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+        result, err = db.PreviewMerge(fromEmail, toEmail)
+    } else {
+        result, err = db.MergeMembers(fromEmail, toEmail)
+    }
+    if err != nil {
+        logger.Fatalf("Merge failed: %v", err)
+    }
+
+    fmt.Println("\n=== Member Merge ===")
+    fmt.Printf("Duplicate:     %s (ID: %d, %s)\n", result.FromEmail, result.FromID, result.FromStatus)
+    fmt.Printf("Surviving:     %s (ID: %d, %s)\n", result.ToEmail, result.ToID, result.ToStatus)
+    fmt.Printf("Final status:  %s\n", result.FinalStatus)
+    fmt.Printf("First seen:    %s\n", displayDate(result.FirstSeen))
+    fmt.Printf("History moved: %d\n", result.HistoryMoved)
+    if result.DryRun {
+        fmt.Println("DRY RUN complete - no changes made")
+    }
+    fmt.Println()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestMergeNotes(t *testing.T) {
+    note := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+    tests := []struct {
+        to, from, want string
+    }{
+        {"", "", ""},
+        {"kept", "", "kept"},
+        {"", "moved", "moved"},
+        {"same", "same", "same"},
+        {"kept", "moved", "kept\n\nmoved"},
+    }
+    for _, tt := range tests {
+        if got := mergeNotes(note(tt.to), note(tt.from)); got != note(tt.want) {
+            t.Errorf("mergeNotes(%q, %q) = %+v, want %q", tt.to, tt.from, got, tt.want)
+        }
+    }
+}
+
+func TestMergeFailedPayments(t *testing.T) {
+    tests := []struct {
+        to, from                          int
+        toStatus, fromStatus, finalStatus string
+        want                              int
+    }{
+        {1, 2, StatusSuspended, StatusSuspended, StatusSuspended, 2},
+        {0, 2, StatusActive, StatusSuspended, StatusSuspended, 2},
+        {0, 2, StatusActive, StatusSuspended, StatusActive, 0},
+        {1, 0, StatusSuspended, StatusActive, StatusSuspended, 1},
+    }
+    for _, tt := range tests {
+        if got := mergeFailedPayments(tt.to, tt.from, tt.toStatus, tt.fromStatus, tt.finalStatus); got != tt.want {
+            t.Errorf("%+v: got %d", tt, got)
+        }
+    }
+}
+
+func TestMergeCarriesOverFields(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, map[string]string{"old@example.org": StatusSuspended, "new@example.org": StatusActive})
+
+    oldNotes, newNotes := "prefers post", "board candidate"
+    db.UpdateMemberAnnotations("old@example.org", &oldNotes, []string{ProtectedTag, "volunteer"})
+    db.UpdateMemberAnnotations("new@example.org", &newNotes, []string{"volunteer"})
+    db.SetDiscordID("old@example.org", "1234")
+
+    early := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    late := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+    db.RecordPayment("old@example.org", early)
+    db.RecordPayment("new@example.org", late)
+    db.member("old@example.org").failedPayments = 2
+
+    // The suspended duplicate was touched last, so its status and streak win
+    db.member("old@example.org").LastUpdated = time.Now().Add(time.Hour)
+
+    result, err := db.MergeMembers("old@example.org", "new@example.org")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.FinalStatus != StatusSuspended {
+        t.Errorf("final status = %s, want suspended", result.FinalStatus)
+    }
+
+    m := db.member("new@example.org")
+    if want := []string{"volunteer", ProtectedTag}; !reflect.DeepEqual(m.Tags, want) {
+        t.Errorf("tags = %v, want %v", m.Tags, want)
+    }
+    if protected, _ := db.IsProtected("new@example.org"); !protected {
+        t.Error("merging a protected duplicate left the survivor unprotected")
+    }
+    if m.Notes.String != "board candidate\n\nprefers post" {
+        t.Errorf("notes = %q", m.Notes.String)
+    }
+    if m.DiscordID.String != "1234" {
+        t.Errorf("discord_id = %q", m.DiscordID.String)
+    }
+    if !m.FirstPaymentAt.Time.Equal(early) || !m.LastPaymentAt.Time.Equal(late) {
+        t.Errorf("payments = %v to %v, want %v to %v", m.FirstPaymentAt.Time, m.LastPaymentAt.Time, early, late)
+    }
+    if count, _ := db.FailedPaymentCount("new@example.org"); count != 2 {
+        t.Errorf("failed payments = %d, want 2", count)
+    }
+}
+
+func TestMergeNormalizesEmails(t *testing.T) {
+    db := newMemStore()
+    db.NormalizeEmails = true
+    seedMembers(t, db, map[string]string{"ada.lovelace@gmail.com": StatusCancelled, "ada@example.org": StatusActive})
+
+    result, err := db.MergeMembers("Ada.Love.lace+news@googlemail.com", " ADA@example.org")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.FromEmail != "adalovelace@gmail.com" || result.ToEmail != "ada@example.org" {
+        t.Errorf("merged %s into %s", result.FromEmail, result.ToEmail)
+    }
+
+    _, err = db.MergeMembers("ada+news@example.org", "ada@example.org")
+    if !errors.Is(err, ErrInvalidMerge) {
+        t.Errorf("merging a member into itself: got %v", err)
+    }
+}
+
+// failingMergeStore is a Store whose merges fail the way a lost database would
+type failingMergeStore struct {
+    *memStore
+}
+
+func (failingMergeStore) MergeMembers(fromEmail, toEmail string) (*MergeResult, error) {
+    return nil, errors.New("connection reset")
+}
+
+func TestMergeHandlerErrors(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+    expectStatus(t, do(t, server, "POST", "/members/merge", testAdminToken, `{"from":"ada@example.org","to":"ADA@example.org"}`), http.StatusUnprocessableEntity)
+    expectStatus(t, do(t, server, "POST", "/members/merge", testAdminToken, `{"from":"","to":"ada@example.org"}`), http.StatusUnprocessableEntity)
+
+    s := NewWebhookServer(failingMergeStore{newMemStore()}, testConfig(), log.New(io.Discard, "", 0))
+    s.routes()
+    failing := httptest.NewServer(s.mux)
+    t.Cleanup(func() {
+        failing.Close()
+        s.accessLog.close()
+    })
+    expectStatus(t, do(t, failing, "POST", "/members/merge", testAdminToken, `{"from":"old@example.org","to":"new@example.org"}`), http.StatusInternalServerError)
+}
@@ -0,0 +1,2 @@
+-- Preserve the address exactly as received when emails are normalized
+ALTER TABLE members ADD COLUMN IF NOT EXISTS raw_email VARCHAR(255);
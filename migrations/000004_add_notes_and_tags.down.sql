@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_members_tags;
+ALTER TABLE members DROP COLUMN IF EXISTS tags;
+ALTER TABLE members DROP COLUMN IF EXISTS notes;
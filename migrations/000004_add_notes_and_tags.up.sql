@@ -0,0 +1,5 @@
+-- Free-text annotations and tags for the membership coordinator
+ALTER TABLE members ADD COLUMN IF NOT EXISTS notes TEXT;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_members_tags ON members USING GIN (tags);
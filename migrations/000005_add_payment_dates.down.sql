@@ -0,0 +1,2 @@
+ALTER TABLE members DROP COLUMN IF EXISTS last_payment_at;
+ALTER TABLE members DROP COLUMN IF EXISTS first_payment_at;
@@ -0,0 +1,9 @@
+-- Track when members actually paid, not just when the row changed
+ALTER TABLE members ADD COLUMN IF NOT EXISTS first_payment_at TIMESTAMP;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS last_payment_at TIMESTAMP;
+
+-- Best-effort backfill: active members last paid when they were last updated
+UPDATE members SET
+    first_payment_at = first_seen,
+    last_payment_at = last_updated
+WHERE status = 'active' AND last_payment_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE status_history DROP COLUMN IF EXISTS reason;
+ALTER TABLE members DROP COLUMN IF EXISTS frequency;
@@ -0,0 +1,5 @@
+-- Recurring frequency drives lapse detection
+ALTER TABLE members ADD COLUMN IF NOT EXISTS frequency VARCHAR(50);
+
+-- Why a status changed, for automated transitions like lapsing
+ALTER TABLE status_history ADD COLUMN IF NOT EXISTS reason TEXT;
@@ -0,0 +1,24 @@
+-- Each applied clean run, so it can be audited and undone
+CREATE TABLE IF NOT EXISTS sync_runs (
+    id SERIAL PRIMARY KEY,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    input_file TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    added INTEGER NOT NULL DEFAULT 0,
+    reactivated INTEGER NOT NULL DEFAULT 0,
+    deactivated INTEGER NOT NULL DEFAULT 0,
+    undone_at TIMESTAMP
+);
+
+-- Before/after status of every member a run touched
+CREATE TABLE IF NOT EXISTS sync_run_changes (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL REFERENCES sync_runs(id) ON DELETE CASCADE,
+    member_id INTEGER REFERENCES members(id) ON DELETE SET NULL,
+    email VARCHAR(255) NOT NULL,
+    before_status VARCHAR(20),
+    after_status VARCHAR(20) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_run_changes_run_id ON sync_run_changes(run_id);
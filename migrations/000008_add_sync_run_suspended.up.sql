@@ -0,0 +1,2 @@
+-- Clean suspends members whose recurring payment failed
+ALTER TABLE sync_runs ADD COLUMN IF NOT EXISTS suspended INTEGER NOT NULL DEFAULT 0;
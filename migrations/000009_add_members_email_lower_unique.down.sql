@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_members_email_lower;
@@ -0,0 +1,3 @@
+-- Prevent rows that differ only by case. Existing duplicates must be
+-- consolidated first with: memberships dedupe --merge
+CREATE UNIQUE INDEX IF NOT EXISTS idx_members_email_lower ON members (lower(email));
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_webhook_logs_state;
+ALTER TABLE webhook_logs DROP COLUMN IF EXISTS last_error;
+ALTER TABLE webhook_logs DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE webhook_logs DROP COLUMN IF EXISTS attempts;
+ALTER TABLE webhook_logs DROP COLUMN IF EXISTS state;
@@ -0,0 +1,9 @@
+-- Webhooks whose processing failed transiently are retried from their log row.
+-- state is NULL for webhooks processed normally, otherwise pending, done, or failed.
+ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS state VARCHAR(20);
+ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP;
+ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS last_error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_logs_state ON webhook_logs(state, next_attempt_at)
+    WHERE state IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE members DROP COLUMN IF EXISTS discord_id;
@@ -0,0 +1,2 @@
+-- Discord user ID linked to a member, for role synchronization
+ALTER TABLE members ADD COLUMN IF NOT EXISTS discord_id VARCHAR(32);
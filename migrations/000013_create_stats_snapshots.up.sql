@@ -0,0 +1,11 @@
+-- One row of member counts per day, for trends over time
+CREATE TABLE IF NOT EXISTS stats_snapshots (
+    snapshot_date DATE PRIMARY KEY,
+    total INTEGER NOT NULL,
+    active INTEGER NOT NULL,
+    cancelled INTEGER NOT NULL,
+    suspended INTEGER NOT NULL,
+    lapsed INTEGER NOT NULL,
+    anonymous INTEGER NOT NULL,
+    taken_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
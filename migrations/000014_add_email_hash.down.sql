@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_members_email_hash;
+ALTER TABLE members DROP COLUMN IF EXISTS email_hash;
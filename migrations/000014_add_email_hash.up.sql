@@ -0,0 +1,8 @@
+-- SHA-256 of the stored (normalized) email, for lookups that never see the
+-- plaintext address. Must match emailHash() in Go. Forgotten members keep none.
+ALTER TABLE members ADD COLUMN IF NOT EXISTS email_hash VARCHAR(64);
+
+UPDATE members SET email_hash = encode(sha256(convert_to(email, 'UTF8')), 'hex')
+WHERE email_hash IS NULL AND email NOT LIKE 'forgotten-%@redacted.invalid';
+
+CREATE INDEX IF NOT EXISTS idx_members_email_hash ON members(email_hash);
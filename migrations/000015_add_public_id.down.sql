@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_members_public_id;
+ALTER TABLE members DROP COLUMN IF EXISTS public_id;
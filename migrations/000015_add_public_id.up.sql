@@ -0,0 +1,5 @@
+-- Stable external handle for members; internal joins keep using id.
+-- The volatile default gives every existing row its own UUID.
+ALTER TABLE members ADD COLUMN IF NOT EXISTS public_id UUID NOT NULL DEFAULT gen_random_uuid();
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_members_public_id ON members(public_id);
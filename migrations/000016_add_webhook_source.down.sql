@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_webhook_logs_source;
+ALTER TABLE status_history DROP COLUMN IF EXISTS source;
+ALTER TABLE webhook_logs DROP COLUMN IF EXISTS source;
@@ -0,0 +1,6 @@
+-- Which configured webhook secret (WEBHOOK_SECRET_<NAME>) authenticated a
+-- request, on its log row and on the history it produced
+ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS source VARCHAR(64);
+ALTER TABLE status_history ADD COLUMN IF NOT EXISTS source VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_logs_source ON webhook_logs(source, received_at);
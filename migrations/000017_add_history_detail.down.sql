@@ -0,0 +1,3 @@
+ALTER TABLE status_history ALTER COLUMN source DROP NOT NULL;
+ALTER TABLE status_history ALTER COLUMN source DROP DEFAULT;
+ALTER TABLE status_history DROP COLUMN IF EXISTS detail;
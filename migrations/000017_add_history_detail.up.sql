@@ -0,0 +1,26 @@
+-- Every status change records what made it (source) and the specifics
+-- (detail): webhook log id, sync run, operator note, lapse reason, ...
+ALTER TABLE status_history ADD COLUMN IF NOT EXISTS detail TEXT;
+
+-- Recover what we can from the free-text reasons written so far
+UPDATE status_history SET source = 'clean', detail = reason
+WHERE source IS NULL AND reason LIKE 'clean sync run #%';
+
+UPDATE status_history SET source = 'undo', detail = reason
+WHERE source IS NULL AND reason LIKE 'undo of sync run #%';
+
+UPDATE status_history SET source = 'manual', detail = NULLIF(regexp_replace(reason, '^source=manual:? ?', ''), '')
+WHERE source IS NULL AND reason LIKE 'source=manual%';
+
+UPDATE status_history SET source = 'lapse', detail = reason
+WHERE source IS NULL AND reason LIKE 'no % payment since %';
+
+-- Named webhook sources recorded before this migration
+UPDATE status_history SET source = CASE WHEN source = 'default' THEN 'webhook' ELSE 'webhook:' || source END
+WHERE source IS NOT NULL AND source NOT IN ('clean', 'undo', 'manual', 'lapse');
+
+UPDATE status_history SET source = 'unknown', detail = COALESCE(detail, reason)
+WHERE source IS NULL;
+
+ALTER TABLE status_history ALTER COLUMN source SET DEFAULT 'unknown';
+ALTER TABLE status_history ALTER COLUMN source SET NOT NULL;
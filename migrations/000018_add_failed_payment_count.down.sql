@@ -0,0 +1 @@
+ALTER TABLE members DROP COLUMN IF EXISTS failed_payment_count;
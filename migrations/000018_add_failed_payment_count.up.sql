@@ -0,0 +1,3 @@
+-- Consecutive failed payments since the last success. A failure suspends the
+-- member; FAILED_PAYMENT_LIMIT in a row cancels them.
+ALTER TABLE members ADD COLUMN IF NOT EXISTS failed_payment_count INTEGER NOT NULL DEFAULT 0;
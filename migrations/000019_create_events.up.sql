@@ -0,0 +1,15 @@
+-- Chronological feed of everything that changes membership data. Rows are
+-- written in the same transaction as the change they describe; the id is the
+-- polling cursor.
+CREATE TABLE IF NOT EXISTS events (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    member_id INTEGER REFERENCES members(id) ON DELETE SET NULL,
+    source VARCHAR(100) NOT NULL DEFAULT 'unknown',
+    detail TEXT,
+    payload JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_type ON events(type, id);
+CREATE INDEX IF NOT EXISTS idx_events_member_id ON events(member_id);
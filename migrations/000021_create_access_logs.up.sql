@@ -0,0 +1,14 @@
+-- Who read member data through the API, for donor-data compliance. Written
+-- asynchronously by the server; pruned by ACCESS_LOG_RETENTION.
+CREATE TABLE IF NOT EXISTS access_logs (
+    id BIGSERIAL PRIMARY KEY,
+    accessed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    client_ip VARCHAR(64),
+    principal VARCHAR(100) NOT NULL,
+    route VARCHAR(200) NOT NULL,
+    path TEXT NOT NULL,
+    query TEXT,
+    status INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_logs_accessed_at ON access_logs(accessed_at);
@@ -0,0 +1,16 @@
+-- Each payment a member has made, from webhooks and CSV imports. external_id
+-- is the payment processor's transaction id when the source gives one, or a
+-- key derived from the donation otherwise, so re-imports don't double count.
+CREATE TABLE IF NOT EXISTS donations (
+    id SERIAL PRIMARY KEY,
+    member_id INTEGER NOT NULL REFERENCES members(id) ON DELETE CASCADE,
+    amount NUMERIC(12, 2) NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    frequency VARCHAR(50),
+    occurred_at TIMESTAMP NOT NULL,
+    source VARCHAR(100) NOT NULL,
+    external_id VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_donations_member_id ON donations(member_id, occurred_at);
@@ -0,0 +1,2 @@
+-- Refunded donations stay in the history but are left out of revenue
+ALTER TABLE donations ADD COLUMN IF NOT EXISTS refunded_at TIMESTAMP;
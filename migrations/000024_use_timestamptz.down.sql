@@ -0,0 +1,27 @@
+ALTER TABLE members ALTER COLUMN first_seen DROP DEFAULT;
+ALTER TABLE members ALTER COLUMN first_seen TYPE DATE USING first_seen::date;
+ALTER TABLE members ALTER COLUMN first_seen SET DEFAULT CURRENT_DATE;
+
+ALTER TABLE donations
+    ALTER COLUMN occurred_at TYPE TIMESTAMP,
+    ALTER COLUMN created_at TYPE TIMESTAMP,
+    ALTER COLUMN refunded_at TYPE TIMESTAMP;
+ALTER TABLE access_logs ALTER COLUMN accessed_at TYPE TIMESTAMP;
+ALTER TABLE settings ALTER COLUMN updated_at TYPE TIMESTAMP;
+ALTER TABLE events ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE stats_snapshots ALTER COLUMN taken_at TYPE TIMESTAMP;
+ALTER TABLE subscriptions
+    ALTER COLUMN disabled_at TYPE TIMESTAMP,
+    ALTER COLUMN created_at TYPE TIMESTAMP;
+ALTER TABLE sync_runs
+    ALTER COLUMN started_at TYPE TIMESTAMP,
+    ALTER COLUMN finished_at TYPE TIMESTAMP,
+    ALTER COLUMN undone_at TYPE TIMESTAMP;
+ALTER TABLE webhook_logs
+    ALTER COLUMN received_at TYPE TIMESTAMP,
+    ALTER COLUMN next_attempt_at TYPE TIMESTAMP;
+ALTER TABLE status_history ALTER COLUMN changed_at TYPE TIMESTAMP;
+ALTER TABLE members
+    ALTER COLUMN last_updated TYPE TIMESTAMP,
+    ALTER COLUMN first_payment_at TYPE TIMESTAMP,
+    ALTER COLUMN last_payment_at TYPE TIMESTAMP;
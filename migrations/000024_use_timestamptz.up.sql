@@ -0,0 +1,43 @@
+-- Timestamps were stored without a zone, in the database server's local
+-- time. Convert them to timestamptz, reading existing values in the
+-- session's zone (the server default when run through migrate.sh).
+ALTER TABLE members
+    ALTER COLUMN last_updated TYPE TIMESTAMPTZ,
+    ALTER COLUMN first_payment_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN last_payment_at TYPE TIMESTAMPTZ;
+ALTER TABLE status_history ALTER COLUMN changed_at TYPE TIMESTAMPTZ;
+ALTER TABLE webhook_logs
+    ALTER COLUMN received_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN next_attempt_at TYPE TIMESTAMPTZ;
+ALTER TABLE sync_runs
+    ALTER COLUMN started_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN finished_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN undone_at TYPE TIMESTAMPTZ;
+ALTER TABLE subscriptions
+    ALTER COLUMN disabled_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE stats_snapshots ALTER COLUMN taken_at TYPE TIMESTAMPTZ;
+ALTER TABLE events ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE settings ALTER COLUMN updated_at TYPE TIMESTAMPTZ;
+ALTER TABLE access_logs ALTER COLUMN accessed_at TYPE TIMESTAMPTZ;
+ALTER TABLE donations
+    ALTER COLUMN occurred_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN refunded_at TYPE TIMESTAMPTZ;
+
+-- first_seen was a DATE, so members who joined in the evening west of the
+-- server got the next day. Make it a timestamp and recover the time of day
+-- from the member's first status change on that date, where there is one.
+ALTER TABLE members ALTER COLUMN first_seen DROP DEFAULT;
+ALTER TABLE members ALTER COLUMN first_seen TYPE TIMESTAMPTZ USING first_seen::timestamp;
+ALTER TABLE members ALTER COLUMN first_seen SET DEFAULT CURRENT_TIMESTAMP;
+
+UPDATE members m SET first_seen = h.first_change
+FROM (
+    SELECT member_id, MIN(changed_at) AS first_change
+    FROM status_history
+    GROUP BY member_id
+) h
+WHERE h.member_id = m.id
+AND h.first_change >= m.first_seen
+AND h.first_change < m.first_seen + INTERVAL '1 day';
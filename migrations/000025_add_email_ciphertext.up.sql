@@ -0,0 +1,3 @@
+-- In PRIVACY_MODE=encrypt, members.email holds an HMAC of the address and
+-- the address itself is kept here, encrypted with EMAIL_ENCRYPTION_KEY
+ALTER TABLE members ADD COLUMN IF NOT EXISTS email_ciphertext TEXT;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_members_unsubscribe_token;
+
+ALTER TABLE members DROP COLUMN IF EXISTS unsubscribe_token;
+ALTER TABLE members DROP COLUMN IF EXISTS consent_source;
+ALTER TABLE members DROP COLUMN IF EXISTS consent_recorded_at;
+ALTER TABLE members DROP COLUMN IF EXISTS email_opt_in;
@@ -0,0 +1,9 @@
+-- Email consent is separate from membership. email_opt_in stays NULL until
+-- a webhook, an admin, or an unsubscribe link records a choice; only false
+-- keeps a member out of mailings. unsubscribe_token backs the footer link.
+ALTER TABLE members ADD COLUMN IF NOT EXISTS email_opt_in BOOLEAN;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS consent_recorded_at TIMESTAMPTZ;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS consent_source VARCHAR(100);
+ALTER TABLE members ADD COLUMN IF NOT EXISTS unsubscribe_token UUID NOT NULL DEFAULT gen_random_uuid();
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_members_unsubscribe_token ON members(unsubscribe_token);
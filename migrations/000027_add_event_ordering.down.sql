@@ -0,0 +1,2 @@
+ALTER TABLE webhook_logs DROP COLUMN IF EXISTS skip_reason;
+ALTER TABLE members DROP COLUMN IF EXISTS last_event_at;
@@ -0,0 +1,5 @@
+-- last_event_at is the newest webhook event_time applied to a member, so a
+-- late delivery of an older event can be skipped. skip_reason says why a
+-- logged webhook was deliberately not applied (e.g. "stale").
+ALTER TABLE members ADD COLUMN IF NOT EXISTS last_event_at TIMESTAMPTZ;
+ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS skip_reason VARCHAR(32);
@@ -0,0 +1,24 @@
+-- Webhooks that couldn't be fully processed, kept for operator review.
+-- body is TEXT rather than JSONB because unparseable bodies are kept too.
+-- state is open until an operator retries or dismisses the failure.
+CREATE TABLE IF NOT EXISTS failed_webhooks (
+    id SERIAL PRIMARY KEY,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    webhook_log_id INTEGER REFERENCES webhook_logs(id) ON DELETE SET NULL,
+    source VARCHAR(64),
+    source_ip VARCHAR(64),
+    headers JSONB,
+    body TEXT NOT NULL,
+    error_class VARCHAR(32) NOT NULL,
+    error TEXT,
+    state VARCHAR(20) NOT NULL DEFAULT 'open',
+    resolved_at TIMESTAMPTZ,
+    resolved_by VARCHAR(100),
+    resolution_note TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_failed_webhooks_state ON failed_webhooks(state, received_at);
+
+-- One open failure per logged webhook, however often its retries fail
+CREATE UNIQUE INDEX IF NOT EXISTS idx_failed_webhooks_open_log ON failed_webhooks(webhook_log_id)
+    WHERE state = 'open';
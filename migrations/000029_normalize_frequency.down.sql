@@ -0,0 +1,3 @@
+UPDATE members SET frequency = frequency_raw WHERE frequency_raw IS NOT NULL;
+
+ALTER TABLE members DROP COLUMN IF EXISTS frequency_raw;
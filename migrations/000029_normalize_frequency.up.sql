@@ -0,0 +1,15 @@
+-- frequency now holds monthly, quarterly, annual, or other so it groups
+-- cleanly; frequency_raw keeps the value as the source sent it
+ALTER TABLE members ADD COLUMN IF NOT EXISTS frequency_raw VARCHAR(50);
+
+UPDATE members SET
+    frequency_raw = frequency,
+    frequency = CASE
+        WHEN lower(frequency) LIKE '%month%' THEN 'monthly'
+        WHEN lower(frequency) LIKE '%quarter%' THEN 'quarterly'
+        WHEN lower(frequency) LIKE '%annual%' OR lower(frequency) LIKE '%year%' THEN 'annual'
+        ELSE 'other'
+    END
+WHERE btrim(COALESCE(frequency, '')) <> '' AND frequency_raw IS NULL;
+
+UPDATE members SET frequency = NULL WHERE btrim(frequency) = '';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_members_household_id;
+
+ALTER TABLE members DROP COLUMN IF EXISTS household_id;
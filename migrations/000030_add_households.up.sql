@@ -0,0 +1,6 @@
+-- Households: household_id is the id of the household's primary member,
+-- whose status the other members follow. The primary holds its own id;
+-- members not in a household hold NULL.
+ALTER TABLE members ADD COLUMN IF NOT EXISTS household_id INTEGER REFERENCES members(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_members_household_id ON members(household_id) WHERE household_id IS NOT NULL;
@@ -0,0 +1,22 @@
+-- Organizational memberships. Every member listed in org_members verifies
+-- as active while their organization is, whatever their own status; the
+-- organization's status never overwrites theirs.
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(50) NOT NULL DEFAULT 'active',
+    tier VARCHAR(100),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_name_lower ON organizations(lower(name));
+
+CREATE TABLE IF NOT EXISTS org_members (
+    organization_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    member_id INTEGER NOT NULL REFERENCES members(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, member_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_member_id ON org_members(member_id);
@@ -0,0 +1,12 @@
+-- Payment statuses no STATUS_RULES rule matched, so the rules can be
+-- extended. mapped_to, set with "memberships statuses map", maps the exact
+-- status (case-insensitively) without a config change.
+CREATE TABLE IF NOT EXISTS unmapped_statuses (
+    payment_status VARCHAR(255) PRIMARY KEY,
+    seen INTEGER NOT NULL DEFAULT 0,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    mapped_to VARCHAR(50),
+    mapped_at TIMESTAMPTZ,
+    mapped_by VARCHAR(255)
+);
@@ -0,0 +1,4 @@
+DELETE FROM unmapped_statuses WHERE kind <> 'status';
+ALTER TABLE unmapped_statuses DROP CONSTRAINT IF EXISTS unmapped_statuses_pkey;
+ALTER TABLE unmapped_statuses ADD PRIMARY KEY (payment_status);
+ALTER TABLE unmapped_statuses DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,5 @@
+-- Frequencies no synonym covers are kept for review alongside unmapped
+-- payment statuses
+ALTER TABLE unmapped_statuses ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'status';
+ALTER TABLE unmapped_statuses DROP CONSTRAINT IF EXISTS unmapped_statuses_pkey;
+ALTER TABLE unmapped_statuses ADD PRIMARY KEY (kind, payment_status);
@@ -0,0 +1,18 @@
+-- Welcome and cancellation emails to members. A row is queued once per
+-- member, kind, and transition, so a repeated webhook never sends twice;
+-- the server's mail worker sends pending rows and backs off on failure.
+CREATE TABLE IF NOT EXISTS member_emails (
+    id SERIAL PRIMARY KEY,
+    member_id INTEGER NOT NULL REFERENCES members(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    transition VARCHAR(100) NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMPTZ,
+    UNIQUE (member_id, kind, transition)
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_emails_due ON member_emails(next_attempt_at) WHERE state = 'pending';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_members_campaign;
+ALTER TABLE donations DROP COLUMN IF EXISTS campaign;
+ALTER TABLE members DROP COLUMN IF EXISTS campaign;
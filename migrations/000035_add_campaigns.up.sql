@@ -0,0 +1,8 @@
+-- The fundraising campaign a member joined through, taken from their first
+-- record and never changed after, and the campaign each donation came from.
+-- Members from before campaigns were recorded have none and report as
+-- "unknown".
+ALTER TABLE members ADD COLUMN IF NOT EXISTS campaign VARCHAR(255);
+ALTER TABLE donations ADD COLUMN IF NOT EXISTS campaign VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_members_campaign ON members(lower(campaign));
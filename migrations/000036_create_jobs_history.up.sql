@@ -0,0 +1,14 @@
+-- One row per run of a background job, scheduled or started by an admin,
+-- with how long it took and any error. Pruned after JOB_HISTORY_RETENTION.
+CREATE TABLE IF NOT EXISTS jobs_history (
+    id BIGSERIAL PRIMARY KEY,
+    job VARCHAR(50) NOT NULL,
+    triggered_by VARCHAR(20) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    result TEXT,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_history_job ON jobs_history(job, started_at DESC);
@@ -0,0 +1,26 @@
+-- Status changes clean and reconcile proposed with --review, kept until an
+-- admin approves or rejects them or they pass expires_at. from_status is
+-- the member's status when proposed (NULL for a member to add), so approval
+-- can skip members who have changed since.
+CREATE TABLE IF NOT EXISTS pending_changes (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    source TEXT NOT NULL,
+    frequency VARCHAR(50),
+    campaign TEXT,
+    paid_at TIMESTAMPTZ,
+    proposed_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ,
+    state VARCHAR(20) NOT NULL DEFAULT 'pending',
+    decided_at TIMESTAMPTZ,
+    decided_by TEXT,
+    note TEXT,
+    sync_run_id INTEGER REFERENCES sync_runs(id) ON DELETE SET NULL
+);
+
+-- A later review run replaces a member's pending change rather than adding
+-- a second one
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pending_changes_email ON pending_changes(email) WHERE state = 'pending';
@@ -0,0 +1 @@
+ALTER TABLE members DROP COLUMN IF EXISTS last_received_at;
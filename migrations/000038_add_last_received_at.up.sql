@@ -0,0 +1,5 @@
+-- last_received_at is when the newest webhook delivery applied to a member
+-- arrived. A retried delivery without an event_time that arrived earlier is
+-- skipped as stale. It's kept apart from last_event_at, which only holds
+-- sender-supplied event times.
+ALTER TABLE members ADD COLUMN IF NOT EXISTS last_received_at TIMESTAMPTZ;
@@ -2,14 +2,131 @@ package main
 
 import (
     "database/sql"
+    "net"
+    "os"
     "time"
 )
 
 // Config holds application configuration
 type Config struct {
-    DatabaseURL   string
-    Port          string
-    WebhookSecret string
+    DatabaseURL     string
+    Port            string
+
+    // ListenSocket replaces the TCP port with a Unix domain socket
+    ListenSocket     string
+    ListenSocketMode os.FileMode
+
+    // PublicURL is where members reach the server, with no trailing slash;
+    // links in member emails point under it
+    PublicURL string
+
+    // RequestTimeout bounds each request (0 disables it), and
+    // MaxConcurrentRequests caps in-flight requests per route group
+    RequestTimeout        time.Duration
+    MaxConcurrentRequests int
+
+    // TrustedProxies are the peers whose X-Forwarded-For and X-Real-IP
+    // headers are believed
+    TrustedProxies []*net.IPNet
+
+    // DebugEndpoints mounts pprof and runtime stats under /debug, for admins
+    DebugEndpoints bool
+
+    // WebhookLogRetention, AccessLogRetention, and JobHistoryRetention are
+    // how long log rows are kept before pruning; 0 keeps them forever
+    WebhookLogRetention time.Duration
+    AccessLogRetention  time.Duration
+    JobHistoryRetention time.Duration
+
+    // PendingChangeTTL is how long changes queued for review can be
+    // approved; 0 means they never expire
+    PendingChangeTTL time.Duration
+
+    // PendingMaxDeactivatePercent is the largest share of active members
+    // approving every pending change may deactivate without forcing it
+    PendingMaxDeactivatePercent int
+
+    // DisplayZone is the zone CLI output and reports show times in
+    DisplayZone *time.Location
+
+    // WebhookSources maps a source name to its secrets: "default" for
+    // WEBHOOK_SECRET, and the lowercased suffix of each WEBHOOK_SECRET_<NAME>.
+    // Any listed secret is accepted, so a secret can be rotated without
+    // downtime; AdminTokens work the same way.
+    WebhookSources  map[string][]string
+    AdminTokens     []string
+    WebhookFailHard bool
+    NormalizeEmails bool
+    
+    // Privacy, if set, stores emails as keyed hashes (PRIVACY_MODE)
+    Privacy *EmailPrivacy
+    
+    // StrictTransitions rejects disallowed status transitions rather than
+    // logging a warning (STATUS_TRANSITIONS=reject)
+    StrictTransitions bool
+    
+    // CountHouseholds counts a linked household as one member in stats
+    // and /verify (MEMBER_COUNTING=households)
+    CountHouseholds bool
+    
+    LapseInterval   time.Duration
+    LapseGraceDays  int
+    
+    // FailedPaymentLimit is how many consecutive failed payments cancel a
+    // member; fewer leave them suspended
+    FailedPaymentLimit int
+    
+    // StatusRules map payment statuses to member statuses, first match
+    // wins. With StrictStatus a payment status no rule matches rejects the
+    // webhook for review; otherwise the member gets StatusFallback.
+    StatusRules    []StatusRule
+    StrictStatus   bool
+    StatusFallback string
+
+    // NotifyWebhookURL is a Slack-compatible incoming webhook for member events
+    NotifyWebhookURL string
+    NotifyEvents     []string
+
+    // Mailchimp audience sync
+    MailchimpAPIKey string
+    MailchimpListID string
+
+    // Membership verification for other services: token -> caller name,
+    // the statuses that count as active, and requests per caller per minute
+    VerifyTokens    map[string]string
+    VerifyStatuses  []string
+    VerifyRateLimit int
+
+    // Scheduled clean from a URL or a directory of CSV drops
+    SyncSource   string
+    SyncInterval time.Duration
+
+    // Stripe reconciliation
+    StripeAPIKey string
+
+    // Discord role sync
+    DiscordBotToken     string
+    DiscordGuildID      string
+    DiscordRoleID       string
+    DiscordSyncInterval time.Duration
+
+    // Outgoing email, for the daily digest and member emails
+    SMTPHost string
+    SMTPPort int
+    SMTPUser string
+    SMTPPass string
+    SMTPFrom string
+
+    // Daily digest recipients and the time of day (since midnight in the
+    // display zone) the server sends it
+    DigestTo   []string
+    DigestTime time.Duration
+
+    // Emails to members on joining and cancelling, with templates from
+    // <kind>.txt in MemberEmailTemplates overriding the built-in ones
+    MemberEmailWelcome      bool
+    MemberEmailCancellation bool
+    MemberEmailTemplates    string
 }
 
 // MemberWebhook represents the incoming webhook payload from Zapier
@@ -18,23 +135,130 @@ type MemberWebhook struct {
     Name      string `json:"name"`
     Status    string `json:"status"`    // Zapier sends "Succeeded", "Failed", etc.
     Anonymous string `json:"anonymous"` // Zapier sends "True", "False" as strings
+    Frequency string `json:"frequency"` // Optional: "Monthly", "Annual", etc.
+    EventTime string `json:"event_time"` // Optional: when the payment event happened
+    
+    // Optional payment details, recorded in the member's donation history
+    Amount     looseString `json:"amount"`      // "50.00" or 50
+    Currency   string      `json:"currency"`    // Defaults to USD
+    DonationID string      `json:"donation_id"` // Processor transaction id, for dedupe
+    
+    // Optional email consent from the donation form, "True" or "False";
+    // absent leaves the member's recorded choice alone
+    EmailOptIn string `json:"email_opt_in"`
+    
+    // Optional organization the payment is for; the status then applies to
+    // the organization, and the email is attached to it as a contact
+    Organization string `json:"organization"`
+    
+    // Optional fundraising campaign; also read from "campaign_name" and
+    // the other campaignColumnAliases
+    Campaign string `json:"campaign"`
 }
 
 // Member represents a member in the database
 type Member struct {
-    ID          int
-    Email       string
-    Name        sql.NullString
-    IsAnonymous bool
-    Status      string
-    FirstSeen   time.Time
-    LastUpdated time.Time
+    ID             int
+    PublicID       string
+    Email          string
+    RawEmail       sql.NullString
+    Name           sql.NullString
+    IsAnonymous    bool
+    Status         string
+    Notes          sql.NullString
+    Tags           []string
+    FirstSeen      time.Time
+    LastUpdated    time.Time
+    FirstPaymentAt sql.NullTime
+    LastPaymentAt  sql.NullTime
+    Frequency      sql.NullString
+    DiscordID      sql.NullString
+    
+    // EmailOptIn is the member's email consent, NULL if never recorded
+    EmailOptIn        sql.NullBool
+    ConsentRecordedAt sql.NullTime
+    ConsentSource     sql.NullString
+    UnsubscribeToken  string
+    
+    // Organization is an active organization the member belongs to, filled
+    // in by GetMembers and EachMember
+    Organization sql.NullString
+    
+    // Campaign is the fundraising campaign the member joined through
+    Campaign sql.NullString
+}
+
+// MemberFilter narrows the members returned by GetMembers
+type MemberFilter struct {
+    // Status "active" also matches members of an active organization
+    Status    string
+    Tag       string
+    Frequency string
+    
+    // Campaign matches case-insensitively; "unknown" matches members
+    // without one
+    Campaign string
+    
+    Limit  int
+    Offset int
 }
 
 // Stats represents membership statistics
 type Stats struct {
-    TotalMembers     int `json:"total_members"`
-    ActiveMembers    int `json:"active_members"`
-    CancelledMembers int `json:"cancelled_members"`
-    AnonymousMembers int `json:"anonymous_members"`
+    TotalMembers          int `json:"total_members"`
+    ActiveMembers         int `json:"active_members"`
+    CancelledMembers      int `json:"cancelled_members"`
+    SuspendedMembers      int `json:"suspended_members"`
+    LapsedMembers         int `json:"lapsed_members"`
+    UnknownStatusMembers  int `json:"unknown_status_members"`
+    AnonymousMembers      int `json:"anonymous_members"`
+    OverduePaymentMembers int `json:"active_no_payment_90_days"`
+    FailedPaymentMembers  int `json:"members_with_failed_payments"`
+    
+    // CountedBy is "individuals", or "households" when a linked household
+    // counts once (MEMBER_COUNTING)
+    CountedBy string `json:"counted_by"`
+    
+    // OtherStatuses counts members whose status isn't one of the known
+    // ones, so the parts always add up to the total
+    OtherStatuses map[string]int `json:"other_statuses,omitempty"`
+    
+    // ActiveByFrequency counts active members per canonical frequency, with
+    // "unknown" for those without one
+    ActiveByFrequency map[string]int `json:"active_by_frequency"`
+    
+    // WebhooksBySource counts webhooks received in the last 30 days per source
+    WebhooksBySource map[string]int `json:"webhooks_by_source_30_days"`
+
+    // LastSync is the server's most recent scheduled sync, if any
+    LastSync *SyncStatus `json:"last_sync,omitempty"`
+    
+    // Revenue is filled in for detailed stats, one entry per currency
+    Revenue []RevenueStats `json:"revenue,omitempty"`
+    
+    // Campaigns is filled in for detailed stats, largest first
+    Campaigns []CampaignStats `json:"campaigns,omitempty"`
+}
+
+// addStatusCount adds count members with status to the total and to that
+// status's count, or to OtherStatuses for a status that isn't a known one
+func (s *Stats) addStatusCount(status string, count int) {
+    s.TotalMembers += count
+    switch status {
+    case StatusActive:
+        s.ActiveMembers += count
+    case StatusCancelled:
+        s.CancelledMembers += count
+    case StatusSuspended:
+        s.SuspendedMembers += count
+    case StatusLapsed:
+        s.LapsedMembers += count
+    case StatusUnknown:
+        s.UnknownStatusMembers += count
+    default:
+        if s.OtherStatuses == nil {
+            s.OtherStatuses = map[string]int{}
+        }
+        s.OtherStatuses[status] += count
+    }
 }
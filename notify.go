@@ -0,0 +1,165 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Member events that can trigger a notification
+const (
+    eventCreated     = "created"
+    eventCancelled   = "cancelled"
+    eventReactivated = "reactivated"
+)
+
+// defaultNotifyEvents is used when NOTIFY_EVENTS is unset
+var defaultNotifyEvents = []string{eventCreated, eventCancelled, eventReactivated}
+
+// notifyTimeout bounds each notification so a slow chat API is abandoned
+const notifyTimeout = 10 * time.Second
+
+// MemberEvent describes a membership change worth announcing
+type MemberEvent struct {
+    Type        string
+    Email       string
+    Name        string
+    IsAnonymous bool
+}
+
+// Notifier posts member events to a Slack-compatible incoming webhook.
+// Discord accepts the same payload at its /slack webhook URL.
+type Notifier struct {
+    url    string
+    events map[string]bool
+    client *http.Client
+    logger *log.Logger
+}
+
+// NewNotifier returns a notifier for url, or nil when url is empty
+func NewNotifier(url string, events []string, logger *log.Logger) *Notifier {
+    if url == "" {
+        return nil
+    }
+
+    n := &Notifier{
+        url:    url,
+        events: make(map[string]bool),
+        client: &http.Client{Timeout: notifyTimeout},
+        logger: logger,
+    }
+    for _, event := range events {
+        n.events[event] = true
+    }
+
+    return n
+}
+
+// parseNotifyEvents validates a comma-separated NOTIFY_EVENTS value
+func parseNotifyEvents(value string) ([]string, error) {
+    if strings.TrimSpace(value) == "" {
+        return defaultNotifyEvents, nil
+    }
+
+    var events []string
+    for _, event := range strings.Split(value, ",") {
+        event = strings.ToLower(strings.TrimSpace(event))
+        switch event {
+        case eventCreated, eventCancelled, eventReactivated:
+            events = append(events, event)
+        case "":
+        default:
+            return nil, fmt.Errorf("unknown event %q (use created, cancelled, reactivated)", event)
+        }
+    }
+
+    return events, nil
+}
+
+// Message formats the announcement. Anonymous members are never identified.
+func (e MemberEvent) Message() string {
+    who := "an anonymous member"
+    if !e.IsAnonymous {
+        who = e.Email
+        if e.Name != "" {
+            who = fmt.Sprintf("%s (%s)", e.Name, e.Email)
+        }
+    }
+
+    switch e.Type {
+    case eventCreated:
+        return "New member: " + who
+    case eventCancelled:
+        return "Membership cancelled: " + who
+    case eventReactivated:
+        return "Membership reactivated: " + who
+    }
+
+    return fmt.Sprintf("Membership %s: %s", e.Type, who)
+}
+
+// Notify sends the event in the background if its type is enabled. It never
+// blocks the caller; failures are only logged. A nil Notifier does nothing.
+func (n *Notifier) Notify(event MemberEvent) {
+    if n == nil || !n.events[event.Type] {
+        return
+    }
+
+    go func() {
+        if err := n.send(event.Message()); err != nil {
+            n.logger.Printf("Warning: Failed to send %s notification: %v", event.Type, err)
+        }
+    }()
+}
+
+// send posts one message
+func (n *Notifier) send(text string) error {
+    // "text" is read by Slack, "content" by Discord
+    body, err := json.Marshal(map[string]string{"text": text, "content": text})
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := n.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("notification endpoint returned %s", resp.Status)
+    }
+
+    return nil
+}
+
+// memberEventFor classifies a webhook-driven change from the member's status
+// before and after processing; ok is false when nothing notable happened
+func memberEventFor(existed bool, previous, status string) (string, bool) {
+    switch {
+    case !existed:
+        return eventCreated, true
+    case previous == status:
+        return "", false
+    case status == "cancelled":
+        return eventCancelled, true
+    case status == "active":
+        return eventReactivated, true
+    }
+
+    return "", false
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
+)
+
+// legacyMemberSchema describes the member objects the unversioned routes
+// return. Those are built as maps by memberResponse; this type only exists
+// to generate their schema, so change it along with it.
+type legacyMemberSchema struct {
+    ID             string     `json:"id"`
+    Email          string     `json:"email"`
+    RawEmail       string     `json:"raw_email,omitempty"`
+    Name           string     `json:"name,omitempty"`
+    Status         string     `json:"status"`
+    IsAnonymous    bool       `json:"is_anonymous"`
+    Tags           []string   `json:"tags"`
+    Frequency      string     `json:"frequency,omitempty"`
+    Notes          string     `json:"notes,omitempty"`
+    DiscordID      string     `json:"discord_id,omitempty"`
+    FirstSeen      time.Time  `json:"first_seen"`
+    LastUpdated    time.Time  `json:"last_updated"`
+    FirstPaymentAt *time.Time `json:"first_payment_at,omitempty"`
+    LastPaymentAt  *time.Time `json:"last_payment_at,omitempty"`
+    Campaign       string     `json:"campaign,omitempty"`
+
+    EmailOptIn        *bool      `json:"email_opt_in"`
+    ConsentRecordedAt *time.Time `json:"consent_recorded_at,omitempty"`
+    ConsentSource     string     `json:"consent_source,omitempty"`
+
+    // Giving history, on the single-member endpoints only
+    Giving    []DonationTotal `json:"giving,omitempty"`
+    Donations []apiDonation   `json:"donations,omitempty"`
+}
+
+// openAPISchemas are the component schemas, generated from the Go types the
+// handlers encode and decode
+var openAPISchemas = map[string]interface{}{
+    "MemberWebhook":       MemberWebhook{},
+    "Member":              apiMember{},
+    "MemberPage":          apiMemberPage{},
+    "LegacyMember":        legacyMemberSchema{},
+    "Stats":               Stats{},
+    "StatsSnapshot":       StatsSnapshot{},
+    "RetentionCohort":     RetentionCohort{},
+    "Anniversary":         Anniversary{},
+    "StatusChange":        StatusChange{},
+    "WebhookLogEntry":     WebhookLogEntry{},
+    "FailedWebhook":       FailedWebhook{},
+    "UnmappedStatus":      UnmappedStatus{},
+    "StatusMapping":       statusMappingRequest{},
+    "StatusMappingResult": statusMappingResponse{},
+    "FeedEvent":           FeedEvent{},
+    "BuildInfo":           BuildInfo{},
+    "VerifyResponse":      verifyResponse{},
+    "MemberPatch":         memberPatch{},
+    "MemberCreate":        createMemberRequest{},
+    "MergeRequest":        mergeRequest{},
+    "BulkStatusRequest":   bulkStatusRequest{},
+    "BulkStatusResponse":  bulkStatusResponse{},
+    "MergeResult":         MergeResult{},
+    "WebhookResult":       ProcessResult{},
+    "HouseholdRequest":    householdRequest{},
+    "HouseholdLink":       HouseholdLink{},
+    "Organization":        Organization{},
+    "OrganizationRequest": organizationRequest{},
+    "OrganizationMember":  organizationMemberRequest{},
+    "OrganizationChange":  OrganizationChange{},
+    "ForgetResult":        ForgetResult{},
+    "SubjectAccessExport": SubjectAccessExport{},
+    "Subscription":        Subscription{},
+    "SubscriptionRequest": subscriptionRequest{},
+    "JobStatus":           JobStatus{},
+    "PendingChange":       PendingChange{},
+    "ReviewResult":        ReviewResult{},
+    "Error": struct {
+        Error apiError `json:"error"`
+    }{},
+}
+
+// openAPIRequired lists the required fields of request schemas whose
+// optional fields aren't tagged omitempty
+var openAPIRequired = map[string][]string{
+    "MemberWebhook": {"email", "status"},
+}
+
+var (
+    timeType       = reflect.TypeOf(time.Time{})
+    rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// jsonSchema builds a JSON Schema for t following encoding/json's rules:
+// json tags name fields, "-" hides them, and omitempty makes them optional
+func jsonSchema(t reflect.Type) map[string]interface{} {
+    switch t {
+    case timeType:
+        return map[string]interface{}{"type": "string", "format": "date-time"}
+    case rawMessageType:
+        return map[string]interface{}{}
+    }
+
+    switch t.Kind() {
+    case reflect.Pointer:
+        schema := jsonSchema(t.Elem())
+        schema["nullable"] = true
+        return schema
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return map[string]interface{}{"type": "integer"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.Slice:
+        // encoding/json writes a nil slice as null
+        return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem()), "nullable": true}
+    case reflect.Array:
+        return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+    case reflect.Struct:
+        properties := map[string]interface{}{}
+        var required []string
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field(i)
+            if !field.IsExported() {
+                continue
+            }
+            name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+            if name == "-" && opts == "" {
+                continue
+            }
+            if name == "" {
+                name = field.Name
+            }
+            properties[name] = jsonSchema(field.Type)
+            if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+                required = append(required, name)
+            }
+        }
+        schema := map[string]interface{}{"type": "object", "properties": properties}
+        if len(required) > 0 {
+            schema["required"] = required
+        }
+        return schema
+    }
+
+    return map[string]interface{}{}
+}
+
+// ref points at a component schema
+func ref(name string) map[string]interface{} {
+    return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonContent is a JSON request or response body of the given schema
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// operation describes one endpoint. body is the request schema, if any,
+// and result the 200 response schema; admin adds bearer authentication.
+func operation(summary string, admin bool, body, result map[string]interface{}, params ...map[string]interface{}) map[string]interface{} {
+    ok := map[string]interface{}{"description": "OK"}
+    if result != nil {
+        ok["content"] = jsonContent(result)
+    }
+    op := map[string]interface{}{
+        "summary":   summary,
+        "responses": map[string]interface{}{
+            "200":     ok,
+            "default": map[string]interface{}{"description": "Error", "content": jsonContent(ref("Error"))},
+        },
+    }
+    if body != nil {
+        op["requestBody"] = map[string]interface{}{"required": true, "content": jsonContent(body)}
+    }
+    if admin {
+        op["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+    }
+    if len(params) > 0 {
+        op["parameters"] = params
+    }
+    return op
+}
+
+// pathParam and queryParam describe string parameters
+func pathParam(name string) map[string]interface{} {
+    return map[string]interface{}{"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func queryParam(name, description string) map[string]interface{} {
+    return map[string]interface{}{"name": name, "in": "query", "description": description, "schema": map[string]interface{}{"type": "string"}}
+}
+
+func arrayOf(schema map[string]interface{}) map[string]interface{} {
+    return map[string]interface{}{"type": "array", "items": schema}
+}
+
+// openAPIReadPaths are the paths registered with handleRead
+var openAPIReadPaths = []string{
+    "/stats", "/stats/history", "/stats/retention", "/members", "/members/{email}", "/members/id/{id}", "/members/anniversaries", "/history/{email}", "/sar/{member}",
+    "/events", "/webhooks", "/webhooks/failed", "/statuses/unmapped", "/subscriptions", "/organizations",
+    "/pending-changes",
+}
+
+// openAPISpec builds the OpenAPI 3 document for the server's endpoints
+func openAPISpec() map[string]interface{} {
+    schemas := map[string]interface{}{}
+    for name, v := range openAPISchemas {
+        schema := jsonSchema(reflect.TypeOf(v))
+        if required, ok := openAPIRequired[name]; ok {
+            schema["required"] = required
+        }
+        schemas[name] = schema
+    }
+
+    object := map[string]interface{}{"type": "object"}
+    paths := map[string]interface{}{
+        "/health": map[string]interface{}{
+            "get": operation("Server and database health", false, nil, object),
+        },
+        "/version": map[string]interface{}{
+            "get": operation("Build information", false, nil, ref("BuildInfo")),
+        },
+        "/openapi.json": map[string]interface{}{
+            "get": operation("This document", false, nil, object),
+        },
+        "/webhook": map[string]interface{}{
+            "post": operation("Receive a payment event (WEBHOOK_SECRET as a bearer token, basic auth, or X-Webhook-Secret); 201 when it created the member, 202 when it was deferred or queued for retry; every 2xx has a WebhookResult whose result says what happened; X-Dry-Run: true or dry_run=1 reports what it would do without changing anything", false, ref("MemberWebhook"), ref("WebhookResult"),
+                queryParam("dry_run", "1 to check the webhook without applying it")),
+        },
+        "/stats": map[string]interface{}{
+            "get": operation("Membership statistics", false, nil, ref("Stats"),
+                queryParam("detailed", "1 to include revenue figures")),
+        },
+        "/stats/history": map[string]interface{}{
+            "get": operation("Daily statistics snapshots", false, nil, arrayOf(ref("StatsSnapshot")),
+                queryParam("days", "Days of history")),
+        },
+        "/stats/retention": map[string]interface{}{
+            "get": operation("Share of each monthly cohort active 1, 3, 6, and 12 months after joining", false, nil,
+                arrayOf(ref("RetentionCohort")), queryParam("months", "Number of monthly cohorts (default 12)")),
+        },
+        "/verify": map[string]interface{}{
+            "post": operation("Check whether an email belongs to an active member (VERIFY_TOKEN)", false,
+                map[string]interface{}{"type": "object", "properties": map[string]interface{}{"email": map[string]interface{}{"type": "string"}}},
+                ref("VerifyResponse")),
+        },
+        "/verify/hash/{hash}": map[string]interface{}{
+            "get": operation("Check membership by the hex SHA-256 of the email trimmed and lowercased; with EMAIL_NORMALIZATION also without +suffix and, at gmail.com or googlemail.com, without dots in the local part and with the domain gmail.com (memberships verify-hash prints it)",
+                false, nil, ref("VerifyResponse"), pathParam("hash")),
+        },
+        "/members": map[string]interface{}{
+            "get":  operation("List members, most recently updated first", false, nil, ref("MemberPage"),
+                queryParam("status", "Only members with this status"), queryParam("tag", "Only members with this tag"),
+                queryParam("frequency", "Only members with this frequency: monthly, quarterly, annual, or other"),
+                queryParam("campaign", "Only members who joined through this campaign; unknown for members without one"),
+                queryParam("format", "json (default), csv, or jsonl, which can also be asked for with Accept: text/csv or application/x-ndjson; CSV and JSON Lines are unpaged and need the admin token"),
+                queryParam("fields", "Comma-separated columns for csv and jsonl exports, e.g. id,email,tags; unknown names are rejected"),
+                queryParam("limit", "Page size (default 100, at most 1000)"), queryParam("offset", "Members to skip")),
+            "post": operation("Add a member; 409 if they exist unless upsert is set", true, ref("MemberCreate"), ref("LegacyMember")),
+        },
+        "/members/{email}": map[string]interface{}{
+            "get":   operation("Get one member", true, nil, ref("Member"), pathParam("email")),
+            "patch": operation("Edit a member's name, anonymity, notes, tags, protection, and Discord link; only fields present change", true, ref("MemberPatch"), ref("LegacyMember"), pathParam("email")),
+        },
+        "/members/id/{id}": map[string]interface{}{
+            "get": operation("Get one member by public ID", true, nil, ref("Member"), pathParam("id")),
+        },
+        "/members/anniversaries": map[string]interface{}{
+            "get": operation("Upcoming membership anniversaries, excluding cancelled and anonymous members", true, nil,
+                arrayOf(ref("Anniversary")), queryParam("within", "How far ahead, e.g. 14d (default)"),
+                queryParam("milestones", "1 to add gift-count milestones reached in the same span back")),
+        },
+        "/members/bulk-status": map[string]interface{}{
+            "post": operation("Set many members to one status in a single transaction", true, ref("BulkStatusRequest"), ref("BulkStatusResponse")),
+        },
+        "/members/merge": map[string]interface{}{
+            "post": operation("Merge one member record into another", true, ref("MergeRequest"), ref("MergeResult")),
+        },
+        "/members/link": map[string]interface{}{
+            "post": operation("Link a secondary member to a primary's household", true, ref("HouseholdRequest"), ref("HouseholdLink")),
+        },
+        "/members/unlink": map[string]interface{}{
+            "post": operation("Make a household's secondary member independent again (cancelled)", true, ref("HouseholdRequest"), ref("HouseholdLink")),
+        },
+        "/members/{email}/forget": map[string]interface{}{
+            "post": operation("Erase a member's personal data", true, nil, ref("ForgetResult"), pathParam("email")),
+        },
+        "/unsubscribe/{token}": map[string]interface{}{
+            "get":  operation("Opt a member out of email from the link in a message footer (plain text response)", false, nil, nil, pathParam("token")),
+            "post": operation("One-click unsubscribe (RFC 8058)", false, nil, nil, pathParam("token")),
+        },
+        "/history/{email}": map[string]interface{}{
+            "get": operation("A member's status history, newest first", true, nil, arrayOf(ref("StatusChange")), pathParam("email")),
+        },
+        "/sar/{member}": map[string]interface{}{
+            "get": operation("Everything held about a member, by email or id, for a subject access request", true, nil, ref("SubjectAccessExport"),
+                pathParam("member"), queryParam("include_notes", "true to include internal notes, withheld by default")),
+        },
+        "/sync": map[string]interface{}{
+            "post": operation("Start a scheduled clean from SYNC_SOURCE", true, nil, nil),
+        },
+        "/events": map[string]interface{}{
+            "get": operation("Events feed after a cursor, oldest first", true, nil,
+                map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+                    "events":   arrayOf(ref("FeedEvent")),
+                    "next":     map[string]interface{}{"type": "string"},
+                    "has_more": map[string]interface{}{"type": "boolean"},
+                }},
+                queryParam("since", "Cursor from a previous response"), queryParam("type", "Only events of this type"),
+                queryParam("limit", "Maximum events to return")),
+        },
+        "/events/stream": map[string]interface{}{
+            "get": operation("Member events as server-sent events; resumes from Last-Event-ID", true, nil, nil),
+        },
+        "/webhooks": map[string]interface{}{
+            "get": operation("Logged webhooks in a retry state", true, nil, arrayOf(ref("WebhookLogEntry")),
+                queryParam("state", "pending, failed (default), done, deferred, or skipped"), queryParam("source", "Only this webhook source"),
+                queryParam("limit", "Maximum webhooks to return")),
+        },
+        "/webhooks/failed": map[string]interface{}{
+            "get": operation("Webhooks that couldn't be processed, for review", true, nil, arrayOf(ref("FailedWebhook")),
+                queryParam("state", "open (default), retried, dismissed, or all"),
+                queryParam("limit", "Maximum failures to return")),
+        },
+        "/statuses/unmapped": map[string]interface{}{
+            "get": operation("Payment statuses no rule matched, awaiting a mapping", true, nil, arrayOf(ref("UnmappedStatus")),
+                queryParam("all", "true to include statuses already mapped")),
+            "post": operation("Map a payment status (an empty status clears the mapping), optionally retrying the webhooks held for it",
+                true, ref("StatusMapping"), ref("StatusMappingResult")),
+        },
+        "/pending-changes": map[string]interface{}{
+            "get": operation("Status changes clean and reconcile queued for review", true, nil, arrayOf(ref("PendingChange")),
+                queryParam("state", "pending (default), approved, rejected, expired, superseded, skipped, or all"),
+                queryParam("limit", "Maximum changes to return")),
+        },
+        "/pending-changes/{id}/approve": map[string]interface{}{
+            "post": operation("Apply a pending change; 409 if it is no longer pending", true, nil, ref("ReviewResult"), pathParam("id")),
+        },
+        "/pending-changes/approve-all": map[string]interface{}{
+            "post": operation("Apply every pending change as one sync run; 409 if it would deactivate more than clean's default --max-deactivate-percent of active members", true, nil, ref("ReviewResult"),
+                queryParam("force", "true to approve a mass deactivation anyway")),
+        },
+        "/pending-changes/{id}/reject": map[string]interface{}{
+            "post": operation("Discard a pending change; 409 if it is no longer pending", true,
+                map[string]interface{}{"type": "object", "properties": map[string]interface{}{"reason": map[string]interface{}{"type": "string"}}},
+                nil, pathParam("id")),
+        },
+        "/subscriptions": map[string]interface{}{
+            "get":  operation("Outbound webhook subscriptions", true, nil, arrayOf(ref("Subscription"))),
+            "post": operation("Register an outbound webhook subscription", true, ref("SubscriptionRequest"), ref("Subscription")),
+        },
+        "/organizations": map[string]interface{}{
+            "get":  operation("Organizational memberships and the members attached to them", true, nil, arrayOf(ref("Organization"))),
+            "post": operation("Create an organization (status defaults to active)", true, ref("OrganizationRequest"), ref("Organization")),
+        },
+        "/organizations/{org}/members": map[string]interface{}{
+            "post": operation("Attach an email to an organization, by organization name or id", true, ref("OrganizationMember"), ref("OrganizationChange"), pathParam("org")),
+        },
+        "/organizations/{org}/members/{email}": map[string]interface{}{
+            "delete": operation("Detach an email from an organization; the member's own status is unchanged", true, nil, ref("OrganizationChange"), pathParam("org"), pathParam("email")),
+        },
+        "/subscriptions/{id}": map[string]interface{}{
+            "delete": operation("Remove a subscription", true, nil, nil, pathParam("id")),
+        },
+        "/admin/maintenance": map[string]interface{}{
+            "get": operation("Maintenance mode state", true, nil, object),
+            "post": operation("Turn maintenance mode on or off", true,
+                map[string]interface{}{"type": "object", "properties": map[string]interface{}{"enabled": map[string]interface{}{"type": "boolean"}}},
+                object),
+        },
+        "/admin/jobs": map[string]interface{}{
+            "get": operation("Background jobs with their schedules and last runs", true, nil, arrayOf(ref("JobStatus"))),
+        },
+        "/admin/jobs/{name}/run": map[string]interface{}{
+            "post": operation("Start a background job now; 409 if it is already running", true, nil, ref("JobStatus"), pathParam("name")),
+        },
+    }
+
+    // Read endpoints are documented under /v1; the unprefixed routes are
+    // deprecated aliases, with the old member shapes
+    legacyResults := map[string]map[string]interface{}{
+        "/members":         arrayOf(ref("LegacyMember")),
+        "/members/{email}": ref("LegacyMember"),
+        "/members/id/{id}": ref("LegacyMember"),
+    }
+    for _, path := range openAPIReadPaths {
+        item := paths[path].(map[string]interface{})
+        current := item["get"].(map[string]interface{})
+        paths[apiVersions[len(apiVersions)-1].prefix()+path] = map[string]interface{}{"get": current}
+
+        legacy := map[string]interface{}{}
+        for k, v := range current {
+            legacy[k] = v
+        }
+        legacy["deprecated"] = true
+        if result, ok := legacyResults[path]; ok {
+            legacy["responses"] = map[string]interface{}{
+                "200":     map[string]interface{}{"description": "OK", "content": jsonContent(result)},
+                "default": current["responses"].(map[string]interface{})["default"],
+            }
+        }
+        if path == "/members" {
+            // Paging is /v1 only
+            legacy["parameters"] = current["parameters"].([]map[string]interface{})[:4]
+        }
+        item["get"] = legacy
+    }
+
+    return map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{
+            "title":   "Operator Foundation memberships",
+            "version": version,
+        },
+        "paths": paths,
+        "components": map[string]interface{}{
+            "schemas": schemas,
+            "securitySchemes": map[string]interface{}{
+                "adminToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+            },
+        },
+    }
+}
+
+var (
+    openAPIOnce sync.Once
+    openAPIJSON []byte
+)
+
+// openAPIHandler serves the OpenAPI document, built on first request
+func (s *WebhookServer) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+    openAPIOnce.Do(func() {
+        openAPIJSON, _ = json.MarshalIndent(openAPISpec(), "", "  ")
+    })
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(openAPIJSON)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "regexp"
+    "sort"
+    "strings"
+    "testing"
+)
+
+// openAPIDocument fetches /openapi.json the way a client would
+func openAPIDocument(t *testing.T, server *httptest.Server) map[string]interface{} {
+    t.Helper()
+    resp := do(t, server, "GET", "/openapi.json", "", "")
+    expectStatus(t, resp, http.StatusOK)
+    var doc map[string]interface{}
+    decode(t, resp, &doc)
+    return doc
+}
+
+// schemaRefs collects every $ref under v
+func schemaRefs(v interface{}, refs map[string]bool) {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        if ref, ok := v["$ref"].(string); ok {
+            refs[ref] = true
+        }
+        for _, child := range v {
+            schemaRefs(child, refs)
+        }
+    case []interface{}:
+        for _, child := range v {
+            schemaRefs(child, refs)
+        }
+    }
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+func TestOpenAPIDocumentIsConsistent(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+    doc := openAPIDocument(t, server)
+
+    if doc["openapi"] != "3.0.3" {
+        t.Errorf("openapi = %v", doc["openapi"])
+    }
+    schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+    refs := map[string]bool{}
+    schemaRefs(doc, refs)
+    for ref := range refs {
+        name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+        if !ok || schemas[name] == nil {
+            t.Errorf("$ref %s doesn't resolve", ref)
+        }
+    }
+    for name := range schemas {
+        if !refs["#/components/schemas/"+name] {
+            t.Errorf("schema %s isn't used by any operation", name)
+        }
+    }
+
+    s := NewWebhookServer(newMemStore(), testConfig(), log.New(io.Discard, "", 0))
+    s.routes()
+    t.Cleanup(s.accessLog.close)
+
+    for path, item := range doc["paths"].(map[string]interface{}) {
+        var want []string
+        for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+            want = append(want, m[1])
+        }
+        sort.Strings(want)
+
+        for method, op := range item.(map[string]interface{}) {
+            op := op.(map[string]interface{})
+            name := strings.ToUpper(method) + " " + path
+
+            var got []string
+            params, _ := op["parameters"].([]interface{})
+            for _, p := range params {
+                if p := p.(map[string]interface{}); p["in"] == "path" {
+                    got = append(got, p["name"].(string))
+                }
+            }
+            sort.Strings(got)
+            if strings.Join(got, ",") != strings.Join(want, ",") {
+                t.Errorf("%s documents path parameters %v, want %v", name, got, want)
+            }
+
+            responses, _ := op["responses"].(map[string]interface{})
+            if responses["200"] == nil || responses["default"] == nil {
+                t.Errorf("%s doesn't document both its result and its errors", name)
+            }
+            if op["summary"] == "" {
+                t.Errorf("%s has no summary", name)
+            }
+
+            // Every documented operation is served
+            url := pathParamPattern.ReplaceAllString(path, "x")
+            r := httptest.NewRequest(strings.ToUpper(method), url, nil)
+            if _, pattern := s.mux.Handler(r); pattern == "" || !strings.HasPrefix(pattern, strings.ToUpper(method)+" ") {
+                t.Errorf("%s is documented but not routed (matched %q)", name, pattern)
+            }
+        }
+    }
+}
+
+// checkSchema reports where v doesn't match schema, resolving references
+// against schemas. Properties the schema doesn't list are reported too, so
+// a field added to a response without its schema shows up.
+func checkSchema(v interface{}, schema, schemas map[string]interface{}, at string) []string {
+    if ref, ok := schema["$ref"].(string); ok {
+        return checkSchema(v, schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{}), schemas, at)
+    }
+    if v == nil {
+        if schema["nullable"] == true || schema["type"] == nil {
+            return nil
+        }
+        return []string{at + ": null"}
+    }
+
+    var problems []string
+    wrongType := func() []string { return []string{fmt.Sprintf("%s: %T isn't %v", at, v, schema["type"])} }
+    switch schema["type"] {
+    case "string":
+        if _, ok := v.(string); !ok {
+            return wrongType()
+        }
+    case "boolean":
+        if _, ok := v.(bool); !ok {
+            return wrongType()
+        }
+    case "integer":
+        if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+            return wrongType()
+        }
+    case "number":
+        if _, ok := v.(float64); !ok {
+            return wrongType()
+        }
+    case "array":
+        items, ok := v.([]interface{})
+        if !ok {
+            return wrongType()
+        }
+        for i, item := range items {
+            problems = append(problems, checkSchema(item, schema["items"].(map[string]interface{}), schemas, fmt.Sprintf("%s[%d]", at, i))...)
+        }
+    case "object":
+        object, ok := v.(map[string]interface{})
+        if !ok {
+            return wrongType()
+        }
+        properties, _ := schema["properties"].(map[string]interface{})
+        required, _ := schema["required"].([]interface{})
+        for _, name := range required {
+            if _, ok := object[name.(string)]; !ok {
+                problems = append(problems, fmt.Sprintf("%s: missing required %s", at, name))
+            }
+        }
+        for name, value := range object {
+            if property, ok := properties[name]; ok {
+                problems = append(problems, checkSchema(value, property.(map[string]interface{}), schemas, at+"."+name)...)
+            } else if extra, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+                problems = append(problems, checkSchema(value, extra, schemas, at+"."+name)...)
+            } else if properties != nil {
+                problems = append(problems, fmt.Sprintf("%s: %s isn't in the schema", at, name))
+            }
+        }
+    }
+    return problems
+}
+
+// TestOpenAPIMatchesHandlers sends payloads through the real handlers and
+// checks each response against the schema the document gives for it
+func TestOpenAPIMatchesHandlers(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    doc := openAPIDocument(t, server)
+    paths := doc["paths"].(map[string]interface{})
+    schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+    expectSchema := func(method, path, url, token, body string, status int) {
+        t.Helper()
+        op := paths[path].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+        response := "default"
+        if status < 300 {
+            response = "200"
+        }
+        content := op["responses"].(map[string]interface{})[response].(map[string]interface{})["content"]
+        schema := content.(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+
+        resp := do(t, server, method, url, token, body)
+        expectStatus(t, resp, status)
+        var got interface{}
+        decode(t, resp, &got)
+        for _, problem := range checkSchema(got, schema, schemas, method+" "+url) {
+            t.Error(problem)
+        }
+    }
+
+    // The webhook payload is the documented MemberWebhook, and so is every
+    // field the handler reads
+    var webhook map[string]interface{}
+    json.Unmarshal([]byte(`{"email":"Ada@Example.org","name":"Ada Lovelace","status":"Succeeded","anonymous":"False","frequency":"Monthly","campaign":"spring"}`), &webhook)
+    for _, problem := range checkSchema(webhook, ref("MemberWebhook"), schemas, "MemberWebhook") {
+        t.Error(problem)
+    }
+    payload, _ := json.Marshal(webhook)
+    expectSchema("POST", "/webhook", "/webhook", testWebhookSecret, string(payload), http.StatusCreated)
+
+    notes := "prefers post"
+    db.UpdateMemberAnnotations("ada@example.org", &notes, []string{"volunteer"})
+    db.SetEmailConsent("ada@example.org", true, ChangeSource{Source: "test"})
+    seedMembers(t, db, map[string]string{"grace@example.org": StatusCancelled})
+
+    expectSchema("GET", "/v1/members", "/v1/members", testAdminToken, "", http.StatusOK)
+    expectSchema("GET", "/members", "/members", testAdminToken, "", http.StatusOK)
+    expectSchema("GET", "/v1/members/{email}", "/v1/members/ada@example.org", testAdminToken, "", http.StatusOK)
+    expectSchema("GET", "/members/{email}", "/members/ada@example.org", testAdminToken, "", http.StatusOK)
+    expectSchema("PATCH", "/members/{email}", "/members/ada@example.org", testAdminToken, `{"name":"Ada King"}`, http.StatusOK)
+    expectSchema("POST", "/members", "/members", testAdminToken, `{"email":"alan@example.org","name":"Alan Turing"}`, http.StatusCreated)
+    expectSchema("GET", "/v1/history/{email}", "/v1/history/ada@example.org", testAdminToken, "", http.StatusOK)
+    expectSchema("GET", "/v1/stats", "/v1/stats", "", "", http.StatusOK)
+    expectSchema("GET", "/v1/webhooks", "/v1/webhooks?state=done", testAdminToken, "", http.StatusOK)
+    expectSchema("POST", "/members/bulk-status", "/members/bulk-status", testAdminToken,
+        `{"emails":["grace@example.org","nobody@example.org"],"status":"active"}`, http.StatusOK)
+    expectSchema("POST", "/members/merge", "/members/merge", testAdminToken, `{"from":"grace@example.org","to":"ada@example.org"}`, http.StatusOK)
+    expectSchema("GET", "/version", "/version", "", "", http.StatusOK)
+
+    // Errors have the documented shape too
+    expectSchema("GET", "/v1/members/{email}", "/v1/members/nobody@example.org", testAdminToken, "", http.StatusNotFound)
+    expectSchema("POST", "/members/merge", "/members/merge", testAdminToken, `{"from":"","to":""}`, http.StatusUnprocessableEntity)
+}
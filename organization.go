@@ -0,0 +1,720 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// organizationSource attributes changes made to or through an organization
+const organizationSource = "organization"
+
+// Organization feed event types
+const (
+    feedOrganizationCreated       = "organization.created"
+    feedOrganizationStatusChanged = "organization.status_changed"
+)
+
+// ErrOrganizationNotFound is returned when no organization matches a name or id
+var ErrOrganizationNotFound = errors.New("organization not found")
+
+// ErrOrganizationExists is returned when creating an organization whose name
+// is taken
+var ErrOrganizationExists = errors.New("organization already exists")
+
+// Organization is an org-level membership. Its status covers every member
+// attached to it, on top of their own.
+type Organization struct {
+    ID        int       `json:"id"`
+    Name      string    `json:"name"`
+    Status    string    `json:"status"`
+    Tier      string    `json:"tier,omitempty"`
+    Members   []string  `json:"members"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrganizationChange is the outcome of a change to an organization or its
+// members
+type OrganizationChange struct {
+    Organization string `json:"organization"`
+    Email        string `json:"email,omitempty"`
+    Previous     string `json:"previous_status,omitempty"`
+    Status       string `json:"status"`
+
+    // Created is set when the change created the organization, and
+    // MemberCreated when it created a member record for the email
+    Created       bool `json:"created,omitempty"`
+    MemberCreated bool `json:"member_created,omitempty"`
+    Unchanged     bool `json:"unchanged,omitempty"`
+}
+
+// orgRow is an organization locked for a change
+type orgRow struct {
+    id     int
+    name   string
+    status string
+}
+
+// lockOrganization loads and locks the organization ref names: "#12" or
+// "12" by id, anything else by name, ignoring case
+func lockOrganization(q querier, ref string) (*orgRow, error) {
+    ref = strings.TrimSpace(ref)
+    query := `SELECT id, name, status FROM organizations WHERE lower(name) = lower($1) FOR UPDATE`
+    var arg interface{} = ref
+    if id, err := strconv.Atoi(strings.TrimPrefix(ref, "#")); err == nil {
+        query = `SELECT id, name, status FROM organizations WHERE id = $1 FOR UPDATE`
+        arg = id
+    }
+
+    var org orgRow
+    err := q.QueryRow(query, arg).Scan(&org.id, &org.name, &org.status)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrOrganizationNotFound, ref)
+    } else if err != nil {
+        return nil, fmt.Errorf("failed to load organization: %w", err)
+    }
+    return &org, nil
+}
+
+// insertOrganization creates an organization, or returns
+// ErrOrganizationExists if the name is taken
+func insertOrganization(q querier, name, tier, status string, change ChangeSource) (*orgRow, error) {
+    org := &orgRow{name: name, status: status}
+    err := q.QueryRow(`
+        INSERT INTO organizations (name, tier, status)
+        VALUES ($1, NULLIF($2, ''), $3)
+        ON CONFLICT ((lower(name))) DO NOTHING
+        RETURNING id
+    `, name, tier, status).Scan(&org.id)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("%w: %s", ErrOrganizationExists, name)
+    } else if err != nil {
+        return nil, fmt.Errorf("failed to create organization: %w", err)
+    }
+
+    err = recordEvent(q, feedOrganizationCreated, 0, change, map[string]interface{}{
+        "organization": name,
+        "tier":         tier,
+        "status":       status,
+    })
+    if err != nil {
+        return nil, err
+    }
+    return org, nil
+}
+
+// setOrganizationStatus moves an organization to status. Its members' own
+// status and history are left alone: verification combines the two.
+func setOrganizationStatus(q querier, org *orgRow, status string, change ChangeSource) error {
+    if org.status == status {
+        return nil
+    }
+
+    _, err := q.Exec(`
+        UPDATE organizations SET status = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+    `, org.id, status)
+    if err != nil {
+        return fmt.Errorf("failed to update organization: %w", err)
+    }
+
+    err = recordEvent(q, feedOrganizationStatusChanged, 0, change, map[string]interface{}{
+        "organization": org.name,
+        "old_status":   org.status,
+        "new_status":   status,
+    })
+    if err != nil {
+        return err
+    }
+    org.status = status
+    return nil
+}
+
+// touchOrganization marks an organization changed, so member listings that
+// include it aren't served from cache
+func touchOrganization(q querier, id int) error {
+    _, err := q.Exec(`UPDATE organizations SET updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+    if err != nil {
+        return fmt.Errorf("failed to update organization: %w", err)
+    }
+    return nil
+}
+
+// attachOrganizationMember adds email to an organization. Someone who isn't
+// a member yet gets a record of their own, cancelled, since the
+// organization's membership is not theirs.
+func (db *Database) attachOrganizationMember(q querier, org *orgRow, email, name string, change ChangeSource) (memberCreated, attached bool, err error) {
+    key := db.NormalizeEmail(email)
+
+    var memberID int
+    err = q.QueryRow(`SELECT id FROM members WHERE email = $1`, key).Scan(&memberID)
+    if err == sql.ErrNoRows {
+        if _, err := db.processMember(q, email, name, false, StatusCancelled, change); err != nil {
+            return false, false, err
+        }
+        memberCreated = true
+        err = q.QueryRow(`SELECT id FROM members WHERE email = $1`, key).Scan(&memberID)
+    }
+    if err != nil {
+        return false, false, fmt.Errorf("failed to load member: %w", err)
+    }
+
+    result, err := q.Exec(`
+        INSERT INTO org_members (organization_id, member_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+    `, org.id, memberID)
+    if err != nil {
+        return false, false, fmt.Errorf("failed to attach member: %w", err)
+    }
+    if n, _ := result.RowsAffected(); n == 0 {
+        return memberCreated, false, nil
+    }
+    if err := touchOrganization(q, org.id); err != nil {
+        return false, false, err
+    }
+
+    err = recordEvent(q, feedMemberUpdated, memberID, change, map[string]interface{}{
+        "email": key,
+        "changes": map[string]interface{}{
+            "organization": map[string]interface{}{"before": nil, "after": org.name},
+        },
+    })
+    return memberCreated, true, err
+}
+
+// CreateOrganization adds an organization with no members
+func (db *Database) CreateOrganization(name, tier, status, by string) (*Organization, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return nil, fmt.Errorf("organization name is required")
+    }
+    if _, err := strconv.Atoi(strings.TrimPrefix(name, "#")); err == nil {
+        return nil, fmt.Errorf("organization name %q would be mistaken for an id", name)
+    }
+    if !validStatus(status) {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+    }
+
+    var org *orgRow
+    err := db.inTx(func(tx *sql.Tx) error {
+        var err error
+        org, err = insertOrganization(tx, name, strings.TrimSpace(tier), status, ChangeSource{Source: organizationSource, Detail: "created by " + by})
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    db.logger.Printf("Created organization %s (ID: %d, Status: %s)", name, org.id, status)
+    return db.GetOrganization(strconv.Itoa(org.id))
+}
+
+// SetOrganizationStatus changes an organization's status, returning the
+// one it had. Cancelling an organization stops its members verifying
+// through it; their own memberships are unaffected.
+func (db *Database) SetOrganizationStatus(ref, status string, change ChangeSource) (*OrganizationChange, error) {
+    if !validStatus(status) {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+    }
+
+    result := &OrganizationChange{Status: status}
+    err := db.inTx(func(tx *sql.Tx) error {
+        org, err := lockOrganization(tx, ref)
+        if err != nil {
+            return err
+        }
+        result.Organization = org.name
+        result.Previous = org.status
+        result.Unchanged = org.status == status
+        return setOrganizationStatus(tx, org, status, change)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if !result.Unchanged {
+        db.logger.Printf("Organization %s: %s -> %s", result.Organization, result.Previous, status)
+    }
+    return result, nil
+}
+
+// AttachOrganizationMember adds email to an organization, creating a member
+// record, with name, for someone who doesn't have one
+func (db *Database) AttachOrganizationMember(ref, email, name, by string) (*OrganizationChange, error) {
+    result := &OrganizationChange{Email: db.NormalizeEmail(email)}
+    err := db.inTx(func(tx *sql.Tx) error {
+        org, err := lockOrganization(tx, ref)
+        if err != nil {
+            return err
+        }
+        result.Organization = org.name
+        result.Status = org.status
+
+        change := ChangeSource{Source: organizationSource, Detail: fmt.Sprintf("attached to %s by %s", org.name, by)}
+        created, attached, err := db.attachOrganizationMember(tx, org, email, name, change)
+        result.MemberCreated = created
+        result.Unchanged = !attached
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if !result.Unchanged {
+        db.logger.Printf("Attached %s to organization %s", result.Email, result.Organization)
+    }
+    return result, nil
+}
+
+// DetachOrganizationMember removes email from an organization. The member
+// record, its status, and its history stay as they were.
+func (db *Database) DetachOrganizationMember(ref, email, by string) (*OrganizationChange, error) {
+    key := db.NormalizeEmail(email)
+    result := &OrganizationChange{Email: key}
+    err := db.inTx(func(tx *sql.Tx) error {
+        org, err := lockOrganization(tx, ref)
+        if err != nil {
+            return err
+        }
+        result.Organization = org.name
+        result.Status = org.status
+
+        var memberID int
+        err = tx.QueryRow(`
+            DELETE FROM org_members
+            WHERE organization_id = $1 AND member_id = (SELECT id FROM members WHERE email = $2)
+            RETURNING member_id
+        `, org.id, key).Scan(&memberID)
+        if err == sql.ErrNoRows {
+            result.Unchanged = true
+            return nil
+        } else if err != nil {
+            return fmt.Errorf("failed to detach member: %w", err)
+        }
+
+        if err := touchOrganization(tx, org.id); err != nil {
+            return err
+        }
+
+        change := ChangeSource{Source: organizationSource, Detail: fmt.Sprintf("detached from %s by %s", org.name, by)}
+        return recordEvent(tx, feedMemberUpdated, memberID, change, map[string]interface{}{
+            "email": key,
+            "changes": map[string]interface{}{
+                "organization": map[string]interface{}{"before": org.name, "after": nil},
+            },
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if !result.Unchanged {
+        db.logger.Printf("Detached %s from organization %s", key, result.Organization)
+    }
+    return result, nil
+}
+
+// ProcessOrganization applies a webhook for an organization's membership:
+// the organization, created if it's new, takes status, and the payer's
+// email is attached to it
+func (db *Database) ProcessOrganization(name, email, contactName, status string, change ChangeSource) (*OrganizationChange, error) {
+    name = strings.TrimSpace(name)
+    result := &OrganizationChange{Organization: name, Email: db.NormalizeEmail(email), Status: status}
+    err := db.inTx(func(tx *sql.Tx) error {
+        org, err := lockOrganization(tx, name)
+        if errors.Is(err, ErrOrganizationNotFound) {
+            org, err = insertOrganization(tx, name, "", status, change)
+            result.Created = true
+        }
+        if err != nil {
+            return err
+        }
+        result.Organization = org.name
+        result.Previous = org.status
+
+        if err := setOrganizationStatus(tx, org, status, change); err != nil {
+            return err
+        }
+        result.MemberCreated, _, err = db.attachOrganizationMember(tx, org, email, contactName, change)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    switch {
+    case result.Created:
+        db.logger.Printf("Created organization %s (Status: %s) for %s", result.Organization, status, result.Email)
+    case result.Previous != status:
+        db.logger.Printf("Organization %s: %s -> %s", result.Organization, result.Previous, status)
+    }
+    return result, nil
+}
+
+// GetOrganization returns the organization ref names, with its members
+func (db *Database) GetOrganization(ref string) (*Organization, error) {
+    orgs, err := db.queryOrganizations(ref)
+    if err != nil {
+        return nil, err
+    }
+    if len(orgs) == 0 {
+        return nil, fmt.Errorf("%w: %s", ErrOrganizationNotFound, ref)
+    }
+    return &orgs[0], nil
+}
+
+// GetOrganizations returns every organization, with its members, by name
+func (db *Database) GetOrganizations() ([]Organization, error) {
+    return db.queryOrganizations("")
+}
+
+func (db *Database) queryOrganizations(ref string) ([]Organization, error) {
+    query := `
+        SELECT o.id, o.name, o.status, COALESCE(o.tier, ''), o.created_at, o.updated_at,
+               COALESCE(array_agg(m.email ORDER BY m.email) FILTER (WHERE m.id IS NOT NULL), '{}')
+        FROM organizations o
+        LEFT JOIN org_members om ON om.organization_id = o.id
+        LEFT JOIN members m ON m.id = om.member_id
+    `
+    var args []interface{}
+    if ref != "" {
+        if id, err := strconv.Atoi(strings.TrimPrefix(ref, "#")); err == nil {
+            query += ` WHERE o.id = $1`
+            args = append(args, id)
+        } else {
+            query += ` WHERE lower(o.name) = lower($1)`
+            args = append(args, strings.TrimSpace(ref))
+        }
+    }
+    query += ` GROUP BY o.id ORDER BY lower(o.name)`
+
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load organizations: %w", err)
+    }
+    defer rows.Close()
+
+    var orgs []Organization
+    for rows.Next() {
+        var org Organization
+        err := rows.Scan(&org.ID, &org.Name, &org.Status, &org.Tier, &org.CreatedAt, &org.UpdatedAt, pq.Array(&org.Members))
+        if err != nil {
+            return nil, err
+        }
+        orgs = append(orgs, org)
+    }
+    return orgs, rows.Err()
+}
+
+// MemberOrganization returns the name of an organization email belongs to
+// whose status is one of statuses; found is false if there is none
+func (db *Database) MemberOrganization(email string, statuses []string) (name string, found bool, err error) {
+    return db.memberOrganization("m.email", db.NormalizeEmail(email), statuses)
+}
+
+// MemberOrganizationByHash is MemberOrganization by email_hash
+func (db *Database) MemberOrganizationByHash(hash string, statuses []string) (name string, found bool, err error) {
+    return db.memberOrganization("m.email_hash", hash, statuses)
+}
+
+func (db *Database) memberOrganization(column, value string, statuses []string) (string, bool, error) {
+    var name string
+    err := db.QueryRow(`
+        SELECT o.name FROM organizations o
+        JOIN org_members om ON om.organization_id = o.id
+        JOIN members m ON m.id = om.member_id
+        WHERE `+column+` = $1 AND o.status = ANY($2)
+        ORDER BY o.id
+        LIMIT 1
+    `, value, pq.Array(statuses)).Scan(&name)
+    if err == sql.ErrNoRows {
+        return "", false, nil
+    } else if err != nil {
+        return "", false, fmt.Errorf("database error: %w", err)
+    }
+    return name, true, nil
+}
+
+// applyOrganizationWebhook applies a webhook naming an organization. The
+// payment is the organization's, so the payer's own status is untouched.
+func (s *WebhookServer) applyOrganizationWebhook(webhook MemberWebhook, status string, occurredAt time.Time, change ChangeSource) (*ProcessResult, error) {
+    name := webhook.Name
+    if s.convertAnonymous(webhook.Anonymous) {
+        name = ""
+    }
+
+    org, err := s.db.ProcessOrganization(webhook.Organization, webhook.Email, name, status, change)
+    if err != nil {
+        return nil, err
+    }
+
+    if status == StatusActive && webhook.Amount != "" {
+        s.recordWebhookDonation(webhook, occurredAt, change)
+    }
+
+    result := &ProcessResult{Action: actionUnchanged, Email: org.Email, PreviousStatus: org.Previous, Status: org.Status, Organization: org.Organization}
+    switch {
+    case org.Created:
+        result.Action = actionCreated
+    case org.Previous != org.Status:
+        result.Action = actionUpdated
+    }
+    return result, nil
+}
+
+// previewOrganizationWebhook reports what applyOrganizationWebhook would do,
+// writing nothing
+func (s *WebhookServer) previewOrganizationWebhook(webhook MemberWebhook, status string) (*ProcessResult, error) {
+    result := &ProcessResult{
+        Action:       actionCreated,
+        Email:        s.db.NormalizeEmail(webhook.Email),
+        Status:       status,
+        Organization: strings.TrimSpace(webhook.Organization),
+    }
+
+    org, err := s.db.GetOrganization(result.Organization)
+    if errors.Is(err, ErrOrganizationNotFound) {
+        return result, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    result.Organization = org.Name
+    result.PreviousStatus = org.Status
+    result.Action = actionUnchanged
+    if org.Status != status {
+        result.Action = actionUpdated
+    }
+    return result, nil
+}
+
+// organizationRequest is the body of POST /organizations
+type organizationRequest struct {
+    Name   string `json:"name"`
+    Tier   string `json:"tier"`
+    Status string `json:"status"`
+}
+
+// organizationMemberRequest is the body of POST /organizations/{org}/members
+type organizationMemberRequest struct {
+    Email string `json:"email"`
+    Name  string `json:"name"`
+}
+
+// listOrganizationsHandler returns every organization with its members
+func (s *WebhookServer) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+    orgs, err := s.db.GetOrganizations()
+    if err != nil {
+        s.logger.Printf("Error getting organizations: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    if orgs == nil {
+        orgs = []Organization{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(orgs)
+}
+
+// createOrganizationHandler adds an organization
+func (s *WebhookServer) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+    var req organizationRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"name\": \"...\"}")
+        return
+    }
+    if req.Status == "" {
+        req.Status = StatusActive
+    }
+
+    org, err := s.db.CreateOrganization(req.Name, req.Tier, strings.ToLower(req.Status), s.principal(r))
+    switch {
+    case errors.Is(err, ErrOrganizationExists):
+        writeError(w, r, http.StatusConflict, errConflict, err.Error())
+        return
+    case errors.Is(err, ErrUnknownStatus):
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+        return
+    case err != nil:
+        s.logger.Printf("Error creating organization: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(org)
+}
+
+// attachOrganizationMemberHandler adds an email to an organization
+func (s *WebhookServer) attachOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+    var req organizationMemberRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Email == "" {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"email\": \"...\"}")
+        return
+    }
+
+    result, err := s.db.AttachOrganizationMember(r.PathValue("org"), req.Email, req.Name, s.principal(r))
+    s.writeOrganizationChange(w, r, result, err)
+}
+
+// detachOrganizationMemberHandler removes an email from an organization
+func (s *WebhookServer) detachOrganizationMemberHandler(w http.ResponseWriter, r *http.Request) {
+    result, err := s.db.DetachOrganizationMember(r.PathValue("org"), r.PathValue("email"), s.principal(r))
+    s.writeOrganizationChange(w, r, result, err)
+}
+
+func (s *WebhookServer) writeOrganizationChange(w http.ResponseWriter, r *http.Request, result *OrganizationChange, err error) {
+    switch {
+    case errors.Is(err, ErrOrganizationNotFound):
+        writeError(w, r, http.StatusNotFound, errNotFound, "Organization not found")
+        return
+    case errors.Is(err, ErrInvalidEmail):
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+        return
+    case err != nil:
+        s.logger.Printf("Error changing organization: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
+
+func runOrganization(logger *log.Logger) {
+    usage := "memberships org <list|create|attach|detach|status> [args]"
+    if len(os.Args) < 3 {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+        os.Exit(2)
+    }
+
+    action := os.Args[2]
+    orgCmd := flag.NewFlagSet("org "+action, flag.ExitOnError)
+    tier := orgCmd.String("tier", "", "With create: membership tier")
+    status := orgCmd.String("status", StatusActive, "With create: initial status")
+    name := orgCmd.String("name", "", "With attach: name for a new member record")
+    by := orgCmd.String("by", os.Getenv("USER"), "Who is making the change (default: $USER)")
+
+    args := parseSubcommand(orgCmd, usage, os.Args[3:])
+
+    switch action {
+    case "list", "create", "attach", "detach", "status":
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown org action %q\nUsage: %s\n", action, usage)
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    fail := func(err error) {
+        if errors.Is(err, ErrOrganizationNotFound) || errors.Is(err, ErrOrganizationExists) ||
+            errors.Is(err, ErrUnknownStatus) || errors.Is(err, ErrInvalidEmail) {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        logger.Fatalf("Organization %s failed: %v", action, err)
+    }
+
+    switch action {
+    case "list":
+        orgs, err := db.GetOrganizations()
+        if err != nil {
+            fail(err)
+        }
+        if len(orgs) == 0 {
+            fmt.Println("No organizations")
+            return
+        }
+        for _, org := range orgs {
+            line := fmt.Sprintf("#%d  %s  [%s]", org.ID, org.Name, org.Status)
+            if org.Tier != "" {
+                line += " " + org.Tier
+            }
+            fmt.Println(line)
+            for _, email := range org.Members {
+                fmt.Printf("      %s\n", email)
+            }
+        }
+
+    case "create":
+        if len(args) != 1 {
+            fmt.Fprintln(os.Stderr, `Usage: memberships org create "<name>" [--tier T] [--status active]`)
+            os.Exit(2)
+        }
+        org, err := db.CreateOrganization(args[0], *tier, strings.ToLower(*status), *by)
+        if err != nil {
+            fail(err)
+        }
+        fmt.Printf("Created organization #%d %s (%s)\n", org.ID, org.Name, org.Status)
+
+    case "attach":
+        if len(args) < 2 {
+            fmt.Fprintln(os.Stderr, `Usage: memberships org attach <org> <email>... [--name "Full Name"]`)
+            os.Exit(2)
+        }
+        for _, email := range args[1:] {
+            result, err := db.AttachOrganizationMember(args[0], email, *name, *by)
+            if err != nil {
+                fail(err)
+            }
+            switch {
+            case result.Unchanged:
+                fmt.Printf("%s is already attached to %s\n", result.Email, result.Organization)
+            case result.MemberCreated:
+                fmt.Printf("Attached %s to %s as a new member record\n", result.Email, result.Organization)
+            default:
+                fmt.Printf("Attached %s to %s\n", result.Email, result.Organization)
+            }
+        }
+
+    case "detach":
+        if len(args) < 2 {
+            fmt.Fprintln(os.Stderr, "Usage: memberships org detach <org> <email>...")
+            os.Exit(2)
+        }
+        for _, email := range args[1:] {
+            result, err := db.DetachOrganizationMember(args[0], email, *by)
+            if err != nil {
+                fail(err)
+            }
+            if result.Unchanged {
+                fmt.Printf("%s isn't attached to %s\n", result.Email, result.Organization)
+            } else {
+                fmt.Printf("Detached %s from %s; their own membership is unchanged\n", result.Email, result.Organization)
+            }
+        }
+
+    case "status":
+        if len(args) != 2 {
+            fmt.Fprintln(os.Stderr, "Usage: memberships org status <org> <active|cancelled|suspended>")
+            os.Exit(2)
+        }
+        newStatus := strings.ToLower(strings.TrimSpace(args[1]))
+        if !manualStatuses[newStatus] {
+            fmt.Fprintf(os.Stderr, "Error: invalid status %q (use active, cancelled, or suspended)\n", args[1])
+            os.Exit(2)
+        }
+        result, err := db.SetOrganizationStatus(args[0], newStatus, ChangeSource{Source: "manual", Detail: "set by " + *by})
+        if err != nil {
+            fail(err)
+        }
+        if result.Unchanged {
+            fmt.Printf("%s is already %s; nothing changed\n", result.Organization, newStatus)
+        } else {
+            fmt.Printf("%s: %s -> %s\n", result.Organization, result.Previous, newStatus)
+        }
+    }
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/csv"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// Default outreach thresholds
+const (
+    defaultRenewalNoticeDays = 30
+    defaultSuspendedDays     = 14
+)
+
+// OutreachRules are the thresholds for flagging members worth a personal
+// email
+type OutreachRules struct {
+    // RenewalNoticeDays flags annual members whose renewal falls within
+    // this many days
+    RenewalNoticeDays int
+
+    // SuspendedDays flags members suspended for longer than this
+    SuspendedDays int
+}
+
+// OutreachMember is a member with the payment and status details the
+// outreach rules look at
+type OutreachMember struct {
+    Member
+    FailedPayments int
+    StatusSince    time.Time
+}
+
+// outreachReasons returns why a member should be contacted, or nothing.
+// Anonymous members are never flagged: they asked not to be identified.
+func outreachReasons(m OutreachMember, rules OutreachRules, now time.Time) []string {
+    if m.IsAnonymous {
+        return nil
+    }
+
+    var reasons []string
+    months, recurring := frequencyMonths(m.Frequency.String)
+
+    if m.Status == StatusActive && recurring && months == 12 {
+        lastPaid := m.FirstSeen
+        if m.LastPaymentAt.Valid {
+            lastPaid = m.LastPaymentAt.Time
+        }
+        renewal := lastPaid.AddDate(0, months, 0)
+        if !renewal.Before(now) && renewal.Before(now.AddDate(0, 0, rules.RenewalNoticeDays)) {
+            reasons = append(reasons, fmt.Sprintf("annual renewal due %s", displayDate(renewal)))
+        }
+    }
+
+    if recurring && months == 1 && m.FailedPayments > 0 && m.Status != StatusCancelled {
+        reasons = append(reasons, fmt.Sprintf("last monthly payment failed (%d in a row)", m.FailedPayments))
+    }
+
+    if m.Status == StatusSuspended && !m.StatusSince.IsZero() && m.StatusSince.Before(now.AddDate(0, 0, -rules.SuspendedDays)) {
+        days := int(now.Sub(m.StatusSince).Hours() / 24)
+        reasons = append(reasons, fmt.Sprintf("suspended for %d days", days))
+    }
+
+    return reasons
+}
+
+// GetOutreachMembers loads active and suspended members with their failed
+// payment count and when their current status began. Members who opted out
+// of email are left out.
+func (db *Database) GetOutreachMembers() ([]OutreachMember, error) {
+    rows, err := db.Query(`
+        SELECT m.email, m.name, m.is_anonymous, m.status, m.frequency, m.first_seen,
+               m.last_payment_at, m.failed_payment_count, h.changed_at
+        FROM members m
+        LEFT JOIN LATERAL (
+            SELECT changed_at FROM status_history
+            WHERE member_id = m.id
+            ORDER BY changed_at DESC, id DESC
+            LIMIT 1
+        ) h ON true
+        WHERE m.status = ANY($1) AND m.email_opt_in IS NOT FALSE
+        ORDER BY m.email
+    `, pq.Array([]string{StatusActive, StatusSuspended}))
+    if err != nil {
+        return nil, fmt.Errorf("failed to load members: %w", err)
+    }
+    defer rows.Close()
+
+    var members []OutreachMember
+    for rows.Next() {
+        var m OutreachMember
+        var since sql.NullTime
+        err := rows.Scan(&m.Email, &m.Name, &m.IsAnonymous, &m.Status, &m.Frequency, &m.FirstSeen,
+            &m.LastPaymentAt, &m.FailedPayments, &since)
+        if err != nil {
+            return nil, err
+        }
+        m.StatusSince = since.Time
+        members = append(members, m)
+    }
+
+    return members, rows.Err()
+}
+
+func runOutreachReport(args []string, logger *log.Logger) {
+    outreachCmd := flag.NewFlagSet("report outreach", flag.ExitOnError)
+    output := outreachCmd.String("output", "", "Write the CSV to this file instead of stdout")
+    renewalDays := outreachCmd.Int("renewal-days", defaultRenewalNoticeDays, "Flag annual members whose renewal is within N days")
+    suspendedDays := outreachCmd.Int("suspended-days", defaultSuspendedDays, "Flag members suspended for more than N days")
+
+    parseSubcommand(outreachCmd, "memberships report outreach [--output outreach.csv] [--renewal-days 30] [--suspended-days 14]", args)
+
+    if *renewalDays < 0 || *suspendedDays < 0 {
+        fmt.Fprintln(os.Stderr, "Error: --renewal-days and --suspended-days can't be negative")
+        os.Exit(2)
+    }
+    rules := OutreachRules{RenewalNoticeDays: *renewalDays, SuspendedDays: *suspendedDays}
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    members, err := db.GetOutreachMembers()
+    if err != nil {
+        logger.Fatalf("Outreach report failed: %v", err)
+    }
+
+    var out io.Writer = os.Stdout
+    if *output != "" {
+        file, err := os.Create(*output)
+        if err != nil {
+            logger.Fatalf("Failed to create %s: %v", *output, err)
+        }
+        defer file.Close()
+        out = file
+    }
+
+    writer := csv.NewWriter(out)
+    writer.Write([]string{"email", "name", "status", "frequency", "reason"})
+    flagged := 0
+    now := time.Now()
+    for _, m := range members {
+        reasons := outreachReasons(m, rules, now)
+        if len(reasons) == 0 {
+            continue
+        }
+        flagged++
+        writer.Write([]string{m.Email, m.Name.String, m.Status, m.Frequency.String, strings.Join(reasons, "; ")})
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        logger.Fatalf("Failed to write CSV: %v", err)
+    }
+
+    if *output != "" {
+        fmt.Printf("Wrote %d members to %s\n", flagged, *output)
+    }
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// defaultPendingChangeTTL is how long a change queued for review can be
+// approved unless PENDING_CHANGE_TTL says otherwise
+const defaultPendingChangeTTL = 14 * 24 * time.Hour
+
+// What a pending change does to the member
+const (
+    pendingAdd        = "add"
+    pendingReactivate = "reactivate"
+    pendingDeactivate = "deactivate"
+    pendingSuspend    = "suspend"
+)
+
+// Pending change states. A change is superseded when a later review run
+// no longer proposes it, and skipped when approved after the member had
+// already moved on from the status it was proposed against.
+const (
+    pendingStatePending    = "pending"
+    pendingStateApproved   = "approved"
+    pendingStateRejected   = "rejected"
+    pendingStateExpired    = "expired"
+    pendingStateSuperseded = "superseded"
+    pendingStateSkipped    = "skipped"
+)
+
+// ErrPendingChangeNotFound is returned for an unknown pending change id
+var ErrPendingChangeNotFound = errors.New("pending change not found")
+
+// ErrPendingChangeDecided is returned when approving or rejecting a change
+// that is no longer pending
+var ErrPendingChangeDecided = errors.New("pending change already decided")
+
+// ErrTooManyDeactivations is returned when approving every pending change
+// would deactivate more members than the deactivation limit allows
+var ErrTooManyDeactivations = errors.New("too many deactivations")
+
+// PendingChange is a status change a clean or reconcile run in review mode
+// proposed instead of making
+type PendingChange struct {
+    ID         int        `json:"id"`
+    Email      string     `json:"email"`
+    Action     string     `json:"action"`
+    FromStatus string     `json:"from_status,omitempty"`
+    ToStatus   string     `json:"to_status"`
+    Source     string     `json:"source"`
+    Frequency  string     `json:"frequency,omitempty"`
+    Campaign   string     `json:"campaign,omitempty"`
+    PaidAt     *time.Time `json:"paid_at,omitempty"`
+    ProposedAt time.Time  `json:"proposed_at"`
+    ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+    State      string     `json:"state"`
+    DecidedAt  *time.Time `json:"decided_at,omitempty"`
+    DecidedBy  string     `json:"decided_by,omitempty"`
+    Note       string     `json:"note,omitempty"`
+    SyncRunID  int        `json:"sync_run_id,omitempty"`
+}
+
+// ReviewResult is what approving pending changes did. Approved changes are
+// recorded as one sync run, so memberships undo can reverse them.
+type ReviewResult struct {
+    SyncRunID int             `json:"sync_run_id,omitempty"`
+    Approved  []PendingChange `json:"approved"`
+    Skipped   []PendingChange `json:"skipped"`
+}
+
+// Queue records the change set's unsuppressed status changes for review
+// instead of applying them, replacing whatever an earlier review run had
+// proposed. Like Apply it refuses a mass deactivation unless opts.Force is
+// set. Payments, frequencies, and donations aren't recorded; a reviewed
+// source may be stale. It returns how many changes were queued.
+func (c *ChangeSet) Queue(db Store, opts CleanOptions) (int, error) {
+    if !opts.NoDeactivate && len(c.Deactivate) > 0 && c.TooManyDeactivations(opts.MaxDeactivatePercent) && !opts.Force {
+        return 0, fmt.Errorf("refusing to queue deactivation of %d members; check the export is complete or re-run with --force", len(c.Deactivate))
+    }
+
+    var changes []PendingChange
+    propose := func(emails []string, action, status string) {
+        for _, email := range emails {
+            change := PendingChange{Email: email, Action: action, ToStatus: status, Source: c.Source.Name}
+            if status == StatusActive {
+                change.Frequency = c.Source.Frequencies[email]
+                if paidAt, ok := c.Source.PaymentDates[email]; ok {
+                    change.PaidAt = &paidAt
+                }
+            }
+            if action == pendingAdd {
+                change.Campaign = c.Source.Campaigns[email]
+            }
+            changes = append(changes, change)
+        }
+    }
+    if !opts.NoAdd {
+        propose(c.Add, pendingAdd, StatusActive)
+    }
+    if !opts.NoReactivate {
+        propose(c.Activate, pendingReactivate, StatusActive)
+    }
+    if !opts.NoDeactivate {
+        propose(c.Deactivate, pendingDeactivate, StatusCancelled)
+        propose(c.Suspend, pendingSuspend, StatusSuspended)
+    }
+
+    var expiresAt *time.Time
+    if opts.ReviewTTL > 0 {
+        at := time.Now().Add(opts.ReviewTTL)
+        expiresAt = &at
+    }
+    if err := db.QueuePendingChanges(changes, expiresAt); err != nil {
+        return 0, err
+    }
+    return len(changes), nil
+}
+
+// QueuePendingChanges stores proposed changes, one pending change per
+// member, noting each member's status at the time. Pending changes the
+// batch doesn't repeat are superseded, so only the latest review run's
+// proposals can be approved.
+func (db *Database) QueuePendingChanges(changes []PendingChange, expiresAt *time.Time) error {
+    return db.inTx(func(tx *sql.Tx) error {
+        if err := expirePendingChanges(tx); err != nil {
+            return err
+        }
+
+        for _, c := range changes {
+            c.Email = db.NormalizeEmail(c.Email)
+            _, err := tx.Exec(`
+                INSERT INTO pending_changes (email, action, from_status, to_status, source, frequency, campaign, paid_at, expires_at)
+                VALUES ($1, $2, (SELECT status FROM members WHERE email = $1), $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8)
+                ON CONFLICT (email) WHERE state = 'pending' DO UPDATE SET
+                    action = EXCLUDED.action,
+                    from_status = EXCLUDED.from_status,
+                    to_status = EXCLUDED.to_status,
+                    source = EXCLUDED.source,
+                    frequency = EXCLUDED.frequency,
+                    campaign = EXCLUDED.campaign,
+                    paid_at = EXCLUDED.paid_at,
+                    proposed_at = CURRENT_TIMESTAMP,
+                    expires_at = EXCLUDED.expires_at
+            `, c.Email, c.Action, c.ToStatus, c.Source, c.Frequency, c.Campaign, c.PaidAt, expiresAt)
+            if err != nil {
+                return fmt.Errorf("failed to queue change for %s: %w", c.Email, err)
+            }
+        }
+
+        // CURRENT_TIMESTAMP is fixed for the transaction, so every row
+        // queued above has it
+        _, err := tx.Exec(`
+            UPDATE pending_changes SET state = $1, decided_at = CURRENT_TIMESTAMP
+            WHERE state = $2 AND proposed_at < CURRENT_TIMESTAMP
+        `, pendingStateSuperseded, pendingStatePending)
+        if err != nil {
+            return fmt.Errorf("failed to supersede pending changes: %w", err)
+        }
+        return nil
+    })
+}
+
+// expirePendingChanges marks pending changes past their expiry as expired
+func expirePendingChanges(q querier) error {
+    _, err := q.Exec(`
+        UPDATE pending_changes SET state = $1, decided_at = expires_at
+        WHERE state = $2 AND expires_at <= CURRENT_TIMESTAMP
+    `, pendingStateExpired, pendingStatePending)
+    if err != nil {
+        return fmt.Errorf("failed to expire pending changes: %w", err)
+    }
+    return nil
+}
+
+// GetPendingChanges expires any stale changes, then returns those in a
+// state (all states if empty), oldest first
+func (db *Database) GetPendingChanges(state string, limit int) ([]PendingChange, error) {
+    if err := expirePendingChanges(db.DB); err != nil {
+        return nil, err
+    }
+    return queryPendingChanges(db.DB, `
+        WHERE $1 = '' OR state = $1
+        ORDER BY id
+        LIMIT $2
+    `, state, limit)
+}
+
+// queryPendingChanges selects pending change rows
+func queryPendingChanges(q querier, where string, args ...interface{}) ([]PendingChange, error) {
+    rows, err := q.Query(`
+        SELECT id, email, action, COALESCE(from_status, ''), to_status, source, COALESCE(frequency, ''),
+               COALESCE(campaign, ''), paid_at, proposed_at, expires_at, state, decided_at,
+               COALESCE(decided_by, ''), COALESCE(note, ''), COALESCE(sync_run_id, 0)
+        FROM pending_changes
+    `+where, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get pending changes: %w", err)
+    }
+    defer rows.Close()
+
+    changes := []PendingChange{}
+    for rows.Next() {
+        var c PendingChange
+        var paidAt, expiresAt, decidedAt sql.NullTime
+        err := rows.Scan(&c.ID, &c.Email, &c.Action, &c.FromStatus, &c.ToStatus, &c.Source, &c.Frequency,
+            &c.Campaign, &paidAt, &c.ProposedAt, &expiresAt, &c.State, &decidedAt,
+            &c.DecidedBy, &c.Note, &c.SyncRunID)
+        if err != nil {
+            return nil, err
+        }
+        if paidAt.Valid {
+            c.PaidAt = &paidAt.Time
+        }
+        if expiresAt.Valid {
+            c.ExpiresAt = &expiresAt.Time
+        }
+        if decidedAt.Valid {
+            c.DecidedAt = &decidedAt.Time
+        }
+        changes = append(changes, c)
+    }
+
+    return changes, rows.Err()
+}
+
+// lockPendingChanges loads and locks the given pending changes, or with no
+// ids every pending one. Each id must exist and still be pending.
+func lockPendingChanges(tx *sql.Tx, ids []int) ([]PendingChange, error) {
+    if len(ids) == 0 {
+        return queryPendingChanges(tx, `WHERE state = $1 ORDER BY id FOR UPDATE`, pendingStatePending)
+    }
+
+    wanted := make([]int64, len(ids))
+    for i, id := range ids {
+        wanted[i] = int64(id)
+    }
+    changes, err := queryPendingChanges(tx, `WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(wanted))
+    if err != nil {
+        return nil, err
+    }
+
+    found := make(map[int]PendingChange, len(changes))
+    for _, c := range changes {
+        found[c.ID] = c
+    }
+    for _, id := range ids {
+        c, ok := found[id]
+        if !ok {
+            return nil, fmt.Errorf("%w: #%d", ErrPendingChangeNotFound, id)
+        }
+        if c.State != pendingStatePending {
+            return nil, fmt.Errorf("%w: #%d is %s", ErrPendingChangeDecided, id, c.State)
+        }
+    }
+    return changes, nil
+}
+
+// ApprovePendingChanges applies the given pending changes, or with no ids
+// every pending one, in one transaction recorded as a sync run. Each
+// member's history names the change, where it was proposed, and who
+// approved it. A member whose status has changed since the proposal is
+// skipped rather than overwritten. Nothing is applied when the changes
+// would deactivate more than maxDeactivatePercent of active members; pass
+// noDeactivateLimit to skip the check.
+func (db *Database) ApprovePendingChanges(ids []int, by string, maxDeactivatePercent int) (*ReviewResult, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    if err := expirePendingChanges(tx); err != nil {
+        return nil, err
+    }
+    changes, err := lockPendingChanges(tx, ids)
+    if err != nil {
+        return nil, err
+    }
+
+    if maxDeactivatePercent >= 0 {
+        var active int
+        err = tx.QueryRow(`SELECT COUNT(*) FROM members WHERE status = $1`, StatusActive).Scan(&active)
+        if err != nil {
+            return nil, fmt.Errorf("failed to count active members: %w", err)
+        }
+        if err := checkPendingDeactivations(changes, active, maxDeactivatePercent); err != nil {
+            return nil, err
+        }
+    }
+
+    result := &ReviewResult{Approved: []PendingChange{}, Skipped: []PendingChange{}}
+    if len(changes) == 0 {
+        return result, tx.Commit()
+    }
+
+    err = tx.QueryRow(`
+        INSERT INTO sync_runs (input_file) VALUES ($1) RETURNING id
+    `, "review by "+by).Scan(&result.SyncRunID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to record sync run: %w", err)
+    }
+
+    counts := map[string]int{}
+    for _, c := range changes {
+        change := ChangeSource{Source: "review", Detail: fmt.Sprintf("pending change #%d from %s, approved by %s", c.ID, c.Source, by)}
+
+        skip, err := db.applyPendingChange(tx, result.SyncRunID, c, change)
+        if err != nil {
+            return nil, fmt.Errorf("failed to apply pending change #%d: %w", c.ID, err)
+        }
+
+        state, runID := pendingStateApproved, result.SyncRunID
+        if skip != "" {
+            state, runID = pendingStateSkipped, 0
+        }
+        _, err = tx.Exec(`
+            UPDATE pending_changes SET state = $2, decided_at = CURRENT_TIMESTAMP, decided_by = NULLIF($3, ''),
+                note = NULLIF($4, ''), sync_run_id = NULLIF($5, 0)
+            WHERE id = $1
+        `, c.ID, state, by, skip, runID)
+        if err != nil {
+            return nil, fmt.Errorf("failed to record decision on pending change #%d: %w", c.ID, err)
+        }
+
+        c.State, c.DecidedBy, c.Note = state, by, skip
+        if skip != "" {
+            result.Skipped = append(result.Skipped, c)
+            continue
+        }
+        c.SyncRunID = runID
+        result.Approved = append(result.Approved, c)
+        counts[c.Action]++
+    }
+
+    _, err = tx.Exec(`
+        UPDATE sync_runs SET
+            status = 'applied',
+            finished_at = CURRENT_TIMESTAMP,
+            added = $2,
+            reactivated = $3,
+            deactivated = $4,
+            suspended = $5
+        WHERE id = $1
+    `, result.SyncRunID, counts[pendingAdd], counts[pendingReactivate], counts[pendingDeactivate], counts[pendingSuspend])
+    if err != nil {
+        return nil, fmt.Errorf("failed to finish sync run: %w", err)
+    }
+
+    review := ChangeSource{Source: "review", Detail: fmt.Sprintf("sync run #%d, approved by %s", result.SyncRunID, by)}
+    err = recordEvent(tx, feedSyncApplied, 0, review, map[string]interface{}{
+        "run_id":      result.SyncRunID,
+        "input_file":  "review by " + by,
+        "added":       counts[pendingAdd],
+        "reactivated": counts[pendingReactivate],
+        "deactivated": counts[pendingDeactivate],
+        "suspended":   counts[pendingSuspend],
+        "skipped":     len(result.Skipped),
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    db.logger.Printf("Approved %d pending changes as sync run #%d (%d skipped) by %s",
+        len(result.Approved), result.SyncRunID, len(result.Skipped), by)
+
+    return result, nil
+}
+
+// applyPendingChange makes one approved change within tx as part of sync
+// run runID. It returns why the change was skipped, or "" once applied.
+func (db *Database) applyPendingChange(tx *sql.Tx, runID int, c PendingChange, change ChangeSource) (string, error) {
+    var current string
+    err := tx.QueryRow(`SELECT status FROM members WHERE email = $1`, c.Email).Scan(&current)
+    if err != nil && err != sql.ErrNoRows {
+        return "", err
+    }
+    if current != c.FromStatus {
+        if current == "" {
+            return "member no longer exists", nil
+        }
+        if c.FromStatus == "" {
+            return "member has been added since", nil
+        }
+        return fmt.Sprintf("member is %s now, not %s", current, c.FromStatus), nil
+    }
+
+    if c.Action == pendingAdd {
+        if _, err := db.processMember(tx, c.Email, "", false, c.ToStatus, change); err != nil {
+            return "", err
+        }
+        if err := db.setMemberCampaign(tx, c.Email, c.Campaign); err != nil {
+            return "", err
+        }
+    } else {
+        err := db.updateMemberStatus(tx, c.Email, c.ToStatus, change)
+        if errors.Is(err, ErrHouseholdMember) || errors.Is(err, ErrInvalidTransition) {
+            return err.Error(), nil
+        } else if err != nil {
+            return "", err
+        }
+    }
+
+    if c.PaidAt != nil {
+        if err := db.recordPayment(tx, c.Email, *c.PaidAt); err != nil {
+            return "", err
+        }
+    }
+    if c.Frequency != "" {
+        if err := db.setMemberFrequency(tx, c.Email, c.Frequency); err != nil {
+            return "", err
+        }
+    }
+    return "", recordSyncChange(tx, runID, c.Email, c.FromStatus, c.ToStatus)
+}
+
+// RejectPendingChanges discards the given pending changes, noting who
+// rejected them and why
+func (db *Database) RejectPendingChanges(ids []int, by, reason string) error {
+    return db.inTx(func(tx *sql.Tx) error {
+        if err := expirePendingChanges(tx); err != nil {
+            return err
+        }
+        if _, err := lockPendingChanges(tx, ids); err != nil {
+            return err
+        }
+
+        wanted := make([]int64, len(ids))
+        for i, id := range ids {
+            wanted[i] = int64(id)
+        }
+        _, err := tx.Exec(`
+            UPDATE pending_changes SET state = $2, decided_at = CURRENT_TIMESTAMP, decided_by = NULLIF($3, ''), note = NULLIF($4, '')
+            WHERE id = ANY($1)
+        `, pq.Array(wanted), pendingStateRejected, by, strings.TrimSpace(reason))
+        if err != nil {
+            return fmt.Errorf("failed to reject pending changes: %w", err)
+        }
+        return nil
+    })
+}
+
+// pendingChangesHandler serves GET /pending-changes, the changes awaiting
+// review, or those in another state with ?state=
+func (s *WebhookServer) pendingChangesHandler(w http.ResponseWriter, r *http.Request) {
+    state := r.URL.Query().Get("state")
+    switch state {
+    case "":
+        state = pendingStatePending
+    case "all":
+        state = ""
+    case pendingStatePending, pendingStateApproved, pendingStateRejected, pendingStateExpired, pendingStateSuperseded, pendingStateSkipped:
+    default:
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "state must be pending, approved, rejected, expired, superseded, skipped, or all")
+        return
+    }
+
+    limit := 1000
+    if value := r.URL.Query().Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid limit")
+            return
+        }
+        limit = n
+    }
+
+    changes, err := s.db.GetPendingChanges(state, limit)
+    if err != nil {
+        s.logger.Printf("Error getting pending changes: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(changes)
+}
+
+// pendingChangeID reads the {id} path value, answering 400 if it isn't one
+func pendingChangeID(w http.ResponseWriter, r *http.Request) (int, bool) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid pending change ID")
+        return 0, false
+    }
+    return id, true
+}
+
+// writePendingChangeError answers for an approval or rejection that failed
+func (s *WebhookServer) writePendingChangeError(w http.ResponseWriter, r *http.Request, err error) {
+    switch {
+    case errors.Is(err, ErrPendingChangeNotFound):
+        writeError(w, r, http.StatusNotFound, errNotFound, err.Error())
+    case errors.Is(err, ErrPendingChangeDecided):
+        writeError(w, r, http.StatusConflict, errConflict, err.Error())
+    case errors.Is(err, ErrTooManyDeactivations):
+        writeError(w, r, http.StatusConflict, errConflict, err.Error()+"; approve with ?force=true if this is intended")
+    default:
+        s.logger.Printf("Error reviewing pending changes: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+    }
+}
+
+// approvePendingChangeHandler applies one pending change
+func (s *WebhookServer) approvePendingChangeHandler(w http.ResponseWriter, r *http.Request) {
+    id, ok := pendingChangeID(w, r)
+    if !ok {
+        return
+    }
+    s.approvePendingChanges(w, r, []int{id}, noDeactivateLimit)
+}
+
+// noDeactivateLimit lets ApprovePendingChanges deactivate any number of
+// members
+const noDeactivateLimit = -1
+
+// checkPendingDeactivations refuses to approve pending changes whose
+// deactivations are more than maxPercent of the active members, the same
+// guard clean applies before applying or queueing them. A negative
+// maxPercent is no limit.
+func checkPendingDeactivations(pending []PendingChange, activeCount, maxPercent int) error {
+    if maxPercent < 0 {
+        return nil
+    }
+
+    changes := &ChangeSet{ActiveCount: activeCount}
+    for _, c := range pending {
+        if c.Action == pendingDeactivate {
+            changes.Deactivate = append(changes.Deactivate, c.Email)
+        }
+    }
+
+    if changes.TooManyDeactivations(maxPercent) {
+        return fmt.Errorf("%w: refusing to deactivate %d of %d active members", ErrTooManyDeactivations, len(changes.Deactivate), changes.ActiveCount)
+    }
+    return nil
+}
+
+// approveAllPendingChangesHandler applies every pending change. It refuses
+// to deactivate more than PENDING_MAX_DEACTIVATE_PERCENT of active members
+// with 409 unless ?force=true is given.
+func (s *WebhookServer) approveAllPendingChangesHandler(w http.ResponseWriter, r *http.Request) {
+    maxPercent := s.config.PendingMaxDeactivatePercent
+    if r.URL.Query().Get("force") == "true" {
+        maxPercent = noDeactivateLimit
+    }
+    s.approvePendingChanges(w, r, nil, maxPercent)
+}
+
+func (s *WebhookServer) approvePendingChanges(w http.ResponseWriter, r *http.Request, ids []int, maxDeactivatePercent int) {
+    result, err := s.db.ApprovePendingChanges(ids, s.principal(r), maxDeactivatePercent)
+    if err != nil {
+        s.writePendingChangeError(w, r, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(result)
+}
+
+// rejectPendingChangeHandler discards one pending change. The body may
+// give {"reason": "..."}.
+func (s *WebhookServer) rejectPendingChangeHandler(w http.ResponseWriter, r *http.Request) {
+    id, ok := pendingChangeID(w, r)
+    if !ok {
+        return
+    }
+
+    var req struct {
+        Reason string `json:"reason"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"reason\": \"...\"}")
+            return
+        }
+    }
+
+    if err := s.db.RejectPendingChanges([]int{id}, s.principal(r), req.Reason); err != nil {
+        s.writePendingChangeError(w, r, err)
+        return
+    }
+    s.logger.Printf("Pending change #%d rejected by %s", id, s.principal(r))
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// printPendingChange prints one pending change for memberships pending
+func printPendingChange(c PendingChange) {
+    from := c.FromStatus
+    if from == "" {
+        from = "new"
+    }
+    fmt.Printf("  #%-5d %-10s %-40s %s -> %s (from %s, %s)", c.ID, c.Action, c.Email, from, c.ToStatus, c.Source, displayTime(c.ProposedAt))
+    if c.State == pendingStatePending && c.ExpiresAt != nil {
+        fmt.Printf(", expires %s", displayDate(*c.ExpiresAt))
+    }
+    if c.State != pendingStatePending {
+        fmt.Printf(" %s", c.State)
+        if c.DecidedBy != "" {
+            fmt.Printf(" by %s", c.DecidedBy)
+        }
+    }
+    if c.Note != "" {
+        fmt.Printf(": %s", c.Note)
+    }
+    fmt.Println()
+}
+
+func runPending(logger *log.Logger) {
+    usage := `memberships pending <list|approve|approve-all|reject> [args]`
+    if len(os.Args) < 3 {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+        os.Exit(2)
+    }
+
+    action := os.Args[2]
+    pendingCmd := flag.NewFlagSet("pending "+action, flag.ExitOnError)
+    state := pendingCmd.String("state", pendingStatePending, "With list: pending, approved, rejected, expired, superseded, skipped, or all")
+    reason := pendingCmd.String("reason", "", "With reject: why the change was rejected")
+    by := pendingCmd.String("by", os.Getenv("USER"), "Who is reviewing (default: $USER)")
+    maxDeactivate := pendingCmd.Int("max-deactivate-percent", -1, "With approve-all: refuse to deactivate more than this percentage of active members (default PENDING_MAX_DEACTIVATE_PERCENT or 25)")
+    force := pendingCmd.Bool("force", false, "With approve-all: approve even if the changes exceed --max-deactivate-percent")
+
+    args := parseSubcommand(pendingCmd, usage, os.Args[3:])
+
+    parseIDs := func() []int {
+        if len(args) < 1 {
+            fmt.Fprintf(os.Stderr, "Error: pending %s requires a pending change ID\n", action)
+            os.Exit(2)
+        }
+        ids := make([]int, len(args))
+        for i, arg := range args {
+            id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error: invalid pending change ID %q\n", arg)
+                os.Exit(2)
+            }
+            ids[i] = id
+        }
+        return ids
+    }
+
+    approve := func(db *Database, ids []int, maxDeactivatePercent int) {
+        result, err := db.ApprovePendingChanges(ids, *by, maxDeactivatePercent)
+        if errors.Is(err, ErrPendingChangeNotFound) || errors.Is(err, ErrPendingChangeDecided) {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        } else if errors.Is(err, ErrTooManyDeactivations) {
+            fmt.Fprintf(os.Stderr, "Error: %v; check the pending changes or re-run with --force\n", err)
+            os.Exit(1)
+        } else if err != nil {
+            logger.Fatalf("Approve failed: %v", err)
+        }
+        if len(result.Approved) == 0 && len(result.Skipped) == 0 {
+            fmt.Println("No pending changes")
+            return
+        }
+        for _, c := range result.Skipped {
+            fmt.Printf("  Skipped #%d (%s %s): %s\n", c.ID, c.Action, c.Email, c.Note)
+        }
+        fmt.Printf("Approved %d changes as sync run #%d (undo with: memberships undo %d)\n",
+            len(result.Approved), result.SyncRunID, result.SyncRunID)
+    }
+
+    switch action {
+    case "list":
+        filter := *state
+        if filter == "all" {
+            filter = ""
+        }
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        changes, err := db.GetPendingChanges(filter, 10000)
+        if err != nil {
+            logger.Fatalf("List failed: %v", err)
+        }
+        if len(changes) == 0 {
+            fmt.Println("No pending changes")
+            return
+        }
+        for _, c := range changes {
+            printPendingChange(c)
+        }
+
+    case "approve":
+        ids := parseIDs()
+
+        db := connectDatabase(logger)
+        defer db.Close()
+        approve(db, ids, noDeactivateLimit)
+
+    case "approve-all":
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        if *force {
+            *maxDeactivate = noDeactivateLimit
+        } else if *maxDeactivate < 0 {
+            *maxDeactivate = mustLoadConfig(logger).PendingMaxDeactivatePercent
+        }
+        approve(db, nil, *maxDeactivate)
+
+    case "reject":
+        ids := parseIDs()
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        err := db.RejectPendingChanges(ids, *by, *reason)
+        if errors.Is(err, ErrPendingChangeNotFound) || errors.Is(err, ErrPendingChangeDecided) {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        } else if err != nil {
+            logger.Fatalf("Reject failed: %v", err)
+        }
+        fmt.Printf("Rejected %d pending changes\n", len(ids))
+
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown action %q\nUsage: %s\n", action, usage)
+        os.Exit(2)
+    }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestApproveAllRefusesMassDeactivation(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    seedMembers(t, db, map[string]string{
+        "a@example.org": StatusActive,
+        "b@example.org": StatusActive,
+        "c@example.org": StatusActive,
+        "d@example.org": StatusActive,
+    })
+    err := db.QueuePendingChanges([]PendingChange{
+        {Email: "a@example.org", Action: pendingDeactivate, ToStatus: StatusCancelled, Source: "export.csv"},
+        {Email: "b@example.org", Action: pendingDeactivate, ToStatus: StatusCancelled, Source: "export.csv"},
+    }, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    resp := do(t, server, "POST", "/pending-changes/approve-all", testAdminToken, "")
+    expectStatus(t, resp, http.StatusConflict)
+    if got := statusesOf(t, db); got["a@example.org"] != StatusActive {
+        t.Errorf("a refused approval changed statuses: %v", got)
+    }
+
+    resp = do(t, server, "POST", "/pending-changes/approve-all?force=true", testAdminToken, "")
+    expectStatus(t, resp, http.StatusOK)
+    var result ReviewResult
+    decode(t, resp, &result)
+    if len(result.Approved) != 2 {
+        t.Errorf("approved %d changes, want 2", len(result.Approved))
+    }
+    if got := statusesOf(t, db); got["a@example.org"] != StatusCancelled || got["b@example.org"] != StatusCancelled {
+        t.Errorf("a forced approval left statuses as %v", got)
+    }
+}
+
+func TestApproveAllWithinLimit(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    seedMembers(t, db, map[string]string{
+        "a@example.org": StatusActive,
+        "b@example.org": StatusActive,
+        "c@example.org": StatusActive,
+        "d@example.org": StatusActive,
+    })
+    db.QueuePendingChanges([]PendingChange{
+        {Email: "a@example.org", Action: pendingDeactivate, ToStatus: StatusCancelled, Source: "export.csv"},
+        {Email: "new@example.org", Action: pendingAdd, ToStatus: StatusActive, Source: "export.csv"},
+    }, nil)
+
+    expectStatus(t, do(t, server, "POST", "/pending-changes/approve-all", testAdminToken, ""), http.StatusOK)
+    if got := statusesOf(t, db); got["a@example.org"] != StatusCancelled || got["new@example.org"] != StatusActive {
+        t.Errorf("statuses = %v", got)
+    }
+}
+
+func TestApproveAllUsesConfiguredLimit(t *testing.T) {
+    config := testConfig()
+    config.PendingMaxDeactivatePercent = 50
+    server, db := newTestServer(t, config)
+    seedMembers(t, db, map[string]string{
+        "a@example.org": StatusActive,
+        "b@example.org": StatusActive,
+        "c@example.org": StatusActive,
+        "d@example.org": StatusActive,
+    })
+    db.QueuePendingChanges([]PendingChange{
+        {Email: "a@example.org", Action: pendingDeactivate, ToStatus: StatusCancelled, Source: "export.csv"},
+        {Email: "b@example.org", Action: pendingDeactivate, ToStatus: StatusCancelled, Source: "export.csv"},
+    }, nil)
+
+    expectStatus(t, do(t, server, "POST", "/pending-changes/approve-all", testAdminToken, ""), http.StatusOK)
+    if got := statusesOf(t, db); got["a@example.org"] != StatusCancelled || got["b@example.org"] != StatusCancelled {
+        t.Errorf("statuses = %v", got)
+    }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "io"
+    "log"
+    "os"
+    "testing"
+)
+
+// pgTestDomain is the domain of every member tests write to Postgres
+const pgTestDomain = "pgtest.example.invalid"
+
+// openTestDatabase connects to the database named by
+// MEMBERSHIPS_TEST_DATABASE_URL, for tests whose behavior depends on SQL that
+// memStore only imitates, and skips the test when it isn't set. The database
+// must be migrated; members under pgTestDomain are removed before and after
+// the test, with their webhook logs.
+//
+//	MEMBERSHIPS_TEST_DATABASE_URL=postgres://localhost/memberships_test go test
+func openTestDatabase(t *testing.T) *Database {
+    t.Helper()
+    url := os.Getenv("MEMBERSHIPS_TEST_DATABASE_URL")
+    if url == "" {
+        t.Skip("MEMBERSHIPS_TEST_DATABASE_URL is not set")
+    }
+    db, err := NewDatabase(url, log.New(io.Discard, "", 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    removeAll := func() {
+        removeTestMembers(t, db, `SELECT id FROM members WHERE email LIKE '%@`+pgTestDomain+`'`)
+        if _, err := db.Exec(`DELETE FROM webhook_logs WHERE email LIKE '%@` + pgTestDomain + `'`); err != nil {
+            t.Fatal(err)
+        }
+    }
+    removeAll()
+    t.Cleanup(func() {
+        removeAll()
+        db.Close()
+    })
+    return db
+}
+
+// removeTestMembers deletes the members a query selects the ids of, with
+// their events, which outlive their member otherwise
+func removeTestMembers(t *testing.T, db *Database, ids string, args ...interface{}) {
+    t.Helper()
+    _, err := db.Exec(`DELETE FROM events WHERE member_id IN (`+ids+`)`, args...)
+    if err == nil {
+        _, err = db.Exec(`DELETE FROM members WHERE id IN (`+ids+`)`, args...)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+}
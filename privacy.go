@@ -0,0 +1,380 @@
+package main
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+)
+
+// Privacy modes (PRIVACY_MODE). Off stores addresses as they are; hash
+// stores only an HMAC of each address; encrypt also keeps the address
+// encrypted so integrations like Mailchimp can still be synced.
+const (
+    privacyOff     = "off"
+    privacyHash    = "hash"
+    privacyEncrypt = "encrypt"
+)
+
+// emailKeyPrefix marks an email column holding an HMAC rather than an address
+const emailKeyPrefix = "hmac:"
+
+// pepperCheckKey is the settings row holding a fingerprint of EMAIL_PEPPER,
+// so starting with a different pepper fails instead of missing every member
+const pepperCheckKey = "email_pepper_check"
+
+// EmailPrivacy turns addresses into the keyed hashes stored in privacy mode
+// and, in encrypt mode, seals and opens the addresses themselves
+type EmailPrivacy struct {
+    Mode string
+
+    pepper []byte
+    aead   cipher.AEAD
+}
+
+// NewEmailPrivacy builds the privacy settings for mode. encryptionKey is
+// only used, and then required, in encrypt mode.
+func NewEmailPrivacy(mode, pepper, encryptionKey string) (*EmailPrivacy, error) {
+    if pepper == "" {
+        return nil, fmt.Errorf("EMAIL_PEPPER is required when PRIVACY_MODE is %s: members are looked up by an HMAC of their email keyed with it", mode)
+    }
+    p := &EmailPrivacy{Mode: mode, pepper: []byte(pepper)}
+    if mode != privacyEncrypt {
+        return p, nil
+    }
+
+    if encryptionKey == "" {
+        return nil, fmt.Errorf("EMAIL_ENCRYPTION_KEY is required when PRIVACY_MODE is encrypt")
+    }
+    key, err := decodeEncryptionKey(encryptionKey)
+    if err != nil {
+        return nil, fmt.Errorf("EMAIL_ENCRYPTION_KEY: %w", err)
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("EMAIL_ENCRYPTION_KEY: %w", err)
+    }
+    if p.aead, err = cipher.NewGCM(block); err != nil {
+        return nil, fmt.Errorf("EMAIL_ENCRYPTION_KEY: %w", err)
+    }
+    return p, nil
+}
+
+// decodeEncryptionKey accepts a 32-byte key as hex or base64
+func decodeEncryptionKey(value string) ([]byte, error) {
+    if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+        return key, nil
+    }
+    if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+        return key, nil
+    }
+    return nil, fmt.Errorf("must be 32 bytes, hex or base64 encoded (e.g. openssl rand -hex 32)")
+}
+
+// isEmailKey reports whether s is an email key rather than an address
+func isEmailKey(s string) bool {
+    s = strings.TrimSpace(s)
+    if !strings.HasPrefix(s, emailKeyPrefix) || len(s) != len(emailKeyPrefix)+64 {
+        return false
+    }
+    _, err := hex.DecodeString(s[len(emailKeyPrefix):])
+    return err == nil
+}
+
+// Key returns the lookup key for an already-normalized email
+func (p *EmailPrivacy) Key(normalizedEmail string) string {
+    mac := hmac.New(sha256.New, p.pepper)
+    mac.Write([]byte(normalizedEmail))
+    return emailKeyPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// fingerprint identifies the pepper without revealing it
+func (p *EmailPrivacy) fingerprint() string {
+    mac := hmac.New(sha256.New, p.pepper)
+    mac.Write([]byte("memberships pepper check"))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CanDecrypt reports whether addresses are kept, encrypted, alongside keys
+func (p *EmailPrivacy) CanDecrypt() bool {
+    return p.aead != nil
+}
+
+// Seal encrypts an address for members.email_ciphertext
+func (p *EmailPrivacy) Seal(email string) (string, error) {
+    nonce := make([]byte, p.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    sealed := p.aead.Seal(nonce, nonce, []byte(email), nil)
+    return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value written by Seal
+func (p *EmailPrivacy) Open(ciphertext string) (string, error) {
+    sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+    if err != nil {
+        return "", err
+    }
+    if len(sealed) < p.aead.NonceSize() {
+        return "", errors.New("ciphertext too short")
+    }
+    nonce, sealed := sealed[:p.aead.NonceSize()], sealed[p.aead.NonceSize():]
+    email, err := p.aead.Open(nil, nonce, sealed, nil)
+    if err != nil {
+        return "", fmt.Errorf("failed to decrypt email (wrong EMAIL_ENCRYPTION_KEY?): %w", err)
+    }
+    return string(email), nil
+}
+
+// storedEmail returns what to keep in raw_email and email_ciphertext for an
+// address as received. Privacy mode never keeps raw_email; encrypt mode
+// seals the address instead.
+func (db *Database) storedEmail(rawEmail string) (raw, ciphertext sql.NullString, err error) {
+    rawEmail = strings.TrimSpace(rawEmail)
+    if db.Privacy == nil {
+        return sql.NullString{String: rawEmail, Valid: true}, sql.NullString{}, nil
+    }
+    if !db.Privacy.CanDecrypt() || isEmailKey(rawEmail) {
+        return sql.NullString{}, sql.NullString{}, nil
+    }
+    sealed, err := db.Privacy.Seal(rawEmail)
+    if err != nil {
+        return sql.NullString{}, sql.NullString{}, fmt.Errorf("failed to encrypt email: %w", err)
+    }
+    return sql.NullString{}, sql.NullString{String: sealed, Valid: true}, nil
+}
+
+// redactPayload replaces a webhook payload's email with its key and drops
+// the name, keeping the rest so the webhook can still be retried
+func (db *Database) redactPayload(payload json.RawMessage) json.RawMessage {
+    var fields map[string]interface{}
+    if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+        return json.RawMessage(`{"redacted":true}`)
+    }
+    if email, ok := fields["email"].(string); ok && email != "" {
+        fields["email"] = db.NormalizeEmail(email)
+    }
+    delete(fields, "name")
+
+    redacted, err := json.Marshal(fields)
+    if err != nil {
+        return json.RawMessage(`{"redacted":true}`)
+    }
+    return redacted
+}
+
+// CheckPrivacy makes sure the privacy settings match how members are
+// stored: the same pepper they were hashed with, and no plaintext members
+// left when privacy mode is on. It records the pepper's fingerprint the
+// first time privacy mode is used on an empty members table.
+func (db *Database) CheckPrivacy() error {
+    var stored string
+    err := db.QueryRow(`SELECT value FROM settings WHERE key = $1`, pepperCheckKey).Scan(&stored)
+    if err != nil && err != sql.ErrNoRows {
+        // Without privacy mode there's nothing to enforce on a database
+        // that predates the settings table
+        if db.Privacy == nil {
+            return nil
+        }
+        return fmt.Errorf("failed to read pepper fingerprint: %w", err)
+    }
+
+    if db.Privacy == nil {
+        if stored != "" {
+            return fmt.Errorf("members are stored as hashed emails but PRIVACY_MODE is off; set PRIVACY_MODE and the original EMAIL_PEPPER")
+        }
+        return nil
+    }
+
+    if stored != "" {
+        if stored != db.Privacy.fingerprint() {
+            return fmt.Errorf("EMAIL_PEPPER has changed since members were hashed, so no member would be found; restore the original EMAIL_PEPPER")
+        }
+        return nil
+    }
+
+    var plaintext int
+    err = db.QueryRow(`SELECT COUNT(*) FROM members WHERE email NOT LIKE $1`, emailKeyPrefix+"%").Scan(&plaintext)
+    if err != nil {
+        return fmt.Errorf("failed to count members: %w", err)
+    }
+    if plaintext > 0 {
+        return fmt.Errorf("PRIVACY_MODE is %s but %d members have plaintext emails; run memberships encrypt-existing first", db.Privacy.Mode, plaintext)
+    }
+    return db.recordPepper(db)
+}
+
+// recordPepper stores the current pepper's fingerprint
+func (db *Database) recordPepper(q querier) error {
+    _, err := q.Exec(`
+        INSERT INTO settings (key, value) VALUES ($1, $2)
+        ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP
+    `, pepperCheckKey, db.Privacy.fingerprint())
+    if err != nil {
+        return fmt.Errorf("failed to record pepper fingerprint: %w", err)
+    }
+    return nil
+}
+
+// EncryptResult counts what EncryptExisting converted
+type EncryptResult struct {
+    Members     int
+    WebhookLogs int
+}
+
+// EncryptExisting converts plaintext members and webhook logs to privacy
+// mode in one transaction: emails become keys, raw_email is cleared (and
+// sealed into email_ciphertext in encrypt mode), and webhook payloads are
+// redacted
+func (db *Database) EncryptExisting() (*EncryptResult, error) {
+    var stored string
+    err := db.QueryRow(`SELECT value FROM settings WHERE key = $1`, pepperCheckKey).Scan(&stored)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, fmt.Errorf("failed to read pepper fingerprint: %w", err)
+    }
+    if stored != "" && stored != db.Privacy.fingerprint() {
+        return nil, fmt.Errorf("EMAIL_PEPPER has changed since members were hashed; restore the original EMAIL_PEPPER before converting")
+    }
+
+    result := &EncryptResult{}
+    err = db.inTx(func(tx *sql.Tx) error {
+        type plainMember struct {
+            id       int
+            email    string
+            rawEmail string
+        }
+        rows, err := tx.Query(`
+            SELECT id, email, COALESCE(raw_email, email) FROM members
+            WHERE email NOT LIKE $1 ORDER BY id FOR UPDATE
+        `, emailKeyPrefix+"%")
+        if err != nil {
+            return fmt.Errorf("failed to load members: %w", err)
+        }
+        var members []plainMember
+        for rows.Next() {
+            var m plainMember
+            if err := rows.Scan(&m.id, &m.email, &m.rawEmail); err != nil {
+                rows.Close()
+                return err
+            }
+            members = append(members, m)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+
+        for _, m := range members {
+            key := db.NormalizeEmail(m.email)
+            _, ciphertext, err := db.storedEmail(m.rawEmail)
+            if err != nil {
+                return err
+            }
+            _, err = tx.Exec(`
+                UPDATE members SET email = $2, email_hash = $3, raw_email = NULL, email_ciphertext = $4
+                WHERE id = $1
+            `, m.id, key, emailHash(key), ciphertext)
+            if err != nil {
+                return fmt.Errorf("failed to convert member %d (two addresses may share a key; run memberships dedupe --merge first): %w", m.id, err)
+            }
+        }
+        result.Members = len(members)
+
+        type plainLog struct {
+            id      int
+            email   string
+            payload json.RawMessage
+        }
+        rows, err = tx.Query(`
+            SELECT id, COALESCE(email, ''), payload FROM webhook_logs
+            WHERE email IS NULL OR email NOT LIKE $1 ORDER BY id FOR UPDATE
+        `, emailKeyPrefix+"%")
+        if err != nil {
+            return fmt.Errorf("failed to load webhook logs: %w", err)
+        }
+        var logs []plainLog
+        for rows.Next() {
+            var l plainLog
+            if err := rows.Scan(&l.id, &l.email, &l.payload); err != nil {
+                rows.Close()
+                return err
+            }
+            logs = append(logs, l)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return err
+        }
+
+        for _, l := range logs {
+            var email sql.NullString
+            if l.email != "" {
+                email = sql.NullString{String: db.NormalizeEmail(l.email), Valid: true}
+            }
+            var payload interface{}
+            if l.payload != nil {
+                payload = db.redactPayload(l.payload)
+            }
+            _, err := tx.Exec(`UPDATE webhook_logs SET email = $2, payload = $3 WHERE id = $1`, l.id, email, payload)
+            if err != nil {
+                return fmt.Errorf("failed to redact webhook log %d: %w", l.id, err)
+            }
+        }
+        result.WebhookLogs = len(logs)
+
+        return db.recordPepper(tx)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// runEncryptExisting converts an existing database to privacy mode
+func runEncryptExisting(logger *log.Logger) {
+    encryptCmd := flag.NewFlagSet("encrypt-existing", flag.ExitOnError)
+    confirm := encryptCmd.Bool("confirm", false, "Confirm the conversion, which can't be undone without a backup")
+
+    parseSubcommand(encryptCmd, "memberships encrypt-existing --confirm", os.Args[2:])
+
+    config := mustLoadConfig(logger)
+    if config.Privacy == nil {
+        logger.Fatal("PRIVACY_MODE must be hash or encrypt to convert existing members")
+    }
+
+    logger.Println("Connecting to database...")
+    db, err := NewDatabase(config.DatabaseURL, logger)
+    if err != nil {
+        logger.Fatalf("Failed to connect to database: %v", err)
+    }
+    defer db.Close()
+    db.NormalizeEmails = config.NormalizeEmails
+    db.Privacy = config.Privacy
+
+    if !*confirm {
+        fmt.Printf("Error: encrypt-existing replaces every stored email with its HMAC under EMAIL_PEPPER (PRIVACY_MODE=%s)", config.Privacy.Mode)
+        if !config.Privacy.CanDecrypt() {
+            fmt.Print(" and keeps no copy of the addresses")
+        }
+        fmt.Println("; losing the pepper afterwards makes members impossible to look up. Take a backup, then re-run with --confirm")
+        os.Exit(1)
+    }
+
+    result, err := db.EncryptExisting()
+    if err != nil {
+        logger.Fatalf("Conversion failed: %v", err)
+    }
+    fmt.Printf("Converted %d members and %d webhook logs\n", result.Members, result.WebhookLogs)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+)
+
+// parseTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of CIDRs
+// or single addresses
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+    if strings.TrimSpace(value) == "" {
+        return nil, nil
+    }
+
+    var nets []*net.IPNet
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if !strings.Contains(entry, "/") {
+            ip := net.ParseIP(entry)
+            if ip == nil {
+                return nil, fmt.Errorf("%q is not an IP address or CIDR", entry)
+            }
+            bits := 128
+            if ip.To4() != nil {
+                ip, bits = ip.To4(), 32
+            }
+            nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+            continue
+        }
+        _, ipNet, err := net.ParseCIDR(entry)
+        if err != nil {
+            return nil, fmt.Errorf("%q is not an IP address or CIDR", entry)
+        }
+        nets = append(nets, ipNet)
+    }
+    return nets, nil
+}
+
+// isTrustedProxy reports whether addr is in TRUSTED_PROXIES
+func (s *WebhookServer) isTrustedProxy(addr string) bool {
+    ip := net.ParseIP(addr)
+    if ip == nil {
+        return false
+    }
+    for _, ipNet := range s.config.TrustedProxies {
+        if ipNet.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// clientIP returns the address a request came from. Forwarding headers are
+// only believed when the direct peer is a trusted proxy, or the request came
+// over the Unix socket, which only the proxy can open; otherwise anyone
+// could claim any address. X-Forwarded-For is read right to left, skipping
+// our own proxies, so a client can't hide behind an entry it made up.
+func (s *WebhookServer) clientIP(r *http.Request) string {
+    peer, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        peer = r.RemoteAddr
+    }
+
+    viaSocket := s.config.ListenSocket != "" && net.ParseIP(peer) == nil
+    if len(s.config.TrustedProxies) == 0 || (!viaSocket && !s.isTrustedProxy(peer)) {
+        return peer
+    }
+
+    var hops []string
+    for _, header := range r.Header.Values("X-Forwarded-For") {
+        for _, hop := range strings.Split(header, ",") {
+            hops = append(hops, strings.TrimSpace(hop))
+        }
+    }
+    for i := len(hops) - 1; i >= 0; i-- {
+        if net.ParseIP(hops[i]) == nil {
+            // Garbage in the chain; nothing before it can be trusted
+            break
+        }
+        if !s.isTrustedProxy(hops[i]) || i == 0 {
+            return hops[i]
+        }
+    }
+
+    if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+        return realIP
+    }
+    return peer
+}
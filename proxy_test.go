@@ -0,0 +1,87 @@
+package main
+
+import (
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+    nets, err := parseTrustedProxies(" 10.0.0.0/8, 192.0.2.1 ,::1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var got []string
+    for _, n := range nets {
+        got = append(got, n.String())
+    }
+    if want := "10.0.0.0/8 192.0.2.1/32 ::1/128"; strings.Join(got, " ") != want {
+        t.Errorf("parsed %v, want %s", got, want)
+    }
+
+    if nets, err := parseTrustedProxies("  "); err != nil || nets != nil {
+        t.Errorf("blank: %v, %v", nets, err)
+    }
+    for _, bad := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.1,"} {
+        if _, err := parseTrustedProxies(bad); err == nil {
+            t.Errorf("%q was accepted", bad)
+        }
+    }
+}
+
+func TestClientIP(t *testing.T) {
+    tests := []struct {
+        name    string
+        trusted string
+        socket  string
+        peer    string
+        xff     []string
+        realIP  string
+        want    string
+    }{
+        {name: "no proxies configured", peer: "203.0.113.7:4000", xff: []string{"198.51.100.1"}, want: "203.0.113.7"},
+        {name: "untrusted peer", trusted: "10.0.0.0/8", peer: "203.0.113.7:4000", xff: []string{"198.51.100.1"}, want: "203.0.113.7"},
+        {name: "untrusted peer with X-Real-IP", trusted: "10.0.0.0/8", peer: "203.0.113.7:4000", realIP: "198.51.100.1", want: "203.0.113.7"},
+        {name: "one trusted hop", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+        {name: "spoofed entry before the real client", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000",
+            xff: []string{"198.51.100.1, 203.0.113.7"}, want: "203.0.113.7"},
+        {name: "spoofed trusted address", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000",
+            xff: []string{"10.9.9.9, 203.0.113.7"}, want: "203.0.113.7"},
+        {name: "chain of trusted hops", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000",
+            xff: []string{"203.0.113.7, 10.0.0.5, 10.0.0.3"}, want: "203.0.113.7"},
+        {name: "every hop trusted", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000", xff: []string{"10.0.0.5, 10.0.0.3"}, want: "10.0.0.5"},
+        {name: "split across headers", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000",
+            xff: []string{"198.51.100.1", "203.0.113.7, 10.0.0.3"}, want: "203.0.113.7"},
+        {name: "garbage hop", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000", xff: []string{"203.0.113.7, unknown"}, want: "10.0.0.2"},
+        {name: "garbage hop with X-Real-IP", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000",
+            xff: []string{"203.0.113.7, unknown"}, realIP: "198.51.100.1", want: "198.51.100.1"},
+        {name: "X-Real-IP only", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000", realIP: "203.0.113.7", want: "203.0.113.7"},
+        {name: "bad X-Real-IP", trusted: "10.0.0.0/8", peer: "10.0.0.2:4000", realIP: "localhost", want: "10.0.0.2"},
+        {name: "IPv6", trusted: "::1", peer: "[::1]:4000", xff: []string{"2001:db8::7"}, want: "2001:db8::7"},
+        {name: "unix socket", trusted: "10.0.0.0/8", socket: "/run/memberships.sock", peer: "@", xff: []string{"203.0.113.7"}, want: "203.0.113.7"},
+        {name: "unix socket not configured", trusted: "10.0.0.0/8", peer: "@", xff: []string{"203.0.113.7"}, want: "@"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            config := testConfig()
+            config.ListenSocket = tt.socket
+            var err error
+            if config.TrustedProxies, err = parseTrustedProxies(tt.trusted); err != nil {
+                t.Fatal(err)
+            }
+            s := &WebhookServer{config: config}
+
+            r := httptest.NewRequest("GET", "/", nil)
+            r.RemoteAddr = tt.peer
+            for _, xff := range tt.xff {
+                r.Header.Add("X-Forwarded-For", xff)
+            }
+            if tt.realIP != "" {
+                r.Header.Set("X-Real-IP", tt.realIP)
+            }
+            if got := s.clientIP(r); got != tt.want {
+                t.Errorf("clientIP = %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
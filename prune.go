@@ -0,0 +1,166 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// pruneInterval is how often the server prunes old log rows
+const pruneInterval = 24 * time.Hour
+
+// defaultAccessLogRetention keeps access logs for a year unless
+// ACCESS_LOG_RETENTION says otherwise
+const defaultAccessLogRetention = 365 * 24 * time.Hour
+
+// pruneTarget is a log table whose old rows are deleted after a retention
+// period. where limits which rows may go at all.
+type pruneTarget struct {
+    Name      string
+    Table     string
+    TimeCol   string
+    Where     string
+    Retention time.Duration
+}
+
+// pruneTargets lists the tables pruned under the configured retention; a
+// zero retention keeps everything. Webhooks still waiting on a retry, or
+// that failed and need attention, are never pruned.
+func pruneTargets(config *Config) []pruneTarget {
+    return []pruneTarget{
+        {
+            Name:      "webhook logs",
+            Table:     "webhook_logs",
+            TimeCol:   "received_at",
+            Where:     "state IS NULL OR state = '" + webhookStateDone + "'",
+            Retention: config.WebhookLogRetention,
+        },
+        {
+            Name:      "access logs",
+            Table:     "access_logs",
+            TimeCol:   "accessed_at",
+            Retention: config.AccessLogRetention,
+        },
+        {
+            Name:      "job runs",
+            Table:     "jobs_history",
+            TimeCol:   "started_at",
+            Retention: config.JobHistoryRetention,
+        },
+    }
+}
+
+// PruneOlderThan deletes, or with dryRun counts, a target's rows older than
+// its retention
+func (db *Database) PruneOlderThan(target pruneTarget, dryRun bool) (int, error) {
+    where := target.TimeCol + " < $1"
+    if target.Where != "" {
+        where += " AND (" + target.Where + ")"
+    }
+    cutoff := time.Now().Add(-target.Retention)
+
+    var count int
+    var err error
+    if dryRun {
+        err = db.QueryRow(`SELECT COUNT(*) FROM `+target.Table+` WHERE `+where, cutoff).Scan(&count)
+    } else {
+        var result sql.Result
+        result, err = db.Exec(`DELETE FROM `+target.Table+` WHERE `+where, cutoff)
+        if err == nil {
+            n, _ := result.RowsAffected()
+            count = int(n)
+        }
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to prune %s: %w", target.Name, err)
+    }
+    return count, nil
+}
+
+// pruneAll prunes every target with a retention set
+func pruneAll(db Store, config *Config, dryRun bool, report func(target pruneTarget, count int)) error {
+    for _, target := range pruneTargets(config) {
+        if target.Retention <= 0 {
+            continue
+        }
+        count, err := db.PruneOlderThan(target, dryRun)
+        if err != nil {
+            return err
+        }
+        report(target, count)
+    }
+    return nil
+}
+
+// pruneJob prunes old log rows daily in server mode
+func (s *WebhookServer) pruneJob() *Job {
+    return &Job{
+        Name:     jobPrune,
+        Interval: pruneInterval,
+        AtStart:  true,
+        run: func() (string, error) {
+            var pruned []string
+            err := pruneAll(s.db, s.config, false, func(target pruneTarget, count int) {
+                if count > 0 {
+                    s.logger.Printf("Pruned %d %s older than %s", count, target.Name, formatAge(target.Retention))
+                    pruned = append(pruned, fmt.Sprintf("%d %s", count, target.Name))
+                }
+            })
+            if len(pruned) == 0 {
+                return "", err
+            }
+            return "pruned " + strings.Join(pruned, ", "), err
+        },
+    }
+}
+
+// parseAge reads a duration that may also be given in days, e.g. 7d or 36h
+func parseAge(value string) (time.Duration, error) {
+    if days, ok := strings.CutSuffix(value, "d"); ok {
+        n, err := strconv.Atoi(days)
+        if err != nil || n < 0 {
+            return 0, fmt.Errorf("invalid number of days %q", value)
+        }
+        return time.Duration(n) * 24 * time.Hour, nil
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil || d < 0 {
+        return 0, fmt.Errorf("%q is not a duration like 7d or 12h", value)
+    }
+    return d, nil
+}
+
+// formatAge prints whole days as Nd
+func formatAge(d time.Duration) string {
+    if d%(24*time.Hour) == 0 {
+        return fmt.Sprintf("%dd", d/(24*time.Hour))
+    }
+    return d.String()
+}
+
+func runPrune(logger *log.Logger) {
+    pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+    dryRun := pruneCmd.Bool("dry-run", false, "Count the rows that would be deleted")
+
+    parseSubcommand(pruneCmd, "memberships prune [--dry-run]", os.Args[2:])
+
+    config := mustLoadConfig(logger)
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    verb := "Deleted"
+    if *dryRun {
+        verb = "Would delete"
+    }
+    err := pruneAll(db, config, *dryRun, func(target pruneTarget, count int) {
+        fmt.Printf("%s %d %s older than %s\n", verb, count, target.Name, formatAge(target.Retention))
+    })
+    if err != nil {
+        logger.Fatalf("Prune failed: %v", err)
+    }
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "sort"
+    "time"
+)
+
+// MemberSource is the membership state reported by an external source (a
+// GiveLively CSV, Stripe) that a Reconciler compares the database against
+type MemberSource struct {
+    // Name identifies the source in logs, reports, and sync_runs
+    Name string
+    
+    // Active holds emails with a current recurring donation
+    Active map[string]bool
+    
+    // Failed holds emails whose payment failed without a cancellation;
+    // active members among them are suspended rather than cancelled
+    Failed map[string]bool
+    
+    // Unmapped holds emails whose rows all had a status or frequency nobody
+    // has mapped; they're left as they are until it is
+    Unmapped map[string]bool
+    
+    // Latest payment date and recurring frequency per active member, if known
+    PaymentDates map[string]time.Time
+    Frequencies  map[string]string
+    
+    // Campaigns is the campaign each active member's earliest row names,
+    // recorded for members the run adds
+    Campaigns map[string]string
+    
+    // Donations are individual payments to add to donation history
+    Donations []Donation
+    
+    // Input statistics carried into the report
+    RowsProcessed int
+    RowsSkipped   int
+}
+
+// ReconcileState is the database side of a reconciliation: every member's
+// status, the protected members, the household members whose status follows
+// another member's, and, with a grace period, when each member last paid or
+// changed
+type ReconcileState struct {
+    Statuses     map[string]string
+    Protected    map[string]bool
+    Household    map[string]bool
+    LastActivity map[string]time.Time
+}
+
+// ChangeSet is what reconciling a source against the database would change.
+// Every category is computed even when suppressed; Apply skips those.
+type ChangeSet struct {
+    Source *MemberSource
+    
+    Add        []string
+    Activate   []string
+    Deactivate []string
+    Suspend    []string
+    
+    // Members that would have been deactivated or suspended but are
+    // protected, within the grace period, or covered only by unmapped rows
+    ProtectedSkipped []string
+    GraceSkipped     []string
+    UnmappedSkipped  []string
+    
+    // ActiveCount is how many members were active before the change
+    ActiveCount int
+}
+
+// Reconciler compares member sources with the database and applies the
+// difference. Clean, scheduled syncs, and Stripe reconcile share it, so
+// protection, grace periods, suppression, and the mass-deactivation guard
+// behave the same everywhere.
+type Reconciler struct {
+    db     Store
+    opts   CleanOptions
+    logger *log.Logger
+}
+
+// NewReconciler returns a Reconciler for db with the given options
+func NewReconciler(db Store, opts CleanOptions) *Reconciler {
+    if opts.ProgressRows <= 0 {
+        opts.ProgressRows = defaultProgressRows
+    }
+    return &Reconciler{db: db, opts: opts, logger: opts.logger()}
+}
+
+// LoadState reads the member state a reconciliation needs
+func (r *Reconciler) LoadState() (*ReconcileState, error) {
+    loadStart := time.Now()
+    statuses, err := r.db.GetAllMemberStatuses()
+    if err != nil {
+        return nil, fmt.Errorf("failed to get current members: %w", err)
+    }
+    r.logger.Printf("Database currently has %d members (loaded in %v)", len(statuses), time.Since(loadStart).Round(time.Millisecond))
+    
+    // Protected members (comps, board, lifetime) are never auto-deactivated
+    protected, err := r.db.GetEmailsWithTag(ProtectedTag)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get protected members: %w", err)
+    }
+    
+    // Household members follow their primary, never the source
+    household, err := r.db.GetHouseholdMembers()
+    if err != nil {
+        return nil, fmt.Errorf("failed to get household members: %w", err)
+    }
+    
+    lastActivity := map[string]time.Time{}
+    if r.opts.GraceDays > 0 {
+        lastActivity, err = r.db.GetLastActivityTimes()
+        if err != nil {
+            return nil, fmt.Errorf("failed to get member activity: %w", err)
+        }
+    }
+    
+    return &ReconcileState{Statuses: statuses, Protected: protected, Household: household, LastActivity: lastActivity}, nil
+}
+
+// diffMembers works out the changes that bring state in line with source:
+// new active members are added, returning ones reactivated, failed payments
+// suspended, and active members missing from the source cancelled. Members
+// paid or updated within graceDays of now aren't cancelled yet, since their
+// charge may simply not have run when the source was generated. Household
+// members are left to follow their primary. Emails in each category are
+// sorted.
+func diffMembers(source *MemberSource, state *ReconcileState, graceDays int, now time.Time) *ChangeSet {
+    changes := &ChangeSet{Source: source}
+    graceCutoff := now.AddDate(0, 0, -graceDays)
+    
+    for email, dbStatus := range state.Statuses {
+        if state.Household[email] {
+            continue
+        }
+        if dbStatus == StatusActive {
+            changes.ActiveCount++
+        }
+        
+        switch {
+        case source.Active[email]:
+            if dbStatus != StatusActive {
+                changes.Activate = append(changes.Activate, email)
+            }
+        case dbStatus != StatusActive:
+            // Members already suspended, cancelled, or lapsed stay as they are
+        case state.Protected[email]:
+            changes.ProtectedSkipped = append(changes.ProtectedSkipped, email)
+        case source.Failed[email]:
+            // Payment failed: suspend rather than cancel
+            changes.Suspend = append(changes.Suspend, email)
+        case source.Unmapped[email]:
+            changes.UnmappedSkipped = append(changes.UnmappedSkipped, email)
+        case graceDays > 0 && state.LastActivity[email].After(graceCutoff):
+            changes.GraceSkipped = append(changes.GraceSkipped, email)
+        default:
+            changes.Deactivate = append(changes.Deactivate, email)
+        }
+    }
+    
+    for email := range source.Active {
+        if _, exists := state.Statuses[email]; !exists {
+            changes.Add = append(changes.Add, email)
+        }
+    }
+    
+    for _, emails := range [][]string{changes.Add, changes.Activate, changes.Deactivate, changes.Suspend,
+        changes.ProtectedSkipped, changes.GraceSkipped, changes.UnmappedSkipped} {
+        sort.Strings(emails)
+    }
+    return changes
+}
+
+// Plan loads the database state and diffs source against it
+func (r *Reconciler) Plan(source *MemberSource) (*ChangeSet, error) {
+    state, err := r.LoadState()
+    if err != nil {
+        return nil, err
+    }
+    return diffMembers(source, state, r.opts.GraceDays, time.Now()), nil
+}
+
+// TooManyDeactivations reports whether the change set deactivates more than
+// maxPercent of active members, the sign of a partial or truncated export
+func (c *ChangeSet) TooManyDeactivations(maxPercent int) bool {
+    return c.ActiveCount > 0 && len(c.Deactivate)*100 > maxPercent*c.ActiveCount
+}
+
+// Log prints a summary of the change set, and with verbose every email
+func (c *ChangeSet) Log(opts CleanOptions) {
+    logger := opts.logger()
+    logger.Printf("Changes to make:")
+    logger.Printf("  - New members to add: %d%s", len(c.Add), suppressedNote(opts.NoAdd, "--no-add"))
+    logger.Printf("  - Members to reactivate: %d%s", len(c.Activate), suppressedNote(opts.NoReactivate, "--no-reactivate"))
+    logger.Printf("  - Members to deactivate: %d%s", len(c.Deactivate), suppressedNote(opts.NoDeactivate, "--no-deactivate"))
+    logger.Printf("  - Members to suspend (failed payment): %d%s", len(c.Suspend), suppressedNote(opts.NoDeactivate, "--no-deactivate"))
+    if len(c.ProtectedSkipped) > 0 {
+        logger.Printf("  - Protected members ignored: %d", len(c.ProtectedSkipped))
+    }
+    if len(c.GraceSkipped) > 0 {
+        logger.Printf("  - Within %d-day grace period, skipped: %d", opts.GraceDays, len(c.GraceSkipped))
+    }
+    if len(c.UnmappedSkipped) > 0 {
+        logger.Printf("  - Unrecognized status or frequency, skipped: %d", len(c.UnmappedSkipped))
+    }
+    
+    if !opts.Verbose {
+        return
+    }
+    for _, category := range []struct {
+        label  string
+        emails []string
+    }{
+        {"New members", c.Add},
+        {"To activate", c.Activate},
+        {"To deactivate", c.Deactivate},
+        {"To suspend", c.Suspend},
+        {"Protected", c.ProtectedSkipped},
+        {"Within grace period", c.GraceSkipped},
+        {"Unrecognized status or frequency", c.UnmappedSkipped},
+    } {
+        if len(category.emails) > 0 {
+            logger.Printf("  %s: %v", category.label, category.emails)
+        }
+    }
+}
+
+// Report describes the change set as a clean report. Suppressed categories
+// stay in it as what would have happened.
+func (c *ChangeSet) Report(opts CleanOptions) *CleanReport {
+    return &CleanReport{
+        RunAt:            time.Now().UTC(),
+        DryRun:           opts.DryRun,
+        InputFile:        c.Source.Name,
+        RowsProcessed:    c.Source.RowsProcessed,
+        RowsSkipped:      c.Source.RowsSkipped,
+        Added:            c.Add,
+        Reactivated:      c.Activate,
+        Deactivated:      c.Deactivate,
+        Suspended:        c.Suspend,
+        ProtectedSkipped: c.ProtectedSkipped,
+        GraceSkipped:     c.GraceSkipped,
+        UnmappedSkipped:  c.UnmappedSkipped,
+        Suppressed:       suppressedCategories(opts),
+        Errors:           map[string]string{},
+    }
+}
+
+// Apply makes the change set's unsuppressed changes in one transaction, so
+// a crash can't leave the database half-synced, and records them as a sync
+// run for undo. It refuses a mass deactivation unless opts.Force is set, and
+// writes a backup first if opts.AutoBackup is. It returns the sync run id.
+func (c *ChangeSet) Apply(db Store, opts CleanOptions) (int, error) {
+    logger := opts.logger()
+    add, activate, deactivate, suspend := c.Add, c.Activate, c.Deactivate, c.Suspend
+    if opts.NoAdd {
+        add = nil
+    }
+    if opts.NoReactivate {
+        activate = nil
+    }
+    if opts.NoDeactivate {
+        deactivate, suspend = nil, nil
+    }
+    
+    if len(deactivate) > 0 && c.TooManyDeactivations(opts.MaxDeactivatePercent) && !opts.Force {
+        return 0, fmt.Errorf("refusing to deactivate %d members; check the export is complete or re-run with --force", len(deactivate))
+    }
+    
+    totalChanges := len(add) + len(activate) + len(deactivate) + len(suspend)
+    if opts.AutoBackup && totalChanges > 0 {
+        path := defaultBackupPath()
+        if _, err := db.WriteBackup(path, false); err != nil {
+            return 0, fmt.Errorf("backup failed, no changes made: %w", err)
+        }
+        fmt.Printf("Backup written to %s\n", path)
+    }
+    
+    applied := 0
+    applyProgress := newProgress(max(opts.ProgressRows/20, 1))
+    reportApply := func() {
+        applied++
+        if applyProgress.due(applied) {
+            logger.Printf("Applying: %d/%d changes (%.0f%%)", applied, totalChanges,
+                float64(applied)*100/float64(totalChanges))
+        }
+    }
+    
+    applyStart := time.Now()
+    runID, err := db.ApplySyncChanges(SyncChanges{
+        InputFile:    c.Source.Name,
+        Add:          add,
+        Activate:     activate,
+        Deactivate:   deactivate,
+        Suspend:      suspend,
+        PaymentDates: c.Source.PaymentDates,
+        Frequencies:  c.Source.Frequencies,
+        Campaigns:    c.Source.Campaigns,
+        Donations:    c.Source.Donations,
+    }, reportApply)
+    if err != nil {
+        return 0, fmt.Errorf("sync rolled back, no changes made: %w", err)
+    }
+    
+    logger.Printf("Applied %d changes in %v", totalChanges, time.Since(applyStart).Round(time.Millisecond))
+    return runID, nil
+}
+
+// Run plans the changes for source, logs them, and applies them unless this
+// is a dry run, or queues them for approval in review mode. The report is
+// returned even when applying fails.
+func (r *Reconciler) Run(source *MemberSource) (*CleanReport, error) {
+    changes, err := r.Plan(source)
+    if err != nil {
+        return nil, err
+    }
+    
+    if !r.opts.NoDeactivate && changes.TooManyDeactivations(r.opts.MaxDeactivatePercent) {
+        r.logger.Printf("WARNING: %d of %d active members (%.1f%%) would be deactivated, above the %d%% limit",
+            len(changes.Deactivate), changes.ActiveCount,
+            float64(len(changes.Deactivate))*100/float64(changes.ActiveCount), r.opts.MaxDeactivatePercent)
+    }
+    changes.Log(r.opts)
+    report := changes.Report(r.opts)
+    
+    if r.opts.DryRun {
+        r.logger.Println("DRY RUN complete - no changes made")
+        return report, nil
+    }
+    
+    if r.opts.Review {
+        queued, err := changes.Queue(r.db, r.opts)
+        if err != nil {
+            report.Errors["sync"] = err.Error()
+            return report, err
+        }
+        report.PendingChanges = queued
+        
+        r.logger.Printf("Queued %d changes for review (see: memberships pending list)", queued)
+        return report, nil
+    }
+    
+    runID, err := changes.Apply(r.db, r.opts)
+    if err != nil {
+        report.Errors["sync"] = err.Error()
+        return report, err
+    }
+    report.SyncRunID = runID
+    
+    r.logger.Printf("Recorded as sync run #%d (undo with: memberships undo %d)", runID, runID)
+    r.logger.Println("Database sync complete!")
+    return report, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+// emailSet builds the map[string]bool sets sources and states use
+func emailSet(emails ...string) map[string]bool {
+    set := map[string]bool{}
+    for _, email := range emails {
+        set[email] = true
+    }
+    return set
+}
+
+func TestDiffMembers(t *testing.T) {
+    now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name      string
+        source    MemberSource
+        state     ReconcileState
+        graceDays int
+        want      ChangeSet
+    }{
+        {
+            name:   "new members are added",
+            source: MemberSource{Active: emailSet("b@example.org", "a@example.org")},
+            state:  ReconcileState{Statuses: map[string]string{}},
+            want:   ChangeSet{Add: []string{"a@example.org", "b@example.org"}},
+        },
+        {
+            name:   "returning members are reactivated, active ones left alone",
+            source: MemberSource{Active: emailSet("lapsed@example.org", "cancelled@example.org", "suspended@example.org", "active@example.org")},
+            state: ReconcileState{Statuses: map[string]string{
+                "lapsed@example.org": StatusLapsed, "cancelled@example.org": StatusCancelled,
+                "suspended@example.org": StatusSuspended, "active@example.org": StatusActive,
+            }},
+            want: ChangeSet{
+                Activate:    []string{"cancelled@example.org", "lapsed@example.org", "suspended@example.org"},
+                ActiveCount: 1,
+            },
+        },
+        {
+            name:   "active members missing from the source are cancelled",
+            source: MemberSource{Active: emailSet()},
+            state:  ReconcileState{Statuses: map[string]string{"a@example.org": StatusActive, "gone@example.org": StatusCancelled}},
+            want:   ChangeSet{Deactivate: []string{"a@example.org"}, ActiveCount: 1},
+        },
+        {
+            name:   "failed payments suspend instead",
+            source: MemberSource{Active: emailSet(), Failed: emailSet("a@example.org", "cancelled@example.org")},
+            state:  ReconcileState{Statuses: map[string]string{"a@example.org": StatusActive, "cancelled@example.org": StatusCancelled}},
+            want:   ChangeSet{Suspend: []string{"a@example.org"}, ActiveCount: 1},
+        },
+        {
+            name:   "protection wins over a failed payment",
+            source: MemberSource{Active: emailSet(), Failed: emailSet("board@example.org")},
+            state: ReconcileState{
+                Statuses:  map[string]string{"board@example.org": StatusActive, "comp@example.org": StatusActive},
+                Protected: emailSet("board@example.org", "comp@example.org"),
+            },
+            want: ChangeSet{ProtectedSkipped: []string{"board@example.org", "comp@example.org"}, ActiveCount: 2},
+        },
+        {
+            name:   "unmapped rows hold a member where they are",
+            source: MemberSource{Active: emailSet(), Unmapped: emailSet("a@example.org", "new@example.org")},
+            state:  ReconcileState{Statuses: map[string]string{"a@example.org": StatusActive}},
+            want:   ChangeSet{UnmappedSkipped: []string{"a@example.org"}, ActiveCount: 1},
+        },
+        {
+            name:   "recent activity is within the grace period",
+            source: MemberSource{Active: emailSet()},
+            state: ReconcileState{
+                Statuses: map[string]string{"recent@example.org": StatusActive, "old@example.org": StatusActive, "unknown@example.org": StatusActive},
+                LastActivity: map[string]time.Time{
+                    "recent@example.org": now.AddDate(0, 0, -3),
+                    "old@example.org":    now.AddDate(0, 0, -30),
+                },
+            },
+            graceDays: 7,
+            want: ChangeSet{
+                Deactivate:   []string{"old@example.org", "unknown@example.org"},
+                GraceSkipped: []string{"recent@example.org"},
+                ActiveCount:  3,
+            },
+        },
+        {
+            name:   "no grace period without grace days",
+            source: MemberSource{Active: emailSet()},
+            state: ReconcileState{
+                Statuses:     map[string]string{"recent@example.org": StatusActive},
+                LastActivity: map[string]time.Time{"recent@example.org": now.AddDate(0, 0, -1)},
+            },
+            want: ChangeSet{Deactivate: []string{"recent@example.org"}, ActiveCount: 1},
+        },
+        {
+            name:   "household members follow their primary",
+            source: MemberSource{Active: emailSet("lapsed@example.org")},
+            state: ReconcileState{
+                Statuses:  map[string]string{"partner@example.org": StatusActive, "lapsed@example.org": StatusLapsed},
+                Household: emailSet("partner@example.org", "lapsed@example.org"),
+            },
+            want: ChangeSet{},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := diffMembers(&tt.source, &tt.state, tt.graceDays, now)
+            if got.Source != &tt.source {
+                t.Error("the change set doesn't point at its source")
+            }
+            got.Source = nil
+            if !reflect.DeepEqual(*got, tt.want) {
+                t.Errorf("got %+v\nwant %+v", *got, tt.want)
+            }
+        })
+    }
+}
+
+func TestTooManyDeactivations(t *testing.T) {
+    tests := []struct {
+        deactivate, active, maxPercent int
+        want                           bool
+    }{
+        {0, 0, 20, false},
+        {5, 0, 20, false},
+        {20, 100, 20, false},
+        {21, 100, 20, true},
+        {1, 3, 33, true},
+        {1, 3, 34, false},
+        {100, 100, 100, false},
+    }
+    for _, tt := range tests {
+        c := &ChangeSet{Deactivate: make([]string, tt.deactivate), ActiveCount: tt.active}
+        if got := c.TooManyDeactivations(tt.maxPercent); got != tt.want {
+            t.Errorf("%d of %d at %d%%: got %v", tt.deactivate, tt.active, tt.maxPercent, got)
+        }
+    }
+}
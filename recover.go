@@ -0,0 +1,54 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "net/http"
+    "runtime/debug"
+)
+
+// requestID returns the request's X-Request-ID, or a new random one if the
+// proxy didn't set it
+func requestID(r *http.Request) string {
+    if id := r.Header.Get("X-Request-ID"); id != "" {
+        return id
+    }
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// requestIDMiddleware gives every request an X-Request-ID, echoed in the
+// response, so error bodies and log lines can be matched up
+func requestIDMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := requestID(r)
+        r.Header.Set("X-Request-ID", id)
+        w.Header().Set("X-Request-ID", id)
+        next.ServeHTTP(w, r)
+    })
+}
+
+// recoverMiddleware turns a panicking handler into a 500 so one bad request
+// doesn't take down the server. It wraps the whole mux, so it covers every
+// route.
+func (s *WebhookServer) recoverMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        defer func() {
+            p := recover()
+            if p == nil {
+                return
+            }
+            // The server uses this to abort a response on purpose
+            if p == http.ErrAbortHandler {
+                panic(p)
+            }
+
+            s.panics.Add(1)
+            s.logger.Printf("Panic in %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r), p, debug.Stack())
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        }()
+
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// lockedBuffer collects log output written from server goroutines
+type lockedBuffer struct {
+    mu  sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.buf.String()
+}
+
+// panickingStore is a Store with a bug in member processing
+type panickingStore struct {
+    *memStore
+}
+
+func (panickingStore) ProcessMember(email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    panic("index out of range")
+}
+
+func TestRecoverFromPanickingHandler(t *testing.T) {
+    db := panickingStore{newMemStore()}
+    var logs lockedBuffer
+    s := NewWebhookServer(db, testConfig(), log.New(&logs, "", 0))
+    s.routes()
+    server := httptest.NewServer(requestIDMiddleware(s.recoverMiddleware(routeMiddleware(s.mux))))
+    t.Cleanup(func() {
+        server.Close()
+        s.accessLog.close()
+    })
+
+    req, _ := http.NewRequest("POST", server.URL+"/webhook", strings.NewReader(`{"email":"ada@example.org","status":"Succeeded"}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer "+testWebhookSecret)
+    req.Header.Set("X-Request-ID", "req-panic")
+    resp, err := server.Client().Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    expectStatus(t, resp, http.StatusInternalServerError)
+    if resp.Header.Get("X-Request-ID") != "req-panic" {
+        t.Errorf("X-Request-ID = %q", resp.Header.Get("X-Request-ID"))
+    }
+    var body map[string]apiError
+    decode(t, resp, &body)
+    if body["error"].Code != errInternal || body["error"].RequestID != "req-panic" {
+        t.Errorf("error body = %+v", body["error"])
+    }
+
+    if got := s.panics.Load(); got != 1 {
+        t.Errorf("panics = %d, want 1", got)
+    }
+    if out := logs.String(); !strings.Contains(out, "Panic in POST /webhook (request req-panic)") || !strings.Contains(out, "goroutine") {
+        t.Errorf("log doesn't have the panic and its stack:\n%s", out)
+    }
+
+    // The payload was kept for replay, and the server is still up
+    if len(db.webhookLogs) != 1 || !strings.Contains(string(db.webhookLogs[0].Payload), "ada@example.org") {
+        t.Errorf("webhook logs = %+v", db.webhookLogs)
+    }
+    var health map[string]interface{}
+    resp = do(t, server, "GET", "/health", "", "")
+    expectStatus(t, resp, http.StatusOK)
+    decode(t, resp, &health)
+    if health["panics"] != float64(1) {
+        t.Errorf("/health panics = %v", health["panics"])
+    }
+}
+
+func TestRecoverLeavesAbortHandler(t *testing.T) {
+    s := &WebhookServer{logger: log.New(io.Discard, "", 0)}
+    handler := s.recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        panic(http.ErrAbortHandler)
+    }))
+
+    defer func() {
+        if p := recover(); p != http.ErrAbortHandler {
+            t.Errorf("recovered %v, want http.ErrAbortHandler", p)
+        }
+        if s.panics.Load() != 0 {
+            t.Error("an aborted response was counted as a panic")
+        }
+    }()
+    handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// runReport dispatches memberships report <name>
+func runReport(logger *log.Logger) {
+    usage := "Usage: memberships report <retention|outreach|anniversaries> [flags]"
+    if len(os.Args) < 3 {
+        fmt.Fprintln(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    switch os.Args[2] {
+    case "retention":
+        runRetentionReport(os.Args[3:], logger)
+    case "outreach":
+        runOutreachReport(os.Args[3:], logger)
+    case "anniversaries":
+        runAnniversariesReport(os.Args[3:], logger)
+    default:
+        fmt.Fprintf(os.Stderr, "Error: unknown report %q\n%s\n", os.Args[2], usage)
+        os.Exit(2)
+    }
+}
+
+// CleanReport is the machine-readable record of a clean run. Dry runs produce
+// the same structure so the planned changes can be reviewed beforehand.
+type CleanReport struct {
+    RunAt            time.Time         `json:"run_at"`
+    DryRun           bool              `json:"dry_run"`
+    InputFile        string            `json:"input_file"`
+    SyncRunID        int               `json:"sync_run_id,omitempty"`
+    PendingChanges   int               `json:"pending_changes,omitempty"`
+    RowsProcessed    int               `json:"rows_processed"`
+    RowsSkipped      int               `json:"rows_skipped"`
+    Counts           map[string]int    `json:"counts"`
+    Added            []string          `json:"added"`
+    Reactivated      []string          `json:"reactivated"`
+    Deactivated      []string          `json:"deactivated"`
+    Suspended        []string          `json:"suspended"`
+    ProtectedSkipped []string          `json:"protected_skipped"`
+    GraceSkipped     []string          `json:"grace_skipped"`
+    UnmappedSkipped  []string          `json:"unmapped_skipped"`
+    Suppressed       []string          `json:"suppressed_categories"`
+    Errors           map[string]string `json:"errors"`
+}
+
+// categories returns each change category with its sorted email list
+func (r *CleanReport) categories() []struct {
+    name   string
+    emails []string
+} {
+    categories := []struct {
+        name   string
+        emails []string
+    }{
+        {"added", r.Added},
+        {"reactivated", r.Reactivated},
+        {"deactivated", r.Deactivated},
+        {"suspended", r.Suspended},
+        {"protected_skipped", r.ProtectedSkipped},
+        {"grace_skipped", r.GraceSkipped},
+        {"unmapped_skipped", r.UnmappedSkipped},
+    }
+
+    for _, c := range categories {
+        sort.Strings(c.emails)
+    }
+
+    return categories
+}
+
+// WriteFile writes the report as JSON or CSV
+func (r *CleanReport) WriteFile(path, format string) error {
+    r.Counts = make(map[string]int)
+    for _, c := range r.categories() {
+        r.Counts[c.name] = len(c.emails)
+    }
+    r.Counts["errors"] = len(r.Errors)
+
+    file, err := os.Create(path)
+    if err != nil {
+        return fmt.Errorf("failed to create report: %w", err)
+    }
+    defer file.Close()
+
+    switch format {
+    case "json":
+        encoder := json.NewEncoder(file)
+        encoder.SetIndent("", "  ")
+        if err := encoder.Encode(r); err != nil {
+            return fmt.Errorf("failed to write report: %w", err)
+        }
+    case "csv":
+        if err := r.writeCSV(file); err != nil {
+            return fmt.Errorf("failed to write report: %w", err)
+        }
+    default:
+        return fmt.Errorf("unsupported report format %q", format)
+    }
+
+    return file.Close()
+}
+
+// writeCSV writes one row per email with the run metadata repeated, so the
+// file stays usable after filtering in a spreadsheet
+func (r *CleanReport) writeCSV(file *os.File) error {
+    writer := csv.NewWriter(file)
+
+    writer.Write([]string{"run_at", "dry_run", "input_file", "category", "suppressed", "email", "error"})
+
+    suppressed := make(map[string]bool)
+    for _, name := range r.Suppressed {
+        suppressed[name] = true
+    }
+
+    runAt := r.RunAt.Format(time.RFC3339)
+    dryRun := strconv.FormatBool(r.DryRun)
+    for _, c := range r.categories() {
+        for _, email := range c.emails {
+            writer.Write([]string{runAt, dryRun, r.InputFile, c.name, strconv.FormatBool(suppressed[c.name]), email, r.Errors[email]})
+        }
+    }
+
+    writer.Flush()
+    return writer.Error()
+}
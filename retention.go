@@ -0,0 +1,219 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// retentionCheckpoints are the member ages, in months, retention is measured at
+var retentionCheckpoints = []int{1, 3, 6, 12}
+
+// Cohorts shown by default and at most
+const (
+    defaultRetentionMonths = 12
+    maxRetentionMonths     = 60
+)
+
+// RetentionPoint is how many of a cohort were active at a given age.
+// Eligible counts the members old enough to have reached it; the point is
+// left empty until any have.
+type RetentionPoint struct {
+    Months   int      `json:"months"`
+    Eligible int      `json:"eligible"`
+    Active   *int     `json:"active"`
+    Rate     *float64 `json:"rate"`
+}
+
+// RetentionCohort is the members who joined in one calendar month
+type RetentionCohort struct {
+    Cohort    string           `json:"cohort"`
+    Members   int              `json:"members"`
+    Retention []RetentionPoint `json:"retention"`
+}
+
+// GetRetention groups members who joined in the last months calendar months
+// into cohorts by first_seen and counts, for each checkpoint, those whose
+// status at that age was active. Status at a point in time is the latest
+// status_history entry by then, so members who cancelled and rejoined count
+// wherever they were active. Members with no history at all predate it, and
+// their current status stands in.
+func (db *Database) GetRetention(months int) ([]RetentionCohort, error) {
+    rows, err := db.Query(`
+        WITH cohort AS (
+            SELECT m.id, m.first_seen, to_char(date_trunc('month', m.first_seen), 'YYYY-MM') AS cohort,
+                   CASE WHEN NOT EXISTS (SELECT 1 FROM status_history h WHERE h.member_id = m.id)
+                        THEN m.status END AS fallback
+            FROM members m
+            WHERE m.first_seen >= date_trunc('month', CURRENT_DATE) - ($1::int - 1) * INTERVAL '1 month'
+        )
+        SELECT c.cohort, p.months, COUNT(*),
+               COUNT(*) FILTER (WHERE c.first_seen + p.months * INTERVAL '1 month' <= CURRENT_TIMESTAMP),
+               COUNT(*) FILTER (WHERE c.first_seen + p.months * INTERVAL '1 month' <= CURRENT_TIMESTAMP
+                                AND COALESCE(s.status, c.fallback) = 'active')
+        FROM cohort c
+        CROSS JOIN unnest($2::int[]) AS p(months)
+        LEFT JOIN LATERAL (
+            SELECT h.status FROM status_history h
+            WHERE h.member_id = c.id AND h.changed_at < c.first_seen + (p.months * INTERVAL '1 month') + INTERVAL '1 day'
+            ORDER BY h.changed_at DESC, h.id DESC
+            LIMIT 1
+        ) s ON true
+        GROUP BY c.cohort, p.months
+        ORDER BY c.cohort, p.months
+    `, months, pq.Array(retentionCheckpoints))
+    if err != nil {
+        return nil, fmt.Errorf("failed to compute retention: %w", err)
+    }
+    defer rows.Close()
+
+    cohorts := []RetentionCohort{}
+    for rows.Next() {
+        var name string
+        var point RetentionPoint
+        var size, active int
+        if err := rows.Scan(&name, &point.Months, &size, &point.Eligible, &active); err != nil {
+            return nil, err
+        }
+        if point.Eligible > 0 {
+            rate := float64(active) / float64(point.Eligible)
+            point.Active, point.Rate = &active, &rate
+        }
+
+        if len(cohorts) == 0 || cohorts[len(cohorts)-1].Cohort != name {
+            cohorts = append(cohorts, RetentionCohort{Cohort: name, Members: size})
+        }
+        last := &cohorts[len(cohorts)-1]
+        last.Retention = append(last.Retention, point)
+    }
+
+    return cohorts, rows.Err()
+}
+
+// statsRetentionHandler serves GET /stats/retention?months=N
+func (s *WebhookServer) statsRetentionHandler(w http.ResponseWriter, r *http.Request) {
+    months := defaultRetentionMonths
+    if value := r.URL.Query().Get("months"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 || n > maxRetentionMonths {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, fmt.Sprintf("months must be between 1 and %d", maxRetentionMonths))
+            return
+        }
+        months = n
+    }
+
+    // Cohorts age with the calendar as well as with status changes
+    if s.notModified(w, r, time.Now().Format("2006-01-02"), strconv.Itoa(months)) {
+        return
+    }
+
+    cohorts, err := s.db.GetRetention(months)
+    if err != nil {
+        s.logger.Printf("Error getting retention: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(cohorts)
+}
+
+func runRetentionReport(args []string, logger *log.Logger) {
+    retentionCmd := flag.NewFlagSet("report retention", flag.ExitOnError)
+    months := retentionCmd.Int("months", defaultRetentionMonths, "Number of monthly cohorts, counting back from this month")
+    format := retentionCmd.String("format", "table", "Output format: table, csv, or json")
+
+    parseSubcommand(retentionCmd, "memberships report retention [--months 12] [--format table|csv|json]", args)
+
+    if *months < 1 || *months > maxRetentionMonths {
+        fmt.Fprintf(os.Stderr, "Error: --months must be between 1 and %d\n", maxRetentionMonths)
+        os.Exit(2)
+    }
+    if *format != "table" && *format != "csv" && *format != "json" {
+        fmt.Fprintf(os.Stderr, "Error: unsupported format %q (use table, csv, or json)\n", *format)
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    cohorts, err := db.GetRetention(*months)
+    if err != nil {
+        logger.Fatalf("Retention report failed: %v", err)
+    }
+
+    switch *format {
+    case "json":
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(cohorts)
+    case "csv":
+        writeRetentionCSV(cohorts, logger)
+    default:
+        printRetentionTable(cohorts)
+    }
+}
+
+// writeRetentionCSV writes one row per cohort with active counts and rates
+// per checkpoint, blank where the cohort isn't old enough yet
+func writeRetentionCSV(cohorts []RetentionCohort, logger *log.Logger) {
+    writer := csv.NewWriter(os.Stdout)
+    header := []string{"cohort", "members"}
+    for _, m := range retentionCheckpoints {
+        header = append(header, fmt.Sprintf("active_%dm", m), fmt.Sprintf("rate_%dm", m))
+    }
+    writer.Write(header)
+
+    for _, c := range cohorts {
+        row := []string{c.Cohort, strconv.Itoa(c.Members)}
+        for _, p := range c.Retention {
+            if p.Active == nil {
+                row = append(row, "", "")
+                continue
+            }
+            row = append(row, strconv.Itoa(*p.Active), strconv.FormatFloat(*p.Rate, 'f', 3, 64))
+        }
+        writer.Write(row)
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        logger.Fatalf("Failed to write CSV: %v", err)
+    }
+}
+
+// printRetentionTable prints the cohort matrix as percentages
+func printRetentionTable(cohorts []RetentionCohort) {
+    if len(cohorts) == 0 {
+        fmt.Println("No members joined in that period")
+        return
+    }
+
+    header := fmt.Sprintf("%-8s  %7s", "Cohort", "Members")
+    for _, m := range retentionCheckpoints {
+        header += fmt.Sprintf("  %6s", fmt.Sprintf("%dm", m))
+    }
+    fmt.Println(header)
+    fmt.Println(strings.Repeat("-", len(header)))
+
+    for _, c := range cohorts {
+        line := fmt.Sprintf("%-8s  %7d", c.Cohort, c.Members)
+        for _, p := range c.Retention {
+            if p.Rate == nil {
+                line += fmt.Sprintf("  %6s", "-")
+                continue
+            }
+            line += fmt.Sprintf("  %5.1f%%", *p.Rate*100)
+        }
+        fmt.Println(line)
+    }
+    fmt.Println("\nShare of each cohort active 1, 3, 6, and 12 months after joining; - where it's too soon to tell")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "time"
+)
+
+// Webhook retry tuning
+const (
+    retryPollInterval = 30 * time.Second
+    retryBaseDelay    = time.Minute
+    retryMaxDelay     = 4 * time.Hour
+    retryMaxAttempts  = 8
+    retryBatchSize    = 50
+)
+
+// Webhook log states used by the retry queue
+const (
+    webhookStatePending = "pending"
+    webhookStateDone    = "done"
+    webhookStateFailed  = "failed"
+    webhookStateSkipped = "skipped"
+)
+
+// retryDelay is the exponential backoff before the given attempt
+func retryDelay(attempt int) time.Duration {
+    delay := retryBaseDelay
+    for i := 0; i < attempt && delay < retryMaxDelay; i++ {
+        delay *= 2
+    }
+    return min(delay, retryMaxDelay)
+}
+
+// QueueWebhookRetry marks a logged webhook for background retry
+func (db *Database) QueueWebhookRetry(logID int, cause error) error {
+    _, err := db.Exec(`
+        UPDATE webhook_logs SET
+            state = $2,
+            attempts = 0,
+            next_attempt_at = $3,
+            last_error = $4
+        WHERE id = $1
+    `, logID, webhookStatePending, time.Now().Add(retryDelay(0)), cause.Error())
+    if err != nil {
+        return fmt.Errorf("failed to queue retry: %w", err)
+    }
+    return nil
+}
+
+// SkipWebhook marks a logged webhook as deliberately not applied, e.g.
+// because it was stale
+func (db *Database) SkipWebhook(logID int, reason string) error {
+    _, err := db.Exec(`
+        UPDATE webhook_logs SET state = $2, skip_reason = $3, next_attempt_at = NULL WHERE id = $1
+    `, logID, webhookStateSkipped, reason)
+    if err != nil {
+        return fmt.Errorf("failed to mark webhook skipped: %w", err)
+    }
+    return nil
+}
+
+// DueWebhookRetries returns pending webhooks whose next attempt is due
+func (db *Database) DueWebhookRetries(limit int) ([]WebhookLogEntry, error) {
+    return db.queryWebhookLogs(`
+        WHERE state = $1 AND next_attempt_at <= CURRENT_TIMESTAMP
+        ORDER BY id
+        LIMIT $2
+    `, webhookStatePending, limit)
+}
+
+// GetWebhookLogsByState returns webhooks in a retry state, newest first,
+// optionally only those from one source
+func (db *Database) GetWebhookLogsByState(state, source string, limit int) ([]WebhookLogEntry, error) {
+    return db.queryWebhookLogs(`
+        WHERE state = $1 AND ($2 = '' OR source = $2)
+        ORDER BY id DESC
+        LIMIT $3
+    `, state, source, limit)
+}
+
+// queryWebhookLogs selects webhook log rows with their retry columns
+func (db *Database) queryWebhookLogs(where string, args ...interface{}) ([]WebhookLogEntry, error) {
+    rows, err := db.Query(`
+        SELECT id, received_at, COALESCE(email, ''), COALESCE(status, ''), COALESCE(source, ''), payload,
+               COALESCE(state, ''), attempts, next_attempt_at, COALESCE(last_error, ''), COALESCE(skip_reason, '')
+        FROM webhook_logs
+    `+where, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get webhook logs: %w", err)
+    }
+    defer rows.Close()
+
+    var logs []WebhookLogEntry
+    for rows.Next() {
+        var entry WebhookLogEntry
+        var payload []byte
+        var nextAttempt sql.NullTime
+        err := rows.Scan(&entry.ID, &entry.ReceivedAt, &entry.Email, &entry.Status, &entry.Source, &payload,
+            &entry.State, &entry.Attempts, &nextAttempt, &entry.LastError, &entry.SkipReason)
+        if err != nil {
+            return nil, err
+        }
+        entry.Payload = payload
+        if nextAttempt.Valid {
+            entry.NextAttemptAt = &nextAttempt.Time
+        }
+        logs = append(logs, entry)
+    }
+
+    return logs, rows.Err()
+}
+
+// WebhookAttempt is the outcome of one retry of a logged webhook, as stored
+// on its webhook_logs row
+type WebhookAttempt struct {
+    State       string
+    Attempts    int
+    NextAttempt *time.Time
+    LastError   string
+    SkipReason  string
+}
+
+// RecordWebhookAttempt stores the outcome of a retry on the webhook's log row
+func (db *Database) RecordWebhookAttempt(logID int, attempt WebhookAttempt) error {
+    _, err := db.Exec(`
+        UPDATE webhook_logs SET
+            state = $2,
+            attempts = $3,
+            next_attempt_at = $4,
+            last_error = NULLIF($5, ''),
+            skip_reason = NULLIF($6, '')
+        WHERE id = $1
+    `, logID, attempt.State, attempt.Attempts, attempt.NextAttempt, attempt.LastError, attempt.SkipReason)
+    if err != nil {
+        return fmt.Errorf("failed to update webhook %d: %w", logID, err)
+    }
+    return nil
+}
+
+// webhookAttemptFor works out what one retry attempt ending in cause leaves
+// the webhook as. Permanent errors and exhausted attempts fail it, and stale
+// events are skipped.
+func webhookAttemptFor(entry WebhookLogEntry, cause error, now time.Time) WebhookAttempt {
+    attempt := WebhookAttempt{State: webhookStateDone, Attempts: entry.Attempts + 1}
+
+    if errors.Is(cause, ErrStaleEvent) {
+        attempt.State = webhookStateSkipped
+        attempt.SkipReason = skipReasonStale
+    } else if cause != nil {
+        attempt.LastError = cause.Error()
+        if isRetryableError(cause) && attempt.Attempts < retryMaxAttempts {
+            next := now.Add(retryDelay(attempt.Attempts))
+            attempt.State = webhookStatePending
+            attempt.NextAttempt = &next
+        } else {
+            attempt.State = webhookStateFailed
+        }
+    }
+    return attempt
+}
+
+// finishWebhookRetry records the outcome of one retry attempt. Failed
+// webhooks go to the failed_webhooks review queue, and leave it once
+// processed.
+func (s *WebhookServer) finishWebhookRetry(entry WebhookLogEntry, cause error) (string, error) {
+    attempt := webhookAttemptFor(entry, cause, time.Now())
+    if err := s.db.RecordWebhookAttempt(entry.ID, attempt); err != nil {
+        return "", err
+    }
+
+    var err error
+    switch attempt.State {
+    case webhookStateFailed:
+        _, err = s.db.RecordFailedWebhook(FailedWebhook{
+            ReceivedAt:   entry.ReceivedAt,
+            WebhookLogID: entry.ID,
+            Source:       entry.Source,
+            Body:         string(entry.Payload),
+            ErrorClass:   classifyWebhookError(cause),
+            Error:        attempt.LastError,
+        })
+    case webhookStateDone:
+        err = s.db.ResolveFailuresForLog(entry.ID, "retry")
+    }
+    if err != nil {
+        return "", err
+    }
+
+    return attempt.State, nil
+}
+
+// retryWebhook reprocesses one logged webhook through the normal path
+func (s *WebhookServer) retryWebhook(entry WebhookLogEntry) (string, error) {
+    var webhook MemberWebhook
+    err := json.Unmarshal(entry.Payload, &webhook)
+    // Redacted payloads hold the member's key, which needs no validating
+    if err == nil && !isEmailKey(webhook.Email) {
+        err = validateEmail(webhook.Email)
+    }
+    if err == nil {
+        change := webhookChange(entry.Source, entry.ID)
+        change.Retried = true
+        _, err = s.applyWebhook(webhook, s.mapPaymentStatus(webhook.Status), entry.ReceivedAt, change)
+    }
+
+    state, ferr := s.finishWebhookRetry(entry, err)
+    if ferr != nil {
+        return "", ferr
+    }
+
+    switch state {
+    case webhookStateDone:
+        s.logger.Printf("Retried webhook %d for %s: processed", entry.ID, entry.Email)
+    case webhookStatePending:
+        s.logger.Printf("Retried webhook %d for %s: %v (attempt %d of %d)", entry.ID, entry.Email, err, entry.Attempts+1, retryMaxAttempts)
+    case webhookStateFailed:
+        s.logger.Printf("Webhook %d for %s FAILED permanently: %v", entry.ID, entry.Email, err)
+    case webhookStateSkipped:
+        s.logger.Printf("Retried webhook %d: skipping %v", entry.ID, err)
+    }
+
+    return state, nil
+}
+
+// retryDueWebhooks processes every pending webhook whose retry is due, and
+// returns how many it retried
+func (s *WebhookServer) retryDueWebhooks() (int, error) {
+    retried := 0
+    for {
+        // Leave the members table alone during maintenance
+        if s.maintenance.Load() {
+            return retried, nil
+        }
+        
+        due, err := s.db.DueWebhookRetries(retryBatchSize)
+        if err != nil {
+            return retried, err
+        }
+
+        for _, entry := range due {
+            if _, err := s.retryWebhook(entry); err != nil {
+                return retried, err
+            }
+            retried++
+        }
+
+        if len(due) < retryBatchSize {
+            return retried, nil
+        }
+    }
+}
+
+// retryJob retries queued webhooks in the background. Pending rows live in
+// webhook_logs, so anything queued before a restart is picked up.
+func (s *WebhookServer) retryJob() *Job {
+    return &Job{
+        Name:     jobWebhookRetry,
+        Interval: retryPollInterval,
+        AtStart:  true,
+        run: func() (string, error) {
+            retried, err := s.retryDueWebhooks()
+            if retried == 0 {
+                return "", err
+            }
+            return fmt.Sprintf("%d webhooks retried", retried), err
+        },
+    }
+}
+
+// listWebhooksHandler returns webhooks in a retry state (failed by default)
+func (s *WebhookServer) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+    state := r.URL.Query().Get("state")
+    if state == "" {
+        state = webhookStateFailed
+    }
+    switch state {
+    case webhookStatePending, webhookStateFailed, webhookStateDone, webhookStateDeferred, webhookStateSkipped:
+    default:
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "state must be pending, failed, done, deferred, or skipped")
+        return
+    }
+
+    limit := 100
+    if value := r.URL.Query().Get("limit"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid limit")
+            return
+        }
+        limit = n
+    }
+
+    logs, err := s.db.GetWebhookLogsByState(state, r.URL.Query().Get("source"), limit)
+    if err != nil {
+        s.logger.Printf("Error getting webhooks: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    if logs == nil {
+        logs = []WebhookLogEntry{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(logs)
+}
+
+func runRetryFailed(logger *log.Logger) {
+    retryCmd := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+    dryRun := retryCmd.Bool("dry-run", false, "List failed webhooks without retrying them")
+    limit := retryCmd.Int("limit", 500, "Maximum number of webhooks to retry")
+
+    parseSubcommand(retryCmd, "memberships retry-failed [--dry-run] [--limit N]", os.Args[2:])
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    failed, err := db.GetWebhookLogsByState(webhookStateFailed, "", *limit)
+    if err != nil {
+        logger.Fatalf("Failed to get failed webhooks: %v", err)
+    }
+
+    if len(failed) == 0 {
+        fmt.Println("No failed webhooks")
+        return
+    }
+
+    for _, entry := range failed {
+        fmt.Printf("  #%d %s %s (%d attempts): %s\n", entry.ID, displayTime(entry.ReceivedAt),
+            entry.Email, entry.Attempts, entry.LastError)
+    }
+
+    if *dryRun {
+        fmt.Printf("Would retry %d webhooks\n", len(failed))
+        return
+    }
+
+    server := NewWebhookServer(db, mustLoadConfig(logger), logger)
+
+    done, skipped, stillFailed := 0, 0, 0
+    for _, entry := range failed {
+        // Give each webhook a fresh attempt budget
+        entry.Attempts = 0
+        state, err := server.retryWebhook(entry)
+        if err != nil {
+            logger.Fatalf("Retry failed: %v", err)
+        }
+        switch state {
+        case webhookStateDone:
+            done++
+        case webhookStateSkipped:
+            skipped++
+        case webhookStateFailed:
+            stillFailed++
+        }
+    }
+
+    fmt.Printf("Processed: %d, Skipped as stale: %d, Queued for retry: %d, Still failed: %d\n",
+        done, skipped, len(failed)-done-skipped-stillFailed, stillFailed)
+}
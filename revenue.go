@@ -0,0 +1,167 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "time"
+)
+
+// RevenueStats summarizes donations in one currency. Refunded donations are
+// left out. Amounts are decimal strings, like donation amounts.
+type RevenueStats struct {
+    Currency string `json:"currency"`
+
+    // EstimatedMRR is what active recurring members give per month, from
+    // each one's latest donation spread over its frequency: a quarterly
+    // gift counts a third, an annual one a twelfth
+    EstimatedMRR     string `json:"estimated_mrr"`
+    RecurringMembers int    `json:"recurring_members"`
+
+    Revenue30Days  string `json:"revenue_30_days"`
+    Revenue365Days string `json:"revenue_365_days"`
+    Donations      int    `json:"donations"`
+    AverageGift    string `json:"average_gift"`
+}
+
+// monthlyCents is a gift's share of MRR: its amount spread over the months
+// its frequency covers, rounded to the nearest cent with halves away from
+// zero. ok is false for one-time and unknown frequencies, which don't recur.
+func monthlyCents(amountCents int64, frequency string) (cents int64, ok bool) {
+    months, ok := frequencyMonths(frequency)
+    if !ok {
+        return 0, false
+    }
+    return int64(math.Round(float64(amountCents) / float64(months))), true
+}
+
+// currencyMRR is the estimated MRR in one currency and how many members it
+// comes from
+type currencyMRR struct {
+    cents   int64
+    members int
+}
+
+// GetRevenueStats returns revenue figures per currency, largest MRR first
+func (db *Database) GetRevenueStats(ctx context.Context) ([]RevenueStats, error) {
+    mrr, err := db.estimateMRR(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := db.QueryContext(ctx, `
+        SELECT currency,
+               (COALESCE(SUM(amount) FILTER (WHERE occurred_at > $1), 0) * 100)::bigint AS last_30,
+               (COALESCE(SUM(amount) FILTER (WHERE occurred_at > $2), 0) * 100)::bigint AS last_365,
+               COUNT(*) AS donations,
+               ROUND(AVG(amount) * 100)::bigint AS average
+        FROM donations
+        WHERE refunded_at IS NULL
+        GROUP BY currency
+    `, time.Now().AddDate(0, 0, -30), time.Now().AddDate(0, 0, -365))
+    if err != nil {
+        return nil, fmt.Errorf("failed to get revenue stats: %w", err)
+    }
+    defer rows.Close()
+
+    revenue := []RevenueStats{}
+    last365 := map[string]int64{}
+    for rows.Next() {
+        var r RevenueStats
+        var last30, year, average int64
+        if err := rows.Scan(&r.Currency, &last30, &year, &r.Donations, &average); err != nil {
+            return nil, err
+        }
+        last365[r.Currency] = year
+        r.EstimatedMRR = formatCents(mrr[r.Currency].cents)
+        r.RecurringMembers = mrr[r.Currency].members
+        r.Revenue30Days = formatCents(last30)
+        r.Revenue365Days = formatCents(year)
+        r.AverageGift = formatCents(average)
+        revenue = append(revenue, r)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    sort.Slice(revenue, func(i, j int) bool {
+        a, b := revenue[i].Currency, revenue[j].Currency
+        if mrr[a].cents != mrr[b].cents {
+            return mrr[a].cents > mrr[b].cents
+        }
+        return last365[a] > last365[b]
+    })
+    return revenue, nil
+}
+
+// estimateMRR adds up monthlyCents of each active member's latest
+// donation, taking the member's frequency when the donation has none
+func (db *Database) estimateMRR(ctx context.Context) (map[string]currencyMRR, error) {
+    rows, err := db.QueryContext(ctx, `
+        SELECT DISTINCT ON (d.member_id)
+            d.currency, (d.amount * 100)::bigint, COALESCE(NULLIF(d.frequency, ''), m.frequency, '')
+        FROM donations d
+        JOIN members m ON m.id = d.member_id
+        WHERE m.status = 'active' AND d.refunded_at IS NULL
+        ORDER BY d.member_id, d.occurred_at DESC, d.id DESC
+    `)
+    if err != nil {
+        return nil, fmt.Errorf("failed to estimate MRR: %w", err)
+    }
+    defer rows.Close()
+
+    mrr := map[string]currencyMRR{}
+    for rows.Next() {
+        var currency, frequency string
+        var amount int64
+        if err := rows.Scan(&currency, &amount, &frequency); err != nil {
+            return nil, err
+        }
+        if cents, ok := monthlyCents(amount, frequency); ok {
+            m := mrr[currency]
+            m.cents += cents
+            m.members++
+            mrr[currency] = m
+        }
+    }
+    return mrr, rows.Err()
+}
+
+// MarkDonationRefunded flags a donation by external id as refunded. It
+// reports whether a donation was found.
+func (db *Database) MarkDonationRefunded(externalID string, refundedAt time.Time) (bool, error) {
+    result, err := db.Exec(`
+        UPDATE donations SET refunded_at = $2
+        WHERE external_id = $1 AND refunded_at IS NULL
+    `, externalID, refundedAt)
+    if err != nil {
+        return false, fmt.Errorf("failed to mark donation refunded: %w", err)
+    }
+    n, _ := result.RowsAffected()
+    return n > 0, nil
+}
+
+// isRefund reports whether a payment status describes a refund
+func isRefund(paymentStatus string) bool {
+    return strings.Contains(strings.ToLower(paymentStatus), "refund")
+}
+
+// printRevenueStats prints the revenue section of memberships stats
+func printRevenueStats(revenue []RevenueStats) {
+    if len(revenue) == 0 {
+        return
+    }
+
+    fmt.Println("\n=== Revenue ===")
+    for _, r := range revenue {
+        if len(revenue) > 1 {
+            fmt.Printf("%s:\n", r.Currency)
+        }
+        fmt.Printf("Estimated MRR:      %s %s (%d recurring members)\n", r.EstimatedMRR, r.Currency, r.RecurringMembers)
+        fmt.Printf("Last 30 days:       %s %s\n", r.Revenue30Days, r.Currency)
+        fmt.Printf("Last 365 days:      %s %s\n", r.Revenue365Days, r.Currency)
+        fmt.Printf("Average gift:       %s %s over %d donations\n", r.AverageGift, r.Currency, r.Donations)
+    }
+}
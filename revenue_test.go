@@ -0,0 +1,107 @@
+package main
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestMonthlyCents(t *testing.T) {
+    tests := []struct {
+        amount    int64
+        frequency string
+        want      int64
+        recurring bool
+    }{
+        // Every spelling of a frequency divides the same way
+        {2500, "monthly", 2500, true},
+        {2500, "Monthly", 2500, true},
+        {2500, "Every month", 2500, true},
+        {2500, "Mensual", 2500, true},
+        {3000, "quarterly", 1000, true},
+        {3000, "Trimestral", 1000, true},
+        {12000, "annual", 1000, true},
+        {12000, "Annually", 1000, true},
+        {12000, "Yearly", 1000, true},
+        {12000, "Année", 1000, true},
+
+        // Rounded to the nearest cent
+        {1000, "quarterly", 333, true},
+        {2000, "quarterly", 667, true},
+        {10000, "annual", 833, true},
+        {5000, "annual", 417, true},
+
+        // Halves round away from zero, as Postgres's ROUND does
+        {6, "annual", 1, true},
+        {18, "annual", 2, true},
+        {30, "annual", 3, true},
+        {-30, "annual", -3, true},
+
+        // One-time and unknown frequencies aren't recurring revenue
+        {5000, "", 0, false},
+        {5000, "One-time", 0, false},
+        {5000, "Every 2 weeks", 0, false},
+        {5000, FrequencyOther, 0, false},
+    }
+    for _, tt := range tests {
+        got, recurring := monthlyCents(tt.amount, tt.frequency)
+        if got != tt.want || recurring != tt.recurring {
+            t.Errorf("monthlyCents(%d, %q) = %d, %v; want %d, %v", tt.amount, tt.frequency, got, recurring, tt.want, tt.recurring)
+        }
+    }
+}
+
+func TestRevenueStatsMRR(t *testing.T) {
+    db := newMemStore()
+    seedMembers(t, db, map[string]string{
+        "monthly@example.org":   StatusActive,
+        "quarterly@example.org": StatusActive,
+        "annual@example.org":    StatusActive,
+        "fallback@example.org":  StatusActive,
+        "once@example.org":      StatusActive,
+        "refunded@example.org":  StatusActive,
+        "euro@example.org":      StatusActive,
+        "gone@example.org":      StatusCancelled,
+    })
+    db.SetMemberFrequency("fallback@example.org", "Annually")
+
+    now := time.Now()
+    donate := func(email string, cents int64, currency, frequency, id string, ago int) {
+        t.Helper()
+        err := db.RecordDonation(Donation{Email: email, AmountCents: cents, Currency: currency, Frequency: frequency,
+            OccurredAt: now.AddDate(0, 0, -ago), Source: "test", ExternalID: id})
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    // Only the latest gift counts: 10.00 a month, not the older 50.00
+    donate("monthly@example.org", 5000, "USD", "Monthly", "m1", 40)
+    donate("monthly@example.org", 1000, "USD", "Monthly", "m2", 10)
+    donate("quarterly@example.org", 1000, "USD", "Quarterly", "q1", 20) // 3.33
+    donate("annual@example.org", 5000, "USD", "Annual", "a1", 100)      // 4.17
+    donate("fallback@example.org", 12000, "USD", "", "f1", 200)         // 10.00 from the member's frequency
+    donate("once@example.org", 2500, "USD", "One-time", "o1", 5)
+    donate("refunded@example.org", 9900, "USD", "Monthly", "r1", 3)
+    db.MarkDonationRefunded("r1", now) // left out of MRR and totals alike
+    donate("gone@example.org", 9900, "USD", "Monthly", "g1", 400)
+    donate("euro@example.org", 6000, "EUR", "Quarterly", "e1", 15) // 20.00
+
+    revenue, err := db.GetRevenueStats(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(revenue) != 2 || revenue[0].Currency != "USD" || revenue[1].Currency != "EUR" {
+        t.Fatalf("revenue = %+v", revenue)
+    }
+
+    usd := revenue[0]
+    if usd.EstimatedMRR != "27.50" || usd.RecurringMembers != 4 {
+        t.Errorf("USD MRR = %s from %d members, want 27.50 from 4", usd.EstimatedMRR, usd.RecurringMembers)
+    }
+    if usd.Donations != 7 || usd.Revenue30Days != "45.00" || usd.Revenue365Days != "265.00" {
+        t.Errorf("USD totals = %+v", usd)
+    }
+    if eur := revenue[1]; eur.EstimatedMRR != "20.00" || eur.RecurringMembers != 1 {
+        t.Errorf("EUR MRR = %s from %d members", eur.EstimatedMRR, eur.RecurringMembers)
+    }
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+)
+
+// routeMethods are the methods probed to build an Allow header
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// routeMiddleware answers requests the mux has no route for: 405 with an
+// Allow header when the path exists under other methods, 204 for OPTIONS
+// on such a path, and 404 otherwise, all with JSON bodies instead of the
+// mux's plain text
+func routeMiddleware(mux *http.ServeMux) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if _, pattern := mux.Handler(r); pattern != "" {
+            mux.ServeHTTP(w, r)
+            return
+        }
+
+        var allow []string
+        for _, method := range routeMethods {
+            probe := r.Clone(r.Context())
+            probe.Method = method
+            if _, pattern := mux.Handler(probe); pattern != "" {
+                allow = append(allow, method)
+            }
+        }
+
+        switch {
+        case len(allow) == 0:
+            writeError(w, r, http.StatusNotFound, errNotFound, "Not found")
+        case r.Method == http.MethodOptions:
+            w.Header().Set("Allow", strings.Join(append(allow, http.MethodOptions), ", "))
+            w.WriteHeader(http.StatusNoContent)
+        default:
+            w.Header().Set("Allow", strings.Join(allow, ", "))
+            writeError(w, r, http.StatusMethodNotAllowed, errMethodNotAllowed, "Method not allowed")
+        }
+    })
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// withheldNotesReason explains leaving notes out of a subject access export
+const withheldNotesReason = "internal staff notes, withheld as exempt; include them with --include-notes or ?include_notes=true"
+
+// WithheldField names something held about the member that an export leaves out
+type WithheldField struct {
+    Field  string `json:"field"`
+    Reason string `json:"reason"`
+}
+
+// SubjectAccessExport is everything held about one member, for answering a
+// GDPR/CCPA access request
+type SubjectAccessExport struct {
+    GeneratedAt   time.Time              `json:"generated_at"`
+    Member        map[string]interface{} `json:"member"`
+    StatusHistory []StatusChange         `json:"status_history"`
+    Donations     []apiDonation          `json:"donations"`
+    WebhookLogs   []WebhookLogEntry      `json:"webhook_logs"`
+    Events        []FeedEvent            `json:"events"`
+    Withheld      []WithheldField        `json:"withheld"`
+}
+
+// SubjectAccess gathers a member's record, full status history, donations,
+// webhook logs, and events. ref is an email or public UUID. Notes are
+// withheld unless includeNotes is set.
+func (db *Database) SubjectAccess(ref string, includeNotes bool) (*SubjectAccessExport, error) {
+    var member *Member
+    var err error
+    if isUUID(ref) {
+        member, err = db.GetMemberByPublicID(ref)
+    } else {
+        member, err = db.GetMemberByEmail(ref)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    export := &SubjectAccessExport{
+        GeneratedAt: time.Now().UTC(),
+        Member:      memberResponse(member),
+        Donations:   []apiDonation{},
+        Withheld:    []WithheldField{},
+    }
+    if !includeNotes && member.Notes.Valid && member.Notes.String != "" {
+        delete(export.Member, "notes")
+        export.Withheld = append(export.Withheld, WithheldField{Field: "notes", Reason: withheldNotesReason})
+    }
+
+    if export.StatusHistory, err = db.GetStatusHistory(member.Email, math.MaxInt32); err != nil {
+        return nil, err
+    }
+    if export.StatusHistory == nil {
+        export.StatusHistory = []StatusChange{}
+    }
+    donations, err := db.GetDonations(member.Email, math.MaxInt32)
+    if err != nil {
+        return nil, err
+    }
+    for _, d := range donations {
+        export.Donations = append(export.Donations, newAPIDonation(d))
+    }
+
+    addresses := []string{strings.ToLower(member.Email)}
+    if member.RawEmail.Valid && member.RawEmail.String != "" {
+        addresses = append(addresses, strings.ToLower(strings.TrimSpace(member.RawEmail.String)))
+    }
+    if export.WebhookLogs, err = db.webhookLogsReferencing(addresses); err != nil {
+        return nil, err
+    }
+    if export.Events, err = db.memberEvents(member.ID, addresses); err != nil {
+        return nil, err
+    }
+
+    return export, nil
+}
+
+// webhookLogsReferencing returns every webhook log whose email column or
+// payload email is one of addresses, oldest first
+func (db *Database) webhookLogsReferencing(addresses []string) ([]WebhookLogEntry, error) {
+    rows, err := db.Query(`
+        SELECT id, received_at, COALESCE(email, ''), COALESCE(status, ''), COALESCE(source, ''), payload
+        FROM webhook_logs
+        WHERE lower(trim(email)) = ANY($1) OR lower(trim(payload->>'email')) = ANY($1)
+        ORDER BY received_at, id
+    `, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to get webhook logs: %w", err)
+    }
+    defer rows.Close()
+
+    logs := []WebhookLogEntry{}
+    for rows.Next() {
+        var entry WebhookLogEntry
+        var payload []byte
+        if err := rows.Scan(&entry.ID, &entry.ReceivedAt, &entry.Email, &entry.Status, &entry.Source, &payload); err != nil {
+            return nil, err
+        }
+        entry.Payload = payload
+        logs = append(logs, entry)
+    }
+
+    return logs, rows.Err()
+}
+
+// memberEvents returns the feed events about a member, including merges
+// that name one of their addresses, oldest first
+func (db *Database) memberEvents(memberID int, addresses []string) ([]FeedEvent, error) {
+    rows, err := db.Query(`
+        SELECT e.id, e.type, COALESCE(m.public_id::text, ''), e.source, COALESCE(e.detail, ''),
+               e.payload, e.created_at
+        FROM events e
+        LEFT JOIN members m ON m.id = e.member_id
+        WHERE e.member_id = $1
+           OR lower(e.payload->>'email') = ANY($2)
+           OR lower(e.payload->>'from_email') = ANY($2)
+           OR lower(e.payload->>'to_email') = ANY($2)
+        ORDER BY e.id
+    `, memberID, pq.Array(addresses))
+    if err != nil {
+        return nil, fmt.Errorf("failed to get events: %w", err)
+    }
+    defer rows.Close()
+
+    events := []FeedEvent{}
+    for rows.Next() {
+        var e FeedEvent
+        var payload []byte
+        if err := rows.Scan(&e.ID, &e.Type, &e.MemberID, &e.Source, &e.Detail, &payload, &e.CreatedAt); err != nil {
+            return nil, err
+        }
+        if payload != nil {
+            e.Payload = payload
+        }
+        events = append(events, e)
+    }
+
+    return events, rows.Err()
+}
+
+// subjectAccessHandler serves GET /sar/{member}, where member is an email or
+// public UUID
+func (s *WebhookServer) subjectAccessHandler(w http.ResponseWriter, r *http.Request) {
+    includeNotes := r.URL.Query().Get("include_notes") == "true"
+
+    export, err := s.db.SubjectAccess(r.PathValue("member"), includeNotes)
+    if err != nil {
+        if errors.Is(err, ErrMemberNotFound) {
+            writeError(w, r, http.StatusNotFound, errNotFound, "Member not found")
+            return
+        }
+        s.logger.Printf("Error building subject access export: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    s.logger.Printf("Subject access export for %v by %s", export.Member["id"], s.principal(r))
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(export)
+}
+
+func runSAR(logger *log.Logger) {
+    sarCmd := flag.NewFlagSet("sar", flag.ExitOnError)
+    output := sarCmd.String("output", "", "Write to this file instead of stdout")
+    includeNotes := sarCmd.Bool("include-notes", false, "Include internal notes, which are withheld by default")
+
+    args := parseSubcommand(sarCmd, "memberships sar <email|id> [--output file] [--include-notes]", os.Args[2:])
+    if len(args) != 1 {
+        fmt.Fprintln(os.Stderr, "Error: sar requires one email or member id")
+        sarCmd.Usage()
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    export, err := db.SubjectAccess(args[0], *includeNotes)
+    if errors.Is(err, ErrMemberNotFound) {
+        fmt.Fprintf(os.Stderr, "No member found for %s\n", args[0])
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Subject access export failed: %v", err)
+    }
+
+    data, err := json.MarshalIndent(export, "", "  ")
+    if err != nil {
+        logger.Fatalf("Subject access export failed: %v", err)
+    }
+    data = append(data, '\n')
+
+    if *output == "" {
+        os.Stdout.Write(data)
+        return
+    }
+    // The export is personal data, so only the owner can read it
+    if err := os.WriteFile(*output, data, 0600); err != nil {
+        logger.Fatalf("Failed to write %s: %v", *output, err)
+    }
+    logger.Printf("Wrote subject access export for %v to %s (%d status changes, %d donations, %d webhook logs, %d events)",
+        export.Member["id"], *output, len(export.StatusHistory), len(export.Donations), len(export.WebhookLogs), len(export.Events))
+}
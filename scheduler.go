@@ -0,0 +1,270 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// SyncStatus summarizes the most recent scheduled or triggered clean run
+type SyncStatus struct {
+    Source      string    `json:"source"`
+    StartedAt   time.Time `json:"started_at"`
+    FinishedAt  time.Time `json:"finished_at"`
+    Status      string    `json:"status"` // applied, skipped, or failed
+    SyncRunID   int       `json:"sync_run_id,omitempty"`
+    Added       int       `json:"added"`
+    Reactivated int       `json:"reactivated"`
+    Deactivated int       `json:"deactivated"`
+    Suspended   int       `json:"suspended"`
+    Error       string    `json:"error,omitempty"`
+}
+
+// SyncScheduler runs clean against SYNC_SOURCE in server mode. A mutex keeps
+// the interval job and manual triggers from overlapping.
+type SyncScheduler struct {
+    db     Store
+    source string
+    rules  []StatusRule
+    logger *log.Logger
+
+    running sync.Mutex
+
+    mu   sync.Mutex
+    last *SyncStatus
+}
+
+// NewSyncScheduler returns a scheduler for source, mapping payment statuses
+// with rules and logging to logger, or nil when source is unset
+func NewSyncScheduler(db Store, source string, rules []StatusRule, logger *log.Logger) *SyncScheduler {
+    if source == "" {
+        return nil
+    }
+    return &SyncScheduler{db: db, source: source, rules: rules, logger: logger}
+}
+
+// Last returns the most recent run's status, or nil before the first run
+func (s *SyncScheduler) Last() *SyncStatus {
+    if s == nil {
+        return nil
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.last
+}
+
+// TryRun runs a sync unless one is already in progress, and reports
+// whether it ran
+func (s *SyncScheduler) TryRun() bool {
+    if !s.running.TryLock() {
+        return false
+    }
+    defer s.running.Unlock()
+
+    s.runAndRecord()
+    return true
+}
+
+// TryStart starts a sync in the background unless one is already in
+// progress, and reports whether it started
+func (s *SyncScheduler) TryStart() bool {
+    if !s.running.TryLock() {
+        return false
+    }
+
+    go func() {
+        defer s.running.Unlock()
+        s.runAndRecord()
+    }()
+    return true
+}
+
+// runAndRecord runs a sync and keeps its status for /stats
+func (s *SyncScheduler) runAndRecord() {
+    status := s.run()
+
+    s.mu.Lock()
+    s.last = status
+    s.mu.Unlock()
+
+    switch status.Status {
+    case "failed":
+        s.logger.Printf("Scheduled sync of %s failed: %s", status.Source, status.Error)
+    case "skipped":
+        s.logger.Printf("Scheduled sync skipped: %s", status.Error)
+    default:
+        s.logger.Printf("Scheduled sync of %s applied as run #%d: %d added, %d reactivated, %d deactivated, %d suspended",
+            status.Source, status.SyncRunID, status.Added, status.Reactivated, status.Deactivated, status.Suspended)
+    }
+}
+
+// run performs one sync. Errors, including panics, are captured in the
+// returned status so they never take down the server.
+func (s *SyncScheduler) run() (status *SyncStatus) {
+    status = &SyncStatus{Source: s.source, StartedAt: time.Now().UTC()}
+    defer func() {
+        if r := recover(); r != nil {
+            status.Status = "failed"
+            status.Error = fmt.Sprintf("panic: %v", r)
+        }
+        status.FinishedAt = time.Now().UTC()
+        if status.Status == "failed" {
+            if err := s.db.RecordFailedSyncRun(status.Source); err != nil {
+                s.logger.Printf("Failed to record failed sync run: %v", err)
+            }
+        }
+    }()
+
+    input, err := s.resolveSource()
+    if err != nil {
+        status.Status = "failed"
+        status.Error = err.Error()
+        return status
+    }
+    if input == "" {
+        status.Status = "skipped"
+        status.Error = fmt.Sprintf("no new CSV in %s", s.source)
+        return status
+    }
+    status.Source = input
+
+    report, err := cleanDatabase(s.db, input, CleanOptions{
+        MaxDeactivatePercent: defaultMaxDeactivatePercent,
+        FetchTimeout:         defaultFetchTimeout,
+        MaxFetchBytes:        defaultMaxFetchBytes,
+        ProgressRows:         defaultProgressRows,
+        StatusRules:          s.rules,
+        Logger:               s.logger,
+    })
+    if err != nil {
+        status.Status = "failed"
+        status.Error = err.Error()
+        return status
+    }
+
+    status.Status = "applied"
+    status.SyncRunID = report.SyncRunID
+    status.Added = len(report.Added)
+    status.Reactivated = len(report.Reactivated)
+    status.Deactivated = len(report.Deactivated)
+    status.Suspended = len(report.Suspended)
+    return status
+}
+
+// resolveSource returns what to clean: a URL as-is, or the newest CSV in a
+// watched directory. It returns "" when the newest drop was already applied.
+func (s *SyncScheduler) resolveSource() (string, error) {
+    if isURL(s.source) {
+        return s.source, nil
+    }
+
+    info, err := os.Stat(s.source)
+    if err != nil {
+        return "", fmt.Errorf("failed to read SYNC_SOURCE: %w", err)
+    }
+    if !info.IsDir() {
+        return s.source, nil
+    }
+
+    entries, err := os.ReadDir(s.source)
+    if err != nil {
+        return "", fmt.Errorf("failed to read SYNC_SOURCE: %w", err)
+    }
+
+    var newest string
+    var newestTime time.Time
+    for _, entry := range entries {
+        if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+        if newest == "" || info.ModTime().After(newestTime) {
+            newest = filepath.Join(s.source, entry.Name())
+            newestTime = info.ModTime()
+        }
+    }
+
+    if newest == "" {
+        return "", nil
+    }
+
+    applied, err := s.db.SyncRunApplied(newest)
+    if err != nil {
+        return "", fmt.Errorf("failed to check sync history: %w", err)
+    }
+    if applied {
+        return "", nil
+    }
+    return newest, nil
+}
+
+// RecordFailedSyncRun records a sync run that errored before applying changes
+func (db *Database) RecordFailedSyncRun(inputFile string) error {
+    _, err := db.Exec(`
+        INSERT INTO sync_runs (input_file, status, finished_at)
+        VALUES ($1, 'failed', CURRENT_TIMESTAMP)
+    `, inputFile)
+    return err
+}
+
+// SyncRunApplied reports whether inputFile has already been applied
+func (db *Database) SyncRunApplied(inputFile string) (bool, error) {
+    var exists bool
+    err := db.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM sync_runs WHERE input_file = $1 AND status IN ('applied', 'undone'))
+    `, inputFile).Scan(&exists)
+    return exists, err
+}
+
+// syncJob runs the scheduled sync in server mode. POST /sync shares the
+// scheduler's lock, so a sync it started also turns the job away.
+func (s *WebhookServer) syncJob() *Job {
+    if s.scheduler == nil || s.config.SyncInterval <= 0 {
+        return nil
+    }
+
+    return &Job{
+        Name:     jobSync,
+        Interval: s.config.SyncInterval,
+        Schedule: fmt.Sprintf("every %s from %s", formatInterval(s.config.SyncInterval), s.config.SyncSource),
+        run: func() (string, error) {
+            if !s.scheduler.TryRun() {
+                return "", ErrJobRunning
+            }
+            status := s.scheduler.Last()
+            switch status.Status {
+            case "failed":
+                return "", errors.New(status.Error)
+            case "skipped":
+                return "skipped: " + status.Error, nil
+            }
+            return fmt.Sprintf("run #%d: %d added, %d reactivated, %d deactivated, %d suspended",
+                status.SyncRunID, status.Added, status.Reactivated, status.Deactivated, status.Suspended), nil
+        },
+    }
+}
+
+// syncHandler starts a sync on demand
+func (s *WebhookServer) syncHandler(w http.ResponseWriter, r *http.Request) {
+    if s.scheduler == nil {
+        writeError(w, r, http.StatusServiceUnavailable, errUnavailable, "SYNC_SOURCE is not configured")
+        return
+    }
+
+    if !s.scheduler.TryStart() {
+        writeError(w, r, http.StatusConflict, errConflict, "Sync already in progress")
+        return
+    }
+
+    w.WriteHeader(http.StatusAccepted)
+    w.Write([]byte("Sync started"))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "time"
+
+    "github.com/lib/pq"
+
+    "memberships/seed"
+)
+
+// ErrRealData is returned when seeding a database that already holds rows
+// the seed command didn't make
+var ErrRealData = errors.New("database already has real data")
+
+// SeedOptions controls what Seed generates
+type SeedOptions struct {
+    seed.Options
+
+    // Force seeds even when the database has data of its own
+    Force bool
+}
+
+// SeedResult counts what Seed added
+type SeedResult struct {
+    Members       int
+    Skipped       int
+    StatusChanges int
+    Webhooks      int
+    Donations     int
+}
+
+// countRealData returns how many members and webhook logs the seed command
+// didn't make
+func (db *Database) countRealData() (members, webhooks int, err error) {
+    err = db.QueryRow(`
+        SELECT
+            (SELECT COUNT(*) FROM members WHERE NOT ($1 = ANY(tags))),
+            (SELECT COUNT(*) FROM webhook_logs WHERE source IS DISTINCT FROM $2)
+    `, seed.Tag, seed.Source).Scan(&members, &webhooks)
+    if err != nil {
+        return 0, 0, fmt.Errorf("failed to check for existing data: %w", err)
+    }
+    return members, webhooks, nil
+}
+
+// Seed fills the database with fake members for development: varied
+// statuses, frequencies, and anonymity, with status history, webhook logs,
+// and donations to match. Seeded members carry the "seed" tag; one whose
+// email already exists is skipped. It refuses a database holding anything
+// else unless opts.Force is set.
+func (db *Database) Seed(opts SeedOptions) (*SeedResult, error) {
+    if opts.Members <= 0 || opts.Days <= 0 {
+        return nil, fmt.Errorf("members and days must be positive")
+    }
+    if opts.Now.IsZero() {
+        opts.Now = time.Now()
+    }
+
+    if !opts.Force {
+        members, webhooks, err := db.countRealData()
+        if err != nil {
+            return nil, err
+        }
+        if members > 0 || webhooks > 0 {
+            return nil, fmt.Errorf("%w: %d members and %d webhook logs weren't made by seed", ErrRealData, members, webhooks)
+        }
+    }
+
+    opts.Zone = displayZone
+    plan := seed.Plan(opts.Options)
+    result := &SeedResult{}
+    err := db.inTx(func(tx *sql.Tx) error {
+        for i := range plan {
+            added, err := db.insertSeedMember(tx, &plan[i], result)
+            if err != nil {
+                return err
+            }
+            if !added {
+                result.Skipped++
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// insertSeedMember writes one planned member and everything that goes with
+// it, reporting false if the email was already taken
+func (db *Database) insertSeedMember(tx *sql.Tx, m *seed.Member, result *SeedResult) (bool, error) {
+    email := db.NormalizeEmail(m.Email)
+    storedRaw, ciphertext, err := db.storedEmail(m.Email)
+    if err != nil {
+        return false, err
+    }
+
+    final := m.Changes[len(m.Changes)-1]
+    var firstPayment, lastPayment interface{}
+    if len(m.Donations) > 0 {
+        firstPayment = m.Donations[0].OccurredAt
+        lastPayment = m.Donations[len(m.Donations)-1].OccurredAt
+    }
+    failedPayments := 0
+    if final.Status == seed.StatusSuspended {
+        failedPayments = 1
+    }
+    var optIn, consentAt, consentSource interface{}
+    if m.OptIn != nil {
+        optIn, consentAt, consentSource = *m.OptIn, m.FirstSeen, seed.Source
+    }
+
+    var memberID int
+    err = tx.QueryRow(`
+        INSERT INTO members (email, email_hash, raw_email, email_ciphertext, name, is_anonymous, status,
+            frequency, frequency_raw, tags, first_seen, last_updated, last_event_at,
+            first_payment_at, last_payment_at, failed_payment_count,
+            email_opt_in, consent_recorded_at, consent_source)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, $12, $13, $14, $15, $16, $17, $18)
+        ON CONFLICT DO NOTHING
+        RETURNING id
+    `, email, emailHash(email), storedRaw, ciphertext, m.Name, m.Anonymous, final.Status,
+        m.Frequency, m.FrequencyRaw, pq.Array([]string{seed.Tag}), m.FirstSeen, final.At,
+        firstPayment, lastPayment, failedPayments, optIn, consentAt, consentSource).Scan(&memberID)
+    if err == sql.ErrNoRows {
+        return false, nil
+    } else if err != nil {
+        return false, fmt.Errorf("failed to create member %s: %w", email, err)
+    }
+    result.Members++
+
+    for _, c := range m.Changes {
+        if c.Payment != "" {
+            logID, err := db.insertSeedWebhook(tx, m, c, webhookStateDone, "")
+            if err != nil {
+                return false, err
+            }
+            c.Detail = fmt.Sprintf("webhook log #%d", logID)
+            result.Webhooks++
+        }
+
+        _, err := tx.Exec(`
+            INSERT INTO status_history (member_id, status, changed_at, source, detail)
+            VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+        `, memberID, c.Status, c.At, c.Source, c.Detail)
+        if err != nil {
+            return false, fmt.Errorf("failed to record status history: %w", err)
+        }
+        result.StatusChanges++
+    }
+
+    if m.FailedWebhook {
+        c := seed.Change{At: final.At, Status: final.Status, Payment: "Succeeded"}
+        if _, err := db.insertSeedWebhook(tx, m, c, webhookStateFailed, "database error: connection reset by peer"); err != nil {
+            return false, err
+        }
+        result.Webhooks++
+    }
+
+    donations := make([]Donation, len(m.Donations))
+    for i, d := range m.Donations {
+        donations[i] = Donation{
+            Email:       m.Email,
+            AmountCents: d.AmountCents,
+            Currency:    defaultCurrency,
+            Frequency:   d.Frequency,
+            OccurredAt:  d.OccurredAt,
+            Source:      seed.Source,
+            ExternalID:  d.ExternalID,
+        }
+    }
+    added, err := db.recordDonations(tx, donations)
+    if err != nil {
+        return false, err
+    }
+    result.Donations += added
+
+    return true, nil
+}
+
+// insertSeedWebhook logs the Zapier delivery behind a seeded change, as
+// LogWebhook would have
+func (db *Database) insertSeedWebhook(tx *sql.Tx, m *seed.Member, c seed.Change, state, lastError string) (int, error) {
+    anonymous := "False"
+    if m.Anonymous {
+        anonymous = "True"
+    }
+    payload, err := json.Marshal(MemberWebhook{
+        Email:     m.Email,
+        Name:      m.Name,
+        Status:    c.Payment,
+        Anonymous: anonymous,
+        Frequency: m.FrequencyRaw,
+        EventTime: c.At.UTC().Format(time.RFC3339),
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    email := m.Email
+    if db.Privacy != nil {
+        email = db.NormalizeEmail(email)
+        payload = db.redactPayload(payload)
+    }
+
+    var id int
+    err = tx.QueryRow(`
+        INSERT INTO webhook_logs (received_at, email, status, source, payload, state, attempts, last_error)
+        VALUES ($1, $2, $3, $4, $5, $6, 1, NULLIF($7, ''))
+        RETURNING id
+    `, c.At, email, c.Status, seed.Source, payload, state, lastError).Scan(&id)
+    if err != nil {
+        return 0, fmt.Errorf("failed to log webhook: %w", err)
+    }
+    return id, nil
+}
+
+func runSeed(logger *log.Logger) {
+    seedCmd := flag.NewFlagSet("seed", flag.ExitOnError)
+    members := seedCmd.Int("members", 500, "Number of members to generate")
+    days := seedCmd.Int("days", 365, "Spread first_seen over this many days before now")
+    seedValue := seedCmd.Uint64("seed", 1, "Random seed; the same seed gives the same members")
+    force := seedCmd.Bool("force", false, "Seed even if the database already has real data")
+
+    parseSubcommand(seedCmd, "memberships seed [--members 500] [--days 365] [--seed 1] [--force]", os.Args[2:])
+    if *members <= 0 || *days <= 0 {
+        fmt.Fprintln(os.Stderr, "Error: --members and --days must be positive")
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    result, err := db.Seed(SeedOptions{
+        Options: seed.Options{Members: *members, Days: *days, Seed: *seedValue},
+        Force:   *force,
+    })
+    if errors.Is(err, ErrRealData) {
+        fmt.Fprintf(os.Stderr, "Refusing to seed: %v\nThis looks like a real database; pass --force to add fake members anyway.\n", err)
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Seed failed: %v", err)
+    }
+
+    fmt.Printf("Seeded %d members (%d status changes, %d webhook logs, %d donations)\n",
+        result.Members, result.StatusChanges, result.Webhooks, result.Donations)
+    if result.Skipped > 0 {
+        fmt.Printf("Skipped %d members whose emails already exist\n", result.Skipped)
+    }
+}
@@ -0,0 +1,280 @@
+// Package seed plans fake members for development databases: varied
+// statuses, frequencies, and anonymity, with the status changes and
+// donations that would have produced them. It only generates; the
+// memberships seed command writes a plan to the database.
+package seed
+
+import (
+    "fmt"
+    "math/rand/v2"
+    "strings"
+    "time"
+)
+
+// Tag marks seeded members, so a later run can tell its own rows from real
+// ones
+const Tag = "seed"
+
+// Source names seeded webhook logs and donations
+const Source = "seed"
+
+// Statuses and canonical frequencies, as memberships stores them
+const (
+    StatusActive    = "active"
+    StatusCancelled = "cancelled"
+    StatusSuspended = "suspended"
+    StatusLapsed    = "lapsed"
+
+    FrequencyMonthly   = "monthly"
+    FrequencyQuarterly = "quarterly"
+    FrequencyAnnual    = "annual"
+    FrequencyOther     = "other"
+)
+
+// Options controls what Plan generates
+type Options struct {
+    Members int
+    Days    int
+
+    // Seed drives every random choice, so the same seed (and Now) gives the
+    // same members
+    Seed uint64
+
+    // Now ends the generated window; zero means the current time
+    Now time.Time
+
+    // Zone is where dates written into change details fall; nil means UTC
+    Zone *time.Location
+}
+
+// Change is one step of a member's status trajectory
+type Change struct {
+    At     time.Time
+    Status string
+    Source string
+    Detail string
+
+    // Payment is the status a webhook would have carried, or "" for a
+    // change that didn't come from a webhook
+    Payment string
+}
+
+// Donation is one payment, in the default currency
+type Donation struct {
+    AmountCents int64
+    Frequency   string
+    OccurredAt  time.Time
+    ExternalID  string
+}
+
+// Member is one generated member. Changes is never empty; its last entry
+// is the member's current status.
+type Member struct {
+    Email        string
+    Name         string
+    Anonymous    bool
+    Frequency    string
+    FrequencyRaw string
+    OptIn        *bool
+    FirstSeen    time.Time
+    Changes      []Change
+    Donations    []Donation
+
+    // FailedWebhook leaves one delivery stuck in the failed state
+    FailedWebhook bool
+}
+
+var (
+    firstNames = []string{"Ada", "Alan", "Amara", "Ben", "Carmen", "Chen", "Dana", "Diego", "Elena", "Farah",
+        "Grace", "Hiro", "Imani", "Jonas", "Kai", "Lena", "Malik", "Maya", "Nadia", "Omar", "Priya", "Quinn",
+        "Rosa", "Sam", "Tariq", "Uma", "Victor", "Wen", "Yara", "Zoe"}
+    lastNames = []string{"Abbott", "Baptiste", "Castillo", "Dubois", "Eriksen", "Fischer", "Garcia", "Haddad",
+        "Ito", "Johansson", "Kowalski", "Lindqvist", "Mensah", "Nakamura", "Okafor", "Patel", "Quintero",
+        "Rossi", "Schmidt", "Tanaka", "Uddin", "Vargas", "Wojcik", "Xu", "Yilmaz", "Zhang"}
+
+    // Reserved for documentation (RFC 2606), so nothing is ever delivered
+    domains = []string{"example.com", "example.org", "example.net"}
+
+    // Frequencies as a donation form sends them, and what each means
+    frequencies = map[string]string{
+        "Monthly":       FrequencyMonthly,
+        "Annually":      FrequencyAnnual,
+        "Quarterly":     FrequencyQuarterly,
+        "Every 2 weeks": FrequencyOther,
+        "":              "",
+    }
+
+    // Gift sizes per payment, in dollars, by canonical frequency
+    amounts = map[string][]int64{
+        FrequencyMonthly:   {5, 10, 10, 15, 20, 25, 25, 50, 100},
+        FrequencyQuarterly: {15, 30, 45, 75, 150},
+        FrequencyAnnual:    {50, 60, 100, 120, 250, 500, 1000},
+        FrequencyOther:     {5, 10, 20},
+    }
+)
+
+// weight is one choice in a weighted random pick
+type weight struct {
+    value  string
+    weight int
+}
+
+// pick returns one of choices at random, in proportion to their weights
+func pick(rng *rand.Rand, choices ...weight) string {
+    total := 0
+    for _, c := range choices {
+        total += c.weight
+    }
+    n := rng.IntN(total)
+    for _, c := range choices {
+        if n -= c.weight; n < 0 {
+            return c.value
+        }
+    }
+    return choices[len(choices)-1].value
+}
+
+// days returns a random duration averaging mean days
+func days(rng *rand.Rand, mean float64) time.Duration {
+    return time.Duration(rng.ExpFloat64() * mean * float64(24*time.Hour))
+}
+
+// nextPayment is when a member on frequency who paid at from is charged
+// next
+func nextPayment(frequency string, from time.Time) time.Time {
+    switch frequency {
+    case FrequencyQuarterly:
+        return from.AddDate(0, 3, 0)
+    case FrequencyAnnual:
+        return from.AddDate(1, 0, 0)
+    case FrequencyOther:
+        return from.AddDate(0, 0, 14)
+    }
+    return from.AddDate(0, 1, 0)
+}
+
+// Plan generates opts.Members members first seen over the opts.Days days
+// before opts.Now
+func Plan(opts Options) []Member {
+    if opts.Now.IsZero() {
+        opts.Now = time.Now()
+    }
+    if opts.Zone == nil {
+        opts.Zone = time.UTC
+    }
+    rng := rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x5eed))
+    window := time.Duration(opts.Days) * 24 * time.Hour
+
+    members := make([]Member, max(opts.Members, 0))
+    for i := range members {
+        m := &members[i]
+
+        first := firstNames[rng.IntN(len(firstNames))]
+        last := lastNames[rng.IntN(len(lastNames))]
+        m.Email = fmt.Sprintf("%s.%s%d@%s", strings.ToLower(first), strings.ToLower(last), i+1,
+            domains[rng.IntN(len(domains))])
+        m.Anonymous = rng.IntN(100) < 15
+        if !m.Anonymous {
+            m.Name = first + " " + last
+        }
+
+        m.FrequencyRaw = pick(rng, weight{"Monthly", 60}, weight{"Annually", 22},
+            weight{"Quarterly", 10}, weight{"Every 2 weeks", 3}, weight{"", 5})
+        m.Frequency = frequencies[m.FrequencyRaw]
+
+        // Some declined email, most agreed, and older records never asked
+        if consent := rng.IntN(100); consent < 75 {
+            optIn := consent >= 20
+            m.OptIn = &optIn
+        }
+
+        m.FirstSeen = opts.Now.Add(-time.Duration(rng.Int64N(int64(window)))).Truncate(time.Second)
+        m.Changes = planTrajectory(rng, m.FirstSeen, opts.Now, opts.Zone)
+        m.Donations = planDonations(rng, m, fmt.Sprintf("%s:%d:%d", Source, opts.Seed, i+1), opts.Now)
+        m.FailedWebhook = rng.IntN(100) < 2
+    }
+    return members
+}
+
+// planTrajectory walks a member through status changes from firstSeen until
+// now: cancellations, failed payments that suspend and recover, lapses, and
+// the occasional reactivation
+func planTrajectory(rng *rand.Rand, firstSeen, now time.Time, zone *time.Location) []Change {
+    webhook := "webhook:" + Source
+    changes := []Change{{At: firstSeen, Status: StatusActive, Source: webhook, Payment: "Succeeded"}}
+
+    at, status := firstSeen, StatusActive
+    for {
+        var next Change
+        switch status {
+        case StatusActive:
+            at = at.Add(days(rng, 240))
+            next.Status = pick(rng, weight{StatusCancelled, 55}, weight{StatusSuspended, 35}, weight{StatusLapsed, 10})
+        case StatusSuspended:
+            at = at.Add(days(rng, 12))
+            next.Status = pick(rng, weight{StatusActive, 65}, weight{StatusCancelled, 35})
+        case StatusLapsed:
+            at = at.Add(days(rng, 30))
+            next.Status = pick(rng, weight{StatusActive, 40}, weight{StatusCancelled, 60})
+        case StatusCancelled:
+            // Most cancellations are final
+            if rng.IntN(100) >= 25 {
+                return changes
+            }
+            at = at.Add(days(rng, 180))
+            next.Status = StatusActive
+        }
+        if !at.Before(now) {
+            return changes
+        }
+
+        next.At = at.Truncate(time.Second)
+        next.Source = webhook
+        switch {
+        case status == StatusCancelled:
+            next.Source, next.Detail = "manual", "reactivated"
+        case next.Status == StatusLapsed:
+            next.Source, next.Detail = "lapse", "no payment since "+at.AddDate(0, 0, -45).In(zone).Format("2006-01-02")
+        case next.Status == StatusActive:
+            next.Payment = "Succeeded"
+        case next.Status == StatusSuspended:
+            next.Payment = "Failed"
+        case next.Status == StatusCancelled:
+            next.Payment = "Cancelled"
+        }
+
+        changes = append(changes, next)
+        status = next.Status
+    }
+}
+
+// planDonations charges a member on their frequency through each stretch of
+// being active
+func planDonations(rng *rand.Rand, m *Member, idPrefix string, now time.Time) []Donation {
+    frequency := m.Frequency
+    if frequency == "" {
+        frequency = FrequencyMonthly
+    }
+    choices := amounts[frequency]
+    cents := choices[rng.IntN(len(choices))] * 100
+
+    var donations []Donation
+    for i, c := range m.Changes {
+        if c.Status != StatusActive {
+            continue
+        }
+        end := now
+        if i+1 < len(m.Changes) {
+            end = m.Changes[i+1].At
+        }
+        for paid := c.At; paid.Before(end); paid = nextPayment(frequency, paid) {
+            donations = append(donations, Donation{
+                AmountCents: cents,
+                Frequency:   m.FrequencyRaw,
+                OccurredAt:  paid,
+                ExternalID:  fmt.Sprintf("%s:%d", idPrefix, len(donations)+1),
+            })
+        }
+    }
+    return donations
+}
@@ -0,0 +1,93 @@
+package seed
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+var testNow = time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestPlanIsDeterministic(t *testing.T) {
+    opts := Options{Members: 50, Days: 365, Seed: 7, Now: testNow}
+    if !reflect.DeepEqual(Plan(opts), Plan(opts)) {
+        t.Error("the same options planned different members")
+    }
+
+    opts.Seed = 8
+    if reflect.DeepEqual(Plan(Options{Members: 50, Days: 365, Seed: 7, Now: testNow}), Plan(opts)) {
+        t.Error("different seeds planned the same members")
+    }
+}
+
+func TestPlanTrajectories(t *testing.T) {
+    members := Plan(Options{Members: 500, Days: 730, Seed: 1, Now: testNow})
+    if len(members) != 500 {
+        t.Fatalf("planned %d members, want 500", len(members))
+    }
+
+    emails := map[string]bool{}
+    for _, m := range members {
+        if emails[m.Email] {
+            t.Errorf("%s planned twice", m.Email)
+        }
+        emails[m.Email] = true
+        if m.Anonymous != (m.Name == "") {
+            t.Errorf("%s: anonymous %v with name %q", m.Email, m.Anonymous, m.Name)
+        }
+        if want, ok := frequencies[m.FrequencyRaw]; !ok || m.Frequency != want {
+            t.Errorf("%s: frequency %q for %q", m.Email, m.Frequency, m.FrequencyRaw)
+        }
+        if m.FirstSeen.Before(testNow.AddDate(0, 0, -730)) || m.FirstSeen.After(testNow) {
+            t.Errorf("%s: first seen %v is outside the window", m.Email, m.FirstSeen)
+        }
+
+        if len(m.Changes) == 0 || m.Changes[0].Status != StatusActive || !m.Changes[0].At.Equal(m.FirstSeen) {
+            t.Fatalf("%s: doesn't start active when first seen: %+v", m.Email, m.Changes)
+        }
+        for i, c := range m.Changes {
+            if c.At.After(testNow) {
+                t.Errorf("%s: change at %v is after now", m.Email, c.At)
+            }
+            if i > 0 && (c.Status == m.Changes[i-1].Status || c.At.Before(m.Changes[i-1].At)) {
+                t.Errorf("%s: change %d (%s at %v) doesn't follow %+v", m.Email, i, c.Status, c.At, m.Changes[i-1])
+            }
+            if (c.Payment == "") == (c.Source == "webhook:"+Source) {
+                t.Errorf("%s: change %d from %s carries payment %q", m.Email, i, c.Source, c.Payment)
+            }
+        }
+
+        for _, d := range m.Donations {
+            if !activeAt(m.Changes, d.OccurredAt) {
+                t.Errorf("%s: donation at %v while not active", m.Email, d.OccurredAt)
+            }
+        }
+    }
+}
+
+// activeAt reports whether a trajectory has the member active at t
+func activeAt(changes []Change, t time.Time) bool {
+    status := ""
+    for _, c := range changes {
+        if c.At.After(t) {
+            break
+        }
+        status = c.Status
+    }
+    return status == StatusActive
+}
+
+func TestNextPayment(t *testing.T) {
+    from := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+    tests := map[string]time.Time{
+        FrequencyMonthly:   from.AddDate(0, 1, 0),
+        FrequencyQuarterly: from.AddDate(0, 3, 0),
+        FrequencyAnnual:    from.AddDate(1, 0, 0),
+        FrequencyOther:     from.AddDate(0, 0, 14),
+    }
+    for frequency, want := range tests {
+        if got := nextPayment(frequency, from); !got.Equal(want) {
+            t.Errorf("nextPayment(%s) = %v, want %v", frequency, got, want)
+        }
+    }
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "memberships/seed"
+)
+
+// The seed package can't import this one, so it keeps its own copy of the
+// status and frequency names
+func TestSeedPlanMatchesModel(t *testing.T) {
+    for _, m := range seed.Plan(seed.Options{Members: 200, Days: 365, Seed: 3, Now: time.Now()}) {
+        if got := normalizeFrequency(m.FrequencyRaw); got != m.Frequency {
+            t.Errorf("%s: seed says %q is %q, normalizeFrequency says %q", m.Email, m.FrequencyRaw, m.Frequency, got)
+        }
+        if err := validateEmail(m.Email); err != nil {
+            t.Errorf("%s: %v", m.Email, err)
+        }
+        for _, c := range m.Changes {
+            if !validStatus(c.Status) {
+                t.Errorf("%s: unknown status %q", m.Email, c.Status)
+            }
+        }
+    }
+}
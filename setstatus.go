@@ -0,0 +1,105 @@
+package main
+
+import (
+    "database/sql"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+)
+
+// manualStatuses are the statuses an operator may set by hand
+var manualStatuses = map[string]bool{
+    "active":    true,
+    "cancelled": true,
+    "suspended": true,
+}
+
+// SetMemberStatus changes a member's status as an operator correction and
+// returns the previous one. The note is kept as the history detail. When the
+// status is unchanged nothing is written, so history gets no duplicate row.
+func (db *Database) SetMemberStatus(email, status, note string) (string, error) {
+    email = db.NormalizeEmail(email)
+
+    tx, err := db.Begin()
+    if err != nil {
+        return "", fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var previous string
+    err = tx.QueryRow(`SELECT status FROM members WHERE email = $1 FOR UPDATE`, email).Scan(&previous)
+    if err == sql.ErrNoRows {
+        return "", fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+    } else if err != nil {
+        return "", fmt.Errorf("database error: %w", err)
+    }
+
+    if previous == status {
+        return previous, nil
+    }
+
+    change := ChangeSource{Source: "manual", Detail: strings.TrimSpace(note)}
+    if err := db.updateMemberStatus(tx, email, status, change); err != nil {
+        return "", fmt.Errorf("failed to update status: %w", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return "", fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    if db.OnStatusChange != nil {
+        db.OnStatusChange(email, previous, status)
+    }
+
+    return previous, nil
+}
+
+func runSetStatus(logger *log.Logger) {
+    setStatusCmd := flag.NewFlagSet("set-status", flag.ExitOnError)
+    reason := setStatusCmd.String("reason", "", "Why the status was changed (recorded in history)")
+
+    args := parseSubcommand(setStatusCmd, `memberships set-status <email> <active|cancelled|suspended> [--reason "text"]`, os.Args[2:])
+    if len(args) < 2 {
+        fmt.Fprintln(os.Stderr, "Error: set-status command requires an email and a status")
+        setStatusCmd.Usage()
+        os.Exit(2)
+    }
+
+    status := strings.ToLower(strings.TrimSpace(args[1]))
+    if !manualStatuses[status] {
+        fmt.Fprintf(os.Stderr, "Error: invalid status %q (use active, cancelled, or suspended)\n", args[1])
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    email := db.NormalizeEmail(args[0])
+
+    // Let subscribers hear about manual corrections too
+    dispatcher := NewDispatcher(db, logger)
+    db.OnStatusChange = dispatcher.StatusChanged
+    defer dispatcher.Wait()
+
+    previous, err := db.SetMemberStatus(email, status, *reason)
+    if errors.Is(err, ErrMemberNotFound) {
+        fmt.Fprintf(os.Stderr, "No member found for %s\n", email)
+        os.Exit(1)
+    } else if errors.Is(err, ErrHouseholdMember) {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    } else if err != nil {
+        logger.Fatalf("Set status failed: %v", err)
+    }
+
+    if previous == status {
+        fmt.Printf("%s is already %s; nothing changed\n", email, status)
+        return
+    }
+
+    fmt.Printf("%s: %s -> %s\n", email, previous, status)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// defaultHistoryDays is how far back stats history looks by default
+const defaultHistoryDays = 90
+
+// maxHistoryDays caps GET /stats/history
+const maxHistoryDays = 3650
+
+// snapshotCheckInterval is how often the server makes sure today's snapshot
+// exists; re-taking it the same day just refreshes the counts
+const snapshotCheckInterval = time.Hour
+
+// StatsSnapshot is one day's member counts
+type StatsSnapshot struct {
+    Date      string    `json:"date"`
+    Total     int       `json:"total"`
+    Active    int       `json:"active"`
+    Cancelled int       `json:"cancelled"`
+    Suspended int       `json:"suspended"`
+    Lapsed    int       `json:"lapsed"`
+    Anonymous int       `json:"anonymous"`
+    TakenAt   time.Time `json:"taken_at"`
+}
+
+// TakeSnapshot records today's counts. There is one row per day, so running
+// it again the same day updates that row instead of adding another.
+func (db *Database) TakeSnapshot() (*StatsSnapshot, error) {
+    var s StatsSnapshot
+    var date time.Time
+    err := db.QueryRow(`
+        INSERT INTO stats_snapshots (snapshot_date, total, active, cancelled, suspended, lapsed, anonymous)
+        SELECT CURRENT_DATE,
+               COUNT(*),
+               COUNT(*) FILTER (WHERE status = 'active'),
+               COUNT(*) FILTER (WHERE status = 'cancelled'),
+               COUNT(*) FILTER (WHERE status = 'suspended'),
+               COUNT(*) FILTER (WHERE status = 'lapsed'),
+               COUNT(*) FILTER (WHERE is_anonymous = true)
+        FROM members
+        WHERE `+db.countedMembers()+`
+        ON CONFLICT (snapshot_date) DO UPDATE SET
+            total = EXCLUDED.total,
+            active = EXCLUDED.active,
+            cancelled = EXCLUDED.cancelled,
+            suspended = EXCLUDED.suspended,
+            lapsed = EXCLUDED.lapsed,
+            anonymous = EXCLUDED.anonymous,
+            taken_at = CURRENT_TIMESTAMP
+        RETURNING snapshot_date, total, active, cancelled, suspended, lapsed, anonymous, taken_at
+    `).Scan(&date, &s.Total, &s.Active, &s.Cancelled, &s.Suspended, &s.Lapsed, &s.Anonymous, &s.TakenAt)
+    if err != nil {
+        return nil, fmt.Errorf("failed to record snapshot: %w", err)
+    }
+    s.Date = date.Format("2006-01-02")
+
+    return &s, nil
+}
+
+// GetSnapshots returns the snapshots from the last days days, oldest first
+func (db *Database) GetSnapshots(days int) ([]StatsSnapshot, error) {
+    rows, err := db.Query(`
+        SELECT snapshot_date, total, active, cancelled, suspended, lapsed, anonymous, taken_at
+        FROM stats_snapshots
+        WHERE snapshot_date > CURRENT_DATE - $1::integer
+        ORDER BY snapshot_date
+    `, days)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    snapshots := []StatsSnapshot{}
+    for rows.Next() {
+        var s StatsSnapshot
+        var date time.Time
+        err := rows.Scan(&date, &s.Total, &s.Active, &s.Cancelled, &s.Suspended, &s.Lapsed, &s.Anonymous, &s.TakenAt)
+        if err != nil {
+            return nil, err
+        }
+        s.Date = date.Format("2006-01-02")
+        snapshots = append(snapshots, s)
+    }
+
+    return snapshots, rows.Err()
+}
+
+// snapshotJob keeps a daily snapshot in server mode
+func (s *WebhookServer) snapshotJob() *Job {
+    return &Job{
+        Name:     jobSnapshot,
+        Interval: snapshotCheckInterval,
+        AtStart:  true,
+        run: func() (string, error) {
+            snapshot, err := s.db.TakeSnapshot()
+            if err != nil {
+                return "", err
+            }
+            return fmt.Sprintf("%s: %d active of %d", snapshot.Date, snapshot.Active, snapshot.Total), nil
+        },
+    }
+}
+
+// statsHistoryHandler returns daily snapshots for ?days=N (default 90)
+func (s *WebhookServer) statsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+    days := defaultHistoryDays
+    if value := r.URL.Query().Get("days"); value != "" {
+        n, err := strconv.Atoi(value)
+        if err != nil || n < 1 || n > maxHistoryDays {
+            writeError(w, r, http.StatusBadRequest, errInvalidPayload, fmt.Sprintf("days must be between 1 and %d", maxHistoryDays))
+            return
+        }
+        days = n
+    }
+
+    snapshots, err := s.db.GetSnapshots(days)
+    if err != nil {
+        s.logger.Printf("Error getting stats history: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(snapshots)
+}
+
+// sparkBlocks draws a sparkline from lowest to highest
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline scales values between their minimum and maximum
+func sparkline(values []int) string {
+    if len(values) == 0 {
+        return ""
+    }
+
+    low, high := values[0], values[0]
+    for _, v := range values {
+        low = min(low, v)
+        high = max(high, v)
+    }
+
+    var b strings.Builder
+    for _, v := range values {
+        i := 0
+        if high > low {
+            i = (v - low) * (len(sparkBlocks) - 1) / (high - low)
+        }
+        b.WriteRune(sparkBlocks[i])
+    }
+    return b.String()
+}
+
+// printStatsHistory prints the snapshot table for stats --history
+func printStatsHistory(db *Database, days int, logger *log.Logger) {
+    snapshots, err := db.GetSnapshots(days)
+    if err != nil {
+        logger.Fatalf("Failed to get stats history: %v", err)
+    }
+
+    if len(snapshots) == 0 {
+        fmt.Println("No snapshots recorded (run: memberships snapshot)")
+        return
+    }
+
+    active := make([]int, len(snapshots))
+    total := make([]int, len(snapshots))
+    for i, s := range snapshots {
+        active[i] = s.Active
+        total[i] = s.Total
+    }
+
+    fmt.Printf("\n=== Membership History (last %d days) ===\n", days)
+    fmt.Printf("Active: %s\n", sparkline(active))
+    fmt.Printf("Total:  %s\n\n", sparkline(total))
+
+    fmt.Printf("%-10s %7s %7s %9s %9s %7s %9s\n", "Date", "Total", "Active", "Cancelled", "Suspended", "Lapsed", "Anonymous")
+    for _, s := range snapshots {
+        fmt.Printf("%-10s %7d %7d %9d %9d %7d %9d\n", s.Date, s.Total, s.Active, s.Cancelled, s.Suspended, s.Lapsed, s.Anonymous)
+    }
+    fmt.Println()
+}
+
+func runSnapshot(logger *log.Logger) {
+    snapshotCmd := flag.NewFlagSet("snapshot", flag.ExitOnError)
+    parseSubcommand(snapshotCmd, "memberships snapshot", os.Args[2:])
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    s, err := db.TakeSnapshot()
+    if err != nil {
+        logger.Fatalf("Snapshot failed: %v", err)
+    }
+
+    fmt.Printf("Snapshot for %s: %d total, %d active, %d cancelled, %d suspended, %d lapsed, %d anonymous\n",
+        s.Date, s.Total, s.Active, s.Cancelled, s.Suspended, s.Lapsed, s.Anonymous)
+}
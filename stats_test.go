@@ -0,0 +1,57 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestAddStatusCount(t *testing.T) {
+    var stats Stats
+    stats.addStatusCount(StatusActive, 3)
+    stats.addStatusCount(StatusActive, 2)
+    stats.addStatusCount("paused", 1)
+    stats.addStatusCount("paused", 1)
+    if stats.ActiveMembers != 5 || stats.OtherStatuses["paused"] != 2 || stats.TotalMembers != 7 {
+        t.Errorf("stats = %+v", stats)
+    }
+}
+
+func TestStatsCountEveryStatus(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    seedMembers(t, db, map[string]string{
+        "active@example.org":    StatusActive,
+        "cancelled@example.org": StatusCancelled,
+        "suspended@example.org": StatusSuspended,
+        "lapsed@example.org":    StatusLapsed,
+        "unknown@example.org":   StatusUnknown,
+        "paused@example.org":    StatusActive,
+    })
+    // A status written by something newer than this build
+    db.member("paused@example.org").Status = "paused"
+
+    var stats Stats
+    resp := do(t, server, "GET", "/stats", "", "")
+    expectStatus(t, resp, http.StatusOK)
+    decode(t, resp, &stats)
+
+    for name, count := range map[string]int{
+        "active":    stats.ActiveMembers,
+        "cancelled": stats.CancelledMembers,
+        "suspended": stats.SuspendedMembers,
+        "lapsed":    stats.LapsedMembers,
+        "unknown":   stats.UnknownStatusMembers,
+        "paused":    stats.OtherStatuses["paused"],
+    } {
+        if count != 1 {
+            t.Errorf("%s = %d, want 1", name, count)
+        }
+    }
+
+    parts := stats.ActiveMembers + stats.CancelledMembers + stats.SuspendedMembers + stats.LapsedMembers + stats.UnknownStatusMembers
+    for _, count := range stats.OtherStatuses {
+        parts += count
+    }
+    if stats.TotalMembers != 6 || parts != stats.TotalMembers {
+        t.Errorf("total_members = %d, parts add up to %d, want 6", stats.TotalMembers, parts)
+    }
+}
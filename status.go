@@ -0,0 +1,110 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Member statuses
+const (
+    StatusActive    = "active"
+    StatusCancelled = "cancelled"
+    StatusSuspended = "suspended"
+    StatusLapsed    = "lapsed"
+
+    // StatusUnknown holds a member whose last payment status matched no
+    // rule, when STRICT_STATUS is off. It never verifies as active.
+    StatusUnknown = "unknown"
+)
+
+// defaultFailedPaymentLimit is how many failed payments in a row cancel a
+// member when FAILED_PAYMENT_LIMIT is unset
+const defaultFailedPaymentLimit = 3
+
+// ErrUnknownStatus is returned for a status that isn't a member status
+var ErrUnknownStatus = errors.New("unknown status")
+
+// ErrStaleEvent is returned for a webhook event older than the last one
+// applied to the member; Zapier doesn't guarantee delivery order
+var ErrStaleEvent = errors.New("stale event")
+
+// skipReasonStale is the webhook log skip_reason for an ErrStaleEvent
+const skipReasonStale = "stale"
+
+// ErrInvalidTransition is returned when a change isn't in statusTransitions
+// and STATUS_TRANSITIONS is "reject"
+var ErrInvalidTransition = errors.New("status transition not allowed")
+
+// statusTransitions lists where each status may move on its own, from
+// webhooks and the lapse job. A cancelled member only comes back through an
+// explicit reactivation (see explicitChange).
+var statusTransitions = map[string][]string{
+    StatusActive:    {StatusSuspended, StatusCancelled, StatusLapsed, StatusUnknown},
+    StatusSuspended: {StatusActive, StatusCancelled, StatusLapsed, StatusUnknown},
+    StatusLapsed:    {StatusActive, StatusSuspended, StatusCancelled, StatusUnknown},
+    StatusUnknown:   {StatusActive, StatusSuspended, StatusCancelled, StatusLapsed},
+    StatusCancelled: {},
+}
+
+// validStatus reports whether status is a known member status
+func validStatus(status string) bool {
+    _, ok := statusTransitions[status]
+    return ok
+}
+
+// explicitChange reports whether a change was made deliberately by an
+// operator or a reviewed clean run, which may make any transition
+func explicitChange(change ChangeSource) bool {
+    switch change.Source {
+    case "manual", "clean", "undo", "merge", "restore", "import", "review":
+        return true
+    }
+    return false
+}
+
+// transitionError reports why moving a member from one status to another
+// isn't allowed: an ErrUnknownStatus for a status that doesn't exist, or an
+// ErrInvalidTransition for one statusTransitions doesn't list
+func transitionError(email, from, to string, change ChangeSource) error {
+    if !validStatus(to) {
+        return fmt.Errorf("%w: %q", ErrUnknownStatus, to)
+    }
+    if from == to || explicitChange(change) {
+        return nil
+    }
+    for _, allowed := range statusTransitions[from] {
+        if allowed == to {
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: %s from %s to %s by %s", ErrInvalidTransition, email, from, to, change.Source)
+}
+
+// checkTransition validates moving a member from one status to another. A
+// disallowed transition is an error when StrictTransitions is set, and
+// otherwise is logged and allowed.
+func (db *Database) checkTransition(email, from, to string, change ChangeSource) error {
+    err := transitionError(email, from, to, change)
+    if err == nil || !errors.Is(err, ErrInvalidTransition) || db.StrictTransitions {
+        return err
+    }
+    db.logger.Printf("Warning: %v; allowing it (STATUS_TRANSITIONS=warn)", err)
+    return nil
+}
+
+// parseEventTime reads a webhook's event_time, as RFC 3339 or Unix seconds
+func parseEventTime(value string) (time.Time, error) {
+    value = strings.TrimSpace(value)
+    if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+        return time.Unix(seconds, 0), nil
+    }
+    t, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("event_time must be RFC 3339 or Unix seconds, got %q", value)
+    }
+    return t, nil
+}
+
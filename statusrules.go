@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// statusFailed is a rule target for a failed charge: the member is suspended
+// and cancelled after FailedPaymentLimit failures in a row
+const statusFailed = "failed"
+
+// StatusRule maps payment statuses containing Pattern (case-insensitively)
+// to a member status or statusFailed
+type StatusRule struct {
+    Pattern string `json:"pattern"`
+    Status  string `json:"status"`
+}
+
+// defaultStatusRules are checked top-down, so the refund and dispute rules
+// come before "succeed": "Chargeback dispute succeeded (refund)" is not an
+// activation.
+var defaultStatusRules = []StatusRule{
+    {"chargeback", StatusCancelled},
+    {"dispute", StatusCancelled},
+    {"refund", StatusCancelled},
+    {"cancel", StatusCancelled},
+    {"inactive", StatusCancelled},
+    {"fail", statusFailed},
+    {"suspend", StatusSuspended},
+    {"pend", StatusSuspended},
+    {"succeed", StatusActive},
+    {"success", StatusActive},
+    {"active", StatusActive},
+}
+
+// matchStatusRule returns the first rule matching a payment status. A status
+// no rule matches as sent is tried again without accents, then translated
+// from Spanish or French (see localizedStatuses), so "Exitoso" and "Réussi"
+// match the rule for "succeed".
+func matchStatusRule(rules []StatusRule, paymentStatus string) (StatusRule, bool) {
+    candidates := []string{strings.ToLower(paymentStatus), foldText(paymentStatus)}
+    if english := localizeStatus(paymentStatus); english != "" {
+        candidates = append(candidates, english)
+    }
+
+    for _, candidate := range candidates {
+        for _, rule := range rules {
+            if strings.Contains(candidate, rule.Pattern) {
+                return rule, true
+            }
+        }
+    }
+    return StatusRule{}, false
+}
+
+// parseStatusRules reads STATUS_RULES, either a JSON array of
+// {"pattern", "status"} objects or comma-separated pattern=status pairs
+func parseStatusRules(value string) ([]StatusRule, error) {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return defaultStatusRules, nil
+    }
+
+    var rules []StatusRule
+    if strings.HasPrefix(value, "[") {
+        if err := json.Unmarshal([]byte(value), &rules); err != nil {
+            return nil, fmt.Errorf("invalid JSON: %w", err)
+        }
+    } else {
+        for _, pair := range strings.Split(value, ",") {
+            pattern, status, ok := strings.Cut(pair, "=")
+            if !ok {
+                return nil, fmt.Errorf("%q is not pattern=status", strings.TrimSpace(pair))
+            }
+            rules = append(rules, StatusRule{Pattern: pattern, Status: status})
+        }
+    }
+
+    return validateStatusRules(rules)
+}
+
+// loadStatusRules reads a JSON rule list from a file
+func loadStatusRules(path string) ([]StatusRule, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    var rules []StatusRule
+    if err := json.Unmarshal(data, &rules); err != nil {
+        return nil, fmt.Errorf("invalid JSON in %s: %w", path, err)
+    }
+
+    return validateStatusRules(rules)
+}
+
+// validateStatusRules normalizes rules and checks every target is a status
+func validateStatusRules(rules []StatusRule) ([]StatusRule, error) {
+    if len(rules) == 0 {
+        return nil, fmt.Errorf("no rules given")
+    }
+
+    for i := range rules {
+        rules[i].Pattern = strings.ToLower(strings.TrimSpace(rules[i].Pattern))
+        rules[i].Status = strings.ToLower(strings.TrimSpace(rules[i].Status))
+        if rules[i].Pattern == "" {
+            return nil, fmt.Errorf("rule %d has an empty pattern", i+1)
+        }
+        if rules[i].Status != statusFailed && !validStatus(rules[i].Status) {
+            return nil, fmt.Errorf("rule %q: unknown status %q", rules[i].Pattern, rules[i].Status)
+        }
+    }
+
+    return rules, nil
+}
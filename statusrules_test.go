@@ -0,0 +1,146 @@
+package main
+
+import (
+    "io"
+    "log"
+    "net/http"
+    "testing"
+)
+
+func TestMatchStatusRule(t *testing.T) {
+    tests := []struct {
+        paymentStatus string
+        want          string // "" for no match
+    }{
+        // Plain statuses
+        {"Succeeded", StatusActive},
+        {"Success", StatusActive},
+        {"Active", StatusActive},
+        {"Cancelled", StatusCancelled},
+        {"Failed", statusFailed},
+        {"Pending", StatusSuspended},
+        {"Suspended", StatusSuspended},
+
+        // Chargebacks, disputes, and refunds that also say they succeeded
+        {"Chargeback dispute succeeded (refund)", StatusCancelled},
+        {"CHARGEBACK SUCCEEDED", StatusCancelled},
+        {"Dispute resolved: payment succeeded", StatusCancelled},
+        {"Refund succeeded", StatusCancelled},
+        {"Successful refund", StatusCancelled},
+        {"Partially refunded, original charge succeeded", StatusCancelled},
+
+        // Words that contain a later rule's pattern
+        {"Inactive", StatusCancelled},
+        {"Payment failed; retry pending", statusFailed},
+        {"Failed after success", statusFailed},
+        {"Pending success", StatusSuspended},
+        {"Suspended (was active)", StatusSuspended},
+
+        // Spanish and French, where the same ordering applies
+        {"Exitoso", StatusActive},
+        {"Réussi", StatusActive},
+        {"Contracargo exitoso", StatusCancelled},
+        {"Remboursement réussi", StatusCancelled},
+        {"Reembolso completado", StatusCancelled},
+        {"Impayé", statusFailed},
+
+        // Nothing matches
+        {"Processing", ""},
+        {"", ""},
+    }
+    for _, tt := range tests {
+        rule, ok := matchStatusRule(defaultStatusRules, tt.paymentStatus)
+        if ok != (tt.want != "") || rule.Status != tt.want {
+            t.Errorf("matchStatusRule(%q) = %q, %v; want %q", tt.paymentStatus, rule.Status, ok, tt.want)
+        }
+    }
+}
+
+func TestStatusRulesOrder(t *testing.T) {
+    // The same two rules, the other way round, turn a chargeback into an
+    // activation: order is what keeps ambiguous statuses safe
+    rules, err := parseStatusRules("succeed=active,chargeback=cancelled")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rule, _ := matchStatusRule(rules, "Chargeback dispute succeeded"); rule.Status != StatusActive {
+        t.Errorf("succeed first: %q", rule.Status)
+    }
+
+    rules, err = parseStatusRules(`[{"pattern": "Chargeback", "status": "Cancelled"}, {"pattern": "succeed", "status": "active"}]`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rule, _ := matchStatusRule(rules, "Chargeback dispute succeeded"); rule.Status != StatusCancelled {
+        t.Errorf("chargeback first: %q", rule.Status)
+    }
+}
+
+func TestParseStatusRules(t *testing.T) {
+    rules, err := parseStatusRules("")
+    if err != nil || len(rules) != len(defaultStatusRules) {
+        t.Errorf("empty = %d rules, %v; want the defaults", len(rules), err)
+    }
+
+    rules, err = parseStatusRules(" Declined = Failed , on hold=suspended")
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []StatusRule{{"declined", statusFailed}, {"on hold", StatusSuspended}}
+    if len(rules) != len(want) || rules[0] != want[0] || rules[1] != want[1] {
+        t.Errorf("rules = %+v, want %+v", rules, want)
+    }
+
+    for _, value := range []string{
+        "declined",
+        "declined=",
+        "=cancelled",
+        "declined=bogus",
+        `[{"pattern": "declined", "status": "failed"}`,
+        `[]`,
+    } {
+        if _, err := parseStatusRules(value); err == nil {
+            t.Errorf("parseStatusRules(%q) succeeded", value)
+        }
+    }
+}
+
+func TestMapPaymentStatusFallback(t *testing.T) {
+    config := testConfig()
+    s := NewWebhookServer(newMemStore(), config, log.New(io.Discard, "", 0))
+
+    if p := s.mapPaymentStatus("Chargeback dispute succeeded (refund)"); p.status != StatusCancelled || !p.mapped {
+        t.Errorf("chargeback = %+v", p)
+    }
+    if p := s.mapPaymentStatus("Processing"); p.status != StatusUnknown || p.mapped {
+        t.Errorf("unmatched = %+v, want the unknown fallback", p)
+    }
+
+    config.StatusFallback = statusFailed
+    if p := s.mapPaymentStatus("Processing"); p.status != StatusSuspended || !p.failed || p.mapped {
+        t.Errorf("failed fallback = %+v", p)
+    }
+
+    config.StrictStatus = true
+    if p := s.mapPaymentStatus("Processing"); p.status != "" || p.mapped {
+        t.Errorf("strict = %+v, want no status", p)
+    }
+}
+
+func TestWebhookChargebackCancels(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    seedMembers(t, db, map[string]string{"ada@example.org": StatusActive})
+
+    resp := postWebhook(t, server, `{"email":"ada@example.org","status":"Chargeback dispute succeeded (refund)"}`)
+    expectStatus(t, resp, http.StatusOK)
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusCancelled {
+        t.Errorf("status = %s, want cancelled", status)
+    }
+
+    // A new member whose first payment is charged back never becomes active
+    resp = postWebhook(t, server, `{"email":"grace@example.org","status":"Chargeback succeeded"}`)
+    expectStatus(t, resp, http.StatusCreated)
+    if status, _, _ := db.GetMemberStatus("grace@example.org"); status != StatusCancelled {
+        t.Errorf("status = %s, want cancelled", status)
+    }
+}
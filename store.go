@@ -0,0 +1,155 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+)
+
+// Store is the membership data the server, clean, and the sync jobs depend
+// on. *Database is the Postgres implementation.
+type Store interface {
+    HealthCheck() error
+    NormalizeEmail(email string) string
+    EventHub() *EventHub
+
+    // Members
+    ProcessMember(email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error)
+    ProcessFailedPayment(email, name string, isAnonymous bool, limit int, change ChangeSource) (*ProcessResult, error)
+    PreviewMember(email string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error)
+    UpdateMemberStatus(email, status string, change ChangeSource) error
+    SetMemberStatuses(emails []string, status string, change ChangeSource) ([]BulkStatusResult, error)
+    GetMemberStatus(email string) (status string, existed bool, err error)
+    GetMemberStatusByHash(hash string) (status string, existed bool, err error)
+    GetMemberByEmail(email string) (*Member, error)
+    GetMemberByPublicID(publicID string) (*Member, error)
+    GetPublicID(email string) (string, error)
+    GetMembers(filter MemberFilter) ([]Member, error)
+    EachMember(filter MemberFilter, fn func(Member) error) error
+    GetSyncMembers() ([]SyncMember, error)
+    GetStatusHistory(email string, limit int) ([]StatusChange, error)
+    UpdateMemberAnnotations(email string, notes *string, tags []string) error
+    UpdateMemberFields(email string, update MemberUpdate) error
+    AddMemberTag(email, tag string) error
+    SetEmailConsent(email string, optIn bool, change ChangeSource) (bool, error)
+    Unsubscribe(token string) error
+    IsProtected(email string) (bool, error)
+    SetMemberFrequency(email, frequency string) error
+    SetMemberCampaign(email, campaign string) error
+    SetDiscordID(email, discordID string) error
+    MergeMembers(fromEmail, toEmail string) (*MergeResult, error)
+    PreviewMerge(fromEmail, toEmail string) (*MergeResult, error)
+    ForgetMember(email string) (*ForgetResult, error)
+    SubjectAccess(ref string, includeNotes bool) (*SubjectAccessExport, error)
+    LapseMembers(graceDays int, dryRun bool) ([]LapseCandidate, error)
+    LinkHousehold(primaryEmail, secondaryEmail, name, by string) (*HouseholdLink, error)
+    UnlinkHousehold(email, by string) (*HouseholdLink, error)
+    HouseholdPrimary(email string) (primary string, linked bool, err error)
+
+    // Organizations
+    CreateOrganization(name, tier, status, by string) (*Organization, error)
+    SetOrganizationStatus(ref, status string, change ChangeSource) (*OrganizationChange, error)
+    AttachOrganizationMember(ref, email, name, by string) (*OrganizationChange, error)
+    DetachOrganizationMember(ref, email, by string) (*OrganizationChange, error)
+    ProcessOrganization(name, email, contactName, status string, change ChangeSource) (*OrganizationChange, error)
+    GetOrganization(ref string) (*Organization, error)
+    GetOrganizations() ([]Organization, error)
+    MemberOrganization(email string, statuses []string) (name string, found bool, err error)
+    MemberOrganizationByHash(hash string, statuses []string) (name string, found bool, err error)
+
+    // Payments
+    RecordPayment(email string, paidAt time.Time) error
+    FailedPaymentCount(email string) (int, error)
+    RecordDonation(d Donation) error
+    GetDonations(email string, limit int) ([]Donation, error)
+    GetDonationTotals(email string) ([]DonationTotal, error)
+    MarkDonationRefunded(externalID string, refundedAt time.Time) (bool, error)
+
+    // Clean and scheduled syncs
+    GetAllMemberStatuses() (map[string]string, error)
+    GetEmailsWithTag(tag string) (map[string]bool, error)
+    GetHouseholdMembers() (map[string]bool, error)
+    GetLastActivityTimes() (map[string]time.Time, error)
+    ApplySyncChanges(changes SyncChanges, progress func()) (int, error)
+    SyncRunApplied(inputFile string) (bool, error)
+    RecordFailedSyncRun(inputFile string) error
+    WriteBackup(path string, includeWebhooks bool) (*BackupResult, error)
+
+    // Changes queued for review
+    QueuePendingChanges(changes []PendingChange, expiresAt *time.Time) error
+    GetPendingChanges(state string, limit int) ([]PendingChange, error)
+    ApprovePendingChanges(ids []int, by string, maxDeactivatePercent int) (*ReviewResult, error)
+    RejectPendingChanges(ids []int, by, reason string) error
+
+    // Webhook log and retry queue
+    LogWebhook(email, status, source string, payload json.RawMessage) (int, error)
+    LogDryRunWebhook(email, status, source string, payload json.RawMessage) (int, error)
+    QueueWebhookRetry(logID int, cause error) error
+    SkipWebhook(logID int, reason string) error
+    DueWebhookRetries(limit int) ([]WebhookLogEntry, error)
+    GetWebhookLogsByState(state, source string, limit int) ([]WebhookLogEntry, error)
+    LatestWebhookLogID() int
+    RecordWebhookAttempt(logID int, attempt WebhookAttempt) error
+    DeferWebhook(logID int) error
+    ReleaseDeferredWebhooks() (int, error)
+
+    // Failed webhook review
+    RecordFailedWebhook(f FailedWebhook) (int, error)
+    GetFailedWebhooks(state string, limit int) ([]FailedWebhook, error)
+    ResolveFailedWebhook(id int, state, by, note string) error
+    UpdateFailedWebhookError(id int, class, message string) error
+    GetOpenFailuresOfClass(class string) ([]FailedWebhook, error)
+    ResolveFailuresForLog(logID int, by string) error
+
+    // Payment statuses and frequencies no rule matches
+    RecordUnmappedValue(kind, value string, seen int) error
+    StatusMapping(paymentStatus string) (string, error)
+    GetUnmappedStatuses(all bool) ([]UnmappedStatus, error)
+    MapUnmappedStatus(paymentStatus, target, by string) error
+    LatestDonations() (map[string]Donation, error)
+    QueueMemberEmail(email, kind, transition string) (bool, error)
+    DueMemberEmails(limit int) ([]MemberEmail, error)
+    RecordMemberEmailAttempt(id int, state string, next time.Time, cause string) error
+
+    // Maintenance mode
+    MaintenanceMode() (bool, error)
+    SetMaintenanceMode(on bool) error
+
+    // Background jobs
+    LockJob(name string) (func(), bool, error)
+    RecordJobRun(run *JobRun) error
+    LastJobRuns() (map[string]JobRun, error)
+
+    // Stats and the events feed
+    GetStats(ctx context.Context) (*Stats, error)
+    GetRevenueStats(ctx context.Context) ([]RevenueStats, error)
+    GetCampaignStats(ctx context.Context) ([]CampaignStats, error)
+    GetChangeToken() (string, error)
+    TakeSnapshot() (*StatsSnapshot, error)
+    GetSnapshots(days int) ([]StatsSnapshot, error)
+    GetRetention(months int) ([]RetentionCohort, error)
+    GetAnniversaryMembers() ([]Anniversary, error)
+    GetDonationMilestones(since time.Time) ([]Anniversary, error)
+    GetEvents(since int64, types []string, limit int) ([]FeedEvent, error)
+    LatestEventID() (int64, error)
+
+    // Outbound webhook subscriptions
+    ListSubscriptions(activeOnly bool) ([]Subscription, error)
+    CreateSubscription(url, secret string, events []string) (*Subscription, error)
+    DeleteSubscription(id int) error
+
+    // Access log and pruning
+    RecordAccess(entries []AccessLogEntry) error
+    GetAccessLogs(since time.Time, limit int) ([]AccessLogEntry, error)
+    PruneOlderThan(target pruneTarget, dryRun bool) (int, error)
+
+    // Daily digest
+    BuildDigest(start, end time.Time) (*Digest, error)
+    LastDigestDate() (string, error)
+    RecordDigestSent(date string) error
+}
+
+// EventHub returns the hub woken when feed events commit, which may be nil
+func (db *Database) EventHub() *EventHub {
+    return db.Events
+}
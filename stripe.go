@@ -0,0 +1,273 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// stripeAPI is the base URL of the Stripe API
+const stripeAPI = "https://api.stripe.com/v1"
+
+// Paging and rate limit handling for the Stripe API
+const (
+    stripePageSize   = 100
+    stripeMaxRetries = 5
+    stripeBaseDelay  = time.Second
+)
+
+// StripeClient reads subscriptions with a restricted or secret API key
+type StripeClient struct {
+    apiKey string
+    client *http.Client
+    logger *log.Logger
+}
+
+// NewStripeClient builds a client from STRIPE_API_KEY
+func NewStripeClient(apiKey string, logger *log.Logger) (*StripeClient, error) {
+    if apiKey == "" {
+        return nil, fmt.Errorf("STRIPE_API_KEY is required")
+    }
+    return &StripeClient{apiKey: apiKey, client: &http.Client{Timeout: 30 * time.Second}, logger: logger}, nil
+}
+
+// stripeSubscription is the subset of a subscription we read
+type stripeSubscription struct {
+    ID       string `json:"id"`
+    Status   string `json:"status"`
+    Customer struct {
+        Email   string `json:"email"`
+        Deleted bool   `json:"deleted"`
+    } `json:"customer"`
+    Items struct {
+        Data []struct {
+            Price struct {
+                Recurring struct {
+                    Interval      string `json:"interval"`
+                    IntervalCount int    `json:"interval_count"`
+                } `json:"recurring"`
+            } `json:"price"`
+        } `json:"data"`
+    } `json:"items"`
+}
+
+// frequency describes the billing interval the way GiveLively does
+func (s stripeSubscription) frequency() string {
+    if len(s.Items.Data) == 0 {
+        return ""
+    }
+    recurring := s.Items.Data[0].Price.Recurring
+    switch {
+    case recurring.Interval == "month" && recurring.IntervalCount == 3:
+        return "Quarterly"
+    case recurring.Interval == "month":
+        return "Monthly"
+    case recurring.Interval == "year":
+        return "Annual"
+    }
+    return ""
+}
+
+// get fetches one API page, backing off on 429 and 5xx responses
+func (c *StripeClient) get(path string, query url.Values, out interface{}) error {
+    delay := stripeBaseDelay
+    for attempt := 0; ; attempt++ {
+        req, err := http.NewRequest(http.MethodGet, stripeAPI+path+"?"+query.Encode(), nil)
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+        resp, err := c.client.Do(req)
+        if err != nil {
+            return fmt.Errorf("stripe %s: %w", path, err)
+        }
+        body, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            return fmt.Errorf("stripe %s: %w", path, err)
+        }
+
+        if (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) && attempt < stripeMaxRetries {
+            wait := delay
+            if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+                wait = time.Duration(seconds) * time.Second
+            }
+            c.logger.Printf("Stripe returned %s, retrying in %v", resp.Status, wait)
+            time.Sleep(wait)
+            delay *= 2
+            continue
+        }
+
+        if resp.StatusCode != http.StatusOK {
+            var apiErr struct {
+                Error struct {
+                    Message string `json:"message"`
+                } `json:"error"`
+            }
+            json.Unmarshal(body, &apiErr)
+            return fmt.Errorf("stripe %s: %s: %s", path, resp.Status, apiErr.Error.Message)
+        }
+
+        return json.Unmarshal(body, out)
+    }
+}
+
+// Subscriptions pages through every subscription with its customer expanded
+func (c *StripeClient) Subscriptions(onPage func(count int)) ([]stripeSubscription, error) {
+    var all []stripeSubscription
+
+    query := url.Values{}
+    query.Set("status", "all")
+    query.Set("limit", strconv.Itoa(stripePageSize))
+    query.Add("expand[]", "data.customer")
+
+    for {
+        var page struct {
+            Data    []stripeSubscription `json:"data"`
+            HasMore bool                 `json:"has_more"`
+        }
+        if err := c.get("/subscriptions", query, &page); err != nil {
+            return nil, err
+        }
+
+        all = append(all, page.Data...)
+        if onPage != nil {
+            onPage(len(all))
+        }
+
+        if !page.HasMore || len(page.Data) == 0 {
+            return all, nil
+        }
+        query.Set("starting_after", page.Data[len(page.Data)-1].ID)
+    }
+}
+
+// stripeMemberSource maps Stripe subscriptions onto a MemberSource. Active
+// and trialing subscriptions are active, past_due and unpaid suspend, and
+// canceled ones count as absent so the member is cancelled. Customers with
+// no usable email are skipped.
+func stripeMemberSource(db *Database, subs []stripeSubscription) (*MemberSource, int) {
+    source := &MemberSource{
+        Name:         "stripe",
+        Active:       make(map[string]bool),
+        Failed:       make(map[string]bool),
+        PaymentDates: make(map[string]time.Time),
+        Frequencies:  make(map[string]string),
+    }
+
+    missingEmail := 0
+    for _, sub := range subs {
+        source.RowsProcessed++
+
+        if sub.Customer.Deleted || strings.TrimSpace(sub.Customer.Email) == "" || validateEmail(sub.Customer.Email) != nil {
+            missingEmail++
+            continue
+        }
+        email := db.NormalizeEmail(sub.Customer.Email)
+
+        switch sub.Status {
+        case "active", "trialing":
+            source.Active[email] = true
+            if freq := sub.frequency(); freq != "" {
+                source.Frequencies[email] = freq
+            }
+        case "past_due", "unpaid":
+            source.Failed[email] = true
+        }
+    }
+
+    // A customer with any live subscription is active
+    for email := range source.Active {
+        delete(source.Failed, email)
+    }
+
+    source.RowsSkipped = missingEmail
+    return source, missingEmail
+}
+
+// runReconcile dispatches "memberships reconcile <source>"
+func runReconcile(logger *log.Logger) {
+    usage := "Usage: memberships reconcile stripe [--dry-run|--review]"
+    if len(os.Args) < 3 || os.Args[2] != "stripe" {
+        fmt.Fprintln(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    stripeCmd := flag.NewFlagSet("reconcile stripe", flag.ExitOnError)
+    dryRun := stripeCmd.Bool("dry-run", false, "Show what would change without making changes")
+    verbose := stripeCmd.Bool("verbose", false, "Show detailed output")
+    graceDays := stripeCmd.Int("grace-days", 0, "Only deactivate members whose last payment or update is older than N days")
+    maxDeactivate := stripeCmd.Int("max-deactivate-percent", defaultMaxDeactivatePercent, "Refuse to deactivate more than this percentage of active members")
+    force := stripeCmd.Bool("force", false, "Apply changes even if they exceed --max-deactivate-percent")
+    noAdd := stripeCmd.Bool("no-add", false, "Report but don't add new members")
+    noReactivate := stripeCmd.Bool("no-reactivate", false, "Report but don't reactivate members")
+    noDeactivate := stripeCmd.Bool("no-deactivate", false, "Report but don't deactivate or suspend members")
+    reportFile := stripeCmd.String("report", "", "Write a change report to this file")
+    reportFormat := stripeCmd.String("report-format", "json", "Report format: json or csv")
+    review := stripeCmd.Bool("review", false, "Queue changes for approval (see memberships pending) instead of applying them")
+
+    parseSubcommand(stripeCmd, "memberships reconcile stripe [--dry-run] [flags]", os.Args[3:])
+
+    config := mustLoadConfig(logger)
+    client, err := NewStripeClient(config.StripeAPIKey, logger)
+    if err != nil {
+        logger.Fatalf("Stripe reconcile failed: %v", err)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if *dryRun {
+        logger.Println("DRY RUN MODE - No changes will be made")
+    } else if *review {
+        logger.Println("REVIEW MODE - Changes will be queued for approval")
+    }
+
+    // Fetch everything before touching the database
+    logger.Println("Fetching Stripe subscriptions...")
+    subs, err := client.Subscriptions(func(count int) {
+        logger.Printf("Fetched %d subscriptions", count)
+    })
+    if err != nil {
+        logger.Fatalf("Stripe reconcile failed: %v", err)
+    }
+
+    source, missingEmail := stripeMemberSource(db, subs)
+    logger.Printf("Stripe has %d active and %d past-due members", len(source.Active), len(source.Failed))
+    if missingEmail > 0 {
+        logger.Printf("Skipped %d subscriptions whose customer has no valid email", missingEmail)
+    }
+
+    report, err := NewReconciler(db, CleanOptions{
+        DryRun:               *dryRun,
+        Verbose:              *verbose,
+        GraceDays:            *graceDays,
+        MaxDeactivatePercent: *maxDeactivate,
+        Force:                *force,
+        NoAdd:                *noAdd,
+        NoReactivate:         *noReactivate,
+        NoDeactivate:         *noDeactivate,
+        Review:               *review,
+        ReviewTTL:            config.PendingChangeTTL,
+        Logger:               logger,
+    }).Run(source)
+    if report != nil && *reportFile != "" {
+        if werr := report.WriteFile(*reportFile, *reportFormat); werr != nil {
+            logger.Printf("Failed to write report: %v", werr)
+        } else {
+            logger.Printf("Wrote %s report to %s", *reportFormat, *reportFile)
+        }
+    }
+    if err != nil {
+        logger.Fatalf("Stripe reconcile failed: %v", err)
+    }
+}
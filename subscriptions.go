@@ -0,0 +1,547 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// Outbound event types a subscription can filter on
+const (
+    outboundMemberCreated       = "member.created"
+    outboundMemberStatusChanged = "member.status_changed"
+    outboundTest                = "test"
+)
+
+// Delivery tuning for outbound webhooks
+const (
+    deliveryTimeout     = 10 * time.Second
+    deliveryAttempts    = 4
+    deliveryBaseDelay   = 2 * time.Second
+    maxDeliveryFailures = 10
+    signatureHeader     = "X-Memberships-Signature"
+)
+
+// ErrSubscriptionNotFound is returned for an unknown subscription ID
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// Subscription is a registered outbound webhook endpoint
+type Subscription struct {
+    ID           int        `json:"id"`
+    URL          string     `json:"url"`
+    Secret       string     `json:"secret,omitempty"`
+    Events       []string   `json:"events"`
+    FailureCount int        `json:"failure_count"`
+    DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+    LastError    string     `json:"last_error,omitempty"`
+    CreatedAt    time.Time  `json:"created_at"`
+}
+
+// wants reports whether the subscription receives an event type; an empty
+// event list means everything
+func (s Subscription) wants(eventType string) bool {
+    if len(s.Events) == 0 || eventType == outboundTest {
+        return true
+    }
+    for _, e := range s.Events {
+        if e == eventType {
+            return true
+        }
+    }
+    return false
+}
+
+// OutboundEvent is the JSON body POSTed to subscribers
+type OutboundEvent struct {
+    Event     string    `json:"event"`
+    MemberID  string    `json:"member_id,omitempty"`
+    Email     string    `json:"email"`
+    OldStatus string    `json:"old_status,omitempty"`
+    NewStatus string    `json:"new_status"`
+    Timestamp time.Time `json:"timestamp"`
+}
+
+// parseOutboundEvents validates a comma-separated list of event types
+func parseOutboundEvents(value string) ([]string, error) {
+    events := []string{}
+    for _, event := range strings.Split(value, ",") {
+        event = strings.TrimSpace(event)
+        switch event {
+        case outboundMemberCreated, outboundMemberStatusChanged:
+            events = append(events, event)
+        case "":
+        default:
+            return nil, fmt.Errorf("unknown event %q (use %s or %s)", event, outboundMemberCreated, outboundMemberStatusChanged)
+        }
+    }
+    return events, nil
+}
+
+// newSubscriptionSecret generates a random signing secret
+func newSubscriptionSecret() (string, error) {
+    buf := make([]byte, 24)
+    if _, err := rand.Read(buf); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(buf), nil
+}
+
+// signPayload returns the signature header value for body
+func signPayload(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateSubscription registers an endpoint, generating a secret if none is given
+func (db *Database) CreateSubscription(url, secret string, events []string) (*Subscription, error) {
+    if !isURL(url) {
+        return nil, fmt.Errorf("subscription URL must be http(s), got %q", url)
+    }
+    if secret == "" {
+        var err error
+        if secret, err = newSubscriptionSecret(); err != nil {
+            return nil, fmt.Errorf("failed to generate secret: %w", err)
+        }
+    }
+    if events == nil {
+        events = []string{}
+    }
+
+    sub := &Subscription{URL: url, Secret: secret, Events: events}
+    err := db.QueryRow(`
+        INSERT INTO subscriptions (url, secret, events) VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `, url, secret, pq.Array(events)).Scan(&sub.ID, &sub.CreatedAt)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create subscription: %w", err)
+    }
+
+    return sub, nil
+}
+
+// DeleteSubscription removes an endpoint
+func (db *Database) DeleteSubscription(id int) error {
+    result, err := db.Exec(`DELETE FROM subscriptions WHERE id = $1`, id)
+    if err != nil {
+        return fmt.Errorf("failed to delete subscription: %w", err)
+    }
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+    }
+    return nil
+}
+
+// EnableSubscription re-enables a disabled endpoint and resets its failures
+func (db *Database) EnableSubscription(id int) error {
+    result, err := db.Exec(`
+        UPDATE subscriptions SET disabled_at = NULL, failure_count = 0 WHERE id = $1
+    `, id)
+    if err != nil {
+        return fmt.Errorf("failed to enable subscription: %w", err)
+    }
+    if rows, _ := result.RowsAffected(); rows == 0 {
+        return fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+    }
+    return nil
+}
+
+// ListSubscriptions returns all endpoints; activeOnly skips disabled ones
+func (db *Database) ListSubscriptions(activeOnly bool) ([]Subscription, error) {
+    query := `
+        SELECT id, url, secret, events, failure_count, disabled_at, COALESCE(last_error, ''), created_at
+        FROM subscriptions
+    `
+    if activeOnly {
+        query += ` WHERE disabled_at IS NULL`
+    }
+    query += ` ORDER BY id`
+
+    rows, err := db.Query(query)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+    }
+    defer rows.Close()
+
+    var subs []Subscription
+    for rows.Next() {
+        var sub Subscription
+        var disabledAt sql.NullTime
+        err := rows.Scan(&sub.ID, &sub.URL, &sub.Secret, pq.Array(&sub.Events), &sub.FailureCount,
+            &disabledAt, &sub.LastError, &sub.CreatedAt)
+        if err != nil {
+            return nil, err
+        }
+        if disabledAt.Valid {
+            sub.DisabledAt = &disabledAt.Time
+        }
+        subs = append(subs, sub)
+    }
+
+    return subs, rows.Err()
+}
+
+// GetSubscription returns one endpoint
+func (db *Database) GetSubscription(id int) (*Subscription, error) {
+    subs, err := db.ListSubscriptions(false)
+    if err != nil {
+        return nil, err
+    }
+    for _, sub := range subs {
+        if sub.ID == id {
+            return &sub, nil
+        }
+    }
+    return nil, fmt.Errorf("%w: %d", ErrSubscriptionNotFound, id)
+}
+
+// recordDelivery resets the failure counter on success, or counts a failure
+// and disables the endpoint once it has failed too many times in a row
+func (db *Database) recordDelivery(id int, deliveryErr error) error {
+    if deliveryErr == nil {
+        _, err := db.Exec(`UPDATE subscriptions SET failure_count = 0, last_error = NULL WHERE id = $1`, id)
+        return err
+    }
+
+    _, err := db.Exec(`
+        UPDATE subscriptions SET
+            failure_count = failure_count + 1,
+            last_error = $2,
+            disabled_at = CASE WHEN failure_count + 1 >= $3 THEN CURRENT_TIMESTAMP ELSE disabled_at END
+        WHERE id = $1
+    `, id, deliveryErr.Error(), maxDeliveryFailures)
+    return err
+}
+
+// Dispatcher delivers outbound events to subscriptions in the background
+type Dispatcher struct {
+    db     *Database
+    client *http.Client
+    logger *log.Logger
+    wg     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher backed by the subscriptions table
+func NewDispatcher(db *Database, logger *log.Logger) *Dispatcher {
+    return &Dispatcher{
+        db:     db,
+        client: &http.Client{Timeout: deliveryTimeout},
+        logger: logger,
+    }
+}
+
+// StatusChanged queues delivery of a status change to every interested
+// subscription; previous is empty for newly created members
+func (d *Dispatcher) StatusChanged(email, previous, status string) {
+    eventType := outboundMemberStatusChanged
+    if previous == "" {
+        eventType = outboundMemberCreated
+    }
+
+    publicID, err := d.db.GetPublicID(email)
+    if err != nil {
+        d.logger.Printf("Failed to look up member id for %s: %v", email, err)
+    }
+
+    d.Dispatch(OutboundEvent{
+        Event:     eventType,
+        MemberID:  publicID,
+        Email:     email,
+        OldStatus: previous,
+        NewStatus: status,
+        Timestamp: time.Now().UTC(),
+    })
+}
+
+// Dispatch delivers event asynchronously to every active subscription that wants it
+func (d *Dispatcher) Dispatch(event OutboundEvent) {
+    subs, err := d.db.ListSubscriptions(true)
+    if err != nil {
+        d.logger.Printf("Warning: Failed to load subscriptions: %v", err)
+        return
+    }
+
+    for _, sub := range subs {
+        if !sub.wants(event.Event) {
+            continue
+        }
+
+        d.wg.Add(1)
+        go func(sub Subscription) {
+            defer d.wg.Done()
+            err := d.deliver(sub, event)
+            if err != nil {
+                d.logger.Printf("Warning: Delivery of %s to subscription %d failed: %v", event.Event, sub.ID, err)
+            }
+            if rerr := d.db.recordDelivery(sub.ID, err); rerr != nil {
+                d.logger.Printf("Warning: Failed to record delivery for subscription %d: %v", sub.ID, rerr)
+            }
+        }(sub)
+    }
+}
+
+// Wait blocks until queued deliveries finish; CLI commands call it before exiting
+func (d *Dispatcher) Wait() {
+    d.wg.Wait()
+}
+
+// deliver POSTs the signed event, retrying with exponential backoff
+func (d *Dispatcher) deliver(sub Subscription, event OutboundEvent) error {
+    body, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+
+    delay := deliveryBaseDelay
+    for attempt := 1; ; attempt++ {
+        err = d.post(sub, body)
+        if err == nil || attempt == deliveryAttempts {
+            return err
+        }
+        time.Sleep(delay)
+        delay *= 2
+    }
+}
+
+// post makes a single delivery attempt
+func (d *Dispatcher) post(sub Subscription, body []byte) error {
+    ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set(signatureHeader, signPayload(sub.Secret, body))
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("endpoint returned %s", resp.Status)
+    }
+
+    return nil
+}
+
+// subscriptionRequest is the body accepted by POST /subscriptions
+type subscriptionRequest struct {
+    URL    string   `json:"url"`
+    Secret string   `json:"secret"`
+    Events []string `json:"events"`
+}
+
+// listSubscriptionsHandler returns all subscriptions without their secrets
+func (s *WebhookServer) listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+    subs, err := s.db.ListSubscriptions(false)
+    if err != nil {
+        s.logger.Printf("Error listing subscriptions: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    for i := range subs {
+        subs[i].Secret = ""
+    }
+    if subs == nil {
+        subs = []Subscription{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(subs)
+}
+
+// createSubscriptionHandler registers an endpoint and returns it with its secret
+func (s *WebhookServer) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+    var req subscriptionRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid JSON")
+        return
+    }
+
+    events, err := parseOutboundEvents(strings.Join(req.Events, ","))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, err.Error())
+        return
+    }
+    if !isURL(req.URL) {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "url must be http(s)")
+        return
+    }
+
+    sub, err := s.db.CreateSubscription(req.URL, req.Secret, events)
+    if err != nil {
+        s.logger.Printf("Error creating subscription: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(sub)
+}
+
+// deleteSubscriptionHandler removes an endpoint
+func (s *WebhookServer) deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid subscription ID")
+        return
+    }
+
+    if err := s.db.DeleteSubscription(id); err != nil {
+        if errors.Is(err, ErrSubscriptionNotFound) {
+            writeError(w, r, http.StatusNotFound, errNotFound, "Subscription not found")
+            return
+        }
+        s.logger.Printf("Error deleting subscription: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func runSubscriptions(logger *log.Logger) {
+    usage := "memberships subscriptions <list|add|remove|enable|test> [args]"
+    if len(os.Args) < 3 {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+        os.Exit(2)
+    }
+
+    action := os.Args[2]
+    subCmd := flag.NewFlagSet("subscriptions "+action, flag.ExitOnError)
+    events := subCmd.String("events", "", "Comma-separated event types (member.created, member.status_changed; default all)")
+    secret := subCmd.String("secret", "", "Signing secret (generated if omitted)")
+
+    args := parseSubcommand(subCmd, usage, os.Args[3:])
+
+    parseID := func() int {
+        if len(args) < 1 {
+            fmt.Fprintf(os.Stderr, "Error: subscriptions %s requires a subscription ID\n", action)
+            os.Exit(2)
+        }
+        id, err := strconv.Atoi(args[0])
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: invalid subscription ID %q\n", args[0])
+            os.Exit(2)
+        }
+        return id
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    switch action {
+    case "list":
+        subs, err := db.ListSubscriptions(false)
+        if err != nil {
+            logger.Fatalf("List failed: %v", err)
+        }
+        if len(subs) == 0 {
+            fmt.Println("No subscriptions")
+            return
+        }
+        for _, sub := range subs {
+            state := "active"
+            if sub.DisabledAt != nil {
+                state = "disabled " + displayDate(*sub.DisabledAt)
+            }
+            events := "all"
+            if len(sub.Events) > 0 {
+                events = strings.Join(sub.Events, ",")
+            }
+            fmt.Printf("  #%d %s [%s] events=%s failures=%d\n", sub.ID, sub.URL, state, events, sub.FailureCount)
+            if sub.LastError != "" {
+                fmt.Printf("      last error: %s\n", sub.LastError)
+            }
+        }
+
+    case "add":
+        if len(args) < 1 {
+            fmt.Fprintln(os.Stderr, "Error: subscriptions add requires a URL")
+            os.Exit(2)
+        }
+        eventList, err := parseOutboundEvents(*events)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(2)
+        }
+        sub, err := db.CreateSubscription(args[0], *secret, eventList)
+        if err != nil {
+            logger.Fatalf("Add failed: %v", err)
+        }
+        fmt.Printf("Created subscription #%d for %s\n", sub.ID, sub.URL)
+        fmt.Printf("Signing secret: %s\n", sub.Secret)
+        fmt.Printf("Deliveries carry %s: sha256=<hex HMAC-SHA256 of the body>\n", signatureHeader)
+
+    case "remove":
+        id := parseID()
+        if err := db.DeleteSubscription(id); err != nil {
+            logger.Fatalf("Remove failed: %v", err)
+        }
+        fmt.Printf("Removed subscription #%d\n", id)
+
+    case "enable":
+        id := parseID()
+        if err := db.EnableSubscription(id); err != nil {
+            logger.Fatalf("Enable failed: %v", err)
+        }
+        fmt.Printf("Enabled subscription #%d\n", id)
+
+    case "test":
+        id := parseID()
+        sub, err := db.GetSubscription(id)
+        if err != nil {
+            logger.Fatalf("Test failed: %v", err)
+        }
+        dispatcher := NewDispatcher(db, logger)
+        err = dispatcher.post(*sub, mustMarshal(OutboundEvent{
+            Event:     outboundTest,
+            MemberID:  "00000000-0000-0000-0000-000000000000",
+            Email:     "test@example.org",
+            OldStatus: "cancelled",
+            NewStatus: "active",
+            Timestamp: time.Now().UTC(),
+        }))
+        if err != nil {
+            fmt.Printf("Test delivery to %s failed: %v\n", sub.URL, err)
+            os.Exit(1)
+        }
+        fmt.Printf("Test delivery to %s succeeded\n", sub.URL)
+
+    default:
+        fmt.Fprintf(os.Stderr, "Error: unknown subscriptions action %q\nUsage: %s\n", action, usage)
+        os.Exit(2)
+    }
+}
+
+// mustMarshal encodes a value that is known to be serializable
+func mustMarshal(v interface{}) []byte {
+    body, err := json.Marshal(v)
+    if err != nil {
+        panic(err)
+    }
+    return body
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "time"
+
+    "github.com/lib/pq"
+)
+
+// SyncChanges is the set of changes a clean run applies
+type SyncChanges struct {
+    InputFile    string
+    Add          []string
+    Activate     []string
+    Deactivate   []string
+    Suspend      []string
+    PaymentDates map[string]time.Time
+    Frequencies  map[string]string
+    Campaigns    map[string]string
+    Donations    []Donation
+}
+
+// SyncRun is a recorded clean run
+type SyncRun struct {
+    ID          int
+    StartedAt   time.Time
+    FinishedAt  sql.NullTime
+    InputFile   string
+    Status      string
+    Added       int
+    Reactivated int
+    Deactivated int
+    Suspended   int
+    UndoneAt    sql.NullTime
+}
+
+// ApplySyncChanges applies a clean run in a single transaction, recording the
+// before and after status of every member touched in sync_runs. Any failure
+// rolls back the whole run. progress, if set, is called once per change.
+func (db *Database) ApplySyncChanges(changes SyncChanges, progress func()) (int, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return 0, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var runID int
+    err = tx.QueryRow(`
+        INSERT INTO sync_runs (input_file) VALUES ($1) RETURNING id
+    `, changes.InputFile).Scan(&runID)
+    if err != nil {
+        return 0, fmt.Errorf("failed to record sync run: %w", err)
+    }
+
+    change := ChangeSource{Source: "clean", Detail: fmt.Sprintf("sync run #%d (%s)", runID, changes.InputFile)}
+
+    // Count what actually changed rather than what was planned; a member
+    // may have been added or updated by a webhook since the plan was made
+    added := 0
+    for _, email := range changes.Add {
+        if progress != nil {
+            progress()
+        }
+        result, err := db.processMember(tx, email, "", false, "active", change)
+        if err != nil {
+            return 0, fmt.Errorf("failed to add member %s: %w", email, err)
+        }
+        if !result.Changed() {
+            continue
+        }
+        if result.Action == actionCreated {
+            added++
+            if err := db.setMemberCampaign(tx, email, changes.Campaigns[email]); err != nil {
+                return 0, err
+            }
+        }
+        if err := recordSyncChange(tx, runID, email, result.PreviousStatus, result.Status); err != nil {
+            return 0, err
+        }
+    }
+
+    apply := func(emails []string, status string) (int, error) {
+        count := 0
+        for start := 0; start < len(emails); start += statusBatchSize {
+            batch := emails[start:min(start+statusBatchSize, len(emails))]
+            updated, err := db.bulkUpdateStatus(tx, batch, status, change)
+            if err != nil {
+                return 0, fmt.Errorf("failed to set members to %s: %w", status, err)
+            }
+            if err := recordSyncChanges(tx, runID, updated, status); err != nil {
+                return 0, err
+            }
+            for _, u := range updated {
+                if u.Before != status {
+                    count++
+                }
+            }
+            if progress != nil {
+                for range batch {
+                    progress()
+                }
+            }
+        }
+        return count, nil
+    }
+
+    reactivated, err := apply(changes.Activate, "active")
+    if err != nil {
+        return 0, err
+    }
+    deactivated, err := apply(changes.Deactivate, "cancelled")
+    if err != nil {
+        return 0, err
+    }
+    suspended, err := apply(changes.Suspend, "suspended")
+    if err != nil {
+        return 0, err
+    }
+
+    for email, paidAt := range changes.PaymentDates {
+        if err := db.recordPayment(tx, email, paidAt); err != nil {
+            return 0, err
+        }
+    }
+    for email, frequency := range changes.Frequencies {
+        if err := db.setMemberFrequency(tx, email, frequency); err != nil {
+            return 0, err
+        }
+    }
+    donations, err := db.recordDonations(tx, changes.Donations)
+    if err != nil {
+        return 0, err
+    }
+    if donations > 0 {
+        db.logger.Printf("Recorded %d new donations", donations)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE sync_runs SET
+            status = 'applied',
+            finished_at = CURRENT_TIMESTAMP,
+            added = $2,
+            reactivated = $3,
+            deactivated = $4,
+            suspended = $5
+        WHERE id = $1
+    `, runID, added, reactivated, deactivated, suspended)
+    if err != nil {
+        return 0, fmt.Errorf("failed to finish sync run: %w", err)
+    }
+
+    err = recordEvent(tx, feedSyncApplied, 0, change, map[string]interface{}{
+        "run_id":      runID,
+        "input_file":  changes.InputFile,
+        "added":       added,
+        "reactivated": reactivated,
+        "deactivated": deactivated,
+        "suspended":   suspended,
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    db.logger.Printf("Sync run #%d: %d added, %d reactivated, %d deactivated, %d suspended",
+        runID, added, reactivated, deactivated, suspended)
+
+    return runID, nil
+}
+
+// recordSyncChange stores one member's transition within a sync run
+func recordSyncChange(q querier, runID int, email, before, after string) error {
+    _, err := q.Exec(`
+        INSERT INTO sync_run_changes (run_id, member_id, email, before_status, after_status)
+        VALUES ($1, (SELECT id FROM members WHERE email = $2), $2, NULLIF($3, ''), $4)
+    `, runID, email, before, after)
+    if err != nil {
+        return fmt.Errorf("failed to record change for %s: %w", email, err)
+    }
+    return nil
+}
+
+// recordSyncChanges stores the transitions made by one bulk status update
+func recordSyncChanges(q querier, runID int, changes []bulkChange, after string) error {
+    ids := make([]int64, len(changes))
+    emails := make([]string, len(changes))
+    befores := make([]string, len(changes))
+    for i, c := range changes {
+        ids[i] = int64(c.MemberID)
+        emails[i] = c.Email
+        befores[i] = c.Before
+    }
+
+    _, err := q.Exec(`
+        INSERT INTO sync_run_changes (run_id, member_id, email, before_status, after_status)
+        SELECT $1, t.id, t.email, t.before, $5
+        FROM unnest($2::int[], $3::text[], $4::text[]) AS t(id, email, before)
+    `, runID, pq.Array(ids), pq.Array(emails), pq.Array(befores), after)
+    if err != nil {
+        return fmt.Errorf("failed to record changes: %w", err)
+    }
+    return nil
+}
+
+// UndoResult summarizes an undone sync run
+type UndoResult struct {
+    Restored int
+    Removed  int
+    Skipped  []string
+}
+
+// UndoSyncRun reverses the status changes of a sync run in one transaction.
+// Members changed again since the run are skipped rather than clobbered, and
+// members the run created are removed only if untouched since.
+func (db *Database) UndoSyncRun(runID int) (*UndoResult, error) {
+    tx, err := db.Begin()
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var status string
+    var finishedAt sql.NullTime
+    err = tx.QueryRow(`
+        SELECT status, finished_at FROM sync_runs WHERE id = $1 FOR UPDATE
+    `, runID).Scan(&status, &finishedAt)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("sync run %d not found", runID)
+    } else if err != nil {
+        return nil, fmt.Errorf("database error: %w", err)
+    }
+    if status != "applied" {
+        return nil, fmt.Errorf("sync run %d is %s, not applied", runID, status)
+    }
+
+    rows, err := tx.Query(`
+        SELECT c.member_id, c.email, COALESCE(c.before_status, ''), c.after_status,
+               COALESCE(m.status, ''), m.last_updated
+        FROM sync_run_changes c
+        LEFT JOIN members m ON m.id = c.member_id
+        WHERE c.run_id = $1
+        ORDER BY c.id DESC
+    `, runID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load run changes: %w", err)
+    }
+
+    type change struct {
+        memberID    sql.NullInt64
+        email       string
+        before      string
+        after       string
+        current     string
+        lastUpdated sql.NullTime
+    }
+    var changes []change
+    for rows.Next() {
+        var c change
+        if err := rows.Scan(&c.memberID, &c.email, &c.before, &c.after, &c.current, &c.lastUpdated); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        changes = append(changes, c)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    result := &UndoResult{}
+    undo := ChangeSource{Source: "undo", Detail: fmt.Sprintf("sync run #%d", runID)}
+
+    for _, c := range changes {
+        if !c.memberID.Valid || c.current != c.after {
+            result.Skipped = append(result.Skipped, c.email)
+            continue
+        }
+
+        if c.before == "" {
+            // Created by the run; only remove if nothing touched it since
+            if finishedAt.Valid && c.lastUpdated.Valid && c.lastUpdated.Time.After(finishedAt.Time) {
+                result.Skipped = append(result.Skipped, c.email)
+                continue
+            }
+            if _, err := tx.Exec(`DELETE FROM members WHERE id = $1`, c.memberID.Int64); err != nil {
+                return nil, fmt.Errorf("failed to remove %s: %w", c.email, err)
+            }
+            result.Removed++
+            continue
+        }
+
+        if err := db.updateMemberStatus(tx, c.email, c.before, undo); err != nil {
+            return nil, fmt.Errorf("failed to restore %s: %w", c.email, err)
+        }
+        result.Restored++
+    }
+
+    _, err = tx.Exec(`
+        UPDATE sync_runs SET status = 'undone', undone_at = CURRENT_TIMESTAMP WHERE id = $1
+    `, runID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to mark run undone: %w", err)
+    }
+
+    err = recordEvent(tx, feedSyncUndone, 0, undo, map[string]interface{}{
+        "run_id":   runID,
+        "restored": result.Restored,
+        "removed":  result.Removed,
+        "skipped":  len(result.Skipped),
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit: %w", err)
+    }
+    db.Events.Publish()
+
+    return result, nil
+}
+
+// ListSyncRuns returns the most recent sync runs
+func (db *Database) ListSyncRuns(limit int) ([]SyncRun, error) {
+    rows, err := db.Query(`
+        SELECT id, started_at, finished_at, COALESCE(input_file, ''), status,
+               added, reactivated, deactivated, suspended, undone_at
+        FROM sync_runs
+        ORDER BY id DESC
+        LIMIT $1
+    `, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var runs []SyncRun
+    for rows.Next() {
+        var run SyncRun
+        err := rows.Scan(&run.ID, &run.StartedAt, &run.FinishedAt, &run.InputFile, &run.Status,
+            &run.Added, &run.Reactivated, &run.Deactivated, &run.Suspended, &run.UndoneAt)
+        if err != nil {
+            return nil, err
+        }
+        runs = append(runs, run)
+    }
+
+    return runs, rows.Err()
+}
+
+// printSyncHistory prints recent sync runs for clean --history
+func printSyncHistory(db *Database, logger *log.Logger) {
+    runs, err := db.ListSyncRuns(20)
+    if err != nil {
+        logger.Fatalf("Failed to get sync history: %v", err)
+    }
+
+    if len(runs) == 0 {
+        fmt.Println("No sync runs recorded")
+        return
+    }
+
+    fmt.Println("\n=== Sync Runs ===")
+    fmt.Printf("%-6s %-23s %-9s %6s %6s %6s %6s  %s\n", "ID", "Started", "Status", "Added", "React.", "Deact.", "Susp.", "Input")
+    for _, run := range runs {
+        fmt.Printf("%-6d %-23s %-9s %6d %6d %6d %6d  %s\n", run.ID, displayTime(run.StartedAt),
+            run.Status, run.Added, run.Reactivated, run.Deactivated, run.Suspended, run.InputFile)
+    }
+    fmt.Println()
+}
+
+// runSyncTarget dispatches "memberships sync <service>"
+func runSyncTarget(logger *log.Logger) {
+    usage := "Usage: memberships sync <mailchimp|discord> [flags]"
+    if len(os.Args) < 3 {
+        fmt.Fprintln(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    switch os.Args[2] {
+    case "mailchimp":
+        runSyncMailchimp(os.Args[3:], logger)
+    case "discord":
+        runSyncDiscord(os.Args[3:], logger)
+    default:
+        fmt.Fprintf(os.Stderr, "Error: unknown sync target %q\n%s\n", os.Args[2], usage)
+        os.Exit(2)
+    }
+}
+
+func runUndo(logger *log.Logger) {
+    undoCmd := flag.NewFlagSet("undo", flag.ExitOnError)
+
+    args := parseSubcommand(undoCmd, "memberships undo <run-id>", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: undo command requires a sync run ID")
+        undoCmd.Usage()
+        os.Exit(2)
+    }
+
+    runID, err := strconv.Atoi(args[0])
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: invalid run ID %q\n", args[0])
+        os.Exit(2)
+    }
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    result, err := db.UndoSyncRun(runID)
+    if err != nil {
+        logger.Fatalf("Undo failed: %v", err)
+    }
+
+    fmt.Printf("Undid sync run #%d: %d statuses restored, %d created members removed\n",
+        runID, result.Restored, result.Removed)
+    if len(result.Skipped) > 0 {
+        fmt.Printf("Skipped %d members changed since the run: %v\n", len(result.Skipped), result.Skipped)
+    }
+}
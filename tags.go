@@ -0,0 +1,248 @@
+package main
+
+import (
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+
+    "github.com/lib/pq"
+)
+
+// ProtectedTag marks members that clean must never auto-deactivate
+const ProtectedTag = "protected"
+
+// normalizeTag canonicalizes a tag so "Board Member" and "board member" match
+func normalizeTag(tag string) string {
+    return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizeTags canonicalizes and de-duplicates a tag list
+func normalizeTags(tags []string) []string {
+    seen := make(map[string]bool)
+    result := []string{}
+    for _, tag := range tags {
+        tag = normalizeTag(tag)
+        if tag == "" || seen[tag] {
+            continue
+        }
+        seen[tag] = true
+        result = append(result, tag)
+    }
+    return result
+}
+
+// AddMemberTag adds a tag to a member if it isn't already present
+func (db *Database) AddMemberTag(email, tag string) error {
+    email = db.NormalizeEmail(email)
+    tag = normalizeTag(tag)
+    if tag == "" {
+        return fmt.Errorf("tag is required")
+    }
+
+    return db.inTx(func(tx *sql.Tx) error {
+        var memberID int
+        err := tx.QueryRow(`
+            UPDATE members SET
+                tags = CASE WHEN $2 = ANY(tags) THEN tags ELSE array_append(tags, $2) END
+            WHERE email = $1
+            RETURNING id
+        `, email, tag).Scan(&memberID)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        } else if err != nil {
+            return fmt.Errorf("failed to add tag: %w", err)
+        }
+
+        return recordEvent(tx, feedMemberUpdated, memberID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":     email,
+            "tag_added": tag,
+        })
+    })
+}
+
+// RemoveMemberTag removes a tag from a member
+func (db *Database) RemoveMemberTag(email, tag string) error {
+    email = db.NormalizeEmail(email)
+
+    tag = normalizeTag(tag)
+
+    return db.inTx(func(tx *sql.Tx) error {
+        var memberID int
+        err := tx.QueryRow(`
+            UPDATE members SET tags = array_remove(tags, $2) WHERE email = $1 RETURNING id
+        `, email, tag).Scan(&memberID)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        } else if err != nil {
+            return fmt.Errorf("failed to remove tag: %w", err)
+        }
+
+        return recordEvent(tx, feedMemberUpdated, memberID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":       email,
+            "tag_removed": tag,
+        })
+    })
+}
+
+// UpdateMemberAnnotations sets notes and/or tags; nil arguments are left unchanged
+func (db *Database) UpdateMemberAnnotations(email string, notes *string, tags []string) error {
+    email = db.NormalizeEmail(email)
+
+    if tags != nil {
+        tags = normalizeTags(tags)
+    }
+
+    var fields []string
+    if notes != nil {
+        fields = append(fields, "notes")
+    }
+    if tags != nil {
+        fields = append(fields, "tags")
+    }
+
+    return db.inTx(func(tx *sql.Tx) error {
+        var memberID int
+        err := tx.QueryRow(`
+            UPDATE members SET
+                notes = CASE WHEN $2 THEN $3 ELSE notes END,
+                tags = CASE WHEN $4 THEN $5::text[] ELSE tags END
+            WHERE email = $1
+            RETURNING id
+        `, email, notes != nil, notes, tags != nil, pq.Array(tags)).Scan(&memberID)
+        if err == sql.ErrNoRows {
+            return fmt.Errorf("%w: %s", ErrMemberNotFound, email)
+        } else if err != nil {
+            return fmt.Errorf("failed to update member: %w", err)
+        }
+
+        return recordEvent(tx, feedMemberUpdated, memberID, ChangeSource{Source: "manual"}, map[string]interface{}{
+            "email":  email,
+            "fields": fields,
+        })
+    })
+}
+
+// GetEmailsWithTag returns the set of member emails carrying a tag
+func (db *Database) GetEmailsWithTag(tag string) (map[string]bool, error) {
+    rows, err := db.Query(`SELECT email FROM members WHERE $1 = ANY(tags)`, normalizeTag(tag))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    emails := make(map[string]bool)
+    for rows.Next() {
+        var email string
+        if err := rows.Scan(&email); err != nil {
+            return nil, err
+        }
+        emails[strings.ToLower(email)] = true
+    }
+
+    return emails, rows.Err()
+}
+
+// IsProtected reports whether a member carries the protected tag. Unknown
+// members are not protected.
+func (db *Database) IsProtected(email string) (bool, error) {
+    var protected bool
+    err := db.QueryRow(`
+        SELECT $2 = ANY(tags) FROM members WHERE email = $1
+    `, db.NormalizeEmail(email), ProtectedTag).Scan(&protected)
+
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    return protected, err
+}
+
+func runTag(logger *log.Logger) {
+    tagCmd := flag.NewFlagSet("tag", flag.ExitOnError)
+    remove := tagCmd.Bool("remove", false, "Remove the tag instead of adding it")
+
+    args := parseSubcommand(tagCmd, "memberships tag <email> <tag> [--remove]", os.Args[2:])
+    if len(args) < 2 {
+        fmt.Fprintln(os.Stderr, "Error: tag command requires an email and a tag")
+        tagCmd.Usage()
+        os.Exit(2)
+    }
+
+    email := args[0]
+    tag := args[1]
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    var err error
+    if *remove {
+        err = db.RemoveMemberTag(email, tag)
+    } else {
+        err = db.AddMemberTag(email, tag)
+    }
+    if err != nil {
+        logger.Fatalf("Tag failed: %v", err)
+    }
+
+    member, err := db.GetMemberByEmail(email)
+    if err != nil {
+        logger.Fatalf("Failed to reload member: %v", err)
+    }
+
+    fmt.Printf("%s tags: %s\n", member.Email, strings.Join(member.Tags, ", "))
+}
+
+func runNote(logger *log.Logger) {
+    if len(os.Args) < 4 {
+        fmt.Println("Error: note command requires an email and the note text")
+        fmt.Println(`Usage: memberships note <email> "text"`)
+        os.Exit(1)
+    }
+
+    email := os.Args[2]
+    notes := strings.Join(os.Args[3:], " ")
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    if err := db.UpdateMemberAnnotations(email, &notes, nil); err != nil {
+        logger.Fatalf("Note failed: %v", err)
+    }
+
+    fmt.Printf("Updated notes for %s\n", db.NormalizeEmail(email))
+}
+
+func runProtect(logger *log.Logger) {
+    protectCmd := flag.NewFlagSet("protect", flag.ExitOnError)
+    remove := protectCmd.Bool("remove", false, "Remove protection instead of adding it")
+
+    args := parseSubcommand(protectCmd, "memberships protect <email> [--remove]", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: protect command requires an email")
+        protectCmd.Usage()
+        os.Exit(2)
+    }
+
+    email := args[0]
+
+    db := connectDatabase(logger)
+    defer db.Close()
+
+    var err error
+    if *remove {
+        err = db.RemoveMemberTag(email, ProtectedTag)
+    } else {
+        err = db.AddMemberTag(email, ProtectedTag)
+    }
+    if err != nil {
+        logger.Fatalf("Protect failed: %v", err)
+    }
+
+    if *remove {
+        fmt.Printf("%s is no longer protected\n", db.NormalizeEmail(email))
+    } else {
+        fmt.Printf("%s is protected; clean and webhooks will not deactivate it\n", db.NormalizeEmail(email))
+    }
+}
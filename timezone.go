@@ -0,0 +1,43 @@
+package main
+
+import (
+    "net/url"
+    "strings"
+    "time"
+)
+
+// displayZone is the zone CLI output and human-facing reports show times
+// in, from DISPLAY_TIMEZONE. The database and API JSON are always UTC.
+var displayZone = time.Local
+
+// displayTime formats a timestamp for people, in the display zone
+func displayTime(t time.Time) string {
+    return t.In(displayZone).Format("2006-01-02 15:04:05 MST")
+}
+
+// displayDate formats the calendar date of a timestamp in the display zone
+func displayDate(t time.Time) string {
+    return t.In(displayZone).Format("2006-01-02")
+}
+
+// utcSession sets a connection string's session time zone to UTC, so
+// timestamps come back in UTC and CURRENT_DATE doesn't depend on how the
+// database server is configured. A zone already in the string is kept.
+func utcSession(connStr string) string {
+    if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+        u, err := url.Parse(connStr)
+        if err != nil {
+            return connStr
+        }
+        query := u.Query()
+        if query.Get("timezone") == "" {
+            query.Set("timezone", "UTC")
+            u.RawQuery = query.Encode()
+        }
+        return u.String()
+    }
+    if strings.Contains(strings.ToLower(connStr), "timezone=") {
+        return connStr
+    }
+    return strings.TrimSpace(connStr + " timezone=UTC")
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+// ErrUnmappedStatusNotFound is returned when clearing a mapping for a
+// payment status that has never been seen or mapped
+var ErrUnmappedStatusNotFound = errors.New("unmapped status not found")
+
+// Kinds of unmapped value
+const (
+    unmappedKindStatus    = "status"
+    unmappedKindFrequency = "frequency"
+)
+
+// UnmappedStatus is a payment status no STATUS_RULES rule matched, or a
+// frequency no synonym covers. MappedTo, once set on a status, is the member
+// status (or "failed") it now maps to; frequencies are reviewed so the
+// synonyms can be extended.
+type UnmappedStatus struct {
+    Kind          string     `json:"kind"`
+    PaymentStatus string     `json:"payment_status"`
+    Seen          int        `json:"seen"`
+    FirstSeenAt   time.Time  `json:"first_seen_at"`
+    LastSeenAt    time.Time  `json:"last_seen_at"`
+    MappedTo      string     `json:"mapped_to,omitempty"`
+    MappedAt      *time.Time `json:"mapped_at,omitempty"`
+    MappedBy      string     `json:"mapped_by,omitempty"`
+}
+
+// unmappedKey is how a payment status is stored: mappings match the whole
+// status, ignoring case and surrounding space
+func unmappedKey(paymentStatus string) string {
+    return strings.ToLower(strings.TrimSpace(paymentStatus))
+}
+
+// RecordUnmappedValue counts sightings of a payment status no rule matched
+// or a frequency no synonym covers
+func (db *Database) RecordUnmappedValue(kind, value string, seen int) error {
+    _, err := db.Exec(`
+        INSERT INTO unmapped_statuses (kind, payment_status, seen) VALUES ($1, $2, $3)
+        ON CONFLICT (kind, payment_status) DO UPDATE SET
+            seen = unmapped_statuses.seen + EXCLUDED.seen,
+            last_seen_at = CURRENT_TIMESTAMP
+    `, kind, unmappedKey(value), seen)
+    if err != nil {
+        return fmt.Errorf("failed to record unmapped %s: %w", kind, err)
+    }
+    return nil
+}
+
+// StatusMapping returns the status a payment status was mapped to with
+// MapUnmappedStatus, or "" if it hasn't been
+func (db *Database) StatusMapping(paymentStatus string) (string, error) {
+    var mapped sql.NullString
+    err := db.QueryRow(`
+        SELECT mapped_to FROM unmapped_statuses WHERE kind = $1 AND payment_status = $2
+    `, unmappedKindStatus, unmappedKey(paymentStatus)).Scan(&mapped)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    if err != nil {
+        return "", fmt.Errorf("failed to look up status mapping: %w", err)
+    }
+    return mapped.String, nil
+}
+
+// GetUnmappedStatuses returns the payment statuses awaiting a mapping and
+// the unrecognized frequencies, most recently seen first, or every observed
+// value if all is set
+func (db *Database) GetUnmappedStatuses(all bool) ([]UnmappedStatus, error) {
+    rows, err := db.Query(`
+        SELECT kind, payment_status, seen, first_seen_at, last_seen_at,
+               COALESCE(mapped_to, ''), mapped_at, COALESCE(mapped_by, '')
+        FROM unmapped_statuses
+        WHERE $1 OR mapped_to IS NULL
+        ORDER BY last_seen_at DESC
+    `, all)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get unmapped statuses: %w", err)
+    }
+    defer rows.Close()
+
+    var statuses []UnmappedStatus
+    for rows.Next() {
+        var u UnmappedStatus
+        var mappedAt sql.NullTime
+        if err := rows.Scan(&u.Kind, &u.PaymentStatus, &u.Seen, &u.FirstSeenAt, &u.LastSeenAt, &u.MappedTo, &mappedAt, &u.MappedBy); err != nil {
+            return nil, err
+        }
+        if mappedAt.Valid {
+            u.MappedAt = &mappedAt.Time
+        }
+        statuses = append(statuses, u)
+    }
+    return statuses, rows.Err()
+}
+
+// MapUnmappedStatus maps a payment status to a member status or "failed",
+// whether or not it has been seen yet. An empty target clears the mapping.
+func (db *Database) MapUnmappedStatus(paymentStatus, target, by string) error {
+    key := unmappedKey(paymentStatus)
+    if key == "" {
+        return fmt.Errorf("payment status is empty")
+    }
+
+    if target == "" {
+        res, err := db.Exec(`
+            UPDATE unmapped_statuses SET mapped_to = NULL, mapped_at = NULL, mapped_by = NULL
+            WHERE kind = $1 AND payment_status = $2
+        `, unmappedKindStatus, key)
+        if err != nil {
+            return fmt.Errorf("failed to clear status mapping: %w", err)
+        }
+        if n, _ := res.RowsAffected(); n == 0 {
+            return fmt.Errorf("%w: %q", ErrUnmappedStatusNotFound, key)
+        }
+        return nil
+    }
+
+    if target != statusFailed && !validStatus(target) {
+        return fmt.Errorf("%w: %q", ErrUnknownStatus, target)
+    }
+    _, err := db.Exec(`
+        INSERT INTO unmapped_statuses (kind, payment_status, mapped_to, mapped_at, mapped_by)
+        VALUES ($1, $2, $3, CURRENT_TIMESTAMP, NULLIF($4, ''))
+        ON CONFLICT (kind, payment_status) DO UPDATE SET
+            mapped_to = EXCLUDED.mapped_to,
+            mapped_at = EXCLUDED.mapped_at,
+            mapped_by = EXCLUDED.mapped_by
+    `, unmappedKindStatus, key, target, by)
+    if err != nil {
+        return fmt.Errorf("failed to map status: %w", err)
+    }
+    return nil
+}
+
+// retryUnmappedFailures retries the open unknown_status failures for a
+// payment status, typically just after it has been mapped
+func (s *WebhookServer) retryUnmappedFailures(paymentStatus, by string) (retried, failing int, err error) {
+    failures, err := s.db.GetOpenFailuresOfClass(failureUnknownStatus)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    key := unmappedKey(paymentStatus)
+    for i := range failures {
+        var webhook MemberWebhook
+        if json.Unmarshal([]byte(failures[i].Body), &webhook) != nil || unmappedKey(webhook.Status) != key {
+            continue
+        }
+        if err := s.retryFailedWebhook(&failures[i], by); err != nil {
+            s.logger.Printf("Failure #%d still fails: %v", failures[i].ID, err)
+            failing++
+            continue
+        }
+        retried++
+    }
+    return retried, failing, nil
+}
+
+// unmappedStatusesHandler serves GET /statuses/unmapped, the payment
+// statuses awaiting a mapping, or every one seen with ?all=true
+func (s *WebhookServer) unmappedStatusesHandler(w http.ResponseWriter, r *http.Request) {
+    all := r.URL.Query().Get("all") == "true" || r.URL.Query().Get("all") == "1"
+
+    statuses, err := s.db.GetUnmappedStatuses(all)
+    if err != nil {
+        s.logger.Printf("Error getting unmapped statuses: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    if statuses == nil {
+        statuses = []UnmappedStatus{}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(statuses)
+}
+
+// statusMappingRequest is the body of POST /statuses/unmapped
+type statusMappingRequest struct {
+    PaymentStatus string `json:"payment_status"`
+    Status        string `json:"status"`
+    Retry         bool   `json:"retry"`
+}
+
+// statusMappingResponse reports a mapping and any failures it retried
+type statusMappingResponse struct {
+    PaymentStatus string `json:"payment_status"`
+    Status        string `json:"status"`
+    Retried       int    `json:"retried"`
+    StillFailing  int    `json:"still_failing"`
+}
+
+// mapStatusHandler maps a payment status, or clears its mapping when status
+// is empty, optionally retrying the webhooks it held for review
+func (s *WebhookServer) mapStatusHandler(w http.ResponseWriter, r *http.Request) {
+    var req statusMappingRequest
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || strings.TrimSpace(req.PaymentStatus) == "" {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"payment_status\": \"...\", \"status\": \"...\"}")
+        return
+    }
+    target := strings.ToLower(strings.TrimSpace(req.Status))
+
+    err := s.db.MapUnmappedStatus(req.PaymentStatus, target, s.principal(r))
+    switch {
+    case errors.Is(err, ErrUnmappedStatusNotFound):
+        writeError(w, r, http.StatusNotFound, errNotFound, err.Error())
+        return
+    case errors.Is(err, ErrUnknownStatus):
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, err.Error())
+        return
+    case err != nil:
+        s.logger.Printf("Error mapping status: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+    s.logger.Printf("Payment status %q mapped to %q by %s", unmappedKey(req.PaymentStatus), target, s.principal(r))
+
+    response := statusMappingResponse{PaymentStatus: unmappedKey(req.PaymentStatus), Status: target}
+    if req.Retry && target != "" {
+        response.Retried, response.StillFailing, err = s.retryUnmappedFailures(req.PaymentStatus, s.principal(r))
+        if err != nil {
+            s.logger.Printf("Error retrying failures for %q: %v", req.PaymentStatus, err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
+
+func runStatuses(logger *log.Logger) {
+    usage := `memberships statuses <list|map|unmap> [args]`
+    if len(os.Args) < 3 {
+        fmt.Fprintf(os.Stderr, "Usage: %s\n", usage)
+        os.Exit(2)
+    }
+
+    action := os.Args[2]
+    statusesCmd := flag.NewFlagSet("statuses "+action, flag.ExitOnError)
+    all := statusesCmd.Bool("all", false, "With list: include statuses already mapped")
+    retry := statusesCmd.Bool("retry", false, "With map: retry the open failures held for this status")
+    by := statusesCmd.String("by", os.Getenv("USER"), "Who is mapping (default: $USER)")
+
+    args := parseSubcommand(statusesCmd, usage, os.Args[3:])
+
+    switch action {
+    case "list":
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        statuses, err := db.GetUnmappedStatuses(*all)
+        if err != nil {
+            logger.Fatalf("List failed: %v", err)
+        }
+        if len(statuses) == 0 {
+            fmt.Println("No unmapped payment statuses or frequencies")
+            return
+        }
+        for _, u := range statuses {
+            fmt.Printf("  %-9s %-30q seen %d times, last %s", u.Kind, u.PaymentStatus, u.Seen, displayTime(u.LastSeenAt))
+            if u.MappedTo != "" {
+                fmt.Printf(" -> %s", u.MappedTo)
+                if u.MappedBy != "" {
+                    fmt.Printf(" (by %s)", u.MappedBy)
+                }
+            }
+            fmt.Println()
+        }
+
+    case "map":
+        if len(args) != 2 {
+            fmt.Fprintln(os.Stderr, "Error: statuses map requires a payment status and a member status (or \"failed\")")
+            os.Exit(2)
+        }
+        target := strings.ToLower(strings.TrimSpace(args[1]))
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        if err := db.MapUnmappedStatus(args[0], target, *by); err != nil {
+            logger.Fatalf("Map failed: %v", err)
+        }
+        fmt.Printf("Payment status %q now maps to %s\n", unmappedKey(args[0]), target)
+
+        if *retry {
+            server := NewWebhookServer(db, mustLoadConfig(logger), logger)
+            retried, failing, err := server.retryUnmappedFailures(args[0], *by)
+            if err != nil {
+                logger.Fatalf("Retry failed: %v", err)
+            }
+            fmt.Printf("Retried %d held webhooks, %d still failing\n", retried, failing)
+        }
+
+    case "unmap":
+        if len(args) != 1 {
+            fmt.Fprintln(os.Stderr, "Error: statuses unmap requires a payment status")
+            os.Exit(2)
+        }
+
+        db := connectDatabase(logger)
+        defer db.Close()
+
+        if err := db.MapUnmappedStatus(args[0], "", *by); err != nil {
+            if errors.Is(err, ErrUnmappedStatusNotFound) {
+                fmt.Fprintf(os.Stderr, "No mapping for %q\n", unmappedKey(args[0]))
+                os.Exit(1)
+            }
+            logger.Fatalf("Unmap failed: %v", err)
+        }
+        fmt.Printf("Payment status %q is unmapped again\n", unmappedKey(args[0]))
+
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown action %q\nUsage: %s\n", action, usage)
+        os.Exit(2)
+    }
+}
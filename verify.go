@@ -0,0 +1,299 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "database/sql"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// defaultVerifyRateLimit is how many verifications a caller may make per minute
+const defaultVerifyRateLimit = 60
+
+// verifyRateWindow is the rate limiter's fixed window
+const verifyRateWindow = time.Minute
+
+// parseVerifyTokens parses VERIFY_TOKEN as comma-separated name:token pairs
+// (e.g. "forum:abc,portal:def") and returns token -> name. A bare token is
+// named "default".
+func parseVerifyTokens(value string) (map[string]string, error) {
+    tokens := make(map[string]string)
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+
+        name, token := "default", entry
+        if i := strings.Index(entry, ":"); i >= 0 {
+            name, token = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+        }
+        if name == "" || token == "" {
+            return nil, fmt.Errorf("entries must be name:token, got %q", entry)
+        }
+        if _, dup := tokens[token]; dup {
+            return nil, fmt.Errorf("token for %q is used more than once", name)
+        }
+        tokens[token] = name
+    }
+    return tokens, nil
+}
+
+// rateLimiter allows a fixed number of requests per key per window
+type rateLimiter struct {
+    limit  int
+    window time.Duration
+
+    mu      sync.Mutex
+    windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+    start time.Time
+    count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+    return &rateLimiter{limit: limit, window: window, windows: make(map[string]*rateWindow)}
+}
+
+// Allow counts a request for key and reports whether it is within the limit,
+// and if not, how long until the window resets
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+
+    // Drop expired windows so the map doesn't grow without bound
+    if len(l.windows) > 10000 {
+        for k, w := range l.windows {
+            if now.Sub(w.start) >= l.window {
+                delete(l.windows, k)
+            }
+        }
+    }
+
+    w, ok := l.windows[key]
+    if !ok || now.Sub(w.start) >= l.window {
+        w = &rateWindow{start: now}
+        l.windows[key] = w
+    }
+
+    if w.count >= l.limit {
+        return false, w.start.Add(l.window).Sub(now)
+    }
+    w.count++
+    return true, 0
+}
+
+// verifyCaller returns the name of the verify token on the request, if any
+func (s *WebhookServer) verifyCaller(r *http.Request) (string, bool) {
+    authHeader := r.Header.Get("Authorization")
+    if !strings.HasPrefix(authHeader, "Bearer ") {
+        return "", false
+    }
+
+    given := []byte(strings.TrimPrefix(authHeader, "Bearer "))
+    for token, name := range s.config.VerifyTokens {
+        if subtle.ConstantTimeCompare(given, []byte(token)) == 1 {
+            return name, true
+        }
+    }
+    return "", false
+}
+
+// GetMemberStatusByHash returns the status of the member whose email_hash
+// matches; existed is false when there is none
+func (db *Database) GetMemberStatusByHash(hash string) (status string, existed bool, err error) {
+    err = db.QueryRow(`SELECT status FROM members WHERE email_hash = $1`, hash).Scan(&status)
+    if err == sql.ErrNoRows {
+        return "", false, nil
+    }
+    return status, err == nil, err
+}
+
+// isEmailHash reports whether s is a lowercase hex SHA-256
+func isEmailHash(s string) bool {
+    if len(s) != sha256.Size*2 {
+        return false
+    }
+    _, err := hex.DecodeString(s)
+    return err == nil && s == strings.ToLower(s)
+}
+
+// verifyResponse is everything /verify reveals about a member
+type verifyResponse struct {
+    Active   bool   `json:"active"`
+    Status   string `json:"status"`
+    MemberID string `json:"member_id,omitempty"`
+
+    // Organization is set when the member is active through it
+    Organization string `json:"organization,omitempty"`
+}
+
+// countedEmail is the member /verify identifies email as: itself, or its
+// household's primary when households count as one member
+func (s *WebhookServer) countedEmail(email string) string {
+    if !s.config.CountHouseholds {
+        return email
+    }
+    if primary, linked, err := s.db.HouseholdPrimary(email); err == nil && linked {
+        return primary
+    }
+    return email
+}
+
+// isVerifiedStatus reports whether status counts as active for /verify
+func (s *WebhookServer) isVerifiedStatus(status string) bool {
+    for _, allowed := range s.config.VerifyStatuses {
+        if status == allowed {
+            return true
+        }
+    }
+    return false
+}
+
+// verifyHandler tells semi-trusted services whether an email belongs to an
+// active member, and nothing more
+func (s *WebhookServer) verifyHandler(w http.ResponseWriter, r *http.Request) {
+    if len(s.config.VerifyTokens) == 0 {
+        writeError(w, r, http.StatusForbidden, errForbidden, "Verification API disabled")
+        return
+    }
+
+    caller, ok := s.verifyCaller(r)
+    if !ok {
+        s.logger.Printf("Unauthorized verify attempt from %s", s.clientIP(r))
+        writeError(w, r, http.StatusUnauthorized, errUnauthorized, "Unauthorized")
+        return
+    }
+
+    // Limit each caller per client address; this endpoint is an email oracle
+    host := s.clientIP(r)
+    if allowed, retryAfter := s.verifyLimiter.Allow(caller + "|" + host); !allowed {
+        s.logger.Printf("Verify rate limit exceeded by %s from %s", caller, host)
+        w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+        writeError(w, r, http.StatusTooManyRequests, errRateLimited, "Too many requests")
+        return
+    }
+
+    var req struct {
+        Email string `json:"email"`
+    }
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Email == "" {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected {\"email\": \"...\"}")
+        return
+    }
+
+    response := verifyResponse{Status: "none"}
+    if validateEmail(req.Email) == nil {
+        status, existed, err := s.db.GetMemberStatus(req.Email)
+        if err != nil {
+            s.logger.Printf("Error verifying member: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+        if existed {
+            response.Status = status
+            response.Active = s.isVerifiedStatus(status)
+            response.MemberID, _ = s.db.GetPublicID(s.countedEmail(req.Email))
+        }
+
+        // Members of an active organization are active whatever their own status
+        if existed && !response.Active {
+            org, found, err := s.db.MemberOrganization(req.Email, s.config.VerifyStatuses)
+            if err != nil {
+                s.logger.Printf("Error verifying member organization: %v", err)
+                writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+                return
+            }
+            response.Active, response.Organization = found, org
+        }
+    }
+
+    s.logger.Printf("Verify by %s: %s -> %s", caller, s.db.NormalizeEmail(req.Email), response.Status)
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(response)
+}
+
+// verifyHashHandler answers public membership checks by verifyHash of the
+// email, so browsers never send a plaintext address. Callers must normalize
+// exactly as verifyHash does, including EMAIL_NORMALIZATION's rules when it's
+// on; memberships verify-hash prints the expected hash. It needs no token but
+// is rate-limited per client address.
+func (s *WebhookServer) verifyHashHandler(w http.ResponseWriter, r *http.Request) {
+    // email_hash is of the member's key in privacy mode, which callers can't compute
+    if s.config.Privacy != nil {
+        writeError(w, r, http.StatusNotFound, errNotFound, "Verifying by hash is unavailable in privacy mode")
+        return
+    }
+    
+    host := s.clientIP(r)
+    if allowed, retryAfter := s.verifyLimiter.Allow("hash|" + host); !allowed {
+        w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+        writeError(w, r, http.StatusTooManyRequests, errRateLimited, "Too many requests")
+        return
+    }
+
+    hash := r.PathValue("hash")
+    if !isEmailHash(hash) {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Expected a lowercase hex SHA-256 of the normalized email")
+        return
+    }
+
+    status, existed, err := s.db.GetMemberStatusByHash(hash)
+    if err != nil {
+        s.logger.Printf("Error verifying member hash: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+        return
+    }
+
+    active := existed && s.isVerifiedStatus(status)
+    if existed && !active {
+        _, active, err = s.db.MemberOrganizationByHash(hash, s.config.VerifyStatuses)
+        if err != nil {
+            s.logger.Printf("Error verifying member hash: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]bool{
+        "active": active,
+    })
+}
+
+func runVerifyHash(logger *log.Logger) {
+    verifyHashCmd := flag.NewFlagSet("verify-hash", flag.ExitOnError)
+
+    args := parseSubcommand(verifyHashCmd, "memberships verify-hash <email>", os.Args[2:])
+    if len(args) < 1 {
+        fmt.Fprintln(os.Stderr, "Error: verify-hash command requires an email")
+        verifyHashCmd.Usage()
+        os.Exit(2)
+    }
+
+    config := mustLoadConfig(logger)
+    if config.Privacy != nil {
+        fmt.Fprintln(os.Stderr, "Verifying by hash is unavailable in privacy mode")
+        os.Exit(1)
+    }
+
+    fmt.Printf("Normalized: %s\n", normalizeEmail(args[0], config.NormalizeEmails))
+    fmt.Printf("Hash:       %s\n", verifyHash(args[0], config.NormalizeEmails))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "testing"
+)
+
+func sha256Hex(s string) string {
+    sum := sha256.Sum256([]byte(s))
+    return hex.EncodeToString(sum[:])
+}
+
+func TestVerifyHash(t *testing.T) {
+    tests := []struct {
+        email    string
+        extended bool
+        hashed   string
+    }{
+        {" Ada@Example.org ", false, "ada@example.org"},
+        {"ada+news@example.org", false, "ada+news@example.org"},
+        {"Ada.Lovelace@gmail.com", false, "ada.lovelace@gmail.com"},
+        {"ada+news@example.org", true, "ada@example.org"},
+        {"Ada.Lovelace+news@GoogleMail.com", true, "adalovelace@gmail.com"},
+        {"ada.lovelace@example.org", true, "ada.lovelace@example.org"},
+    }
+    for _, tt := range tests {
+        if got := verifyHash(tt.email, tt.extended); got != sha256Hex(tt.hashed) {
+            t.Errorf("verifyHash(%q, %v) isn't the SHA-256 of %q", tt.email, tt.extended, tt.hashed)
+        }
+    }
+}
+
+func TestVerifyHashHandler(t *testing.T) {
+    for _, normalize := range []bool{false, true} {
+        config := testConfig()
+        config.NormalizeEmails = normalize
+        config.VerifyRateLimit = defaultVerifyRateLimit
+        server, _ := newTestServer(t, config)
+        expectStatus(t, postWebhook(t, server, `{"email":"Ada.Lovelace+news@gmail.com","status":"Succeeded"}`), http.StatusCreated)
+
+        var got map[string]bool
+        resp := do(t, server, "GET", "/verify/hash/"+verifyHash("ada.lovelace+news@gmail.com", normalize), "", "")
+        expectStatus(t, resp, http.StatusOK)
+        decode(t, resp, &got)
+        if !got["active"] {
+            t.Errorf("EMAIL_NORMALIZATION=%v: verifyHash of the address isn't found", normalize)
+        }
+
+        if normalize {
+            resp = do(t, server, "GET", "/verify/hash/"+sha256Hex("ada.lovelace+news@gmail.com"), "", "")
+            expectStatus(t, resp, http.StatusOK)
+            decode(t, resp, &got)
+            if got["active"] {
+                t.Error("a hash of the merely lowercased address matched under EMAIL_NORMALIZATION")
+            }
+        }
+    }
+}
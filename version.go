@@ -0,0 +1,78 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "runtime"
+    "runtime/debug"
+)
+
+// Build metadata, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+    version   = "dev"
+    commit    = ""
+    buildDate = ""
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit"`
+    BuildDate string `json:"build_date"`
+    GoVersion string `json:"go_version"`
+}
+
+// buildInfo returns the ldflags values, falling back to the VCS stamp Go
+// embeds in plain `go build` output so dev builds still identify themselves
+func buildInfo() BuildInfo {
+    info := BuildInfo{
+        Version:   version,
+        Commit:    commit,
+        BuildDate: buildDate,
+        GoVersion: runtime.Version(),
+    }
+
+    if bi, ok := debug.ReadBuildInfo(); ok {
+        for _, setting := range bi.Settings {
+            switch setting.Key {
+            case "vcs.revision":
+                if info.Commit == "" {
+                    info.Commit = setting.Value
+                }
+            case "vcs.time":
+                if info.BuildDate == "" {
+                    info.BuildDate = setting.Value
+                }
+            case "vcs.modified":
+                if setting.Value == "true" && commit == "" && info.Commit != "" {
+                    info.Commit += "-dirty"
+                }
+            }
+        }
+    }
+
+    if info.Commit == "" {
+        info.Commit = "unknown"
+    }
+    if info.BuildDate == "" {
+        info.BuildDate = "unknown"
+    }
+
+    return info
+}
+
+// String formats build info for logs and the version command
+func (b BuildInfo) String() string {
+    return fmt.Sprintf("%s (commit %s, built %s, %s)", b.Version, b.Commit, b.BuildDate, b.GoVersion)
+}
+
+// versionHandler returns build metadata
+func (s *WebhookServer) versionHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(buildInfo())
+}
+
+func runVersion() {
+    fmt.Printf("memberships %s\n", buildInfo())
+}
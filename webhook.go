@@ -1,80 +1,251 @@
 package main
 
 import (
+    "crypto/subtle"
     "encoding/base64"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
+    "log"
     "net/http"
+    "strconv"
     "strings"
+    "sync/atomic"
     "time"
 )
 
 // WebhookServer handles HTTP endpoints
 type WebhookServer struct {
-    db     *Database
-    config *Config
+    db        Store
+    config    *Config
+    notifier  *Notifier
+    scheduler *SyncScheduler
+    
+    // memberMail sends welcome and cancellation emails, when enabled
+    memberMail *MemberMailer
+    
+    // jobs are the background jobs registerJobs sets up
+    jobs *JobRegistry
+    
+    verifyLimiter *rateLimiter
+    logger        *log.Logger
+    
+    // panics counts handler panics caught by recoverMiddleware
+    panics atomic.Int64
+    
+    // limits caps in-flight requests per route group
+    limits map[string]*concurrencyLimit
+    
+    // maintenance mirrors the persisted maintenance flag
+    maintenance atomic.Bool
+    
+    // accessLog records reads of member data
+    accessLog *accessLogger
+    
+    // mux holds only the routes Start registers, so nothing a package
+    // registers on http.DefaultServeMux is exposed
+    mux *http.ServeMux
 }
 
-// NewWebhookServer creates a new webhook server instance
-func NewWebhookServer(db *Database, config *Config) *WebhookServer {
+// NewWebhookServer creates a new webhook server instance. A nil logger uses
+// the standard logger.
+func NewWebhookServer(db Store, config *Config, logger *log.Logger) *WebhookServer {
+    if logger == nil {
+        logger = log.Default()
+    }
+    
+    limits := make(map[string]*concurrencyLimit, len(routeGroups))
+    for _, group := range routeGroups {
+        limits[group] = newConcurrencyLimit(config.MaxConcurrentRequests)
+    }
+    
     return &WebhookServer{
-        db:     db,
-        config: config,
+        db:        db,
+        config:    config,
+        notifier:  NewNotifier(config.NotifyWebhookURL, config.NotifyEvents, logger),
+        scheduler: NewSyncScheduler(db, config.SyncSource, config.StatusRules, logger),
+        jobs:      NewJobRegistry(db, logger),
+        
+        verifyLimiter: newRateLimiter(config.VerifyRateLimit, verifyRateWindow),
+        logger:        logger,
+        limits:        limits,
+        accessLog:     newAccessLogger(db, logger),
+        mux:           http.NewServeMux(),
     }
 }
 
 // Start begins listening for HTTP requests
 func (s *WebhookServer) Start() error {
-    http.HandleFunc("/health", s.loggingMiddleware(s.healthHandler))
-    http.HandleFunc("/stats", s.loggingMiddleware(s.statsHandler))
-    http.HandleFunc("/webhook", s.loggingMiddleware(s.webhookHandler))
-    http.HandleFunc("/members", s.loggingMiddleware(s.listMembersHandler))
+    s.routes()
     
-    addr := "127.0.0.1:" + s.config.Port
-    logger.Printf("Starting membership server on %s", addr)
-    logger.Printf("Webhook endpoint: https://memberships.operatorfoundation.org/webhook")
-    logger.Printf("Stats endpoint: https://memberships.operatorfoundation.org/stats")
-    logger.Printf("Members endpoint: https://memberships.operatorfoundation.org/members")
+    listener, err := s.listen()
+    if err != nil {
+        return err
+    }
+    s.logger.Printf("Starting membership server on %s", listener.Addr())
+    s.logger.Printf("Webhook endpoint: https://memberships.operatorfoundation.org/webhook")
+    s.logger.Printf("Stats endpoint: https://memberships.operatorfoundation.org/stats")
+    s.logger.Printf("Members endpoint: https://memberships.operatorfoundation.org/members")
     
-    return http.ListenAndServe(addr, nil)
+    return s.serve(listener)
+}
+
+// routes registers every endpoint on the server's mux
+func (s *WebhookServer) routes() {
+    // /health skips the limits so monitoring works under load, the event
+    // stream is long-lived by design, and maintenance mode and the jobs
+    // have to be reachable while everything else returns 503
+    s.mux.HandleFunc("GET /health", s.loggingMiddleware(s.healthHandler))
+    s.mux.HandleFunc("GET /version", s.loggingMiddleware(s.guard(groupPublic, s.versionHandler)))
+    s.mux.HandleFunc("GET /openapi.json", s.loggingMiddleware(s.guard(groupPublic, s.gzipMiddleware(s.openAPIHandler))))
+    s.handleRead("GET /stats", s.loggingMiddleware(s.guard(groupPublic, s.gzipMiddleware(s.statsHandler))))
+    s.handleRead("GET /stats/history", s.loggingMiddleware(s.guard(groupPublic, s.gzipMiddleware(s.statsHistoryHandler))))
+    s.handleRead("GET /stats/retention", s.loggingMiddleware(s.guard(groupPublic, s.gzipMiddleware(s.statsRetentionHandler))))
+    s.mux.HandleFunc("POST /webhook", s.loggingMiddleware(s.guard(groupWebhook, s.webhookHandler)))
+    s.mux.HandleFunc("POST /verify", s.loggingMiddleware(s.guard(groupPublic, s.verifyHandler)))
+    s.mux.HandleFunc("GET /verify/hash/{hash}", s.loggingMiddleware(s.guard(groupPublic, s.verifyHashHandler)))
+    s.mux.HandleFunc("GET /unsubscribe/{token}", s.loggingMiddleware(s.guard(groupPublic, s.unsubscribeHandler)))
+    s.mux.HandleFunc("POST /unsubscribe/{token}", s.loggingMiddleware(s.guard(groupPublic, s.unsubscribeHandler)))
+    s.handleRead("GET /members", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupPublic, s.gzipMiddleware(s.listMembersHandler)))))
+    s.handleRead("GET /members/{email}", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.getMemberHandler)))))
+    s.handleRead("GET /history/{email}", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.memberHistoryHandler)))))
+    s.handleRead("GET /sar/{member}", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.subjectAccessHandler)))))
+    s.handleRead("GET /members/anniversaries", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.anniversariesHandler)))))
+    s.handleRead("GET /members/id/{id}", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.getMemberByIDHandler)))))
+    s.mux.HandleFunc("POST /members", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.createMemberHandler))))
+    s.mux.HandleFunc("PATCH /members/{email}", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.patchMemberHandler))))
+    s.mux.HandleFunc("POST /members/bulk-status", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.bulkStatusHandler))))
+    s.mux.HandleFunc("POST /members/merge", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.mergeHandler))))
+    s.mux.HandleFunc("POST /members/link", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.linkHouseholdHandler))))
+    s.mux.HandleFunc("POST /members/unlink", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.unlinkHouseholdHandler))))
+    s.handleRead("GET /organizations", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.listOrganizationsHandler)))))
+    s.mux.HandleFunc("POST /organizations", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.createOrganizationHandler))))
+    s.mux.HandleFunc("POST /organizations/{org}/members", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.attachOrganizationMemberHandler))))
+    s.mux.HandleFunc("DELETE /organizations/{org}/members/{email}", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.detachOrganizationMemberHandler))))
+    s.mux.HandleFunc("POST /members/{email}/forget", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.forgetHandler))))
+    s.mux.HandleFunc("POST /sync", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.syncHandler))))
+    s.mux.HandleFunc("GET /dashboard", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.basicAuthChallenge(s.adminMiddleware(s.dashboardHandler))))))
+    s.handleRead("GET /events", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.gzipMiddleware(s.eventsHandler)))))
+    s.mux.HandleFunc("GET /events/stream", s.loggingMiddleware(s.adminMiddleware(s.eventStreamHandler)))
+    s.handleRead("GET /webhooks", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.gzipMiddleware(s.listWebhooksHandler))))))
+    s.handleRead("GET /webhooks/failed", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.gzipMiddleware(s.failedWebhooksHandler))))))
+    s.handleRead("GET /statuses/unmapped", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.unmappedStatusesHandler)))))
+    s.mux.HandleFunc("POST /statuses/unmapped", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.mapStatusHandler))))
+    s.handleRead("GET /pending-changes", s.loggingMiddleware(s.accessLogMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.pendingChangesHandler)))))
+    s.mux.HandleFunc("POST /pending-changes/{id}/approve", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.approvePendingChangeHandler))))
+    s.mux.HandleFunc("POST /pending-changes/approve-all", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.approveAllPendingChangesHandler))))
+    s.mux.HandleFunc("POST /pending-changes/{id}/reject", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.rejectPendingChangeHandler))))
+    s.handleRead("GET /subscriptions", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.listSubscriptionsHandler))))
+    s.mux.HandleFunc("POST /subscriptions", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.createSubscriptionHandler))))
+    s.mux.HandleFunc("DELETE /subscriptions/{id}", s.loggingMiddleware(s.guard(groupAdmin, s.adminMiddleware(s.deleteSubscriptionHandler))))
+    s.mux.HandleFunc("GET /admin/maintenance", s.loggingMiddleware(s.adminMiddleware(s.maintenanceHandler)))
+    s.mux.HandleFunc("POST /admin/maintenance", s.loggingMiddleware(s.adminMiddleware(s.maintenanceHandler)))
+    s.mux.HandleFunc("GET /admin/jobs", s.loggingMiddleware(s.adminMiddleware(s.listJobsHandler)))
+    s.mux.HandleFunc("POST /admin/jobs/{name}/run", s.loggingMiddleware(s.adminMiddleware(s.runJobHandler)))
+    if s.config.DebugEndpoints {
+        s.registerDebug()
+    }
 }
 
 // loggingMiddleware logs all HTTP requests
 func (s *WebhookServer) loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         start := time.Now()
-        logger.Printf("%s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+        s.logger.Printf("%s %s from %s", r.Method, r.URL.Path, s.clientIP(r))
         next(w, r)
-        logger.Printf("Request completed in %v", time.Since(start))
+        s.logger.Printf("Request completed in %v", time.Since(start))
+    }
+}
+
+// adminMiddleware rejects requests that don't carry the admin token
+func (s *WebhookServer) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if s.authorizeAdmin(w, r) {
+            next(w, r)
+        }
     }
 }
 
+// authorizeAdmin reports whether the request carries the admin token, and
+// answers it with an error if not
+func (s *WebhookServer) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+    if len(s.config.AdminTokens) == 0 {
+        writeError(w, r, http.StatusForbidden, errForbidden, "Admin API disabled")
+        return false
+    }
+    
+    if !s.isAdmin(r) {
+        s.logger.Printf("Unauthorized admin attempt from %s", s.clientIP(r))
+        writeError(w, r, http.StatusUnauthorized, errUnauthorized, "Unauthorized")
+        return false
+    }
+    
+    return true
+}
+
 // healthHandler returns server health status
 func (s *WebhookServer) healthHandler(w http.ResponseWriter, r *http.Request) {
     dbStatus := "ok"
-    if err := s.db.HealthCheck(); err != nil {
-        dbStatus = fmt.Sprintf("error: %v", err)
+    dbErr := s.db.HealthCheck()
+    if dbErr != nil {
+        dbStatus = fmt.Sprintf("error: %v", dbErr)
     }
     
     response := map[string]interface{}{
-        "status":    "ok",
-        "timestamp": time.Now().Format(time.RFC3339),
-        "database":  dbStatus,
+        "status":             "ok",
+        "timestamp":          time.Now().Format(time.RFC3339),
+        "database":           dbStatus,
+        "version":            version,
+        "panics":             s.panics.Load(),
+        "in_flight":          s.inFlight(),
+        "maintenance":        s.maintenance.Load(),
+        "access_log_dropped": s.accessLog.dropped.Load(),
     }
     
+    // Report a database outage as an error, keeping the details above
     w.Header().Set("Content-Type", "application/json")
+    if dbErr != nil {
+        response["status"] = "error"
+        response["error"] = apiError{Code: errUnavailable, Message: "Database unavailable", RequestID: requestID(r)}
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
     json.NewEncoder(w).Encode(response)
 }
 
 // statsHandler returns membership statistics
 func (s *WebhookServer) statsHandler(w http.ResponseWriter, r *http.Request) {
-    stats, err := s.db.GetStats()
+    // The 90-day overdue count moves with the calendar, and webhook counts
+    // and the last sync live outside the members table
+    detailed := r.URL.Query().Get("detailed") == "1" || r.URL.Query().Get("detailed") == "true"
+    extra := []string{time.Now().Format("2006-01-02"), strconv.Itoa(s.db.LatestWebhookLogID()), strconv.FormatBool(detailed)}
+    if last := s.scheduler.Last(); last != nil {
+        extra = append(extra, last.FinishedAt.String())
+    }
+    if s.notModified(w, r, extra...) {
+        return
+    }
+    
+    stats, err := s.db.GetStats(r.Context())
     if err != nil {
-        logger.Printf("Error getting stats: %v", err)
-        http.Error(w, "Internal server error", http.StatusInternalServerError)
+        s.logger.Printf("Error getting stats: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
         return
     }
+    stats.LastSync = s.scheduler.Last()
+    
+    if detailed {
+        if stats.Revenue, err = s.db.GetRevenueStats(r.Context()); err != nil {
+            s.logger.Printf("Error getting revenue stats: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+        if stats.Campaigns, err = s.db.GetCampaignStats(r.Context()); err != nil {
+            s.logger.Printf("Error getting campaign stats: %v", err)
+            writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
+            return
+        }
+    }
     
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(stats)
@@ -82,113 +253,612 @@ func (s *WebhookServer) statsHandler(w http.ResponseWriter, r *http.Request) {
 
 // webhookHandler processes incoming webhooks from Zapier
 func (s *WebhookServer) webhookHandler(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    // Check authorization
+    source, ok := s.webhookSource(r)
+    if !ok {
+        s.logger.Printf("Unauthorized webhook attempt from %s", s.clientIP(r))
+        writeError(w, r, http.StatusUnauthorized, errUnauthorized, "Unauthorized")
         return
     }
     
-    // Check authorization
-    if !s.isAuthorized(r) {
-        logger.Printf("Unauthorized webhook attempt from %s", r.RemoteAddr)
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
-        return
+    // A dry run goes through every check but writes nothing beyond a
+    // flagged log row
+    dryRun := isDryRun(r)
+    logWebhook := s.db.LogWebhook
+    if dryRun {
+        logWebhook = s.db.LogDryRunWebhook
     }
     
     // Read body
     body, err := io.ReadAll(r.Body)
     if err != nil {
-        logger.Printf("Error reading body: %v", err)
-        http.Error(w, "Bad request", http.StatusBadRequest)
+        s.logger.Printf("Error reading body: %v", err)
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Bad request")
         return
     }
     defer r.Body.Close()
     
+    // Keep the payload of a webhook that panics before it's logged, so it
+    // can be looked at and replayed
+    logged := false
+    defer func() {
+        if p := recover(); p != nil {
+            if !logged {
+                logWebhook("", "", source, body)
+            }
+            panic(p)
+        }
+    }()
+    
     // Parse webhook
     var webhook MemberWebhook
     if err := json.Unmarshal(body, &webhook); err != nil {
-        logger.Printf("Error parsing JSON: %v", err)
-        logger.Printf("Raw body: %s", string(body))
-        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+        s.logger.Printf("Error parsing JSON: %v", err)
+        if s.config.Privacy == nil {
+            s.logger.Printf("Raw body: %s", string(body))
+        }
+        s.recordFailure(r, source, 0, body, failureBadJSON, err)
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid JSON")
         return
     }
     
-    logger.Printf("Webhook received - Email: %s, Status: %s, Anonymous: %s", 
-        webhook.Email, webhook.Status, webhook.Anonymous)
+    // Privacy mode keeps addresses out of the log too
+    logEmail := webhook.Email
+    if s.config.Privacy != nil {
+        logEmail = s.db.NormalizeEmail(logEmail)
+    }
+    s.logger.Printf("Webhook received from %s - Email: %s, Status: %s, Anonymous: %s", 
+        source, logEmail, webhook.Status, webhook.Anonymous)
     
-    // Process the webhook
-    status := s.convertStatus(webhook.Status)
-    isAnonymous := s.convertAnonymous(webhook.Anonymous)
+    payment := s.mapPaymentStatus(webhook.Status)
     
-    // Log webhook for debugging
-    if err := s.db.LogWebhook(webhook.Email, status, body); err != nil {
-        logger.Printf("Warning: Failed to log webhook: %v", err)
+    // Log webhook for debugging; the row also backs the retry queue
+    logID, err := logWebhook(webhook.Email, payment.status, source, body)
+    if err != nil {
+        s.logger.Printf("Warning: Failed to log webhook: %v", err)
     }
+    logged = true
     
-    // Process member
-    if err := s.db.ProcessMember(webhook.Email, webhook.Name, isAnonymous, status); err != nil {
-        logger.Printf("Error processing member: %v", err)
-        // Still return 200 to prevent retries
+    // Reject addresses that can't be an email (mis-mapped Zapier fields)
+    if err := validateEmail(webhook.Email); err != nil {
+        s.logger.Printf("Rejecting webhook: %v", err)
+        s.recordFailure(r, source, logID, body, failureMissingEmail, err)
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, "Invalid email")
+        return
     }
     
-    w.WriteHeader(http.StatusOK)
-    fmt.Fprint(w, "OK")
+    if dryRun {
+        result, err := s.previewWebhook(webhook, payment, time.Now(), webhookChange(source, logID))
+        s.writeDryRun(w, r, webhook.Email, result, err)
+        return
+    }
+    
+    // During maintenance the payload is kept and applied afterwards
+    if s.maintenance.Load() {
+        if logID == 0 || s.db.DeferWebhook(logID) != nil {
+            w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+            writeError(w, r, http.StatusServiceUnavailable, errUnavailable, "Down for maintenance, try again shortly")
+            return
+        }
+        s.writeWebhookOutcome(w, http.StatusAccepted, webhook.Email, actionDeferred,
+            "DEFERRED: received during maintenance, will be processed afterwards")
+        return
+    }
+    
+    result, err := s.applyWebhook(webhook, payment, time.Now(), webhookChange(source, logID))
+    if err != nil {
+        // An out-of-order event is expected, not an error
+        if errors.Is(err, ErrStaleEvent) {
+            s.logger.Printf("STALE EVENT: skipping %v", err)
+            if logID > 0 {
+                if serr := s.db.SkipWebhook(logID, skipReasonStale); serr != nil {
+                    s.logger.Printf("Warning: Failed to record skipped webhook %d: %v", logID, serr)
+                }
+            }
+            s.writeWebhookOutcome(w, http.StatusOK, webhook.Email, actionSkipped, fmt.Sprintf("SKIPPED: %v", err))
+            return
+        }
+        
+        s.logger.Printf("Error processing member: %v", err)
+        
+        // Transient failures are retried in the background from the log row
+        if logID > 0 && isRetryableError(err) {
+            qerr := s.db.QueueWebhookRetry(logID, err)
+            if qerr == nil {
+                s.writeWebhookOutcome(w, http.StatusAccepted, webhook.Email, actionQueued,
+                    fmt.Sprintf("QUEUED: temporary failure, the server will retry automatically: %v", err))
+                return
+            }
+            s.logger.Printf("Warning: Failed to queue webhook %d for retry: %v", logID, qerr)
+        }
+        
+        s.recordFailure(r, source, logID, body, classifyWebhookError(err), err)
+        s.writeProcessingError(w, r, webhook.Email, err)
+        return
+    }
+    
+    // Zaps can branch on the result, e.g. to welcome new members; anything
+    // checking only for a 2xx keeps working
+    code := http.StatusOK
+    if result.Action == actionCreated {
+        code = http.StatusCreated
+    }
+    s.writeWebhookResult(w, code, result)
 }
 
-// listMembersHandler returns a list of members
+// listMembersHandler returns a list of members. The legacy route returns the
+// 100 most recently updated as a bare array; /v1 pages through all of them
+// with limit and offset. Either returns every match as CSV or JSON Lines
+// when an admin asks for it.
 func (s *WebhookServer) listMembersHandler(w http.ResponseWriter, r *http.Request) {
-    status := r.URL.Query().Get("status")
+    query := r.URL.Query()
+    filter := MemberFilter{
+        Status:    query.Get("status"),
+        Tag:       query.Get("tag"),
+        Frequency: strings.ToLower(query.Get("frequency")),
+        Campaign:  query.Get("campaign"),
+        Limit:     defaultMembersLimit,
+    }
+    if filter.Frequency != "" && !validFrequency(filter.Frequency) {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, "frequency must be monthly, quarterly, annual, or other")
+        return
+    }
+    
+    version := versionOf(r)
+    if version >= apiV1 {
+        if value := query.Get("limit"); value != "" {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 {
+                writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid limit")
+                return
+            }
+            filter.Limit = min(n, maxMembersLimit)
+        }
+        if value := query.Get("offset"); value != "" {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 0 {
+                writeError(w, r, http.StatusBadRequest, errInvalidPayload, "Invalid offset")
+                return
+            }
+            filter.Offset = n
+        }
+    }
+    
+    // The same URL serves JSON, CSV, or JSON Lines depending on Accept. The
+    // exports hold every matching member's details, so they're admin-only.
+    format := exportFormatOf(r)
+    if format != exportJSON && !s.authorizeAdmin(w, r) {
+        return
+    }
+    fields, err := parseExportFields(r.URL.Query().Get("fields"), false)
+    if err != nil {
+        writeError(w, r, http.StatusBadRequest, errInvalidPayload, err.Error())
+        return
+    }
+    w.Header().Add("Vary", "Accept")
+    if s.notModified(w, r, format, r.URL.Query().Get("fields")) {
+        return
+    }
+    
+    // CSV and JSON Lines are exports: everything matching the filters, unpaged
+    if format != exportJSON {
+        filter.Limit, filter.Offset = 0, 0
+        s.writeMembersExportResponse(w, r, filter, format, fields)
+        return
+    }
     
-    members, err := s.db.GetMembers(status, 100)
+    // Ask for one extra to tell whether there's another page
+    page := filter
+    page.Limit++
+    members, err := s.db.GetMembers(page)
     if err != nil {
-        logger.Printf("Error getting members: %v", err)
-        http.Error(w, "Internal server error", http.StatusInternalServerError)
+        s.logger.Printf("Error getting members: %v", err)
+        writeError(w, r, http.StatusInternalServerError, errInternal, "Internal server error")
         return
     }
+    hasMore := len(members) > filter.Limit
+    members = members[:min(len(members), filter.Limit)]
     
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(members)
+    if version == apiLegacy {
+        var response []map[string]interface{}
+        for i := range members {
+            response = append(response, memberResponse(&members[i]))
+        }
+        json.NewEncoder(w).Encode(response)
+        return
+    }
+    
+    response := apiMemberPage{Members: []apiMember{}, Limit: filter.Limit, Offset: filter.Offset, HasMore: hasMore}
+    for i := range members {
+        response.Members = append(response.Members, newAPIMember(&members[i]))
+    }
+    json.NewEncoder(w).Encode(response)
 }
 
-// isAuthorized checks if the request has valid authentication
-func (s *WebhookServer) isAuthorized(r *http.Request) bool {
-    authHeader := r.Header.Get("Authorization")
+// applyWebhook applies a parsed webhook to the database and reports what it
+// did. The HTTP handler and the retry worker both go through it so retries
+// follow the same rules. payment is mapPaymentStatus of the webhook's status.
+// receivedAt is when the webhook first arrived and is used as the payment time.
+func (s *WebhookServer) applyWebhook(webhook MemberWebhook, payment paymentStatus, receivedAt time.Time, change ChangeSource) (*ProcessResult, error) {
+    return s.processWebhook(webhook, payment, receivedAt, change, false)
+}
+
+// previewWebhook reports what applyWebhook would do, making the same
+// decisions from the current rows without writing anything
+func (s *WebhookServer) previewWebhook(webhook MemberWebhook, payment paymentStatus, receivedAt time.Time, change ChangeSource) (*ProcessResult, error) {
+    return s.processWebhook(webhook, payment, receivedAt, change, true)
+}
+
+// processWebhook is applyWebhook, or previewWebhook when dryRun is set
+func (s *WebhookServer) processWebhook(webhook MemberWebhook, payment paymentStatus, receivedAt time.Time, change ChangeSource, dryRun bool) (*ProcessResult, error) {
+    // Statuses neither the rules nor a stored mapping cover are counted so
+    // they can be mapped
+    if !payment.mapped && unmappedKey(webhook.Status) != "" && !dryRun {
+        if err := s.db.RecordUnmappedValue(unmappedKindStatus, webhook.Status, 1); err != nil {
+            s.logger.Printf("Warning: %v", err)
+        }
+    }
+    
+    status := payment.status
+    if status == "" {
+        return nil, fmt.Errorf("%w: unexpected payment status %q", ErrUnknownStatus, webhook.Status)
+    }
+    isAnonymous := s.convertAnonymous(webhook.Anonymous)
+    
+    // With an event time, ProcessMember refuses events older than the last
+    // one applied (ErrStaleEvent), since Zapier doesn't guarantee delivery
+    // order. Without one, only a retry is checked, against the arrival of
+    // the last delivery applied.
+    occurredAt := receivedAt
+    change.ReceivedAt = receivedAt
+    if webhook.EventTime != "" {
+        eventTime, err := parseEventTime(webhook.EventTime)
+        if err != nil {
+            s.logger.Printf("Warning: Ignoring event time for %s: %v", webhook.Email, err)
+        } else {
+            occurredAt = eventTime
+            change.EventTime = eventTime
+        }
+    }
+    
+    // An organization's payment changes the organization, not the payer
+    if strings.TrimSpace(webhook.Organization) != "" {
+        if dryRun {
+            return s.previewOrganizationWebhook(webhook, status)
+        }
+        return s.applyOrganizationWebhook(webhook, status, occurredAt, change)
+    }
+    
+    // The prior status decides how a failed payment is treated
+    previous, _, err := s.db.GetMemberStatus(webhook.Email)
+    if err != nil {
+        return nil, err
+    }
+    
+    // A failed payment suspends the member; FailedPaymentLimit failures in a
+    // row cancel them. Cancelled members stay cancelled. Outside a dry run
+    // ProcessFailedPayment counts the failure and decides in one transaction.
+    failedPayment := payment.failed
+    if failedPayment && dryRun {
+        failures, err := s.db.FailedPaymentCount(webhook.Email)
+        if err != nil {
+            return nil, err
+        }
+        status = failedPaymentStatus(previous, failures+1, s.config.FailedPaymentLimit)
+    }
+    
+    // Household members take their status from the primary member
+    if primary, linked, err := s.db.HouseholdPrimary(webhook.Email); err != nil {
+        s.logger.Printf("Warning: Failed to check household for %s: %v", webhook.Email, err)
+    } else if linked && status != previous {
+        s.logger.Printf("HOUSEHOLD MEMBER: not setting %s to %s from webhook (payment status %q); it follows %s",
+            webhook.Email, status, webhook.Status, primary)
+        return s.unchangedResult(webhook.Email, previous), nil
+    }
     
-    // Check Bearer token
-    if authHeader == "Bearer " + s.config.WebhookSecret {
+    // Protected members (comps, board, lifetime) are never auto-deactivated
+    if status != StatusActive {
+        protected, err := s.db.IsProtected(webhook.Email)
+        if err != nil {
+            s.logger.Printf("Warning: Failed to check protection for %s: %v", webhook.Email, err)
+        } else if protected {
+            s.logger.Printf("PROTECTED MEMBER: refusing to set %s to %s from webhook (payment status %q); review manually",
+                webhook.Email, status, webhook.Status)
+            return s.unchangedResult(webhook.Email, previous), nil
+        }
+    }
+    
+    if dryRun {
+        return s.db.PreviewMember(webhook.Email, isAnonymous, status, change)
+    }
+    
+    // Process member
+    var result *ProcessResult
+    if failedPayment {
+        result, err = s.db.ProcessFailedPayment(webhook.Email, webhook.Name, isAnonymous, s.config.FailedPaymentLimit, change)
+    } else {
+        result, err = s.db.ProcessMember(webhook.Email, webhook.Name, isAnonymous, status, change)
+    }
+    if err != nil {
+        return nil, err
+    }
+    
+    if result.Action == actionCreated && webhook.Campaign != "" {
+        if err := s.db.SetMemberCampaign(webhook.Email, webhook.Campaign); err != nil {
+            s.logger.Printf("Warning: %v", err)
+        }
+    }
+    
+    // A refund takes the original donation out of revenue
+    if isRefund(webhook.Status) && webhook.DonationID != "" {
+        if found, err := s.db.MarkDonationRefunded(strings.TrimSpace(webhook.DonationID), occurredAt); err != nil {
+            s.logger.Printf("Warning: Failed to mark donation refunded: %v", err)
+        } else if !found {
+            s.logger.Printf("Refund for unknown donation %s from %s", webhook.DonationID, webhook.Email)
+        }
+    }
+    
+    if eventType, ok := memberEventFor(result.Action != actionCreated, result.PreviousStatus, result.Status); ok {
+        s.notifier.Notify(MemberEvent{
+            Type:        eventType,
+            Email:       result.Email,
+            Name:        webhook.Name,
+            IsAnonymous: result.IsAnonymous,
+        })
+    }
+    
+    if status == StatusActive {
+        // A success status means a payment just went through
+        if err := s.db.RecordPayment(webhook.Email, receivedAt); err != nil {
+            s.logger.Printf("Warning: Failed to record payment: %v", err)
+        }
+        if webhook.Amount != "" {
+            s.recordWebhookDonation(webhook, occurredAt, change)
+        }
+    }
+    if webhook.Frequency != "" {
+        if err := s.db.SetMemberFrequency(webhook.Email, webhook.Frequency); err != nil {
+            s.logger.Printf("Warning: Failed to record frequency: %v", err)
+        }
+        if !knownFrequency(webhook.Frequency) {
+            if err := s.db.RecordUnmappedValue(unmappedKindFrequency, webhook.Frequency, 1); err != nil {
+                s.logger.Printf("Warning: %v", err)
+            }
+        }
+    }
+    if webhook.EmailOptIn != "" {
+        if optIn, ok := parseOptIn(webhook.EmailOptIn); !ok {
+            s.logger.Printf("Warning: Ignoring unrecognized email_opt_in %q for %s", webhook.EmailOptIn, webhook.Email)
+        } else if _, err := s.db.SetEmailConsent(webhook.Email, optIn, change); err != nil {
+            s.logger.Printf("Warning: Failed to record email consent: %v", err)
+        }
+    }
+    
+    // Queued after the consent above so an opt-out in the same webhook counts
+    s.memberMail.Queue(result)
+    
+    return result, nil
+}
+
+// webhookStateDryRun flags the log row of a dry-run webhook, which is never
+// retried or counted as received
+const webhookStateDryRun = "dry_run"
+
+// isDryRun reports whether a webhook asked to be checked without being
+// applied, with an X-Dry-Run: true header or ?dry_run=1
+func isDryRun(r *http.Request) bool {
+    switch strings.ToLower(r.URL.Query().Get("dry_run")) {
+    case "1", "true":
         return true
     }
+    return strings.EqualFold(r.Header.Get("X-Dry-Run"), "true")
+}
+
+// writeWebhookOutcome answers a webhook that wasn't applied with the same
+// JSON shape as one that was, so Zaps can branch on "result" either way
+func (s *WebhookServer) writeWebhookOutcome(w http.ResponseWriter, code int, email, action, message string) {
+    s.writeWebhookResult(w, code, &ProcessResult{Action: action, Email: s.db.NormalizeEmail(email), Message: message})
+}
+
+// writeWebhookResult sends a webhook's JSON result
+func (s *WebhookServer) writeWebhookResult(w http.ResponseWriter, code int, result *ProcessResult) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    json.NewEncoder(w).Encode(result)
+}
+
+// writeDryRun answers a dry run with the result the webhook would have had,
+// or the error that would have stopped it
+func (s *WebhookServer) writeDryRun(w http.ResponseWriter, r *http.Request, email string, result *ProcessResult, err error) {
+    switch {
+    case errors.Is(err, ErrStaleEvent):
+        s.writeWebhookResult(w, http.StatusOK, &ProcessResult{
+            Action:  actionSkipped,
+            Email:   s.db.NormalizeEmail(email),
+            Message: fmt.Sprintf("DRY RUN: would be skipped: %v", err),
+            DryRun:  true,
+        })
+        return
+    case err != nil && isRetryableError(err):
+        writeError(w, r, http.StatusInternalServerError, errInternal, fmt.Sprintf("DRY RUN: would fail temporarily: %v", err))
+        return
+    case err != nil:
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, fmt.Sprintf("DRY RUN: would be rejected: %v", err))
+        return
+    }
+    
+    result.DryRun = true
+    s.writeWebhookResult(w, http.StatusOK, result)
+}
+
+// unchangedResult describes a webhook that left the member as they were
+func (s *WebhookServer) unchangedResult(email, status string) *ProcessResult {
+    result := &ProcessResult{Action: actionUnchanged, Email: s.db.NormalizeEmail(email), PreviousStatus: status, Status: status}
+    if m, err := s.db.GetMemberByEmail(email); err == nil {
+        result.MemberID = m.PublicID
+        result.IsAnonymous = m.IsAnonymous
+    }
+    return result
+}
+
+// writeProcessingError reports a failed webhook. By default it returns 200 so
+// Zapier doesn't retry; in fail-hard mode (WEBHOOK_FAIL_HARD or an
+// X-Webhook-Fail-Hard: true header) transient failures return 500 so Zapier
+// retries, and permanent ones return 422 so it doesn't. The body says which
+// happened so the Zapier task history explains itself.
+func (s *WebhookServer) writeProcessingError(w http.ResponseWriter, r *http.Request, email string, err error) {
+    failHard := s.config.WebhookFailHard || strings.EqualFold(r.Header.Get("X-Webhook-Fail-Hard"), "true")
+    retryable := isRetryableError(err)
+    
+    switch {
+    case s.config.StrictStatus && errors.Is(err, ErrUnknownStatus):
+        // Held for review whatever WEBHOOK_FAIL_HARD says, so a status
+        // nobody has mapped never looks processed
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, fmt.Sprintf("HELD FOR REVIEW: %v; map it with memberships statuses map", err))
+    case !failHard:
+        s.writeWebhookOutcome(w, http.StatusOK, email, actionNotProcessed,
+            fmt.Sprintf("NOT PROCESSED: %v (returned 200 so this will not be retried; set WEBHOOK_FAIL_HARD to enable retries)", err))
+    case retryable:
+        writeError(w, r, http.StatusInternalServerError, errInternal, fmt.Sprintf("RETRYABLE: temporary failure, please retry: %v", err))
+    default:
+        writeError(w, r, http.StatusUnprocessableEntity, errInvalidPayload, fmt.Sprintf("REJECTED: permanent failure, do not retry: %v", err))
+    }
+}
+
+// webhookSource authenticates a webhook and returns the name of the source
+// whose secret it presented
+func (s *WebhookServer) webhookSource(r *http.Request) (string, bool) {
+    source, index := s.matchWebhookSecret(r)
+    if index < 0 {
+        return "", false
+    }
+    
+    // During a rotation, show which secret callers still use
+    if len(s.config.WebhookSources[source]) > 1 {
+        s.logger.Printf("Webhook from source %s authenticated with secret #%d", source, index+1)
+    }
+    return source, true
+}
+
+// matchWebhookSecret returns the source and position within that source's
+// list of the secret the request presents, or an index of -1
+func (s *WebhookServer) matchWebhookSecret(r *http.Request) (string, int) {
+    var candidates []string
+    
+    authHeader := r.Header.Get("Authorization")
+    
+    // Bearer token
+    if strings.HasPrefix(authHeader, "Bearer ") {
+        candidates = append(candidates, strings.TrimPrefix(authHeader, "Bearer "))
+    }
     
-    // Check Basic auth (from Zapier)
+    // Basic auth (from Zapier), with the secret as username or password
     if strings.HasPrefix(authHeader, "Basic ") {
         payload, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(authHeader, "Basic "))
         parts := strings.SplitN(string(payload), ":", 2)
-        if len(parts) == 2 && (parts[1] == s.config.WebhookSecret || parts[0] == s.config.WebhookSecret) {
-            return true
+        if len(parts) == 2 {
+            candidates = append(candidates, parts[1], parts[0])
         }
     }
     
-    // Check custom header
-    if r.Header.Get("X-Webhook-Secret") == s.config.WebhookSecret {
-        return true
+    // Custom header
+    if header := r.Header.Get("X-Webhook-Secret"); header != "" {
+        candidates = append(candidates, header)
+    }
+    
+    for _, candidate := range candidates {
+        for source, secrets := range s.config.WebhookSources {
+            if index := matchSecret(candidate, secrets); index >= 0 {
+                return source, index
+            }
+        }
+    }
+    return "", -1
+}
+
+// matchSecret compares candidate against every secret in constant time and
+// returns the index of the match, or -1. Empty candidates never match.
+func matchSecret(candidate string, secrets []string) int {
+    match := -1
+    if candidate == "" {
+        return match
+    }
+    for i, secret := range secrets {
+        if subtle.ConstantTimeCompare([]byte(candidate), []byte(secret)) == 1 && match < 0 {
+            match = i
+        }
+    }
+    return match
+}
+
+// isAdmin checks if the request carries an admin bearer token
+func (s *WebhookServer) isAdmin(r *http.Request) bool {
+    index := s.adminTokenIndex(r)
+    if index >= 0 && len(s.config.AdminTokens) > 1 {
+        s.logger.Printf("Admin request authenticated with token #%d", index+1)
+    }
+    return index >= 0
+}
+
+// adminTokenIndex returns which ADMIN_TOKENS entry the request's bearer
+// token matches, or -1. Browsers may send the token as the Basic auth
+// password instead.
+func (s *WebhookServer) adminTokenIndex(r *http.Request) int {
+    if _, password, ok := r.BasicAuth(); ok {
+        return matchSecret(password, s.config.AdminTokens)
     }
     
-    return false
+    authHeader := r.Header.Get("Authorization")
+    if !strings.HasPrefix(authHeader, "Bearer ") {
+        return -1
+    }
+    return matchSecret(strings.TrimPrefix(authHeader, "Bearer "), s.config.AdminTokens)
 }
 
-// convertStatus converts Zapier's payment status to membership status
-func (s *WebhookServer) convertStatus(zapierStatus string) string {
-    statusLower := strings.ToLower(zapierStatus)
+// paymentStatus is what a webhook's payment status maps to, worked out
+// once per webhook
+type paymentStatus struct {
+    // status is the membership status, or "" for a status not recognized
+    // in strict mode. A failed charge is suspended; applyWebhook cancels
+    // only after FailedPaymentLimit failures in a row, since GiveLively
+    // retries failed charges.
+    status string
     
-    if strings.Contains(statusLower, "succeed") || strings.Contains(statusLower, "success") || strings.Contains(statusLower, "active") {
-        return "active"
-    } else if strings.Contains(statusLower, "fail") || strings.Contains(statusLower, "cancel") || strings.Contains(statusLower, "refund") {
-        return "cancelled"
-    } else if strings.Contains(statusLower, "suspend") || strings.Contains(statusLower, "pend") {
-        return "suspended"
+    // failed is set for a failed charge
+    failed bool
+    
+    // mapped is set when a status rule or stored mapping covers the status,
+    // rather than the fallback
+    mapped bool
+}
+
+// mapPaymentStatus applies the status rules to Zapier's payment status, then
+// any mapping made since with "memberships statuses map". When neither
+// covers it the status is "" in strict mode, or the STATUS_FALLBACK target.
+func (s *WebhookServer) mapPaymentStatus(zapierStatus string) paymentStatus {
+    var status string
+    payment := paymentStatus{mapped: true}
+    if rule, ok := matchStatusRule(s.config.StatusRules, zapierStatus); ok {
+        status = rule.Status
+    } else if mapped, err := s.db.StatusMapping(zapierStatus); err == nil && mapped != "" {
+        status = mapped
+    } else {
+        if err != nil {
+            s.logger.Printf("Warning: %v", err)
+        }
+        payment.mapped = false
+        if !s.config.StrictStatus {
+            s.logger.Printf("Unexpected status '%s', using fallback '%s'", zapierStatus, s.config.StatusFallback)
+            status = s.config.StatusFallback
+        }
     }
     
-    logger.Printf("Unexpected status '%s', defaulting to 'active'", zapierStatus)
-    return "active"
+    payment.status = status
+    if status == statusFailed {
+        payment.status, payment.failed = StatusSuspended, true
+    }
+    return payment
 }
 
 // convertAnonymous converts Zapier's anonymous string to boolean
@@ -0,0 +1,832 @@
+package main
+
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// Credentials newTestServer accepts
+const (
+    testAdminToken    = "test-admin-token"
+    testWebhookSecret = "test-webhook-secret"
+)
+
+// testConfig is the configuration LoadConfig gives an empty environment,
+// plus a webhook secret and an admin token
+func testConfig() *Config {
+    return &Config{
+        Port:                  "3000",
+        PublicURL:             defaultPublicURL,
+        RequestTimeout:        defaultRequestTimeout,
+        MaxConcurrentRequests: defaultMaxConcurrentRequests,
+        DisplayZone:           time.UTC,
+        WebhookSources:        map[string][]string{"default": {testWebhookSecret}},
+        AdminTokens:           []string{testAdminToken},
+        LapseGraceDays:        defaultLapseGraceDays,
+        FailedPaymentLimit:    defaultFailedPaymentLimit,
+        StatusRules:           defaultStatusRules,
+        StatusFallback:        StatusUnknown,
+        VerifyStatuses:        []string{StatusActive},
+
+        PendingMaxDeactivatePercent: defaultMaxDeactivatePercent,
+    }
+}
+
+// newTestServer serves the API from a fresh memStore. A nil config means
+// testConfig().
+func newTestServer(t *testing.T, config *Config) (*httptest.Server, *memStore) {
+    t.Helper()
+    if config == nil {
+        config = testConfig()
+    }
+
+    db := newMemStore()
+    db.NormalizeEmails = config.NormalizeEmails
+    db.StrictTransitions = config.StrictTransitions
+    db.CountHouseholds = config.CountHouseholds
+    return serveTestStore(t, db, config), db
+}
+
+// serveTestStore serves the webhook server's routes over db
+func serveTestStore(t *testing.T, db Store, config *Config) *httptest.Server {
+    t.Helper()
+    s := NewWebhookServer(db, config, log.New(io.Discard, "", 0))
+    s.routes()
+
+    server := httptest.NewServer(s.mux)
+    t.Cleanup(func() {
+        server.Close()
+        s.accessLog.close()
+    })
+    return server
+}
+
+// do sends a request with an optional JSON body and bearer token
+func do(t *testing.T, server *httptest.Server, method, path, token, body string) *http.Response {
+    t.Helper()
+
+    var reader io.Reader
+    if body != "" {
+        reader = strings.NewReader(body)
+    }
+    req, err := http.NewRequest(method, server.URL+path, reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    if body != "" {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    resp, err := server.Client().Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { resp.Body.Close() })
+    return resp
+}
+
+// postWebhook sends a webhook payload with the test secret
+func postWebhook(t *testing.T, server *httptest.Server, payload string) *http.Response {
+    t.Helper()
+    return do(t, server, "POST", "/webhook", testWebhookSecret, payload)
+}
+
+// decode reads a JSON response into v
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+    t.Helper()
+    if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+        t.Fatalf("decoding %s response: %v", resp.Request.URL.Path, err)
+    }
+}
+
+// expectStatus fails the test if resp doesn't have the wanted status code
+func expectStatus(t *testing.T, resp *http.Response, want int) {
+    t.Helper()
+    if resp.StatusCode != want {
+        body, _ := io.ReadAll(resp.Body)
+        t.Fatalf("%s %s: got %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, want, body)
+    }
+}
+
+func TestHealth(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    resp := do(t, server, "GET", "/health", "", "")
+    expectStatus(t, resp, http.StatusOK)
+    var health map[string]interface{}
+    decode(t, resp, &health)
+    if health["database"] != "ok" {
+        t.Errorf("database = %v, want ok", health["database"])
+    }
+
+    db.Healthy = errors.New("connection refused")
+    resp = do(t, server, "GET", "/health", "", "")
+    expectStatus(t, resp, http.StatusServiceUnavailable)
+}
+
+func TestWebhookRequiresSecret(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    for _, token := range []string{"", "wrong-secret"} {
+        resp := do(t, server, "POST", "/webhook", token, `{"email":"a@example.org","status":"Succeeded"}`)
+        expectStatus(t, resp, http.StatusUnauthorized)
+    }
+    if len(db.members) != 0 {
+        t.Errorf("unauthorized webhooks created %d members", len(db.members))
+    }
+}
+
+func TestWebhookCreatesAndUpdates(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    resp := postWebhook(t, server, `{"email":"Ada@Example.org","name":"Ada","status":"Succeeded","anonymous":"False"}`)
+    expectStatus(t, resp, http.StatusCreated)
+    var created ProcessResult
+    decode(t, resp, &created)
+    if created.Action != actionCreated || created.Email != "ada@example.org" || created.Status != StatusActive {
+        t.Errorf("create: got %+v", created)
+    }
+    if !isUUID(created.MemberID) {
+        t.Errorf("create: member id %q is not a UUID", created.MemberID)
+    }
+
+    resp = postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`)
+    expectStatus(t, resp, http.StatusOK)
+    var unchanged ProcessResult
+    decode(t, resp, &unchanged)
+    if unchanged.Action != actionUnchanged || unchanged.MemberID != created.MemberID {
+        t.Errorf("repeat: got %+v", unchanged)
+    }
+
+    resp = postWebhook(t, server, `{"email":"ada@example.org","status":"Cancelled"}`)
+    expectStatus(t, resp, http.StatusOK)
+    var cancelled ProcessResult
+    decode(t, resp, &cancelled)
+    if cancelled.Action != actionUpdated || cancelled.PreviousStatus != StatusActive || cancelled.Status != StatusCancelled {
+        t.Errorf("cancel: got %+v", cancelled)
+    }
+
+    history, _ := db.GetStatusHistory("ada@example.org", 10)
+    if len(history) != 2 || history[0].Status != StatusCancelled || history[0].Source != "webhook" {
+        t.Errorf("history = %+v, want cancelled by webhook then active", history)
+    }
+    if len(db.webhookLogs) != 3 {
+        t.Errorf("logged %d webhooks, want 3", len(db.webhookLogs))
+    }
+}
+
+func TestWebhookRejectsInvalidPayloads(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    tests := []struct {
+        name    string
+        payload string
+        want    int
+    }{
+        {"bad JSON", `{"email":`, http.StatusBadRequest},
+        {"missing email", `{"status":"Succeeded"}`, http.StatusUnprocessableEntity},
+        {"not an email", `{"email":"Ada Lovelace","status":"Succeeded"}`, http.StatusUnprocessableEntity},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            expectStatus(t, postWebhook(t, server, tt.payload), tt.want)
+        })
+    }
+
+    if len(db.members) != 0 {
+        t.Errorf("invalid webhooks created %d members", len(db.members))
+    }
+    if len(db.failures) != len(tests) {
+        t.Errorf("recorded %d failures, want %d", len(db.failures), len(tests))
+    }
+}
+
+func TestWebhookSkipsStaleEvents(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    resp := postWebhook(t, server, `{"email":"ada@example.org","status":"Cancelled","event_time":"2026-03-02T00:00:00Z"}`)
+    expectStatus(t, resp, http.StatusCreated)
+
+    resp = postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded","event_time":"2026-03-01T00:00:00Z"}`)
+    expectStatus(t, resp, http.StatusOK)
+    var result ProcessResult
+    decode(t, resp, &result)
+    if result.Action != actionSkipped || result.Email != "ada@example.org" || !strings.HasPrefix(result.Message, "SKIPPED: ") {
+        t.Errorf("stale webhook: got %+v", result)
+    }
+
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusCancelled {
+        t.Errorf("status = %s, want the newer event's cancelled", status)
+    }
+    if last := db.webhookLogs[len(db.webhookLogs)-1]; last.State != webhookStateSkipped || last.SkipReason != skipReasonStale {
+        t.Errorf("stale webhook log = %s/%s, want skipped as stale", last.State, last.SkipReason)
+    }
+}
+
+// A failure that arrives after a newer success (a retried delivery, say)
+// mustn't suspend the member or count toward the failed-payment limit
+func TestWebhookLateFailureKeepsMemberActive(t *testing.T) {
+    config := testConfig()
+    config.FailedPaymentLimit = 2
+    server, db := newTestServer(t, config)
+
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded","event_time":"2026-03-02T00:00:00Z"}`), http.StatusCreated)
+
+    for _, late := range []string{"2026-03-01T00:00:00Z", "2026-03-01T23:59:59Z"} {
+        resp := postWebhook(t, server, `{"email":"ada@example.org","status":"Failed","event_time":"`+late+`"}`)
+        expectStatus(t, resp, http.StatusOK)
+        var result ProcessResult
+        decode(t, resp, &result)
+        if result.Action != actionSkipped {
+            t.Errorf("failure at %s: got %+v", late, result)
+        }
+    }
+
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusActive {
+        t.Errorf("status = %s, want active", status)
+    }
+    if count, _ := db.FailedPaymentCount("ada@example.org"); count != 0 {
+        t.Errorf("failed payments = %d, want 0", count)
+    }
+
+    // A failure after the success still applies, as the first of the two
+    // the limit allows
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Failed","event_time":"2026-03-03T00:00:00Z"}`), http.StatusOK)
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusSuspended {
+        t.Errorf("status after a newer failure = %s, want suspended", status)
+    }
+}
+
+func TestWebhookDryRunWritesNothing(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    resp := do(t, server, "POST", "/webhook?dry_run=1", testWebhookSecret, `{"email":"ada@example.org","status":"Succeeded"}`)
+    expectStatus(t, resp, http.StatusOK)
+    var result ProcessResult
+    decode(t, resp, &result)
+    if !result.DryRun || result.Action != actionCreated {
+        t.Errorf("dry run: got %+v", result)
+    }
+    if len(db.members) != 0 {
+        t.Errorf("dry run created %d members", len(db.members))
+    }
+    if db.webhookLogs[0].State != webhookStateDryRun {
+        t.Errorf("dry run log state = %q", db.webhookLogs[0].State)
+    }
+}
+
+func TestWebhookDryRunStaleResult(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Cancelled","event_time":"2026-03-02T00:00:00Z"}`), http.StatusCreated)
+
+    resp := do(t, server, "POST", "/webhook?dry_run=1", testWebhookSecret, `{"email":"ada@example.org","status":"Succeeded","event_time":"2026-03-01T00:00:00Z"}`)
+    expectStatus(t, resp, http.StatusOK)
+    if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+        t.Errorf("Content-Type = %q", ct)
+    }
+    var result ProcessResult
+    decode(t, resp, &result)
+    if result.Action != actionSkipped || !result.DryRun {
+        t.Errorf("got %+v", result)
+    }
+}
+
+func TestWebhookNotProcessedResult(t *testing.T) {
+    config := testConfig()
+    config.StrictTransitions = true
+    server, _ := newTestServer(t, config)
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Cancelled"}`), http.StatusCreated)
+
+    // Strict transitions don't let a webhook reactivate a cancelled member
+    resp := postWebhook(t, server, `{"email":"Ada@example.org","status":"Succeeded"}`)
+    expectStatus(t, resp, http.StatusOK)
+    var result ProcessResult
+    decode(t, resp, &result)
+    if result.Action != actionNotProcessed || result.Email != "ada@example.org" || !strings.HasPrefix(result.Message, "NOT PROCESSED: ") {
+        t.Errorf("got %+v", result)
+    }
+}
+
+// mappingCountStore is a Store that counts stored status mapping lookups
+type mappingCountStore struct {
+    *memStore
+    lookups *atomic.Int32
+}
+
+func (s mappingCountStore) StatusMapping(paymentStatus string) (string, error) {
+    s.lookups.Add(1)
+    return s.memStore.StatusMapping(paymentStatus)
+}
+
+func TestWebhookMapsStatusOnce(t *testing.T) {
+    db := mappingCountStore{newMemStore(), &atomic.Int32{}}
+    s := NewWebhookServer(db, testConfig(), log.New(io.Discard, "", 0))
+    s.routes()
+    server := httptest.NewServer(s.mux)
+    t.Cleanup(func() {
+        server.Close()
+        s.accessLog.close()
+    })
+
+    // A status only a stored mapping covers, failing so every check runs
+    if err := db.RecordUnmappedValue(unmappedKindStatus, "Declined", 1); err != nil {
+        t.Fatal(err)
+    }
+    if err := db.MapUnmappedStatus("Declined", statusFailed, "admin"); err != nil {
+        t.Fatal(err)
+    }
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`), http.StatusCreated)
+    db.lookups.Store(0)
+
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Declined"}`), http.StatusOK)
+    if n := db.lookups.Load(); n != 1 {
+        t.Errorf("looked up the stored mapping %d times for one webhook", n)
+    }
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusSuspended {
+        t.Errorf("status = %s, want the mapped failure to suspend", status)
+    }
+}
+
+func TestWebhookCountsOnlyUnmappedStatuses(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    seen := func(status string) int {
+        return db.unmapped[unmappedKindStatus+"|"+unmappedKey(status)].Seen
+    }
+
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Teleported"}`), http.StatusCreated)
+    if seen("Teleported") != 1 {
+        t.Errorf("an unmapped status was seen %d times, want 1", seen("Teleported"))
+    }
+
+    if err := db.MapUnmappedStatus("Teleported", StatusCancelled, "admin"); err != nil {
+        t.Fatal(err)
+    }
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Teleported"}`), http.StatusOK)
+    if seen("Teleported") != 1 {
+        t.Errorf("a mapped status was still counted as unmapped (seen %d times)", seen("Teleported"))
+    }
+
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`), http.StatusOK)
+    if _, ok := db.unmapped[unmappedKindStatus+"|"+unmappedKey("Succeeded")]; ok {
+        t.Error("a status the rules cover was counted as unmapped")
+    }
+}
+
+// flakyStore is a Store whose member updates fail with a lost connection
+type flakyStore struct {
+    *memStore
+}
+
+func (flakyStore) ProcessMember(email, name string, isAnonymous bool, status string, change ChangeSource) (*ProcessResult, error) {
+    return nil, fmt.Errorf("processing %s: %w", email, driver.ErrBadConn)
+}
+
+func TestWebhookQueuedResult(t *testing.T) {
+    s := NewWebhookServer(flakyStore{newMemStore()}, testConfig(), log.New(io.Discard, "", 0))
+    s.routes()
+    server := httptest.NewServer(s.mux)
+    t.Cleanup(func() {
+        server.Close()
+        s.accessLog.close()
+    })
+
+    resp := postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`)
+    expectStatus(t, resp, http.StatusAccepted)
+    var result ProcessResult
+    decode(t, resp, &result)
+    if result.Action != actionQueued || result.Email != "ada@example.org" || !strings.HasPrefix(result.Message, "QUEUED: ") {
+        t.Errorf("got %+v", result)
+    }
+}
+
+func TestWebhookDeferredDuringMaintenance(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    expectStatus(t, do(t, server, "POST", "/admin/maintenance", testAdminToken, `{"enabled":true}`), http.StatusOK)
+    resp := postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`)
+    expectStatus(t, resp, http.StatusAccepted)
+    var result ProcessResult
+    decode(t, resp, &result)
+    if result.Action != actionDeferred || result.Email != "ada@example.org" {
+        t.Errorf("deferred webhook: got %+v", result)
+    }
+    if len(db.members) != 0 {
+        t.Fatal("a webhook was applied during maintenance")
+    }
+
+    expectStatus(t, do(t, server, "POST", "/admin/maintenance", testAdminToken, `{"enabled":false}`), http.StatusOK)
+    due, _ := db.DueWebhookRetries(10)
+    if len(due) != 1 || due[0].Email != "ada@example.org" {
+        t.Errorf("released %d webhooks for retry, want the deferred one", len(due))
+    }
+}
+
+func TestFailedPaymentsSuspendThenCancel(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`), http.StatusCreated)
+
+    for i := 1; i <= defaultFailedPaymentLimit; i++ {
+        expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Failed"}`), http.StatusOK)
+
+        want := StatusSuspended
+        if i == defaultFailedPaymentLimit {
+            want = StatusCancelled
+        }
+        if status, _, _ := db.GetMemberStatus("ada@example.org"); status != want {
+            t.Fatalf("after %d failures status = %s, want %s", i, status, want)
+        }
+    }
+}
+
+func TestFailedPaymentStatus(t *testing.T) {
+    tests := []struct {
+        previous string
+        failures int
+        want     string
+    }{
+        {"", 1, StatusSuspended},
+        {StatusActive, 1, StatusSuspended},
+        {StatusSuspended, 2, StatusSuspended},
+        {StatusSuspended, 3, StatusCancelled},
+        {StatusSuspended, 4, StatusCancelled},
+        {StatusCancelled, 1, StatusCancelled},
+    }
+    for _, tt := range tests {
+        if got := failedPaymentStatus(tt.previous, tt.failures, 3); got != tt.want {
+            t.Errorf("failedPaymentStatus(%q, %d, 3) = %s, want %s", tt.previous, tt.failures, got, tt.want)
+        }
+    }
+}
+
+func TestFailedPaymentsResetBySuccess(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Succeeded"}`), http.StatusCreated)
+
+    // fail, fail, succeed: the run starts over
+    for _, status := range []string{"Failed", "Failed", "Succeeded"} {
+        expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"`+status+`"}`), http.StatusOK)
+    }
+    if count, _ := db.FailedPaymentCount("ada@example.org"); count != 0 {
+        t.Errorf("failed payments = %d after a success, want 0", count)
+    }
+
+    for i := 1; i < defaultFailedPaymentLimit; i++ {
+        expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Failed"}`), http.StatusOK)
+    }
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusSuspended {
+        t.Errorf("status = %s after %d failures since the success, want suspended", status, defaultFailedPaymentLimit-1)
+    }
+}
+
+func TestFailedPaymentForNewMember(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","status":"Failed"}`), http.StatusCreated)
+    if count, _ := db.FailedPaymentCount("ada@example.org"); count != 1 {
+        t.Errorf("failed payments = %d, want the first failure counted", count)
+    }
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusSuspended {
+        t.Errorf("status = %s, want suspended", status)
+    }
+}
+
+// TestConcurrentFailedPaymentsAllCount needs Postgres: memStore serializes
+// every call, so only row locking can lose a count
+func TestConcurrentFailedPaymentsAllCount(t *testing.T) {
+    db := openTestDatabase(t)
+    server := serveTestStore(t, db, testConfig())
+    email := "ada@" + pgTestDomain
+    expectStatus(t, postWebhook(t, server, `{"email":"`+email+`","status":"Succeeded"}`), http.StatusCreated)
+
+    const failures = 10
+    var wg sync.WaitGroup
+    for i := 0; i < failures; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            resp := postWebhook(t, server, `{"email":"`+email+`","status":"Failed"}`)
+            resp.Body.Close()
+        }()
+    }
+    wg.Wait()
+
+    if count, _ := db.FailedPaymentCount(email); count != failures {
+        t.Errorf("failed payments = %d, want %d", count, failures)
+    }
+    if status, _, _ := db.GetMemberStatus(email); status != StatusCancelled {
+        t.Errorf("status = %s, want cancelled", status)
+    }
+}
+
+func TestProtectedMemberNotDeactivatedByWebhook(t *testing.T) {
+    server, db := newTestServer(t, nil)
+
+    expectStatus(t, postWebhook(t, server, `{"email":"board@example.org","status":"Succeeded"}`), http.StatusCreated)
+    if err := db.AddMemberTag("board@example.org", ProtectedTag); err != nil {
+        t.Fatal(err)
+    }
+
+    resp := postWebhook(t, server, `{"email":"board@example.org","status":"Cancelled"}`)
+    expectStatus(t, resp, http.StatusOK)
+    var result ProcessResult
+    decode(t, resp, &result)
+    if result.Action != actionUnchanged || result.Status != StatusActive {
+        t.Errorf("got %+v, want unchanged and active", result)
+    }
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+
+    for _, route := range []struct{ method, path string }{
+        {"GET", "/members/ada@example.org"},
+        {"POST", "/members/merge"},
+        {"POST", "/members/ada@example.org/forget"},
+        {"GET", "/webhooks/failed"},
+    } {
+        expectStatus(t, do(t, server, route.method, route.path, "", ""), http.StatusUnauthorized)
+        expectStatus(t, do(t, server, route.method, route.path, "wrong-token", ""), http.StatusUnauthorized)
+    }
+
+    config := testConfig()
+    config.AdminTokens = nil
+    disabled, _ := newTestServer(t, config)
+    expectStatus(t, do(t, disabled, "GET", "/members/ada@example.org", testAdminToken, ""), http.StatusForbidden)
+}
+
+func TestGetMember(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","name":"Ada","status":"Succeeded"}`), http.StatusCreated)
+
+    resp := do(t, server, "GET", "/members/ADA@example.org", testAdminToken, "")
+    expectStatus(t, resp, http.StatusOK)
+    var member map[string]interface{}
+    decode(t, resp, &member)
+    if member["email"] != "ada@example.org" || member["status"] != StatusActive || member["name"] != "Ada" {
+        t.Errorf("got %v", member)
+    }
+
+    expectStatus(t, do(t, server, "GET", "/members/nobody@example.org", testAdminToken, ""), http.StatusNotFound)
+}
+
+func TestListMembersFilters(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+    for _, payload := range []string{
+        `{"email":"a@example.org","status":"Succeeded","frequency":"Monthly"}`,
+        `{"email":"b@example.org","status":"Succeeded","frequency":"Annual"}`,
+        `{"email":"c@example.org","status":"Cancelled"}`,
+    } {
+        expectStatus(t, postWebhook(t, server, payload), http.StatusCreated)
+    }
+
+    tests := []struct {
+        query string
+        want  int
+    }{
+        {"", 3},
+        {"?status=active", 2},
+        {"?status=cancelled", 1},
+        {"?frequency=annual", 1},
+        {"?limit=2", 2},
+        {"?offset=2", 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.query, func(t *testing.T) {
+            resp := do(t, server, "GET", "/v1/members"+tt.query, testAdminToken, "")
+            expectStatus(t, resp, http.StatusOK)
+            var page apiMemberPage
+            decode(t, resp, &page)
+            if len(page.Members) != tt.want {
+                t.Errorf("got %d members, want %d", len(page.Members), tt.want)
+            }
+        })
+    }
+
+    expectStatus(t, do(t, server, "GET", "/v1/members?frequency=weekly", testAdminToken, ""), http.StatusBadRequest)
+}
+
+func TestStats(t *testing.T) {
+    server, _ := newTestServer(t, nil)
+    for _, payload := range []string{
+        `{"email":"a@example.org","status":"Succeeded","anonymous":"True"}`,
+        `{"email":"b@example.org","status":"Succeeded"}`,
+        `{"email":"c@example.org","status":"Cancelled"}`,
+    } {
+        expectStatus(t, postWebhook(t, server, payload), http.StatusCreated)
+    }
+
+    resp := do(t, server, "GET", "/stats", "", "")
+    expectStatus(t, resp, http.StatusOK)
+    var stats Stats
+    decode(t, resp, &stats)
+    if stats.TotalMembers != 3 || stats.ActiveMembers != 2 || stats.CancelledMembers != 1 || stats.AnonymousMembers != 1 {
+        t.Errorf("got %+v", stats)
+    }
+    if stats.WebhooksBySource["default"] != 3 {
+        t.Errorf("webhooks by source = %v, want 3 from default", stats.WebhooksBySource)
+    }
+}
+
+func TestMergeHandler(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    expectStatus(t, postWebhook(t, server, `{"email":"old@example.org","status":"Cancelled"}`), http.StatusCreated)
+    expectStatus(t, postWebhook(t, server, `{"email":"new@example.org","status":"Succeeded"}`), http.StatusCreated)
+
+    resp := do(t, server, "POST", "/members/merge", testAdminToken, `{"from":"old@example.org","to":"new@example.org","dry_run":true}`)
+    expectStatus(t, resp, http.StatusOK)
+    var preview MergeResult
+    decode(t, resp, &preview)
+    if !preview.DryRun || preview.FinalStatus != StatusActive {
+        t.Errorf("preview: got %+v", preview)
+    }
+    if _, existed, _ := db.GetMemberStatus("old@example.org"); !existed {
+        t.Fatal("a dry-run merge removed the duplicate")
+    }
+
+    resp = do(t, server, "POST", "/members/merge", testAdminToken, `{"from":"old@example.org","to":"new@example.org"}`)
+    expectStatus(t, resp, http.StatusOK)
+    if _, existed, _ := db.GetMemberStatus("old@example.org"); existed {
+        t.Error("the duplicate still exists after merging")
+    }
+
+    resp = do(t, server, "POST", "/members/merge", testAdminToken, `{"from":"old@example.org","to":"new@example.org"}`)
+    expectStatus(t, resp, http.StatusNotFound)
+    expectStatus(t, do(t, server, "POST", "/members/merge", testAdminToken, `{"from":`), http.StatusBadRequest)
+}
+
+func TestForgetHandler(t *testing.T) {
+    server, db := newTestServer(t, nil)
+    expectStatus(t, postWebhook(t, server, `{"email":"ada@example.org","name":"Ada","status":"Succeeded"}`), http.StatusCreated)
+
+    resp := do(t, server, "POST", "/members/ada@example.org/forget", testAdminToken, "")
+    expectStatus(t, resp, http.StatusOK)
+    var result ForgetResult
+    decode(t, resp, &result)
+    if !result.NameCleared || result.WebhookLogsRedacted != 1 {
+        t.Errorf("got %+v", result)
+    }
+
+    if _, existed, _ := db.GetMemberStatus("ada@example.org"); existed {
+        t.Error("the member can still be found by their email")
+    }
+    if strings.Contains(string(db.webhookLogs[0].Payload), "ada@example.org") {
+        t.Errorf("webhook log still holds the email: %s", db.webhookLogs[0].Payload)
+    }
+
+    expectStatus(t, do(t, server, "POST", "/members/ada@example.org/forget", testAdminToken, ""), http.StatusNotFound)
+}
+
+func TestRetryWorkerAppliesQueuedWebhooks(t *testing.T) {
+    db := newMemStore()
+    logID, _ := db.LogWebhook("ada@example.org", StatusActive, "default", []byte(`{"email":"ada@example.org","status":"Succeeded"}`))
+    if err := db.QueueWebhookRetry(logID, driver.ErrBadConn); err != nil {
+        t.Fatal(err)
+    }
+    entry := db.webhookLog(logID)
+    past := time.Now().Add(-time.Minute)
+    entry.NextAttemptAt = &past
+
+    s := NewWebhookServer(db, testConfig(), log.New(io.Discard, "", 0))
+    defer s.accessLog.close()
+    due, _ := db.DueWebhookRetries(10)
+    if len(due) != 1 {
+        t.Fatalf("%d webhooks due, want 1", len(due))
+    }
+    if state, err := s.retryWebhook(due[0]); err != nil || state != webhookStateDone {
+        t.Fatalf("retry: got %s, %v", state, err)
+    }
+
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusActive {
+        t.Errorf("status = %q after the retry, want active", status)
+    }
+    if entry := db.webhookLog(logID); entry.State != webhookStateDone || entry.Attempts != 1 {
+        t.Errorf("log state = %s after %d attempts, want done after 1", entry.State, entry.Attempts)
+    }
+}
+
+func TestRetrySkipsDeliveryOlderThanLastApplied(t *testing.T) {
+    db := newMemStore()
+    s := NewWebhookServer(db, testConfig(), log.New(io.Discard, "", 0))
+    defer s.accessLog.close()
+
+    // A cancellation that failed to apply ten minutes ago, with no event time
+    logID, _ := db.LogWebhook("ada@example.org", StatusCancelled, "default", []byte(`{"email":"ada@example.org","status":"Cancelled"}`))
+    if err := db.QueueWebhookRetry(logID, driver.ErrBadConn); err != nil {
+        t.Fatal(err)
+    }
+    entry := db.webhookLog(logID)
+    entry.ReceivedAt = time.Now().Add(-10 * time.Minute)
+    past := time.Now().Add(-time.Minute)
+    entry.NextAttemptAt = &past
+
+    // A payment that arrived since
+    payment := MemberWebhook{Email: "ada@example.org", Status: "Succeeded"}
+    if _, err := s.applyWebhook(payment, s.mapPaymentStatus(payment.Status), time.Now(), webhookChange("default", 0)); err != nil {
+        t.Fatal(err)
+    }
+
+    due, _ := db.DueWebhookRetries(10)
+    if len(due) != 1 {
+        t.Fatalf("%d webhooks due, want 1", len(due))
+    }
+    if state, err := s.retryWebhook(due[0]); err != nil || state != webhookStateSkipped {
+        t.Fatalf("retry: got %s, %v; want skipped", state, err)
+    }
+    if status, _, _ := db.GetMemberStatus("ada@example.org"); status != StatusActive {
+        t.Errorf("status = %q, want the newer payment to stand", status)
+    }
+    if entry := db.webhookLog(logID); entry.SkipReason != skipReasonStale {
+        t.Errorf("skip reason = %q, want %q", entry.SkipReason, skipReasonStale)
+    }
+}
+
+func TestMixedTimestampedDeliveries(t *testing.T) {
+    db := newMemStore()
+    s := NewWebhookServer(db, testConfig(), log.New(io.Discard, "", 0))
+    defer s.accessLog.close()
+
+    base := time.Now().Add(-time.Hour).Truncate(time.Second)
+    at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+    deliver := func(status, eventTime string, receivedAt time.Time, retried bool) error {
+        webhook := MemberWebhook{Email: "ada@example.org", Status: status, EventTime: eventTime}
+        change := webhookChange("default", 0)
+        change.Retried = retried
+        _, err := s.applyWebhook(webhook, s.mapPaymentStatus(status), receivedAt, change)
+        return err
+    }
+
+    steps := []struct {
+        name       string
+        status     string
+        eventTime  string
+        receivedAt time.Time
+        retried    bool
+        stale      bool
+        want       string
+    }{
+        {"untimestamped payment", "Succeeded", "", at(10), false, false, StatusActive},
+        // The sender's clock runs behind ours: a newer event whose time is
+        // before the last arrival still applies
+        {"timestamped cancellation", "Cancelled", at(5).Format(time.RFC3339), at(12), false, false, StatusCancelled},
+        {"untimestamped payment", "Succeeded", "", at(20), false, false, StatusActive},
+        {"older timestamped failure", "Failed", at(4).Format(time.RFC3339), at(21), false, true, StatusActive},
+        {"retried untimestamped cancellation", "Cancelled", "", at(15), true, true, StatusActive},
+        {"retried timestamped cancellation", "Cancelled", at(6).Format(time.RFC3339), at(15), true, false, StatusCancelled},
+        {"retried untimestamped payment", "Succeeded", "", at(25), true, false, StatusActive},
+    }
+    for _, step := range steps {
+        err := deliver(step.status, step.eventTime, step.receivedAt, step.retried)
+        if errors.Is(err, ErrStaleEvent) != step.stale || (err != nil && !step.stale) {
+            t.Fatalf("%s: err = %v, stale %v", step.name, err, step.stale)
+        }
+        if status, _, _ := db.GetMemberStatus("ada@example.org"); status != step.want {
+            t.Fatalf("%s: status = %s, want %s", step.name, status, step.want)
+        }
+    }
+}
+
+func TestWebhookAttemptFor(t *testing.T) {
+    now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+    retryable := fmt.Errorf("failed to update member: %w", driver.ErrBadConn)
+
+    tests := []struct {
+        name     string
+        attempts int
+        cause    error
+        want     string
+    }{
+        {"success", 0, nil, webhookStateDone},
+        {"stale", 0, ErrStaleEvent, webhookStateSkipped},
+        {"transient", 0, retryable, webhookStatePending},
+        {"transient, out of attempts", retryMaxAttempts - 1, retryable, webhookStateFailed},
+        {"permanent", 0, ErrUnknownStatus, webhookStateFailed},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            attempt := webhookAttemptFor(WebhookLogEntry{Attempts: tt.attempts}, tt.cause, now)
+            if attempt.State != tt.want {
+                t.Errorf("state = %s, want %s", attempt.State, tt.want)
+            }
+            if attempt.Attempts != tt.attempts+1 {
+                t.Errorf("attempts = %d, want %d", attempt.Attempts, tt.attempts+1)
+            }
+            if (attempt.State == webhookStatePending) != (attempt.NextAttempt != nil) {
+                t.Errorf("next attempt = %v for state %s", attempt.NextAttempt, attempt.State)
+            }
+        })
+    }
+}